@@ -0,0 +1,180 @@
+package logsearch
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Reasons of the searches quarantined by WithAbuseDetection
+const (
+	// ReasonRate is a user logging searches faster than AbuseLimits.MaxRate
+	ReasonRate = "rate"
+	// ReasonUniqueWords is a user logging more distinct searches a minute than AbuseLimits.MaxUniqueWords
+	ReasonUniqueWords = "unique_words"
+	// ReasonEntropy is a random-looking search, see AbuseLimits.MaxEntropy
+	ReasonEntropy = "entropy"
+)
+
+const (
+	// rateWindow is the window AbuseLimits.MaxRate is averaged over
+	rateWindow = 10 * time.Second
+	// uniqueWordsWindow is the window of AbuseLimits.MaxUniqueWords
+	uniqueWordsWindow = time.Minute
+)
+
+// AbuseLimits are the heuristics of WithAbuseDetection, a zero field disables its heuristic
+type AbuseLimits struct {
+	// MaxRate is the most searches per second a user may log, counted over
+	// 10 second windows, e.g. 20. A user streaming keystrokes logs one search
+	// per key, about 10 per second for a fast typist.
+	MaxRate float64
+	// MaxUniqueWords is the most distinct searches a user may log within a
+	// minute, e.g. 30. A search extending or extended by the previous one of
+	// the user is the same one, so typing a word counts once.
+	MaxUniqueWords int
+	// MaxEntropy is the most Shannon entropy, in bits per character, of a
+	// search of at least MinEntropyLength characters, e.g. 3.5 over 16
+	// characters: random-looking strings exceed it, words and phrases do not
+	MaxEntropy       float64
+	MinEntropyLength int
+	// QuarantineFor is how long all the searches of a user flagged by MaxRate
+	// or MaxUniqueWords are quarantined, e.g. time.Hour, 0 only quarantines
+	// the searches over the limits
+	QuarantineFor time.Duration
+}
+
+// userActivity is what the abuse heuristics remember of a user
+type userActivity struct {
+	rateStart time.Time
+	rate      int
+	// wordsStart is the start of the minute of words distinct searches, lastWord the last one
+	wordsStart time.Time
+	words      int
+	lastWord   string
+	// until is the end of the quarantine of the user for reason
+	until    time.Time
+	reason   string
+	lastSeen time.Time
+}
+
+// abuseDetector applies the heuristics of WithAbuseDetection. A nil
+// *abuseDetector flags nothing.
+type abuseDetector struct {
+	limits AbuseLimits
+
+	mutex     sync.Mutex
+	users     map[string]*userActivity
+	lastSweep time.Time
+}
+
+// WithAbuseDetection quarantines the searches of users behaving like bots,
+// by the heuristics of limits, into the quarantined_searches table instead
+// of logging them, so they do not pollute the stored vocabulary. A user
+// logging searches too fast or too many distinct ones is quarantined for
+// limits.QuarantineFor, a random-looking search is quarantined alone. The
+// activity of the users is kept in memory, so every instance judges the
+// searches it receives. The store must implement store.QuarantineStore.
+func WithAbuseDetection(limits AbuseLimits) Option {
+	return func(sl *SearchLoggerV2) {
+		sl.abuse = &abuseDetector{limits: limits, users: make(map[string]*userActivity)}
+	}
+}
+
+// check records a search of word by user at now and returns the reason to
+// quarantine it, empty when it looks legitimate
+func (d *abuseDetector) check(userIdentifier, word string, now time.Time) string {
+	if d == nil {
+		return ""
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.sweepLocked(now)
+
+	a := d.users[userIdentifier]
+	if a == nil {
+		a = &userActivity{}
+		d.users[userIdentifier] = a
+	}
+	a.lastSeen = now
+	if now.Before(a.until) {
+		return a.reason
+	}
+
+	var reason string
+	if d.limits.MaxRate > 0 {
+		if now.Sub(a.rateStart) >= rateWindow {
+			a.rateStart, a.rate = now, 0
+		}
+		a.rate++
+		if float64(a.rate) > d.limits.MaxRate*rateWindow.Seconds() {
+			reason = ReasonRate
+		}
+	}
+	if d.limits.MaxUniqueWords > 0 {
+		if now.Sub(a.wordsStart) >= uniqueWordsWindow {
+			a.wordsStart, a.words = now, 0
+		}
+		if a.words == 0 || !(strings.HasPrefix(word, a.lastWord) || strings.HasPrefix(a.lastWord, word)) {
+			a.words++
+		}
+		a.lastWord = word
+		if a.words > d.limits.MaxUniqueWords && reason == "" {
+			reason = ReasonUniqueWords
+		}
+	}
+	if reason != "" {
+		a.until, a.reason = now.Add(d.limits.QuarantineFor), reason
+		return reason
+	}
+
+	if d.limits.MaxEntropy > 0 && utf8.RuneCountInString(word) >= d.limits.MinEntropyLength && entropy(word) > d.limits.MaxEntropy {
+		return ReasonEntropy
+	}
+	return ""
+}
+
+// sweepLocked drops the users idle for longer than the windows and out of
+// quarantine, at most once a minute. Caller must hold the mutex.
+func (d *abuseDetector) sweepLocked(now time.Time) {
+	if now.Sub(d.lastSweep) < uniqueWordsWindow {
+		return
+	}
+	d.lastSweep = now
+	for user, a := range d.users {
+		if now.Sub(a.lastSeen) >= uniqueWordsWindow && !now.Before(a.until) {
+			delete(d.users, user)
+		}
+	}
+}
+
+// forget drops what the heuristics remember of a user, lifting their quarantine
+func (d *abuseDetector) forget(userIdentifier string) {
+	if d == nil {
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.users, userIdentifier)
+}
+
+// entropy returns the Shannon entropy of the characters of word, in bits per character
+func entropy(word string) float64 {
+	counts := make(map[rune]int)
+	n := 0
+	for _, r := range word {
+		counts[r]++
+		n++
+	}
+
+	var h float64
+	for _, count := range counts {
+		p := float64(count) / float64(n)
+		h -= p * math.Log2(p)
+	}
+	return h
+}
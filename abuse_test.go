@@ -0,0 +1,104 @@
+package logsearch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAbuseDetector(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("rate", func(t *testing.T) {
+		d := &abuseDetector{limits: AbuseLimits{MaxRate: 0.5, QuarantineFor: time.Minute}, users: make(map[string]*userActivity)}
+		for i := 0; i < 5; i++ {
+			assert.Empty(t, d.check("user_1", "c", now.Add(time.Duration(i)*time.Second)))
+		}
+		assert.Equal(t, ReasonRate, d.check("user_1", "c", now.Add(5*time.Second)))
+		assert.Empty(t, d.check("user_2", "c", now.Add(5*time.Second)), "Other users are not flagged")
+
+		// Quarantined until QuarantineFor elapsed, then judged again
+		assert.Equal(t, ReasonRate, d.check("user_1", "cat", now.Add(30*time.Second)))
+		assert.Empty(t, d.check("user_1", "cat", now.Add(2*time.Minute)))
+	})
+
+	t.Run("unique words", func(t *testing.T) {
+		d := &abuseDetector{limits: AbuseLimits{MaxUniqueWords: 2}, users: make(map[string]*userActivity)}
+		for _, word := range []string{"c", "ca", "cat", "ca", "dog"} {
+			assert.Empty(t, d.check("user_1", word, now), "Typing a word counts once")
+		}
+		assert.Equal(t, ReasonUniqueWords, d.check("user_1", "bus", now))
+		assert.Equal(t, ReasonUniqueWords, d.check("user_1", "cow", now), "Over the limit for the rest of the minute")
+		assert.Empty(t, d.check("user_1", "bus", now.Add(time.Minute)), "Without QuarantineFor the count restarts every minute")
+	})
+
+	t.Run("entropy", func(t *testing.T) {
+		d := &abuseDetector{limits: AbuseLimits{MaxEntropy: 3.5, MinEntropyLength: 16}, users: make(map[string]*userActivity)}
+		assert.Equal(t, ReasonEntropy, d.check("user_1", "x7qk2m9vz4bw1pjh", now))
+		assert.Empty(t, d.check("user_1", "how to reset password", now))
+		assert.Empty(t, d.check("user_1", "x7qk2m9vz4", now), "Shorter than MinEntropyLength")
+	})
+
+	t.Run("sweep", func(t *testing.T) {
+		d := &abuseDetector{limits: AbuseLimits{MaxRate: 1}, users: make(map[string]*userActivity)}
+		d.check("user_1", "cat", now)
+		d.check("user_2", "cat", now.Add(2*time.Minute))
+		assert.NotContains(t, d.users, "user_1")
+		assert.Contains(t, d.users, "user_2")
+	})
+
+	var d *abuseDetector
+	assert.Empty(t, d.check("user_1", "x7qk2m9vz4bw1pjh", now), "A nil detector flags nothing")
+}
+
+func TestSearchLoggerV2_AbuseDetection(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDBV2()
+	logger, err := NewSearchLoggerV2WithDB(db, WithAbuseDetection(AbuseLimits{
+		MaxUniqueWords: 2,
+		QuarantineFor:  time.Hour,
+		MaxEntropy:     3.5,
+	}))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	for _, word := range []string{"cat", "dog", "bus", "cow"} {
+		require.NoError(t, logger.LogSearchV2(ctx, "user_1", word))
+	}
+	require.NoError(t, logger.LogSearchV2(ctx, "user_2", "cat"))
+	require.NoError(t, logger.LogSearchV2(ctx, "user_2", "x7qk2m9vz4bw1pjh"))
+
+	searches, err := logger.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"cat", "dog"}, searches, "The searches of a flagged user are not logged")
+
+	quarantined, err := logger.GetQuarantinedSearches(ctx, "user_1")
+	require.NoError(t, err)
+	require.Len(t, quarantined, 2)
+	assert.Equal(t, "bus", quarantined[0].Word)
+	assert.Equal(t, ReasonUniqueWords, quarantined[0].Reason)
+	assert.Equal(t, "cow", quarantined[1].Word, "Quarantined for QuarantineFor")
+
+	all, err := logger.GetQuarantinedSearches(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, all, 3)
+	assert.Equal(t, ReasonEntropy, all[2].Reason)
+
+	// Deleting a user lifts their quarantine and deletes their quarantined searches
+	_, err = logger.DeleteUserData(ctx, "user_1")
+	require.NoError(t, err)
+	quarantined, err = logger.GetQuarantinedSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Empty(t, quarantined)
+	require.NoError(t, logger.LogSearchV2(ctx, "user_1", "cow"))
+	searches, err = logger.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cow"}, searches)
+
+	_, err = NewSearchLoggerV2WithDB(&countingStore{UserSearchStore: store.NewMockPostgresDBV2()}, WithAbuseDetection(AbuseLimits{MaxRate: 20}))
+	assert.Error(t, err, "Stores without quarantine should be rejected")
+}
@@ -0,0 +1,192 @@
+// Package admin serves a small HTML dashboard for internal moderators: the
+// top searches, the moderation queue, the recently finalized words, the
+// search history of a user and the trie stats, with actions to verify, merge
+// and delete. The page only calls the JSON API of the server package and the
+// two endpoints of this package, so it adds no logic of its own.
+//
+// The dashboard has no authentication, like the API it drives. Serve it on an
+// internal address or behind an authenticating proxy.
+package admin
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/trie"
+)
+
+//go:embed static
+var static embed.FS
+
+var (
+	index  = template.Must(template.ParseFS(static, "static/index.html"))
+	assets = http.FileServer(http.FS(must(fs.Sub(static, "static"))))
+)
+
+func must(fsys fs.FS, err error) fs.FS {
+	if err != nil {
+		panic(err)
+	}
+	return fsys
+}
+
+// StatsSource reports the trie stats shown on the dashboard, implemented by trie.SearchLogger
+type StatsSource interface {
+	Stats(ctx context.Context) (trie.Stats, error)
+}
+
+// Finalization is a word finalized by a logger, as listed by the dashboard
+type Finalization struct {
+	// UserIdentifier is empty for the global trie logger
+	UserIdentifier string    `json:"user_identifier,omitempty"`
+	Word           string    `json:"word"`
+	ReplacedWords  []string  `json:"replaced_words,omitempty"`
+	Count          int       `json:"count"`
+	At             time.Time `json:"at"`
+}
+
+// RecentResponse is returned by GET api/recent, newest first
+type RecentResponse struct {
+	Finalizations []Finalization `json:"finalizations"`
+}
+
+// StatsResponse is returned by GET api/stats, Error reports a store failure
+// while the figures of the trie are still filled
+type StatsResponse struct {
+	trie.Stats
+	Error string `json:"error,omitempty"`
+}
+
+// Dashboard records the finalized words listed by the dashboard and serves
+// its page with Handler. It is safe for concurrent use.
+type Dashboard struct {
+	apiBase string
+
+	mutex sync.Mutex
+	// recent is a ring of the last finalizations, next is where the next one goes
+	recent []Finalization
+	size   int
+	next   int
+}
+
+// Option configures optional Dashboard behavior
+type Option func(*Dashboard)
+
+// WithRecent lists the last n finalized words, 100 by default
+func WithRecent(n int) Option {
+	return func(d *Dashboard) {
+		if n > 0 {
+			d.size = n
+		}
+	}
+}
+
+// WithAPIBase is the URL the page prefixes the paths of the search API with,
+// "/" by default, for a search API mounted elsewhere on the same origin
+func WithAPIBase(base string) Option {
+	return func(d *Dashboard) {
+		d.apiBase = base
+	}
+}
+
+// New creates a Dashboard, register its Hook on the loggers to list the
+// finalized words before serving it with Handler
+func New(opts ...Option) *Dashboard {
+	d := &Dashboard{apiBase: "/", size: 100}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Hook returns the hook recording the finalized words, register it with
+// logsearch.WithWordFinalizedHook and trie.WithWordFinalizedHook
+func (d *Dashboard) Hook() logsearch.WordFinalizedHook {
+	return func(word logsearch.FinalizedWord) {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+
+		finalization := Finalization{
+			UserIdentifier: word.UserIdentifier,
+			Word:           word.Word,
+			ReplacedWords:  word.ReplacedWords,
+			Count:          word.Count,
+			At:             word.At.UTC(),
+		}
+		if len(d.recent) < d.size {
+			d.recent = append(d.recent, finalization)
+		} else {
+			d.recent[d.next] = finalization
+		}
+		d.next = (d.next + 1) % d.size
+	}
+}
+
+// Recent returns the last finalized words, newest first
+func (d *Dashboard) Recent() []Finalization {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	recent := make([]Finalization, 0, len(d.recent))
+	for i := 1; i <= len(d.recent); i++ {
+		recent = append(recent, d.recent[(d.next-i+len(d.recent))%len(d.recent)])
+	}
+	return recent
+}
+
+// Handler serves the page on /, its script and style sheet, and the JSON
+// endpoints api/recent and api/stats showing the stats of stats, nil to leave
+// them out. Mount it under a prefix with http.StripPrefix, e.g.
+// mux.Handle("/admin/", http.StripPrefix("/admin", d.Handler(stats))).
+func (d *Dashboard) Handler(stats StatsSource) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.serve(w, r, stats)
+	})
+}
+
+func (d *Dashboard) serve(w http.ResponseWriter, r *http.Request, stats StatsSource) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// The page only loads its own assets, and cannot be framed to trick a moderator into an action
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+	w.Header().Set("X-Frame-Options", "DENY")
+
+	switch r.URL.Path {
+	case "/", "":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		index.Execute(w, struct{ APIBase string }{d.apiBase})
+	case "/api/recent":
+		writeJSON(w, http.StatusOK, RecentResponse{Finalizations: d.Recent()})
+	case "/api/stats":
+		if stats == nil {
+			writeJSON(w, http.StatusNotImplemented, map[string]string{"error": "trie stats are not enabled"})
+			return
+		}
+		figures, err := stats.Stats(r.Context())
+		resp := StatsResponse{Stats: figures}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		writeJSON(w, http.StatusOK, resp)
+	case "/app.js", "/style.css":
+		assets.ServeHTTP(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
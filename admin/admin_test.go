@@ -0,0 +1,85 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/trie"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubStats struct {
+	stats trie.Stats
+	err   error
+}
+
+func (s stubStats) Stats(ctx context.Context) (trie.Stats, error) {
+	return s.stats, s.err
+}
+
+func get(t *testing.T, h http.Handler, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+func TestDashboardPage(t *testing.T) {
+	d := New(WithAPIBase(`/api/"v1"`)).Handler(nil)
+
+	rec := get(t, d, "/")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Header().Get("Content-Security-Policy"), "default-src 'self'")
+	assert.Contains(t, rec.Body.String(), `data-api-base="/api/&#34;v1&#34;"`)
+
+	for _, asset := range []string{"/app.js", "/style.css"} {
+		rec = get(t, d, asset)
+		assert.Equal(t, http.StatusOK, rec.Code, asset)
+		assert.NotEmpty(t, rec.Body.String(), asset)
+	}
+	assert.Equal(t, http.StatusNotFound, get(t, d, "/static/index.html").Code)
+
+	rec = httptest.NewRecorder()
+	d.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestDashboardRecent(t *testing.T) {
+	d := New(WithRecent(2))
+	at := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	hook := d.Hook()
+	hook(logsearch.FinalizedWord{Word: "ca", Count: 1, At: at})
+	hook(logsearch.FinalizedWord{UserIdentifier: "user_1", Word: "cat", ReplacedWords: []string{"ca"}, Count: 2, At: at})
+	hook(logsearch.FinalizedWord{Word: "dog", Count: 1, At: at})
+
+	rec := get(t, d.Handler(nil), "/api/recent")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp RecentResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	// The oldest finalization fell out of the ring
+	assert.Equal(t, []Finalization{
+		{Word: "dog", Count: 1, At: at},
+		{UserIdentifier: "user_1", Word: "cat", ReplacedWords: []string{"ca"}, Count: 2, At: at},
+	}, resp.Finalizations)
+}
+
+func TestDashboardStats(t *testing.T) {
+	assert.Equal(t, http.StatusNotImplemented, get(t, New().Handler(nil), "/api/stats").Code)
+
+	d := New().Handler(stubStats{stats: trie.Stats{Nodes: 4, Words: 2}, err: errors.New("store down")})
+	rec := get(t, d, "/api/stats")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp StatsResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, 4, resp.Nodes)
+	assert.Equal(t, 2, resp.Words)
+	assert.Equal(t, "store down", resp.Error)
+}
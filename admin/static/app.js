@@ -0,0 +1,179 @@
+// The dashboard only drives the JSON API, every value is rendered as text
+// since the searches are typed by anyone.
+"use strict";
+
+const apiBase = document.body.dataset.apiBase.replace(/\/?$/, "/");
+
+function setStatus(message, isError) {
+  const status = document.getElementById("status");
+  status.textContent = message;
+  status.className = isError ? "error" : "";
+}
+
+async function call(method, path, body) {
+  const init = { method, headers: {} };
+  if (body !== undefined) {
+    init.headers["Content-Type"] = "application/json";
+    init.body = JSON.stringify(body);
+  }
+  const resp = await fetch(path, init);
+  const data = await resp.json().catch(() => ({}));
+  if (!resp.ok) {
+    throw new Error(data.error || resp.status + " " + resp.statusText);
+  }
+  return data;
+}
+
+function api(method, path, body) {
+  return call(method, apiBase + path, body);
+}
+
+function cell(row, value, className) {
+  const td = row.insertCell();
+  if (value instanceof Node) {
+    td.append(value);
+  } else {
+    td.textContent = value;
+  }
+  if (className) {
+    td.className = className;
+  }
+}
+
+function button(label, onClick, className) {
+  const b = document.createElement("button");
+  b.textContent = label;
+  b.className = className || "";
+  b.addEventListener("click", async () => {
+    try {
+      await onClick();
+      refresh();
+    } catch (err) {
+      setStatus(err.message, true);
+    }
+  });
+  return b;
+}
+
+function fill(table, headers, rows) {
+  table.replaceChildren();
+  const head = table.createTHead().insertRow();
+  for (const header of headers) {
+    const th = document.createElement("th");
+    th.textContent = header;
+    head.append(th);
+  }
+  const body = table.createTBody();
+  for (const cells of rows) {
+    const row = body.insertRow();
+    for (const [value, className] of cells) {
+      cell(row, value, className);
+    }
+  }
+}
+
+function verifyButton(word) {
+  return button("Verify", async () => {
+    await api("PUT", "search/verified?word=" + encodeURIComponent(word));
+    setStatus("Verified " + word);
+  });
+}
+
+async function loadStats() {
+  const stats = await call("GET", "api/stats");
+  const rows = [
+    ["Nodes", stats.nodes],
+    ["Words", stats.words],
+    ["Longest word", stats.max_depth],
+    ["Pending words", stats.pending_words],
+    ["Stored records", stats.stored_records],
+    ["Stored searches", stats.stored_searches],
+    ["Flush cycles", stats.flushes.cycles + " (" + stats.flushes.failed + " failed)"],
+  ];
+  fill(document.getElementById("stats"), ["", ""], rows.map(([name, value]) => [[name], [value, "number"]]));
+  if (stats.error) {
+    setStatus("Store: " + stats.error, true);
+  }
+}
+
+async function loadTop() {
+  const window = document.querySelector("#top-form select").value;
+  const top = await api("GET", "search/top?limit=20" + (window ? "&window=" + window : ""));
+  fill(document.getElementById("top"), ["Word", "Searches", ""],
+    top.searches.map((s) => [[s.word], [s.count, "number"], [verifyButton(s.word)]]));
+}
+
+async function loadUnverified() {
+  const unverified = await api("GET", "search/unverified?limit=20");
+  fill(document.getElementById("unverified"), ["Word", "Searches", ""],
+    unverified.searches.map((s) => [[s.word], [s.count, "number"], [verifyButton(s.word)]]));
+}
+
+async function loadRecent() {
+  const recent = await call("GET", "api/recent");
+  fill(document.getElementById("recent"), ["At", "User", "Word", "Replaced"],
+    recent.finalizations.map((f) => [
+      [new Date(f.at).toLocaleTimeString()],
+      [f.user_identifier || "(trie)"],
+      [f.word],
+      [(f.replaced_words || []).join(", ")],
+    ]));
+}
+
+async function loadUser(userID) {
+  const container = document.getElementById("user");
+  const history = await api("GET", "search/user?user_id=" + encodeURIComponent(userID));
+  const list = document.createElement("ul");
+  for (const search of history.searches) {
+    const item = document.createElement("li");
+    item.textContent = search;
+    list.append(item);
+  }
+  const erase = button("Delete all searches of " + userID, async () => {
+    if (!confirm("Delete every search of " + userID + "?")) {
+      return;
+    }
+    const deleted = await api("DELETE", "search/user?user_id=" + encodeURIComponent(userID));
+    setStatus("Deleted " + deleted.deleted + " searches of " + userID);
+    container.replaceChildren();
+  }, "danger");
+  container.replaceChildren(list, erase);
+}
+
+// Each panel loads on its own, a disabled feature only leaves its panel empty
+async function refresh() {
+  for (const load of [loadStats, loadTop, loadUnverified, loadRecent]) {
+    try {
+      await load();
+    } catch (err) {
+      setStatus(err.message, true);
+    }
+  }
+}
+
+document.querySelector("#top-form select").addEventListener("change", () => loadTop().catch((err) => setStatus(err.message, true)));
+
+document.getElementById("merge-form").addEventListener("submit", async (event) => {
+  event.preventDefault();
+  const form = new FormData(event.target);
+  try {
+    await api("POST", "search/words/merge", { from: form.get("from"), to: form.get("to") });
+    setStatus("Merged " + form.get("from") + " into " + form.get("to"));
+    event.target.reset();
+    refresh();
+  } catch (err) {
+    setStatus(err.message, true);
+  }
+});
+
+document.getElementById("user-form").addEventListener("submit", async (event) => {
+  event.preventDefault();
+  try {
+    await loadUser(new FormData(event.target).get("user_id"));
+  } catch (err) {
+    setStatus(err.message, true);
+  }
+});
+
+refresh();
+setInterval(() => loadRecent().catch((err) => setStatus(err.message, true)), 5000);
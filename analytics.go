@@ -0,0 +1,38 @@
+package logsearch
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// GetSearchesBetween returns the words searched over all users in [from, to),
+// most searched first. A stored record keeps only its last search, so all the
+// searches of a word by a user count at the time of its last one. Only stored
+// searches count, not pending or buffered ones. The store must implement
+// store.AnalyticsStore.
+func (sl *SearchLoggerV2) GetSearchesBetween(ctx context.Context, from, to time.Time) ([]store.WordCount, error) {
+	analyticsStore, ok := sl.db.(store.AnalyticsStore)
+	if !ok {
+		return nil, errors.New("store does not support search analytics")
+	}
+
+	counts, err := analyticsStore.SearchesBetween(ctx, from, to)
+	return counts, store.Classify(err)
+}
+
+// GetSearchHistogram counts the distinct words and the searches of every hour
+// or day of [from, to) for usage dashboards, see GetSearchesBetween. The
+// buckets are in time order and start in UTC, those without searches are
+// omitted.
+func (sl *SearchLoggerV2) GetSearchHistogram(ctx context.Context, from, to time.Time, granularity store.Granularity) ([]store.SearchBucket, error) {
+	analyticsStore, ok := sl.db.(store.AnalyticsStore)
+	if !ok {
+		return nil, errors.New("store does not support search analytics")
+	}
+
+	buckets, err := analyticsStore.SearchHistogram(ctx, from, to, granularity)
+	return buckets, store.Classify(err)
+}
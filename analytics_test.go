@@ -0,0 +1,39 @@
+package logsearch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchLoggerV2_Analytics(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDBV2()
+	logger, err := NewSearchLoggerV2WithDB(db)
+	require.NoError(t, err)
+	defer logger.Close()
+
+	morning := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	for i, search := range []struct {
+		user string
+		word string
+	}{
+		{"user_1", "cat"},
+		{"user_2", "cat"},
+		{"user_2", "dog"},
+	} {
+		require.NoError(t, logger.storeOrExtendUserSearch(ctx, search.user, search.word, morning.Add(time.Duration(i)*time.Hour), false, store.Region{}))
+	}
+
+	counts, err := logger.GetSearchesBetween(ctx, morning, morning.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []store.WordCount{{Word: "cat", Count: 2}}, counts)
+
+	buckets, err := logger.GetSearchHistogram(ctx, morning, morning.Add(24*time.Hour), store.Daily)
+	require.NoError(t, err)
+	assert.Equal(t, []store.SearchBucket{{Start: morning.Truncate(24 * time.Hour), UniqueWords: 2, Searches: 3}}, buckets)
+}
@@ -0,0 +1,172 @@
+// Package anomaly flags the words whose searches spike, counting them in
+// rolling time buckets and comparing the current bucket to the trailing ones,
+// e.g. to spot an incident when "login not working" is suddenly searched, or
+// abuse hammering one word.
+//
+// A Detector is registered as a hook of a logger with Hook, or fed by the
+// webhook package to notify the spikes as term.spike events. A nil *Detector
+// is valid and raises nothing.
+package anomaly
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/afanwang/logsearch"
+)
+
+// Spike is a word searched far more in a bucket than in the trailing ones
+type Spike struct {
+	Word string
+	// Count is the number of searches of the word in the bucket
+	Count int64
+	// Mean and StdDev are those of the counts of the word in the baseline buckets
+	Mean   float64
+	StdDev float64
+	// Start is the start of the bucket, At the search that raised the spike
+	Start time.Time
+	At    time.Time
+}
+
+// bucket counts the searches of one period of the ring
+type bucket struct {
+	start  time.Time
+	counts map[string]int64
+	// flagged are the words whose spike was raised in the period
+	flagged map[string]bool
+}
+
+// Detector counts the searches of every word in a ring of fixed width
+// buckets, the current one and the baseline before it, and raises a Spike the
+// first time the count of a word in the current bucket exceeds the mean of
+// its baseline by the given number of standard deviations. It is safe for
+// concurrent use.
+type Detector struct {
+	width    time.Duration
+	sigmas   float64
+	minCount int64
+
+	mutex   sync.Mutex
+	buckets []bucket
+	// started is the start of the first bucket counted, no spike is raised
+	// before a full baseline was counted since
+	started time.Time
+}
+
+// Option configures optional Detector behavior
+type Option func(*Detector)
+
+// WithMinCount only raises the spikes of at least n searches in a bucket, 10
+// by default, so a word searched for the first time a few times is not a spike
+func WithMinCount(n int64) Option {
+	return func(d *Detector) {
+		if n > 0 {
+			d.minCount = n
+		}
+	}
+}
+
+// New creates a detector of buckets of the given width, comparing the current
+// bucket to the baseline buckets before it, e.g. New(5*time.Minute, 12, 3)
+// raises a spike when the searches of a word in the last 5 minutes exceed
+// their mean over the hour before by 3 standard deviations
+func New(width time.Duration, baseline int, sigmas float64, opts ...Option) *Detector {
+	if width <= 0 {
+		width = 5 * time.Minute
+	}
+	if baseline <= 0 {
+		baseline = 1
+	}
+	if sigmas <= 0 {
+		sigmas = 3
+	}
+	d := &Detector{width: width, sigmas: sigmas, minCount: 10, buckets: make([]bucket, baseline+1)}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// index returns the index in the ring of the bucket starting at start
+func (d *Detector) index(start time.Time) int {
+	index := (start.UnixNano() / int64(d.width)) % int64(len(d.buckets))
+	if index < 0 {
+		index += int64(len(d.buckets))
+	}
+	return int(index)
+}
+
+// slot returns the bucket of start, resetting it when it still counts an
+// older period, or nil when start is older than the period the bucket counts
+// now. Caller must hold the mutex.
+func (d *Detector) slot(start time.Time) *bucket {
+	b := &d.buckets[d.index(start)]
+	if !b.start.Equal(start) || b.counts == nil {
+		if start.Before(b.start) {
+			return nil
+		}
+		*b = bucket{start: start, counts: make(map[string]int64), flagged: make(map[string]bool)}
+	}
+	return b
+}
+
+// Add counts delta searches of word at at and returns the spike they raise,
+// if any. A word raises one spike per bucket, and searches older than the
+// ring are ignored.
+func (d *Detector) Add(word string, delta int64, at time.Time) (Spike, bool) {
+	if d == nil || delta <= 0 {
+		return Spike{}, false
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	start := at.Truncate(d.width)
+	if d.started.IsZero() {
+		d.started = start
+	}
+	b := d.slot(start)
+	if b == nil {
+		return Spike{}, false
+	}
+	b.counts[word] += delta
+
+	count := b.counts[word]
+	baseline := len(d.buckets) - 1
+	if b.flagged[word] || count < d.minCount || start.Before(d.started.Add(time.Duration(baseline)*d.width)) {
+		return Spike{}, false
+	}
+
+	// The buckets of the baseline not counted yet, or reused by a later period, count zero searches
+	var sum, sumSquares float64
+	for i := 1; i <= baseline; i++ {
+		previous := start.Add(-time.Duration(i) * d.width)
+		var c float64
+		if p := &d.buckets[d.index(previous)]; p.start.Equal(previous) {
+			c = float64(p.counts[word])
+		}
+		sum += c
+		sumSquares += c * c
+	}
+	mean := sum / float64(baseline)
+	stdDev := math.Sqrt(max(sumSquares/float64(baseline)-mean*mean, 0))
+	if float64(count) <= mean+d.sigmas*stdDev {
+		return Spike{}, false
+	}
+
+	b.flagged[word] = true
+	return Spike{Word: word, Count: count, Mean: mean, StdDev: stdDev, Start: start, At: at}, true
+}
+
+// Hook returns a hook counting every finalized word and calling onSpike with
+// the spikes raised, register it with logsearch.WithWordFinalizedHook or
+// trie.WithWordFinalizedHook. onSpike runs on the writing goroutine, like the
+// hooks it must return quickly.
+func (d *Detector) Hook(onSpike func(Spike)) logsearch.WordFinalizedHook {
+	return func(word logsearch.FinalizedWord) {
+		if spike, ok := d.Add(word.Word, int64(word.Count), word.At); ok {
+			onSpike(spike)
+		}
+	}
+}
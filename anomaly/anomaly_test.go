@@ -0,0 +1,72 @@
+package anomaly
+
+import (
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetector(t *testing.T) {
+	detector := New(5*time.Minute, 4, 3, WithMinCount(5))
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// A steady baseline of 2 or 3 searches every 5 minutes
+	for i, count := range []int64{2, 3, 2, 3} {
+		_, ok := detector.Add("login", count, start.Add(time.Duration(i)*5*time.Minute))
+		assert.False(t, ok)
+	}
+
+	now := start.Add(20 * time.Minute)
+	_, ok := detector.Add("login", 4, now)
+	assert.False(t, ok, "Within the baseline")
+	spike, ok := detector.Add("login", 20, now.Add(time.Minute))
+	require.True(t, ok)
+	assert.Equal(t, "login", spike.Word)
+	assert.Equal(t, int64(24), spike.Count)
+	assert.Equal(t, 2.5, spike.Mean)
+	assert.Equal(t, 0.5, spike.StdDev)
+	assert.Equal(t, now, spike.Start)
+
+	// One spike per bucket
+	_, ok = detector.Add("login", 20, now.Add(2*time.Minute))
+	assert.False(t, ok)
+
+	// A word never searched before spikes once it reaches the min count
+	_, ok = detector.Add("outage", 4, now)
+	assert.False(t, ok)
+	_, ok = detector.Add("outage", 1, now)
+	assert.True(t, ok)
+
+	// Searches older than the ring are ignored
+	_, ok = detector.Add("old", 100, start.Add(-time.Hour))
+	assert.False(t, ok)
+}
+
+func TestDetectorWarmUp(t *testing.T) {
+	detector := New(time.Minute, 3, 3, WithMinCount(1))
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// No spike before a full baseline was counted
+	_, ok := detector.Add("bus", 10, start)
+	assert.False(t, ok)
+	_, ok = detector.Add("bus", 100, start.Add(2*time.Minute))
+	assert.False(t, ok)
+	_, ok = detector.Add("bus", 1000, start.Add(3*time.Minute))
+	assert.True(t, ok)
+}
+
+func TestDetectorHook(t *testing.T) {
+	detector := New(time.Minute, 1, 3, WithMinCount(2))
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	var spikes []Spike
+	hook := detector.Hook(func(spike Spike) { spikes = append(spikes, spike) })
+	hook(logsearch.FinalizedWord{Word: "cat", Count: 1, At: start})
+	hook(logsearch.FinalizedWord{Word: "cat", Count: 3, At: start.Add(time.Minute)})
+	require.Len(t, spikes, 1)
+	assert.Equal(t, int64(3), spikes[0].Count)
+	assert.Equal(t, 1.0, spikes[0].Mean)
+}
@@ -0,0 +1,57 @@
+package logsearch
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/afanwang/logsearch/metrics"
+	"github.com/afanwang/logsearch/store"
+	"github.com/afanwang/logsearch/tracing"
+)
+
+// WithAtomicConsolidation lets the store decide in one atomic write whether
+// a search extends a stored prefix of the user, is ignored as the prefix of a
+// stored word, or is stored, instead of reading the words of the user and
+// writing the decision in a second round trip. The store must implement
+// store.UserConsolidateStore. It cannot be combined with WithUserCache,
+// WithWriteBuffer, WithTypoMerge or WithSessionGap, which decide from the
+// words held by the logger, nor with WithClientClock: the store decides
+// without knowing a search arrived late.
+func WithAtomicConsolidation() Option {
+	return func(sl *SearchLoggerV2) {
+		sl.atomicConsolidation = true
+	}
+}
+
+// consolidateInStore stores the search of word with the single write of the
+// store, then reports its decision like consolidateUserSearch
+func (sl *SearchLoggerV2) consolidateInStore(ctx context.Context, userIdentifier, word, surface string, timestamp time.Time, region store.Region) error {
+	writeCtx, span := sl.tracer.Start(ctx, "store.ConsolidateUserSearch", tracing.KeyOp.String(metrics.OpUpdate))
+	start := time.Now()
+	consolidation, err := sl.db.(store.UserConsolidateStore).ConsolidateUserSearch(writeCtx, userIdentifier, word, timestamp)
+	sl.metrics.ObserveWrite(metrics.LoggerV2, metrics.OpUpdate, start, err)
+	tracing.End(span, err)
+	if err != nil {
+		sl.invalidateResults(ctx, userIdentifier)
+		return err
+	}
+
+	switch {
+	case consolidation.Extension != "":
+		fmt.Fprintf(sl.out, " (ignoring prefix of '%s')", consolidation.Extension)
+		sl.decided(ctx, metrics.DecisionIgnore)
+		return nil
+	case consolidation.Extended != "":
+		fmt.Fprintf(sl.out, " (extending '%s' to '%s')", consolidation.Extended, word)
+		sl.decided(ctx, metrics.DecisionExtend)
+		sl.renamed(ctx, userIdentifier, consolidation.Extended, word, timestamp)
+	default:
+		fmt.Fprintf(sl.out, " (new)")
+		sl.decided(ctx, metrics.DecisionNew)
+		sl.inserted(ctx, userIdentifier, word, timestamp)
+	}
+	sl.setSurface(ctx, userIdentifier, word, surface)
+	sl.setRegion(ctx, userIdentifier, word, region)
+	return nil
+}
@@ -0,0 +1,47 @@
+package logsearch
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// WithAudience counts the distinct users who searched every word in a
+// HyperLogLog persisted by the store, so GetTopAudiences ranks the words by
+// audience size instead of by searches. Every write adds its user to the
+// audience of the word it stored, costing one more store call, or one per
+// flush with WithWriteBuffer. The store must implement store.AudienceStore.
+func WithAudience() Option {
+	return func(sl *SearchLoggerV2) {
+		sl.audience = true
+	}
+}
+
+// countAudience adds the users of the writes to the audiences of their words.
+// A failure is only logged, the writes themselves succeeded.
+func (sl *SearchLoggerV2) countAudience(ctx context.Context, adds []store.AudienceAdd) {
+	if !sl.audience || len(adds) == 0 {
+		return
+	}
+
+	if err := sl.db.(store.AudienceStore).AddAudiences(ctx, adds); err != nil {
+		log.Printf("Error counting the audience of %d searches: %v", len(adds), store.Classify(err))
+	}
+}
+
+// GetTopAudiences returns the limit stored words searched by the most distinct
+// users, last searched at or after since, with their approximate Users and
+// their search Count. A zero since covers all time. Only users counted since
+// WithAudience was enabled are included. The store must implement store.AudienceStore.
+func (sl *SearchLoggerV2) GetTopAudiences(ctx context.Context, since time.Time, limit int) ([]store.WordCount, error) {
+	audienceStore, ok := sl.db.(store.AudienceStore)
+	if !ok {
+		return nil, errors.New("store does not support audiences")
+	}
+
+	counts, err := audienceStore.TopAudiences(ctx, since, limit)
+	return counts, store.Classify(err)
+}
@@ -0,0 +1,52 @@
+package logsearch
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchLoggerV2_Audience(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts []Option
+	}{
+		{"direct", []Option{WithAudience()}},
+		{"buffered", []Option{WithAudience(), WithWriteBuffer(100, time.Hour)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			logger, err := NewSearchLoggerV2WithDB(store.NewMockPostgresDBV2(), tt.opts...)
+			require.NoError(t, err)
+			defer logger.Close()
+
+			// One user searches "bus" often, many users search "cat" once
+			for i := 0; i < 5; i++ {
+				require.NoError(t, logger.LogSearchV2(ctx, "user_0", "bus"))
+			}
+			for i := 0; i < 3; i++ {
+				user := fmt.Sprintf("user_%d", i)
+				require.NoError(t, logger.LogSearchV2(ctx, user, "ca"))
+				require.NoError(t, logger.LogSearchV2(ctx, user, "cat"))
+			}
+			require.NoError(t, logger.Flush(ctx))
+
+			top, err := logger.GetTopAudiences(ctx, time.Time{}, 10)
+			require.NoError(t, err)
+			require.Len(t, top, 2)
+			assert.Equal(t, "cat", top[0].Word)
+			assert.Equal(t, 3, top[0].Users)
+			assert.Equal(t, store.WordCount{Word: "bus", Count: 5, Users: 1}, top[1])
+
+			// Ranking by searches is unchanged
+			bySearches, err := logger.GetTopSearches(ctx, 1)
+			require.NoError(t, err)
+			assert.Equal(t, "cat", bySearches[0].Word)
+		})
+	}
+}
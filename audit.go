@@ -0,0 +1,77 @@
+package logsearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// actorKey is the context key of WithActor
+type actorKey struct{}
+
+// WithActor returns a copy of ctx naming who makes the administrative
+// mutations done with it, e.g. the operator behind an HTTP request. The
+// mutations the logger records in the audit log carry it.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor of ctx set by WithActor, empty when none
+func ActorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// audit records a mutation the logger made with the actor of ctx, a no-op
+// unless the store implements store.AuditStore. The mutation is done, so a
+// failure to record it is only logged.
+func (sl *SearchLoggerV2) audit(ctx context.Context, action, target, before, after string) {
+	if _, ok := sl.db.(store.AuditStore); !ok {
+		return
+	}
+
+	entry := store.AuditEntry{Actor: ActorFrom(ctx), Action: action, Target: target, Before: before, After: after}
+	if _, err := sl.RecordAudit(ctx, entry); err != nil {
+		log.Printf("Error recording %s of %q by %s: %v", action, target, entry.Actor, err)
+	}
+}
+
+// RecordAudit appends entry to the audit log of the administrative mutations,
+// stamping it with the current time when At is zero, and returns its ID. The
+// store must implement store.AuditStore.
+func (sl *SearchLoggerV2) RecordAudit(ctx context.Context, entry store.AuditEntry) (int64, error) {
+	auditStore, ok := sl.db.(store.AuditStore)
+	if !ok {
+		return 0, errors.New("store does not support the audit log")
+	}
+	if entry.Action == "" {
+		return 0, errors.New("audit entry needs an action")
+	}
+	if entry.At.IsZero() {
+		entry.At = time.Now()
+	}
+
+	id, err := auditStore.AppendAudit(ctx, entry)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record %s: %w", entry.Action, store.Classify(err))
+	}
+	return id, nil
+}
+
+// AuditLog returns the audit entries selected by query, newest first
+func (sl *SearchLoggerV2) AuditLog(ctx context.Context, query store.AuditQuery) ([]store.AuditEntry, error) {
+	auditStore, ok := sl.db.(store.AuditStore)
+	if !ok {
+		return nil, errors.New("store does not support the audit log")
+	}
+
+	entries, err := auditStore.ListAudit(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the audit log: %w", store.Classify(err))
+	}
+	return entries, nil
+}
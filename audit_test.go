@@ -0,0 +1,69 @@
+package logsearch
+
+import (
+	"context"
+	"testing"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchLoggerV2_Audit(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLoggerV2WithDB(store.NewMockPostgresDBV2())
+	require.NoError(t, err)
+	defer logger.Close()
+
+	_, err = logger.RecordAudit(ctx, store.AuditEntry{Actor: "alice"})
+	assert.Error(t, err)
+
+	id, err := logger.RecordAudit(ctx, store.AuditEntry{Actor: "alice", Action: "delete-user", Target: "user_1", Before: "2 searches"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), id)
+	_, err = logger.RecordAudit(ctx, store.AuditEntry{Actor: "bob", Action: "verify", Target: "bus"})
+	require.NoError(t, err)
+
+	entries, err := logger.AuditLog(ctx, store.AuditQuery{})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "verify", entries[0].Action)
+	assert.False(t, entries[0].At.IsZero())
+
+	entries, err = logger.AuditLog(ctx, store.AuditQuery{Actor: "alice"})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "user_1", entries[0].Target)
+}
+
+func TestSearchLoggerV2_AuditMutations(t *testing.T) {
+	ctx := WithActor(context.Background(), "alice")
+	logger, err := NewSearchLoggerV2WithDB(store.NewMockPostgresDBV2(), WithSynonyms(true))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	require.NoError(t, logger.AddSynonym(ctx, "tv", "telly"))
+	require.NoError(t, logger.AddSynonym(ctx, "tv", "television"))
+	require.NoError(t, logger.RemoveSynonym(ctx, "tv"))
+	assert.Error(t, logger.RemoveSynonym(ctx, "tv"))
+
+	for _, user := range []string{"user_1", "user_2"} {
+		require.NoError(t, logger.LogSearchV2(ctx, user, "nyc"))
+	}
+	require.NoError(t, logger.MergeWords(ctx, "NYC", "new york"))
+	assert.ErrorIs(t, logger.RenameWord(ctx, "nyc", "new york"), ErrWordNotFound)
+
+	// The entries hold the state before and after each mutation that happened
+	entries, err := logger.AuditLog(ctx, store.AuditQuery{Actor: "alice"})
+	require.NoError(t, err)
+	var got [][4]string
+	for i := len(entries) - 1; i >= 0; i-- {
+		got = append(got, [4]string{entries[i].Action, entries[i].Target, entries[i].Before, entries[i].After})
+	}
+	assert.Equal(t, [][4]string{
+		{"add-synonym", "tv", "", "telly"},
+		{"add-synonym", "tv", "telly", "television"},
+		{"remove-synonym", "tv", "television", ""},
+		{"merge-words", "nyc", "2 searches", "new york"},
+	}, got)
+}
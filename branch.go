@@ -0,0 +1,69 @@
+package logsearch
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// WithBranchDetection treats a search diverging from the previous word of the
+// session as a correction of it: a user typing "busin", backspacing to "busi"
+// and typing "busia" settled on "busia", so the stored "busin" is renamed to
+// it instead of being kept next to it. Both words must share at least
+// minShared characters, so "cat" followed by "dog" stays two searches, and
+// neither may extend the other. It needs WithSessionGap to tell the words of
+// the current session.
+func WithBranchDetection(minShared int) Option {
+	return func(sl *SearchLoggerV2) {
+		sl.branchMinShared = max(minShared, 1)
+	}
+}
+
+// correctedBranch returns the previous word of the session when word is a
+// sibling branch of it, see WithBranchDetection. The previous word must still
+// be among the sorted stored words of the session.
+func (sl *SearchLoggerV2) correctedBranch(sorted []string, previous, word string) (string, bool) {
+	if sl.branchMinShared == 0 || previous == "" || previous == word {
+		return "", false
+	}
+	if i := sort.SearchStrings(sorted, previous); i == len(sorted) || sorted[i] != previous {
+		return "", false
+	}
+	if strings.HasPrefix(word, previous) || strings.HasPrefix(previous, word) {
+		return "", false
+	}
+	return previous, sharedRunes(previous, word) >= sl.branchMinShared
+}
+
+// lateBranch returns the stored word that corrected word when word is a late
+// search of a sibling branch of it, see WithClientClock. Delivered in order
+// word would have been renamed to it.
+func (sl *SearchLoggerV2) lateBranch(sorted []string, word string, late bool) (string, bool) {
+	if !late || sl.branchMinShared == 0 {
+		return "", false
+	}
+	for _, stored := range sorted {
+		if strings.HasPrefix(word, stored) || strings.HasPrefix(stored, word) {
+			continue
+		}
+		if sharedRunes(stored, word) >= sl.branchMinShared {
+			return stored, true
+		}
+	}
+	return "", false
+}
+
+// sharedRunes returns the number of runes of the longest common prefix of a and b
+func sharedRunes(a, b string) int {
+	n := 0
+	for a != "" && b != "" {
+		ra, sizeA := utf8.DecodeRuneInString(a)
+		rb, sizeB := utf8.DecodeRuneInString(b)
+		if ra != rb {
+			break
+		}
+		a, b = a[sizeA:], b[sizeB:]
+		n++
+	}
+	return n
+}
@@ -0,0 +1,53 @@
+package logsearch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchLoggerV2_BranchDetection(t *testing.T) {
+	_, err := NewSearchLoggerV2WithDB(store.NewMockPostgresDBV2(), WithBranchDetection(3))
+	assert.Error(t, err)
+
+	for _, tt := range []struct {
+		name string
+		opts []Option
+	}{
+		{"direct", nil},
+		{"buffered", []Option{WithWriteBuffer(100, time.Hour)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := store.NewMockPostgresDBV2()
+			logger, err := NewSearchLoggerV2WithDB(db, append([]Option{WithSessionGap(30 * time.Minute), WithBranchDetection(3)}, tt.opts...)...)
+			require.NoError(t, err)
+			defer logger.Close()
+
+			now := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+			for i, word := range []string{"b", "bu", "bus", "busi", "busin", "busi", "busia", "cat", "dog"} {
+				require.NoError(t, logger.storeOrExtendUserSearch(ctx, "user_1", word, now.Add(time.Duration(i)*time.Second), false, store.Region{}))
+			}
+			require.NoError(t, logger.Flush(ctx))
+
+			var words []string
+			require.NoError(t, db.ForEachUserSearch(ctx, "user_1", func(record store.UserSearchRecord) error {
+				words = append(words, record.SearchWord)
+				return nil
+			}))
+			// "busin" was corrected to "busia", "cat" and "dog" share no prefix
+			assert.ElementsMatch(t, []string{"busia", "cat", "dog"}, words)
+		})
+	}
+}
+
+func TestSharedRunes(t *testing.T) {
+	assert.Equal(t, 4, sharedRunes("busin", "busia"))
+	assert.Equal(t, 3, sharedRunes("café", "caffè"))
+	assert.Equal(t, 3, sharedRunes("été", "étés"))
+	assert.Equal(t, 0, sharedRunes("", "cat"))
+}
@@ -0,0 +1,149 @@
+// Package cache holds query results for a short while so read-heavy traffic,
+// e.g. autocomplete, does not reach the store on every request. NewMemory
+// keeps them in process, NewRedis shares them between every instance.
+//
+// An entry is a field of a key and deleting a key drops all its fields, so a
+// write drops every cached read it affects with a single call:
+//
+//	c := cache.NewMemory(100000)
+//	c.Set(ctx, "user_1", "suggest:bu", value, time.Minute)
+//	c.Delete(ctx, "user_1")
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Cache stores byte values under a key and a field for a limited time.
+// Implementations are safe for concurrent use.
+type Cache interface {
+	// Get returns the value of field of key, false when missing or expired
+	Get(ctx context.Context, key, field string) ([]byte, bool, error)
+	// Set stores value as field of key for ttl
+	Set(ctx context.Context, key, field string, value []byte, ttl time.Duration) error
+	// Delete drops every field of keys
+	Delete(ctx context.Context, keys ...string) error
+	// Clear drops every key
+	Clear(ctx context.Context) error
+}
+
+// Memory is a Cache in process memory holding at most maxEntries fields, the
+// least recently used keys are evicted first
+type Memory struct {
+	mutex      sync.Mutex
+	maxEntries int
+	entries    int
+	// lru holds *memoryKey, most recently used at the front
+	lru  *list.List
+	keys map[string]*list.Element
+}
+
+type memoryKey struct {
+	key    string
+	fields map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+var _ Cache = (*Memory)(nil)
+
+// NewMemory creates a Memory cache holding at most maxEntries fields, at least one
+func NewMemory(maxEntries int) *Memory {
+	return &Memory{
+		maxEntries: max(maxEntries, 1),
+		lru:        list.New(),
+		keys:       make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value of field of key, false when missing or expired
+func (m *Memory) Get(_ context.Context, key, field string) ([]byte, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	elem, ok := m.keys[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entries := elem.Value.(*memoryKey)
+	entry, ok := entries.fields[field]
+	if !ok {
+		return nil, false, nil
+	}
+	if !time.Now().Before(entry.expires) {
+		delete(entries.fields, field)
+		m.entries--
+		return nil, false, nil
+	}
+
+	m.lru.MoveToFront(elem)
+	return entry.value, true, nil
+}
+
+// Set stores value as field of key for ttl, evicting idle keys beyond the cap
+func (m *Memory) Set(_ context.Context, key, field string, value []byte, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	elem, ok := m.keys[key]
+	if ok {
+		m.lru.MoveToFront(elem)
+	} else {
+		elem = m.lru.PushFront(&memoryKey{key: key, fields: make(map[string]memoryEntry)})
+		m.keys[key] = elem
+	}
+	entries := elem.Value.(*memoryKey)
+	if _, ok := entries.fields[field]; !ok {
+		m.entries++
+	}
+	entries.fields[field] = memoryEntry{value: value, expires: time.Now().Add(ttl)}
+
+	for m.entries > m.maxEntries && m.lru.Len() > 1 {
+		m.remove(m.lru.Back())
+	}
+	return nil
+}
+
+// Delete drops every field of keys
+func (m *Memory) Delete(_ context.Context, keys ...string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, key := range keys {
+		if elem, ok := m.keys[key]; ok {
+			m.remove(elem)
+		}
+	}
+	return nil
+}
+
+// Clear drops every key
+func (m *Memory) Clear(context.Context) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.lru.Init()
+	m.keys = make(map[string]*list.Element)
+	m.entries = 0
+	return nil
+}
+
+// Len returns how many fields are cached, expired ones included until they are read or evicted
+func (m *Memory) Len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.entries
+}
+
+// remove drops a key and its fields, caller must hold the mutex
+func (m *Memory) remove(elem *list.Element) {
+	entries := m.lru.Remove(elem).(*memoryKey)
+	delete(m.keys, entries.key)
+	m.entries -= len(entries.fields)
+}
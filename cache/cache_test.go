@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// testCache checks the behavior shared by every Cache
+func testCache(t *testing.T, c Cache) {
+	ctx := context.Background()
+
+	_, ok, err := c.Get(ctx, "user_1", "suggest")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, c.Set(ctx, "user_1", "suggest", []byte("bus"), time.Minute))
+	require.NoError(t, c.Set(ctx, "user_1", "searches", []byte("business"), time.Minute))
+	require.NoError(t, c.Set(ctx, "user_2", "suggest", []byte("cat"), time.Minute))
+	value, ok, err := c.Get(ctx, "user_1", "suggest")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("bus"), value)
+
+	// Deleting a key drops all its fields, not the other keys
+	require.NoError(t, c.Delete(ctx, "user_1"))
+	_, ok, _ = c.Get(ctx, "user_1", "suggest")
+	assert.False(t, ok)
+	_, ok, _ = c.Get(ctx, "user_1", "searches")
+	assert.False(t, ok)
+	_, ok, _ = c.Get(ctx, "user_2", "suggest")
+	assert.True(t, ok)
+
+	// The fields expire after their ttl
+	require.NoError(t, c.Set(ctx, "user_3", "suggest", []byte("dog"), 20*time.Millisecond))
+	time.Sleep(30 * time.Millisecond)
+	_, ok, err = c.Get(ctx, "user_3", "suggest")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, c.Clear(ctx))
+	_, ok, _ = c.Get(ctx, "user_2", "suggest")
+	assert.False(t, ok)
+}
+
+func TestMemory(t *testing.T) {
+	testCache(t, NewMemory(100))
+
+	// The least recently used keys are evicted beyond the cap
+	ctx := context.Background()
+	c := NewMemory(3)
+	require.NoError(t, c.Set(ctx, "a", "1", nil, time.Minute))
+	require.NoError(t, c.Set(ctx, "a", "2", nil, time.Minute))
+	require.NoError(t, c.Set(ctx, "b", "1", nil, time.Minute))
+	_, _, _ = c.Get(ctx, "a", "1")
+	require.NoError(t, c.Set(ctx, "c", "1", nil, time.Minute))
+	assert.Equal(t, 3, c.Len())
+	_, ok, _ := c.Get(ctx, "b", "1")
+	assert.False(t, ok, "the idle key is evicted")
+	_, ok, _ = c.Get(ctx, "a", "2")
+	assert.True(t, ok)
+}
+
+func TestRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	c, err := NewRedis(store.RedisConfig{Addr: server.Addr()})
+	require.NoError(t, err)
+	defer c.Close()
+	testCache(t, c)
+
+	// The keys are namespaced and live as long as their latest field
+	require.NoError(t, c.Set(context.Background(), "user_1", "suggest", []byte("bus"), time.Minute))
+	assert.True(t, server.Exists("logsearch:cache:user_1"))
+	server.FastForward(2 * time.Minute)
+	assert.False(t, server.Exists("logsearch:cache:user_1"))
+
+	_, err = NewRedis(store.RedisConfig{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// Redis is a Cache shared by every instance using the same Redis server, a
+// write on one instance drops the results cached by all of them. Every key is
+// a hash of its fields, each value prefixed with its expiry since a hash field
+// does not expire on its own.
+type Redis struct {
+	client       *redis.Client
+	prefix       string
+	queryTimeout time.Duration
+}
+
+var _ Cache = (*Redis)(nil)
+
+// NewRedis connects to Redis and verifies the connection with a ping. The keys
+// are namespaced by cfg.KeyPrefix followed by "cache:", so a RedisDBV2 can
+// share the server.
+func NewRedis(cfg store.RedisConfig) (*Redis, error) {
+	if cfg.Addr == "" {
+		return nil, fmt.Errorf("redis address cannot be empty")
+	}
+
+	prefix := cfg.KeyPrefix
+	if prefix == "" {
+		prefix = "logsearch:"
+	}
+	queryTimeout := cfg.QueryTimeout
+	if queryTimeout <= 0 {
+		queryTimeout = time.Second
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: cfg.Addr, Password: cfg.Password, DB: cfg.DB})
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+
+	return &Redis{client: client, prefix: prefix + "cache:", queryTimeout: queryTimeout}, nil
+}
+
+// queryContext bounds the caller's context by the configured query timeout
+func (r *Redis) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, r.queryTimeout)
+}
+
+// Get returns the value of field of key, false when missing or expired
+func (r *Redis) Get(ctx context.Context, key, field string) ([]byte, bool, error) {
+	ctx, cancel := r.queryContext(ctx)
+	defer cancel()
+
+	raw, err := r.client.HGet(ctx, r.prefix+key, field).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if len(raw) < 8 || time.Now().UnixNano() >= int64(binary.BigEndian.Uint64(raw)) {
+		return nil, false, r.client.HDel(ctx, r.prefix+key, field).Err()
+	}
+	return raw[8:], true, nil
+}
+
+// Set stores value as field of key for ttl. The key lives as long as its
+// latest field, so the expired fields of an idle key are dropped with it.
+func (r *Redis) Set(ctx context.Context, key, field string, value []byte, ttl time.Duration) error {
+	ctx, cancel := r.queryContext(ctx)
+	defer cancel()
+
+	raw := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(raw, uint64(time.Now().Add(ttl).UnixNano()))
+	copy(raw[8:], value)
+
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, r.prefix+key, field, raw)
+		pipe.PExpire(ctx, r.prefix+key, ttl)
+		return nil
+	})
+	return err
+}
+
+// Delete drops every field of keys
+func (r *Redis) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	ctx, cancel := r.queryContext(ctx)
+	defer cancel()
+
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = r.prefix + key
+	}
+	return r.client.Del(ctx, prefixed...).Err()
+}
+
+// Clear drops every key of the cache, scanning them in batches
+func (r *Redis) Clear(ctx context.Context) error {
+	ctx, cancel := r.queryContext(ctx)
+	defer cancel()
+
+	var cursor uint64
+	for {
+		keys, next, err := r.client.Scan(ctx, cursor, r.prefix+"*", 1000).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := r.client.Del(ctx, keys...).Err(); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// Close closes the connection to Redis
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,236 @@
+// Package capture logs the searches served by existing search handlers
+// without changing them: a net/http middleware reads the query parameter and
+// the user of the matching requests and logs them with LogSearchV2 from its
+// own goroutines, so the handlers never wait on the store. The gincapture and
+// echocapture packages adapt it to Gin and Echo.
+package capture
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/afanwang/logsearch"
+)
+
+// Logger logs the captured searches, e.g. a *logsearch.SearchLoggerV2
+type Logger interface {
+	LogSearchV2(ctx context.Context, userIdentifier, word string) error
+}
+
+// Capturer captures the searches of HTTP requests. It is safe for concurrent use.
+type Capturer struct {
+	logger     Logger
+	queryParam string
+	paths      []string
+	identities []func(*http.Request) string
+	workers    int
+
+	queue   chan logsearch.SearchEvent
+	mutex   sync.RWMutex
+	closed  bool
+	dropped atomic.Int64
+	// abort cancels the searches being logged once Close gave up
+	abort       context.Context
+	cancelAbort context.CancelFunc
+	done        sync.WaitGroup
+}
+
+// Option configures optional Capturer behavior
+type Option func(*Capturer)
+
+// WithQueryParam reads the search from the URL query parameter name, "q" by default
+func WithQueryParam(name string) Option {
+	return func(c *Capturer) {
+		if name != "" {
+			c.queryParam = name
+		}
+	}
+}
+
+// WithPaths only captures the requests to these paths, a path ending with a
+// slash matching its whole subtree like http.ServeMux patterns, e.g. "/search"
+// and "/api/search/". By default every request with the query parameter is captured.
+func WithPaths(paths ...string) Option {
+	return func(c *Capturer) {
+		c.paths = append(c.paths, paths...)
+	}
+}
+
+// WithUserHeader identifies the user by the request header name
+func WithUserHeader(name string) Option {
+	return WithIdentity(func(r *http.Request) string {
+		return r.Header.Get(name)
+	})
+}
+
+// WithUserCookie identifies the user by the value of the cookie name
+func WithUserCookie(name string) Option {
+	return WithIdentity(func(r *http.Request) string {
+		cookie, err := r.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return cookie.Value
+	})
+}
+
+// WithIdentity identifies the user with fn, e.g. from a session or a token
+// validated by an earlier middleware. Identities are tried in the order of
+// the options and the first non-empty one wins. Requests without a user are
+// not captured. By default the user is the X-User-ID header.
+func WithIdentity(fn func(*http.Request) string) Option {
+	return func(c *Capturer) {
+		c.identities = append(c.identities, fn)
+	}
+}
+
+// WithQueueSize bounds the searches waiting to be logged, 1000 by default.
+// Searches captured while the queue is full are dropped rather than slowing
+// the handlers down, see Dropped.
+func WithQueueSize(size int) Option {
+	return func(c *Capturer) {
+		if size > 0 {
+			c.queue = make(chan logsearch.SearchEvent, size)
+		}
+	}
+}
+
+// WithWorkers logs the queued searches from n goroutines, 1 by default. With
+// more than one the searches of a user may be logged out of order.
+func WithWorkers(n int) Option {
+	return func(c *Capturer) {
+		if n > 0 {
+			c.workers = n
+		}
+	}
+}
+
+// New starts a Capturer logging into logger, stop it with Close
+func New(logger Logger, opts ...Option) *Capturer {
+	c := &Capturer{
+		logger:     logger,
+		queryParam: "q",
+		workers:    1,
+		queue:      make(chan logsearch.SearchEvent, 1000),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if len(c.identities) == 0 {
+		WithUserHeader("X-User-ID")(c)
+	}
+	c.abort, c.cancelAbort = context.WithCancel(context.Background())
+
+	c.done.Add(c.workers)
+	for i := 0; i < c.workers; i++ {
+		go c.run()
+	}
+	return c
+}
+
+// Middleware captures the searches of the requests before passing them to next
+func (c *Capturer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Capture(r)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Capture queues the search of r, if r is a search of a known user to a
+// matching path. It never blocks, which lets frameworks call it from their
+// own middleware.
+func (c *Capturer) Capture(r *http.Request) {
+	if !c.matches(r.URL.Path) {
+		return
+	}
+	query := r.URL.Query().Get(c.queryParam)
+	if strings.TrimSpace(query) == "" {
+		return
+	}
+	userIdentifier := c.identify(r)
+	if userIdentifier == "" {
+		return
+	}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.queue <- logsearch.SearchEvent{UserIdentifier: userIdentifier, Query: query}:
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+// matches reports whether the searches of path are captured
+func (c *Capturer) matches(path string) bool {
+	if len(c.paths) == 0 {
+		return true
+	}
+	for _, pattern := range c.paths {
+		if path == pattern || strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// identify returns the first identity of the user of r
+func (c *Capturer) identify(r *http.Request) string {
+	for _, identity := range c.identities {
+		if userIdentifier := identity(r); userIdentifier != "" {
+			return userIdentifier
+		}
+	}
+	return ""
+}
+
+// Dropped returns the number of searches dropped because the queue was full
+func (c *Capturer) Dropped() int64 {
+	return c.dropped.Load()
+}
+
+// Close logs the queued searches and stops the Capturer. Once ctx is done it
+// drops what is left and returns the error of ctx. Searches captured after
+// Close are ignored.
+func (c *Capturer) Close(ctx context.Context) error {
+	c.mutex.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.queue)
+	}
+	c.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.done.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		c.cancelAbort()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// run logs the queued searches until the queue is closed
+func (c *Capturer) run() {
+	defer c.done.Done()
+	for event := range c.queue {
+		if c.abort.Err() != nil {
+			continue
+		}
+		if err := c.logger.LogSearchV2(c.abort, event.UserIdentifier, event.Query); err != nil {
+			log.Printf("Failed to log the captured search %q of %s: %v", event.Query, event.UserIdentifier, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package capture
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder is a Logger recording the searches it logs, blocking on release if set
+type recorder struct {
+	mutex    sync.Mutex
+	searches []string
+	release  chan struct{}
+}
+
+func (r *recorder) LogSearchV2(ctx context.Context, userIdentifier, word string) error {
+	if r.release != nil {
+		select {
+		case <-r.release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.searches = append(r.searches, userIdentifier+":"+word)
+	return nil
+}
+
+func (r *recorder) logged() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string(nil), r.searches...)
+}
+
+func TestMiddleware(t *testing.T) {
+	r := &recorder{}
+	c := New(r, WithQueryParam("query"), WithPaths("/search", "/api/search/"), WithUserCookie("session"), WithUserHeader("X-Anon-ID"))
+
+	served := 0
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+	}))
+	for _, request := range []struct {
+		target string
+		cookie string
+		anonID string
+	}{
+		{"/search?query=cat", "user_1", ""},
+		{"/api/search/products?query=dog&page=2", "", "anon_1"},
+		// The cookie wins over the header
+		{"/search?query=bus", "user_2", "anon_2"},
+		// Not captured: other path, no query, blank query, no user
+		{"/searches?query=cat", "user_1", ""},
+		{"/api/search?query=cat", "user_1", ""},
+		{"/search?q=cat", "user_1", ""},
+		{"/search?query=+", "user_1", ""},
+		{"/search?query=cat", "", ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, request.target, nil)
+		if request.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "session", Value: request.cookie})
+		}
+		if request.anonID != "" {
+			req.Header.Set("X-Anon-ID", request.anonID)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	require.NoError(t, c.Close(context.Background()))
+	assert.Equal(t, 8, served)
+	assert.Equal(t, []string{"user_1:cat", "anon_1:dog", "user_2:bus"}, r.logged())
+
+	// Captured after Close, ignored
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/search?query=late", nil))
+	assert.Len(t, r.logged(), 3)
+}
+
+func TestCaptureDropsWhenTheQueueIsFull(t *testing.T) {
+	r := &recorder{release: make(chan struct{})}
+	c := New(r, WithQueueSize(1))
+
+	capture := func(query string) {
+		req := httptest.NewRequest(http.MethodGet, "/?q="+query, nil)
+		req.Header.Set("X-User-ID", "user_1")
+		c.Capture(req)
+	}
+	// The worker blocks on the first search, the second waits in the queue
+	capture("a")
+	assert.Eventually(t, func() bool { return len(c.queue) == 0 }, time.Second, time.Millisecond)
+	capture("b")
+	capture("c")
+	assert.Equal(t, int64(1), c.Dropped())
+
+	close(r.release)
+	require.NoError(t, c.Close(context.Background()))
+	assert.Equal(t, []string{"user_1:a", "user_1:b"}, r.logged())
+}
+
+func TestCloseGivesUp(t *testing.T) {
+	r := &recorder{release: make(chan struct{})}
+	c := New(r)
+	req := httptest.NewRequest(http.MethodGet, "/?q=cat", nil)
+	req.Header.Set("X-User-ID", "user_1")
+	c.Capture(req)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, c.Close(ctx), context.DeadlineExceeded)
+	assert.Empty(t, r.logged())
+}
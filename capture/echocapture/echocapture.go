@@ -0,0 +1,19 @@
+// Package echocapture adapts a capture.Capturer to Echo
+package echocapture
+
+import (
+	"github.com/labstack/echo/v4"
+
+	"github.com/afanwang/logsearch/capture"
+)
+
+// Middleware captures the searches of the requests of an Echo server, e.g.
+// e.Use(echocapture.Middleware(c))
+func Middleware(c *capture.Capturer) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			c.Capture(ctx.Request())
+			return next(ctx)
+		}
+	}
+}
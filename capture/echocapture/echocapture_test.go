@@ -0,0 +1,47 @@
+package echocapture
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/afanwang/logsearch/capture"
+)
+
+type recorder struct {
+	mutex    sync.Mutex
+	searches []string
+}
+
+func (r *recorder) LogSearchV2(ctx context.Context, userIdentifier, word string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.searches = append(r.searches, userIdentifier+":"+word)
+	return nil
+}
+
+func TestMiddleware(t *testing.T) {
+	r := &recorder{}
+	c := capture.New(r, capture.WithPaths("/search"))
+
+	e := echo.New()
+	e.Use(Middleware(c))
+	e.GET("/search", func(ctx echo.Context) error {
+		return ctx.String(http.StatusOK, "results")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/search?q=cat", nil)
+	req.Header.Set("X-User-ID", "user_1")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	assert.Equal(t, "results", w.Body.String())
+
+	require.NoError(t, c.Close(context.Background()))
+	assert.Equal(t, []string{"user_1:cat"}, r.searches)
+}
@@ -0,0 +1,17 @@
+// Package gincapture adapts a capture.Capturer to Gin
+package gincapture
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/afanwang/logsearch/capture"
+)
+
+// Middleware captures the searches of the requests of a Gin router, e.g.
+// router.Use(gincapture.Middleware(c))
+func Middleware(c *capture.Capturer) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		c.Capture(ctx.Request)
+		ctx.Next()
+	}
+}
@@ -0,0 +1,240 @@
+// Package changes streams the mutations of the vocabulary store of the trie
+// logger, so external systems such as a search index or a cache warmer can
+// mirror the stored words instead of polling GetAllSearchedWords. The trie
+// logger emits a Change after every write the store committed, see
+// trie.WithChangeStream. A Stream numbers the changes in that order, queues
+// them in memory and publishes them in batches from its own goroutine through
+// a Publisher: a channel, a JSON Lines file or a Kafka topic. A failed batch
+// is retried with exponential backoff before the next one is published, so
+// the order is kept and every change is published at least once, unless the
+// process dies, the batch is rejected for good or Close gives up first.
+package changes
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// Op is the kind of mutation of a Change
+type Op string
+
+const (
+	// OpInsert is a search of Word, which inserted its record or added Count to it
+	OpInsert Op = "insert"
+	// OpUpdate renamed the record of OldWord to Word, or reviewed Word when Verified is set
+	OpUpdate Op = "update"
+	// OpDelete removed the record of Word
+	OpDelete Op = "delete"
+)
+
+// Change is a mutation of a record of the store
+type Change struct {
+	// Seq numbers the changes of a Stream from its first sequence number, without gaps
+	Seq uint64 `json:"seq"`
+	Op  Op     `json:"op"`
+	// ID is the ID of the record, 0 when unknown, e.g. for the purged words
+	ID   int64  `json:"id,omitempty"`
+	Word string `json:"word"`
+	// OldWord is the word an update renamed
+	OldWord string `json:"old_word,omitempty"`
+	// Count is how many searches the change added to the record
+	Count int `json:"count,omitempty"`
+	// Verified is the review of the word set by a moderator
+	Verified *bool     `json:"verified,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// Publisher publishes a batch of changes in order. An error retries the
+// batch, unless it is a *StatusError that is not Temporary.
+type Publisher interface {
+	Publish(ctx context.Context, changes []Change) error
+}
+
+// Stream numbers the changes and publishes them through a Publisher. It is safe for concurrent use.
+type Stream struct {
+	publisher  Publisher
+	batchSize  int
+	interval   time.Duration
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	// mutex keeps the changes queued in the order of their Seq
+	mutex   sync.Mutex
+	nextSeq uint64
+	queue   chan Change
+	closed  bool
+	// abort cancels the publication once Close gave up
+	abort       context.Context
+	cancelAbort context.CancelFunc
+	done        chan struct{}
+}
+
+// Option configures optional Stream behavior
+type Option func(*Stream)
+
+// WithBatchSize publishes a batch once it holds size changes, 500 by default
+func WithBatchSize(size int) Option {
+	return func(s *Stream) {
+		if size > 0 {
+			s.batchSize = size
+		}
+	}
+}
+
+// WithFlushInterval publishes the changes queued for interval even if the batch is not full, 1s by default
+func WithFlushInterval(interval time.Duration) Option {
+	return func(s *Stream) {
+		if interval > 0 {
+			s.interval = interval
+		}
+	}
+}
+
+// WithQueueSize bounds the changes waiting for publication, 10000 by default.
+// Emit blocks while the queue is full rather than dropping changes, slowing
+// the logger down until the publisher recovers.
+func WithQueueSize(size int) Option {
+	return func(s *Stream) {
+		if size > 0 {
+			s.queue = make(chan Change, size)
+		}
+	}
+}
+
+// WithBackoff waits min before retrying a failed batch, doubling the wait up to max, 100ms and 30s by default
+func WithBackoff(min, max time.Duration) Option {
+	return func(s *Stream) {
+		if min > 0 && max >= min {
+			s.minBackoff, s.maxBackoff = min, max
+		}
+	}
+}
+
+// WithFirstSeq numbers the first change seq, 1 by default, e.g. File.LastSeq
+// plus 1 to go on numbering the changes of a file across restarts
+func WithFirstSeq(seq uint64) Option {
+	return func(s *Stream) {
+		if seq > 0 {
+			s.nextSeq = seq
+		}
+	}
+}
+
+// New starts a stream publishing through publisher, stop it with Close
+func New(publisher Publisher, opts ...Option) *Stream {
+	s := &Stream{
+		publisher:  publisher,
+		batchSize:  500,
+		interval:   time.Second,
+		minBackoff: 100 * time.Millisecond,
+		maxBackoff: 30 * time.Second,
+		nextSeq:    1,
+		queue:      make(chan Change, 10000),
+		done:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.abort, s.cancelAbort = context.WithCancel(context.Background())
+
+	go s.run()
+	return s
+}
+
+// Emit numbers change and queues it for publication, the changes emitted
+// after Close are dropped. A nil Stream drops every change.
+func (s *Stream) Emit(change Change) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return
+	}
+	change.Seq = s.nextSeq
+	s.nextSeq++
+	change.At = change.At.UTC()
+	s.queue <- change
+}
+
+// Close publishes the queued changes and stops the stream. Once ctx is done
+// it stops retrying, drops what is left and returns the error of ctx. Close
+// the logger first, so that its last writes reach the stream.
+func (s *Stream) Close(ctx context.Context) error {
+	s.mutex.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mutex.Unlock()
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		s.cancelAbort()
+		<-s.done
+		return ctx.Err()
+	}
+}
+
+// run batches the queued changes until the queue is closed
+func (s *Stream) run() {
+	defer close(s.done)
+	defer s.cancelAbort()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	batch := make([]Change, 0, s.batchSize)
+	for {
+		select {
+		case change, ok := <-s.queue:
+			if !ok {
+				s.publish(batch)
+				return
+			}
+			batch = append(batch, change)
+			if len(batch) < s.batchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		s.publish(batch)
+		batch = batch[:0]
+	}
+}
+
+// publish sends the batch until it succeeds, fails permanently or the stream
+// is aborted. The next batch waits meanwhile, keeping the order.
+func (s *Stream) publish(batch []Change) {
+	if len(batch) == 0 {
+		return
+	}
+
+	backoff := s.minBackoff
+	for {
+		err := s.publisher.Publish(s.abort, batch)
+		if err == nil {
+			return
+		}
+		var status *StatusError
+		if errors.As(err, &status) && !status.Temporary() {
+			log.Printf("Dropping changes %d to %d rejected by the publisher: %v", batch[0].Seq, batch[len(batch)-1].Seq, err)
+			return
+		}
+		log.Printf("Failed to publish changes %d to %d, retrying in %s: %v", batch[0].Seq, batch[len(batch)-1].Seq, backoff, err)
+
+		select {
+		case <-time.After(backoff):
+			backoff = min(2*backoff, s.maxBackoff)
+		case <-s.abort.Done():
+			log.Printf("Dropping changes %d to %d unpublished on close", batch[0].Seq, batch[len(batch)-1].Seq)
+			return
+		}
+	}
+}
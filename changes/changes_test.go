@@ -0,0 +1,230 @@
+package changes
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStream_Order(t *testing.T) {
+	published := make(chan Change)
+	s := New(Channel(published), WithBatchSize(3), WithFlushInterval(time.Millisecond))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var got []Change
+	go func() {
+		defer wg.Done()
+		for change := range published {
+			got = append(got, change)
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		s.Emit(Change{Op: OpInsert, Word: fmt.Sprintf("word_%d", i), At: time.Now()})
+	}
+	require.NoError(t, s.Close(context.Background()))
+	close(published)
+	wg.Wait()
+
+	// The changes are numbered and published in the order they were emitted
+	require.Len(t, got, 10)
+	for i, change := range got {
+		assert.Equal(t, uint64(i+1), change.Seq)
+		assert.Equal(t, fmt.Sprintf("word_%d", i), change.Word)
+	}
+
+	// Emitting after Close, or to a nil stream, drops the change
+	s.Emit(Change{Op: OpInsert, Word: "late"})
+	var disabled *Stream
+	disabled.Emit(Change{Op: OpInsert, Word: "bus"})
+}
+
+func TestStream_Retry(t *testing.T) {
+	var calls atomic.Int32
+	var mutex sync.Mutex
+	var published []Change
+	s := New(PublisherFunc(func(_ context.Context, changes []Change) error {
+		if calls.Add(1) == 1 {
+			return &StatusError{StatusCode: http.StatusServiceUnavailable}
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		published = append(published, changes...)
+		return nil
+	}), WithFlushInterval(time.Millisecond), WithBackoff(time.Millisecond, time.Millisecond))
+
+	s.Emit(Change{Op: OpInsert, Word: "bus"})
+	require.NoError(t, s.Close(context.Background()))
+	assert.Equal(t, int32(2), calls.Load(), "a temporary failure is retried")
+	assert.Len(t, published, 1)
+
+	// A rejected batch is dropped, a batch failing until Close gives up too
+	rejected := New(PublisherFunc(func(context.Context, []Change) error {
+		return &StatusError{StatusCode: http.StatusBadRequest}
+	}), WithFlushInterval(time.Millisecond))
+	rejected.Emit(Change{Op: OpInsert, Word: "bus"})
+	assert.NoError(t, rejected.Close(context.Background()))
+
+	failing := New(PublisherFunc(func(context.Context, []Change) error {
+		return fmt.Errorf("unreachable")
+	}), WithFlushInterval(time.Millisecond), WithBackoff(time.Millisecond, time.Millisecond))
+	failing.Emit(Change{Op: OpInsert, Word: "bus"})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, failing.Close(ctx), context.DeadlineExceeded)
+}
+
+func TestFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "changes.jsonl")
+	f, err := OpenFile(path)
+	require.NoError(t, err)
+	assert.Zero(t, f.LastSeq())
+
+	s := New(f)
+	s.Emit(Change{Op: OpInsert, ID: 1, Word: "bus", Count: 1, At: time.Now()})
+	s.Emit(Change{Op: OpUpdate, ID: 1, Word: "business", OldWord: "bus", Count: 1, At: time.Now()})
+	require.NoError(t, s.Close(ctx))
+	require.NoError(t, f.Close())
+
+	// Reopening the file goes on numbering its changes
+	f, err = OpenFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), f.LastSeq())
+	s = New(f, WithFirstSeq(f.LastSeq()+1))
+	s.Emit(Change{Op: OpDelete, Word: "business", At: time.Now()})
+	require.NoError(t, s.Close(ctx))
+	require.NoError(t, f.Close())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	var ops []Op
+	scanner := bufio.NewScanner(file)
+	for seq := uint64(1); scanner.Scan(); seq++ {
+		var change Change
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &change))
+		assert.Equal(t, seq, change.Seq)
+		ops = append(ops, change.Op)
+	}
+	assert.Equal(t, []Op{OpInsert, OpUpdate, OpDelete}, ops)
+}
+
+func TestKafkaREST(t *testing.T) {
+	var body kafkaRecords
+	status := http.StatusOK
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/topics/search-changes", r.URL.Path)
+		assert.Equal(t, "application/vnd.kafka.json.v2+json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		w.WriteHeader(status)
+	}))
+	defer proxy.Close()
+
+	k, err := NewKafkaREST(proxy.URL+"/", "search-changes", nil)
+	require.NoError(t, err)
+	change := Change{Seq: 1, Op: OpInsert, ID: 1, Word: "bus", Count: 1, At: time.Now().UTC()}
+	require.NoError(t, k.Publish(context.Background(), []Change{change}))
+	require.Len(t, body.Records, 1)
+	assert.Equal(t, "bus", body.Records[0].Key, "the records are keyed by word")
+	assert.True(t, change.At.Equal(body.Records[0].Value.At))
+
+	status = http.StatusUnprocessableEntity
+	err = k.Publish(context.Background(), []Change{change})
+	var statusErr *StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.False(t, statusErr.Temporary())
+
+	_, err = NewKafkaREST(proxy.URL, "", nil)
+	assert.Error(t, err)
+}
+
+func TestHub(t *testing.T) {
+	ctx := context.Background()
+	hub := NewHub(WithBacklog(3))
+	srv := httptest.NewServer(hub)
+	defer srv.Close()
+	follower, err := NewFollower(srv.URL, nil)
+	require.NoError(t, err)
+
+	last, err := follower.LastSeq(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, last)
+
+	// A retried batch is published once
+	batch := []Change{{Seq: 1, Op: OpInsert, Word: "bus"}, {Seq: 2, Op: OpInsert, Word: "cat"}}
+	require.NoError(t, Tee(hub).Publish(ctx, batch))
+	require.NoError(t, hub.Publish(ctx, batch))
+	last, err = follower.LastSeq(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), last)
+
+	// A follower receives the changes of the backlog then the new ones, in order
+	followCtx, cancel := context.WithCancel(ctx)
+	received := make(chan Change, 10)
+	followed := make(chan error, 1)
+	go func() {
+		followed <- follower.Follow(followCtx, 1, func(change Change) error {
+			received <- change
+			return nil
+		})
+	}()
+	assert.Equal(t, "cat", (<-received).Word)
+	require.NoError(t, hub.Publish(ctx, []Change{{Seq: 3, Op: OpDelete, Word: "bus"}}))
+	assert.Equal(t, uint64(3), (<-received).Seq)
+	cancel()
+	assert.ErrorIs(t, <-followed, context.Canceled)
+
+	// The changes out of the backlog, or never published by the hub, are a gap
+	require.NoError(t, hub.Publish(ctx, []Change{{Seq: 4, Op: OpInsert, Word: "dog"}}))
+	for _, after := range []uint64{0, 5} {
+		err = follower.Follow(ctx, after, func(Change) error { return nil })
+		assert.ErrorIs(t, err, ErrGap, after)
+	}
+
+	// A failing apply ends the follow
+	err = follower.Follow(ctx, 3, func(Change) error { return fmt.Errorf("full") })
+	assert.ErrorContains(t, err, "full")
+
+	resp, err := http.Get(srv.URL + "?after=bus")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	hub.Close()
+	resp, err = http.Get(srv.URL + "?after=4")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	_, err = NewFollower("/search/changes", nil)
+	assert.Error(t, err)
+}
+
+func TestFollower_Gap(t *testing.T) {
+	// A hub restarted without going on numbering its changes starts empty
+	hub := NewHub()
+	defer hub.Close()
+	srv := httptest.NewServer(hub)
+	defer srv.Close()
+	follower, err := NewFollower(srv.URL, nil)
+	require.NoError(t, err)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		hub.Publish(context.Background(), []Change{{Seq: 1, Op: OpInsert, Word: "bus"}})
+	}()
+	err = follower.Follow(context.Background(), 7, func(Change) error { return nil })
+	assert.ErrorIs(t, err, ErrGap)
+}
@@ -0,0 +1,169 @@
+package changes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ErrGap is returned by Follower.Follow when the changes following the last
+// applied one cannot be received anymore: the hub dropped them from its
+// backlog, or restarted and numbers its changes anew. The follower has to
+// reload its state from the store and follow from the current LastSeq.
+var ErrGap = errors.New("gap in the followed changes")
+
+// Follower receives the changes served by a Hub
+type Follower struct {
+	url    string
+	client *http.Client
+	// idle is how long the stream may stay silent, heartbeats included, before reconnecting
+	idle       time.Duration
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// NewFollower follows the Hub served at hubURL, e.g.
+// http://primary:8080/search/changes, client may be nil for
+// http.DefaultClient and must not time out the streams
+func NewFollower(hubURL string, client *http.Client) (*Follower, error) {
+	u, err := url.Parse(hubURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hub URL: %w", err)
+	}
+	if !u.IsAbs() {
+		return nil, fmt.Errorf("hub URL must be absolute: %q", hubURL)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Follower{
+		url:        hubURL,
+		client:     client,
+		idle:       time.Minute,
+		minBackoff: 100 * time.Millisecond,
+		maxBackoff: 30 * time.Second,
+	}, nil
+}
+
+// LastSeq returns the sequence number of the last change published by the hub
+func (f *Follower) LastSeq(ctx context.Context) (uint64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return 0, &StatusError{StatusCode: resp.StatusCode, Body: string(excerpt)}
+	}
+
+	var head hubHead
+	if err := json.NewDecoder(resp.Body).Decode(&head); err != nil {
+		return 0, fmt.Errorf("invalid hub answer: %w", err)
+	}
+	return head.LastSeq, nil
+}
+
+// Follow calls apply with every change after the change after, in order and
+// without gaps, until ctx is done, apply fails or a gap is found, returning a
+// wrapped ErrGap then. A broken stream is reconnected with exponential
+// backoff from the last applied change.
+func (f *Follower) Follow(ctx context.Context, after uint64, apply func(Change) error) error {
+	backoff := f.minBackoff
+	for {
+		applied, err := f.follow(ctx, &after, apply)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, ErrGap) || errors.Is(err, errApply) {
+			return err
+		}
+		if applied {
+			backoff = f.minBackoff
+		}
+		log.Printf("Lost the change stream after change %d, reconnecting in %s: %v", after, backoff, err)
+
+		select {
+		case <-time.After(backoff):
+			backoff = min(2*backoff, f.maxBackoff)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// errApply wraps the errors of the apply function of Follow, which end it
+var errApply = errors.New("failed to apply change")
+
+// follow streams the changes after *after once, moving *after along, and
+// reports whether a change was applied
+func (f *Follower) follow(ctx context.Context, after *uint64, apply func(Change) error) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// A stream silent for longer than the heartbeats is dead
+	idle := time.AfterFunc(f.idle, cancel)
+	defer idle.Stop()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.url+"?after="+strconv.FormatUint(*after, 10), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		return false, fmt.Errorf("%w: changes after %d are gone", ErrGap, *after)
+	}
+	if resp.StatusCode != http.StatusOK {
+		excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return false, &StatusError{StatusCode: resp.StatusCode, Body: string(excerpt)}
+	}
+
+	applied := false
+	dec := json.NewDecoder(&idleReader{r: resp.Body, idle: idle, timeout: f.idle})
+	for {
+		var change Change
+		if err := dec.Decode(&change); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return applied, err
+		}
+		if change.Seq != *after+1 {
+			return applied, fmt.Errorf("%w: received change %d after %d", ErrGap, change.Seq, *after)
+		}
+		if err := apply(change); err != nil {
+			return applied, fmt.Errorf("%w %d: %w", errApply, change.Seq, err)
+		}
+		*after = change.Seq
+		applied = true
+	}
+}
+
+// idleReader pushes the idle timer back on every read, heartbeats included
+type idleReader struct {
+	r       io.Reader
+	idle    *time.Timer
+	timeout time.Duration
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.idle.Reset(r.timeout)
+	}
+	return n, err
+}
@@ -0,0 +1,268 @@
+package changes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Hub is a Publisher serving the changes to the followers over HTTP, so a
+// standby instance keeps a warm copy of the trie of the primary, see
+// trie.Follow. A follower asks for the changes after the last one it applied
+// and receives them as JSON Lines, the missed ones from the backlog of the
+// hub and then the new ones as they are published. It is safe for concurrent use.
+type Hub struct {
+	backlogSize int
+	bufferSize  int
+	heartbeat   time.Duration
+
+	mutex sync.Mutex
+	// backlog is a ring of the last changes, replayed to reconnecting followers
+	backlog   []Change
+	published uint64
+	lastSeq   uint64
+	followers map[chan Change]struct{}
+	closed    bool
+}
+
+// HubOption configures optional Hub behavior
+type HubOption func(*Hub)
+
+// WithBacklog keeps the last n changes, 100000 by default. A follower asking
+// for older changes is answered 410 Gone and reloads the trie from the store.
+func WithBacklog(n int) HubOption {
+	return func(h *Hub) {
+		if n > 0 {
+			h.backlogSize = n
+		}
+	}
+}
+
+// WithFollowerBuffer buffers up to n changes per follower, 1000 by default. A
+// follower falling further behind is disconnected rather than slowing the
+// stream down, and catches up from the backlog when it reconnects.
+func WithFollowerBuffer(n int) HubOption {
+	return func(h *Hub) {
+		if n > 0 {
+			h.bufferSize = n
+		}
+	}
+}
+
+// WithHubHeartbeat sends an empty line to idle followers every interval, 15s
+// by default, so proxies keep the connections open and followers can tell a
+// quiet primary from a dead one
+func WithHubHeartbeat(interval time.Duration) HubOption {
+	return func(h *Hub) {
+		if interval > 0 {
+			h.heartbeat = interval
+		}
+	}
+}
+
+// NewHub creates a Hub, stop it with Close
+func NewHub(opts ...HubOption) *Hub {
+	h := &Hub{
+		backlogSize: 100000,
+		bufferSize:  1000,
+		heartbeat:   15 * time.Second,
+		followers:   make(map[chan Change]struct{}),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Publish adds the changes to the backlog and broadcasts them to the
+// followers. It never fails, the changes it already published are skipped
+// when a batch is retried.
+func (h *Hub) Publish(_ context.Context, changes []Change) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.closed {
+		return nil
+	}
+
+	for _, change := range changes {
+		if h.published > 0 && change.Seq <= h.lastSeq {
+			continue
+		}
+		if len(h.backlog) < h.backlogSize {
+			h.backlog = append(h.backlog, change)
+		} else {
+			h.backlog[int(h.published%uint64(h.backlogSize))] = change
+		}
+		h.published++
+		h.lastSeq = change.Seq
+
+		for followed := range h.followers {
+			select {
+			case followed <- change:
+			default:
+				// Too slow, it catches up from the backlog once reconnected
+				delete(h.followers, followed)
+				close(followed)
+			}
+		}
+	}
+	return nil
+}
+
+// LastSeq returns the sequence number of the last published change, 0 before the first one
+func (h *Hub) LastSeq() uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.lastSeq
+}
+
+// errGone tells a follower that the backlog does not hold the changes after its last one
+var errGone = errors.New("changes are no longer available")
+
+// subscribe registers a follower, returning the changes of the backlog after
+// after, errGone when some of them left the backlog or were never published
+// by this hub, e.g. after a restart of the primary, and false once closed
+func (h *Hub) subscribe(after uint64) (chan Change, []Change, bool, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.closed {
+		return nil, nil, false, nil
+	}
+
+	// The backlog is ordered by Seq once rotated to start at its oldest change
+	start := 0
+	if len(h.backlog) == h.backlogSize {
+		start = int(h.published % uint64(h.backlogSize))
+	}
+	if len(h.backlog) > 0 && (after+1 < h.backlog[start].Seq || after > h.lastSeq) {
+		return nil, nil, true, errGone
+	}
+	var missed []Change
+	for i := range h.backlog {
+		if change := h.backlog[(start+i)%len(h.backlog)]; change.Seq > after {
+			missed = append(missed, change)
+		}
+	}
+
+	followed := make(chan Change, h.bufferSize)
+	h.followers[followed] = struct{}{}
+	return followed, missed, true, nil
+}
+
+// unsubscribe removes a follower, unless the hub already did
+func (h *Hub) unsubscribe(followed chan Change) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, ok := h.followers[followed]; ok {
+		delete(h.followers, followed)
+		close(followed)
+	}
+}
+
+// hubHead is the answer to a request without the after parameter
+type hubHead struct {
+	LastSeq uint64 `json:"last_seq"`
+}
+
+// ServeHTTP answers the last sequence number as {"last_seq":N}, or with the
+// after=N query parameter streams the changes after N as JSON Lines until
+// the follower leaves or the hub is closed
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !r.URL.Query().Has("after") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hubHead{LastSeq: h.LastSeq()})
+		return
+	}
+	after, err := strconv.ParseUint(r.URL.Query().Get("after"), 10, 64)
+	if err != nil {
+		http.Error(w, "after must be a sequence number", http.StatusBadRequest)
+		return
+	}
+
+	followed, missed, ok, err := h.subscribe(after)
+	if !ok {
+		http.Error(w, "hub is closed", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	defer h.unsubscribe(followed)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	for _, change := range missed {
+		if err := enc.Encode(change); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case change, ok := <-followed:
+			if !ok {
+				return
+			}
+			err = enc.Encode(change)
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, "\n")
+		case <-r.Context().Done():
+			return
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Close ends the streams of the followers and refuses new ones, the HTTP
+// server can only shut down gracefully once they are ended
+func (h *Hub) Close() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.closed {
+		return
+	}
+	h.closed = true
+	for followed := range h.followers {
+		delete(h.followers, followed)
+		close(followed)
+	}
+}
+
+// Tee publishes every batch through each of publishers in turn. A failure
+// stops the batch before the next publishers and retries it from the first
+// one, so put those that never fail, such as a Hub, last.
+func Tee(publishers ...Publisher) Publisher {
+	return PublisherFunc(func(ctx context.Context, changes []Change) error {
+		for _, publisher := range publishers {
+			if err := publisher.Publish(ctx, changes); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,189 @@
+package changes
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// StatusError is returned by KafkaREST when the proxy answered a non 2xx status
+type StatusError struct {
+	StatusCode int
+	// Body is the start of the response body, for the logs
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("publisher answered %d: %s", e.StatusCode, e.Body)
+}
+
+// Temporary reports whether the batch may succeed later: on timeouts, rate
+// limits and server errors. Other client errors reject the batch for good.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// PublisherFunc adapts a function to the Publisher interface
+type PublisherFunc func(ctx context.Context, changes []Change) error
+
+// Publish calls f
+func (f PublisherFunc) Publish(ctx context.Context, changes []Change) error {
+	return f(ctx, changes)
+}
+
+// Channel publishes every change to ch, waiting for the consumer to receive
+// it, so a slow consumer slows the stream down instead of missing changes
+func Channel(ch chan<- Change) Publisher {
+	return PublisherFunc(func(ctx context.Context, changes []Change) error {
+		for _, change := range changes {
+			select {
+			case ch <- change:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+}
+
+// File appends the changes to a JSON Lines file, one change per line, and
+// syncs it after every batch
+type File struct {
+	mutex   sync.Mutex
+	file    *os.File
+	lastSeq uint64
+}
+
+// OpenFile opens or creates the file at path for appending, reading the
+// sequence number of its last change
+func OpenFile(path string) (*File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the change file: %w", err)
+	}
+
+	f := &File{file: file}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var change Change
+		// A line cut by a crash is skipped
+		if json.Unmarshal(scanner.Bytes(), &change) == nil {
+			f.lastSeq = change.Seq
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read the change file: %w", err)
+	}
+	return f, nil
+}
+
+// LastSeq returns the sequence number of the last change of the file, 0 when empty
+func (f *File) LastSeq() uint64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.lastSeq
+}
+
+// Publish appends the changes and syncs the file
+func (f *File) Publish(_ context.Context, changes []Change) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, change := range changes {
+		if err := enc.Encode(change); err != nil {
+			return err
+		}
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, err := f.file.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+	f.lastSeq = changes[len(changes)-1].Seq
+	return nil
+}
+
+// Close closes the file
+func (f *File) Close() error {
+	return f.file.Close()
+}
+
+// KafkaREST produces the changes to a Kafka topic through a Kafka REST Proxy
+// (the v2 API of Confluent's REST Proxy). Every change is a JSON record keyed
+// by its word, so the changes of a word land on the same partition in order.
+// Give the topic a single partition to keep the order of all the changes,
+// e.g. of a rename and the later changes of the new word.
+type KafkaREST struct {
+	url    string
+	client *http.Client
+}
+
+// kafkaRecords is the body of the v2 produce request
+type kafkaRecords struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+type kafkaRecord struct {
+	Key   string `json:"key"`
+	Value Change `json:"value"`
+}
+
+// NewKafkaREST produces to topic through the proxy at baseURL, e.g.
+// http://localhost:8082, client may be nil for http.DefaultClient
+func NewKafkaREST(baseURL, topic string, client *http.Client) (*KafkaREST, error) {
+	if topic == "" {
+		return nil, fmt.Errorf("kafka topic cannot be empty")
+	}
+	if _, err := url.Parse(baseURL); err != nil {
+		return nil, fmt.Errorf("invalid Kafka REST Proxy URL: %w", err)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &KafkaREST{url: strings.TrimSuffix(baseURL, "/") + "/topics/" + url.PathEscape(topic), client: client}, nil
+}
+
+// Publish produces the changes, failing unless the proxy answers 2xx
+func (k *KafkaREST) Publish(ctx context.Context, changes []Change) error {
+	body := kafkaRecords{Records: make([]kafkaRecord, len(changes))}
+	for i, change := range changes {
+		body.Records[i] = kafkaRecord{Key: change.Word, Value: change}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &StatusError{StatusCode: resp.StatusCode, Body: string(excerpt)}
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
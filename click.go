@@ -0,0 +1,90 @@
+package logsearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/afanwang/logsearch/metrics"
+	"github.com/afanwang/logsearch/store"
+	"github.com/afanwang/logsearch/tracing"
+)
+
+// WithClickBoost ranks the completions of SuggestForUser by how often their
+// searches lead to a click, as logged by LogSearchClick: weight times the
+// click-through rate of a completion over every user, from 0 to 1, is added
+// to its score, so with a weight of 1 a completion always clicked outranks
+// the most searched word of the user never clicked. A weight of 0 or less
+// disables it. The store must implement store.SearchClickStore.
+func WithClickBoost(weight float64) Option {
+	return func(sl *SearchLoggerV2) {
+		if weight > 0 {
+			sl.clickBoost = weight
+		}
+	}
+}
+
+// LogSearchClick records a click of the user on resultID, e.g. a product ID,
+// among the results of their search of word, marking the search as
+// converted. word runs through the ingest chain like a logged search, without
+// being logged, so it counts for the stored word of the same search. The
+// clicks feed the click-through rates of WithClickBoost. The store must
+// implement store.SearchClickStore.
+func (sl *SearchLoggerV2) LogSearchClick(ctx context.Context, userIdentifier, word, resultID string) (err error) {
+	ctx, span := sl.tracer.Start(ctx, "logsearch.LogSearchClick", tracing.KeyLogger.String(metrics.LoggerV2))
+	defer func() { tracing.End(span, err) }()
+
+	if _, ok := sl.db.(store.SearchClickStore); !ok {
+		return errors.New("store does not support clicks")
+	}
+	return sl.ingest(ctx, SearchEvent{UserIdentifier: userIdentifier, Query: word, click: true, resultID: resultID})
+}
+
+// clickStage ends the chain of a click, recording it for the stored form of the word
+func (sl *SearchLoggerV2) clickStage(ctx context.Context, event SearchEvent) error {
+	err := sl.db.(store.SearchClickStore).AddSearchClick(ctx, store.SearchClick{
+		UserIdentifier: event.UserIdentifier,
+		Word:           sl.stem(sl.synonyms.foldQuery(event.Query)),
+		ResultID:       event.resultID,
+		ClickedAt:      time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store click: %w", store.Classify(err))
+	}
+
+	sl.invalidateResults(ctx, event.UserIdentifier)
+	return nil
+}
+
+// boostClicks adds the click boost of the words to their scores
+func (sl *SearchLoggerV2) boostClicks(ctx context.Context, scores map[string]float64) error {
+	if sl.clickBoost == 0 || len(scores) == 0 {
+		return nil
+	}
+
+	words := make([]string, 0, len(scores))
+	for word := range scores {
+		words = append(words, word)
+	}
+	stats, err := sl.db.(store.SearchClickStore).ClickStats(ctx, words)
+	if err != nil {
+		return fmt.Errorf("failed to get click stats: %w", store.Classify(err))
+	}
+	for word, s := range stats {
+		scores[word] += sl.clickBoost * s.ClickThrough()
+	}
+	return nil
+}
+
+// forgetClicks deletes the clicks of a deleted user
+func (sl *SearchLoggerV2) forgetClicks(ctx context.Context, userIdentifier string) error {
+	clickStore, ok := sl.db.(store.SearchClickStore)
+	if !ok {
+		return nil
+	}
+	if _, err := clickStore.DeleteUserClicks(ctx, userIdentifier); err != nil {
+		return fmt.Errorf("failed to delete the clicks of %s: %w", userIdentifier, store.Classify(err))
+	}
+	return nil
+}
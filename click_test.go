@@ -0,0 +1,56 @@
+package logsearch
+
+import (
+	"context"
+	"testing"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchLoggerV2_ClickBoost(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDBV2()
+	logger, err := NewSearchLoggerV2WithDB(db, WithClickBoost(1))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	for _, word := range []string{"bus", "bus", "bus", "bug"} {
+		require.NoError(t, logger.LogSearchV2(ctx, "user_1", word))
+	}
+	require.NoError(t, logger.LogSearchV2(ctx, "user_2", "bug"))
+
+	suggestions, err := logger.SuggestForUser(ctx, "user_1", "bu", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bus", "bug"}, suggestions)
+
+	// Every search of "bug" led to a click, none of "bus"
+	require.NoError(t, logger.LogSearchClick(ctx, "user_1", "BUG ", "issue-1"))
+	require.NoError(t, logger.LogSearchClick(ctx, "user_2", "bug", "issue-2"))
+	stats, err := db.ClickStats(ctx, []string{"bug"})
+	require.NoError(t, err)
+	assert.Equal(t, store.ClickStats{Searches: 2, Clicks: 2}, stats["bug"], "The click counts for the normalized word")
+
+	suggestions, err = logger.SuggestForUser(ctx, "user_1", "bu", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bug", "bus"}, suggestions)
+
+	// Deleting a user deletes their clicks
+	_, err = logger.DeleteUserData(ctx, "user_2")
+	require.NoError(t, err)
+	stats, err = db.ClickStats(ctx, []string{"bug"})
+	require.NoError(t, err)
+	assert.Equal(t, store.ClickStats{Searches: 1, Clicks: 1}, stats["bug"])
+
+	assert.ErrorIs(t, logger.LogSearchClick(ctx, "", "bug", "issue-1"), ErrEmptyUser)
+	assert.ErrorIs(t, logger.LogSearchClick(ctx, "user_1", " ", "issue-1"), ErrEmptyWord)
+
+	counting := &countingStore{UserSearchStore: store.NewMockPostgresDBV2()}
+	_, err = NewSearchLoggerV2WithDB(counting, WithClickBoost(1))
+	assert.Error(t, err, "Stores without clicks should be rejected")
+	plain, err := NewSearchLoggerV2WithDB(counting)
+	require.NoError(t, err)
+	defer plain.Close()
+	assert.Error(t, plain.LogSearchClick(ctx, "user_1", "bug", "issue-1"))
+}
@@ -0,0 +1,73 @@
+package logsearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// delivery tells how a search arrived relative to the other searches of its user
+type delivery int
+
+const (
+	// inOrder is a search sent after every search of its user delivered so far, or without client clock
+	inOrder delivery = iota
+	// late is a search sent before a search of its user delivered earlier
+	late
+	// redelivered is a search carrying the sequence number of the latest search of its user
+	redelivered
+)
+
+// WithClientClock orders the searches of every user by the client clock of
+// SearchEvent, its ClientTime or Sequence, instead of their arrival. The
+// latest clock of every user is kept in the store, so a search sent before
+// one already delivered is recognized as late even after a restart. A late
+// search is judged against every stored word of its user, whatever the
+// session: it is ignored when it is a prefix of one, it never renames a
+// stored word as a typo or a corrected branch, it merges into the stored word
+// it is a typo of and is ignored when a stored sibling branch corrected it. A
+// search carrying the latest sequence number of its user is dropped as a
+// redelivery. Records keep the server time.
+func WithClientClock() Option {
+	return func(sl *SearchLoggerV2) {
+		sl.clientClock = true
+	}
+}
+
+// deliveryOf advances the clock of the user of event and tells how it arrived
+func (sl *SearchLoggerV2) deliveryOf(ctx context.Context, event SearchEvent) (delivery, error) {
+	clock := store.UserClock{At: event.ClientTime, Sequence: event.Sequence}
+	if !sl.clientClock || clock.IsZero() {
+		return inOrder, nil
+	}
+
+	clockStore, ok := sl.db.(store.UserClockStore)
+	if !ok {
+		return inOrder, errors.New("store does not support user clocks")
+	}
+	previous, err := clockStore.AdvanceUserClock(ctx, event.UserIdentifier, clock)
+	if err != nil {
+		return inOrder, fmt.Errorf("failed to advance the clock of %s: %w", event.UserIdentifier, store.Classify(err))
+	}
+
+	switch {
+	case clock.Sequence != 0 && clock.Sequence == previous.Sequence:
+		return redelivered, nil
+	case clock.Before(previous):
+		return late, nil
+	}
+	return inOrder, nil
+}
+
+// forgetClock deletes the clock of a user, a no-op without WithClientClock
+func (sl *SearchLoggerV2) forgetClock(ctx context.Context, userIdentifier string) error {
+	if !sl.clientClock {
+		return nil
+	}
+	if err := sl.db.(store.UserClockStore).DeleteUserClock(ctx, userIdentifier); err != nil {
+		return fmt.Errorf("failed to delete the clock of %s: %w", userIdentifier, store.Classify(err))
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package logsearch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchLoggerV2_ClientClock(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDBV2()
+	logger, err := NewSearchLoggerV2WithDB(db, WithClientClock(), WithSessionGap(time.Millisecond))
+	require.NoError(t, err)
+
+	log := func(logger *SearchLoggerV2, word string, seq uint64) {
+		require.NoError(t, logger.LogSearchEvent(ctx, SearchEvent{UserIdentifier: "user_1", Query: word, Sequence: seq}))
+	}
+	log(logger, "b", 1)
+	log(logger, "business", 4)
+
+	// The late prefixes are ignored although the session of "business" ended
+	time.Sleep(5 * time.Millisecond)
+	log(logger, "bus", 3)
+	log(logger, "bu", 2)
+	searches, err := logger.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"business"}, searches)
+
+	assert.Equal(t, 2, searchCount(t, logger, "user_1", "business"))
+
+	// A redelivery of the latest search is dropped
+	log(logger, "business", 4)
+	assert.Equal(t, 2, searchCount(t, logger, "user_1", "business"))
+	require.NoError(t, logger.Close())
+
+	// The clock survives a restart, a late word unrelated to the stored ones is stored
+	logger, err = NewSearchLoggerV2WithDB(db, WithClientClock())
+	require.NoError(t, err)
+	defer logger.Close()
+	log(logger, "bus", 3)
+	log(logger, "cat", 3)
+	searches, err = logger.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"business", "cat"}, searches)
+
+	// Client timestamps order the searches without sequence numbers
+	now := time.Now()
+	require.NoError(t, logger.LogSearchEvent(ctx, SearchEvent{UserIdentifier: "user_2", Query: "dog", ClientTime: now}))
+	require.NoError(t, logger.LogSearchEvent(ctx, SearchEvent{UserIdentifier: "user_2", Query: "do", ClientTime: now.Add(-time.Second)}))
+	searches, err = logger.GetUserSearches(ctx, "user_2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dog"}, searches)
+
+	// Deleting a user forgets their clock
+	_, err = logger.DeleteUserData(ctx, "user_2")
+	require.NoError(t, err)
+	previous, err := db.AdvanceUserClock(ctx, "user_2", store.UserClock{Sequence: 1})
+	require.NoError(t, err)
+	assert.True(t, previous.IsZero())
+}
+
+func TestSearchLoggerV2_ClientClockNeverRenames(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLoggerV2(WithClientClock(), WithTypoMerge(QWERTY), WithSessionGap(time.Hour), WithBranchDetection(3))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	log := func(word string, seq uint64) {
+		require.NoError(t, logger.LogSearchEvent(ctx, SearchEvent{UserIdentifier: "user_1", Query: word, Sequence: seq}))
+	}
+
+	// The late typo counts as the word typed after it instead of renaming it
+	log("business", 2)
+	log("businrss", 1)
+	assert.Equal(t, 2, searchCount(t, logger, "user_1", "business"))
+
+	// The late branch was corrected by the stored one
+	log("cabin", 5)
+	log("cabbage", 4)
+	searches, err := logger.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"business", "cabin"}, searches)
+
+	_, err = NewSearchLoggerV2WithDB(&countingStore{UserSearchStore: store.NewMockPostgresDBV2()}, WithClientClock())
+	assert.EqualError(t, err, "client clocks need a store that supports user clocks")
+}
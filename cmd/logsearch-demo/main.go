@@ -1,23 +1,30 @@
+// Command logsearch-demo shows the per-user deduplication of logsearch.SearchLoggerV2.
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
 	"time"
+
+	"github.com/afanwang/logsearch"
 )
 
 func main() {
 	fmt.Println("=== Search Logger V2 Demo ===")
+	ctx := context.Background()
 
 	// Create Version 2 logger
-	logger, err := NewSearchLoggerV2()
+	logger, err := logsearch.NewSearchLoggerV2()
 	if err != nil {
 		log.Fatal("Failed to create SearchLoggerV2:", err)
 	}
 	defer logger.Close()
+	logger.SetOutput(os.Stdout)
 
 	// Create user identifier generator for demo
-	idGen := NewUserIdentifierGenerator()
+	idGen := logsearch.NewUserIdentifierGenerator()
 
 	fmt.Println("\n=== Scenario 1: Logged-in user progressive typing in order ===")
 	user1Identifier := idGen.GenerateUserID()
@@ -25,7 +32,7 @@ func main() {
 	for i := 1; i <= len("Business"); i++ {
 		partial := "Business"[:i]
 		fmt.Printf("  Typing: '%s'", partial)
-		if err := logger.LogSearchV2(user1Identifier, partial); err != nil {
+		if err := logger.LogSearchV2(ctx, user1Identifier, partial); err != nil {
 			fmt.Printf(" - Error: %v\n", err)
 		} else {
 			fmt.Printf("\n")
@@ -39,7 +46,7 @@ func main() {
 	for i := 1; i <= len("Business"); i++ {
 		partial := "Business"[:i]
 		fmt.Printf("  Typing: '%s'", partial)
-		if err := logger.LogSearchV2(anon1Identifier, partial); err != nil {
+		if err := logger.LogSearchV2(ctx, anon1Identifier, partial); err != nil {
 			fmt.Printf(" - Error: %v\n", err)
 		} else {
 			fmt.Printf("\n")
@@ -53,7 +60,7 @@ func main() {
 	for i := 1; i <= len("business"); i++ {
 		partial := "Business"[:i]
 		fmt.Printf("  Typing: '%s'", partial)
-		if err := logger.LogSearchV2(user2Identifier, partial); err != nil {
+		if err := logger.LogSearchV2(ctx, user2Identifier, partial); err != nil {
 			fmt.Printf(" - Error: %v\n", err)
 		} else {
 			fmt.Printf("\n")
@@ -69,7 +76,7 @@ func main() {
 	for i := len("business"); i >= 1; i-- {
 		partial := "Business"[:i]
 		fmt.Printf("  Typing: '%s'", partial)
-		if err := logger.LogSearchV2(user3Identifier, partial); err != nil {
+		if err := logger.LogSearchV2(ctx, user3Identifier, partial); err != nil {
 			fmt.Printf(" - Error: %v\n", err)
 		} else {
 			fmt.Printf("\n")
@@ -78,10 +85,10 @@ func main() {
 	}
 
 	fmt.Println("\n=== Final results: per-user deduplication ===")
-	displayFinalResults(logger, user1Identifier, anon1Identifier, user2Identifier, user3Identifier)
+	displayFinalResults(ctx, logger, user1Identifier, anon1Identifier, user2Identifier, user3Identifier)
 }
 
-func displayFinalResults(logger *SearchLoggerV2, user1Identifier, anon1Identifier, user2Identifier, user3Identifier string) {
+func displayFinalResults(ctx context.Context, logger *logsearch.SearchLoggerV2, user1Identifier, anon1Identifier, user2Identifier, user3Identifier string) {
 	users := []struct {
 		identifier string
 		name       string
@@ -95,7 +102,7 @@ func displayFinalResults(logger *SearchLoggerV2, user1Identifier, anon1Identifie
 	totalRecords := 0
 	fmt.Println("Final search results:")
 	for _, user := range users {
-		searches, err := logger.GetUserSearches(user.identifier)
+		searches, err := logger.GetUserSearches(ctx, user.identifier)
 		if err != nil {
 			log.Printf("Error getting searches for %s: %v", user.name, err)
 			continue
@@ -0,0 +1,52 @@
+// Command logsearch-router serves the search API in front of several
+// logsearch-server shards, forwarding the requests of every user to the shard
+// owning them, e.g.
+//
+//	logsearch-router -shard-map shards.yaml -addr :8080
+//
+// See shard.Map for the format of the shard map.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/afanwang/logsearch/server"
+	"github.com/afanwang/logsearch/shard"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to serve the search API on")
+	mapPath := flag.String("shard-map", "", "YAML file of the shards, and of the previous shards while users are moved after a change")
+	timeout := flag.Duration("timeout", 10*time.Second, "how long the requests fanned out to the shards and the moves of users may take")
+	flag.Parse()
+
+	if *mapPath == "" {
+		log.Fatal("-shard-map is required")
+	}
+	m, err := shard.LoadMap(*mapPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	router, err := shard.NewRouter(m, &http.Client{Timeout: *timeout})
+	if err != nil {
+		log.Fatal("Failed to create the shard router:", err)
+	}
+	if len(m.PreviousShards) > 0 {
+		log.Printf("Moving the users of the previous shards on their next request")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Routing %d shards on %s", len(m.Shards), *addr)
+	if err := server.New(*addr, router).Run(ctx); err != nil {
+		log.Printf("Server error: %v", err)
+	}
+}
@@ -0,0 +1,810 @@
+// Command logsearch-server serves the search API: per-user logging with
+// logsearch.SearchLoggerV2 and autocomplete from the trie.SearchLogger.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"golang.org/x/text/language"
+	"google.golang.org/grpc"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/admin"
+	"github.com/afanwang/logsearch/anomaly"
+	"github.com/afanwang/logsearch/cache"
+	"github.com/afanwang/logsearch/changes"
+	"github.com/afanwang/logsearch/config"
+	"github.com/afanwang/logsearch/denylist"
+	"github.com/afanwang/logsearch/feed"
+	"github.com/afanwang/logsearch/grpcserver"
+	"github.com/afanwang/logsearch/ingest"
+	"github.com/afanwang/logsearch/leader"
+	"github.com/afanwang/logsearch/metrics"
+	"github.com/afanwang/logsearch/normalize"
+	"github.com/afanwang/logsearch/ops"
+	"github.com/afanwang/logsearch/scrub"
+	"github.com/afanwang/logsearch/server"
+	"github.com/afanwang/logsearch/sink"
+	"github.com/afanwang/logsearch/store"
+	"github.com/afanwang/logsearch/tracing"
+	"github.com/afanwang/logsearch/trie"
+	"github.com/afanwang/logsearch/wal"
+	"github.com/afanwang/logsearch/webhook"
+)
+
+// searchLogger logs every search per user and into the global trie used for suggestions
+type searchLogger struct {
+	*logsearch.SearchLoggerV2
+	trie *trie.SearchLogger
+}
+
+func (l *searchLogger) LogSearchV2(ctx context.Context, userIdentifier, word string) error {
+	if err := l.SearchLoggerV2.LogSearchV2(ctx, userIdentifier, word); err != nil {
+		return err
+	}
+	return l.trie.LogSearch(ctx, word)
+}
+
+// LogSearchEvent logs the event into the trie, tagged with its category, and per user.
+// The trie goes first so an unknown category is rejected before anything is logged.
+func (l *searchLogger) LogSearchEvent(ctx context.Context, event logsearch.SearchEvent) error {
+	if err := l.trie.LogSearchEvent(ctx, event); err != nil {
+		return err
+	}
+	return l.SearchLoggerV2.LogSearchEvent(ctx, event)
+}
+
+// LogSearchBatch logs the events of a user per user and every event into the trie
+func (l *searchLogger) LogSearchBatch(ctx context.Context, events []logsearch.SearchEvent) error {
+	var userEvents []logsearch.SearchEvent
+	for _, event := range events {
+		if event.UserIdentifier != "" {
+			userEvents = append(userEvents, event)
+		}
+	}
+	return errors.Join(l.SearchLoggerV2.LogSearchBatch(ctx, userEvents), l.trie.LogSearchBatch(ctx, events))
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to serve the search API on")
+	timeout := flag.Duration("timeout", 2*time.Second, "idle time before a word in the trie is considered complete")
+	pruning := flag.Bool("pruning", false, "drop the stored words from the trie, which then only holds the recent searches")
+	snapshotCompaction := flag.Bool("snapshot-compaction", false, "build the -snapshot-interval copies as word graphs sharing the common endings of the words, to cut their memory")
+	displayForms := flag.Bool("display-forms", false, "answer the trie suggestions and top searches with the casing the users type most, e.g. iPhone, rather than lowercase")
+	snapshotInterval := flag.Duration("snapshot-interval", 0, "answer the trie suggestions from a copy of the trie rebuilt this often, without waiting for the searches being logged, e.g. 1s, 0 reads the trie itself")
+	nodeBudget := flag.Int("node-budget", 0, "max nodes of the trie, evicting the least recently searched stored words, 0 disables the cap")
+	categories := flag.String("categories", "", "comma separated categories the searches may be tagged with, e.g. products,help-center, to suggest per search box")
+	userCache := flag.Int("user-cache", 100000, "max words cached in memory for per-user dedup, 0 disables the cache")
+	userQuota := flag.Int("user-quota", 0, "max searches stored per user, evicting the least recently updated, 0 disables the cap")
+	userLocks := flag.Bool("user-locks", false, "lock every user in the store while consolidating their searches, for replicas sharing a postgres store, needs -user-cache 0")
+	atomicConsolidation := flag.Bool("atomic-consolidation", false, "let the store decide every search in one atomic write, needs -user-cache 0")
+	drainTimeout := flag.Duration("drain-timeout", 10*time.Second, "how long shutdown may take to store the pending words of the trie, 0 waits as long as needed")
+	drainMinLength := flag.Int("drain-min-length", 0, "pending trie words shorter than this are dropped on shutdown")
+	grpcAddr := flag.String("grpc-addr", "", "address to serve the gRPC API on, disabled when empty")
+	maxWordBytes := flag.Int("max-word-bytes", logsearch.MaxWordBytes, "searches longer than this many bytes are rejected")
+	maxWordRunes := flag.Int("max-word-runes", 0, "searches longer than this many characters are rejected, 0 disables the limit")
+	unicodeNormalize := flag.Bool("normalize", false, "apply NFKC normalization and language aware lowercasing to searches")
+	foldDiacritics := flag.Bool("fold-diacritics", false, "strip diacritics from searches so café and cafe are the same word, implies -normalize")
+	lang := flag.String("lang", "und", "BCP 47 language of the lowercasing rules of -normalize, e.g. tr")
+	emojiPolicy := flag.String("emoji", "keep", "how searches handle emoji and pictographic symbols: keep, strip or shortcode to store them as :shortcode:")
+	transliterate := flag.String("transliterate", "", "comma separated scripts whose letters are transliterated to Latin so москва and moskva are the same word: cyrillic, greek, arabic")
+	sessionGap := flag.Duration("session-gap", 0, "only consolidate per-user searches typed within this inactivity gap of each other, e.g. 30m, 0 consolidates across all time")
+	branchMinShared := flag.Int("branch-min-shared", 0, "keep only the last of two diverging searches sharing this many leading characters, e.g. busin corrected to busia, 0 disables it, the per-user logger needs -session-gap")
+	finalizeIdle := flag.Duration("finalize-idle", 0, "hold each per-user search in memory until its user has not extended it for this long, e.g. 2s, 0 stores every keystroke unless -stem")
+	finalizeMaxPending := flag.Duration("finalize-max-pending", 0, "store a per-user search held this long since its user started typing it, even while extended, e.g. 30s, 0 disables it")
+	finalizeWordBoundaries := flag.Bool("finalize-word-boundaries", false, "store the held per-user searches as soon as their user types a search replacing whole words of them")
+	stem := flag.String("stem", "", "store per-user searches by their Snowball stem in this language, e.g. en, once finalized, idle for -timeout without -finalize-idle or -finalize-max-pending, disabled when empty")
+	sqlitePath := flag.String("sqlite", "", "SQLite database file persisting the searches, in-memory mock stores when empty")
+	retention := flag.Duration("retention", 0, "delete stored searches not repeated within this window, e.g. 2160h for 90 days, 0 keeps them forever")
+	retentionInterval := flag.Duration("retention-interval", time.Hour, "how often expired searches are purged")
+	rollupInterval := flag.Duration("rollup-interval", 0, "how often the per-user searches are summarized into the hourly and daily rollup tables, 0 disables rollups")
+	rollupDelay := flag.Duration("rollup-delay", 5*time.Minute, "how long after an hour or a day ends it is rolled up, must exceed the flush interval and -timeout")
+	heavyHitters := flag.Int("heavy-hitters", 0, "answer all-time top searches from a count-min sketch tracking this many words, 0 queries the store")
+	audience := flag.Bool("audience", false, "count the distinct users of every word so /search/top?rank=users ranks by audience size")
+	synonymsEnabled := flag.Bool("synonyms", false, "manage aliases of the per-user searches on /search/synonyms, e.g. tv of television, expanded by the personalized suggestions")
+	synonymsFold := flag.Bool("synonyms-fold", false, "store the per-user searches with their aliases replaced by their canonical words, implies -synonyms")
+	zeroResultStreak := flag.Int("zero-result-streak", 0, "list a word on /search/zero-results once this many submitted searches of it in a row returned no result, e.g. 3, 0 disables it")
+	clickBoost := flag.Float64("click-boost", 0, "rank the personalized suggestions higher the more their searches lead to a click on /search/click, 1 letting a word always clicked outrank the favorite word of the user, 0 disables it")
+	regions := flag.Bool("regions", false, "keep the country and locale of the logged searches so /search/top?country=FR&locale=fr-FR ranks the words searched there")
+	abuseMaxRate := flag.Float64("abuse-max-rate", 0, "quarantine the searches of a user logging more than this many per second over 10 seconds, e.g. 20, 0 disables the check")
+	abuseMaxUniqueWords := flag.Int("abuse-max-unique-words", 0, "quarantine the searches of a user logging more than this many distinct words a minute, typing a word counting once, e.g. 30, 0 disables the check")
+	abuseMaxEntropy := flag.Float64("abuse-max-entropy", 0, "quarantine the searches of at least -abuse-min-entropy-length characters whose entropy exceeds this many bits per character, e.g. 3.5, 0 disables the check")
+	abuseMinEntropyLength := flag.Int("abuse-min-entropy-length", 16, "shortest search judged by -abuse-max-entropy")
+	abuseQuarantineFor := flag.Duration("abuse-quarantine-for", time.Hour, "how long all the searches of a user flagged by -abuse-max-rate or -abuse-max-unique-words are quarantined")
+	coalesceWindow := flag.Duration("coalesce-window", 0, "collapse concurrent duplicate per-user searches into one store write and drop the retries of a search by Idempotency-Key within this window, e.g. 10m, 0 disables both")
+	resultCacheTTL := flag.Duration("result-cache-ttl", 0, "answer the per-user searches, personal suggestions and top searches from a cache for this long, each write dropping the cached reads of its user, e.g. 30s, 0 disables the cache")
+	resultCacheSize := flag.Int("result-cache-size", 100000, "max reads held by the in-process cache of -result-cache-ttl")
+	resultCacheRedis := flag.String("result-cache-redis", "", "host:port of a Redis server holding the cache of -result-cache-ttl, shared by every instance, in process when empty")
+	clientClock := flag.Bool("client-clock", false, "order each user's searches by the client_time and sequence of the requests, so late prefixes never overwrite or resurrect a stored word")
+	walDir := flag.String("wal-dir", "", "directory of the write-ahead log replaying the pending trie words after a crash, encrypted with the encryption.key setting if any, disabled when empty")
+	minLength := flag.Int("min-length", 0, "searches shorter than this are never stored, 0 disables the filter")
+	stopWords := flag.String("stop-words", "", "comma separated words never stored, e.g. the,and")
+	blocklist := flag.String("blocklist", "", "regular expression of the words never stored, e.g. '^\\d+$'")
+	denylistPath := flag.String("denylist", "", "file of terms, one per line, whose searches are never stored, disabled when empty")
+	denylistMask := flag.Bool("denylist-mask", false, "store the searches of -denylist with the terms masked by '*' instead of dropping them")
+	scrubMode := flag.String("scrub", "", "drop the searches with emails, phone, social security or card numbers, or redact them with redact, disabled when empty")
+	denylistQuarantine := flag.Bool("denylist-quarantine", false, "write the searches of -denylist to the quarantine of the per-user logger for review on /search/quarantine instead of dropping them")
+	denylistWholeWords := flag.Bool("denylist-whole-words", false, "only match the terms of -denylist that are not part of a longer word")
+	trendingSpan := flag.Duration("trending-span", 0, "longest window ranked by /search/trending, e.g. 24h, 0 disables trending")
+	trendingBucket := flag.Duration("trending-bucket", 5*time.Minute, "granularity of the trending windows")
+	cjkBigrams := flag.Bool("cjk-bigrams", false, "keep a lone Chinese, Japanese or Korean character apart from the longer trie words it starts")
+	decayHalfLife := flag.Duration("decay-half-life", 0, "rank trie suggestions by search counts halving every this long, e.g. 168h, 0 ranks them alphabetically")
+	knownWords := flag.Int("known-words", 0, "let repeated trie searches of stored words skip the write lock, a Bloom filter being sized for this many stored words, 0 disables it")
+	typoMerge := flag.Bool("typo-merge", false, "merge a search into the user's stored word differing by one adjacent QWERTY key")
+	substringSearch := flag.Bool("substring-search", false, "index the stored words by trigrams and serve /search/terms?contains=")
+	spellDistance := flag.Int("spell-distance", 0, "serve /search/didyoumean with corrections within this many edits, e.g. 2, 0 disables it")
+	userWeight := flag.Float64("user-weight", 0.5, "share of a user's own searches in /search/suggest?user_id=, between 0 and 1, the rest comes from the global trie")
+	queueSize := flag.Int("queue-size", 0, "queue this many searches for the trie workers instead of logging them on the request goroutine, 0 disables the queue")
+	queueWorkers := flag.Int("queue-workers", 4, "goroutines logging the queued searches into the trie")
+	sinkURL := flag.String("sink-url", "", "URL receiving the finalized words as JSON Lines batches, disabled when empty")
+	sinkClickHouseTable := flag.String("sink-clickhouse-table", "", "insert the finalized words into this ClickHouse table, -sink-url being the ClickHouse HTTP interface")
+	changesFile := flag.String("changes-file", "", "JSON Lines file receiving the inserts, updates and deletes of the stored trie words in order, disabled when empty")
+	changesKafkaREST := flag.String("changes-kafka-rest", "", "URL of a Kafka REST Proxy producing the changes of -changes-file to -changes-kafka-topic instead, e.g. http://localhost:8082")
+	changesKafkaTopic := flag.String("changes-kafka-topic", "logsearch-changes", "Kafka topic of the changes of -changes-kafka-rest")
+	changesHub := flag.Bool("changes-hub", false, "serve the changes of the stored trie words on /search/changes to standby instances started with -follow")
+	flushLeaderPostgres := flag.Bool("flush-leader-postgres", false, "run each trie flush cycle under an advisory lock of the postgres store, for replicas sharing it")
+	flushLeaderRedis := flag.String("flush-leader-redis", "", "host:port of a Redis server leasing the trie flush cycles to one replica at a time instead, disabled when empty")
+	flushLeaderTTL := flag.Duration("flush-leader-ttl", time.Minute, "lease of -flush-leader-redis, longer than any flush cycle")
+	followURL := flag.String("follow", "", "URL of the /search/changes of a primary sharing the store, keeping the trie warm as a standby until SIGUSR1 promotes it, disabled when empty")
+	queueDrop := flag.Bool("queue-drop", false, "reject searches with 503 while the queue is full instead of waiting for room")
+	feedEnabled := flag.Bool("feed", false, "stream the finalized words as Server-Sent Events on /search/feed")
+	webhooksPath := flag.String("webhooks", "", "JSON file of the webhook endpoints notified of new words, e.g. [{\"url\": \"https://...\", \"secret\": \"...\", \"events\": [\"term.new\"]}], disabled when empty")
+	webhookThresholds := flag.String("webhook-thresholds", "", "comma separated search counts notified to -webhooks when a word reaches them, e.g. 100,1000")
+	spikeWindow := flag.Duration("webhook-spike-window", 0, "notify -webhooks of the words searched in this window -webhook-spike-sigmas standard deviations more than in the windows before, e.g. 5m, 0 disables it")
+	spikeBaseline := flag.Int("webhook-spike-baseline", 12, "how many windows of -webhook-spike-window before the current one make the baseline of a word")
+	spikeSigmas := flag.Float64("webhook-spike-sigmas", 3, "how many standard deviations above its baseline the searches of a word spike")
+	spikeMinCount := flag.Int64("webhook-spike-min-count", 10, "fewest searches of a word in a -webhook-spike-window notified as a spike")
+	tailFile := flag.String("tail-file", "", "log file of another service whose searches are followed and logged, rotation included, disabled when empty")
+	tailRegex := flag.String("tail-regex", "", "regular expression of the -tail-file lines, its named group query capturing the search and user the optional user")
+	tailUnescape := flag.Bool("tail-unescape", false, "URL-decode the groups of -tail-regex, e.g. captured from access log request lines")
+	tailJSONQuery := flag.String("tail-json-query", "query", "dotted field of the search in JSON -tail-file lines, used without -tail-regex")
+	tailJSONUser := flag.String("tail-json-user", "", "dotted field of the user in JSON -tail-file lines, the searches only feed the trie without it")
+	tailFromStart := flag.Bool("tail-from-start", false, "also log the lines already in -tail-file on startup")
+	heartbeatMaxAge := flag.Duration("heartbeat-max-age", time.Minute, "/healthz fails once a flushing routine of the loggers did not complete a round for this long, must exceed -timeout")
+	queueReadyRatio := flag.Float64("queue-ready-ratio", 0.9, "/readyz fails while the queue of -queue-size is filled beyond this share of its capacity")
+	healthTimeout := flag.Duration("health-timeout", 2*time.Second, "how long /healthz and /readyz wait for their checks, e.g. the store ping")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "OTLP/HTTP URL receiving the traces of the searches, flushes and store calls, e.g. http://localhost:4318, disabled when empty")
+	traceRatio := flag.Float64("trace-ratio", 1, "share of the requests traced with -otlp-endpoint, the callers' sampling decision is kept")
+	opsAddr := flag.String("ops-addr", "", "private address serving pprof on /debug/pprof/ and the trie internals on /debug/stats, e.g. localhost:6060, disabled when empty")
+	adminEnabled := flag.Bool("admin", false, "serve the moderators' dashboard on /admin/, without authentication like the API")
+	configPath := flag.String("config", "", "YAML file of the settings, overridden by the LOGSEARCH_* environment variables and then by the flags set on the command line")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Only the flags set on the command line override the file and the environment
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.Server.Addr = *addr
+		case "grpc-addr":
+			cfg.Server.GRPCAddr = *grpcAddr
+		case "ops-addr":
+			cfg.Server.OpsAddr = *opsAddr
+		case "sqlite":
+			cfg.Store = config.Store{Driver: config.DriverSQLite, DSN: *sqlitePath}
+		case "timeout":
+			cfg.Timeout = *timeout
+		case "pruning":
+			cfg.Pruning = *pruning
+		case "display-forms":
+			cfg.DisplayForms = *displayForms
+		case "snapshot-interval":
+			cfg.SnapshotInterval = *snapshotInterval
+		case "snapshot-compaction":
+			cfg.SnapshotCompaction = *snapshotCompaction
+		case "node-budget":
+			cfg.NodeBudget = *nodeBudget
+		case "categories":
+			cfg.Categories = strings.Split(*categories, ",")
+		case "user-cache":
+			cfg.UserCache = *userCache
+		case "user-quota":
+			cfg.UserQuota = *userQuota
+		case "user-locks":
+			cfg.UserLocks = *userLocks
+		case "atomic-consolidation":
+			cfg.AtomicConsolidation = *atomicConsolidation
+		case "user-weight":
+			cfg.UserWeight = *userWeight
+		case "drain-timeout":
+			cfg.Drain.Timeout = *drainTimeout
+		case "drain-min-length":
+			cfg.Drain.MinLength = *drainMinLength
+		case "max-word-bytes":
+			cfg.Limits.MaxWordBytes = *maxWordBytes
+		case "max-word-runes":
+			cfg.Limits.MaxWordRunes = *maxWordRunes
+		case "min-length":
+			cfg.Filters.MinLength = *minLength
+		case "stop-words":
+			cfg.Filters.StopWords = strings.Split(*stopWords, ",")
+		case "blocklist":
+			cfg.Filters.Blocklist = *blocklist
+		case "retention":
+			cfg.Retention.Window = *retention
+		case "retention-interval":
+			cfg.Retention.Interval = *retentionInterval
+		}
+	})
+	if err := cfg.Validate(); err != nil {
+		log.Fatal("Invalid configuration:\n", err)
+	}
+
+	m := metrics.New()
+	userOpts := []logsearch.Option{logsearch.WithMetrics(m)}
+	trieOpts := []trie.Option{trie.WithMetrics(m)}
+
+	var tracer *tracing.Tracer
+	if *otlpEndpoint != "" {
+		exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(*otlpEndpoint))
+		if err != nil {
+			log.Fatal("Failed to create the OTLP exporter:", err)
+		}
+		// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+		res, err := resource.New(context.Background(), resource.WithTelemetrySDK(),
+			resource.WithAttributes(attribute.String("service.name", "logsearch-server")), resource.WithFromEnv())
+		if err != nil {
+			log.Fatal("Failed to describe the traced resource:", err)
+		}
+		tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res),
+			sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(*traceRatio))))
+		// Shut down last, once the loggers recorded the spans of their final flush
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := tp.Shutdown(ctx); err != nil {
+				log.Printf("Error exporting the last traces: %v", err)
+			}
+		}()
+		tracer = tracing.New(tp)
+		userOpts = append(userOpts, logsearch.WithTracing(tracer))
+		trieOpts = append(trieOpts, trie.WithTracing(tracer))
+	}
+
+	n := normalize.Default
+	if *unicodeNormalize || *foldDiacritics {
+		tag, err := language.Parse(*lang)
+		if err != nil {
+			log.Fatal("Invalid -lang:", err)
+		}
+		normOpts := []normalize.Option{normalize.WithLanguage(tag)}
+		if *foldDiacritics {
+			normOpts = append(normOpts, normalize.WithDiacriticFolding())
+		}
+		n = normalize.NewUnicode(normOpts...)
+	}
+	if *transliterate != "" {
+		var scripts []normalize.Script
+		for _, name := range strings.Split(*transliterate, ",") {
+			script, err := normalize.ParseScript(name)
+			if err != nil {
+				log.Fatal("Invalid -transliterate:", err)
+			}
+			scripts = append(scripts, script)
+		}
+		n = normalize.Transliterating(n, normalize.NewTransliterator(scripts...))
+	}
+	policy, err := normalize.ParseEmojiPolicy(*emojiPolicy)
+	if err != nil {
+		log.Fatal("Invalid -emoji:", err)
+	}
+	if policy != normalize.EmojiKeep {
+		n = normalize.EmojiFolding(n, policy)
+	}
+
+	if *sessionGap > 0 {
+		userOpts = append(userOpts, logsearch.WithSessionGap(*sessionGap))
+	}
+
+	if *branchMinShared > 0 {
+		userOpts = append(userOpts, logsearch.WithBranchDetection(*branchMinShared))
+		trieOpts = append(trieOpts, trie.WithBranchDetection(*branchMinShared))
+	}
+
+	if *stem != "" {
+		tag, err := language.Parse(*stem)
+		if err != nil {
+			log.Fatal("Invalid -stem:", err)
+		}
+		stemmer, err := normalize.NewSnowball(tag)
+		if err != nil {
+			log.Fatal("Invalid -stem:", err)
+		}
+		userOpts = append(userOpts, logsearch.WithStemmer(stemmer))
+		// Stemming needs the searches held until finalized
+		if *finalizeIdle <= 0 && *finalizeMaxPending <= 0 {
+			*finalizeIdle = cfg.Timeout
+		}
+	}
+
+	if *finalizeIdle > 0 || *finalizeMaxPending > 0 {
+		userOpts = append(userOpts, logsearch.WithFinalizePolicy(logsearch.FinalizePolicy{
+			Idle:           *finalizeIdle,
+			MaxPending:     *finalizeMaxPending,
+			WordBoundaries: *finalizeWordBoundaries,
+		}))
+	}
+
+	if *rollupInterval > 0 {
+		userOpts = append(userOpts, logsearch.WithRollups(*rollupInterval, *rollupDelay))
+	}
+
+	if *heavyHitters > 0 {
+		userOpts = append(userOpts, logsearch.WithHeavyHitters(*heavyHitters, 0.001, 0.01))
+		trieOpts = append(trieOpts, trie.WithHeavyHitters(*heavyHitters, 0.001, 0.01))
+	}
+	if *clientClock {
+		userOpts = append(userOpts, logsearch.WithClientClock())
+	}
+
+	if *coalesceWindow > 0 {
+		userOpts = append(userOpts, logsearch.WithCoalescing(*coalesceWindow))
+	}
+
+	if *resultCacheTTL > 0 {
+		var results cache.Cache = cache.NewMemory(*resultCacheSize)
+		if *resultCacheRedis != "" {
+			shared, err := cache.NewRedis(store.RedisConfig{Addr: *resultCacheRedis})
+			if err != nil {
+				log.Fatal("Failed to connect to -result-cache-redis:", err)
+			}
+			defer shared.Close()
+			results = shared
+		}
+		userOpts = append(userOpts, logsearch.WithResultCache(results, *resultCacheTTL))
+	}
+
+	if *audience {
+		userOpts = append(userOpts, logsearch.WithAudience())
+	}
+
+	if *regions {
+		userOpts = append(userOpts, logsearch.WithRegions())
+	}
+
+	if *clickBoost > 0 {
+		userOpts = append(userOpts, logsearch.WithClickBoost(*clickBoost))
+	}
+
+	if *synonymsEnabled || *synonymsFold {
+		userOpts = append(userOpts, logsearch.WithSynonyms(*synonymsFold))
+	}
+
+	if *zeroResultStreak > 0 {
+		userOpts = append(userOpts, logsearch.WithZeroResultTracking(*zeroResultStreak))
+	}
+
+	if *abuseMaxRate > 0 || *abuseMaxUniqueWords > 0 || *abuseMaxEntropy > 0 {
+		userOpts = append(userOpts, logsearch.WithAbuseDetection(logsearch.AbuseLimits{
+			MaxRate:          *abuseMaxRate,
+			MaxUniqueWords:   *abuseMaxUniqueWords,
+			MaxEntropy:       *abuseMaxEntropy,
+			MinEntropyLength: *abuseMinEntropyLength,
+			QuarantineFor:    *abuseQuarantineFor,
+		}))
+	}
+
+	if *trendingSpan > 0 && *trendingBucket > 0 {
+		buckets := int((*trendingSpan + *trendingBucket - 1) / *trendingBucket)
+		userOpts = append(userOpts, logsearch.WithTrending(*trendingBucket, buckets))
+	}
+
+	if *walDir != "" {
+		cipher, err := cfg.Encryption.Cipher()
+		if err != nil {
+			log.Fatal("Invalid encryption key:", err)
+		}
+		l, err := wal.Open(*walDir, wal.WithCipher(cipher))
+		if err != nil {
+			log.Fatal("Failed to open write-ahead log:", err)
+		}
+		trieOpts = append(trieOpts, trie.WithWAL(l))
+	}
+
+	if *typoMerge {
+		userOpts = append(userOpts, logsearch.WithTypoMerge(logsearch.QWERTY))
+	}
+
+	if *substringSearch {
+		userOpts = append(userOpts, logsearch.WithSubstringSearch())
+	}
+	if *spellDistance > 0 {
+		userOpts = append(userOpts, logsearch.WithSpellCorrection(*spellDistance))
+	}
+
+	if *cjkBigrams {
+		trieOpts = append(trieOpts, trie.WithCJKBigrams())
+	}
+
+	if *decayHalfLife > 0 {
+		trieOpts = append(trieOpts, trie.WithDecay(*decayHalfLife))
+	}
+
+	if *knownWords > 0 {
+		trieOpts = append(trieOpts, trie.WithKnownWords(*knownWords, 0.01))
+	}
+
+	if *queueSize > 0 {
+		policy := trie.Block
+		if *queueDrop {
+			policy = trie.Drop
+		}
+		trieOpts = append(trieOpts, trie.WithAsync(*queueSize, *queueWorkers, policy))
+	}
+
+	// The filters of the settings are added by config.NewFromConfig
+	var filters []logsearch.Filter
+	if *denylistPath != "" {
+		data, err := os.ReadFile(*denylistPath)
+		if err != nil {
+			log.Fatal("Failed to read -denylist:", err)
+		}
+		denyOpts := []denylist.Option{denylist.OnMatch(m.DeniedTerm)}
+		if *denylistWholeWords {
+			denyOpts = append(denyOpts, denylist.WholeWords())
+		}
+		d := denylist.New(strings.Split(string(data), "\n"), denyOpts...)
+		switch {
+		case *denylistMask:
+			n = d.Masking(n)
+		case *denylistQuarantine:
+			// The trie has no quarantine, it drops them
+			userOpts = append(userOpts, logsearch.WithQuarantineFilters(d))
+			trieOpts = append(trieOpts, trie.WithFilters(d))
+		default:
+			filters = append(filters, d)
+		}
+	}
+	if *sinkURL != "" {
+		var transport sink.Transport = sink.NewHTTPTransport(*sinkURL, nil)
+		if *sinkClickHouseTable != "" {
+			clickHouse, err := sink.NewClickHouseTransport(*sinkURL, *sinkClickHouseTable, nil)
+			if err != nil {
+				log.Fatal("Invalid -sink-url:", err)
+			}
+			transport = clickHouse
+		}
+		s := sink.New(transport)
+		// Deferred before the loggers are created, so it runs after they flushed their last words
+		defer func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			if cfg.Drain.Timeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, cfg.Drain.Timeout)
+			}
+			defer cancel()
+			if err := s.Close(ctx); err != nil {
+				log.Printf("Error closing the sink: %v", err)
+			}
+		}()
+		userOpts = append(userOpts, logsearch.WithWordFinalizedHook(s.Hook()))
+		trieOpts = append(trieOpts, trie.WithWordFinalizedHook(s.Hook()))
+	}
+
+	var hub *changes.Hub
+	if *changesFile != "" || *changesKafkaREST != "" || *changesHub {
+		var publisher changes.Publisher
+		var streamOpts []changes.Option
+		switch {
+		case *changesFile != "" && *changesKafkaREST != "":
+			log.Fatal("-changes-file and -changes-kafka-rest are exclusive")
+		case *changesFile == "" && *changesKafkaREST == "":
+		case *changesFile != "":
+			f, err := changes.OpenFile(*changesFile)
+			if err != nil {
+				log.Fatal("Invalid -changes-file:", err)
+			}
+			defer f.Close()
+			publisher = f
+			streamOpts = append(streamOpts, changes.WithFirstSeq(f.LastSeq()+1))
+		default:
+			kafka, err := changes.NewKafkaREST(*changesKafkaREST, *changesKafkaTopic, nil)
+			if err != nil {
+				log.Fatal("Invalid -changes-kafka-rest:", err)
+			}
+			publisher = kafka
+		}
+		if *changesHub {
+			hub = changes.NewHub()
+			// The hub never fails, it only receives the changes the other publisher accepted
+			if publisher != nil {
+				publisher = changes.Tee(publisher, hub)
+			} else {
+				publisher = hub
+			}
+		}
+		stream := changes.New(publisher, streamOpts...)
+		// Deferred before the loggers are created, so it runs after they wrote their last words
+		defer func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			if cfg.Drain.Timeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, cfg.Drain.Timeout)
+			}
+			defer cancel()
+			if err := stream.Close(ctx); err != nil {
+				log.Printf("Error closing the change stream: %v", err)
+			}
+		}()
+		trieOpts = append(trieOpts, trie.WithChangeStream(stream))
+	}
+
+	switch {
+	case *flushLeaderPostgres && *flushLeaderRedis != "":
+		log.Fatal("-flush-leader-postgres and -flush-leader-redis are exclusive")
+	case *flushLeaderPostgres:
+		if cfg.Store.Driver != config.DriverPostgres {
+			log.Fatal("-flush-leader-postgres needs the postgres store driver")
+		}
+		lock, err := leader.NewPostgres(cfg.Store.DSN, "logsearch:flush")
+		if err != nil {
+			log.Fatal("Failed to connect -flush-leader-postgres:", err)
+		}
+		defer lock.Close()
+		trieOpts = append(trieOpts, trie.WithFlushLeader(lock))
+	case *flushLeaderRedis != "":
+		lock, err := leader.NewRedis(store.RedisConfig{Addr: *flushLeaderRedis}, "flush", *flushLeaderTTL)
+		if err != nil {
+			log.Fatal("Failed to connect to -flush-leader-redis:", err)
+		}
+		defer lock.Close()
+		trieOpts = append(trieOpts, trie.WithFlushLeader(lock))
+	}
+
+	var notifier *webhook.Notifier
+	if *webhooksPath != "" {
+		data, err := os.ReadFile(*webhooksPath)
+		if err != nil {
+			log.Fatal("Failed to read -webhooks:", err)
+		}
+		var endpoints []webhook.Endpoint
+		if err := json.Unmarshal(data, &endpoints); err != nil {
+			log.Fatal("Invalid -webhooks:", err)
+		}
+		var thresholds []int
+		if *webhookThresholds != "" {
+			for _, raw := range strings.Split(*webhookThresholds, ",") {
+				threshold, err := strconv.Atoi(strings.TrimSpace(raw))
+				if err != nil {
+					log.Fatal("Invalid -webhook-thresholds:", err)
+				}
+				thresholds = append(thresholds, threshold)
+			}
+		}
+		notifierOpts := []webhook.Option{webhook.WithThresholds(thresholds...)}
+		if *spikeWindow > 0 {
+			detector := anomaly.New(*spikeWindow, *spikeBaseline, *spikeSigmas, anomaly.WithMinCount(*spikeMinCount))
+			notifierOpts = append(notifierOpts, webhook.WithSpikes(detector))
+		}
+		if notifier, err = webhook.New(endpoints, notifierOpts...); err != nil {
+			log.Fatal("Invalid -webhooks:", err)
+		}
+		// Deferred before the loggers are created, so it runs after they stored their last words
+		defer func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			if cfg.Drain.Timeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, cfg.Drain.Timeout)
+			}
+			defer cancel()
+			if err := notifier.Close(ctx); err != nil {
+				log.Printf("Error closing the webhooks: %v", err)
+			}
+		}()
+		// The trie store holds one record per word, the per-user logger would count every user anew
+		trieOpts = append(trieOpts, trie.WithWordFinalizedHook(notifier.Hook()))
+	}
+
+	var wordFeed *feed.Feed
+	if *feedEnabled {
+		wordFeed = feed.New()
+		userOpts = append(userOpts, logsearch.WithWordFinalizedHook(wordFeed.Hook()))
+		trieOpts = append(trieOpts, trie.WithWordFinalizedHook(wordFeed.Hook()))
+	}
+
+	var dashboard *admin.Dashboard
+	if *adminEnabled {
+		dashboard = admin.New()
+		userOpts = append(userOpts, logsearch.WithWordFinalizedHook(dashboard.Hook()))
+		trieOpts = append(trieOpts, trie.WithWordFinalizedHook(dashboard.Hook()))
+	}
+
+	userOpts = append(userOpts, logsearch.WithNormalizer(n))
+	trieOpts = append(trieOpts, trie.WithNormalizer(n))
+	switch *scrubMode {
+	case "":
+	case "drop", "redact":
+		scrubOpts := []scrub.Option{scrub.OnMatch(m.Scrubbed)}
+		if *scrubMode == "redact" {
+			scrubOpts = append(scrubOpts, scrub.Redact())
+		}
+		scrubber := scrub.New(scrubOpts...)
+		userOpts = append(userOpts, logsearch.WithProcessor(logsearch.StageNormalize, scrubber))
+		// The trie has no ingest chain, it redacts in its normalizer or drops with its filters
+		if *scrubMode == "redact" {
+			trieOpts = append(trieOpts, trie.WithNormalizer(scrubber.Redacting(n)))
+		} else {
+			trieOpts = append(trieOpts, trie.WithFilters(scrubber))
+		}
+	default:
+		log.Fatalf("Invalid -scrub %q, must be drop or redact", *scrubMode)
+	}
+	if len(filters) > 0 {
+		userOpts = append(userOpts, logsearch.WithFilters(filters...))
+		trieOpts = append(trieOpts, trie.WithFilters(filters...))
+	}
+
+	loggers, err := config.NewFromConfig(cfg, config.WithUserOptions(userOpts...), config.WithTrieOptions(trieOpts...))
+	if err != nil {
+		log.Fatal("Failed to create search loggers:", err)
+	}
+	defer loggers.Close()
+	userLogger, trieLogger := loggers.User, loggers.Trie
+
+	if notifier != nil {
+		records, err := trieLogger.GetStoredRecords(context.Background())
+		if err != nil {
+			log.Fatal("Failed to load the stored words of -webhooks:", err)
+		}
+		counts := make([]store.WordCount, len(records))
+		for i, record := range records {
+			counts[i] = store.WordCount{Word: record.Word, Count: record.SearchCount}
+		}
+		notifier.Seed(counts)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger := &searchLogger{SearchLoggerV2: userLogger, trie: trieLogger}
+
+	if *followURL != "" {
+		follower, err := changes.NewFollower(*followURL, nil)
+		if err != nil {
+			log.Fatal("Invalid -follow:", err)
+		}
+		// SIGUSR1 promotes the standby, it stops following and the traffic can be switched to it
+		followCtx, promote := signal.NotifyContext(ctx, syscall.SIGUSR1)
+		done := make(chan struct{})
+		// Stops following before the loggers are closed
+		defer func() {
+			promote()
+			<-done
+		}()
+		go func() {
+			defer close(done)
+			defer promote()
+			if err := trieLogger.Follow(followCtx, follower); err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("Following %s stopped: %v", *followURL, err)
+				return
+			}
+			if ctx.Err() == nil {
+				log.Printf("Promoted, stopped following %s", *followURL)
+			}
+		}()
+	}
+
+	if *tailFile != "" {
+		parser := ingest.JSON(*tailJSONQuery, *tailJSONUser)
+		if *tailRegex != "" {
+			if parser, err = ingest.Regexp(*tailRegex, *tailUnescape); err != nil {
+				log.Fatal("Invalid -tail-regex:", err)
+			}
+		}
+		var tailOpts []ingest.Option
+		if *tailFromStart {
+			tailOpts = append(tailOpts, ingest.FromStart())
+		}
+		tailer := ingest.New(*tailFile, logger, parser, tailOpts...)
+		done := make(chan struct{})
+		// Stops the tailer before the loggers are closed
+		defer func() {
+			stop()
+			<-done
+		}()
+		log.Printf("Tailing searches from %s", *tailFile)
+		go func() {
+			defer close(done)
+			if err := tailer.Run(ctx); err != nil {
+				log.Printf("Tailing %s stopped: %v", *tailFile, err)
+			}
+		}()
+	}
+
+	if cfg.Server.GRPCAddr != "" {
+		listener, err := net.Listen("tcp", cfg.Server.GRPCAddr)
+		if err != nil {
+			log.Fatal("Failed to listen for gRPC:", err)
+		}
+		g := grpc.NewServer()
+		grpcserver.NewServer(logger, trieLogger).Register(g)
+		defer g.GracefulStop()
+
+		log.Printf("Serving gRPC API on %s", cfg.Server.GRPCAddr)
+		go func() {
+			if err := g.Serve(listener); err != nil {
+				log.Printf("gRPC server error: %v", err)
+			}
+		}()
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	// Only the API requests are traced, not the scrapes and probes
+	mux.Handle("/", tracer.Middleware(server.NewHandler(logger, trieLogger)))
+	// A wedged flushing routine asks for a restart, an unreachable store or a full queue only stops the traffic
+	health := server.NewHealth(*healthTimeout)
+	health.AddLiveness("user_flush", server.HeartbeatCheck(userLogger, *heartbeatMaxAge))
+	health.AddLiveness("trie_flush", server.HeartbeatCheck(trieLogger, *heartbeatMaxAge))
+	health.AddReadiness("user_store", server.PingCheck(userLogger))
+	health.AddReadiness("trie_store", server.PingCheck(trieLogger))
+	health.AddReadiness("trie_queue", server.QueueCheck(trieLogger, *queueReadyRatio))
+	mux.Handle("/healthz", health.LivenessHandler())
+	mux.Handle("/readyz", health.ReadinessHandler())
+	if notifier != nil {
+		mux.Handle("/search/webhooks/deliveries", notifier)
+	}
+	if wordFeed != nil {
+		mux.Handle("/search/feed", wordFeed)
+		// The streams never end by themselves, the server only shuts down once they are closed
+		go func() {
+			<-ctx.Done()
+			wordFeed.Close()
+		}()
+	}
+	if hub != nil {
+		mux.Handle("/search/changes", hub)
+		// The streams never end by themselves, the server only shuts down once they are closed
+		go func() {
+			<-ctx.Done()
+			hub.Close()
+		}()
+	}
+	if dashboard != nil {
+		mux.Handle("/admin/", http.StripPrefix("/admin", dashboard.Handler(trieLogger)))
+	}
+	// Keystrokes streamed over a WebSocket are consolidated until idle for -timeout
+	stream := server.NewStreamHandler(logger, cfg.Timeout)
+	mux.Handle("/search/stream", stream)
+	// Closed before the loggers, which store the last words of the connections
+	defer func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		if cfg.Drain.Timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, cfg.Drain.Timeout)
+		}
+		defer cancel()
+		if err := stream.Close(ctx); err != nil {
+			log.Printf("Error closing the keystroke streams: %v", err)
+		}
+	}()
+
+	if cfg.Server.OpsAddr != "" {
+		log.Printf("Serving ops endpoints on %s", cfg.Server.OpsAddr)
+		go func() {
+			if err := server.New(cfg.Server.OpsAddr, ops.NewHandler(trieLogger)).Run(ctx); err != nil {
+				log.Printf("Ops server error: %v", err)
+			}
+		}()
+	}
+
+	log.Printf("Serving search API on %s", cfg.Server.Addr)
+	if err := server.New(cfg.Server.Addr, mux).Run(ctx); err != nil {
+		log.Printf("Server error: %v", err)
+	}
+}
@@ -1,9 +1,14 @@
+// Command logsearch-trie-demo shows the timeout based deduplication of trie.SearchLogger.
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/afanwang/logsearch/trie"
 )
 
 func main() {
@@ -13,21 +18,22 @@ func main() {
 // Demo function for the search logger functionality
 func demonSearchLogger() {
 	fmt.Println("=== Search Logger Demo ===")
+	ctx := context.Background()
 	fmt.Println("1. Load existing words from DB into trie at startup")
 	fmt.Println("2. Build new tries for new words")
 	fmt.Println("3. Store words to DB with timeout mechanism")
 
-	sharedDB := NewMockPostgresDB()
+	sharedDB := store.NewMockPostgresDB()
 
 	fmt.Println("\n1. Creating initial logger and adding test data:")
-	initialLogger, err := NewSearchLoggerWithDB(200*time.Millisecond, sharedDB)
+	initialLogger, err := trie.NewSearchLoggerWithDB(200*time.Millisecond, sharedDB)
 	if err != nil {
 		log.Fatal("Failed to create initial logger:", err)
 	}
 
 	testWords := []string{"apple", "application", "banana", "band"}
 	for _, word := range testWords {
-		if err := initialLogger.LogSearch(word); err != nil {
+		if err := initialLogger.LogSearch(ctx, word); err != nil {
 			log.Printf("Error adding '%s': %v", word, err)
 		}
 	}
@@ -35,14 +41,14 @@ func demonSearchLogger() {
 	initialLogger.Close()
 
 	fmt.Println("\n\n2. Creating new logger - load existing words from DB into trie:")
-	logger, err := NewSearchLoggerWithDB(200*time.Millisecond, sharedDB)
+	logger, err := trie.NewSearchLoggerWithDB(200*time.Millisecond, sharedDB)
 	if err != nil {
 		log.Fatal("Failed to create logger:", err)
 	}
 	defer logger.Close()
 
 	fmt.Println("\n\n3. Words loaded from database into tries:")
-	stored, err := logger.GetStoredSearches()
+	stored, err := logger.GetStoredSearches(ctx)
 	if err != nil {
 		log.Printf("Error getting stored words: %v", err)
 	} else {
@@ -59,7 +65,7 @@ func demonSearchLogger() {
 	}
 
 	for _, search := range newSearches {
-		err := logger.LogSearch(search)
+		err := logger.LogSearch(ctx, search)
 		if err != nil {
 			log.Printf("Error adding '%s': %v", search, err)
 		}
@@ -68,7 +74,7 @@ func demonSearchLogger() {
 
 	time.Sleep(1 * time.Second)
 
-	storedAfter, err := logger.GetStoredSearches()
+	storedAfter, err := logger.GetStoredSearches(ctx)
 	if err != nil {
 		log.Printf("Error getting stored searches: %v", err)
 		return
@@ -78,7 +84,7 @@ func demonSearchLogger() {
 	fmt.Println("\n\n6. Testing word extension:")
 	fmt.Println("   Adding 'Businesses' (extends 'Business')")
 
-	if err := logger.LogSearch("Businesses"); err != nil {
+	if err := logger.LogSearch(ctx, "Businesses"); err != nil {
 		log.Printf("Error getting stored searches: %v", err)
 		return
 	}
@@ -86,7 +92,7 @@ func demonSearchLogger() {
 	time.Sleep(1 * time.Second)
 
 	fmt.Println("\n\n7. Final stored searches after extension:")
-	finalStored, _ := logger.GetStoredSearches()
+	finalStored, _ := logger.GetStoredSearches(ctx)
 	for _, word := range finalStored {
 		fmt.Printf("   - %s\n", word)
 	}
@@ -0,0 +1,361 @@
+// Command logsearchctl queries and manages the searches of a running
+// logsearch-server over its HTTP API, so operators need no SQL, e.g.
+//
+//	logsearchctl -server http://localhost:8080 top -window 24h
+//	logsearchctl delete-user -yes user_1
+//	logsearchctl export -format csv -o user_1.csv user_1
+//	logsearchctl audit -action delete-user -since 2024-05-01T00:00:00Z
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/server"
+)
+
+// command runs a subcommand with its arguments, printing its result to out
+type command struct {
+	usage string
+	run   func(ctx context.Context, c *server.Client, args []string, out output) error
+}
+
+var commands = map[string]command{
+	"top":         {"top [-limit 10] [-window 24h] [-users]", runTop},
+	"suggest":     {"suggest [-limit 10] [-user user_1] <prefix>", runSuggest},
+	"user":        {"user <user_id>", runUser},
+	"delete-user": {"delete-user -yes <user_id>", runDeleteUser},
+	"merge":       {"merge <from> <to> | merge -users <anon_id> <user_id>", runMerge},
+	"export":      {"export [-format jsonl|csv] [-o file] <user_id>", runExport},
+	"import":      {"import [file], JSON Lines of {\"user_id\": ..., \"query\": ...}, stdin by default", runImport},
+	"purge":       {"purge -yes -before 2024-05-01T00:00:00Z | -older-than 2160h", runPurge},
+	"audit":       {"audit [-limit 100] [-actor name] [-action delete-user] [-since 2024-05-01T00:00:00Z]", runAudit},
+}
+
+var commandOrder = []string{"top", "suggest", "user", "delete-user", "merge", "export", "import", "purge", "audit"}
+
+// errUsage is returned by a subcommand called with wrong arguments
+var errUsage = errors.New("invalid arguments")
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("logsearchctl: ")
+
+	defaultServer := os.Getenv("LOGSEARCH_SERVER")
+	if defaultServer == "" {
+		defaultServer = "http://localhost:8080"
+	}
+	serverURL := flag.String("server", defaultServer, "URL of the logsearch-server search API, $LOGSEARCH_SERVER by default")
+	timeout := flag.Duration("timeout", time.Minute, "give up on a command after this long, imports and exports included")
+	jsonOutput := flag.Bool("json", false, "print the results as JSON instead of text")
+	actor := flag.String("actor", os.Getenv("USER"), "who runs the command, recorded in the audit log of the server, $USER by default")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	name := flag.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		log.Printf("unknown command %q", name)
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	c := server.NewClient(*serverURL, nil)
+	c.SetActor(*actor)
+	err := cmd.run(ctx, c, flag.Args()[1:], output{w: os.Stdout, json: *jsonOutput})
+	if errors.Is(err, errUsage) {
+		log.Printf("%v\nusage: logsearchctl %s", err, cmd.usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "usage: logsearchctl [flags] <command> [arguments]\n\ncommands:\n")
+	for _, name := range commandOrder {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s\n", commands[name].usage)
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "\nflags:\n")
+	flag.PrintDefaults()
+}
+
+// output prints the results as text, or as JSON with -json
+type output struct {
+	w    io.Writer
+	json bool
+}
+
+// print prints v as JSON, or calls text to print it
+func (o output) print(v any, text func(w io.Writer)) error {
+	if o.json {
+		encoder := json.NewEncoder(o.w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+	tw := tabwriter.NewWriter(o.w, 0, 4, 2, ' ', 0)
+	text(tw)
+	return tw.Flush()
+}
+
+// parse parses the flags of a subcommand and checks it got n positional arguments
+func parse(fs *flag.FlagSet, args []string, n int) error {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if fs.NArg() != n {
+		return errUsage
+	}
+	return nil
+}
+
+func runTop(ctx context.Context, c *server.Client, args []string, out output) error {
+	fs := flag.NewFlagSet("top", flag.ContinueOnError)
+	limit := fs.Int("limit", 10, "how many words to list")
+	window := fs.Duration("window", 0, "only rank the words searched within this window, e.g. 24h, all time when 0")
+	users := fs.Bool("users", false, "rank the words by the distinct users who searched them, needs -audience on the server")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	if *users {
+		top, err := c.TopAudiences(ctx, *limit, *window)
+		if err != nil {
+			return err
+		}
+		return out.print(top, func(w io.Writer) {
+			fmt.Fprintln(w, "WORD\tUSERS\tSEARCHES")
+			for _, search := range top {
+				fmt.Fprintf(w, "%s\t%d\t%d\n", search.Word, search.Users, search.Count)
+			}
+		})
+	}
+
+	top, err := c.Top(ctx, *limit, *window)
+	if err != nil {
+		return err
+	}
+	return out.print(top, func(w io.Writer) {
+		fmt.Fprintln(w, "WORD\tSEARCHES")
+		for _, search := range top {
+			fmt.Fprintf(w, "%s\t%d\n", search.Word, search.Count)
+		}
+	})
+}
+
+func runSuggest(ctx context.Context, c *server.Client, args []string, out output) error {
+	fs := flag.NewFlagSet("suggest", flag.ContinueOnError)
+	limit := fs.Int("limit", 10, "how many suggestions to list")
+	user := fs.String("user", "", "blend the searches of this user into the suggestions")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+
+	suggestions, err := c.Suggest(ctx, fs.Arg(0), *limit, *user)
+	if err != nil {
+		return err
+	}
+	return out.print(suggestions, func(w io.Writer) {
+		for _, suggestion := range suggestions {
+			fmt.Fprintln(w, suggestion)
+		}
+	})
+}
+
+func runUser(ctx context.Context, c *server.Client, args []string, out output) error {
+	fs := flag.NewFlagSet("user", flag.ContinueOnError)
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+
+	searches, err := c.UserSearches(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return out.print(searches, func(w io.Writer) {
+		for _, search := range searches {
+			fmt.Fprintln(w, search)
+		}
+	})
+}
+
+func runDeleteUser(ctx context.Context, c *server.Client, args []string, out output) error {
+	fs := flag.NewFlagSet("delete-user", flag.ContinueOnError)
+	yes := fs.Bool("yes", false, "confirm the deletion")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+	// Erasing a user cannot be undone, a mistyped command must not do it
+	if !*yes {
+		return fmt.Errorf("deleting every search of %s cannot be undone, pass -yes to confirm", fs.Arg(0))
+	}
+
+	deleted, err := c.DeleteUser(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return out.print(server.DeleteUserResponse{UserID: fs.Arg(0), Deleted: deleted}, func(w io.Writer) {
+		fmt.Fprintf(w, "Deleted %d searches of %s\n", deleted, fs.Arg(0))
+	})
+}
+
+func runMerge(ctx context.Context, c *server.Client, args []string, out output) error {
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	users := fs.Bool("users", false, "merge the searches of a guest into a user instead of a word into another")
+	if err := parse(fs, args, 2); err != nil {
+		return err
+	}
+
+	from, to := fs.Arg(0), fs.Arg(1)
+	var err error
+	if *users {
+		err = c.MergeUsers(ctx, from, to)
+	} else {
+		err = c.MergeWords(ctx, from, to)
+	}
+	if err != nil {
+		return err
+	}
+	return out.print(server.StatusResponse{Status: "ok"}, func(w io.Writer) {
+		fmt.Fprintf(w, "Merged %s into %s\n", from, to)
+	})
+}
+
+func runExport(ctx context.Context, c *server.Client, args []string, out output) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	rawFormat := fs.String("format", string(logsearch.ExportJSONLines), "file format, jsonl or csv")
+	path := fs.String("o", "-", "output file, - for stdout")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+	format, err := logsearch.ParseExportFormat(*rawFormat)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	if *path == "-" {
+		return c.Export(ctx, fs.Arg(0), format, out.w)
+	}
+	f, err := os.Create(*path)
+	if err != nil {
+		return err
+	}
+	if err := c.Export(ctx, fs.Arg(0), format, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func runImport(ctx context.Context, c *server.Client, args []string, out output) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil || fs.NArg() > 1 {
+		return errUsage
+	}
+
+	var r io.Reader = os.Stdin
+	if fs.NArg() == 1 && fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	imported, err := c.Import(ctx, r)
+	if err != nil {
+		return err
+	}
+	return out.print(server.ImportResponse{Imported: imported}, func(w io.Writer) {
+		fmt.Fprintf(w, "Imported %d searches\n", imported)
+	})
+}
+
+func runPurge(ctx context.Context, c *server.Client, args []string, out output) error {
+	fs := flag.NewFlagSet("purge", flag.ContinueOnError)
+	yes := fs.Bool("yes", false, "confirm the purge")
+	before := fs.String("before", "", "delete the searches last updated before this RFC 3339 time")
+	olderThan := fs.Duration("older-than", 0, "delete the searches not repeated for this long, e.g. 2160h")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	var cutoff time.Time
+	switch {
+	case *before != "" && *olderThan != 0:
+		return fmt.Errorf("%w: -before and -older-than are exclusive", errUsage)
+	case *before != "":
+		var err error
+		if cutoff, err = time.Parse(time.RFC3339, *before); err != nil {
+			return fmt.Errorf("%w: invalid -before: %v", errUsage, err)
+		}
+	case *olderThan > 0:
+		cutoff = time.Now().Add(-*olderThan)
+	default:
+		return fmt.Errorf("%w: -before or -older-than is required", errUsage)
+	}
+	if !*yes {
+		return fmt.Errorf("purging the searches last updated before %s cannot be undone, pass -yes to confirm", cutoff.Format(time.RFC3339))
+	}
+
+	resp, err := c.Purge(ctx, cutoff)
+	if err != nil {
+		return err
+	}
+	return out.print(resp, func(w io.Writer) {
+		fmt.Fprintf(w, "Purged %d user searches and %d words last updated before %s\n", resp.UserSearches, resp.Words, resp.Before.Format(time.RFC3339))
+	})
+}
+
+func runAudit(ctx context.Context, c *server.Client, args []string, out output) error {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	limit := fs.Int("limit", 100, "how many entries to list, newest first")
+	actor := fs.String("actor", "", "only list the entries of this actor")
+	action := fs.String("action", "", "only list the entries of this action, e.g. delete-user, merge-users, restore-user, merge-words, rename-word, verify, unverify or purge")
+	rawSince := fs.String("since", "", "only list the entries at or after this RFC 3339 time")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	var since time.Time
+	if *rawSince != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, *rawSince); err != nil {
+			return fmt.Errorf("%w: invalid -since: %v", errUsage, err)
+		}
+	}
+
+	entries, err := c.Audit(ctx, *actor, *action, since, *limit)
+	if err != nil {
+		return err
+	}
+	return out.print(entries, func(w io.Writer) {
+		fmt.Fprintln(w, "AT\tACTOR\tACTION\tTARGET\tBEFORE\tAFTER")
+		for _, entry := range entries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", entry.At.Format(time.RFC3339), entry.Actor, entry.Action, entry.Target, entry.Before, entry.After)
+		}
+	})
+}
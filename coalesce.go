@@ -0,0 +1,127 @@
+package logsearch
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/afanwang/logsearch/metrics"
+)
+
+// coalescer collapses the duplicates of a search into a single store operation
+type coalescer struct {
+	// window is how long an idempotency key is remembered after its search succeeded
+	window time.Duration
+	mu     sync.Mutex
+	// inflight[key] is the search being processed under key, see flightKeys
+	inflight map[string]*flight
+	// seen[user key] is when the idempotency key of a succeeded search is forgotten
+	seen      map[string]time.Time
+	nextSweep time.Time
+}
+
+// flight is a search being processed, done is closed once err is set
+type flight struct {
+	done chan struct{}
+	err  error
+}
+
+// WithCoalescing collapses the duplicates of a search, e.g. retries or the
+// replicas of a fanned-out keystroke, into a single store operation. Searches
+// of the same word by the same user arriving while one is processed wait for
+// it and return its result instead of repeating the read-modify-write cycle.
+// Only the exact duplicates are, so a submitted search, one carrying another
+// result count or another client clock never joins a keystroke of the word.
+// A search carrying the SearchEvent.IdempotencyKey of a search of the same
+// user that succeeded within window is dropped, one still processed is waited
+// for. A failed search does not record its key, so its retry is processed.
+func WithCoalescing(window time.Duration) Option {
+	return func(sl *SearchLoggerV2) {
+		sl.coalesce = &coalescer{
+			window:   max(window, 0),
+			inflight: make(map[string]*flight),
+			seen:     make(map[string]time.Time),
+		}
+	}
+}
+
+// flightKeys returns the keys event is coalesced under, its word and its
+// idempotency key if any. The word key also holds what the dedup makes of the
+// search besides its word: whether it was submitted, its result count and its
+// client clock.
+func flightKeys(event SearchEvent) (string, string) {
+	results := "-"
+	if event.ResultCount != nil {
+		results = strconv.Itoa(*event.ResultCount)
+	}
+	word := fmt.Sprintf("w\x00%s\x00%s\x00%t\x00%s\x00%d\x00%d",
+		event.UserIdentifier, event.Query, event.Submitted, results, event.ClientTime.UnixNano(), event.Sequence)
+	if event.IdempotencyKey == "" {
+		return word, ""
+	}
+	return word, fmt.Sprintf("k\x00%s\x00%s", event.UserIdentifier, event.IdempotencyKey)
+}
+
+// do runs store for event unless a duplicate is processed or was processed,
+// it returns the result of the search actually processed
+func (c *coalescer) do(ctx context.Context, sl *SearchLoggerV2, event SearchEvent, store func() error) error {
+	wordKey, idemKey := flightKeys(event)
+	now := time.Now()
+
+	c.mu.Lock()
+	c.sweepLocked(now)
+	if expires, ok := c.seen[idemKey]; ok && now.Before(expires) {
+		c.mu.Unlock()
+		sl.decided(ctx, metrics.DecisionDuplicate)
+		return nil
+	}
+	f := c.inflight[wordKey]
+	if f == nil && idemKey != "" {
+		f = c.inflight[idemKey]
+	}
+	if f != nil {
+		c.mu.Unlock()
+		sl.decided(ctx, metrics.DecisionDuplicate)
+		select {
+		case <-f.done:
+			return f.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	f = &flight{done: make(chan struct{})}
+	c.inflight[wordKey] = f
+	if idemKey != "" {
+		c.inflight[idemKey] = f
+	}
+	c.mu.Unlock()
+
+	f.err = store()
+
+	c.mu.Lock()
+	delete(c.inflight, wordKey)
+	if idemKey != "" {
+		delete(c.inflight, idemKey)
+		if f.err == nil && c.window > 0 {
+			c.seen[idemKey] = time.Now().Add(c.window)
+		}
+	}
+	c.mu.Unlock()
+	close(f.done)
+	return f.err
+}
+
+// sweepLocked forgets the expired idempotency keys at most once per window, caller must hold mu
+func (c *coalescer) sweepLocked(now time.Time) {
+	if now.Before(c.nextSweep) {
+		return
+	}
+	for key, expires := range c.seen {
+		if !now.Before(expires) {
+			delete(c.seen, key)
+		}
+	}
+	c.nextSweep = now.Add(c.window)
+}
@@ -0,0 +1,137 @@
+package logsearch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gatedStore holds every insert until release is closed, and fails them while failing is set
+type gatedStore struct {
+	*store.MockPostgresDBV2
+	release chan struct{}
+	inserts atomic.Int32
+	failing atomic.Bool
+}
+
+func (s *gatedStore) InsertOrUpdateUserSearch(ctx context.Context, userIdentifier, word string, firstSearched, lastUpdated time.Time) (int64, error) {
+	s.inserts.Add(1)
+	<-s.release
+	if s.failing.Load() {
+		return 0, errors.New("insert failed")
+	}
+	return s.MockPostgresDBV2.InsertOrUpdateUserSearch(ctx, userIdentifier, word, firstSearched, lastUpdated)
+}
+
+// searchCount returns the search count of the stored word of user
+func searchCount(t *testing.T, logger *SearchLoggerV2, user, word string) int {
+	records, err := logger.GetUserSearchRecords(context.Background(), user)
+	require.NoError(t, err)
+	for _, record := range records {
+		if record.SearchWord == word {
+			return record.SearchCount
+		}
+	}
+	return 0
+}
+
+func TestSearchLoggerV2_CoalesceConcurrent(t *testing.T) {
+	ctx := context.Background()
+	gated := &gatedStore{MockPostgresDBV2: store.NewMockPostgresDBV2(), release: make(chan struct{})}
+	logger, err := NewSearchLoggerV2WithDB(gated, WithCoalescing(time.Minute))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	// Five replicas of the same keystroke arrive while the first one is written
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "Bus"))
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(gated.release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), gated.inserts.Load())
+	assert.Equal(t, 1, searchCount(t, logger, "user_1", "bus"))
+
+	// Once done, a new search of the word is a search again
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "bus"))
+	assert.Equal(t, 2, searchCount(t, logger, "user_1", "bus"))
+}
+
+func TestSearchLoggerV2_IdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	gated := &gatedStore{MockPostgresDBV2: store.NewMockPostgresDBV2(), release: make(chan struct{})}
+	close(gated.release)
+	logger, err := NewSearchLoggerV2WithDB(gated, WithCoalescing(100*time.Millisecond))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	// A failed search does not record its key, its retry is processed
+	gated.failing.Store(true)
+	assert.Error(t, logger.LogSearchEvent(ctx, SearchEvent{UserIdentifier: "user_1", Query: "bus", IdempotencyKey: "req-1"}))
+	gated.failing.Store(false)
+	assert.NoError(t, logger.LogSearchEvent(ctx, SearchEvent{UserIdentifier: "user_1", Query: "bus", IdempotencyKey: "req-1"}))
+	assert.Equal(t, 1, searchCount(t, logger, "user_1", "bus"))
+
+	// Retries of the succeeded request are dropped, other requests and users are not
+	assert.NoError(t, logger.LogSearchEvent(ctx, SearchEvent{UserIdentifier: "user_1", Query: "bus", IdempotencyKey: "req-1"}))
+	assert.Equal(t, 1, searchCount(t, logger, "user_1", "bus"))
+	assert.NoError(t, logger.LogSearchEvent(ctx, SearchEvent{UserIdentifier: "user_1", Query: "bus", IdempotencyKey: "req-2"}))
+	assert.Equal(t, 2, searchCount(t, logger, "user_1", "bus"))
+	assert.NoError(t, logger.LogSearchBatch(ctx, []SearchEvent{{UserIdentifier: "user_2", Query: "bus", IdempotencyKey: "req-1"}}))
+	assert.Equal(t, 1, searchCount(t, logger, "user_2", "bus"))
+
+	// The key is forgotten after the window
+	time.Sleep(150 * time.Millisecond)
+	assert.NoError(t, logger.LogSearchEvent(ctx, SearchEvent{UserIdentifier: "user_1", Query: "bus", IdempotencyKey: "req-1"}))
+	assert.Equal(t, 3, searchCount(t, logger, "user_1", "bus"))
+	logger.coalesce.mu.Lock()
+	assert.Len(t, logger.coalesce.seen, 1, "the expired keys are swept")
+	logger.coalesce.mu.Unlock()
+}
+
+func TestSearchLoggerV2_CoalesceKeepsSubmits(t *testing.T) {
+	ctx := context.Background()
+	gated := &gatedStore{MockPostgresDBV2: store.NewMockPostgresDBV2(), release: make(chan struct{})}
+	logger, err := NewSearchLoggerV2WithDB(gated, WithCoalescing(time.Minute), WithZeroResultTracking(1))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	// A keystroke, its submit with no result and a later keystroke of the client arrive together
+	zero := 0
+	at := time.Now()
+	var wg sync.WaitGroup
+	for _, event := range []SearchEvent{
+		{UserIdentifier: "user_1", Query: "bus"},
+		{UserIdentifier: "user_1", Query: "bus", Submitted: true, ResultCount: &zero},
+		{UserIdentifier: "user_1", Query: "bus", ClientTime: at},
+	} {
+		wg.Add(1)
+		go func(event SearchEvent) {
+			defer wg.Done()
+			assert.NoError(t, logger.LogSearchEvent(ctx, event))
+		}(event)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(gated.release)
+	wg.Wait()
+
+	// None joined another, the submit kept its zero result
+	assert.Equal(t, int32(3), gated.inserts.Load())
+	terms, err := logger.GetZeroResultTerms(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, terms, 1)
+	assert.Equal(t, "bus", terms[0].Word)
+}
@@ -0,0 +1,324 @@
+// Package config loads the settings of both loggers and of the server from a
+// YAML file and LOGSEARCH_* environment variables, validates them, and builds
+// the loggers with NewFromConfig:
+//
+//	cfg, err := config.Load("logsearch.yaml")
+//	if err != nil {
+//		return err
+//	}
+//	loggers, err := config.NewFromConfig(cfg)
+//
+// Every setting has an environment variable named after its YAML path, e.g.
+// LOGSEARCH_STORE_DSN for store.dsn or LOGSEARCH_FILTERS_STOP_WORDS for
+// filters.stop_words, lists being comma separated. The variables override the
+// file, which overrides the defaults of Default.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/encrypt"
+)
+
+// EnvPrefix starts the names of the environment variables read by Load
+const EnvPrefix = "LOGSEARCH"
+
+// Store drivers
+const (
+	// DriverMemory keeps the searches in the in-memory mock stores, lost on exit
+	DriverMemory = "memory"
+	// DriverSQLite stores the searches in the SQLite database file of DSN
+	DriverSQLite = "sqlite"
+	// DriverPostgres stores the searches in the PostgreSQL database of DSN
+	DriverPostgres = "postgres"
+)
+
+// Config holds the settings of both loggers and of the server
+type Config struct {
+	Store Store `yaml:"store"`
+	// Timeout is the idle time before a word in the trie is considered complete
+	Timeout time.Duration `yaml:"timeout"`
+	// Pruning drops the stored words from the trie, which then only holds the recent searches
+	Pruning bool `yaml:"pruning"`
+	// DisplayForms answers the trie suggestions and top searches with the casing the users type most
+	DisplayForms bool `yaml:"display_forms"`
+	// SnapshotInterval answers the trie suggestions from a copy rebuilt this often, without the trie lock, 0 disables snapshots
+	SnapshotInterval time.Duration `yaml:"snapshot_interval"`
+	// SnapshotCompaction shares the common endings of the words in the snapshots, to cut their memory
+	SnapshotCompaction bool `yaml:"snapshot_compaction"`
+	// NodeBudget caps the nodes of the trie, evicting the least recently searched stored words, 0 disables the cap
+	NodeBudget int `yaml:"node_budget"`
+	// Categories are the search boxes the searches may be tagged with, e.g. products, to suggest per box
+	Categories []string `yaml:"categories"`
+	// UserCache is how many words are cached in memory for per-user dedup, 0 disables the cache
+	UserCache int `yaml:"user_cache"`
+	// UserQuota caps the stored searches of every user, evicting the least recently updated, 0 disables the cap
+	UserQuota int `yaml:"user_quota"`
+	// UserLocks locks every user in the store while consolidating their searches, for replicas sharing a postgres store
+	UserLocks bool `yaml:"user_locks"`
+	// AtomicConsolidation lets the store decide every search in one atomic write, for the postgres and memory drivers
+	AtomicConsolidation bool `yaml:"atomic_consolidation"`
+	// UserWeight is the share of a user's own searches in their suggestions, between 0 and 1
+	UserWeight float64    `yaml:"user_weight"`
+	Drain      Drain      `yaml:"drain"`
+	Limits     Limits     `yaml:"limits"`
+	Filters    Filters    `yaml:"filters"`
+	Retention  Retention  `yaml:"retention"`
+	Encryption Encryption `yaml:"encryption"`
+	Server     Server     `yaml:"server"`
+}
+
+// Store selects the database of both loggers
+type Store struct {
+	// Driver is memory, sqlite or postgres
+	Driver string `yaml:"driver"`
+	// DSN is the database file of sqlite, or the connection string of postgres
+	DSN string `yaml:"dsn"`
+	// QueryTimeout bounds every single query, 0 keeps the default of the store
+	QueryTimeout time.Duration `yaml:"query_timeout"`
+	// ReplicaDSNs are read replicas of the postgres DSN taking the read-only queries
+	ReplicaDSNs []string `yaml:"replica_dsns"`
+	// MaxReplicaLag sends the reads to DSN while a replica lags more, 0 tolerates any lag
+	MaxReplicaLag time.Duration `yaml:"max_replica_lag"`
+}
+
+// Drain bounds the shutdown of the trie logger
+type Drain struct {
+	// Timeout is how long storing the pending words may take, 0 waits as long as needed
+	Timeout time.Duration `yaml:"timeout"`
+	// MinLength drops the pending words shorter than this on shutdown
+	MinLength int `yaml:"min_length"`
+}
+
+// Limits rejects the oversized searches
+type Limits struct {
+	MaxWordBytes int `yaml:"max_word_bytes"`
+	// MaxWordRunes counts characters, 0 disables the limit
+	MaxWordRunes int `yaml:"max_word_runes"`
+}
+
+// Filters drops the searches never worth storing
+type Filters struct {
+	// MinLength drops the searches shorter than this, 0 disables the filter
+	MinLength int `yaml:"min_length"`
+	// StopWords are never stored
+	StopWords []string `yaml:"stop_words"`
+	// Blocklist is a regular expression of the words never stored, disabled when empty
+	Blocklist string `yaml:"blocklist"`
+}
+
+// Retention deletes the searches not repeated for a while
+type Retention struct {
+	// Window is how long the searches are kept after their last update, 0 keeps them forever
+	Window time.Duration `yaml:"window"`
+	// Interval is how often the expired searches are purged
+	Interval time.Duration `yaml:"interval"`
+}
+
+// Encryption encrypts the files left on disk, see the encrypt package
+type Encryption struct {
+	// Key is the AES key in hex or base64, disabled when empty. Prefer
+	// LOGSEARCH_ENCRYPTION_KEY to writing it in the file.
+	Key string `yaml:"key"`
+}
+
+// Cipher returns the cipher of Key, nil when encryption is disabled
+func (e Encryption) Cipher() (*encrypt.Cipher, error) {
+	if e.Key == "" {
+		return nil, nil
+	}
+	key, err := encrypt.ParseKey(e.Key)
+	if err != nil {
+		return nil, err
+	}
+	return encrypt.New(key)
+}
+
+// Server holds the listening addresses of logsearch-server
+type Server struct {
+	Addr string `yaml:"addr"`
+	// GRPCAddr serves the gRPC API, disabled when empty
+	GRPCAddr string `yaml:"grpc_addr"`
+	// OpsAddr serves pprof and the trie internals, disabled when empty
+	OpsAddr string `yaml:"ops_addr"`
+}
+
+// Default returns the settings used for what the file and the environment leave out
+func Default() Config {
+	return Config{
+		Store:      Store{Driver: DriverMemory},
+		Timeout:    2 * time.Second,
+		UserCache:  100000,
+		UserWeight: 0.5,
+		Drain:      Drain{Timeout: 10 * time.Second},
+		Limits:     Limits{MaxWordBytes: logsearch.MaxWordBytes},
+		Retention:  Retention{Interval: time.Hour},
+		Server:     Server{Addr: ":8080"},
+	}
+}
+
+// Load returns the defaults overridden by the YAML file at path, skipped when
+// path is empty, then by the environment. Unknown keys of the file are errors,
+// so a misspelled setting is not silently ignored. Load does not validate,
+// callers may still override settings, NewFromConfig validates.
+func Load(path string) (Config, error) {
+	cfg := Default()
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to read config: %w", err)
+		}
+		defer f.Close()
+		decoder := yaml.NewDecoder(f)
+		decoder.KnownFields(true)
+		// An empty file leaves the defaults
+		if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+			return Config{}, fmt.Errorf("invalid config %s: %w", path, err)
+		}
+	}
+	if err := applyEnv(reflect.ValueOf(&cfg).Elem(), EnvPrefix); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// applyEnv sets the fields of v from the environment variables named after
+// prefix and their YAML keys
+func applyEnv(v reflect.Value, prefix string) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		key, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+		name := prefix + "_" + strings.ToUpper(key)
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field, name); err != nil {
+				return err
+			}
+			continue
+		}
+		raw, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setField(field, raw); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// setField parses raw into a field of Config
+func setField(field reflect.Value, raw string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
+
+// Validate returns every invalid setting at once, named by its YAML path
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	switch c.Store.Driver {
+	case DriverMemory:
+		check(c.Store.DSN == "", "store.dsn is not used by the memory driver")
+	case DriverSQLite, DriverPostgres:
+		check(c.Store.DSN != "", "store.dsn is required by the %s driver", c.Store.Driver)
+	default:
+		errs = append(errs, fmt.Errorf("store.driver must be %s, %s or %s, not %q", DriverMemory, DriverSQLite, DriverPostgres, c.Store.Driver))
+	}
+	check(c.Store.QueryTimeout >= 0, "store.query_timeout must not be negative")
+	check(len(c.Store.ReplicaDSNs) == 0 || c.Store.Driver == DriverPostgres, "store.replica_dsns is only used by the %s driver", DriverPostgres)
+	for _, dsn := range c.Store.ReplicaDSNs {
+		check(dsn != "", "store.replica_dsns must not hold empty DSNs")
+	}
+	check(c.Store.MaxReplicaLag >= 0, "store.max_replica_lag must not be negative")
+	check(c.Timeout > 0, "timeout must be positive")
+	check(c.SnapshotInterval >= 0, "snapshot_interval must not be negative")
+	check(!c.SnapshotCompaction || c.SnapshotInterval > 0, "snapshot_compaction needs a positive snapshot_interval")
+	check(c.NodeBudget >= 0, "node_budget must not be negative")
+	check(len(c.Categories) <= 64, "categories holds %d categories, at most 64 are supported", len(c.Categories))
+	check(c.UserCache >= 0, "user_cache must not be negative")
+	check(c.UserQuota >= 0, "user_quota must not be negative")
+	check(!c.UserLocks || c.UserCache == 0, "user_locks needs user_cache 0, the cache of a replica goes stale")
+	check(!c.UserLocks || c.Store.Driver != DriverSQLite, "user_locks is not supported by the %s driver", DriverSQLite)
+	check(!c.AtomicConsolidation || c.UserCache == 0, "atomic_consolidation needs user_cache 0, the store decides from its own records")
+	check(!c.AtomicConsolidation || c.Store.Driver != DriverSQLite, "atomic_consolidation is not supported by the %s driver", DriverSQLite)
+	check(c.UserWeight >= 0 && c.UserWeight <= 1, "user_weight must be between 0 and 1")
+	check(c.Drain.Timeout >= 0, "drain.timeout must not be negative")
+	check(c.Drain.MinLength >= 0, "drain.min_length must not be negative")
+	check(c.Limits.MaxWordBytes > 0, "limits.max_word_bytes must be positive")
+	check(c.Limits.MaxWordRunes >= 0, "limits.max_word_runes must not be negative")
+	check(c.Filters.MinLength >= 0, "filters.min_length must not be negative")
+	for _, word := range c.Filters.StopWords {
+		check(strings.TrimSpace(word) != "", "filters.stop_words must not hold empty words")
+	}
+	if c.Filters.Blocklist != "" {
+		_, err := regexp.Compile(c.Filters.Blocklist)
+		check(err == nil, "filters.blocklist is not a valid regular expression: %v", err)
+	}
+	check(c.Retention.Window >= 0, "retention.window must not be negative")
+	check(c.Retention.Window == 0 || c.Retention.Interval > 0, "retention.interval must be positive with a retention.window")
+	if c.Encryption.Key != "" {
+		_, err := encrypt.ParseKey(c.Encryption.Key)
+		check(err == nil, "encryption.key is invalid: %v", err)
+	}
+	check(c.Server.Addr != "", "server.addr is required")
+	check(c.Server.GRPCAddr == "" || c.Server.GRPCAddr != c.Server.Addr, "server.grpc_addr must differ from server.addr")
+	check(c.Server.OpsAddr == "" || (c.Server.OpsAddr != c.Server.Addr && c.Server.OpsAddr != c.Server.GRPCAddr),
+		"server.ops_addr must differ from server.addr and server.grpc_addr")
+
+	return errors.Join(errs...)
+}
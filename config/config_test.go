@@ -0,0 +1,151 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "logsearch.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	cfg, err := Load(writeFile(t, ""))
+	require.NoError(t, err)
+	assert.Equal(t, Default(), cfg)
+
+	path := writeFile(t, `
+store:
+  driver: sqlite
+  dsn: searches.db
+timeout: 500ms
+filters:
+  min_length: 3
+  stop_words: [the, and]
+retention:
+  window: 2160h
+server:
+  addr: :9090
+`)
+	// The environment overrides the file
+	t.Setenv("LOGSEARCH_SERVER_ADDR", ":9191")
+	t.Setenv("LOGSEARCH_FILTERS_STOP_WORDS", "the, a")
+	t.Setenv("LOGSEARCH_USER_WEIGHT", "0.25")
+
+	cfg, err = Load(path)
+	require.NoError(t, err)
+	want := Default()
+	want.Store = Store{Driver: DriverSQLite, DSN: "searches.db"}
+	want.Timeout = 500 * time.Millisecond
+	want.UserWeight = 0.25
+	want.Filters = Filters{MinLength: 3, StopWords: []string{"the", "a"}}
+	want.Retention.Window = 2160 * time.Hour
+	want.Server.Addr = ":9191"
+	assert.Equal(t, want, cfg)
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestLoadErrors(t *testing.T) {
+	_, err := Load(writeFile(t, "timout: 1s\n"))
+	assert.ErrorContains(t, err, "timout")
+
+	_, err = Load(writeFile(t, "timeout: soon\n"))
+	assert.Error(t, err)
+
+	_, err = Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+
+	t.Setenv("LOGSEARCH_USER_CACHE", "many")
+	_, err = Load("")
+	assert.ErrorContains(t, err, "LOGSEARCH_USER_CACHE")
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Default().Validate())
+
+	cfg := Default()
+	cfg.Store = Store{Driver: DriverPostgres}
+	cfg.Timeout = 0
+	cfg.UserWeight = 2
+	cfg.Filters.Blocklist = "("
+	cfg.Retention = Retention{Window: time.Hour}
+	cfg.Encryption.Key = "short"
+	cfg.Server.GRPCAddr = cfg.Server.Addr
+	err := cfg.Validate()
+	for _, problem := range []string{"store.dsn", "timeout", "user_weight", "filters.blocklist", "retention.interval", "encryption.key", "server.grpc_addr"} {
+		assert.ErrorContains(t, err, problem)
+	}
+
+	cfg = Default()
+	cfg.Store.ReplicaDSNs = []string{"postgres://replica/logsearch"}
+	cfg.Store.MaxReplicaLag = -time.Second
+	err = cfg.Validate()
+	assert.ErrorContains(t, err, "store.replica_dsns is only used by the postgres driver")
+	assert.ErrorContains(t, err, "store.max_replica_lag")
+
+	cfg = Default()
+	cfg.Store = Store{Driver: DriverSQLite, DSN: "searches.db"}
+	cfg.UserLocks = true
+	cfg.AtomicConsolidation = true
+	err = cfg.Validate()
+	assert.ErrorContains(t, err, "user_locks needs user_cache 0")
+	assert.ErrorContains(t, err, "user_locks is not supported by the sqlite driver")
+	assert.ErrorContains(t, err, "atomic_consolidation needs user_cache 0")
+	assert.ErrorContains(t, err, "atomic_consolidation is not supported by the sqlite driver")
+
+	cfg = Default()
+	cfg.SnapshotCompaction = true
+	assert.ErrorContains(t, cfg.Validate(), "snapshot_compaction needs a positive snapshot_interval")
+
+	cfg = Default()
+	cfg.Store.Driver = "mysql"
+	assert.ErrorContains(t, cfg.Validate(), `not "mysql"`)
+
+	c, err := Default().Encryption.Cipher()
+	assert.NoError(t, err)
+	assert.Nil(t, c, "Encryption should be disabled by default")
+	c, err = Encryption{Key: strings.Repeat("ab", 32)}.Cipher()
+	assert.NoError(t, err)
+	assert.NotNil(t, c)
+}
+
+func TestNewFromConfig(t *testing.T) {
+	cfg := Default()
+	cfg.Store = Store{Driver: DriverSQLite, DSN: filepath.Join(t.TempDir(), "searches.db")}
+	cfg.Timeout = 50 * time.Millisecond
+	cfg.Filters = Filters{MinLength: 3, StopWords: []string{"the"}}
+
+	loggers, err := NewFromConfig(cfg)
+	require.NoError(t, err)
+	defer loggers.Close()
+
+	ctx := context.Background()
+	for _, word := range []string{"bus", "the", "to"} {
+		require.NoError(t, loggers.User.LogSearchV2(ctx, "user_1", word))
+		require.NoError(t, loggers.Trie.LogSearch(ctx, word))
+	}
+	searches, err := loggers.User.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bus"}, searches)
+
+	// The trie completions are blended into the personal suggestions
+	require.NoError(t, loggers.Trie.LogSearch(ctx, "bike"))
+	require.Eventually(t, func() bool {
+		suggestions, err := loggers.User.SuggestForUser(ctx, "user_2", "b", 10)
+		return err == nil && len(suggestions) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	cfg.Timeout = 0
+	_, err = NewFromConfig(cfg)
+	assert.ErrorContains(t, err, "invalid config")
+}
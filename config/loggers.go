@@ -0,0 +1,149 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/store"
+	"github.com/afanwang/logsearch/trie"
+)
+
+// Loggers are the per-user and the trie based loggers built by NewFromConfig,
+// the per-user logger blending the trie completions into its suggestions
+type Loggers struct {
+	User *logsearch.SearchLoggerV2
+	Trie *trie.SearchLogger
+}
+
+// Close stores the pending words of both loggers and closes their stores
+func (l *Loggers) Close() error {
+	return errors.Join(l.Trie.Close(), l.User.Close())
+}
+
+// Option adds options of the loggers that have no setting, e.g. metrics or hooks
+type Option func(*builder)
+
+type builder struct {
+	userOpts []logsearch.Option
+	trieOpts []trie.Option
+}
+
+// WithUserOptions passes opts to the per-user logger after the options of the settings
+func WithUserOptions(opts ...logsearch.Option) Option {
+	return func(b *builder) {
+		b.userOpts = append(b.userOpts, opts...)
+	}
+}
+
+// WithTrieOptions passes opts to the trie based logger after the options of the settings
+func WithTrieOptions(opts ...trie.Option) Option {
+	return func(b *builder) {
+		b.trieOpts = append(b.trieOpts, opts...)
+	}
+}
+
+// NewFromConfig validates cfg and creates both loggers on the store it selects.
+// The options passed with WithUserOptions and WithTrieOptions come after the
+// ones of the settings, so they can override them and add filters.
+func NewFromConfig(cfg Config, opts ...Option) (*Loggers, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	validator := logsearch.NewValidator(cfg.Limits.MaxWordBytes, cfg.Limits.MaxWordRunes)
+	var filters []logsearch.Filter
+	if cfg.Filters.MinLength > 0 {
+		filters = append(filters, logsearch.MinLength(cfg.Filters.MinLength))
+	}
+	if len(cfg.Filters.StopWords) > 0 {
+		filters = append(filters, logsearch.StopWords(cfg.Filters.StopWords...))
+	}
+	if cfg.Filters.Blocklist != "" {
+		filters = append(filters, logsearch.Blocklist(regexp.MustCompile(cfg.Filters.Blocklist)))
+	}
+
+	b := &builder{
+		userOpts: []logsearch.Option{
+			logsearch.WithUserCache(cfg.UserCache),
+			logsearch.WithUserQuota(cfg.UserQuota),
+			logsearch.WithValidator(validator),
+			logsearch.WithFilters(filters...),
+		},
+		trieOpts: []trie.Option{
+			trie.WithDrain(cfg.Drain.MinLength, cfg.Drain.Timeout),
+			trie.WithValidator(validator),
+			trie.WithFilters(filters...),
+		},
+	}
+	if cfg.Pruning {
+		b.trieOpts = append(b.trieOpts, trie.WithPruning())
+	}
+	if cfg.DisplayForms {
+		b.trieOpts = append(b.trieOpts, trie.WithDisplayForms())
+	}
+	if cfg.SnapshotInterval > 0 {
+		b.trieOpts = append(b.trieOpts, trie.WithSnapshots(cfg.SnapshotInterval))
+	}
+	if cfg.SnapshotCompaction {
+		b.trieOpts = append(b.trieOpts, trie.WithSnapshotCompaction())
+	}
+	if cfg.NodeBudget > 0 {
+		b.trieOpts = append(b.trieOpts, trie.WithNodeBudget(cfg.NodeBudget))
+	}
+	if len(cfg.Categories) > 0 {
+		b.trieOpts = append(b.trieOpts, trie.WithCategories(cfg.Categories...))
+	}
+	if cfg.UserLocks {
+		b.userOpts = append(b.userOpts, logsearch.WithUserLocks())
+	}
+	if cfg.AtomicConsolidation {
+		b.userOpts = append(b.userOpts, logsearch.WithAtomicConsolidation())
+	}
+	if cfg.Retention.Window > 0 {
+		b.userOpts = append(b.userOpts, logsearch.WithRetention(cfg.Retention.Window, cfg.Retention.Interval))
+		b.trieOpts = append(b.trieOpts, trie.WithRetention(cfg.Retention.Window, cfg.Retention.Interval))
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	// The trie comes first, it blends the global completions into the personalized suggestions
+	l := &Loggers{}
+	var err error
+	switch cfg.Store.Driver {
+	case DriverSQLite:
+		storeCfg := store.SQLiteConfig{Path: cfg.Store.DSN, QueryTimeout: cfg.Store.QueryTimeout}
+		if l.Trie, err = trie.NewSearchLoggerWithSQLite(cfg.Timeout, storeCfg, b.trieOpts...); err == nil {
+			l.User, err = logsearch.NewSearchLoggerV2WithSQLite(storeCfg, b.userOptions(cfg, l.Trie)...)
+		}
+	case DriverPostgres:
+		storeCfg := store.PostgresConfig{
+			DSN:           cfg.Store.DSN,
+			QueryTimeout:  cfg.Store.QueryTimeout,
+			ReplicaDSNs:   cfg.Store.ReplicaDSNs,
+			MaxReplicaLag: cfg.Store.MaxReplicaLag,
+		}
+		if l.Trie, err = trie.NewSearchLoggerWithPostgres(cfg.Timeout, storeCfg, b.trieOpts...); err == nil {
+			l.User, err = logsearch.NewSearchLoggerV2WithPostgres(storeCfg, b.userOptions(cfg, l.Trie)...)
+		}
+	default:
+		if l.Trie, err = trie.NewSearchLogger(cfg.Timeout, b.trieOpts...); err == nil {
+			l.User, err = logsearch.NewSearchLoggerV2(b.userOptions(cfg, l.Trie)...)
+		}
+	}
+	if err != nil {
+		if l.Trie != nil {
+			l.Trie.Close()
+		}
+		return nil, fmt.Errorf("failed to create the loggers: %w", err)
+	}
+	return l, nil
+}
+
+// userOptions are the options of the per-user logger, the global suggestions
+// first so that the callers' options can replace them
+func (b *builder) userOptions(cfg Config, global *trie.SearchLogger) []logsearch.Option {
+	return append([]logsearch.Option{logsearch.WithGlobalSuggestions(global, cfg.UserWeight)}, b.userOpts...)
+}
@@ -0,0 +1,65 @@
+package logsearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// MergeWords merges the word from into to for every user, e.g. "nyc" into
+// "new york", so operators can curate the vocabulary. The record of from of a
+// user is merged into their record of to like MergeIdentities merges records,
+// or renamed when they have none. It returns ErrWordNotFound wrapped when no
+// user stored from. Searches of from still pending in the sessions are stored
+// later under from. The store must implement store.UserCurationStore.
+func (sl *SearchLoggerV2) MergeWords(ctx context.Context, from, to string) error {
+	return sl.curate(ctx, "merge-words", from, to)
+}
+
+// RenameWord renames the word oldWord to newWord for every user. Records are
+// per user, so a user who searched both words keeps a single record summing
+// both, which makes RenameWord the same operation as MergeWords.
+func (sl *SearchLoggerV2) RenameWord(ctx context.Context, oldWord, newWord string) error {
+	return sl.curate(ctx, "rename-word", oldWord, newWord)
+}
+
+// curate implements MergeWords and RenameWord, recording action in the audit log
+func (sl *SearchLoggerV2) curate(ctx context.Context, action, from, to string) error {
+	curationStore, ok := sl.db.(store.UserCurationStore)
+	if !ok {
+		return errors.New("store does not support curating words")
+	}
+	from, to = sl.normalizer.Normalize(from), sl.normalizer.Normalize(to)
+	if from == "" || to == "" {
+		return ErrEmptyWord
+	}
+	if from == to {
+		return nil
+	}
+
+	if sl.buffer != nil {
+		// The buffered writes of from must reach the store before it merges them
+		sl.buffer.mutex.Lock()
+		defer sl.buffer.mutex.Unlock()
+		if err := sl.flushLocked(ctx); err != nil {
+			return err
+		}
+	}
+
+	moved, err := curationStore.MergeUserWords(ctx, from, to)
+	// Any cached user may have stored from
+	sl.cache.clear()
+	sl.clearResults(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to merge '%s': %w", from, store.Classify(err))
+	}
+	if moved == 0 {
+		return fmt.Errorf("%w: %q", ErrWordNotFound, from)
+	}
+
+	sl.merged(from, to)
+	sl.audit(ctx, action, from, fmt.Sprintf("%d searches", moved), to)
+	return nil
+}
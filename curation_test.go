@@ -0,0 +1,52 @@
+package logsearch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchLoggerV2_MergeWords(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts []Option
+	}{
+		{"direct", nil},
+		{"cached", []Option{WithUserCache(100)}},
+		{"buffered", []Option{WithWriteBuffer(100, time.Hour), WithSpellCorrection(2)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			logger, err := NewSearchLoggerV2WithDB(store.NewMockPostgresDBV2(), tt.opts...)
+			require.NoError(t, err)
+			defer logger.Close()
+
+			for _, search := range []struct{ user, word string }{
+				{"user_1", "nyc"},
+				{"user_1", "new york"},
+				{"user_2", "nyc"},
+			} {
+				require.NoError(t, logger.LogSearchV2(ctx, search.user, search.word))
+			}
+			// Loads the users into the cache
+			_, err = logger.GetUserSearches(ctx, "user_2")
+			require.NoError(t, err)
+
+			require.NoError(t, logger.MergeWords(ctx, "NYC", "new york"))
+			assert.ErrorIs(t, logger.RenameWord(ctx, "nyc", "new york"), ErrWordNotFound)
+
+			for _, user := range []string{"user_1", "user_2"} {
+				searches, err := logger.GetUserSearches(ctx, user)
+				require.NoError(t, err)
+				assert.Equal(t, []string{"new york"}, searches, user)
+			}
+			top, err := logger.GetTopSearches(ctx, 10)
+			require.NoError(t, err)
+			assert.Equal(t, []store.WordCount{{Word: "new york", Count: 3}}, top)
+		})
+	}
+}
@@ -0,0 +1,220 @@
+// Package denylist finds the terms of a denylist in searches, e.g. profanity,
+// with an Aho-Corasick automaton: the terms are compiled once into a trie
+// whose nodes link to their longest proper suffix that is also a prefix of a
+// term, so a search is scanned once whatever the number of terms. Checking
+// every keystroke against thousands of terms stays cheap.
+package denylist
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/afanwang/logsearch/normalize"
+)
+
+// Match is an occurrence of a term in a text, at the bytes text[Start:End]
+type Match struct {
+	Term  string
+	Start int
+	End   int
+}
+
+// node is a state of the automaton
+type node struct {
+	next map[byte]int32
+	// fail is the state of the longest proper suffix of this state that is a prefix of a term
+	fail int32
+	// terms are the indexes of the terms ending at this state, those of its suffixes included
+	terms []int32
+}
+
+// Denylist is a compiled set of terms. It is immutable and safe for concurrent use.
+type Denylist struct {
+	terms      []string
+	nodes      []node
+	wholeWords bool
+	onMatch    func(term string)
+}
+
+// Option configures optional Denylist behavior
+type Option func(*Denylist)
+
+// WholeWords only matches terms that are not part of a longer word, so the
+// term "ass" does not deny "class". A word is a run of letters and digits.
+func WholeWords() Option {
+	return func(d *Denylist) {
+		d.wholeWords = true
+	}
+}
+
+// OnMatch calls fn with the term of every match that denies or masks a text,
+// e.g. to count the denied terms. fn must be safe for concurrent use.
+func OnMatch(fn func(term string)) Option {
+	return func(d *Denylist) {
+		d.onMatch = fn
+	}
+}
+
+// New compiles the terms, trimmed and lowercased like the default normalizer
+// does. Empty and duplicate terms are ignored.
+func New(terms []string, opts ...Option) *Denylist {
+	d := &Denylist{nodes: []node{{}}}
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	seen := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		term = strings.ToLower(strings.TrimSpace(term))
+		if term == "" || seen[term] {
+			continue
+		}
+		seen[term] = true
+		d.insert(term)
+	}
+	d.link()
+	return d
+}
+
+// insert adds a term to the trie of the automaton
+func (d *Denylist) insert(term string) {
+	state := int32(0)
+	for i := 0; i < len(term); i++ {
+		next, ok := d.nodes[state].next[term[i]]
+		if !ok {
+			next = int32(len(d.nodes))
+			d.nodes = append(d.nodes, node{})
+			if d.nodes[state].next == nil {
+				d.nodes[state].next = make(map[byte]int32)
+			}
+			d.nodes[state].next[term[i]] = next
+		}
+		state = next
+	}
+	d.nodes[state].terms = append(d.nodes[state].terms, int32(len(d.terms)))
+	d.terms = append(d.terms, term)
+}
+
+// link computes the failure links breadth first, every state inheriting the
+// terms of its failure state, which is shallower hence already linked
+func (d *Denylist) link() {
+	queue := make([]int32, 0, len(d.nodes))
+	for _, child := range d.nodes[0].next {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for b, child := range d.nodes[state].next {
+			queue = append(queue, child)
+			fail := d.nodes[state].fail
+			for fail != 0 && !d.has(fail, b) {
+				fail = d.nodes[fail].fail
+			}
+			if next, ok := d.nodes[fail].next[b]; ok && next != child {
+				d.nodes[child].fail = next
+			}
+			d.nodes[child].terms = append(d.nodes[child].terms, d.nodes[d.nodes[child].fail].terms...)
+		}
+	}
+}
+
+// has reports whether state has a transition on b
+func (d *Denylist) has(state int32, b byte) bool {
+	_, ok := d.nodes[state].next[b]
+	return ok
+}
+
+// Len returns the number of distinct terms
+func (d *Denylist) Len() int {
+	return len(d.terms)
+}
+
+// Find returns every match of the terms in text, by end then start position
+func (d *Denylist) Find(text string) []Match {
+	var matches []Match
+	state := int32(0)
+	for i := 0; i < len(text); i++ {
+		b := text[i]
+		for state != 0 && !d.has(state, b) {
+			state = d.nodes[state].fail
+		}
+		state = d.nodes[state].next[b]
+
+		// Longer terms first, their start is further left
+		for _, term := range d.nodes[state].terms {
+			match := Match{Term: d.terms[term], Start: i + 1 - len(d.terms[term]), End: i + 1}
+			if !d.wholeWords || d.wordBounded(text, match) {
+				matches = append(matches, match)
+			}
+		}
+	}
+	return matches
+}
+
+// wordBounded reports whether a match is neither preceded nor followed by a word character
+func (d *Denylist) wordBounded(text string, match Match) bool {
+	before, _ := utf8.DecodeLastRuneInString(text[:match.Start])
+	after, _ := utf8.DecodeRuneInString(text[match.End:])
+	return !isWordRune(before) && !isWordRune(after)
+}
+
+// isWordRune reports whether r is part of a word, utf8.RuneError at the edges of the text is not
+func isWordRune(r rune) bool {
+	return r != utf8.RuneError && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// Allow reports whether text contains no term, so a Denylist is a filter of
+// the loggers, e.g. logsearch.WithFilters(d)
+func (d *Denylist) Allow(text string) bool {
+	matches := d.Find(text)
+	d.observe(matches)
+	return len(matches) == 0
+}
+
+// Mask replaces every character of the terms found in text with '*', e.g.
+// "darn it" becomes "**** it" for the term "darn"
+func (d *Denylist) Mask(text string) string {
+	matches := d.Find(text)
+	if len(matches) == 0 {
+		return text
+	}
+	d.observe(matches)
+
+	masked := make([]bool, len(text))
+	for _, match := range matches {
+		for i := match.Start; i < match.End; i++ {
+			masked[i] = true
+		}
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(text))
+	for i, r := range text {
+		if masked[i] {
+			sb.WriteByte('*')
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// Masking returns a normalizer masking the terms in the searches normalized by n,
+// e.g. logsearch.WithNormalizer(d.Masking(normalize.Default))
+func (d *Denylist) Masking(n normalize.Normalizer) normalize.Normalizer {
+	return normalize.Func(func(s string) string {
+		return d.Mask(n.Normalize(s))
+	})
+}
+
+// observe reports the matches to the OnMatch callback
+func (d *Denylist) observe(matches []Match) {
+	if d.onMatch == nil {
+		return
+	}
+	for _, match := range matches {
+		d.onMatch(match.Term)
+	}
+}
@@ -0,0 +1,85 @@
+package denylist
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/afanwang/logsearch/normalize"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFind(t *testing.T) {
+	// The classic example: overlapping terms and terms suffixes of others
+	d := New([]string{"he", "she", " His ", "hers", "", "he"})
+	assert.Equal(t, 4, d.Len())
+
+	assert.Equal(t, []Match{
+		{Term: "she", Start: 1, End: 4},
+		{Term: "he", Start: 2, End: 4},
+		{Term: "hers", Start: 2, End: 6},
+	}, d.Find("ushers"))
+	assert.Equal(t, []Match{{Term: "his", Start: 0, End: 3}}, d.Find("his"))
+	assert.Empty(t, d.Find("bus"))
+	assert.Empty(t, New(nil).Find("anything"))
+}
+
+func TestFindMatchesNaiveSearch(t *testing.T) {
+	terms := []string{"ab", "abc", "bca", "c", "caa", "aab"}
+	d := New(terms)
+	for _, text := range []string{"abcaab", "aabcaaab", "cccc", "bacab"} {
+		var want []Match
+		for end := 1; end <= len(text); end++ {
+			for start := 0; start < end; start++ {
+				for _, term := range terms {
+					if text[start:end] == term {
+						want = append(want, Match{Term: term, Start: start, End: end})
+					}
+				}
+			}
+		}
+		assert.Equal(t, want, d.Find(text), text)
+	}
+}
+
+func TestWholeWords(t *testing.T) {
+	d := New([]string{"ass", "darn"}, WholeWords())
+	assert.True(t, d.Allow("class"))
+	assert.True(t, d.Allow("darn1"))
+	assert.False(t, d.Allow("ass"))
+	assert.False(t, d.Allow("oh darn, it"))
+	assert.True(t, New([]string{"ass"}).Allow("bus"))
+	assert.False(t, New([]string{"ass"}).Allow("class"))
+}
+
+func TestMask(t *testing.T) {
+	var mutex sync.Mutex
+	counts := make(map[string]int)
+	d := New([]string{"darn", "café"}, OnMatch(func(term string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		counts[term]++
+	}))
+
+	assert.Equal(t, "**** it", d.Mask("darn it"))
+	assert.Equal(t, "le **** ****", d.Mask("le café darn"))
+	assert.Equal(t, "bus", d.Mask("bus"))
+	assert.Equal(t, "**** it", d.Masking(normalize.Default).Normalize("  DARN It "))
+	assert.False(t, d.Allow("darn"))
+	assert.Equal(t, map[string]int{"darn": 4, "café": 1}, counts)
+}
+
+func BenchmarkAllow(b *testing.B) {
+	terms := make([]string, 5000)
+	for i := range terms {
+		terms[i] = fmt.Sprintf("term%dx", i)
+	}
+	d := New(terms)
+	text := strings.Repeat("a search typed by a user ", 4)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.Allow(text)
+	}
+}
@@ -0,0 +1,273 @@
+// Package encrypt encrypts the files the loggers leave on disk, the WAL
+// segments and the exports, since raw search logs are sensitive. It uses
+// AES-GCM, so a tampered or truncated file fails to decrypt instead of
+// yielding altered searches.
+//
+// The key is 16, 24 or 32 bytes for AES-128, AES-192 or AES-256. It comes
+// from the configuration with ParseKey, or from a KeyProvider such as a KMS
+// unwrapping a data key:
+//
+//	c, err := encrypt.FromProvider(ctx, kmsProvider)
+//	l, err := wal.Open(dir, wal.WithCipher(c))
+package encrypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrDecrypt is returned for data that was not encrypted with the key, or was damaged
+var ErrDecrypt = errors.New("encrypt: message authentication failed")
+
+// streamMagic starts the files written by NewWriter
+var streamMagic = []byte("LSENC1\n")
+
+const (
+	// chunkSize is the plaintext size of the chunks of a stream
+	chunkSize = 64 << 10
+	// chunkFinal flags the last chunk of a stream, so a truncated stream is detected
+	chunkFinal = 1
+)
+
+// KeyProvider returns the data key, e.g. by unwrapping it with a KMS
+type KeyProvider interface {
+	Key(ctx context.Context) ([]byte, error)
+}
+
+// KeyFunc adapts a plain function to a KeyProvider
+type KeyFunc func(ctx context.Context) ([]byte, error)
+
+// Key calls f
+func (f KeyFunc) Key(ctx context.Context) ([]byte, error) {
+	return f(ctx)
+}
+
+// ParseKey decodes a key written in hex or base64, e.g. the output of
+// openssl rand -hex 32
+func ParseKey(s string) ([]byte, error) {
+	for _, decode := range []func(string) ([]byte, error){hex.DecodeString, base64.StdEncoding.DecodeString} {
+		if key, err := decode(s); err == nil && validKeySize(len(key)) {
+			return key, nil
+		}
+	}
+	return nil, errors.New("encryption key must be 16, 24 or 32 bytes in hex or base64")
+}
+
+func validKeySize(n int) bool {
+	return n == 16 || n == 24 || n == 32
+}
+
+// Cipher encrypts and decrypts with one key. It is safe for concurrent use.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// New creates a Cipher with key, 16, 24 or 32 bytes long
+func New(key []byte) (*Cipher, error) {
+	if !validKeySize(len(key)) {
+		return nil, fmt.Errorf("encryption key of %d bytes, want 16, 24 or 32", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// FromProvider creates a Cipher with the key of p
+func FromProvider(ctx context.Context, p KeyProvider) (*Cipher, error) {
+	key, err := p.Key(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the encryption key: %w", err)
+	}
+	return New(key)
+}
+
+// Overhead is how many bytes Seal adds to the plaintext
+func (c *Cipher) Overhead() int {
+	return c.aead.NonceSize() + c.aead.Overhead()
+}
+
+// Seal encrypts plaintext under a random nonce, which it prepends. additional
+// is authenticated but not encrypted, e.g. the position of a record, so a
+// record cannot be moved elsewhere unnoticed.
+func (c *Cipher) Seal(plaintext, additional []byte) []byte {
+	nonce := make([]byte, c.aead.NonceSize(), c.Overhead()+len(plaintext))
+	if _, err := rand.Read(nonce); err != nil {
+		// The system random source never fails on supported platforms
+		panic(fmt.Sprintf("encrypt: failed to read random nonce: %v", err))
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, additional)
+}
+
+// Open decrypts what Seal returned for the same additional data
+func (c *Cipher) Open(sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < c.Overhead() {
+		return nil, ErrDecrypt
+	}
+	nonceSize := c.aead.NonceSize()
+	plaintext, err := c.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], additional)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// chunkAD is the additional data of a chunk: its index and flags
+func chunkAD(index uint64, flags byte) []byte {
+	ad := make([]byte, 9)
+	binary.BigEndian.PutUint64(ad, index)
+	ad[8] = flags
+	return ad
+}
+
+// writer encrypts a stream in chunks
+type writer struct {
+	c      *Cipher
+	w      io.Writer
+	buf    []byte
+	index  uint64
+	closed bool
+	err    error
+}
+
+// errClosed is returned by a write after Close
+var errClosed = errors.New("encrypt: write after close")
+
+// NewWriter returns a writer encrypting what is written to it into w, in
+// chunks of 64 KiB. Close must be called to write the last chunk, it does not
+// close w. The stream is read back with NewReader.
+func (c *Cipher) NewWriter(w io.Writer) io.WriteCloser {
+	ew := &writer{c: c, w: w, buf: make([]byte, 0, chunkSize)}
+	_, ew.err = w.Write(streamMagic)
+	return ew
+}
+
+func (ew *writer) Write(p []byte) (int, error) {
+	if ew.closed {
+		return 0, errClosed
+	}
+	if ew.err != nil {
+		return 0, ew.err
+	}
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), chunkSize-len(ew.buf))
+		ew.buf = append(ew.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(ew.buf) == chunkSize {
+			if ew.err = ew.flush(0); ew.err != nil {
+				return written, ew.err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close writes the last chunk, possibly empty
+func (ew *writer) Close() error {
+	if ew.closed || ew.err != nil {
+		return ew.err
+	}
+	ew.closed = true
+	ew.err = ew.flush(chunkFinal)
+	return ew.err
+}
+
+// flush writes the buffered plaintext as one chunk: its flags, the length of
+// the sealed chunk and the sealed chunk
+func (ew *writer) flush(flags byte) error {
+	sealed := ew.c.Seal(ew.buf, chunkAD(ew.index, flags))
+	header := make([]byte, 5)
+	header[0] = flags
+	binary.BigEndian.PutUint32(header[1:], uint32(len(sealed)))
+	if _, err := ew.w.Write(append(header, sealed...)); err != nil {
+		return err
+	}
+	ew.index++
+	ew.buf = ew.buf[:0]
+	return nil
+}
+
+// reader decrypts a stream written by writer
+type reader struct {
+	c     *Cipher
+	r     io.Reader
+	buf   []byte
+	index uint64
+	done  bool
+	err   error
+}
+
+// NewReader returns a reader decrypting the stream of r written by NewWriter.
+// A stream encrypted with another key, damaged or truncated fails with ErrDecrypt.
+func (c *Cipher) NewReader(r io.Reader) io.Reader {
+	return &reader{c: c, r: r}
+}
+
+func (er *reader) Read(p []byte) (int, error) {
+	for len(er.buf) == 0 {
+		if er.err != nil {
+			return 0, er.err
+		}
+		if er.done {
+			return 0, io.EOF
+		}
+		er.err = er.next()
+	}
+	n := copy(p, er.buf)
+	er.buf = er.buf[n:]
+	return n, nil
+}
+
+// next decrypts the next chunk into buf
+func (er *reader) next() error {
+	if er.index == 0 {
+		magic := make([]byte, len(streamMagic))
+		if _, err := io.ReadFull(er.r, magic); err != nil || !bytes.Equal(magic, streamMagic) {
+			return fmt.Errorf("%w: not an encrypted stream", ErrDecrypt)
+		}
+	}
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(er.r, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("%w: truncated stream", ErrDecrypt)
+		}
+		return err
+	}
+	flags, length := header[0], binary.BigEndian.Uint32(header[1:])
+	if length > uint32(chunkSize+er.c.Overhead()) {
+		return ErrDecrypt
+	}
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(er.r, sealed); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("%w: truncated stream", ErrDecrypt)
+		}
+		return err
+	}
+
+	plaintext, err := er.c.Open(sealed, chunkAD(er.index, flags))
+	if err != nil {
+		return err
+	}
+	er.buf = plaintext
+	er.index++
+	er.done = flags&chunkFinal != 0
+	return nil
+}
@@ -0,0 +1,89 @@
+package encrypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCipher(t *testing.T, b byte) *Cipher {
+	c, err := New(bytes.Repeat([]byte{b}, 32))
+	require.NoError(t, err)
+	return c
+}
+
+func TestSealAndOpen(t *testing.T) {
+	c := newCipher(t, 1)
+	sealed := c.Seal([]byte("bus"), []byte("1"))
+	assert.Len(t, sealed, 3+c.Overhead())
+	assert.NotEqual(t, sealed, c.Seal([]byte("bus"), []byte("1")), "Every seal should use a fresh nonce")
+
+	plain, err := c.Open(sealed, []byte("1"))
+	require.NoError(t, err)
+	assert.Equal(t, "bus", string(plain))
+
+	_, err = c.Open(sealed, []byte("2"))
+	assert.ErrorIs(t, err, ErrDecrypt, "A record moved elsewhere should fail")
+	_, err = newCipher(t, 2).Open(sealed, []byte("1"))
+	assert.ErrorIs(t, err, ErrDecrypt)
+	_, err = c.Open(sealed[:5], []byte("1"))
+	assert.ErrorIs(t, err, ErrDecrypt)
+}
+
+func TestStream(t *testing.T) {
+	c := newCipher(t, 1)
+	for _, size := range []int{0, 10, chunkSize, 3*chunkSize + 7} {
+		data := bytes.Repeat([]byte("bus "), size/4+1)[:size]
+		var out bytes.Buffer
+		w := c.NewWriter(&out)
+		_, err := w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		require.NoError(t, w.Close(), "Closing twice should be a no-op")
+		_, err = w.Write([]byte("more"))
+		assert.Error(t, err, "Writing after close should fail")
+
+		plain, err := io.ReadAll(c.NewReader(bytes.NewReader(out.Bytes())))
+		require.NoError(t, err)
+		assert.Equal(t, data, plain)
+
+		// A truncated stream fails instead of ending early
+		_, err = io.ReadAll(c.NewReader(bytes.NewReader(out.Bytes()[:out.Len()-1])))
+		assert.ErrorIs(t, err, ErrDecrypt)
+		_, err = io.ReadAll(newCipher(t, 2).NewReader(bytes.NewReader(out.Bytes())))
+		assert.ErrorIs(t, err, ErrDecrypt)
+	}
+
+	_, err := io.ReadAll(c.NewReader(bytes.NewReader([]byte("word\nbus\n"))))
+	assert.ErrorIs(t, err, ErrDecrypt, "A file in clear should fail")
+}
+
+func TestKeys(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	for _, encoded := range []string{hex.EncodeToString(key), base64.StdEncoding.EncodeToString(key)} {
+		parsed, err := ParseKey(encoded)
+		require.NoError(t, err)
+		assert.Equal(t, key, parsed)
+	}
+	_, err := ParseKey("not a key")
+	assert.Error(t, err)
+	_, err = New(key[:20])
+	assert.Error(t, err)
+
+	c, err := FromProvider(context.Background(), KeyFunc(func(ctx context.Context) ([]byte, error) {
+		return key, nil
+	}))
+	require.NoError(t, err)
+	assert.NotNil(t, c)
+	_, err = FromProvider(context.Background(), KeyFunc(func(ctx context.Context) ([]byte, error) {
+		return nil, errors.New("kms unavailable")
+	}))
+	assert.Error(t, err)
+}
@@ -0,0 +1,70 @@
+package logsearch
+
+import (
+	"errors"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// MaxWordBytes bounds the length of a search word by default, longer ones are
+// rejected with ErrWordTooLong instead of growing the trie and the store
+// without limit, see NewValidator
+const MaxWordBytes = 1024
+
+// The loggers return these errors wrapped, test for them with errors.Is.
+var (
+	// ErrEmptyWord rejects a search whose word is empty, before or after
+	// normalization. It is not retryable.
+	ErrEmptyWord = errors.New("word cannot be empty")
+
+	// ErrEmptyUser rejects a search or a request without a user identifier.
+	// It is not retryable.
+	ErrEmptyUser = errors.New("user identifier cannot be empty")
+
+	// ErrWordTooLong rejects a word longer than the limits of the Validator,
+	// MaxWordBytes by default. It is not retryable, the caller may truncate
+	// the word and log it again.
+	ErrWordTooLong = errors.New("word is too long")
+
+	// ErrInvalidInput rejects a word that is binary garbage rather than text,
+	// see Validator. It is not retryable.
+	ErrInvalidInput = errors.New("word is not valid text")
+
+	// ErrUserNotFound is returned when the record of a user's word vanished
+	// under an update, e.g. deleted or purged meanwhile. It is not retryable
+	// as is, the search should be logged again instead.
+	ErrUserNotFound = store.ErrUserNotFound
+
+	// ErrWordNotFound is returned when a moderated word has no stored record,
+	// e.g. because it is still pending. It is not retryable.
+	ErrWordNotFound = store.ErrWordNotFound
+
+	// ErrWordExists rejects renaming a word to a stored word, which must be
+	// merged instead. It is not retryable.
+	ErrWordExists = store.ErrWordExists
+
+	// ErrStoreUnavailable is returned when the store cannot be reached, timed
+	// out or is busy. It is retryable with backoff.
+	ErrStoreUnavailable = store.ErrStoreUnavailable
+
+	// ErrQueueFull drops a search because the async ingestion queue is full,
+	// see trie.WithAsync. It is retryable once the workers caught up.
+	ErrQueueFull = errors.New("search queue is full")
+
+	// ErrUnknownCategory rejects a search or a query in a category the trie
+	// was not configured with, see trie.WithCategories. It is not retryable.
+	ErrUnknownCategory = errors.New("unknown category")
+)
+
+// ValidateWord checks a search word with DefaultValidator, returning a wrapped
+// ErrEmptyWord, ErrWordTooLong or ErrInvalidInput
+func ValidateWord(word string) error {
+	_, err := DefaultValidator.Sanitize(word)
+	return err
+}
+
+// Retryable reports whether the failed operation of err may succeed when
+// retried later, which is the case for ErrStoreUnavailable and ErrQueueFull
+func Retryable(err error) bool {
+	return errors.Is(err, ErrStoreUnavailable) || errors.Is(err, ErrQueueFull)
+}
@@ -0,0 +1,50 @@
+package logsearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateWord(t *testing.T) {
+	assert.NoError(t, ValidateWord("bus"))
+	assert.ErrorIs(t, ValidateWord(""), ErrEmptyWord)
+	assert.NoError(t, ValidateWord(strings.Repeat("a", MaxWordBytes)))
+	assert.ErrorIs(t, ValidateWord(strings.Repeat("a", MaxWordBytes+1)), ErrWordTooLong)
+	assert.ErrorIs(t, ValidateWord("\x00"), ErrInvalidInput)
+}
+
+func TestRetryable(t *testing.T) {
+	assert.True(t, Retryable(fmt.Errorf("failed: %w", store.Classify(context.DeadlineExceeded))))
+	assert.True(t, Retryable(ErrQueueFull))
+	assert.False(t, Retryable(ErrWordTooLong))
+	assert.False(t, Retryable(errors.New("boom")))
+	assert.False(t, Retryable(nil))
+}
+
+func TestSearchLoggerV2_Errors(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLoggerV2()
+	require.NoError(t, err)
+	defer logger.Close()
+
+	assert.ErrorIs(t, logger.LogSearchV2(ctx, "", "bus"), ErrEmptyUser)
+	assert.ErrorIs(t, logger.LogSearchV2(ctx, "user_1", ""), ErrEmptyWord)
+	assert.ErrorIs(t, logger.LogSearchV2(ctx, "user_1", "   "), ErrEmptyWord, "Normalized to nothing")
+	assert.ErrorIs(t, logger.LogSearchV2(ctx, "user_1", strings.Repeat("a", MaxWordBytes+1)), ErrWordTooLong)
+	assert.ErrorIs(t, logger.LogSearchV2(ctx, "user_1", "bus\xff"), ErrInvalidInput)
+	assert.ErrorIs(t, logger.MergeIdentities(ctx, "", "user_1"), ErrEmptyUser)
+
+	_, err = logger.DeleteUserData(ctx, "")
+	assert.ErrorIs(t, err, ErrEmptyUser)
+
+	// Errors of a batch stay testable through the join
+	err = logger.LogSearchBatch(ctx, []SearchEvent{{UserIdentifier: "user_1", Query: "bus"}, {UserIdentifier: "user_1"}})
+	assert.ErrorIs(t, err, ErrEmptyWord)
+}
@@ -0,0 +1,43 @@
+package logsearch
+
+import "time"
+
+// SearchEvent is a single search as received from the API, the unit of batch ingestion
+type SearchEvent struct {
+	// UserIdentifier is the user_id for logged-in users or the anon_id for guests,
+	// the global trie logger ignores it
+	UserIdentifier string
+	Query          string
+	// IdempotencyKey identifies the client request of the search, optional.
+	// With WithCoalescing the retries of a request carrying the same key are logged once.
+	IdempotencyKey string
+	// ClientTime and Sequence are when the client sent the search and its
+	// number among the searches of its user, optional, see WithClientClock
+	ClientTime time.Time
+	Sequence   uint64
+	// Country and Locale are where the search was made, e.g. "FR" and
+	// "fr-FR", optional, see WithRegions
+	Country string
+	Locale  string
+	// Category is the search box the search was typed in, e.g. "products",
+	// optional, see trie.WithCategories
+	Category string
+	// Submitted marks a search the user submitted, e.g. by pressing Enter,
+	// rather than a keystroke, see SearchLoggerV2.LogSearchSubmitted. The
+	// global trie logger ignores it.
+	Submitted bool
+	// ResultCount is how many results a submitted search returned, optional,
+	// see WithZeroResultTracking. It is ignored for keystrokes and by the
+	// global trie logger.
+	ResultCount *int
+
+	// finalize marks the signal of SearchLoggerV2.Finalize, which is not a search
+	finalize bool
+	// click marks a click of SearchLoggerV2.LogSearchClick on resultID, which is not a search
+	click    bool
+	resultID string
+	// at is when the dedup received the search, late whether it was sent
+	// before a search of its user delivered earlier, for StageStore
+	at   time.Time
+	late bool
+}
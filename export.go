@@ -0,0 +1,186 @@
+package logsearch
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// ExportFormat is the encoding of ExportUserSearches
+type ExportFormat string
+
+const (
+	// ExportJSONLines writes one JSON object per record and line
+	ExportJSONLines ExportFormat = "jsonl"
+	// ExportCSV writes a header line then one line per record
+	ExportCSV ExportFormat = "csv"
+)
+
+// ParseExportFormat returns the format named s, "jsonl" or "csv"
+func ParseExportFormat(s string) (ExportFormat, error) {
+	switch format := ExportFormat(s); format {
+	case ExportJSONLines, ExportCSV:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown export format %q, expected jsonl or csv", s)
+	}
+}
+
+// ExportedSearch is a record of ExportUserSearches
+type ExportedSearch struct {
+	Word            string    `json:"word"`
+	FirstSearchedAt time.Time `json:"first_searched_at"`
+	LastUpdatedAt   time.Time `json:"last_updated_at"`
+	SearchCount     int       `json:"search_count"`
+	// SurfaceWord is the form typed when Word is a stem, see WithStemmer
+	SurfaceWord string `json:"surface_word,omitempty"`
+}
+
+// exportCSVHeader names the columns of ExportCSV
+var exportCSVHeader = []string{"word", "first_searched_at", "last_updated_at", "search_count", "surface_word"}
+
+// ExportUserSearches streams the full search history of a user to w in word
+// order, for data-subject access requests and analytics handoffs. Timestamps
+// are RFC 3339 in UTC. Only stored searches are exported, call Flush first to
+// include the buffered and pending ones.
+func (sl *SearchLoggerV2) ExportUserSearches(ctx context.Context, userIdentifier string, format ExportFormat, w io.Writer) error {
+	exportStore, ok := sl.db.(store.UserExportStore)
+	if !ok {
+		return errors.New("store does not support exporting users")
+	}
+
+	var write func(ExportedSearch) error
+	var flush func() error
+	switch format {
+	case ExportJSONLines:
+		enc := json.NewEncoder(w)
+		write = func(search ExportedSearch) error { return enc.Encode(search) }
+		flush = func() error { return nil }
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(exportCSVHeader); err != nil {
+			return err
+		}
+		write = func(search ExportedSearch) error {
+			return cw.Write([]string{
+				search.Word,
+				search.FirstSearchedAt.Format(time.RFC3339Nano),
+				search.LastUpdatedAt.Format(time.RFC3339Nano),
+				strconv.Itoa(search.SearchCount),
+				search.SurfaceWord,
+			})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+
+	// Failures of w are not classified, a broken client connection is no store outage
+	var writeErr error
+	err := exportStore.ForEachUserSearch(ctx, userIdentifier, func(record store.UserSearchRecord) error {
+		writeErr = write(ExportedSearch{
+			Word:            record.SearchWord,
+			FirstSearchedAt: record.FirstSearchedAt.UTC(),
+			LastUpdatedAt:   record.LastUpdatedAt.UTC(),
+			SearchCount:     record.SearchCount,
+			SurfaceWord:     record.SurfaceWord,
+		})
+		return writeErr
+	})
+	if err != nil && writeErr == nil {
+		err = store.Classify(err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to export user searches: %w", err)
+	}
+
+	return flush()
+}
+
+// RestoreUserSearches writes the records of an export of the user back to the
+// store with their counts and timestamps, e.g. to move the user to another
+// instance. The words the user already stores are kept as they are, so a
+// restore retried after a partial failure does not count them twice. It
+// returns how many records were restored. The restored searches feed the
+// indexes of WithHeavyHitters, WithSpellCorrection and WithTrending but not
+// the word finalized hooks, they were finalized where they were logged. The
+// store must implement store.BatchUserSearchStore.
+func (sl *SearchLoggerV2) RestoreUserSearches(ctx context.Context, userIdentifier string, searches []ExportedSearch) (int, error) {
+	if userIdentifier == "" {
+		return 0, ErrEmptyUser
+	}
+	batchStore, ok := sl.db.(store.BatchUserSearchStore)
+	if !ok {
+		return 0, errors.New("store does not support restoring users")
+	}
+
+	if sl.buffer != nil {
+		// The buffered writes of the user must reach the store before it is read
+		sl.buffer.mutex.Lock()
+		defer sl.buffer.mutex.Unlock()
+		if err := sl.flushLocked(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	stored, err := sl.db.GetUserSearches(ctx, userIdentifier)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the searches of %s: %w", userIdentifier, store.Classify(err))
+	}
+	kept := make(map[string]bool, len(stored))
+	for _, word := range stored {
+		kept[word] = true
+	}
+
+	writes := make([]store.UserSearchWrite, 0, len(searches))
+	for _, search := range searches {
+		if search.Word == "" || kept[search.Word] {
+			continue
+		}
+		kept[search.Word] = true
+		writes = append(writes, store.UserSearchWrite{
+			UserIdentifier:  userIdentifier,
+			Word:            search.Word,
+			FirstSearchedAt: search.FirstSearchedAt,
+			LastUpdatedAt:   search.LastUpdatedAt,
+			Count:           max(search.SearchCount, 1),
+			SurfaceWord:     search.SurfaceWord,
+		})
+	}
+	if len(writes) == 0 {
+		return 0, nil
+	}
+
+	err = batchStore.ApplyUserSearchWrites(ctx, writes)
+	sl.cache.invalidate(userIdentifier)
+	sl.invalidateResults(ctx, userIdentifier)
+	if err != nil {
+		return 0, fmt.Errorf("failed to restore the searches of %s: %w", userIdentifier, store.Classify(err))
+	}
+
+	surfaceStore, _ := sl.db.(store.UserSurfaceStore)
+	adds := make([]store.AudienceAdd, len(writes))
+	for i, write := range writes {
+		if surfaceStore != nil && write.SurfaceWord != "" {
+			if err := surfaceStore.SetSurfaceWord(ctx, userIdentifier, write.Word, write.SurfaceWord); err != nil {
+				log.Printf("Error setting surface '%s' of user search '%s': %v", write.SurfaceWord, write.Word, err)
+			}
+		}
+		adds[i] = store.AudienceAdd{Word: write.Word, UserIdentifier: userIdentifier, At: write.LastUpdatedAt}
+		sl.counted(write.Word, int64(write.Count), write.LastUpdatedAt)
+	}
+	sl.countAudience(ctx, adds)
+	sl.enforceQuota(ctx, userIdentifier)
+	return len(writes), nil
+}
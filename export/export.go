@@ -0,0 +1,160 @@
+// Package export dumps the searches table to CSV or Parquet files for
+// analytics handoffs. The records are streamed from the store and written as
+// they arrive, so tables of millions of rows do not have to fit in memory.
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/afanwang/logsearch/encrypt"
+	"github.com/afanwang/logsearch/store"
+)
+
+// Format is the file format of an export
+type Format string
+
+const (
+	// CSV writes a header line with the column names, then one line per record
+	CSV Format = "csv"
+	// Parquet writes a columnar Parquet file, in row groups of RowGroupSize records
+	Parquet Format = "parquet"
+)
+
+// ParseFormat returns the Format named s, case insensitively
+func ParseFormat(s string) (Format, error) {
+	switch format := Format(strings.ToLower(s)); format {
+	case CSV, Parquet:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown export format %q, want csv or parquet", s)
+}
+
+// Columns are the columns of the searches table that can be exported, all by default
+var Columns = []string{"id", "word", "first_searched_at", "last_updated_at", "search_count", "verified"}
+
+// RowGroupSize is the number of records per Parquet row group, the records
+// buffered in memory before they are written out
+const RowGroupSize = 100000
+
+// Options selects what an export writes
+type Options struct {
+	Format Format
+	// Columns are written in this order, nil writes all of Columns
+	Columns []string
+	// Query filters and orders the records, e.g. by a time range with Since and Until
+	Query store.SearchQuery
+	// Cipher encrypts the file, read back through Cipher.NewReader, nil writes it in clear
+	Cipher *encrypt.Cipher
+}
+
+// rowWriter writes records in a file format
+type rowWriter interface {
+	write(record store.SearchRecord) error
+	close() error
+}
+
+// Searches streams the records of the searches table matching opts to w and
+// returns how many it wrote. The times are written in UTC.
+func Searches(ctx context.Context, db store.SearchExportStore, w io.Writer, opts Options) (int64, error) {
+	columns := opts.Columns
+	if len(columns) == 0 {
+		columns = Columns
+	}
+	for _, column := range columns {
+		if !isColumn(column) {
+			return 0, fmt.Errorf("unknown column %q, want one of %s", column, strings.Join(Columns, ", "))
+		}
+	}
+
+	var encrypted io.WriteCloser
+	if opts.Cipher != nil {
+		encrypted = opts.Cipher.NewWriter(w)
+		w = encrypted
+	}
+
+	var rw rowWriter
+	switch opts.Format {
+	case CSV:
+		cw, err := newCSVWriter(w, columns)
+		if err != nil {
+			return 0, err
+		}
+		rw = cw
+	case Parquet:
+		rw = newParquetWriter(w, columns)
+	default:
+		return 0, fmt.Errorf("unknown export format %q", opts.Format)
+	}
+
+	var written int64
+	err := db.ForEachSearch(ctx, opts.Query, func(record store.SearchRecord) error {
+		if err := rw.write(record); err != nil {
+			return err
+		}
+		written++
+		return nil
+	})
+	if err != nil {
+		return written, fmt.Errorf("failed to export searches: %w", store.Classify(err))
+	}
+	if err := rw.close(); err != nil {
+		return written, err
+	}
+	if encrypted != nil {
+		return written, encrypted.Close()
+	}
+	return written, nil
+}
+
+// isColumn reports whether column is one of Columns
+func isColumn(column string) bool {
+	for _, c := range Columns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// csvWriter writes the records as CSV
+type csvWriter struct {
+	w       *csv.Writer
+	columns []string
+	line    []string
+}
+
+func newCSVWriter(w io.Writer, columns []string) (*csvWriter, error) {
+	cw := &csvWriter{w: csv.NewWriter(w), columns: columns, line: make([]string, len(columns))}
+	return cw, cw.w.Write(columns)
+}
+
+func (cw *csvWriter) write(record store.SearchRecord) error {
+	for i, column := range cw.columns {
+		switch column {
+		case "id":
+			cw.line[i] = strconv.FormatInt(record.ID, 10)
+		case "word":
+			cw.line[i] = record.Word
+		case "first_searched_at":
+			cw.line[i] = record.FirstSearchedAt.UTC().Format(time.RFC3339Nano)
+		case "last_updated_at":
+			cw.line[i] = record.LastUpdatedAt.UTC().Format(time.RFC3339Nano)
+		case "search_count":
+			cw.line[i] = strconv.Itoa(record.SearchCount)
+		case "verified":
+			cw.line[i] = strconv.FormatBool(record.Verified)
+		}
+	}
+	return cw.w.Write(cw.line)
+}
+
+func (cw *csvWriter) close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
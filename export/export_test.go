@@ -0,0 +1,120 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/afanwang/logsearch/encrypt"
+	"github.com/afanwang/logsearch/store"
+)
+
+// newStore returns a store with "bus" searched twice and "cat" once, an hour later
+func newStore(t *testing.T) (*store.MockPostgresDB, time.Time) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDB()
+	t0 := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for _, search := range []struct {
+		word string
+		at   time.Time
+	}{
+		{"bus", t0},
+		{"bus", t0},
+		{"cat", t0.Add(time.Hour)},
+	} {
+		_, err := db.InsertOrReplace(ctx, search.word, search.at, search.at)
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.SetVerified(ctx, "cat", true))
+	return db, t0
+}
+
+func TestSearchesCSV(t *testing.T) {
+	db, t0 := newStore(t)
+
+	var out bytes.Buffer
+	written, err := Searches(context.Background(), db, &out, Options{Format: CSV})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), written)
+	assert.Equal(t, "id,word,first_searched_at,last_updated_at,search_count,verified\n"+
+		"1,bus,2024-05-01T10:00:00Z,2024-05-01T10:00:00Z,2,false\n"+
+		"2,cat,2024-05-01T11:00:00Z,2024-05-01T11:00:00Z,1,true\n", out.String())
+
+	// Selected columns in their order, filtered by time range
+	out.Reset()
+	written, err = Searches(context.Background(), db, &out, Options{
+		Format:  CSV,
+		Columns: []string{"search_count", "word"},
+		Query:   store.SearchQuery{Since: t0.Add(30 * time.Minute)},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), written)
+	assert.Equal(t, "search_count,word\n1,cat\n", out.String())
+
+	_, err = Searches(context.Background(), db, &out, Options{Format: CSV, Columns: []string{"password"}})
+	assert.Error(t, err)
+	_, err = Searches(context.Background(), db, &out, Options{Format: "xml"})
+	assert.Error(t, err)
+}
+
+func TestSearchesEncrypted(t *testing.T) {
+	db, _ := newStore(t)
+	c, err := encrypt.New(bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	written, err := Searches(context.Background(), db, &out, Options{Format: CSV, Columns: []string{"word"}, Cipher: c})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), written)
+	assert.NotContains(t, out.String(), "bus")
+
+	plain, err := io.ReadAll(c.NewReader(&out))
+	require.NoError(t, err)
+	assert.Equal(t, "word\nbus\ncat\n", string(plain))
+}
+
+func TestSearchesParquet(t *testing.T) {
+	db, t0 := newStore(t)
+
+	var out bytes.Buffer
+	written, err := Searches(context.Background(), db, &out, Options{
+		Format:  Parquet,
+		Columns: []string{"word", "search_count", "last_updated_at", "verified"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), written)
+
+	type row struct {
+		Word          string    `parquet:"word"`
+		SearchCount   int64     `parquet:"search_count"`
+		LastUpdatedAt time.Time `parquet:"last_updated_at,timestamp(millisecond)"`
+		Verified      bool      `parquet:"verified"`
+	}
+	reader := parquet.NewGenericReader[row](bytes.NewReader(out.Bytes()))
+	defer reader.Close()
+	rows := make([]row, 3)
+	n, err := reader.Read(rows)
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, 2, n)
+	for i := range rows[:n] {
+		rows[i].LastUpdatedAt = rows[i].LastUpdatedAt.UTC()
+	}
+	assert.Equal(t, []row{
+		{Word: "bus", SearchCount: 2, LastUpdatedAt: t0},
+		{Word: "cat", SearchCount: 1, LastUpdatedAt: t0.Add(time.Hour), Verified: true},
+	}, rows[:n])
+}
+
+func TestParseFormat(t *testing.T) {
+	format, err := ParseFormat("Parquet")
+	assert.NoError(t, err)
+	assert.Equal(t, Parquet, format)
+	_, err = ParseFormat("xlsx")
+	assert.Error(t, err)
+}
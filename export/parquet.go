@@ -0,0 +1,77 @@
+package export
+
+import (
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// parquetColumns are the Parquet types of the columns
+var parquetColumns = map[string]parquet.Node{
+	"id":                parquet.Int(64),
+	"word":              parquet.String(),
+	"first_searched_at": parquet.Timestamp(parquet.Millisecond),
+	"last_updated_at":   parquet.Timestamp(parquet.Millisecond),
+	"search_count":      parquet.Int(64),
+	"verified":          parquet.Leaf(parquet.BooleanType),
+}
+
+// parquetWriter writes the records as a Parquet file, flushing a row group every RowGroupSize records
+type parquetWriter struct {
+	w *parquet.Writer
+	// fields are the columns in the order of the schema, which sorts them by name
+	fields []string
+	row    parquet.Row
+	rows   int
+}
+
+func newParquetWriter(w io.Writer, columns []string) *parquetWriter {
+	group := make(parquet.Group, len(columns))
+	for _, column := range columns {
+		group[column] = parquetColumns[column]
+	}
+	schema := parquet.NewSchema("searches", group)
+
+	pw := &parquetWriter{w: parquet.NewWriter(w, schema)}
+	for _, field := range schema.Fields() {
+		pw.fields = append(pw.fields, field.Name())
+	}
+	pw.row = make(parquet.Row, len(pw.fields))
+	return pw
+}
+
+func (pw *parquetWriter) write(record store.SearchRecord) error {
+	for i, field := range pw.fields {
+		var value parquet.Value
+		switch field {
+		case "id":
+			value = parquet.Int64Value(record.ID)
+		case "word":
+			value = parquet.ByteArrayValue([]byte(record.Word))
+		case "first_searched_at":
+			value = parquet.Int64Value(record.FirstSearchedAt.UnixMilli())
+		case "last_updated_at":
+			value = parquet.Int64Value(record.LastUpdatedAt.UnixMilli())
+		case "search_count":
+			value = parquet.Int64Value(int64(record.SearchCount))
+		case "verified":
+			value = parquet.BooleanValue(record.Verified)
+		}
+		pw.row[i] = value.Level(0, 0, i)
+	}
+	if _, err := pw.w.WriteRows([]parquet.Row{pw.row}); err != nil {
+		return err
+	}
+
+	pw.rows++
+	if pw.rows%RowGroupSize == 0 {
+		return pw.w.Flush()
+	}
+	return nil
+}
+
+func (pw *parquetWriter) close() error {
+	return pw.w.Close()
+}
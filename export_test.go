@@ -0,0 +1,141 @@
+package logsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchLoggerV2_ExportUserSearches(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDBV2()
+	first := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	last := first.Add(time.Hour)
+	for _, word := range []string{"cat", "bus", "cat"} {
+		_, err := db.InsertOrUpdateUserSearch(ctx, "user_1", word, first, last)
+		require.NoError(t, err)
+	}
+	_, err := db.InsertOrUpdateUserSearch(ctx, "user_2", "dog", first, last)
+	require.NoError(t, err)
+
+	logger, err := NewSearchLoggerV2WithDB(db)
+	require.NoError(t, err)
+	defer logger.Close()
+
+	var out bytes.Buffer
+	require.NoError(t, logger.ExportUserSearches(ctx, "user_1", ExportJSONLines, &out))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.JSONEq(t, `{"word":"bus","first_searched_at":"2024-05-01T10:00:00Z","last_updated_at":"2024-05-01T11:00:00Z","search_count":1}`, lines[0])
+
+	var search ExportedSearch
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &search))
+	assert.Equal(t, ExportedSearch{Word: "cat", FirstSearchedAt: first, LastUpdatedAt: last, SearchCount: 2}, search)
+
+	out.Reset()
+	require.NoError(t, logger.ExportUserSearches(ctx, "user_1", ExportCSV, &out))
+	assert.Equal(t, "word,first_searched_at,last_updated_at,search_count,surface_word\n"+
+		"bus,2024-05-01T10:00:00Z,2024-05-01T11:00:00Z,1,\n"+
+		"cat,2024-05-01T10:00:00Z,2024-05-01T11:00:00Z,2,\n", out.String())
+
+	// Unknown users export nothing
+	out.Reset()
+	require.NoError(t, logger.ExportUserSearches(ctx, "user_3", ExportJSONLines, &out))
+	assert.Empty(t, out.String())
+
+	assert.Error(t, logger.ExportUserSearches(ctx, "user_1", ExportFormat("xml"), &out))
+}
+
+func TestSearchLoggerV2_GetUserSearchRecords(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDBV2()
+	first := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	last := first.Add(time.Hour)
+	for _, word := range []string{"cat", "bus", "cat"} {
+		_, err := db.InsertOrUpdateUserSearch(ctx, "user_1", word, first, last)
+		require.NoError(t, err)
+	}
+
+	logger, err := NewSearchLoggerV2WithDB(db)
+	require.NoError(t, err)
+	defer logger.Close()
+
+	records, err := logger.GetUserSearchRecords(ctx, "user_1")
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	for i := range records {
+		records[i].ID = 0
+	}
+	assert.Equal(t, []store.UserSearchRecord{
+		{UserIdentifier: "user_1", SearchWord: "bus", FirstSearchedAt: first, LastUpdatedAt: last, SearchCount: 1},
+		{UserIdentifier: "user_1", SearchWord: "cat", FirstSearchedAt: first, LastUpdatedAt: last, SearchCount: 2},
+	}, records)
+
+	records, err = logger.GetUserSearchRecords(ctx, "user_2")
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestParseExportFormat(t *testing.T) {
+	format, err := ParseExportFormat("csv")
+	assert.NoError(t, err)
+	assert.Equal(t, ExportCSV, format)
+
+	_, err = ParseExportFormat("xml")
+	assert.Error(t, err)
+}
+
+func TestSearchLoggerV2_RestoreUserSearches(t *testing.T) {
+	ctx := context.Background()
+	first := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	last := first.Add(time.Hour)
+	source, err := NewSearchLoggerV2()
+	require.NoError(t, err)
+	defer source.Close()
+	for _, word := range []string{"cat", "bus", "cat"} {
+		_, err := source.db.InsertOrUpdateUserSearch(ctx, "user_1", word, first, last)
+		require.NoError(t, err)
+	}
+
+	var export bytes.Buffer
+	require.NoError(t, source.ExportUserSearches(ctx, "user_1", ExportJSONLines, &export))
+	var searches []ExportedSearch
+	decoder := json.NewDecoder(&export)
+	for decoder.More() {
+		var search ExportedSearch
+		require.NoError(t, decoder.Decode(&search))
+		searches = append(searches, search)
+	}
+
+	target, err := NewSearchLoggerV2(WithWriteBuffer(100, time.Hour))
+	require.NoError(t, err)
+	defer target.Close()
+	require.NoError(t, target.LogSearchV2(ctx, "user_1", "dog"))
+
+	restored, err := target.RestoreUserSearches(ctx, "user_1", searches)
+	require.NoError(t, err)
+	assert.Equal(t, 2, restored)
+	records, err := target.GetUserSearchRecords(ctx, "user_1")
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, "cat", records[1].SearchWord)
+	assert.Equal(t, 2, records[1].SearchCount)
+	assert.True(t, first.Equal(records[1].FirstSearchedAt))
+	assert.Equal(t, 1, searchCount(t, target, "user_1", "dog"), "the buffered search was flushed first")
+
+	// A retried restore keeps the restored records
+	restored, err = target.RestoreUserSearches(ctx, "user_1", searches)
+	require.NoError(t, err)
+	assert.Equal(t, 0, restored)
+	assert.Equal(t, 2, searchCount(t, target, "user_1", "cat"))
+
+	_, err = target.RestoreUserSearches(ctx, "", searches)
+	assert.ErrorIs(t, err, ErrEmptyUser)
+}
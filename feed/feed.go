@@ -0,0 +1,298 @@
+// Package feed streams the words finalized by the loggers to HTTP clients as
+// Server-Sent Events, so dashboards and moderation tools can watch new
+// vocabulary arrive live. A Feed is registered as a hook of the loggers and
+// served as an http.Handler, e.g. consumed by a browser EventSource.
+package feed
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/afanwang/logsearch"
+)
+
+// Event is a finalized word as streamed to the clients, the data of an SSE message
+type Event struct {
+	// ID increases with every event, it is the id of the SSE message
+	ID   uint64 `json:"id"`
+	Word string `json:"word"`
+	// UserIdentifier is the user who searched the word, empty for the global trie logger
+	UserIdentifier string `json:"user_identifier,omitempty"`
+	// Count is the number of searches of the word finalized since the feed started
+	Count int `json:"count"`
+	// Users is the number of distinct users of these searches
+	Users int       `json:"users"`
+	At    time.Time `json:"at"`
+}
+
+// wordStats are the totals of a word since the feed started
+type wordStats struct {
+	word  string
+	count int
+	users map[string]struct{}
+}
+
+// Feed broadcasts the finalized words to the connected clients. It is safe for concurrent use.
+type Feed struct {
+	maxWords   int
+	bufferSize int
+	heartbeat  time.Duration
+
+	mutex sync.Mutex
+	// lru holds the *wordStats of the tracked words, most recently finalized at the front
+	lru   *list.List
+	words map[string]*list.Element
+	// history is a ring of the last events, replayed to reconnecting clients
+	history     []Event
+	historySize int
+	lastID      uint64
+	subscribers map[chan Event]struct{}
+	closed      bool
+}
+
+// Option configures optional Feed behavior
+type Option func(*Feed)
+
+// WithMaxWords tracks the totals of at most n words, 10000 by default. The
+// totals of the least recently finalized word are forgotten beyond, it counts
+// anew when finalized again.
+func WithMaxWords(n int) Option {
+	return func(f *Feed) {
+		if n > 0 {
+			f.maxWords = n
+		}
+	}
+}
+
+// WithHistory keeps the last n events, 100 by default, so a client
+// reconnecting with the Last-Event-ID header receives those it missed
+func WithHistory(n int) Option {
+	return func(f *Feed) {
+		if n > 0 {
+			f.historySize = n
+		}
+	}
+}
+
+// WithBufferSize buffers up to n events per client, 100 by default. A client
+// falling further behind is disconnected rather than slowing the loggers
+// down, and catches up from the history when it reconnects.
+func WithBufferSize(n int) Option {
+	return func(f *Feed) {
+		if n > 0 {
+			f.bufferSize = n
+		}
+	}
+}
+
+// WithHeartbeat sends an SSE comment to idle clients every interval, 15s by
+// default, so proxies keep the connections open
+func WithHeartbeat(interval time.Duration) Option {
+	return func(f *Feed) {
+		if interval > 0 {
+			f.heartbeat = interval
+		}
+	}
+}
+
+// New creates a Feed, stop it with Close
+func New(opts ...Option) *Feed {
+	f := &Feed{
+		maxWords:    10000,
+		bufferSize:  100,
+		heartbeat:   15 * time.Second,
+		historySize: 100,
+		lru:         list.New(),
+		words:       make(map[string]*list.Element),
+		subscribers: make(map[chan Event]struct{}),
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// Hook returns the hook feeding the clients, register it with
+// logsearch.WithWordFinalizedHook and trie.WithWordFinalizedHook
+func (f *Feed) Hook() logsearch.WordFinalizedHook {
+	return f.publish
+}
+
+// publish updates the totals of a finalized word and broadcasts its event
+func (f *Feed) publish(word logsearch.FinalizedWord) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.closed {
+		return
+	}
+
+	stats := f.stats(word.Word)
+	stats.count += word.Count
+	if word.UserIdentifier != "" {
+		stats.users[word.UserIdentifier] = struct{}{}
+	}
+
+	f.lastID++
+	event := Event{
+		ID:             f.lastID,
+		Word:           word.Word,
+		UserIdentifier: word.UserIdentifier,
+		Count:          stats.count,
+		Users:          len(stats.users),
+		At:             word.At.UTC(),
+	}
+	if len(f.history) < f.historySize {
+		f.history = append(f.history, event)
+	} else {
+		f.history[int((event.ID-1)%uint64(f.historySize))] = event
+	}
+
+	for events := range f.subscribers {
+		select {
+		case events <- event:
+		default:
+			// Too slow, it catches up from the history once reconnected
+			delete(f.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// stats returns the totals of word, tracking it if needed, caller must hold the mutex
+func (f *Feed) stats(word string) *wordStats {
+	if elem, ok := f.words[word]; ok {
+		f.lru.MoveToFront(elem)
+		return elem.Value.(*wordStats)
+	}
+
+	if f.lru.Len() >= f.maxWords {
+		oldest := f.lru.Back()
+		f.lru.Remove(oldest)
+		delete(f.words, oldest.Value.(*wordStats).word)
+	}
+	stats := &wordStats{word: word, users: make(map[string]struct{})}
+	f.words[word] = f.lru.PushFront(stats)
+	return stats
+}
+
+// subscribe registers a client, returning the events of the history after
+// lastID, all of them when lastID is 0, and false once the feed is closed
+func (f *Feed) subscribe(lastID uint64) (chan Event, []Event, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.closed {
+		return nil, nil, false
+	}
+
+	// The history is ordered by ID once rotated to start at its oldest event
+	start := 0
+	if len(f.history) == f.historySize {
+		start = int(f.lastID % uint64(f.historySize))
+	}
+	var missed []Event
+	for i := range f.history {
+		if event := f.history[(start+i)%len(f.history)]; lastID > 0 && event.ID > lastID {
+			missed = append(missed, event)
+		}
+	}
+
+	events := make(chan Event, f.bufferSize)
+	f.subscribers[events] = struct{}{}
+	return events, missed, true
+}
+
+// unsubscribe removes a client, unless the feed already did
+func (f *Feed) unsubscribe(events chan Event) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, ok := f.subscribers[events]; ok {
+		delete(f.subscribers, events)
+		close(events)
+	}
+}
+
+// ServeHTTP streams the events as Server-Sent Events until the client leaves
+// or the feed is closed. Only the events finalized after the client connected
+// are sent, unless it reconnects with the Last-Event-ID header.
+func (f *Feed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// A malformed id replays nothing, like a first connection
+	lastID, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+
+	events, missed, ok := f.subscribe(lastID)
+	if !ok {
+		http.Error(w, "feed is closed", http.StatusServiceUnavailable)
+		return
+	}
+	defer f.unsubscribe(events)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for _, event := range missed {
+		if err := writeEvent(w, event); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(f.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			err = writeEvent(w, event)
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes an event as an SSE message
+func writeEvent(w http.ResponseWriter, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", event.ID, data)
+	return err
+}
+
+// Close ends the streams of the connected clients and refuses new ones, the
+// HTTP server can only shut down gracefully once they are ended
+func (f *Feed) Close() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.closed {
+		return
+	}
+	f.closed = true
+	for events := range f.subscribers {
+		delete(f.subscribers, events)
+		close(events)
+	}
+}
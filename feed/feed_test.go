@@ -0,0 +1,158 @@
+package feed
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// client reads the SSE messages of a feed
+type client struct {
+	resp    *http.Response
+	scanner *bufio.Scanner
+}
+
+// connect opens a stream, resuming after lastID if not empty
+func connect(t *testing.T, ctx context.Context, url, lastID string) *client {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	require.NoError(t, err)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	return &client{resp: resp, scanner: bufio.NewScanner(resp.Body)}
+}
+
+// next returns the next event, skipping the heartbeats, false once the stream ended
+func (c *client) next(t *testing.T) (Event, bool) {
+	t.Helper()
+	var event Event
+	for c.scanner.Scan() {
+		line := c.scanner.Text()
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			require.NoError(t, json.Unmarshal([]byte(data), &event))
+		}
+		if line == "" && event.ID > 0 {
+			return event, true
+		}
+	}
+	return Event{}, false
+}
+
+func TestFeedStreamsFinalizedWords(t *testing.T) {
+	f := New(WithHeartbeat(time.Millisecond))
+	server := httptest.NewServer(f)
+	defer server.Close()
+	defer f.Close()
+
+	c := connect(t, context.Background(), server.URL, "")
+	hook := f.Hook()
+	at := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	hook(logsearch.FinalizedWord{UserIdentifier: "user_1", Word: "cat", Count: 2, At: at})
+	hook(logsearch.FinalizedWord{UserIdentifier: "user_2", Word: "cat", Count: 1, At: at})
+	hook(logsearch.FinalizedWord{UserIdentifier: "user_1", Word: "cat", Count: 1, At: at})
+	hook(logsearch.FinalizedWord{Word: "dog", Count: 1, At: at})
+
+	var events []Event
+	for len(events) < 4 {
+		event, ok := c.next(t)
+		require.True(t, ok)
+		events = append(events, event)
+	}
+	assert.Equal(t, []Event{
+		{ID: 1, Word: "cat", UserIdentifier: "user_1", Count: 2, Users: 1, At: at},
+		{ID: 2, Word: "cat", UserIdentifier: "user_2", Count: 3, Users: 2, At: at},
+		{ID: 3, Word: "cat", UserIdentifier: "user_1", Count: 4, Users: 2, At: at},
+		{ID: 4, Word: "dog", Count: 1, Users: 0, At: at},
+	}, events)
+}
+
+func TestFeedReplaysMissedEvents(t *testing.T) {
+	f := New(WithHistory(3))
+	server := httptest.NewServer(f)
+	defer server.Close()
+	defer f.Close()
+
+	hook := f.Hook()
+	for _, word := range []string{"a", "b", "c", "d", "e"} {
+		hook(logsearch.FinalizedWord{Word: word, Count: 1})
+	}
+
+	// Events 4 and 5 were missed, 3 is the oldest one kept
+	c := connect(t, context.Background(), server.URL, "3")
+	for _, want := range []string{"d", "e"} {
+		event, ok := c.next(t)
+		require.True(t, ok)
+		assert.Equal(t, want, event.Word)
+	}
+	c = connect(t, context.Background(), server.URL, "1")
+	for _, want := range []string{"c", "d", "e"} {
+		event, ok := c.next(t)
+		require.True(t, ok)
+		assert.Equal(t, want, event.Word)
+	}
+}
+
+func TestFeedForgetsLeastRecentWords(t *testing.T) {
+	f := New(WithMaxWords(2))
+	defer f.Close()
+	events, _, ok := f.subscribe(0)
+	require.True(t, ok)
+
+	hook := f.Hook()
+	for _, word := range []string{"a", "b", "a", "c", "b", "a"} {
+		hook(logsearch.FinalizedWord{Word: word, Count: 1})
+	}
+	var counts []int
+	for i := 0; i < 6; i++ {
+		counts = append(counts, (<-events).Count)
+	}
+	// "b" is forgotten when "c" arrives, then "a" when "b" returns
+	assert.Equal(t, []int{1, 1, 2, 1, 1, 1}, counts)
+}
+
+func TestFeedDisconnectsSlowClients(t *testing.T) {
+	f := New(WithBufferSize(1))
+	defer f.Close()
+	events, _, ok := f.subscribe(0)
+	require.True(t, ok)
+
+	hook := f.Hook()
+	hook(logsearch.FinalizedWord{Word: "a", Count: 1})
+	hook(logsearch.FinalizedWord{Word: "b", Count: 1})
+	event, ok := <-events
+	assert.True(t, ok)
+	assert.Equal(t, "a", event.Word)
+	_, ok = <-events
+	assert.False(t, ok)
+}
+
+func TestFeedClose(t *testing.T) {
+	f := New()
+	server := httptest.NewServer(f)
+	defer server.Close()
+
+	c := connect(t, context.Background(), server.URL, "")
+	f.Close()
+	_, ok := c.next(t)
+	assert.False(t, ok)
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
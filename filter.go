@@ -0,0 +1,74 @@
+package logsearch
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Filter decides whether a normalized word is eligible for storage, e.g. to
+// keep the single letters of abandoned searches out of the store. It must be
+// safe for concurrent use.
+type Filter interface {
+	Allow(word string) bool
+}
+
+// FilterFunc adapts a plain function to a Filter
+type FilterFunc func(word string) bool
+
+// Allow calls f
+func (f FilterFunc) Allow(word string) bool {
+	return f(word)
+}
+
+// MinLength allows the words of at least n characters
+func MinLength(n int) Filter {
+	return FilterFunc(func(word string) bool {
+		return utf8.RuneCountInString(word) >= n
+	})
+}
+
+// StopWords rejects the given words, e.g. "the" or "and". They are trimmed and
+// lowercased like the default normalizer does.
+func StopWords(words ...string) Filter {
+	stop := make(map[string]struct{}, len(words))
+	for _, word := range words {
+		stop[strings.ToLower(strings.TrimSpace(word))] = struct{}{}
+	}
+	return FilterFunc(func(word string) bool {
+		_, ok := stop[word]
+		return !ok
+	})
+}
+
+// Blocklist rejects the words matching any of patterns, e.g. regexp.MustCompile(`^\d+$`)
+func Blocklist(patterns ...*regexp.Regexp) Filter {
+	return FilterFunc(func(word string) bool {
+		for _, pattern := range patterns {
+			if pattern.MatchString(word) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Allowed reports whether word passes every filter
+func Allowed(filters []Filter, word string) bool {
+	for _, filter := range filters {
+		if !filter.Allow(word) {
+			return false
+		}
+	}
+	return true
+}
+
+// WithFilters keeps the words rejected by any of filters out of the store.
+// They apply to every search in StageFilter, so with WithFinalizeTimeout an
+// abandoned "b" is dropped while "bus" typed through it is stored, and a stored
+// word is not extended to a rejected one.
+func WithFilters(filters ...Filter) Option {
+	return func(sl *SearchLoggerV2) {
+		sl.filters = append(sl.filters, filters...)
+	}
+}
@@ -0,0 +1,67 @@
+package logsearch
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/afanwang/logsearch/denylist"
+	"github.com/afanwang/logsearch/normalize"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilters(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		word   string
+		want   bool
+	}{
+		{"allows long enough words", MinLength(2), "bu", true},
+		{"rejects short words", MinLength(2), "b", false},
+		{"counts characters not bytes", MinLength(2), "é", false},
+		{"rejects stop words", StopWords(" The ", "and"), "the", false},
+		{"allows other words", StopWords("the"), "there", true},
+		{"rejects blocked patterns", Blocklist(regexp.MustCompile(`\d`)), "bus9", false},
+		{"allows unblocked words", Blocklist(regexp.MustCompile(`\d`)), "bus", true},
+		{"rejects denylisted terms", denylist.New([]string{"darn"}), "oh darn", false},
+		{"allows other terms", denylist.New([]string{"darn"}), "bus", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.Allow(tt.word))
+		})
+	}
+
+	assert.True(t, Allowed(nil, "b"))
+}
+
+func TestSearchLoggerV2_Filters(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLoggerV2(WithFilters(MinLength(2), StopWords("the"), Blocklist(regexp.MustCompile(`\d`))))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	for _, word := range []string{"b", "The", "c", "ca", "cat", "cat9", "42"} {
+		assert.NoError(t, logger.LogSearchV2(ctx, "user_1", word))
+	}
+
+	// "cat" is not extended to the blocked "cat9"
+	searches, err := logger.GetUserSearches(ctx, "user_1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cat"}, searches)
+}
+
+func TestSearchLoggerV2_DenylistMasking(t *testing.T) {
+	ctx := context.Background()
+	d := denylist.New([]string{"darn"})
+	logger, err := NewSearchLoggerV2(WithNormalizer(d.Masking(normalize.Default)))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "Darn it"))
+	searches, err := logger.GetUserSearches(ctx, "user_1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"**** it"}, searches)
+}
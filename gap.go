@@ -0,0 +1,110 @@
+package logsearch
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// sessionGaps splits the searches of each user into typing sessions separated
+// by an inactivity gap, and remembers the words each user searched in their
+// current session. A nil *sessionGaps puts every search in one endless session.
+type sessionGaps struct {
+	mutex sync.Mutex
+	gap   time.Duration
+	users map[string]*gapSession
+	// swept is the number of users after the last sweep of ended sessions
+	swept int
+}
+
+// gapSession is the current typing session of a user
+type gapSession struct {
+	lastSeen time.Time
+	words    map[string]bool
+	// last is the word of the latest search that was no prefix of the one
+	// before, i.e. the tip of the branch being typed, see WithBranchDetection
+	last string
+}
+
+// WithSessionGap only consolidates a search with the stored words the user
+// searched in the same typing session, a session ending after gap without
+// searches. "cat" then "cats" typed in one session store "cats", while "cats"
+// searched the next day is stored next to "cat" and "cat" searched again the
+// next day counts one more search of "cat". Sessions are tracked in memory,
+// so like WithUserCache this assumes sticky routing of the users.
+func WithSessionGap(gap time.Duration) Option {
+	return func(sl *SearchLoggerV2) {
+		if gap > 0 {
+			sl.gaps = &sessionGaps{gap: gap, users: make(map[string]*gapSession)}
+		}
+	}
+}
+
+// enter records a search of word at at and returns the words of sorted the
+// user searched in the session of at, starting a new session after the gap,
+// and the word of the previous search of the session, empty if none
+func (g *sessionGaps) enter(userIdentifier, word string, sorted []string, at time.Time) ([]string, string) {
+	if g == nil {
+		return sorted, ""
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	session := g.users[userIdentifier]
+	if session == nil || at.Sub(session.lastSeen) > g.gap {
+		g.sweepLocked(at)
+		session = &gapSession{words: make(map[string]bool)}
+		g.users[userIdentifier] = session
+	}
+	session.lastSeen = latest(session.lastSeen, at)
+
+	current := make([]string, 0, len(session.words))
+	for _, stored := range sorted {
+		if session.words[stored] {
+			current = append(current, stored)
+		}
+	}
+	session.words[word] = true
+	previous := session.last
+	if !strings.HasPrefix(previous, word) {
+		session.last = word
+	}
+	return current, previous
+}
+
+// enterSession records a search of word at at in the session of its user and
+// returns the words of sorted it consolidates with and the previous word of the
+// session. A late search is left out of the session and consolidates with
+// every word, without previous word so it never corrects a branch.
+func (sl *SearchLoggerV2) enterSession(userIdentifier, word string, sorted []string, at time.Time, late bool) ([]string, string) {
+	if late {
+		return sorted, ""
+	}
+	return sl.gaps.enter(userIdentifier, word, sorted, at)
+}
+
+// sweepLocked drops the sessions ended at now once the users doubled since the
+// last sweep, keeping the cost amortized, caller must hold the mutex
+func (g *sessionGaps) sweepLocked(now time.Time) {
+	if len(g.users) < 2*g.swept {
+		return
+	}
+	for userIdentifier, session := range g.users {
+		if now.Sub(session.lastSeen) > g.gap {
+			delete(g.users, userIdentifier)
+		}
+	}
+	g.swept = max(len(g.users), 1)
+}
+
+// forget ends the session of a user
+func (g *sessionGaps) forget(userIdentifier string) {
+	if g == nil {
+		return
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	delete(g.users, userIdentifier)
+}
@@ -0,0 +1,58 @@
+package logsearch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchLoggerV2_SessionGap(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts []Option
+	}{
+		{"direct", nil},
+		{"cached", []Option{WithUserCache(100)}},
+		{"buffered", []Option{WithWriteBuffer(100, time.Hour)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := store.NewMockPostgresDBV2()
+			logger, err := NewSearchLoggerV2WithDB(db, append([]Option{WithSessionGap(30 * time.Minute)}, tt.opts...)...)
+			require.NoError(t, err)
+			defer logger.Close()
+
+			morning := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+			night := morning.Add(12 * time.Hour)
+			for _, search := range []struct {
+				word string
+				at   time.Time
+			}{
+				{"c", morning},
+				{"ca", morning.Add(time.Second)},
+				{"cat", morning.Add(2 * time.Second)},
+				// Within the gap the session goes on
+				{"cats", morning.Add(20 * time.Minute)},
+				{"cat", night},
+				{"ca", night.Add(time.Second)},
+				{"cats", night.Add(24 * time.Hour)},
+			} {
+				require.NoError(t, logger.storeOrExtendUserSearch(ctx, "user_1", search.word, search.at, false, store.Region{}))
+			}
+			require.NoError(t, logger.Flush(ctx))
+
+			counts := make(map[string]int)
+			require.NoError(t, db.ForEachUserSearch(ctx, "user_1", func(record store.UserSearchRecord) error {
+				counts[record.SearchWord] = record.SearchCount
+				return nil
+			}))
+			// The morning consolidated into "cats", the night stored "cat" apart
+			// and ignored its prefix, the next night counted "cats" once more
+			assert.Equal(t, map[string]int{"cats": 5, "cat": 1}, counts)
+		})
+	}
+}
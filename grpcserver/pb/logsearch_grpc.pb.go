@@ -0,0 +1,275 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: logsearch.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	SearchLogService_LogSearch_FullMethodName       = "/logsearch.v1.SearchLogService/LogSearch"
+	SearchLogService_LogSearchStream_FullMethodName = "/logsearch.v1.SearchLogService/LogSearchStream"
+	SearchLogService_GetUserSearches_FullMethodName = "/logsearch.v1.SearchLogService/GetUserSearches"
+	SearchLogService_Suggest_FullMethodName         = "/logsearch.v1.SearchLogService/Suggest"
+)
+
+// SearchLogServiceClient is the client API for SearchLogService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// SearchLogService logs per-user searches and serves autocomplete suggestions
+type SearchLogServiceClient interface {
+	// LogSearch logs a single search of a user
+	LogSearch(ctx context.Context, in *LogSearchRequest, opts ...grpc.CallOption) (*LogSearchResponse, error)
+	// LogSearchStream logs every search streamed by the client, for bulk
+	// keystroke ingestion from edge services, and reports the totals once the
+	// client closes the stream
+	LogSearchStream(ctx context.Context, opts ...grpc.CallOption) (SearchLogService_LogSearchStreamClient, error)
+	// GetUserSearches returns the searches of a user
+	GetUserSearches(ctx context.Context, in *GetUserSearchesRequest, opts ...grpc.CallOption) (*GetUserSearchesResponse, error)
+	// Suggest returns autocomplete suggestions for a prefix
+	Suggest(ctx context.Context, in *SuggestRequest, opts ...grpc.CallOption) (*SuggestResponse, error)
+}
+
+type searchLogServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSearchLogServiceClient(cc grpc.ClientConnInterface) SearchLogServiceClient {
+	return &searchLogServiceClient{cc}
+}
+
+func (c *searchLogServiceClient) LogSearch(ctx context.Context, in *LogSearchRequest, opts ...grpc.CallOption) (*LogSearchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LogSearchResponse)
+	err := c.cc.Invoke(ctx, SearchLogService_LogSearch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *searchLogServiceClient) LogSearchStream(ctx context.Context, opts ...grpc.CallOption) (SearchLogService_LogSearchStreamClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &SearchLogService_ServiceDesc.Streams[0], SearchLogService_LogSearchStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &searchLogServiceLogSearchStreamClient{ClientStream: stream}
+	return x, nil
+}
+
+type SearchLogService_LogSearchStreamClient interface {
+	Send(*LogSearchRequest) error
+	CloseAndRecv() (*LogSearchStreamResponse, error)
+	grpc.ClientStream
+}
+
+type searchLogServiceLogSearchStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *searchLogServiceLogSearchStreamClient) Send(m *LogSearchRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *searchLogServiceLogSearchStreamClient) CloseAndRecv() (*LogSearchStreamResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(LogSearchStreamResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *searchLogServiceClient) GetUserSearches(ctx context.Context, in *GetUserSearchesRequest, opts ...grpc.CallOption) (*GetUserSearchesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetUserSearchesResponse)
+	err := c.cc.Invoke(ctx, SearchLogService_GetUserSearches_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *searchLogServiceClient) Suggest(ctx context.Context, in *SuggestRequest, opts ...grpc.CallOption) (*SuggestResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SuggestResponse)
+	err := c.cc.Invoke(ctx, SearchLogService_Suggest_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SearchLogServiceServer is the server API for SearchLogService service.
+// All implementations must embed UnimplementedSearchLogServiceServer
+// for forward compatibility
+//
+// SearchLogService logs per-user searches and serves autocomplete suggestions
+type SearchLogServiceServer interface {
+	// LogSearch logs a single search of a user
+	LogSearch(context.Context, *LogSearchRequest) (*LogSearchResponse, error)
+	// LogSearchStream logs every search streamed by the client, for bulk
+	// keystroke ingestion from edge services, and reports the totals once the
+	// client closes the stream
+	LogSearchStream(SearchLogService_LogSearchStreamServer) error
+	// GetUserSearches returns the searches of a user
+	GetUserSearches(context.Context, *GetUserSearchesRequest) (*GetUserSearchesResponse, error)
+	// Suggest returns autocomplete suggestions for a prefix
+	Suggest(context.Context, *SuggestRequest) (*SuggestResponse, error)
+	mustEmbedUnimplementedSearchLogServiceServer()
+}
+
+// UnimplementedSearchLogServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedSearchLogServiceServer struct {
+}
+
+func (UnimplementedSearchLogServiceServer) LogSearch(context.Context, *LogSearchRequest) (*LogSearchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LogSearch not implemented")
+}
+func (UnimplementedSearchLogServiceServer) LogSearchStream(SearchLogService_LogSearchStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method LogSearchStream not implemented")
+}
+func (UnimplementedSearchLogServiceServer) GetUserSearches(context.Context, *GetUserSearchesRequest) (*GetUserSearchesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUserSearches not implemented")
+}
+func (UnimplementedSearchLogServiceServer) Suggest(context.Context, *SuggestRequest) (*SuggestResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Suggest not implemented")
+}
+func (UnimplementedSearchLogServiceServer) mustEmbedUnimplementedSearchLogServiceServer() {}
+
+// UnsafeSearchLogServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SearchLogServiceServer will
+// result in compilation errors.
+type UnsafeSearchLogServiceServer interface {
+	mustEmbedUnimplementedSearchLogServiceServer()
+}
+
+func RegisterSearchLogServiceServer(s grpc.ServiceRegistrar, srv SearchLogServiceServer) {
+	s.RegisterService(&SearchLogService_ServiceDesc, srv)
+}
+
+func _SearchLogService_LogSearch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LogSearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SearchLogServiceServer).LogSearch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SearchLogService_LogSearch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SearchLogServiceServer).LogSearch(ctx, req.(*LogSearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SearchLogService_LogSearchStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(SearchLogServiceServer).LogSearchStream(&searchLogServiceLogSearchStreamServer{ServerStream: stream})
+}
+
+type SearchLogService_LogSearchStreamServer interface {
+	SendAndClose(*LogSearchStreamResponse) error
+	Recv() (*LogSearchRequest, error)
+	grpc.ServerStream
+}
+
+type searchLogServiceLogSearchStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *searchLogServiceLogSearchStreamServer) SendAndClose(m *LogSearchStreamResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *searchLogServiceLogSearchStreamServer) Recv() (*LogSearchRequest, error) {
+	m := new(LogSearchRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _SearchLogService_GetUserSearches_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUserSearchesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SearchLogServiceServer).GetUserSearches(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SearchLogService_GetUserSearches_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SearchLogServiceServer).GetUserSearches(ctx, req.(*GetUserSearchesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SearchLogService_Suggest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SuggestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SearchLogServiceServer).Suggest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SearchLogService_Suggest_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SearchLogServiceServer).Suggest(ctx, req.(*SuggestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// SearchLogService_ServiceDesc is the grpc.ServiceDesc for SearchLogService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var SearchLogService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "logsearch.v1.SearchLogService",
+	HandlerType: (*SearchLogServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "LogSearch",
+			Handler:    _SearchLogService_LogSearch_Handler,
+		},
+		{
+			MethodName: "GetUserSearches",
+			Handler:    _SearchLogService_GetUserSearches_Handler,
+		},
+		{
+			MethodName: "Suggest",
+			Handler:    _SearchLogService_Suggest_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "LogSearchStream",
+			Handler:       _SearchLogService_LogSearchStream_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "logsearch.proto",
+}
@@ -0,0 +1,153 @@
+// Package grpcserver serves the search API over gRPC, mirroring the HTTP API of
+// the server package, with a client-streaming endpoint for edge services that
+// ingest keystrokes in bulk.
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/grpcserver/pb"
+	"github.com/afanwang/logsearch/server"
+)
+
+const (
+	defaultSuggestLimit = 10
+	maxSuggestLimit     = 100
+)
+
+// Server implements pb.SearchLogServiceServer on the same interfaces as the HTTP handler
+type Server struct {
+	pb.UnimplementedSearchLogServiceServer
+
+	logger    server.UserSearchLogger
+	suggester server.Suggester
+}
+
+// NewServer creates the gRPC service, suggester may be nil in which case
+// Suggest answers codes.Unimplemented
+func NewServer(logger server.UserSearchLogger, suggester server.Suggester) *Server {
+	return &Server{logger: logger, suggester: suggester}
+}
+
+// Register registers the service on a gRPC server
+func (s *Server) Register(g *grpc.Server) {
+	pb.RegisterSearchLogServiceServer(g, s)
+}
+
+// codeForError maps the sentinel errors of the loggers to a gRPC code,
+// codes.Unavailable tells clients that retrying later may succeed
+func codeForError(err error) codes.Code {
+	switch {
+	case err == nil:
+		return codes.OK
+	case errors.Is(err, logsearch.ErrEmptyWord), errors.Is(err, logsearch.ErrEmptyUser), errors.Is(err, logsearch.ErrWordTooLong),
+		errors.Is(err, logsearch.ErrInvalidInput), errors.Is(err, logsearch.ErrUnknownCategory):
+		return codes.InvalidArgument
+	case errors.Is(err, logsearch.ErrUserNotFound), errors.Is(err, logsearch.ErrWordNotFound):
+		return codes.NotFound
+	case errors.Is(err, logsearch.ErrWordExists):
+		return codes.AlreadyExists
+	case errors.Is(err, logsearch.ErrStoreUnavailable):
+		return codes.Unavailable
+	case errors.Is(err, logsearch.ErrQueueFull):
+		return codes.ResourceExhausted
+	}
+	return codes.Internal
+}
+
+// validLogRequest reports whether a search has both a user and a query
+func validLogRequest(req *pb.LogSearchRequest) bool {
+	return strings.TrimSpace(req.GetUserId()) != "" && strings.TrimSpace(req.GetQuery()) != ""
+}
+
+// LogSearch logs a single search of a user
+func (s *Server) LogSearch(ctx context.Context, req *pb.LogSearchRequest) (*pb.LogSearchResponse, error) {
+	if !validLogRequest(req) {
+		return nil, status.Error(codes.InvalidArgument, "user_id and query are required")
+	}
+
+	if err := s.logger.LogSearchV2(ctx, req.GetUserId(), req.GetQuery()); err != nil {
+		return nil, status.Error(codeForError(err), err.Error())
+	}
+
+	return &pb.LogSearchResponse{}, nil
+}
+
+// LogSearchStream logs the searches streamed by the client in order. Searches
+// missing a user or query, or rejected by the logger as invalid, are counted as
+// rejected instead of failing the whole stream, a failing logger aborts it.
+func (s *Server) LogSearchStream(stream pb.SearchLogService_LogSearchStreamServer) error {
+	var accepted, rejected int64
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&pb.LogSearchStreamResponse{Accepted: accepted, Rejected: rejected})
+		}
+		if err != nil {
+			return err
+		}
+
+		if !validLogRequest(req) {
+			rejected++
+			continue
+		}
+		err = s.logger.LogSearchV2(stream.Context(), req.GetUserId(), req.GetQuery())
+		if codeForError(err) == codes.InvalidArgument {
+			rejected++
+			continue
+		}
+		if err != nil {
+			return status.Errorf(codeForError(err), "search %d: %v", accepted+rejected+1, err)
+		}
+		accepted++
+	}
+}
+
+// GetUserSearches returns the searches of a user
+func (s *Server) GetUserSearches(ctx context.Context, req *pb.GetUserSearchesRequest) (*pb.GetUserSearchesResponse, error) {
+	if req.GetUserId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	searches, err := s.logger.GetUserSearches(ctx, req.GetUserId())
+	if err != nil {
+		return nil, status.Error(codeForError(err), err.Error())
+	}
+
+	return &pb.GetUserSearchesResponse{UserId: req.GetUserId(), Searches: searches}, nil
+}
+
+// Suggest returns autocomplete suggestions for a prefix, limit 0 means the default of 10
+func (s *Server) Suggest(ctx context.Context, req *pb.SuggestRequest) (*pb.SuggestResponse, error) {
+	if s.suggester == nil {
+		return nil, status.Error(codes.Unimplemented, "suggestions are not enabled")
+	}
+	if req.GetPrefix() == "" {
+		return nil, status.Error(codes.InvalidArgument, "prefix is required")
+	}
+
+	limit := int(req.GetLimit())
+	switch {
+	case limit < 0:
+		return nil, status.Error(codes.InvalidArgument, "limit must be a positive integer")
+	case limit == 0:
+		limit = defaultSuggestLimit
+	case limit > maxSuggestLimit:
+		limit = maxSuggestLimit
+	}
+
+	suggestions, err := s.suggester.Suggest(req.GetPrefix(), limit)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.SuggestResponse{Prefix: req.GetPrefix(), Suggestions: suggestions}, nil
+}
@@ -0,0 +1,157 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/grpcserver/pb"
+)
+
+type fakeLogger struct {
+	mutex    sync.Mutex
+	searches map[string][]string
+	err      error
+}
+
+func (f *fakeLogger) LogSearchV2(ctx context.Context, userIdentifier, word string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.searches[userIdentifier] = append(f.searches[userIdentifier], strings.ToLower(word))
+	return nil
+}
+
+func (f *fakeLogger) GetUserSearches(ctx context.Context, userIdentifier string) ([]string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.searches[userIdentifier], nil
+}
+
+type fakeSuggester struct{}
+
+func (fakeSuggester) Suggest(prefix string, limit int) ([]string, error) {
+	words := []string{prefix + "a", prefix + "b", prefix + "c"}
+	if limit < len(words) {
+		words = words[:limit]
+	}
+	return words, nil
+}
+
+// newClient serves s over an in-memory listener and returns a client connected to it
+func newClient(t *testing.T, s *Server) pb.SearchLogServiceClient {
+	listener := bufconn.Listen(1 << 20)
+	g := grpc.NewServer()
+	s.Register(g)
+	go g.Serve(listener)
+	t.Cleanup(g.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewSearchLogServiceClient(conn)
+}
+
+func TestServer_LogAndGetUserSearches(t *testing.T) {
+	ctx := context.Background()
+	client := newClient(t, NewServer(&fakeLogger{searches: map[string][]string{}}, nil))
+
+	_, err := client.LogSearch(ctx, &pb.LogSearchRequest{UserId: "user_1", Query: "Business"})
+	require.NoError(t, err)
+
+	resp, err := client.GetUserSearches(ctx, &pb.GetUserSearchesRequest{UserId: "user_1"})
+	require.NoError(t, err)
+	assert.Equal(t, "user_1", resp.GetUserId())
+	assert.Equal(t, []string{"business"}, resp.GetSearches())
+
+	_, err = client.LogSearch(ctx, &pb.LogSearchRequest{UserId: "user_1", Query: "  "})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.GetUserSearches(ctx, &pb.GetUserSearchesRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServer_LogSearchStream(t *testing.T) {
+	ctx := context.Background()
+	logger := &fakeLogger{searches: map[string][]string{}}
+	client := newClient(t, NewServer(logger, nil))
+
+	stream, err := client.LogSearchStream(ctx)
+	require.NoError(t, err)
+	for _, req := range []*pb.LogSearchRequest{
+		{UserId: "user_1", Query: "b"},
+		{UserId: "user_1", Query: "bu"},
+		{UserId: "", Query: "orphan"},
+		{UserId: "user_2", Query: "cat"},
+	} {
+		require.NoError(t, stream.Send(req))
+	}
+	resp, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), resp.GetAccepted())
+	assert.Equal(t, int64(1), resp.GetRejected())
+	assert.Equal(t, map[string][]string{"user_1": {"b", "bu"}, "user_2": {"cat"}}, logger.searches)
+
+	// Searches the logger rejects as invalid are counted too
+	logger.err = fmt.Errorf("%w: 2048 bytes", logsearch.ErrWordTooLong)
+	stream, err = client.LogSearchStream(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.LogSearchRequest{UserId: "user_1", Query: "bus"}))
+	resp, err = stream.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), resp.GetRejected())
+
+	// An unreachable store is worth retrying
+	logger.err = fmt.Errorf("failed to store user search: %w", logsearch.ErrStoreUnavailable)
+	_, err = client.LogSearch(ctx, &pb.LogSearchRequest{UserId: "user_1", Query: "bus"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	// A failing logger aborts the stream
+	logger.err = errors.New("store down")
+	stream, err = client.LogSearchStream(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.LogSearchRequest{UserId: "user_1", Query: "bus"}))
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestServer_Suggest(t *testing.T) {
+	ctx := context.Background()
+	client := newClient(t, NewServer(&fakeLogger{searches: map[string][]string{}}, fakeSuggester{}))
+
+	resp, err := client.Suggest(ctx, &pb.SuggestRequest{Prefix: "bu", Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bua", "bub"}, resp.GetSuggestions())
+
+	resp, err = client.Suggest(ctx, &pb.SuggestRequest{Prefix: "bu"})
+	require.NoError(t, err)
+	assert.Len(t, resp.GetSuggestions(), 3)
+
+	_, err = client.Suggest(ctx, &pb.SuggestRequest{Prefix: "bu", Limit: -1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Suggest(ctx, &pb.SuggestRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	client = newClient(t, NewServer(&fakeLogger{searches: map[string][]string{}}, nil))
+	_, err = client.Suggest(ctx, &pb.SuggestRequest{Prefix: "bu"})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
@@ -0,0 +1,37 @@
+package logsearch
+
+import (
+	"context"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// Ping checks the connection to the store, it succeeds for stores that cannot check it
+func (sl *SearchLoggerV2) Ping(ctx context.Context) error {
+	pinger, ok := sl.db.(store.Pinger)
+	if !ok {
+		return nil
+	}
+	return store.Classify(pinger.Ping(ctx))
+}
+
+// Heartbeat returns when the most lagging of the write buffer flushing and the
+// finalization routines last completed a round, the zero Time when neither
+// runs. A heartbeat older than a few of their intervals means a routine is
+// wedged, e.g. on a store call that never returns.
+func (sl *SearchLoggerV2) Heartbeat() time.Time {
+	var oldest int64
+	if sl.buffer != nil {
+		oldest = sl.buffer.heartbeat.Load()
+	}
+	if sl.sessions != nil {
+		if beat := sl.sessions.heartbeat.Load(); oldest == 0 || beat < oldest {
+			oldest = beat
+		}
+	}
+	if oldest == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, oldest)
+}
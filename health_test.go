@@ -0,0 +1,27 @@
+package logsearch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeartbeat(t *testing.T) {
+	logger, err := NewSearchLoggerV2()
+	require.NoError(t, err)
+	defer logger.Close()
+	assert.NoError(t, logger.Ping(context.Background()))
+	assert.True(t, logger.Heartbeat().IsZero())
+
+	// The finalization routine beating every 30m lags behind the flushing one
+	logger, err = NewSearchLoggerV2(WithWriteBuffer(100, 10*time.Millisecond), WithFinalizeTimeout(time.Hour))
+	require.NoError(t, err)
+	defer logger.Close()
+	started := logger.Heartbeat()
+	assert.False(t, started.IsZero())
+	assert.Eventually(t, func() bool { return logger.buffer.heartbeat.Load() > started.UnixNano() }, time.Second, time.Millisecond)
+	assert.Equal(t, time.Unix(0, logger.sessions.heartbeat.Load()), logger.Heartbeat())
+}
@@ -0,0 +1,54 @@
+package logsearch
+
+import "time"
+
+// FinalizedWord is a word written to the store, passed to the hooks of
+// WithWordFinalizedHook and trie.WithWordFinalizedHook
+type FinalizedWord struct {
+	// UserIdentifier is the user who searched the word, empty for the global trie logger
+	UserIdentifier string
+	Word           string
+	// ReplacedWords are the stored prefixes the search extended, now merged into the record of Word
+	ReplacedWords []string
+	// Count is how many searches the write added to the record of Word
+	Count int
+	// At is when the word was last searched
+	At time.Time
+}
+
+// WordFinalizedHook reacts to a word written to the store, e.g. to update
+// trending words, raise alerts or warm a search index. Hooks run synchronously
+// on the writing goroutine, possibly while the logger holds a lock, so they
+// must return quickly and must not call the logger. ChannelHook hands the
+// words off to another goroutine.
+type WordFinalizedHook func(FinalizedWord)
+
+// ChannelHook returns a hook sending every finalized word to ch without
+// blocking. Words are dropped while ch is full, so give it enough buffer for
+// the consumer to keep up.
+func ChannelHook(ch chan<- FinalizedWord) WordFinalizedHook {
+	return func(word FinalizedWord) {
+		select {
+		case ch <- word:
+		default:
+		}
+	}
+}
+
+// WithWordFinalizedHook calls hook after every word written to the store: a
+// new word, an extension of a stored word or, with WithWriteBuffer, every
+// coalesced write of a flush. It may be given several times to register several hooks.
+func WithWordFinalizedHook(hook WordFinalizedHook) Option {
+	return func(sl *SearchLoggerV2) {
+		if hook != nil {
+			sl.hooks = append(sl.hooks, hook)
+		}
+	}
+}
+
+// wordFinalized calls every registered hook with word
+func (sl *SearchLoggerV2) wordFinalized(word FinalizedWord) {
+	for _, hook := range sl.hooks {
+		hook(word)
+	}
+}
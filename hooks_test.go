@@ -0,0 +1,54 @@
+package logsearch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWordFinalizedHook(t *testing.T) {
+	ctx := context.Background()
+	var finalized []FinalizedWord
+	logger, err := NewSearchLoggerV2(WithWordFinalizedHook(func(word FinalizedWord) {
+		finalized = append(finalized, word)
+	}))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	for _, word := range []string{"bus", "b", "busi"} {
+		assert.NoError(t, logger.LogSearchV2(ctx, "user_1", word))
+	}
+
+	// The ignored prefix is never written
+	assert.Len(t, finalized, 2)
+	assert.Equal(t, FinalizedWord{UserIdentifier: "user_1", Word: "bus", Count: 1, At: finalized[0].At}, finalized[0])
+	assert.Equal(t, FinalizedWord{UserIdentifier: "user_1", Word: "busi", ReplacedWords: []string{"bus"}, Count: 1, At: finalized[1].At}, finalized[1])
+	assert.False(t, finalized[1].At.Before(finalized[0].At))
+}
+
+func TestWordFinalizedHookBuffered(t *testing.T) {
+	ctx := context.Background()
+	ch := make(chan FinalizedWord, 1)
+	logger, err := NewSearchLoggerV2(WithWriteBuffer(100, time.Hour), WithWordFinalizedHook(ChannelHook(ch)))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	for _, word := range []string{"b", "bu", "bus"} {
+		assert.NoError(t, logger.LogSearchV2(ctx, "user_1", word))
+	}
+	assert.Empty(t, ch, "Buffered words are not written yet")
+
+	assert.NoError(t, logger.Flush(ctx))
+	word := <-ch
+	assert.Equal(t, "user_1", word.UserIdentifier)
+	assert.Equal(t, "bus", word.Word)
+	assert.Equal(t, 3, word.Count)
+
+	// A full channel drops words instead of blocking the flush
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_2", "cat"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_3", "dog"))
+	assert.NoError(t, logger.Flush(ctx))
+	assert.Len(t, ch, 1)
+}
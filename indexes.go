@@ -0,0 +1,100 @@
+package logsearch
+
+import (
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// wordIndex is an in-memory index of the stored words, e.g. the sketch of
+// WithHeavyHitters. The logger registers every enabled index on startup and
+// keeps them in step with the store through counted, moved, merged and removed.
+type wordIndex interface {
+	// Add adds delta to the count of word, searched at at
+	Add(word string, delta int64, at time.Time)
+	// Move moves one count from a word to the longer word extending it at at
+	Move(from, to string, at time.Time)
+	// Merge moves the whole count of a word to another word
+	Merge(from, to string)
+	// Remove takes count searches of word away
+	Remove(word string, count int64)
+}
+
+// countIndex is an index that ignores when the words were searched
+type countIndex interface {
+	Add(word string, delta int64)
+	Move(from, to string)
+	Merge(from, to string)
+}
+
+// untimed adapts a countIndex to a wordIndex
+type untimed struct {
+	countIndex
+}
+
+func (u untimed) Add(word string, delta int64, _ time.Time) { u.countIndex.Add(word, delta) }
+func (u untimed) Move(from, to string, _ time.Time)         { u.countIndex.Move(from, to) }
+func (u untimed) Remove(word string, count int64)           { u.countIndex.Add(word, -count) }
+
+// wordIndexes returns the indexes enabled by the options
+func (sl *SearchLoggerV2) wordIndexes() []wordIndex {
+	var indexes []wordIndex
+	if sl.heavy != nil {
+		indexes = append(indexes, untimed{sl.heavy})
+	}
+	if sl.trending != nil {
+		indexes = append(indexes, sl.trending)
+	}
+	if sl.spell != nil {
+		indexes = append(indexes, untimed{sl.spell})
+	}
+	if sl.terms != nil {
+		indexes = append(indexes, untimed{sl.terms})
+	}
+	return indexes
+}
+
+// counted adds delta searches of word at at to every index
+func (sl *SearchLoggerV2) counted(word string, delta int64, at time.Time) {
+	for _, index := range sl.indexes {
+		index.Add(word, delta, at)
+	}
+}
+
+// moved moves one search from a word to the longer word extending it at at in every index
+func (sl *SearchLoggerV2) moved(from, to string, at time.Time) {
+	for _, index := range sl.indexes {
+		index.Move(from, to, at)
+	}
+}
+
+// merged moves the searches of a word to another word in every index
+func (sl *SearchLoggerV2) merged(from, to string) {
+	for _, index := range sl.indexes {
+		index.Merge(from, to)
+	}
+}
+
+// discarded takes the searches of the buffered writes dropped before they
+// reached the store away from every index. A stored word a write extended gets
+// the search moved from it back, its record takes it away.
+func (sl *SearchLoggerV2) discarded(writes map[string]*store.UserSearchWrite) {
+	for _, write := range writes {
+		for _, index := range sl.indexes {
+			index.Remove(write.Word, int64(write.Count))
+			for _, word := range write.ReplaceWords {
+				index.Add(word, 1, write.LastUpdatedAt)
+			}
+		}
+	}
+}
+
+// removed takes the searches of the records deleted from the store away from
+// every index, so a word nobody searched anymore leaves them
+func (sl *SearchLoggerV2) removed(records []store.WordCount) {
+	for _, record := range records {
+		for _, index := range sl.indexes {
+			index.Remove(record.Word, int64(record.Count))
+		}
+	}
+}
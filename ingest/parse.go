@@ -0,0 +1,96 @@
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/afanwang/logsearch"
+)
+
+// Parser extracts the search logged on a line, false when the line logs none
+type Parser interface {
+	Parse(line string) (logsearch.SearchEvent, bool)
+}
+
+// ParserFunc adapts a function to a Parser
+type ParserFunc func(line string) (logsearch.SearchEvent, bool)
+
+// Parse calls f
+func (f ParserFunc) Parse(line string) (logsearch.SearchEvent, bool) {
+	return f(line)
+}
+
+// Regexp parses the lines matching expr, whose named group "query" captures
+// the search and the optional named group "user" its user. With unescape the
+// query is URL-decoded, e.g. captured from the request line of an access log:
+//
+//	ingest.Regexp(`"GET /search\?q=(?P<query>[^&" ]+)(?:&user=(?P<user>[^&" ]+))?`, true)
+func Regexp(expr string, unescape bool) (Parser, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	query, user := re.SubexpIndex("query"), re.SubexpIndex("user")
+	if query < 0 {
+		return nil, fmt.Errorf("regular expression %q has no group named query", expr)
+	}
+
+	decode := func(s string) string {
+		if !unescape {
+			return s
+		}
+		if decoded, err := url.QueryUnescape(s); err == nil {
+			return decoded
+		}
+		return s
+	}
+	return ParserFunc(func(line string) (logsearch.SearchEvent, bool) {
+		match := re.FindStringSubmatch(line)
+		if match == nil || match[query] == "" {
+			return logsearch.SearchEvent{}, false
+		}
+		event := logsearch.SearchEvent{Query: decode(match[query])}
+		if user >= 0 {
+			event.UserIdentifier = decode(match[user])
+		}
+		return event, true
+	}), nil
+}
+
+// JSON parses lines holding a JSON object, the search being the string at the
+// field queryField and its user the one at userField, if not empty. Nested
+// fields are separated by dots, e.g. "request.query".
+func JSON(queryField, userField string) Parser {
+	return ParserFunc(func(line string) (logsearch.SearchEvent, bool) {
+		var object map[string]any
+		if err := json.Unmarshal([]byte(line), &object); err != nil {
+			return logsearch.SearchEvent{}, false
+		}
+		query, ok := field(object, queryField)
+		if !ok || query == "" {
+			return logsearch.SearchEvent{}, false
+		}
+		event := logsearch.SearchEvent{Query: query}
+		if userField != "" {
+			event.UserIdentifier, _ = field(object, userField)
+		}
+		return event, true
+	})
+}
+
+// field returns the string at the dotted path of object
+func field(object map[string]any, path string) (string, bool) {
+	names := strings.Split(path, ".")
+	for _, name := range names[:len(names)-1] {
+		nested, ok := object[name].(map[string]any)
+		if !ok {
+			return "", false
+		}
+		object = nested
+	}
+	value, ok := object[names[len(names)-1]].(string)
+	return value, ok
+}
@@ -0,0 +1,59 @@
+package ingest
+
+import (
+	"testing"
+
+	"github.com/afanwang/logsearch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegexp(t *testing.T) {
+	parser, err := Regexp(`"GET /search\?q=(?P<query>[^&" ]+)(?:&user=(?P<user>[^&" ]+))?`, true)
+	require.NoError(t, err)
+
+	event, ok := parser.Parse(`127.0.0.1 - - [01/May/2024:09:00:00 +0000] "GET /search?q=caf%C3%A9+au+lait&user=user_1 HTTP/1.1" 200 512`)
+	assert.True(t, ok)
+	assert.Equal(t, logsearch.SearchEvent{UserIdentifier: "user_1", Query: "café au lait"}, event)
+
+	event, ok = parser.Parse(`127.0.0.1 - - [01/May/2024:09:00:00 +0000] "GET /search?q=c%2B%2B HTTP/1.1" 200 512`)
+	assert.True(t, ok)
+	assert.Equal(t, logsearch.SearchEvent{Query: "c++"}, event)
+
+	_, ok = parser.Parse(`127.0.0.1 - - [01/May/2024:09:00:00 +0000] "GET /health HTTP/1.1" 200 2`)
+	assert.False(t, ok)
+
+	raw, err := Regexp(`search: (?P<query>.+)`, false)
+	require.NoError(t, err)
+	event, ok = raw.Parse("search: c++")
+	assert.True(t, ok)
+	assert.Equal(t, "c++", event.Query)
+
+	_, err = Regexp(`search: (.+)`, false)
+	assert.Error(t, err)
+	_, err = Regexp(`(`, false)
+	assert.Error(t, err)
+}
+
+func TestJSON(t *testing.T) {
+	parser := JSON("request.query", "user_id")
+
+	event, ok := parser.Parse(`{"level":"info","request":{"query":"cat"},"user_id":"user_1"}`)
+	assert.True(t, ok)
+	assert.Equal(t, logsearch.SearchEvent{UserIdentifier: "user_1", Query: "cat"}, event)
+
+	event, ok = parser.Parse(`{"request":{"query":"cat"}}`)
+	assert.True(t, ok)
+	assert.Equal(t, logsearch.SearchEvent{Query: "cat"}, event)
+
+	for _, line := range []string{
+		`{"request":{"query":""}}`,
+		`{"request":{"query":42}}`,
+		`{"request":"cat"}`,
+		`{"query":"cat"}`,
+		`not json`,
+	} {
+		_, ok := parser.Parse(line)
+		assert.False(t, ok, line)
+	}
+}
@@ -0,0 +1,251 @@
+// Package ingest feeds the loggers with the searches other services already
+// write to log files, so logsearch can be retrofitted without touching them.
+// A Tailer follows a file like tail -F, surviving its rotation, and a Parser
+// extracts the search logged on every line.
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/afanwang/logsearch"
+)
+
+// Logger is fed with the tailed searches, both logsearch.SearchLoggerV2 and trie.SearchLogger are
+type Logger interface {
+	LogSearchBatch(ctx context.Context, events []logsearch.SearchEvent) error
+}
+
+// Tailer follows a log file and logs the searches of the lines appended to it.
+// It polls the file, so it works on every file system, and notices both
+// rotation schemes: a file renamed and replaced by a new one, whose remaining
+// lines are read before following the new file, and a file truncated in place.
+type Tailer struct {
+	path      string
+	logger    Logger
+	parser    Parser
+	interval  time.Duration
+	batchSize int
+	fromStart bool
+
+	file   *os.File
+	reader *bufio.Reader
+	// offset is the number of bytes read from file
+	offset int64
+	// partial is the last line read, until its newline is written
+	partial string
+	batch   []logsearch.SearchEvent
+}
+
+// Option configures optional Tailer behavior
+type Option func(*Tailer)
+
+// WithPollInterval checks the file for new lines every interval, 250ms by default
+func WithPollInterval(interval time.Duration) Option {
+	return func(t *Tailer) {
+		if interval > 0 {
+			t.interval = interval
+		}
+	}
+}
+
+// WithBatchSize logs the searches by batches of up to size, 100 by default
+func WithBatchSize(size int) Option {
+	return func(t *Tailer) {
+		if size > 0 {
+			t.batchSize = size
+		}
+	}
+}
+
+// FromStart also logs the lines in the file when Run starts, by default only
+// the lines appended afterwards are. A file created after Run started, or
+// replacing a rotated one, is always read from its start.
+func FromStart() Option {
+	return func(t *Tailer) {
+		t.fromStart = true
+	}
+}
+
+// New returns a Tailer logging the searches parser finds in the file at path into logger, start it with Run
+func New(path string, logger Logger, parser Parser, opts ...Option) *Tailer {
+	t := &Tailer{
+		path:      path,
+		logger:    logger,
+		parser:    parser,
+		interval:  250 * time.Millisecond,
+		batchSize: 100,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	t.batch = make([]logsearch.SearchEvent, 0, t.batchSize)
+	return t
+}
+
+// Run follows the file until ctx is done. The file may not exist yet, it is
+// followed once created. Lines without a search are skipped and searches the
+// logger rejects are logged and dropped, so Run only returns an error when
+// the file cannot be read.
+func (t *Tailer) Run(ctx context.Context) error {
+	defer t.close()
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for first := true; ; first = false {
+		err := t.poll(ctx, first)
+		t.flush(ctx)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll reads the lines appended since the last poll and follows the rotation of the file
+func (t *Tailer) poll(ctx context.Context, first bool) error {
+	if t.file == nil {
+		// A file existing when Run starts is followed from its end
+		if err := t.open(first && !t.fromStart); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+	}
+	if err := t.readLines(ctx); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(t.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Moved away, the new file is not created yet
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	current, err := t.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !os.SameFile(info, current):
+		// Renamed and replaced, the lines written to the old file meanwhile come first
+		if err := t.readLines(ctx); err != nil {
+			return err
+		}
+		t.endLine(ctx)
+		t.close()
+		if err := t.open(false); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		return t.readLines(ctx)
+	case current.Size() < t.offset:
+		// Truncated in place, e.g. by logrotate with copytruncate
+		if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		t.reader.Reset(t.file)
+		t.offset = 0
+		t.partial = ""
+		return t.readLines(ctx)
+	}
+	return nil
+}
+
+// open opens the file at path, positioned at its end with seekEnd
+func (t *Tailer) open(seekEnd bool) error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	var offset int64
+	if seekEnd {
+		if offset, err = f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	t.file, t.offset, t.partial = f, offset, ""
+	if t.reader == nil {
+		t.reader = bufio.NewReader(f)
+	} else {
+		t.reader.Reset(f)
+	}
+	return nil
+}
+
+// close closes the followed file, if any
+func (t *Tailer) close() {
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+}
+
+// readLines parses the complete lines up to the end of the file
+func (t *Tailer) readLines(ctx context.Context) error {
+	for {
+		chunk, err := t.reader.ReadString('\n')
+		t.offset += int64(len(chunk))
+		if errors.Is(err, io.EOF) {
+			t.partial += chunk
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		t.parse(ctx, t.partial+chunk)
+		t.partial = ""
+	}
+}
+
+// endLine parses the last line of a file that will not grow anymore, even without a newline
+func (t *Tailer) endLine(ctx context.Context) {
+	if t.partial != "" {
+		t.parse(ctx, t.partial)
+		t.partial = ""
+	}
+}
+
+// parse adds the search of a line to the batch, logging the batch once full
+func (t *Tailer) parse(ctx context.Context, line string) {
+	event, ok := t.parser.Parse(strings.TrimRight(line, "\r\n"))
+	if !ok {
+		return
+	}
+	t.batch = append(t.batch, event)
+	if len(t.batch) >= t.batchSize {
+		t.flush(ctx)
+	}
+}
+
+// flush logs the batch
+func (t *Tailer) flush(ctx context.Context) {
+	if len(t.batch) == 0 {
+		return
+	}
+	if err := t.logger.LogSearchBatch(ctx, t.batch); err != nil {
+		log.Printf("Failed to log searches tailed from %s: %v", t.path, err)
+	}
+	t.batch = t.batch[:0]
+}
@@ -0,0 +1,171 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder is a Logger recording the searches it is fed
+type recorder struct {
+	mutex   sync.Mutex
+	queries []string
+}
+
+func (r *recorder) LogSearchBatch(ctx context.Context, events []logsearch.SearchEvent) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, event := range events {
+		r.queries = append(r.queries, event.Query)
+	}
+	return nil
+}
+
+func (r *recorder) logged() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string(nil), r.queries...)
+}
+
+// appendLines appends text to the file at path
+func appendLines(t *testing.T, path, text string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(text)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+// startTailer runs a Tailer of the file at path until the test ends
+func startTailer(t *testing.T, path string, r *recorder, opts ...Option) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	tailer := New(path, r, JSON("q", ""), append([]Option{WithPollInterval(time.Millisecond)}, opts...)...)
+	go func() { done <- tailer.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-done)
+	})
+}
+
+// waitLogged waits until r logged want
+func waitLogged(t *testing.T, r *recorder, want ...string) {
+	t.Helper()
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(want, r.logged())
+	}, 2*time.Second, time.Millisecond, "want %v", want)
+}
+
+func search(query string) string {
+	return fmt.Sprintf("{\"q\":%q}\n", query)
+}
+
+func TestTailerFollowsAppendedLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search.log")
+	appendLines(t, path, search("before"))
+
+	r := &recorder{}
+	startTailer(t, path, r)
+	// Give the tailer time to open the file at its end
+	time.Sleep(20 * time.Millisecond)
+
+	appendLines(t, path, search("cat")+"not json\n"+`{"q":"do`)
+	waitLogged(t, r, "cat")
+	// The partial line is logged once complete
+	appendLines(t, path, "g\"}\n")
+	waitLogged(t, r, "cat", "dog")
+}
+
+func TestTailerFromStart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search.log")
+	appendLines(t, path, search("before"))
+
+	r := &recorder{}
+	startTailer(t, path, r, FromStart())
+	waitLogged(t, r, "before")
+}
+
+func TestTailerWaitsForTheFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search.log")
+
+	r := &recorder{}
+	startTailer(t, path, r)
+	time.Sleep(20 * time.Millisecond)
+
+	// Created after Run started, the file is read from its start
+	appendLines(t, path, search("cat"))
+	waitLogged(t, r, "cat")
+}
+
+func TestTailerFollowsRenamedFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "search.log")
+	appendLines(t, path, "")
+
+	r := &recorder{}
+	startTailer(t, path, r)
+	time.Sleep(20 * time.Millisecond)
+
+	appendLines(t, path, search("cat"))
+	waitLogged(t, r, "cat")
+
+	// Lines written to the old file after the rename are not lost
+	rotated := filepath.Join(dir, "search.log.1")
+	require.NoError(t, os.Rename(path, rotated))
+	appendLines(t, rotated, search("dog")+`{"q":"fox"}`)
+	appendLines(t, path, search("bus"))
+	waitLogged(t, r, "cat", "dog", "fox", "bus")
+
+	appendLines(t, path, search("car"))
+	waitLogged(t, r, "cat", "dog", "fox", "bus", "car")
+}
+
+func TestTailerFollowsTruncatedFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search.log")
+	appendLines(t, path, "")
+
+	r := &recorder{}
+	startTailer(t, path, r)
+	time.Sleep(20 * time.Millisecond)
+
+	appendLines(t, path, search("caterpillar"))
+	waitLogged(t, r, "caterpillar")
+
+	require.NoError(t, os.Truncate(path, 0))
+	// Let the tailer notice the truncation before the file grows again
+	time.Sleep(20 * time.Millisecond)
+	appendLines(t, path, search("cat"))
+	waitLogged(t, r, "caterpillar", "cat")
+}
+
+func TestTailerBatches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search.log")
+	appendLines(t, path, search("a")+search("b")+search("c"))
+
+	var batches []int
+	logger := loggerFunc(func(ctx context.Context, events []logsearch.SearchEvent) error {
+		batches = append(batches, len(events))
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, New(path, logger, JSON("q", ""), FromStart(), WithBatchSize(2)).Run(ctx))
+	assert.Equal(t, []int{2, 1}, batches)
+}
+
+// loggerFunc adapts a function to a Logger
+type loggerFunc func(ctx context.Context, events []logsearch.SearchEvent) error
+
+func (f loggerFunc) LogSearchBatch(ctx context.Context, events []logsearch.SearchEvent) error {
+	return f(ctx, events)
+}
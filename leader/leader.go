@@ -0,0 +1,43 @@
+// Package leader elects the replica running a cycle of a periodic job when
+// several replicas share one store, e.g. the flush cycles of the trie logger
+// which would race on the updates of the same records, see
+// trie.WithFlushLeader. A Lock is held by at most one replica at a time: the
+// replica taking it leads the cycle and releases it afterwards, the others
+// skip the cycle and try again on the next one, so every replica still gets
+// its turn. Postgres takes an advisory lock of the shared database, Redis a
+// lease expiring on its own when its holder died.
+package leader
+
+import (
+	"context"
+	"sync"
+)
+
+// Lock is held by at most one replica at a time
+type Lock interface {
+	// TryLock takes the lock without waiting, reporting false while another replica holds it
+	TryLock(ctx context.Context) (bool, error)
+	// Unlock releases the lock taken by TryLock
+	Unlock(ctx context.Context) error
+}
+
+// Local is a Lock of the replicas running in one process, e.g. in tests
+type Local struct {
+	mutex sync.Mutex
+}
+
+// NewLocal creates an unlocked Local
+func NewLocal() *Local {
+	return &Local{}
+}
+
+// TryLock takes the lock unless it is held
+func (l *Local) TryLock(context.Context) (bool, error) {
+	return l.mutex.TryLock(), nil
+}
+
+// Unlock releases the lock
+func (l *Local) Unlock(context.Context) error {
+	l.mutex.Unlock()
+	return nil
+}
@@ -0,0 +1,87 @@
+package leader
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// testLock checks that a and b, contending for the same lock, hold it in turn
+func testLock(t *testing.T, a, b Lock) {
+	ctx := context.Background()
+	taken, err := a.TryLock(ctx)
+	require.NoError(t, err)
+	assert.True(t, taken)
+	taken, err = b.TryLock(ctx)
+	require.NoError(t, err)
+	assert.False(t, taken, "only one replica holds the lock")
+
+	require.NoError(t, a.Unlock(ctx))
+	taken, err = b.TryLock(ctx)
+	require.NoError(t, err)
+	assert.True(t, taken, "the lock is released for the next replica")
+	require.NoError(t, b.Unlock(ctx))
+}
+
+func TestLocal(t *testing.T) {
+	l := NewLocal()
+	testLock(t, l, l)
+}
+
+func TestRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	newLock := func(name string) *Redis {
+		r, err := NewRedis(store.RedisConfig{Addr: server.Addr()}, name, time.Minute)
+		require.NoError(t, err)
+		t.Cleanup(func() { r.Close() })
+		return r
+	}
+	a, b := newLock("flush"), newLock("flush")
+	testLock(t, a, b)
+
+	// The lease of a dead replica expires, and its late release keeps the lease of the next one
+	ctx := context.Background()
+	taken, err := a.TryLock(ctx)
+	require.NoError(t, err)
+	require.True(t, taken)
+	server.FastForward(time.Minute)
+	taken, err = b.TryLock(ctx)
+	require.NoError(t, err)
+	assert.True(t, taken)
+	require.NoError(t, a.Unlock(ctx))
+	taken, err = newLock("flush").TryLock(ctx)
+	require.NoError(t, err)
+	assert.False(t, taken)
+
+	// Other names are other locks
+	taken, err = newLock("rollup").TryLock(ctx)
+	require.NoError(t, err)
+	assert.True(t, taken)
+
+	_, err = NewRedis(store.RedisConfig{Addr: server.Addr()}, "", time.Minute)
+	assert.Error(t, err)
+	_, err = NewRedis(store.RedisConfig{Addr: server.Addr()}, "flush", 0)
+	assert.Error(t, err)
+}
+
+// TestPostgres runs against a real database when LOGSEARCH_POSTGRES_DSN is set
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("LOGSEARCH_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("LOGSEARCH_POSTGRES_DSN not set")
+	}
+	a, err := NewPostgres(dsn, "logsearch-test")
+	require.NoError(t, err)
+	defer a.Close()
+	b, err := NewPostgres(dsn, "logsearch-test")
+	require.NoError(t, err)
+	defer b.Close()
+	testLock(t, a, b)
+}
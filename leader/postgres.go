@@ -0,0 +1,115 @@
+package leader
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"time"
+
+	// Register the pgx driver with database/sql under the name "pgx"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// Postgres is a Lock on a session advisory lock of a PostgreSQL database. The
+// lock is held by a connection of its own, so PostgreSQL releases it when the
+// replica dies and its connection drops.
+type Postgres struct {
+	db           *sql.DB
+	name         string
+	queryTimeout time.Duration
+
+	mutex sync.Mutex
+	// conn holds the advisory lock, nil when not held
+	conn *sql.Conn
+}
+
+// NewPostgres connects to the database of dsn, e.g. the one the replicas
+// share, and verifies the connection with a ping. The replicas using the same
+// name contend for the same lock.
+func NewPostgres(dsn, name string) (*Postgres, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("postgres DSN cannot be empty")
+	}
+	if name == "" {
+		return nil, fmt.Errorf("lock name cannot be empty")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open postgres connection: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	p := &Postgres{db: db, name: name, queryTimeout: 5 * time.Second}
+	ctx, cancel := p.queryContext(context.Background())
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to postgres: %w", err)
+	}
+	return p, nil
+}
+
+// queryContext bounds the caller's context by the query timeout
+func (p *Postgres) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, p.queryTimeout)
+}
+
+// TryLock takes the advisory lock keyed by the hash of the name
+func (p *Postgres) TryLock(ctx context.Context) (bool, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.conn != nil {
+		return false, fmt.Errorf("lock %q is already held", p.name)
+	}
+
+	ctx, cancel := p.queryContext(ctx)
+	defer cancel()
+	conn, err := p.db.Conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to postgres: %w", err)
+	}
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock(hashtext($1))`, p.name).Scan(&locked); err != nil {
+		conn.Close()
+		return false, fmt.Errorf("failed to take lock %q: %w", p.name, err)
+	}
+	if !locked {
+		conn.Close()
+		return false, nil
+	}
+	p.conn = conn
+	return true, nil
+}
+
+// Unlock releases the advisory lock. The connection is dropped even if the
+// release fails, which releases the lock too.
+func (p *Postgres) Unlock(ctx context.Context) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.conn == nil {
+		return nil
+	}
+
+	ctx, cancel := p.queryContext(ctx)
+	defer cancel()
+	_, err := p.conn.ExecContext(ctx, `SELECT pg_advisory_unlock(hashtext($1))`, p.name)
+	if err != nil {
+		// A pooled connection would keep holding the lock
+		p.conn.Raw(func(any) error { return driver.ErrBadConn })
+	}
+	p.conn.Close()
+	p.conn = nil
+	if err != nil {
+		return fmt.Errorf("failed to release lock %q: %w", p.name, err)
+	}
+	return nil
+}
+
+// Close releases the lock if held and closes the connection
+func (p *Postgres) Close() error {
+	p.Unlock(context.Background())
+	return p.db.Close()
+}
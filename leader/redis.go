@@ -0,0 +1,109 @@
+package leader
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// Redis is a Lock on a lease of a Redis key, which expires on its own once
+// ttl elapsed, so a replica that died holding it blocks the others at most
+// ttl. A cycle must end within ttl, or another replica may take the lease
+// before the cycle is over.
+type Redis struct {
+	client       *redis.Client
+	key          string
+	token        string
+	ttl          time.Duration
+	queryTimeout time.Duration
+}
+
+// release deletes the key if it still holds the token of the replica
+var release = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// NewRedis connects to Redis and verifies the connection with a ping. The
+// replicas using the same name contend for the same lease, kept under the key
+// cfg.KeyPrefix followed by "leader:" and name.
+func NewRedis(cfg store.RedisConfig, name string, ttl time.Duration) (*Redis, error) {
+	if cfg.Addr == "" {
+		return nil, fmt.Errorf("redis address cannot be empty")
+	}
+	if name == "" {
+		return nil, fmt.Errorf("lock name cannot be empty")
+	}
+	if ttl < time.Millisecond {
+		return nil, fmt.Errorf("lease ttl must be at least 1ms, got %s", ttl)
+	}
+
+	prefix := cfg.KeyPrefix
+	if prefix == "" {
+		prefix = "logsearch:"
+	}
+	queryTimeout := cfg.QueryTimeout
+	if queryTimeout <= 0 {
+		queryTimeout = time.Second
+	}
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("failed to generate lease token: %w", err)
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: cfg.Addr, Password: cfg.Password, DB: cfg.DB})
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+
+	return &Redis{
+		client:       client,
+		key:          prefix + "leader:" + name,
+		token:        hex.EncodeToString(token),
+		ttl:          ttl,
+		queryTimeout: queryTimeout,
+	}, nil
+}
+
+// queryContext bounds the caller's context by the configured query timeout
+func (r *Redis) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, r.queryTimeout)
+}
+
+// TryLock takes the lease for ttl
+func (r *Redis) TryLock(ctx context.Context) (bool, error) {
+	ctx, cancel := r.queryContext(ctx)
+	defer cancel()
+	taken, err := r.client.SetNX(ctx, r.key, r.token, r.ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to take lease %q: %w", r.key, err)
+	}
+	return taken, nil
+}
+
+// Unlock ends the lease, unless it expired and another replica took it meanwhile
+func (r *Redis) Unlock(ctx context.Context) error {
+	ctx, cancel := r.queryContext(ctx)
+	defer cancel()
+	if err := release.Run(ctx, r.client, []string{r.key}, r.token).Err(); err != nil {
+		return fmt.Errorf("failed to release lease %q: %w", r.key, err)
+	}
+	return nil
+}
+
+// Close closes the connection, a held lease expires after its ttl
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,309 @@
+// Package metrics instruments the search loggers with Prometheus collectors
+// and serves them for scraping.
+//
+// A nil *Metrics is valid and records nothing, so the loggers only pay for
+// instrumentation when built with their WithMetrics option.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Values of the logger label
+const (
+	LoggerTrie = "trie"
+	LoggerV2   = "v2"
+)
+
+// Values of the decision label, what the dedup logic did with a search
+const (
+	DecisionNew        = "new"
+	DecisionExtend     = "extend"
+	DecisionIgnore     = "ignore"
+	DecisionFilter     = "filter"
+	DecisionTypo       = "typo"
+	DecisionBranch     = "branch"
+	DecisionKnown      = "known"
+	DecisionDuplicate  = "duplicate"
+	DecisionQuarantine = "quarantine"
+	DecisionSubmitted  = "submitted"
+)
+
+// Values of the query label of cached reads
+const (
+	QueryUserSearches = "user_searches"
+	QuerySuggest      = "suggest"
+	QueryTop          = "top"
+)
+
+// Values of the op label of store writes
+const (
+	OpInsert = "insert"
+	OpUpdate = "update"
+	OpBatch  = "batch"
+)
+
+// Metrics holds the collectors shared by both loggers
+type Metrics struct {
+	registry *prometheus.Registry
+
+	searches      *prometheus.CounterVec
+	decisions     *prometheus.CounterVec
+	flushDuration *prometheus.HistogramVec
+	flushedWrites *prometheus.CounterVec
+	writeDuration *prometheus.HistogramVec
+	trieNodes     prometheus.Gauge
+	userRecords   prometheus.Histogram
+	purged        *prometheus.CounterVec
+	quotaEvicted  *prometheus.CounterVec
+	trieEvicted   *prometheus.CounterVec
+	queueDepth    *prometheus.GaugeVec
+	dropped       *prometheus.CounterVec
+	deniedTerms   *prometheus.CounterVec
+	scrubbed      *prometheus.CounterVec
+	cacheLookups  *prometheus.CounterVec
+}
+
+// New creates the collectors in their own registry, along with the Go runtime
+// and process collectors
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		searches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "logsearch",
+			Name:      "searches_logged_total",
+			Help:      "Searches accepted by the loggers, one per keystroke.",
+		}, []string{"logger"}),
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "logsearch",
+			Name:      "dedup_decisions_total",
+			Help:      "Dedup decisions: new words stored, stored words extended, prefixes ignored and words filtered out.",
+		}, []string{"logger", "decision"}),
+		flushDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "logsearch",
+			Name:      "flush_duration_seconds",
+			Help:      "Duration of the flush cycles writing completed or buffered searches to the store.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"logger", "result"}),
+		flushedWrites: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "logsearch",
+			Name:      "flushed_writes_total",
+			Help:      "Words written to the store by successful flush cycles.",
+		}, []string{"logger"}),
+		writeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "logsearch",
+			Name:      "db_write_duration_seconds",
+			Help:      "Duration of the store writes.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"logger", "op", "result"}),
+		trieNodes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "logsearch",
+			Name:      "trie_nodes",
+			Help:      "Nodes in the Version 1 trie.",
+		}),
+		userRecords: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "logsearch",
+			Name:      "user_records",
+			Help:      "Stored records per user, observed whenever a user is loaded from the store.",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+		}),
+		purged: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "logsearch",
+			Name:      "purged_records_total",
+			Help:      "Records deleted by the retention reaper for not being searched within the retention window.",
+		}, []string{"logger"}),
+		quotaEvicted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "logsearch",
+			Name:      "quota_evicted_records_total",
+			Help:      "Records deleted for exceeding the quota of their user, least recently updated first.",
+		}, []string{"logger"}),
+		trieEvicted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "logsearch",
+			Name:      "trie_evicted_nodes_total",
+			Help:      "Trie nodes dropped to keep the trie within its node budget, least recently searched words first.",
+		}, []string{"logger"}),
+		queueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "logsearch",
+			Name:      "queue_depth",
+			Help:      "Searches waiting in the async ingestion queue.",
+		}, []string{"logger"}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "logsearch",
+			Name:      "dropped_searches_total",
+			Help:      "Searches dropped because the async ingestion queue was full.",
+		}, []string{"logger"}),
+		deniedTerms: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "logsearch",
+			Name:      "denied_terms_total",
+			Help:      "Denylist terms found in searches that were dropped or masked, one per check of a keystroke.",
+		}, []string{"term"}),
+		scrubbed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "logsearch",
+			Name:      "scrubbed_total",
+			Help:      "Personal data found in searches that were dropped or redacted, by pattern, one per check of a keystroke.",
+		}, []string{"pattern"}),
+		cacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "logsearch",
+			Name:      "cache_lookups_total",
+			Help:      "Reads looked up in the result cache, by query and hit or miss.",
+		}, []string{"query", "result"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.searches,
+		m.decisions,
+		m.flushDuration,
+		m.flushedWrites,
+		m.writeDuration,
+		m.trieNodes,
+		m.userRecords,
+		m.purged,
+		m.quotaEvicted,
+		m.trieEvicted,
+		m.queueDepth,
+		m.dropped,
+		m.deniedTerms,
+		m.scrubbed,
+		m.cacheLookups,
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format, mount it on /metrics
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// SearchLogged counts a search accepted by logger
+func (m *Metrics) SearchLogged(logger string) {
+	if m == nil {
+		return
+	}
+	m.searches.WithLabelValues(logger).Inc()
+}
+
+// Decision counts a dedup decision of logger
+func (m *Metrics) Decision(logger, decision string) {
+	if m == nil {
+		return
+	}
+	m.decisions.WithLabelValues(logger, decision).Inc()
+}
+
+// ObserveFlush records a flush cycle of logger that started at start and wrote writes words
+func (m *Metrics) ObserveFlush(logger string, writes int, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	m.flushDuration.WithLabelValues(logger, result(err)).Observe(time.Since(start).Seconds())
+	if err == nil {
+		m.flushedWrites.WithLabelValues(logger).Add(float64(writes))
+	}
+}
+
+// ObserveWrite records a store write of logger that started at start
+func (m *Metrics) ObserveWrite(logger, op string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	m.writeDuration.WithLabelValues(logger, op, result(err)).Observe(time.Since(start).Seconds())
+}
+
+// SetTrieNodes sets the number of nodes in the trie
+func (m *Metrics) SetTrieNodes(nodes int) {
+	if m == nil {
+		return
+	}
+	m.trieNodes.Set(float64(nodes))
+}
+
+// ObserveUserRecords records how many records a user has in the store
+func (m *Metrics) ObserveUserRecords(records int) {
+	if m == nil {
+		return
+	}
+	m.userRecords.Observe(float64(records))
+}
+
+// Purged counts records of logger deleted by the retention reaper
+func (m *Metrics) Purged(logger string, records int64) {
+	if m == nil {
+		return
+	}
+	m.purged.WithLabelValues(logger).Add(float64(records))
+}
+
+// QuotaEvicted counts records of logger deleted for exceeding the quota of their user
+func (m *Metrics) QuotaEvicted(logger string, records int64) {
+	if m == nil {
+		return
+	}
+	m.quotaEvicted.WithLabelValues(logger).Add(float64(records))
+}
+
+// TrieEvicted counts the trie nodes of logger dropped to honor its node budget
+func (m *Metrics) TrieEvicted(logger string, nodes int) {
+	if m == nil {
+		return
+	}
+	m.trieEvicted.WithLabelValues(logger).Add(float64(nodes))
+}
+
+// SetQueueDepth sets the number of searches waiting in the async queue of logger
+func (m *Metrics) SetQueueDepth(logger string, depth int) {
+	if m == nil {
+		return
+	}
+	m.queueDepth.WithLabelValues(logger).Set(float64(depth))
+}
+
+// SearchDropped counts a search of logger dropped by its full async queue
+func (m *Metrics) SearchDropped(logger string) {
+	if m == nil {
+		return
+	}
+	m.dropped.WithLabelValues(logger).Inc()
+}
+
+// DeniedTerm counts a denylist term found in a search, see denylist.OnMatch
+func (m *Metrics) DeniedTerm(term string) {
+	if m == nil {
+		return
+	}
+	m.deniedTerms.WithLabelValues(term).Inc()
+}
+
+// Scrubbed counts personal data of pattern found in a search, see scrub.OnMatch
+func (m *Metrics) Scrubbed(pattern string) {
+	if m == nil {
+		return
+	}
+	m.scrubbed.WithLabelValues(pattern).Inc()
+}
+
+// CacheLookup counts a read of query answered from the result cache when hit, from the store otherwise
+func (m *Metrics) CacheLookup(query string, hit bool) {
+	if m == nil {
+		return
+	}
+	outcome := "miss"
+	if hit {
+		outcome = "hit"
+	}
+	m.cacheLookups.WithLabelValues(query, outcome).Inc()
+}
+
+// result is the value of the result label for err
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scrape returns the metrics as served on /metrics
+func scrape(t *testing.T, m *Metrics) string {
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestMetrics_Handler(t *testing.T) {
+	m := New()
+	start := time.Now()
+
+	m.SearchLogged(LoggerV2)
+	m.SearchLogged(LoggerV2)
+	m.Decision(LoggerV2, DecisionExtend)
+	m.ObserveFlush(LoggerTrie, 3, start, nil)
+	m.ObserveFlush(LoggerTrie, 5, start, errors.New("db down"))
+	m.ObserveWrite(LoggerTrie, OpBatch, start, nil)
+	m.SetTrieNodes(42)
+	m.ObserveUserRecords(7)
+	m.Purged(LoggerV2, 4)
+	m.SetQueueDepth(LoggerTrie, 9)
+	m.SearchDropped(LoggerTrie)
+	m.DeniedTerm("darn")
+	m.CacheLookup(QuerySuggest, true)
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `logsearch_searches_logged_total{logger="v2"} 2`)
+	assert.Contains(t, body, `logsearch_dedup_decisions_total{decision="extend",logger="v2"} 1`)
+	assert.Contains(t, body, `logsearch_flush_duration_seconds_count{logger="trie",result="ok"} 1`)
+	assert.Contains(t, body, `logsearch_flush_duration_seconds_count{logger="trie",result="error"} 1`)
+	assert.Contains(t, body, `logsearch_flushed_writes_total{logger="trie"} 3`)
+	assert.Contains(t, body, `logsearch_db_write_duration_seconds_count{logger="trie",op="batch",result="ok"} 1`)
+	assert.Contains(t, body, `logsearch_trie_nodes 42`)
+	assert.Contains(t, body, `logsearch_user_records_count 1`)
+	assert.Contains(t, body, `logsearch_purged_records_total{logger="v2"} 4`)
+	assert.Contains(t, body, `logsearch_queue_depth{logger="trie"} 9`)
+	assert.Contains(t, body, `logsearch_dropped_searches_total{logger="trie"} 1`)
+	assert.Contains(t, body, `logsearch_denied_terms_total{term="darn"} 1`)
+	assert.Contains(t, body, `logsearch_cache_lookups_total{query="suggest",result="hit"} 1`)
+	assert.Contains(t, body, `go_goroutines`)
+}
+
+func TestMetrics_NilIsDisabled(t *testing.T) {
+	var m *Metrics
+	assert.NotPanics(t, func() {
+		m.SearchLogged(LoggerV2)
+		m.Decision(LoggerV2, DecisionNew)
+		m.ObserveFlush(LoggerV2, 1, time.Now(), nil)
+		m.ObserveWrite(LoggerV2, OpInsert, time.Now(), nil)
+		m.SetTrieNodes(1)
+		m.ObserveUserRecords(1)
+		m.Purged(LoggerV2, 1)
+		m.SetQueueDepth(LoggerTrie, 1)
+		m.SearchDropped(LoggerTrie)
+		m.DeniedTerm("darn")
+		m.CacheLookup(QueryTop, false)
+	})
+}
@@ -0,0 +1,190 @@
+// Package ngram finds the words of a vocabulary containing a substring, e.g.
+// "iphone case" and "headphones" for "phone", which a trie, only walking
+// prefixes, cannot. Every word is indexed under each run of three characters
+// it holds, its trigrams, so a lookup only checks in full the words listed
+// under the rarest trigram of the substring instead of the whole vocabulary.
+//
+// A nil *Index is valid and indexes nothing, so SearchLoggerV2 only pays for it
+// when built with WithSubstringSearch.
+package ngram
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// n is the length of the indexed grams, in characters
+const n = 3
+
+// Term is a word of the vocabulary containing a looked up substring
+type Term struct {
+	Word  string
+	Count int64
+}
+
+// Index is a vocabulary of counted words indexed by their trigrams. It is safe
+// for concurrent use.
+type Index struct {
+	mutex  sync.RWMutex
+	counts map[string]int64
+	// grams maps every trigram to the words holding it
+	grams map[string]map[string]struct{}
+}
+
+// New creates an empty index
+func New() *Index {
+	return &Index{
+		counts: make(map[string]int64),
+		grams:  make(map[string]map[string]struct{}),
+	}
+}
+
+// Add adds delta to the count of word, indexing it when it first counts and
+// dropping it once its count is no longer positive
+func (ix *Index) Add(word string, delta int64) {
+	if ix == nil || word == "" || delta == 0 {
+		return
+	}
+
+	ix.mutex.Lock()
+	defer ix.mutex.Unlock()
+	ix.addLocked(word, delta)
+}
+
+// Move moves one count from a word to the longer word extending it
+func (ix *Index) Move(from, to string) {
+	if ix == nil {
+		return
+	}
+
+	ix.mutex.Lock()
+	defer ix.mutex.Unlock()
+	ix.addLocked(from, -1)
+	ix.addLocked(to, 1)
+}
+
+// Merge moves the whole count of a word to another word, e.g. when an operator merges two words
+func (ix *Index) Merge(from, to string) {
+	if ix == nil || from == to {
+		return
+	}
+
+	ix.mutex.Lock()
+	defer ix.mutex.Unlock()
+	if count := ix.counts[from]; count > 0 {
+		ix.addLocked(from, -count)
+		ix.addLocked(to, count)
+	}
+}
+
+// addLocked is Add, caller must hold the write lock
+func (ix *Index) addLocked(word string, delta int64) {
+	count, known := ix.counts[word]
+	count += delta
+	switch {
+	case count > 0 && known:
+		ix.counts[word] = count
+	case count > 0:
+		ix.counts[word] = count
+		for _, gram := range trigrams(word) {
+			words, ok := ix.grams[gram]
+			if !ok {
+				words = make(map[string]struct{})
+				ix.grams[gram] = words
+			}
+			words[word] = struct{}{}
+		}
+	case known:
+		delete(ix.counts, word)
+		for _, gram := range trigrams(word) {
+			delete(ix.grams[gram], word)
+			if len(ix.grams[gram]) == 0 {
+				delete(ix.grams, gram)
+			}
+		}
+	}
+}
+
+// Len returns how many words the index holds
+func (ix *Index) Len() int {
+	if ix == nil {
+		return 0
+	}
+
+	ix.mutex.RLock()
+	defer ix.mutex.RUnlock()
+	return len(ix.counts)
+}
+
+// Containing returns up to limit words of the vocabulary containing
+// substring, most counted first, then in alphabetical order. A substring
+// shorter than a trigram has none to look up, so it is checked against every
+// word.
+func (ix *Index) Containing(substring string, limit int) []Term {
+	if ix == nil || substring == "" || limit <= 0 {
+		return nil
+	}
+
+	ix.mutex.RLock()
+	defer ix.mutex.RUnlock()
+
+	var terms []Term
+	for word := range ix.candidates(substring) {
+		if strings.Contains(word, substring) {
+			terms = append(terms, Term{Word: word, Count: ix.counts[word]})
+		}
+	}
+
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].Count != terms[j].Count {
+			return terms[i].Count > terms[j].Count
+		}
+		return terms[i].Word < terms[j].Word
+	})
+	if len(terms) > limit {
+		terms = terms[:limit]
+	}
+	return terms
+}
+
+// candidates returns the words listed under the rarest trigram of substring,
+// which every word containing it holds, or every word for a short substring.
+// Caller must hold the read lock.
+func (ix *Index) candidates(substring string) map[string]struct{} {
+	grams := trigrams(substring)
+	if len(grams) == 0 {
+		words := make(map[string]struct{}, len(ix.counts))
+		for word := range ix.counts {
+			words[word] = struct{}{}
+		}
+		return words
+	}
+
+	rarest := ix.grams[grams[0]]
+	for _, gram := range grams[1:] {
+		if words := ix.grams[gram]; len(words) < len(rarest) {
+			rarest = words
+		}
+	}
+	return rarest
+}
+
+// trigrams returns the distinct runs of n characters of word, none when it is shorter
+func trigrams(word string) []string {
+	runes := []rune(word)
+	if len(runes) < n {
+		return nil
+	}
+
+	seen := make(map[string]struct{}, len(runes)-n+1)
+	grams := make([]string, 0, len(runes)-n+1)
+	for i := 0; i+n <= len(runes); i++ {
+		gram := string(runes[i : i+n])
+		if _, ok := seen[gram]; !ok {
+			seen[gram] = struct{}{}
+			grams = append(grams, gram)
+		}
+	}
+	return grams
+}
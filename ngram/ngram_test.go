@@ -0,0 +1,58 @@
+package ngram
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndex_Containing(t *testing.T) {
+	ix := New()
+	ix.Add("iphone case", 5)
+	ix.Add("headphones", 3)
+	ix.Add("phone", 3)
+	ix.Add("photo", 8)
+	ix.Add("ph", 1)
+
+	assert.Equal(t, []Term{{"iphone case", 5}, {"headphones", 3}, {"phone", 3}}, ix.Containing("phone", 10))
+	assert.Equal(t, []Term{{"iphone case", 5}}, ix.Containing("phone", 1))
+	assert.Equal(t, []Term{{"photo", 8}, {"iphone case", 5}, {"headphones", 3}, {"phone", 3}, {"ph", 1}}, ix.Containing("ph", 10), "A short substring is checked against every word")
+	assert.Empty(t, ix.Containing("phones case", 10), "Every trigram must be held in order")
+	assert.Empty(t, ix.Containing("tablet", 10))
+	assert.Empty(t, ix.Containing("", 10))
+	assert.Empty(t, ix.Containing("phone", 0))
+}
+
+func TestIndex_Counts(t *testing.T) {
+	ix := New()
+	ix.Add("iphon", 1)
+	ix.Move("iphon", "iphone")
+	assert.Equal(t, []Term{{"iphone", 1}}, ix.Containing("pho", 10), "A moved word is dropped once its count is gone")
+
+	ix.Add("smartphone", 2)
+	ix.Merge("iphone", "smartphone")
+	assert.Equal(t, []Term{{"smartphone", 3}}, ix.Containing("phone", 10))
+	assert.Equal(t, 1, ix.Len())
+
+	ix.Add("smartphone", -3)
+	assert.Empty(t, ix.Containing("phone", 10))
+	assert.Zero(t, ix.Len())
+	assert.Empty(t, ix.grams, "Trigrams without words are dropped")
+}
+
+func TestIndex_Nil(t *testing.T) {
+	var ix *Index
+	ix.Add("phone", 1)
+	ix.Move("phone", "phones")
+	ix.Merge("phone", "phones")
+	assert.Nil(t, ix.Containing("phone", 10))
+	assert.Zero(t, ix.Len())
+}
+
+func TestIndex_Unicode(t *testing.T) {
+	ix := New()
+	ix.Add("東京タワー", 2)
+	ix.Add("café crème", 1)
+	assert.Equal(t, []Term{{"東京タワー", 2}}, ix.Containing("京タワ", 10))
+	assert.Equal(t, []Term{{"café crème", 1}}, ix.Containing("é cr", 10))
+}
@@ -0,0 +1,155 @@
+package normalize
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/rivo/uniseg"
+)
+
+// EmojiPolicy is how EmojiFolding handles the emoji and pictographic symbols of a search
+type EmojiPolicy int
+
+const (
+	// EmojiKeep leaves them as typed, the loggers' behavior without EmojiFolding
+	EmojiKeep EmojiPolicy = iota
+	// EmojiStrip removes them, so "pizza 🍕" and "pizza" are the same search
+	EmojiStrip
+	// EmojiShortcode replaces them by their :shortcode:, so "🍕" is stored as
+	// ":pizza:" whatever its skin tone or presentation
+	EmojiShortcode
+)
+
+// emojiPolicyNames are the names ParseEmojiPolicy accepts
+var emojiPolicyNames = []string{EmojiKeep: "keep", EmojiStrip: "strip", EmojiShortcode: "shortcode"}
+
+// String returns the lowercase name of p
+func (p EmojiPolicy) String() string {
+	if p < 0 || int(p) >= len(emojiPolicyNames) {
+		return fmt.Sprintf("EmojiPolicy(%d)", int(p))
+	}
+	return emojiPolicyNames[p]
+}
+
+// ParseEmojiPolicy returns the policy named name: keep, strip or shortcode
+func ParseEmojiPolicy(name string) (EmojiPolicy, error) {
+	for i, policyName := range emojiPolicyNames {
+		if strings.EqualFold(strings.TrimSpace(name), policyName) {
+			return EmojiPolicy(i), nil
+		}
+	}
+	return EmojiKeep, fmt.Errorf("unknown emoji policy %q, must be keep, strip or shortcode", name)
+}
+
+// emojiShortcodes are the shortcodes of the common emoji, as chat apps name
+// them. The others get their code point, e.g. ":u1f9a9:".
+var emojiShortcodes = map[rune]string{
+	'😀': "grinning", '😃': "smiley", '😄': "smile", '😁': "grin", '😆': "laughing",
+	'😅': "sweat_smile", '😂': "joy", '🤣': "rofl", '😊': "blush", '😇': "innocent",
+	'🙂': "slightly_smiling_face", '😉': "wink", '😍': "heart_eyes", '😘': "kissing_heart",
+	'😋': "yum", '😎': "sunglasses", '🤔': "thinking", '😐': "neutral_face", '😴': "sleeping",
+	'😢': "cry", '😭': "sob", '😡': "rage", '😱': "scream", '🥳': "partying_face",
+	'👍': "thumbsup", '👎': "thumbsdown", '👏': "clap", '🙏': "pray", '👋': "wave",
+	'💪': "muscle", '👀': "eyes", '❤': "heart", '💔': "broken_heart", '🔥': "fire",
+	'⭐': "star", '✨': "sparkles", '🎉': "tada", '🎁': "gift", '🎂': "birthday",
+	'💯': "100", '✅': "white_check_mark", '❌': "x", '⚠': "warning", '💡': "bulb",
+	'📱': "iphone", '💻': "computer", '📷': "camera", '🎮': "video_game", '🎧': "headphones",
+	'🚗': "car", '🚌': "bus", '✈': "airplane", '🚀': "rocket", '🏠': "house",
+	'☀': "sunny", '☕': "coffee", '🍕': "pizza", '🍔': "hamburger", '🍺': "beer",
+	'🍷': "wine_glass", '🍎': "apple", '🐶': "dog", '🐱': "cat", '🌍': "earth_africa",
+	'💰': "moneybag", '🛒': "shopping_cart", '⚽': "soccer", '🏀': "basketball",
+	'🎵': "musical_note", '📚': "books", '🔍': "mag",
+}
+
+// isPictograph reports whether r is an emoji or a pictographic symbol. The
+// letterlike symbols such as "℃" and the symbols of the Latin-1 block such
+// as "°" are not.
+func isPictograph(r rune) bool {
+	if r >= 0x1f000 && r <= 0x1faff {
+		return true
+	}
+	inBlocks := (r >= 0x2190 && r <= 0x2bff) || r == 0x3030 || r == 0x303d || r == 0x3297 || r == 0x3299
+	return inBlocks && unicode.Is(unicode.So, r)
+}
+
+// isRegionalIndicator reports whether r is one of the letters of a flag emoji
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+// emojiShortcode returns the shortcode of an emoji grapheme, or false when it
+// is not one. A flag is named after its region, a keycap after its key, the
+// skin tones and the presentation selectors are left out.
+func emojiShortcode(grapheme string) (string, bool) {
+	runes := []rune(grapheme)
+	if strings.ContainsRune(grapheme, '\u20e3') {
+		return "keycap_" + string(runes[0]), true
+	}
+	if !isPictograph(runes[0]) {
+		return "", false
+	}
+	if len(runes) >= 2 && isRegionalIndicator(runes[0]) && isRegionalIndicator(runes[1]) {
+		return "flag_" + string([]rune{'a' + runes[0] - 0x1f1e6, 'a' + runes[1] - 0x1f1e6}), true
+	}
+	if name, ok := emojiShortcodes[runes[0]]; ok {
+		return name, true
+	}
+	return fmt.Sprintf("u%x", runes[0]), true
+}
+
+// FoldEmoji applies policy to the emoji of s, one grapheme at a time so a
+// sequence such as a flag or a family counts as one emoji. Stripping collapses
+// the spaces left around them.
+func FoldEmoji(s string, policy EmojiPolicy) string {
+	if policy == EmojiKeep || printableASCII(s) {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	folded := false
+	state := -1
+	for rest := s; rest != ""; {
+		var grapheme string
+		grapheme, rest, _, state = uniseg.FirstGraphemeClusterInString(rest, state)
+		name, ok := emojiShortcode(grapheme)
+		switch {
+		case !ok:
+			b.WriteString(grapheme)
+		case policy == EmojiShortcode:
+			b.WriteString(":" + name + ":")
+			folded = true
+		default:
+			b.WriteByte(' ')
+			folded = true
+		}
+	}
+	if !folded {
+		return s
+	}
+	if policy == EmojiStrip {
+		return strings.Join(strings.Fields(b.String()), " ")
+	}
+	return b.String()
+}
+
+// printableASCII reports whether s only holds printable ASCII characters, which are never emoji
+func printableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// EmojiFolding returns a Normalizer that applies policy to what n returns,
+// e.g. logsearch.WithNormalizer(normalize.EmojiFolding(normalize.Default,
+// normalize.EmojiStrip)). A search made only of emoji is empty once stripped,
+// and both loggers reject it with ErrEmptyWord.
+func EmojiFolding(n Normalizer, policy EmojiPolicy) Normalizer {
+	return Func(func(s string) string {
+		return FoldEmoji(n.Normalize(s), policy)
+	})
+}
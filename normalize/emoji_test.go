@@ -0,0 +1,48 @@
+package normalize
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmojiFolding(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy EmojiPolicy
+		in     string
+		want   string
+	}{
+		{"keeps by default", EmojiKeep, "Pizza 🍕", "pizza 🍕"},
+		{"strips", EmojiStrip, "Pizza 🍕", "pizza"},
+		{"strips between words", EmojiStrip, "pizza 🍕 near me", "pizza near me"},
+		{"strips sequences", EmojiStrip, "family👨‍👩‍👧 trip 🇫🇷", "family trip"},
+		{"strips only emoji", EmojiStrip, "🔥🔥", ""},
+		{"keeps letterlike symbols", EmojiStrip, "25℃ 90°", "25℃ 90°"},
+		{"shortcodes", EmojiShortcode, "Pizza 🍕", "pizza :pizza:"},
+		{"shortcodes skin tones", EmojiShortcode, "👍🏽 deal", ":thumbsup: deal"},
+		{"shortcodes presentation selectors", EmojiShortcode, "i ❤️ ny", "i :heart: ny"},
+		{"shortcodes flags", EmojiShortcode, "🇫🇷 wine", ":flag_fr: wine"},
+		{"shortcodes keycaps", EmojiShortcode, "1️⃣ pick", ":keycap_1: pick"},
+		{"shortcodes unknown emoji", EmojiShortcode, "🦩", ":u1f9a9:"},
+		{"leaves text alone", EmojiShortcode, "москва 東京", "москва 東京"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, EmojiFolding(Default, tt.policy).Normalize(tt.in))
+		})
+	}
+}
+
+func TestParseEmojiPolicy(t *testing.T) {
+	for _, policy := range []EmojiPolicy{EmojiKeep, EmojiStrip, EmojiShortcode} {
+		parsed, err := ParseEmojiPolicy(policy.String())
+		require.NoError(t, err)
+		assert.Equal(t, policy, parsed)
+	}
+
+	_, err := ParseEmojiPolicy("drop")
+	assert.Error(t, err)
+}
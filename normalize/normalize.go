@@ -0,0 +1,82 @@
+// Package normalize maps raw searches to the canonical form both loggers store
+// and compare, so variants of a word share one trie path and one DB row.
+package normalize
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalizer maps a raw search to its canonical form, it must be safe for concurrent use
+type Normalizer interface {
+	Normalize(s string) string
+}
+
+// Func adapts an ordinary function to a Normalizer
+type Func func(s string) string
+
+// Normalize calls f(s)
+func (f Func) Normalize(s string) string {
+	return f(s)
+}
+
+// Default trims surrounding spaces and lowercases, the loggers' behavior without a normalizer
+var Default Normalizer = Func(func(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+})
+
+// Unicode trims surrounding spaces, applies NFKC normalization and lowercases
+// with the rules of a language, optionally stripping diacritics. "Café", the
+// decomposed "cafe\u0301" and the full-width "ｃａｆé" all become "café", or
+// "cafe" with diacritic folding.
+type Unicode struct {
+	lang language.Tag
+	fold bool
+}
+
+// Option configures a Unicode normalizer
+type Option func(*Unicode)
+
+// WithLanguage lowercases with the rules of lang, e.g. language.Turkish maps
+// "I" to "ı" and "İ" to "i". The default is language.Und, Unicode's default rules.
+func WithLanguage(lang language.Tag) Option {
+	return func(u *Unicode) {
+		u.lang = lang
+	}
+}
+
+// WithDiacriticFolding strips combining marks so "café" and "cafe" are the same search
+func WithDiacriticFolding() Option {
+	return func(u *Unicode) {
+		u.fold = true
+	}
+}
+
+// NewUnicode creates a Unicode normalizer
+func NewUnicode(opts ...Option) *Unicode {
+	u := &Unicode{lang: language.Und}
+	for _, opt := range opts {
+		opt(u)
+	}
+	return u
+}
+
+// Normalize returns the canonical form of s
+func (u *Unicode) Normalize(s string) string {
+	s = norm.NFKC.String(strings.TrimSpace(s))
+
+	// Casers and transformers keep state, so they are created per call to stay safe for concurrent use
+	s = cases.Lower(u.lang).String(s)
+	if u.fold {
+		s, _, _ = transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), s)
+	}
+
+	// Lowercasing can leave sequences NFKC would compose
+	return norm.NFKC.String(s)
+}
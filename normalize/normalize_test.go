@@ -0,0 +1,56 @@
+package normalize
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/language"
+)
+
+func TestDefault(t *testing.T) {
+	assert.Equal(t, "café", Default.Normalize("  Café "))
+	assert.NotEqual(t, Default.Normalize("café"), Default.Normalize("cafe\u0301"))
+}
+
+func TestUnicode(t *testing.T) {
+	tests := []struct {
+		name string
+		n    Normalizer
+		in   string
+		want string
+	}{
+		{"composes decomposed forms", NewUnicode(), "Cafe\u0301", "café"},
+		{"folds compatibility forms", NewUnicode(), "ｃａｆé", "café"},
+		{"trims spaces", NewUnicode(), "  Bus ", "bus"},
+		{"keeps diacritics by default", NewUnicode(), "Crème Brûlée", "crème brûlée"},
+		{"strips diacritics", NewUnicode(WithDiacriticFolding()), "Crème Brûlée", "creme brulee"},
+		{"strips decomposed diacritics", NewUnicode(WithDiacriticFolding()), "cafe\u0301", "cafe"},
+		{"lowercases dotless I in Turkish", NewUnicode(WithLanguage(language.Turkish)), "KIRMIZI", "kırmızı"},
+		{"lowercases dotted I in Turkish", NewUnicode(WithLanguage(language.Turkish)), "İSTANBUL", "istanbul"},
+		{"lowercases I by default rules", NewUnicode(), "KIRMIZI", "kirmizi"},
+		{"keeps non latin scripts", NewUnicode(WithDiacriticFolding()), "Москва 東京", "москва 東京"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.n.Normalize(tt.in))
+		})
+	}
+}
+
+func TestUnicode_Concurrent(t *testing.T) {
+	n := NewUnicode(WithLanguage(language.Turkish), WithDiacriticFolding())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				assert.Equal(t, "istanbul cafe", n.Normalize("İstanbul Café"))
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,66 @@
+package normalize
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kljensen/snowball/english"
+	"github.com/kljensen/snowball/french"
+	"github.com/kljensen/snowball/hungarian"
+	"github.com/kljensen/snowball/norwegian"
+	"github.com/kljensen/snowball/russian"
+	"github.com/kljensen/snowball/spanish"
+	"github.com/kljensen/snowball/swedish"
+	"golang.org/x/text/language"
+)
+
+// Stemmer reduces a lowercase word to its stem, e.g. "running" to "run". It
+// must be safe for concurrent use.
+type Stemmer interface {
+	Stem(word string) string
+}
+
+// StemmerFunc adapts an ordinary function to a Stemmer
+type StemmerFunc func(word string) string
+
+// Stem calls f(word)
+func (f StemmerFunc) Stem(word string) string {
+	return f(word)
+}
+
+// snowballStemmers are the Snowball stemmers by base language
+var snowballStemmers = map[string]func(string, bool) string{
+	"en": english.Stem,
+	"es": spanish.Stem,
+	"fr": french.Stem,
+	"hu": hungarian.Stem,
+	"nb": norwegian.Stem,
+	"nn": norwegian.Stem,
+	"no": norwegian.Stem,
+	"ru": russian.Stem,
+	"sv": swedish.Stem,
+}
+
+// NewSnowball returns the Snowball stemmer of lang, e.g. language.English.
+// English, Spanish, French, Hungarian, Norwegian, Russian and Swedish are
+// supported. Stop words such as "the" are left alone.
+func NewSnowball(lang language.Tag) (Stemmer, error) {
+	base, _ := lang.Base()
+	stem, ok := snowballStemmers[base.String()]
+	if !ok {
+		return nil, fmt.Errorf("no snowball stemmer for language %s", lang)
+	}
+	return StemmerFunc(func(word string) string {
+		return stem(word, false)
+	}), nil
+}
+
+// StemWords stems each space separated word of a normalized search, so
+// "running shoes" becomes "run shoe"
+func StemWords(s Stemmer, search string) string {
+	words := strings.Fields(search)
+	for i, word := range words {
+		words[i] = s.Stem(word)
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,30 @@
+package normalize
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/language"
+)
+
+func TestSnowball(t *testing.T) {
+	english, err := NewSnowball(language.English)
+	require.NoError(t, err)
+	assert.Equal(t, "run", english.Stem("running"))
+	assert.Equal(t, "run", english.Stem("runs"))
+	assert.Equal(t, "the", english.Stem("the"), "Stop words are left alone")
+	assert.Equal(t, "run shoe", StemWords(english, " running  shoes "))
+
+	// Regional variants use the stemmer of their base language
+	british, err := NewSnowball(language.BritishEnglish)
+	require.NoError(t, err)
+	assert.Equal(t, "run", british.Stem("running"))
+
+	french, err := NewSnowball(language.French)
+	require.NoError(t, err)
+	assert.Equal(t, "chanson", french.Stem("chansons"))
+
+	_, err = NewSnowball(language.Japanese)
+	assert.Error(t, err)
+}
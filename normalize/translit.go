@@ -0,0 +1,173 @@
+package normalize
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Script is a non-Latin script Transliterating maps to Latin letters
+type Script int
+
+const (
+	// Cyrillic covers Russian, Ukrainian, Belarusian, Serbian and Bulgarian letters
+	Cyrillic Script = iota
+	// Greek covers the monotonic Greek letters
+	Greek
+	// Arabic covers Arabic and Persian letters and digits, dropping the vowel marks
+	Arabic
+)
+
+// scriptNames are the names ParseScript accepts
+var scriptNames = map[string]Script{
+	"cyrillic": Cyrillic,
+	"greek":    Greek,
+	"arabic":   Arabic,
+}
+
+// String returns the lowercase name of s
+func (s Script) String() string {
+	for name, script := range scriptNames {
+		if script == s {
+			return name
+		}
+	}
+	return fmt.Sprintf("Script(%d)", int(s))
+}
+
+// ParseScript returns the script named name, e.g. "cyrillic"
+func ParseScript(name string) (Script, error) {
+	script, ok := scriptNames[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return 0, fmt.Errorf("unknown script %q, must be cyrillic, greek or arabic", name)
+	}
+	return script, nil
+}
+
+// cyrillicLatin transliterates the lowercase Cyrillic letters, close to the
+// romanizations the users type, e.g. "щ" to "shch"
+var cyrillicLatin = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya",
+	// Ukrainian and Belarusian
+	'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g", 'ў': "u",
+	// Serbian and Macedonian
+	'ђ': "dj", 'ј': "j", 'љ': "lj", 'њ': "nj", 'ћ': "c", 'џ': "dz", 'ѓ': "gj", 'ќ': "kj", 'ѕ': "dz",
+}
+
+// greekLatin transliterates the lowercase Greek letters, accented or not
+var greekLatin = map[rune]string{
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i", 'θ': "th",
+	'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x", 'ο': "o", 'π': "p",
+	'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t", 'υ': "y", 'φ': "f", 'χ': "ch", 'ψ': "ps",
+	'ω': "o",
+	'ά': "a", 'έ': "e", 'ή': "i", 'ί': "i", 'ϊ': "i", 'ΐ': "i", 'ό': "o", 'ύ': "y",
+	'ϋ': "y", 'ΰ': "y", 'ώ': "o",
+}
+
+// greekDigraphs are the Greek letter pairs romanized as a whole, checked before the letters
+var greekDigraphs = map[string]string{
+	"ου": "ou", "ού": "ou",
+}
+
+// arabicLatin transliterates the Arabic and Persian letters and digits. The
+// hamza and the ayn, which the users leave out, are dropped like the vowel
+// marks.
+var arabicLatin = map[rune]string{
+	'ا': "a", 'أ': "a", 'إ': "i", 'آ': "a", 'ٱ': "a", 'ب': "b", 'ت': "t", 'ث': "th",
+	'ج': "j", 'ح': "h", 'خ': "kh", 'د': "d", 'ذ': "dh", 'ر': "r", 'ز': "z", 'س': "s",
+	'ش': "sh", 'ص': "s", 'ض': "d", 'ط': "t", 'ظ': "z", 'ع': "", 'غ': "gh", 'ف': "f",
+	'ق': "q", 'ك': "k", 'ل': "l", 'م': "m", 'ن': "n", 'ه': "h", 'و': "w", 'ي': "y",
+	'ى': "a", 'ة': "a", 'ء': "", 'ؤ': "", 'ئ': "",
+	// Persian
+	'پ': "p", 'چ': "ch", 'ژ': "zh", 'گ': "g", 'ک': "k", 'ی': "y",
+	// Arabic-Indic and Persian digits
+	'٠': "0", '١': "1", '٢': "2", '٣': "3", '٤': "4", '٥': "5", '٦': "6", '٧': "7", '٨': "8", '٩': "9",
+	'۰': "0", '۱': "1", '۲': "2", '۳': "3", '۴': "4", '۵': "5", '۶': "6", '۷': "7", '۸': "8", '۹': "9",
+}
+
+// isArabicMark reports whether r is an Arabic vowel mark or the tatweel, which
+// the transliteration drops
+func isArabicMark(r rune) bool {
+	return (r >= '\u064b' && r <= '\u065f') || r == '\u0670' || r == '\u0640'
+}
+
+// Transliterator maps the letters of non-Latin scripts to Latin letters, so
+// "москва", "Москва" and "moskva" are the same search. Latin text and the
+// letters of the other scripts are left alone.
+type Transliterator struct {
+	letters  map[rune]string
+	digraphs map[string]string
+	arabic   bool
+}
+
+// NewTransliterator creates a Transliterator of scripts, every supported
+// script when none is given
+func NewTransliterator(scripts ...Script) *Transliterator {
+	if len(scripts) == 0 {
+		scripts = []Script{Cyrillic, Greek, Arabic}
+	}
+
+	t := &Transliterator{letters: make(map[rune]string), digraphs: make(map[string]string)}
+	for _, script := range scripts {
+		switch script {
+		case Cyrillic:
+			copyLetters(t.letters, cyrillicLatin)
+		case Greek:
+			copyLetters(t.letters, greekLatin)
+			for digraph, latin := range greekDigraphs {
+				t.digraphs[digraph] = latin
+			}
+		case Arabic:
+			copyLetters(t.letters, arabicLatin)
+			t.arabic = true
+		}
+	}
+	return t
+}
+
+// copyLetters adds the letters of src to dst
+func copyLetters(dst, src map[rune]string) {
+	for r, latin := range src {
+		dst[r] = latin
+	}
+}
+
+// Transliterate returns s with the letters of the scripts replaced. s is
+// expected lowercase, as a normalizer leaves it.
+func (t *Transliterator) Transliterate(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if i+1 < len(runes) {
+			if latin, ok := t.digraphs[string(runes[i:i+2])]; ok {
+				b.WriteString(latin)
+				i++
+				continue
+			}
+		}
+		if latin, ok := t.letters[r]; ok {
+			b.WriteString(latin)
+			continue
+		}
+		if t.arabic && isArabicMark(r) {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Transliterating returns a Normalizer that transliterates what n returns,
+// e.g. logsearch.WithNormalizer(normalize.Transliterating(normalize.Default, t)),
+// so a search typed in a non-Latin script counts for the same word as its
+// Latin transliteration.
+func Transliterating(n Normalizer, t *Transliterator) Normalizer {
+	return Func(func(s string) string {
+		return t.Transliterate(n.Normalize(s))
+	})
+}
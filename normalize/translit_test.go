@@ -0,0 +1,58 @@
+package normalize
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransliterating(t *testing.T) {
+	tests := []struct {
+		name    string
+		scripts []Script
+		in      string
+		want    string
+	}{
+		{"cyrillic", []Script{Cyrillic}, "  Москва ", "moskva"},
+		{"cyrillic digraphs", []Script{Cyrillic}, "Щука и Жук", "shchuka i zhuk"},
+		{"cyrillic signs", []Script{Cyrillic}, "объявление", "obyavlenie"},
+		{"ukrainian", []Script{Cyrillic}, "Київ", "kiyiv"},
+		{"greek", []Script{Greek}, "Αθήνα", "athina"},
+		{"greek final sigma", []Script{Greek}, "ΚΑΦΕΣ καφές", "kafes kafes"},
+		{"greek digraph", []Script{Greek}, "Μουσείο", "mouseio"},
+		{"arabic", []Script{Arabic}, "بيروت", "byrwt"},
+		{"arabic vowel marks", []Script{Arabic}, "كِتَاب", "ktab"},
+		{"arabic digits", []Script{Arabic}, "iphone ١٥", "iphone 15"},
+		{"every script by default", nil, "Москва Αθήνα", "moskva athina"},
+		{"other scripts left alone", []Script{Greek}, "Москва 東京 athina", "москва 東京 athina"},
+		{"latin left alone", nil, "moskva", "moskva"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := Transliterating(Default, NewTransliterator(tt.scripts...))
+			assert.Equal(t, tt.want, n.Normalize(tt.in))
+		})
+	}
+}
+
+func TestTransliterating_Unicode(t *testing.T) {
+	n := Transliterating(NewUnicode(WithDiacriticFolding()), NewTransliterator())
+	assert.Equal(t, n.Normalize("Αθήνα"), n.Normalize("athina"))
+	assert.Equal(t, "cafe moskva", n.Normalize("Café Москва"))
+}
+
+func TestParseScript(t *testing.T) {
+	for _, script := range []Script{Cyrillic, Greek, Arabic} {
+		parsed, err := ParseScript(script.String())
+		require.NoError(t, err)
+		assert.Equal(t, script, parsed)
+	}
+	parsed, err := ParseScript(" Greek")
+	require.NoError(t, err)
+	assert.Equal(t, Greek, parsed)
+
+	_, err = ParseScript("latin")
+	assert.Error(t, err)
+}
@@ -0,0 +1,110 @@
+// Package ops serves the endpoints operators use to look inside a running
+// logger: the pprof profiles of the Go runtime and a JSON snapshot of the trie
+// internals. They expose the process internals and profiling costs CPU, so
+// serve them on a private address rather than next to the search API.
+package ops
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/trie"
+)
+
+// TrieIntrospector reports the internals of a trie, implemented by trie.SearchLogger
+type TrieIntrospector interface {
+	RuntimeStats() trie.RuntimeStats
+	ExportSubtrieDOT(w io.Writer, prefix string, maxDepth int) error
+}
+
+// GoStats are the figures of the Go runtime worth checking first when the process grows
+type GoStats struct {
+	Goroutines     int           `json:"goroutines"`
+	HeapAllocBytes uint64        `json:"heap_alloc_bytes"`
+	HeapInuseBytes uint64        `json:"heap_inuse_bytes"`
+	SysBytes       uint64        `json:"sys_bytes"`
+	NumGC          uint32        `json:"num_gc"`
+	GCPauseTotal   time.Duration `json:"gc_pause_total_ns"`
+}
+
+// StatsResponse is the body of /debug/stats
+type StatsResponse struct {
+	Trie *trie.RuntimeStats `json:"trie,omitempty"`
+	Go   GoStats            `json:"go"`
+}
+
+// NewHandler serves the pprof profiles under /debug/pprof/, e.g.
+// `go tool pprof http://localhost:6060/debug/pprof/heap`, and the stats of the
+// trie, nil to leave them out, and of the Go runtime on /debug/stats. The trie
+// is rendered as Graphviz DOT on /debug/trie.dot?prefix=bu&depth=3, e.g.
+// piped to `dot -Tsvg`.
+func NewHandler(t TrieIntrospector) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/trie.dot", func(w http.ResponseWriter, r *http.Request) {
+		if t == nil {
+			http.NotFound(w, r)
+			return
+		}
+		depth := 0
+		if raw := r.URL.Query().Get("depth"); raw != "" {
+			var err error
+			if depth, err = strconv.Atoi(raw); err != nil || depth < 0 {
+				http.Error(w, "depth must be a non-negative integer", http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Rendered first, so a missing prefix is answered with a 404 rather than a truncated graph
+		var buf bytes.Buffer
+		if err := t.ExportSubtrieDOT(&buf, r.URL.Query().Get("prefix"), depth); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, logsearch.ErrWordNotFound) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		buf.WriteTo(w)
+	})
+	mux.HandleFunc("/debug/stats", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var resp StatsResponse
+		if t != nil {
+			stats := t.RuntimeStats()
+			resp.Trie = &stats
+		}
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		resp.Go = GoStats{
+			Goroutines:     runtime.NumGoroutine(),
+			HeapAllocBytes: mem.HeapAlloc,
+			HeapInuseBytes: mem.HeapInuse,
+			SysBytes:       mem.Sys,
+			NumGC:          mem.NumGC,
+			GCPauseTotal:   time.Duration(mem.PauseTotalNs),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+	return mux
+}
@@ -0,0 +1,80 @@
+package ops
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/trie"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	ctx := context.Background()
+	logger, err := trie.NewSearchLogger(time.Hour)
+	require.NoError(t, err)
+	defer logger.Close()
+	for _, word := range []string{"b", "bu", "bus", "cat"} {
+		require.NoError(t, logger.LogSearch(ctx, word))
+	}
+
+	handler := NewHandler(logger)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/stats", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp StatsResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	require.NotNil(t, resp.Trie)
+	assert.Equal(t, 6, resp.Trie.Nodes)
+	assert.Equal(t, 4, resp.Trie.PendingWords)
+	assert.Positive(t, resp.Trie.EstimatedBytes)
+	assert.Positive(t, resp.Go.Goroutines)
+
+	// The flush cycle stores the words, only the leaves "bus" and "cat"
+	require.NoError(t, logger.Flush(ctx))
+	stats := logger.RuntimeStats()
+	assert.Zero(t, stats.PendingWords)
+	assert.Equal(t, int64(1), stats.Flushes.Cycles)
+	assert.Equal(t, int64(2), stats.Flushes.Words)
+	assert.Positive(t, stats.Lock.Acquisitions)
+
+	rec = httptest.NewRecorder()
+	NewHandler(nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/stats", nil))
+	assert.NotContains(t, rec.Body.String(), `"trie"`)
+}
+
+func TestPprof(t *testing.T) {
+	handler := NewHandler(nil)
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/goroutine?debug=1", "/debug/pprof/cmdline"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, rec.Code, path)
+	}
+}
+
+func TestTrieDOT(t *testing.T) {
+	logger, err := trie.NewSearchLogger(time.Hour)
+	require.NoError(t, err)
+	defer logger.Close()
+	require.NoError(t, logger.LogSearch(context.Background(), "bus"))
+
+	handler := NewHandler(logger)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/trie.dot?prefix=bu&depth=1", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/vnd.graphviz; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), `[label="bus", style=dashed]`)
+
+	for path, status := range map[string]int{
+		"/debug/trie.dot?prefix=dog": http.StatusNotFound,
+		"/debug/trie.dot?depth=-1":   http.StatusBadRequest,
+	} {
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, status, rec.Code, path)
+	}
+}
@@ -0,0 +1,152 @@
+package logsearch
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/afanwang/logsearch/metrics"
+	"github.com/afanwang/logsearch/store"
+)
+
+// GlobalSuggester returns the completions of a prefix popular over all users,
+// most relevant first. trie.SearchLogger implements it.
+type GlobalSuggester interface {
+	Suggest(prefix string, limit int) ([]string, error)
+}
+
+// defaultUserWeight balances the user's own searches and the global completions
+// of SuggestForUser when WithGlobalSuggestions is not given a valid weight
+const defaultUserWeight = 0.5
+
+// WithGlobalSuggestions blends the completions of global, e.g. the Version 1
+// trie, into SuggestForUser. userWeight in [0, 1] is the share of a
+// suggestion's score coming from the user's own searches, the rest comes from
+// its rank in global: 1 only ranks the user's searches, 0 only the global
+// completions, and values outside [0, 1] fall back to 0.5.
+func WithGlobalSuggestions(global GlobalSuggester, userWeight float64) Option {
+	return func(sl *SearchLoggerV2) {
+		if userWeight < 0 || userWeight > 1 {
+			userWeight = defaultUserWeight
+		}
+		sl.global = global
+		sl.userWeight = userWeight
+	}
+}
+
+// SuggestForUser returns up to limit completions of prefix for a user, merging
+// the user's own stored searches with the global completions of
+// WithGlobalSuggestions. A user's word scores its search count relative to
+// their most searched word of the prefix, a global completion scores by its
+// rank, and both scores are blended with the user weight, ties in alphabetical
+// order. Without WithGlobalSuggestions only the user's searches are suggested.
+// WithClickBoost adds the click-through rates of the completions to their
+// scores, and WithSynonyms also completes the prefix with its aliases replaced.
+func (sl *SearchLoggerV2) SuggestForUser(ctx context.Context, userIdentifier, prefix string, limit int) ([]string, error) {
+	if userIdentifier == "" {
+		return nil, ErrEmptyUser
+	}
+	prefix = sl.normalizer.Normalize(prefix)
+	if limit <= 0 {
+		return []string{}, nil
+	}
+	var suggestions []string
+	if sl.cachedResult(ctx, metrics.QuerySuggest, userKey(userIdentifier), suggestField(prefix, limit), &suggestions) {
+		return suggestions, nil
+	}
+
+	prefixes := sl.synonyms.expand(prefix)
+	userCounts, err := sl.userPrefixCounts(ctx, userIdentifier, prefixes)
+	if err != nil {
+		return nil, err
+	}
+
+	// A global completion of several prefixes scores by its best rank
+	globalScores := make(map[string]float64)
+	if sl.global != nil {
+		for _, prefix := range prefixes {
+			global, err := sl.global.Suggest(prefix, limit)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get global suggestions: %w", err)
+			}
+			for rank, word := range global {
+				globalScores[word] = max(globalScores[word], float64(len(global)-rank)/float64(len(global)))
+			}
+		}
+	}
+
+	userWeight := 1.0
+	if sl.global != nil {
+		userWeight = sl.userWeight
+	}
+
+	maxCount := 0
+	for _, count := range userCounts {
+		maxCount = max(maxCount, count)
+	}
+	scores := make(map[string]float64, len(userCounts)+len(globalScores))
+	for word, count := range userCounts {
+		scores[word] += userWeight * float64(count) / float64(maxCount)
+	}
+	for word, score := range globalScores {
+		scores[word] += (1 - userWeight) * score
+	}
+	if err := sl.boostClicks(ctx, scores); err != nil {
+		return nil, err
+	}
+
+	suggestions = make([]string, 0, len(scores))
+	for word := range scores {
+		suggestions = append(suggestions, word)
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		a, b := suggestions[i], suggestions[j]
+		if scores[a] != scores[b] {
+			return scores[a] > scores[b]
+		}
+		return a < b
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	sl.cacheResult(ctx, userKey(userIdentifier), suggestField(prefix, limit), suggestions)
+	return suggestions, nil
+}
+
+// userPrefixCounts returns the search counts of the user's words starting
+// with any of prefixes. Stores that cannot read full records count every word once.
+func (sl *SearchLoggerV2) userPrefixCounts(ctx context.Context, userIdentifier string, prefixes []string) (map[string]int, error) {
+	counts := make(map[string]int)
+
+	if exportStore, ok := sl.db.(store.UserExportStore); ok {
+		err := exportStore.ForEachUserSearch(ctx, userIdentifier, func(record store.UserSearchRecord) error {
+			if hasAnyPrefix(record.SearchWord, prefixes) {
+				counts[record.SearchWord] += max(record.SearchCount, 1)
+			}
+			return nil
+		})
+		return counts, store.Classify(err)
+	}
+
+	words, err := sl.GetUserSearches(ctx, userIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	for _, word := range words {
+		if hasAnyPrefix(word, prefixes) {
+			counts[word] = 1
+		}
+	}
+	return counts, nil
+}
+
+// hasAnyPrefix reports whether word starts with any of prefixes
+func hasAnyPrefix(word string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(word, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package logsearch
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fixedSuggester suggests its words starting with the prefix, in order
+type fixedSuggester []string
+
+func (f fixedSuggester) Suggest(prefix string, limit int) ([]string, error) {
+	var suggestions []string
+	for _, word := range f {
+		if len(suggestions) < limit && strings.HasPrefix(word, prefix) {
+			suggestions = append(suggestions, word)
+		}
+	}
+	return suggestions, nil
+}
+
+func TestSuggestForUser(t *testing.T) {
+	ctx := context.Background()
+	global := fixedSuggester{"burger", "bus", "butter", "cat"}
+	logger, err := NewSearchLoggerV2(WithGlobalSuggestions(global, 0.5))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	for _, word := range []string{"bus", "bus", "bus", "bug", "cab"} {
+		assert.NoError(t, logger.LogSearchV2(ctx, "user_1", word))
+	}
+
+	// "bus" is searched by the user and popular, the user's rare "bug" ties with the last global completion
+	suggestions, err := logger.SuggestForUser(ctx, "user_1", "BU", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bus", "burger", "bug", "butter"}, suggestions)
+
+	suggestions, err = logger.SuggestForUser(ctx, "user_1", "bu", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bus", "burger"}, suggestions)
+
+	// A user without history gets the global completions
+	suggestions, err = logger.SuggestForUser(ctx, "user_2", "bu", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"burger", "bus", "butter"}, suggestions)
+
+	_, err = logger.SuggestForUser(ctx, "", "bu", 10)
+	assert.ErrorIs(t, err, ErrEmptyUser)
+}
+
+func TestSuggestForUserWithoutGlobal(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLoggerV2()
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	for _, word := range []string{"bug", "bus", "bus"} {
+		assert.NoError(t, logger.LogSearchV2(ctx, "user_1", word))
+	}
+
+	suggestions, err := logger.SuggestForUser(ctx, "user_1", "bu", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bus", "bug"}, suggestions)
+}
@@ -0,0 +1,224 @@
+package logsearch
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/afanwang/logsearch/metrics"
+	"github.com/afanwang/logsearch/store"
+	"github.com/afanwang/logsearch/tracing"
+)
+
+// Stage is a built-in stage of the ingest chain of SearchLoggerV2. Every search
+// runs through StageValidate, StageNormalize, StageFilter, StageDedup then
+// StageStore, and WithProcessor inserts custom stages before any of them.
+type Stage int
+
+const (
+	// StageValidate rejects empty users and sanitizes the raw search, see WithValidator
+	StageValidate Stage = iota
+	// StageNormalize maps the search to the form stored and compared, see
+	// WithNormalizer
+	StageNormalize
+	// StageFilter drops the searches rejected by WithFilters
+	StageFilter
+	// StageDedup drops the redelivered searches. With WithFinalizePolicy it
+	// holds the search until its user stopped typing it.
+	StageDedup
+	// StageStore stems the finished search, then extends, ignores or stores it
+	// against the stored words of its user. WithQuarantineFilters quarantines
+	// it first.
+	StageStore
+)
+
+// Next passes a search on to the rest of the ingest chain
+type Next func(ctx context.Context, event SearchEvent) error
+
+// Processor is a custom stage of the ingest chain, e.g. scrubbing personal
+// data or canonicalizing searches, without forking the dedup. It may rewrite
+// the search before passing it on to next, drop it by returning nil without
+// calling next, or reject it with an error returned by LogSearchV2. It must be
+// safe for concurrent use.
+type Processor interface {
+	Process(ctx context.Context, event SearchEvent, next Next) error
+}
+
+// ProcessorFunc adapts a plain function to a Processor
+type ProcessorFunc func(ctx context.Context, event SearchEvent, next Next) error
+
+// Process calls f
+func (f ProcessorFunc) Process(ctx context.Context, event SearchEvent, next Next) error {
+	return f(ctx, event, next)
+}
+
+// WithProcessor inserts p into the ingest chain right before the built-in
+// stage, after the processors already inserted there. E.g. a processor before
+// StageValidate sees the raw searches, one before StageDedup sees them
+// sanitized, normalized and filtered, one before StageStore sees the finished
+// searches.
+func WithProcessor(before Stage, p Processor) Option {
+	return func(sl *SearchLoggerV2) {
+		if p == nil {
+			return
+		}
+		if sl.processors == nil {
+			sl.processors = make(map[Stage][]Processor)
+		}
+		sl.processors[before] = append(sl.processors[before], p)
+	}
+}
+
+// buildChain links the built-in stages and the processors inserted before
+// them, and keeps the rest of the chain from StageStore for the searches the
+// dedup finishes later
+func (sl *SearchLoggerV2) buildChain() Next {
+	next := Next(sl.storeStage)
+	for _, stage := range []struct {
+		stage Stage
+		run   ProcessorFunc
+	}{
+		{StageStore, nil},
+		{StageDedup, sl.dedupStage},
+		{StageFilter, sl.filterStage},
+		{StageNormalize, sl.normalizeStage},
+		{StageValidate, sl.validateStage},
+	} {
+		if stage.run != nil {
+			next = link(stage.run, next)
+		}
+		processors := sl.processors[stage.stage]
+		for i := len(processors) - 1; i >= 0; i-- {
+			next = link(processors[i], next)
+		}
+		if stage.stage == StageStore {
+			sl.store = next
+		}
+	}
+	return next
+}
+
+// link calls p with next as the rest of the chain
+func link(p Processor, next Next) Next {
+	return func(ctx context.Context, event SearchEvent) error {
+		return p.Process(ctx, event, next)
+	}
+}
+
+// validateStage rejects empty users and negative result counts, and sanitizes the raw search and its region
+func (sl *SearchLoggerV2) validateStage(ctx context.Context, event SearchEvent, next Next) error {
+	if event.UserIdentifier == "" {
+		return ErrEmptyUser
+	}
+	word, err := sl.validator.Sanitize(event.Query)
+	if err != nil {
+		return err
+	}
+	tracing.SetWordLength(ctx, len(word))
+	if sl.regions {
+		region, err := regionOf(event)
+		if err != nil {
+			return err
+		}
+		event.Country, event.Locale = region.Country, region.Locale
+	}
+
+	if event.ResultCount != nil && *event.ResultCount < 0 {
+		return fmt.Errorf("%w: result count %d is negative", ErrInvalidInput, *event.ResultCount)
+	}
+
+	event.Query = word
+	return next(ctx, event)
+}
+
+// normalizeStage maps the search to the form stored and compared
+func (sl *SearchLoggerV2) normalizeStage(ctx context.Context, event SearchEvent, next Next) error {
+	// Normalization may leave nothing of a word made of spaces
+	event.Query = sl.normalizer.Normalize(event.Query)
+	if event.Query == "" {
+		return ErrEmptyWord
+	}
+
+	return next(ctx, event)
+}
+
+// filterStage drops the searches rejected by the filters
+func (sl *SearchLoggerV2) filterStage(ctx context.Context, event SearchEvent, next Next) error {
+	if !event.finalize && !event.click && !Allowed(sl.filters, event.Query) {
+		if event.Submitted && sl.sessions != nil {
+			// The search is over all the same, its pending keystrokes are drafts left behind
+			sl.sessions.drop(event.UserIdentifier, event.Query)
+		}
+		fmt.Fprintf(sl.out, " (filtered)")
+		sl.decided(ctx, metrics.DecisionFilter)
+		return nil
+	}
+
+	return next(ctx, event)
+}
+
+// dedupStage holds the search until finalized, or passes it on to be stored right away
+func (sl *SearchLoggerV2) dedupStage(ctx context.Context, event SearchEvent, next Next) error {
+	if event.finalize {
+		return sl.finalizeStage(ctx, event)
+	}
+	if event.click {
+		return sl.clickStage(ctx, event)
+	}
+
+	now := time.Now()
+	sl.metrics.SearchLogged(metrics.LoggerV2)
+	if reason := sl.abuse.check(event.UserIdentifier, event.Query, now); reason != "" {
+		return sl.quarantine(ctx, event.UserIdentifier, event.Query, reason, now)
+	}
+
+	if sl.coalesce != nil {
+		return sl.coalesce.do(ctx, sl, event, func() error { return sl.dedup(ctx, event, now, next) })
+	}
+	return sl.dedup(ctx, event, now, next)
+}
+
+// dedup holds the search received at now until finalized, or passes it on to next right away
+func (sl *SearchLoggerV2) dedup(ctx context.Context, event SearchEvent, now time.Time, next Next) error {
+	delivery, err := sl.deliveryOf(ctx, event)
+	if err != nil {
+		return err
+	}
+	if delivery == redelivered {
+		fmt.Fprintf(sl.out, " (redelivered)")
+		sl.decided(ctx, metrics.DecisionDuplicate)
+		return nil
+	}
+
+	event.at, event.late = now, delivery == late
+	if event.Submitted {
+		if sl.sessions != nil {
+			// The keystrokes of the search are not searches of their own
+			sl.sessions.drop(event.UserIdentifier, event.Query)
+		}
+		return next(ctx, event)
+	}
+	// A late search is judged right away, the words held for its user were typed after it
+	if sl.sessions != nil && !event.late {
+		completed := sl.sessions.track(event.UserIdentifier, event.Query, now, store.Region{Country: event.Country, Locale: event.Locale})
+		fmt.Fprintf(sl.out, " (pending)")
+		return sl.finalizeWords(ctx, completed)
+	}
+
+	return next(ctx, event)
+}
+
+// storeStage ends the chain: it stores the finished search against the stored words of its user
+func (sl *SearchLoggerV2) storeStage(ctx context.Context, event SearchEvent) error {
+	region := store.Region{Country: event.Country, Locale: event.Locale}
+	if event.Submitted {
+		return sl.submit(ctx, event.UserIdentifier, event.Query, event.at, region, event.ResultCount)
+	}
+
+	// Handle word extension and storage in a single operation
+	if err := sl.storeOrExtendUserSearch(ctx, event.UserIdentifier, event.Query, event.at, event.late, region); err != nil {
+		return fmt.Errorf("failed to store user search: %w", store.Classify(err))
+	}
+
+	return nil
+}
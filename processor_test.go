@@ -0,0 +1,99 @@
+package logsearch
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchLoggerV2_Processors(t *testing.T) {
+	ctx := context.Background()
+	var mutex sync.Mutex
+	var order []string
+	// record notes which stage saw which search
+	record := func(name string) Processor {
+		return ProcessorFunc(func(ctx context.Context, event SearchEvent, next Next) error {
+			mutex.Lock()
+			order = append(order, name+":"+event.Query)
+			mutex.Unlock()
+			return next(ctx, event)
+		})
+	}
+	errRejected := errors.New("rejected")
+	canonical := ProcessorFunc(func(ctx context.Context, event SearchEvent, next Next) error {
+		switch {
+		case event.Query == "nyc":
+			event.Query = "new york"
+		case strings.HasPrefix(event.Query, "secret"):
+			// Dropped without an error
+			return nil
+		case event.Query == "bad":
+			return errRejected
+		}
+		return next(ctx, event)
+	})
+
+	logger, err := NewSearchLoggerV2(
+		WithProcessor(StageDedup, record("dedup")),
+		WithProcessor(StageValidate, record("validate")),
+		WithProcessor(StageNormalize, record("normalize")),
+		WithProcessor(StageDedup, canonical),
+		WithProcessor(StageDedup, nil),
+	)
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", " NYC "))
+	assert.Equal(t, []string{"validate: NYC ", "normalize: NYC ", "dedup:nyc"}, order)
+
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "Secret Plan"))
+	assert.ErrorIs(t, logger.LogSearchV2(ctx, "user_1", "bad"), errRejected)
+	// The built-in stages still reject invalid searches before the processors after them
+	assert.ErrorIs(t, logger.LogSearchV2(ctx, "", "bus"), ErrEmptyUser)
+	assert.ErrorIs(t, logger.LogSearchV2(ctx, "user_1", "   "), ErrEmptyWord)
+
+	searches, err := logger.GetUserSearches(ctx, "user_1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"new york"}, searches)
+}
+
+func TestSearchLoggerV2_ProcessorStages(t *testing.T) {
+	ctx := context.Background()
+	var mutex sync.Mutex
+	var order []string
+	record := func(name string) Processor {
+		return ProcessorFunc(func(ctx context.Context, event SearchEvent, next Next) error {
+			mutex.Lock()
+			order = append(order, name+":"+event.Query)
+			mutex.Unlock()
+			return next(ctx, event)
+		})
+	}
+
+	logger, err := NewSearchLoggerV2(
+		WithFilters(MinLength(3)),
+		WithFinalizePolicy(FinalizePolicy{Idle: time.Hour}),
+		WithProcessor(StageStore, record("store")),
+		WithProcessor(StageFilter, record("filter")),
+		WithProcessor(StageDedup, record("dedup")),
+	)
+	require.NoError(t, err)
+	defer logger.Close()
+
+	// A stage before StageDedup runs after the filter, one before StageStore on the finished search
+	for _, word := range []string{"b", "bu", "bus"} {
+		require.NoError(t, logger.LogSearchV2(ctx, "user_1", word))
+	}
+	require.NoError(t, logger.Finalize(ctx, "user_1", "bus"))
+	assert.Equal(t, []string{"filter:b", "filter:bu", "filter:bus", "dedup:bus", "filter:bus", "dedup:bus", "store:bus"}, order)
+
+	searches, err := logger.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bus"}, searches)
+}
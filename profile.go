@@ -0,0 +1,92 @@
+package logsearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// SearchProfile is the search volume of a word by hour of day and by day of
+// week, in the location it was asked for
+type SearchProfile struct {
+	// Hours counts the searches of every hour of day, Hours[0] from midnight to 1am
+	Hours [24]int
+	// Weekdays counts the searches of every day of week, indexed by time.Weekday
+	Weekdays [7]int
+	// Searches is the total of the profile
+	Searches int
+}
+
+// add counts count searches in the hour starting at hour
+func (p *SearchProfile) add(hour time.Time, count int, loc *time.Location) {
+	hour = hour.In(loc)
+	p.Hours[hour.Hour()] += count
+	p.Weekdays[hour.Weekday()] += count
+	p.Searches += count
+}
+
+// GetSearchProfile returns when word was searched in [from, to) by hour of
+// day and day of week in loc, UTC when nil, every word when word is empty, so
+// dashboards show when users search a term. The hours already rolled up by
+// WithRollups are read from the hourly rollups, which outlive the records
+// purged by WithRetention, and the later ones from the records. Searches are
+// bucketed by whole UTC hours, from is rounded down to its hour, so the
+// profile is exact for locations offset by whole hours. A record keeps only
+// its last search, so all the searches of a word by a user count at the last
+// one. The store must implement store.HourlySearchStore.
+func (sl *SearchLoggerV2) GetSearchProfile(ctx context.Context, word string, from, to time.Time, loc *time.Location) (SearchProfile, error) {
+	hourlyStore, ok := sl.db.(store.HourlySearchStore)
+	if !ok {
+		return SearchProfile{}, errors.New("store does not support hourly searches")
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	if word != "" {
+		word = sl.normalizer.Normalize(word)
+	}
+	from = store.Hourly.Truncate(from)
+
+	var profile SearchProfile
+	if rollupStore, ok := sl.db.(store.RollupStore); ok && sl.rollups != nil {
+		until, err := rollupStore.RolledUpUntil(ctx, store.Hourly)
+		if err != nil {
+			return SearchProfile{}, fmt.Errorf("failed to get search profile: %w", store.Classify(err))
+		}
+		if until.After(from) {
+			rollups, err := rollupStore.GetRollups(ctx, from, minTime(until, to), store.Hourly)
+			if err != nil {
+				return SearchProfile{}, fmt.Errorf("failed to get search profile: %w", store.Classify(err))
+			}
+			for _, rollup := range rollups {
+				if word == "" || rollup.Word == word {
+					profile.add(rollup.Bucket, rollup.TotalCount, loc)
+				}
+			}
+			from = until
+		}
+	}
+	if !from.Before(to) {
+		return profile, nil
+	}
+
+	hours, err := hourlyStore.SearchesByHour(ctx, from, to, word)
+	if err != nil {
+		return SearchProfile{}, fmt.Errorf("failed to get search profile: %w", store.Classify(err))
+	}
+	for _, hour := range hours {
+		profile.add(hour.Bucket, hour.TotalCount, loc)
+	}
+	return profile, nil
+}
+
+// minTime returns the earlier of a and b
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,57 @@
+package logsearch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchLoggerV2_SearchProfile(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDBV2()
+	logger, err := NewSearchLoggerV2WithDB(db, WithRollups(time.Hour, 0))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	// A Wednesday, rolled up until 10:00
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, logger.storeOrExtendUserSearch(ctx, "user_1", "cat", day.Add(9*time.Hour+10*time.Minute), false, store.Region{}))
+	require.NoError(t, logger.storeOrExtendUserSearch(ctx, "user_2", "cat", day.Add(9*time.Hour+30*time.Minute), false, store.Region{}))
+	require.NoError(t, logger.storeOrExtendUserSearch(ctx, "user_1", "dog", day.Add(9*time.Hour+40*time.Minute), false, store.Region{}))
+	_, err = logger.Rollup(ctx, day.Add(10*time.Hour))
+	require.NoError(t, err)
+	require.NoError(t, logger.storeOrExtendUserSearch(ctx, "user_3", "cat", day.Add(21*time.Hour), false, store.Region{}))
+
+	profile, err := logger.GetSearchProfile(ctx, "CAT", day.Add(30*time.Minute), day.Add(24*time.Hour), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, profile.Searches)
+	assert.Equal(t, 2, profile.Hours[9], "Read from the rollups")
+	assert.Equal(t, 1, profile.Hours[21], "Read from the records")
+	assert.Equal(t, 3, profile.Weekdays[time.Wednesday])
+
+	// The hours move to the location, and the late ones to the next day
+	tokyo := time.FixedZone("JST", 9*60*60)
+	profile, err = logger.GetSearchProfile(ctx, "cat", day, day.Add(24*time.Hour), tokyo)
+	require.NoError(t, err)
+	assert.Equal(t, 2, profile.Hours[18])
+	assert.Equal(t, 1, profile.Hours[6])
+	assert.Equal(t, 2, profile.Weekdays[time.Wednesday])
+	assert.Equal(t, 1, profile.Weekdays[time.Thursday])
+
+	profile, err = logger.GetSearchProfile(ctx, "", day, day.Add(24*time.Hour), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 4, profile.Searches, "An empty word profiles every word")
+
+	// Without rollups every hour is read from the records
+	plain, err := NewSearchLoggerV2WithDB(db)
+	require.NoError(t, err)
+	defer plain.Close()
+	profile, err = plain.GetSearchProfile(ctx, "cat", day, day.Add(24*time.Hour), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, profile.Hours[9])
+	assert.Equal(t, 1, profile.Hours[21])
+}
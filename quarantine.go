@@ -0,0 +1,121 @@
+package logsearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/afanwang/logsearch/metrics"
+	"github.com/afanwang/logsearch/store"
+)
+
+// ReasonFilter is a search rejected by a filter of WithQuarantineFilters
+const ReasonFilter = "filter"
+
+// WithQuarantineFilters keeps the words rejected by any of filters out of the
+// store like WithFilters, but writes them to the quarantined_searches table
+// instead of dropping them, so moderators review them and approve the false
+// positives, e.g. the searches of a denylist too eager. Only the words
+// passing the filters of WithFilters are judged, so a word too short to be
+// stored is never quarantined. The store must implement store.QuarantineStore.
+func WithQuarantineFilters(filters ...Filter) Option {
+	return func(sl *SearchLoggerV2) {
+		sl.quarantineFilters = append(sl.quarantineFilters, filters...)
+	}
+}
+
+// quarantines reports whether searches may be quarantined, by WithAbuseDetection or WithQuarantineFilters
+func (sl *SearchLoggerV2) quarantines() bool {
+	return sl.abuse != nil || len(sl.quarantineFilters) > 0
+}
+
+// quarantine stores the search of word by the user at at in the quarantine instead of logging it
+func (sl *SearchLoggerV2) quarantine(ctx context.Context, userIdentifier, word, reason string, at time.Time) error {
+	_, err := sl.db.(store.QuarantineStore).QuarantineSearch(ctx, store.QuarantinedSearch{
+		UserIdentifier: userIdentifier,
+		Word:           word,
+		Reason:         reason,
+		SearchedAt:     at,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to quarantine search: %w", store.Classify(err))
+	}
+
+	fmt.Fprintf(sl.out, " (quarantined: %s)", reason)
+	sl.decided(ctx, metrics.DecisionQuarantine)
+	return nil
+}
+
+// GetQuarantinedSearches returns the searches of the user quarantined by
+// WithAbuseDetection or WithQuarantineFilters, of every user when empty,
+// oldest first. The store must implement store.QuarantineStore.
+func (sl *SearchLoggerV2) GetQuarantinedSearches(ctx context.Context, userIdentifier string) ([]store.QuarantinedSearch, error) {
+	quarantineStore, ok := sl.db.(store.QuarantineStore)
+	if !ok {
+		return nil, errors.New("store does not support quarantine")
+	}
+
+	searches, err := quarantineStore.ListQuarantinedSearches(ctx, userIdentifier)
+	return searches, store.Classify(err)
+}
+
+// ApproveQuarantinedSearches promotes the quarantined searches of ids to the
+// searches of their users, as if logged at their SearchedAt without the
+// filters, and returns how many were approved. The ids not quarantined are
+// skipped, so concurrent reviews approve a search once. The searches failing
+// to be stored are quarantined again, under new IDs. The store must
+// implement store.QuarantineStore.
+func (sl *SearchLoggerV2) ApproveQuarantinedSearches(ctx context.Context, ids []int64) (int, error) {
+	quarantineStore, ok := sl.db.(store.QuarantineStore)
+	if !ok {
+		return 0, errors.New("store does not support quarantine")
+	}
+
+	searches, err := quarantineStore.TakeQuarantinedSearches(ctx, ids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to approve quarantined searches: %w", store.Classify(err))
+	}
+
+	for i, search := range searches {
+		if err := sl.storeUserSearch(ctx, search.UserIdentifier, search.Word, search.SearchedAt, false, store.Region{}); err != nil {
+			// Nothing is lost: the searches left are put back for a later review
+			for _, left := range searches[i:] {
+				if _, requarantineErr := quarantineStore.QuarantineSearch(ctx, left); requarantineErr != nil {
+					return i, errors.Join(fmt.Errorf("failed to approve quarantined search %d: %w", search.ID, store.Classify(err)),
+						fmt.Errorf("failed to quarantine search %d again: %w", left.ID, store.Classify(requarantineErr)))
+				}
+			}
+			return i, fmt.Errorf("failed to approve quarantined search %d: %w", search.ID, store.Classify(err))
+		}
+	}
+	return len(searches), nil
+}
+
+// PurgeQuarantinedSearches deletes the quarantined searches of ids and
+// returns how many were deleted, skipping the ids not quarantined. The store
+// must implement store.QuarantineStore.
+func (sl *SearchLoggerV2) PurgeQuarantinedSearches(ctx context.Context, ids []int64) (int, error) {
+	quarantineStore, ok := sl.db.(store.QuarantineStore)
+	if !ok {
+		return 0, errors.New("store does not support quarantine")
+	}
+
+	searches, err := quarantineStore.TakeQuarantinedSearches(ctx, ids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge quarantined searches: %w", store.Classify(err))
+	}
+	return len(searches), nil
+}
+
+// forgetQuarantine deletes the quarantined searches and the activity of a deleted user
+func (sl *SearchLoggerV2) forgetQuarantine(ctx context.Context, userIdentifier string) error {
+	if !sl.quarantines() {
+		return nil
+	}
+	sl.abuse.forget(userIdentifier)
+	if _, err := sl.db.(store.QuarantineStore).DeleteUserQuarantine(ctx, userIdentifier); err != nil {
+		return fmt.Errorf("failed to delete the quarantined searches of %s: %w", userIdentifier, store.Classify(err))
+	}
+	return nil
+}
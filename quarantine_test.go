@@ -0,0 +1,69 @@
+package logsearch
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchLoggerV2_QuarantineFilters(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDBV2()
+	denied := FilterFunc(func(word string) bool { return !strings.Contains(word, "scunthorpe") })
+	logger, err := NewSearchLoggerV2WithDB(db, WithFilters(MinLength(3)), WithQuarantineFilters(denied))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	for _, word := range []string{"sc", "scunthorpe", "bus"} {
+		require.NoError(t, logger.LogSearchV2(ctx, "user_1", word))
+	}
+	require.NoError(t, logger.LogSearchV2(ctx, "user_2", "scunthorpe united"))
+
+	searches, err := logger.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bus"}, searches)
+
+	quarantined, err := logger.GetQuarantinedSearches(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, quarantined, 2, "The words dropped by WithFilters are not quarantined")
+	assert.Equal(t, "scunthorpe", quarantined[0].Word)
+	assert.Equal(t, ReasonFilter, quarantined[0].Reason)
+
+	// An approved search is stored as searched when it was quarantined
+	approved, err := logger.ApproveQuarantinedSearches(ctx, []int64{quarantined[0].ID})
+	require.NoError(t, err)
+	assert.Equal(t, 1, approved)
+	searches, err = logger.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"bus", "scunthorpe"}, searches)
+	approved, err = logger.ApproveQuarantinedSearches(ctx, []int64{quarantined[0].ID})
+	require.NoError(t, err)
+	assert.Zero(t, approved, "A search is approved once")
+
+	// A search failing to be stored is quarantined again
+	db.Faults().SetErrorRate("InsertOrUpdateUserSearch", 1)
+	_, err = logger.ApproveQuarantinedSearches(ctx, []int64{quarantined[1].ID})
+	assert.ErrorIs(t, err, ErrStoreUnavailable)
+	db.Faults().Reset()
+	left, err := logger.GetQuarantinedSearches(ctx, "user_2")
+	require.NoError(t, err)
+	require.Len(t, left, 1)
+	assert.Equal(t, "scunthorpe united", left[0].Word)
+
+	purged, err := logger.PurgeQuarantinedSearches(ctx, []int64{left[0].ID, 999})
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	left, err = logger.GetQuarantinedSearches(ctx, "")
+	require.NoError(t, err)
+	assert.Empty(t, left)
+	searches, err = logger.GetUserSearches(ctx, "user_2")
+	require.NoError(t, err)
+	assert.Empty(t, searches)
+
+	_, err = NewSearchLoggerV2WithDB(&countingStore{UserSearchStore: store.NewMockPostgresDBV2()}, WithQuarantineFilters(denied))
+	assert.Error(t, err, "Stores without quarantine should be rejected")
+}
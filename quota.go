@@ -0,0 +1,47 @@
+package logsearch
+
+import (
+	"context"
+	"log"
+
+	"github.com/afanwang/logsearch/metrics"
+	"github.com/afanwang/logsearch/store"
+)
+
+// WithUserQuota caps the stored searches of every user at maxRecords, e.g.
+// WithUserQuota(10000), so a scripted client cannot bloat the table. Every write
+// beyond the quota evicts the user's least recently updated records. The store
+// must implement store.UserQuotaStore.
+//
+// With WithUserCache the quota is only checked once the cached words of the user
+// exceed it, otherwise every write checks it with one more store call.
+func WithUserQuota(maxRecords int) Option {
+	return func(sl *SearchLoggerV2) {
+		if maxRecords > 0 {
+			sl.quota = maxRecords
+		}
+	}
+}
+
+// enforceQuota evicts the records of the user beyond the quota after a write.
+// A failure is only logged, the write itself succeeded and the next one retries.
+func (sl *SearchLoggerV2) enforceQuota(ctx context.Context, userIdentifier string) {
+	if sl.quota == 0 {
+		return
+	}
+	if words, ok := sl.cache.get(userIdentifier); ok && len(words) <= sl.quota {
+		return
+	}
+
+	evicted, err := sl.db.(store.UserQuotaStore).TrimUserSearches(ctx, userIdentifier, sl.quota)
+	if err != nil {
+		log.Printf("Error enforcing the quota of user %s: %v", userIdentifier, store.Classify(err))
+		return
+	}
+	if len(evicted) > 0 {
+		sl.cache.invalidate(userIdentifier)
+		sl.invalidateResults(ctx, userIdentifier)
+		sl.removed(evicted)
+		sl.metrics.QuotaEvicted(metrics.LoggerV2, int64(len(evicted)))
+	}
+}
@@ -0,0 +1,75 @@
+package logsearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// maxLocaleBytes bounds a locale, the longest BCP 47 tag in common use fits
+const maxLocaleBytes = 35
+
+// WithRegions keeps the country and locale of the SearchEvents next to the
+// record of every user's word, so GetTopSearchesByRegion ranks the words
+// searched in a country or a locale, e.g. for regional trending. A record
+// keeps the region of its last search, all its searches count there. Every
+// write of a search with a region costs one more store call. The store must
+// implement store.UserRegionStore.
+func WithRegions() Option {
+	return func(sl *SearchLoggerV2) {
+		sl.regions = true
+	}
+}
+
+// regionOf returns the region of event, its country uppercased. It returns
+// ErrInvalidInput wrapped for a country that is not two letters or a locale
+// too long to be a language tag.
+func regionOf(event SearchEvent) (store.Region, error) {
+	region := store.Region{
+		Country: strings.ToUpper(strings.TrimSpace(event.Country)),
+		Locale:  strings.TrimSpace(event.Locale),
+	}
+	if region.Country != "" && !isCountryCode(region.Country) {
+		return region, fmt.Errorf("%w: country %q is not an ISO 3166-1 alpha-2 code", ErrInvalidInput, event.Country)
+	}
+	if len(region.Locale) > maxLocaleBytes {
+		return region, fmt.Errorf("%w: locale is longer than %d bytes", ErrInvalidInput, maxLocaleBytes)
+	}
+	return region, nil
+}
+
+// isCountryCode reports whether code is made of two uppercase ASCII letters
+func isCountryCode(code string) bool {
+	return len(code) == 2 && code[0] >= 'A' && code[0] <= 'Z' && code[1] >= 'A' && code[1] <= 'Z'
+}
+
+// setRegion sets the region of the user's stored word when enabled. It is
+// for analytics only, a failure is logged.
+func (sl *SearchLoggerV2) setRegion(ctx context.Context, userIdentifier, word string, region store.Region) {
+	if !sl.regions || region.IsZero() {
+		return
+	}
+	if err := sl.db.(store.UserRegionStore).SetUserSearchRegion(ctx, userIdentifier, word, region); err != nil {
+		log.Printf("Error setting region of user search '%s': %v", word, store.Classify(err))
+	}
+}
+
+// GetTopSearchesByRegion returns the limit most searched words over the
+// records of the users' words last searched in the country and locale of
+// query, last updated at or after query.Since. An empty country or locale
+// matches any. The store must implement store.UserRegionStore.
+func (sl *SearchLoggerV2) GetTopSearchesByRegion(ctx context.Context, query store.RegionQuery) ([]store.WordCount, error) {
+	regionStore, ok := sl.db.(store.UserRegionStore)
+	if !ok {
+		return nil, errors.New("store does not support regions")
+	}
+
+	query.Country = strings.ToUpper(strings.TrimSpace(query.Country))
+	query.Locale = strings.TrimSpace(query.Locale)
+	counts, err := regionStore.TopSearchesByRegion(ctx, query)
+	return counts, store.Classify(err)
+}
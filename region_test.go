@@ -0,0 +1,56 @@
+package logsearch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchLoggerV2_Regions(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts []Option
+	}{
+		{"direct", []Option{WithRegions()}},
+		{"buffered", []Option{WithRegions(), WithWriteBuffer(100, time.Hour)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			logger, err := NewSearchLoggerV2WithDB(store.NewMockPostgresDBV2(), tt.opts...)
+			require.NoError(t, err)
+			defer logger.Close()
+
+			log := func(user, word, country, locale string) {
+				require.NoError(t, logger.LogSearchEvent(ctx, SearchEvent{UserIdentifier: user, Query: word, Country: country, Locale: locale}))
+			}
+			log("user_1", "ca", "fr", "fr-FR")
+			log("user_1", "cat", "fr", "fr-FR")
+			log("user_2", "cat", "FR", "en-GB")
+			log("user_3", "bus", "GB", "en-GB")
+			log("user_4", "dog", "", "")
+			require.NoError(t, logger.Flush(ctx))
+
+			top, err := logger.GetTopSearchesByRegion(ctx, store.RegionQuery{Country: "fr", Limit: 10})
+			require.NoError(t, err)
+			assert.Equal(t, []store.WordCount{{Word: "cat", Count: 3}}, top, "The country is uppercased, the extended prefix counts")
+
+			top, err = logger.GetTopSearchesByRegion(ctx, store.RegionQuery{Locale: "en-GB", Limit: 10})
+			require.NoError(t, err)
+			assert.Equal(t, []store.WordCount{{Word: "bus", Count: 1}, {Word: "cat", Count: 1}}, top)
+
+			top, err = logger.GetTopSearchesByRegion(ctx, store.RegionQuery{Country: "GB", Since: time.Now().Add(time.Hour), Limit: 10})
+			require.NoError(t, err)
+			assert.Empty(t, top)
+
+			err = logger.LogSearchEvent(ctx, SearchEvent{UserIdentifier: "user_5", Query: "cow", Country: "France"})
+			assert.ErrorIs(t, err, ErrInvalidInput)
+		})
+	}
+
+	_, err := NewSearchLoggerV2WithDB(&countingStore{UserSearchStore: store.NewMockPostgresDBV2()}, WithRegions())
+	assert.Error(t, err, "Stores without regions should be rejected")
+}
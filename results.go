@@ -0,0 +1,115 @@
+package logsearch
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/afanwang/logsearch/cache"
+)
+
+// Keys and fields of the result cache: the reads of a user are the fields of
+// their key so any write of the user drops them at once, the top searches are
+// the fields of topKey
+const (
+	topKey             = "top"
+	userSearchesField  = "searches"
+	suggestFieldPrefix = "suggest\x00"
+)
+
+// results caches the answers of the read APIs, nil when disabled
+type results struct {
+	cache cache.Cache
+	ttl   time.Duration
+}
+
+// WithResultCache answers GetUserSearches, SuggestForUser and GetTopSearches
+// from c for up to ttl, e.g. WithResultCache(cache.NewMemory(100000), time.Minute)
+// in process or WithResultCache(redisCache, time.Minute) shared by every
+// instance. Every write of a user drops the cached reads of that user, while
+// the top searches are only refreshed after ttl, or after curation and purges
+// which clear the whole cache. A read racing a write, or served by a lagging
+// read replica, may keep a stale answer for up to ttl. A failing cache is
+// logged and bypassed.
+func WithResultCache(c cache.Cache, ttl time.Duration) Option {
+	return func(sl *SearchLoggerV2) {
+		if c != nil && ttl > 0 {
+			sl.results = &results{cache: c, ttl: ttl}
+		}
+	}
+}
+
+// userKey is the key of the cached reads of a user
+func userKey(userIdentifier string) string {
+	return "user:" + userIdentifier
+}
+
+// suggestField is the field of the cached suggestions of prefix for a user
+func suggestField(prefix string, limit int) string {
+	return suggestFieldPrefix + strconv.Itoa(limit) + "\x00" + prefix
+}
+
+// topField is the field of the cached top searches since, which shares the
+// entry of the other windows starting within the same ttl
+func (r *results) topField(since time.Time, limit int) string {
+	return strconv.FormatInt(since.Truncate(r.ttl).Unix(), 10) + ":" + strconv.Itoa(limit)
+}
+
+// cachedResult decodes the cached answer of query into result and reports whether it was cached
+func (sl *SearchLoggerV2) cachedResult(ctx context.Context, query, key, field string, result any) bool {
+	if sl.results == nil {
+		return false
+	}
+
+	value, ok, err := sl.results.cache.Get(ctx, key, field)
+	if err != nil {
+		log.Printf("Error reading the result cache: %v", err)
+	}
+	hit := ok && err == nil && json.Unmarshal(value, result) == nil
+	sl.metrics.CacheLookup(query, hit)
+	return hit
+}
+
+// cacheResult caches the answer of a read
+func (sl *SearchLoggerV2) cacheResult(ctx context.Context, key, field string, result any) {
+	if sl.results == nil {
+		return
+	}
+
+	value, err := json.Marshal(result)
+	if err == nil {
+		err = sl.results.cache.Set(ctx, key, field, value, sl.results.ttl)
+	}
+	if err != nil {
+		log.Printf("Error writing the result cache: %v", err)
+	}
+}
+
+// invalidateResults drops the cached reads of the users after their writes
+func (sl *SearchLoggerV2) invalidateResults(ctx context.Context, userIdentifiers ...string) {
+	if sl.results == nil || len(userIdentifiers) == 0 {
+		return
+	}
+
+	// The write happened, dropping its stale reads must outlive the request
+	ctx = context.WithoutCancel(ctx)
+	keys := make([]string, len(userIdentifiers))
+	for i, userIdentifier := range userIdentifiers {
+		keys[i] = userKey(userIdentifier)
+	}
+	if err := sl.results.cache.Delete(ctx, keys...); err != nil {
+		log.Printf("Error invalidating the result cache: %v", err)
+	}
+}
+
+// clearResults drops every cached read after a write of any user
+func (sl *SearchLoggerV2) clearResults(ctx context.Context) {
+	if sl.results == nil {
+		return
+	}
+	if err := sl.results.cache.Clear(context.WithoutCancel(ctx)); err != nil {
+		log.Printf("Error clearing the result cache: %v", err)
+	}
+}
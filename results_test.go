@@ -0,0 +1,129 @@
+package logsearch
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/afanwang/logsearch/cache"
+	"github.com/afanwang/logsearch/store"
+)
+
+// readCountingStore counts the reads served by the store
+type readCountingStore struct {
+	*store.MockPostgresDBV2
+	reads atomic.Int32
+}
+
+func (s *readCountingStore) GetUserSearches(ctx context.Context, userIdentifier string) ([]string, error) {
+	s.reads.Add(1)
+	return s.MockPostgresDBV2.GetUserSearches(ctx, userIdentifier)
+}
+
+func (s *readCountingStore) ForEachUserSearch(ctx context.Context, userIdentifier string, fn func(store.UserSearchRecord) error) error {
+	s.reads.Add(1)
+	return s.MockPostgresDBV2.ForEachUserSearch(ctx, userIdentifier, fn)
+}
+
+func (s *readCountingStore) TopSearches(ctx context.Context, since time.Time, limit int) ([]store.WordCount, error) {
+	s.reads.Add(1)
+	return s.MockPostgresDBV2.TopSearches(ctx, since, limit)
+}
+
+func TestSearchLoggerV2_ResultCache(t *testing.T) {
+	ctx := context.Background()
+	db := &readCountingStore{MockPostgresDBV2: store.NewMockPostgresDBV2()}
+	logger, err := NewSearchLoggerV2WithDB(db, WithResultCache(cache.NewMemory(100), time.Minute))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	// reads returns how many reads reached the store while running fn
+	reads := func(fn func()) int {
+		before := db.reads.Load()
+		fn()
+		return int(db.reads.Load() - before)
+	}
+	suggest := func(user, prefix string) []string {
+		suggestions, err := logger.SuggestForUser(ctx, user, prefix, 5)
+		require.NoError(t, err)
+		return suggestions
+	}
+	require.NoError(t, logger.LogSearchV2(ctx, "user_1", "bus"))
+	require.NoError(t, logger.LogSearchV2(ctx, "user_2", "cat"))
+
+	// The repeated reads are answered from the cache
+	assert.Equal(t, 1, reads(func() {
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, []string{"bus"}, suggest("user_1", "bu"))
+		}
+	}))
+	assert.Equal(t, 1, reads(func() {
+		for i := 0; i < 3; i++ {
+			searches, err := logger.GetUserSearches(ctx, "user_1")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"bus"}, searches)
+		}
+	}))
+	assert.Equal(t, 1, reads(func() {
+		for i := 0; i < 3; i++ {
+			top, err := logger.GetTopSearches(ctx, 10)
+			require.NoError(t, err)
+			assert.Len(t, top, 2)
+		}
+	}))
+
+	// A write drops the cached reads of its user only
+	assert.Equal(t, []string{"cat"}, suggest("user_2", "c"))
+	require.NoError(t, logger.LogSearchV2(ctx, "user_1", "business"))
+	assert.Equal(t, 0, reads(func() { suggest("user_2", "c") }))
+	assert.Equal(t, []string{"business"}, suggest("user_1", "bu"))
+	assert.Equal(t, 0, reads(func() {
+		top, err := logger.GetTopSearches(ctx, 10)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []store.WordCount{{Word: "bus", Count: 1}, {Word: "cat", Count: 1}}, top, "the top searches wait for the ttl")
+	}))
+
+	// Curation clears the whole cache
+	require.NoError(t, logger.MergeWords(ctx, "cat", "kitten"))
+	assert.Equal(t, []string{"kitten"}, suggest("user_2", "k"))
+	top, err := logger.GetTopSearches(ctx, 10)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []store.WordCount{{Word: "business", Count: 2}, {Word: "kitten", Count: 1}}, top)
+}
+
+func TestSearchLoggerV2_SharedResultCache(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	shared, err := cache.NewRedis(store.RedisConfig{Addr: server.Addr()})
+	require.NoError(t, err)
+	defer shared.Close()
+
+	// Two instances in front of the same store share the cache
+	db := store.NewMockPostgresDBV2()
+	first, err := NewSearchLoggerV2WithDB(db, WithResultCache(shared, time.Minute))
+	require.NoError(t, err)
+	second, err := NewSearchLoggerV2WithDB(db, WithResultCache(shared, time.Minute))
+	require.NoError(t, err)
+
+	require.NoError(t, first.LogSearchV2(ctx, "user_1", "bus"))
+	searches, err := first.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bus"}, searches)
+
+	// A write on one instance drops the reads cached by the other
+	require.NoError(t, second.LogSearchV2(ctx, "user_1", "business"))
+	searches, err = first.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"business"}, searches)
+
+	// A failing cache is bypassed
+	server.Close()
+	searches, err = first.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"business"}, searches)
+}
@@ -0,0 +1,76 @@
+package logsearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/afanwang/logsearch/metrics"
+	"github.com/afanwang/logsearch/store"
+)
+
+// retention is how long stored searches are kept after their last update
+type retention struct {
+	window   time.Duration
+	interval time.Duration
+}
+
+// WithRetention deletes the stored searches not repeated within window, checking
+// every interval, e.g. WithRetention(90*24*time.Hour, time.Hour). The store must
+// implement store.UserSearchPurgeStore.
+func WithRetention(window, interval time.Duration) Option {
+	return func(sl *SearchLoggerV2) {
+		if window > 0 && interval > 0 {
+			sl.retention = &retention{window: window, interval: interval}
+		}
+	}
+}
+
+// retentionRoutine purges the expired searches every interval until ctx is cancelled by Close
+func (sl *SearchLoggerV2) retentionRoutine(ctx context.Context) {
+	defer sl.wg.Done()
+
+	ticker := time.NewTicker(sl.retention.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := sl.Purge(ctx, time.Now().Add(-sl.retention.window)); err != nil {
+				log.Printf("Error purging expired searches: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Purge deletes the stored searches last updated before cutoff and returns how
+// many records were deleted. The retention reaper calls it on its own, it is
+// exported for one-off purges.
+func (sl *SearchLoggerV2) Purge(ctx context.Context, cutoff time.Time) (int64, error) {
+	purgeStore, ok := sl.db.(store.UserSearchPurgeStore)
+	if !ok {
+		return 0, errors.New("store does not support purging searches")
+	}
+
+	if sl.buffer != nil {
+		// Holding the buffer keeps users from being loaded into the cache mid-purge
+		sl.buffer.mutex.Lock()
+		defer sl.buffer.mutex.Unlock()
+	}
+
+	deleted, err := purgeStore.PurgeUserSearches(ctx, cutoff)
+	// Any cached user may have lost words
+	sl.cache.clear()
+	sl.clearResults(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge searches: %w", store.Classify(err))
+	}
+
+	sl.removed(deleted)
+	sl.metrics.Purged(metrics.LoggerV2, int64(len(deleted)))
+	return int64(len(deleted)), nil
+}
@@ -0,0 +1,94 @@
+package logsearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// rollups is the schedule of the rollup job
+type rollups struct {
+	interval time.Duration
+	delay    time.Duration
+}
+
+// WithRollups summarizes the stored searches into the hourly and daily rollup
+// tables every interval, e.g. WithRollups(10*time.Minute, 5*time.Minute). Each
+// hour and day is rolled up once, delay after it ended, so delay must exceed
+// the flush interval of WithWriteBuffer and the timeout of WithFinalizeTimeout
+// for their searches to be stored by then. Combined with WithRetention the
+// summaries outlive the purged records. The store must implement
+// store.RollupStore.
+func WithRollups(interval, delay time.Duration) Option {
+	return func(sl *SearchLoggerV2) {
+		if interval > 0 {
+			sl.rollups = &rollups{interval: interval, delay: max(delay, 0)}
+		}
+	}
+}
+
+// rollupRoutine rolls the ended buckets up every interval until ctx is cancelled by Close
+func (sl *SearchLoggerV2) rollupRoutine(ctx context.Context) {
+	defer sl.wg.Done()
+
+	ticker := time.NewTicker(sl.rollups.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := sl.Rollup(ctx, time.Now().Add(-sl.rollups.delay)); err != nil {
+				log.Printf("Error rolling up searches: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Rollup summarizes the hours and days ended by until that were not rolled up
+// yet, starting with the oldest stored search on the first run, and returns
+// the number of rollup rows written. The rollup job calls it on its own, it is
+// exported for one-off runs. A stored record keeps only its last search, so
+// all the searches of a word by a user count in the bucket of the last one.
+func (sl *SearchLoggerV2) Rollup(ctx context.Context, until time.Time) (int64, error) {
+	rollupStore, ok := sl.db.(store.RollupStore)
+	if !ok {
+		return 0, errors.New("store does not support rollups")
+	}
+
+	var written int64
+	for _, granularity := range []store.Granularity{store.Hourly, store.Daily} {
+		from, err := rollupStore.RolledUpUntil(ctx, granularity)
+		if err != nil {
+			return written, fmt.Errorf("failed to roll up searches: %w", store.Classify(err))
+		}
+		to := granularity.Truncate(until)
+		if !from.Before(to) {
+			continue
+		}
+
+		rows, err := rollupStore.RollupSearches(ctx, from, to, granularity)
+		written += rows
+		if err != nil {
+			return written, fmt.Errorf("failed to roll up searches: %w", store.Classify(err))
+		}
+	}
+	return written, nil
+}
+
+// GetRollups returns the rollup rows of the hours or days in [from, to), in
+// time order then most searched first, see WithRollups
+func (sl *SearchLoggerV2) GetRollups(ctx context.Context, from, to time.Time, granularity store.Granularity) ([]store.SearchRollup, error) {
+	rollupStore, ok := sl.db.(store.RollupStore)
+	if !ok {
+		return nil, errors.New("store does not support rollups")
+	}
+
+	rollups, err := rollupStore.GetRollups(ctx, from, to, granularity)
+	return rollups, store.Classify(err)
+}
@@ -0,0 +1,59 @@
+package logsearch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchLoggerV2_Rollup(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLoggerV2WithDB(store.NewMockPostgresDBV2())
+	require.NoError(t, err)
+	defer logger.Close()
+
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, logger.storeOrExtendUserSearch(ctx, "user_1", "cat", day.Add(9*time.Hour), false, store.Region{}))
+	require.NoError(t, logger.storeOrExtendUserSearch(ctx, "user_2", "cat", day.Add(9*time.Hour), false, store.Region{}))
+	require.NoError(t, logger.storeOrExtendUserSearch(ctx, "user_1", "dog", day.Add(10*time.Hour), false, store.Region{}))
+
+	// The hour of "dog" has not ended
+	written, err := logger.Rollup(ctx, day.Add(10*time.Hour+30*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), written)
+
+	written, err = logger.Rollup(ctx, day.Add(24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), written, "dog hourly, cat and dog daily")
+
+	hourly, err := logger.GetRollups(ctx, day, day.Add(24*time.Hour), store.Hourly)
+	require.NoError(t, err)
+	assert.Equal(t, []store.SearchRollup{
+		{Word: "cat", Bucket: day.Add(9 * time.Hour), TotalCount: 2, UniqueUsers: 2},
+		{Word: "dog", Bucket: day.Add(10 * time.Hour), TotalCount: 1, UniqueUsers: 1},
+	}, hourly)
+
+	// Nothing is left to roll up
+	written, err = logger.Rollup(ctx, day.Add(24*time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, written)
+}
+
+func TestSearchLoggerV2_RollupJob(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLoggerV2WithDB(store.NewMockPostgresDBV2(), WithRollups(10*time.Millisecond, 0))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	yesterday := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, logger.storeOrExtendUserSearch(ctx, "user_1", "cat", yesterday, false, store.Region{}))
+
+	assert.Eventually(t, func() bool {
+		daily, err := logger.GetRollups(ctx, yesterday.Add(-24*time.Hour), time.Now(), store.Daily)
+		return err == nil && len(daily) == 1
+	}, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,215 @@
+// Package scrub keeps personal data out of the stored searches: emails, phone
+// numbers, US social security numbers and payment card numbers that users
+// paste into a search box. A Scrubber drops the searches containing them, or
+// redacts them with a placeholder such as "[email]". The keystrokes typing a
+// number are scrubbed too, before the number is complete.
+//
+// It is an ingest processor of SearchLoggerV2 and, for the trie logger, a
+// filter or a normalizer:
+//
+//	s := scrub.New(scrub.OnMatch(m.Scrubbed))
+//	logger, err := logsearch.NewSearchLoggerV2(logsearch.WithProcessor(logsearch.StageNormalize, s))
+//	trieLogger, err := trie.NewSearchLogger(timeout, trie.WithFilters(s))
+package scrub
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/normalize"
+)
+
+// Patterns of personal data
+const (
+	Email = "email"
+	Card  = "card"
+	SSN   = "ssn"
+	Phone = "phone"
+	// Partial is a number still typed at the end of a search, too short to
+	// tell whether it is one of the others, e.g. "415 555" of a phone number
+	Partial = "partial"
+)
+
+// pattern finds one kind of personal data, valid rejects the false positives of re
+type pattern struct {
+	name  string
+	re    *regexp.Regexp
+	valid func(match string) bool
+}
+
+// patterns are checked in order, the longer digit runs of cards before the
+// shorter ones of SSNs and phone numbers, so a number is reported once. The
+// numbers still typed come last, only those no other pattern matched.
+var patterns = []pattern{
+	{Email, regexp.MustCompile(`[\p{L}\p{N}._%+-]+@[\p{L}\p{N}-]+(?:\.[\p{L}\p{N}-]+)*`), nil},
+	{Card, regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), luhn},
+	{SSN, regexp.MustCompile(`\b\d{3}[- ]\d{2}[- ]\d{4}\b`), validSSN},
+	{Phone, regexp.MustCompile(`(?:\+|\(|\b)\d[\d ().-]{7,}\d\b`), validPhone},
+	{Partial, regexp.MustCompile(`\d(?:[ ().-]*\d){5,}[ ().-]*$`), partialNumber},
+}
+
+// Scrubber finds personal data in searches. It is immutable and safe for concurrent use.
+type Scrubber struct {
+	patterns []pattern
+	redact   bool
+	onMatch  func(pattern string)
+}
+
+// Option configures optional Scrubber behavior
+type Option func(*Scrubber)
+
+// Redact replaces the personal data with the name of its pattern in brackets,
+// e.g. "mail john@example.com" becomes "mail [email]", instead of dropping the search
+func Redact() Option {
+	return func(s *Scrubber) {
+		s.redact = true
+	}
+}
+
+// Patterns only checks the named patterns, e.g. Patterns(scrub.Email, scrub.Card),
+// every pattern is checked by default. Unknown names are ignored.
+func Patterns(names ...string) Option {
+	return func(s *Scrubber) {
+		s.patterns = nil
+		for _, p := range patterns {
+			for _, name := range names {
+				if p.name == name {
+					s.patterns = append(s.patterns, p)
+					break
+				}
+			}
+		}
+	}
+}
+
+// OnMatch calls fn with the pattern of every personal data found in a search,
+// e.g. metrics.Metrics.Scrubbed to count them. fn must be safe for concurrent use.
+func OnMatch(fn func(pattern string)) Option {
+	return func(s *Scrubber) {
+		s.onMatch = fn
+	}
+}
+
+// New creates a Scrubber dropping the searches with personal data of any pattern
+func New(opts ...Option) *Scrubber {
+	s := &Scrubber{patterns: patterns}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// scan returns text with every personal data redacted, and the patterns found.
+// Each pattern runs on the text redacted by the previous ones, so a digit run
+// matched as a card is not matched again as a phone number.
+func (s *Scrubber) scan(text string) (string, []string) {
+	var found []string
+	for _, p := range s.patterns {
+		text = p.re.ReplaceAllStringFunc(text, func(match string) string {
+			if p.valid != nil && !p.valid(match) {
+				return match
+			}
+			found = append(found, p.name)
+			return "[" + p.name + "]"
+		})
+	}
+	if s.onMatch != nil {
+		for _, name := range found {
+			s.onMatch(name)
+		}
+	}
+	return text, found
+}
+
+// Find returns the patterns of the personal data in text, one per occurrence
+func (s *Scrubber) Find(text string) []string {
+	_, found := s.scan(text)
+	return found
+}
+
+// Allow reports whether text holds no personal data, so a Scrubber is a filter
+// of the loggers, e.g. trie.WithFilters(s)
+func (s *Scrubber) Allow(text string) bool {
+	return len(s.Find(text)) == 0
+}
+
+// Redact replaces the personal data of text with the name of its pattern in brackets
+func (s *Scrubber) Redact(text string) string {
+	text, _ = s.scan(text)
+	return text
+}
+
+// Redacting returns a normalizer redacting the searches normalized by n, e.g.
+// trie.WithNormalizer(s.Redacting(normalize.Default))
+func (s *Scrubber) Redacting(n normalize.Normalizer) normalize.Normalizer {
+	return normalize.Func(func(text string) string {
+		return s.Redact(n.Normalize(text))
+	})
+}
+
+// Process drops the searches with personal data, or redacts them with Redact,
+// so a Scrubber is an ingest processor of SearchLoggerV2
+func (s *Scrubber) Process(ctx context.Context, event logsearch.SearchEvent, next logsearch.Next) error {
+	scrubbed, found := s.scan(event.Query)
+	if len(found) == 0 {
+		return next(ctx, event)
+	}
+	if !s.redact {
+		return nil
+	}
+	event.Query = scrubbed
+	return next(ctx, event)
+}
+
+// digits returns the digits of s
+func digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// luhn reports whether the digits of number pass the Luhn checksum of card numbers
+func luhn(number string) bool {
+	number = digits(number)
+	if len(number) < 13 || len(number) > 19 {
+		return false
+	}
+	sum := 0
+	for i := len(number) - 1; i >= 0; i-- {
+		d := int(number[i] - '0')
+		if (len(number)-i)%2 == 0 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// validSSN rejects the area, group and serial numbers never issued
+func validSSN(ssn string) bool {
+	ssn = digits(ssn)
+	area, group, serial := ssn[:3], ssn[3:5], ssn[5:]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
+
+// partialNumber accepts the numbers ending a search that may still grow into
+// a card or phone number, of 6 to 18 digits. Shorter ones are left alone,
+// e.g. years or model numbers.
+func partialNumber(number string) bool {
+	n := len(digits(number))
+	return n >= 6 && n <= 18
+}
+
+// validPhone accepts the numbers of 10 to 15 digits, the lengths of E.164
+// numbers with their area code
+func validPhone(phone string) bool {
+	n := len(digits(phone))
+	return n >= 10 && n <= 15
+}
@@ -0,0 +1,123 @@
+package scrub
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/normalize"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFind(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"finds emails", "mail john.doe+news@example.co.uk now", []string{Email}},
+		{"finds card numbers", "pay 4111 1111 1111 1111", []string{Card}},
+		{"finds card numbers without separators", "5500005555555559", []string{Card}},
+		{"ignores numbers failing the checksum", "4111111111111112 refund", nil},
+		{"finds social security numbers", "ssn 123-45-6789", []string{SSN}},
+		{"ignores numbers never issued", "000-12-3456 lookup", nil},
+		{"finds numbers still typed", "pay 4111 1111", []string{Partial}},
+		{"finds phone numbers still typed", "call (415) 555", []string{Partial}},
+		{"ignores short numbers still typed", "order 12345", nil},
+		{"finds phone numbers", "call +1 (415) 555-2671", []string{Phone}},
+		{"finds local phone numbers", "(415) 555-2671", []string{Phone}},
+		{"ignores short numbers", "iphone 15 pro 256", nil},
+		{"ignores years", "1990-2000 charts", nil},
+		{"finds every occurrence", "a@b.io or c@d.io 123-45-6789", []string{Email, Email, SSN}},
+		{"ignores plain searches", "bus to london", nil},
+	}
+
+	s := New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, s.Find(tt.text))
+			assert.Equal(t, tt.want == nil, s.Allow(tt.text))
+		})
+	}
+
+	assert.Empty(t, New(Patterns(Email)).Find("123-45-6789"))
+	assert.Equal(t, []string{SSN}, New(Patterns(SSN, "unknown")).Find("a@b.io 123-45-6789"))
+}
+
+func TestRedact(t *testing.T) {
+	var mutex sync.Mutex
+	counts := make(map[string]int)
+	s := New(OnMatch(func(pattern string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		counts[pattern]++
+	}))
+
+	assert.Equal(t, "mail [email] re [card]", s.Redact("mail john@example.com re 4111-1111-1111-1111"))
+	assert.Equal(t, "bus", s.Redact("bus"))
+	assert.Equal(t, "call [phone]", s.Redacting(normalize.Default).Normalize("  CALL 415.555.2671 "))
+	assert.Equal(t, map[string]int{Email: 1, Card: 1, Phone: 1}, counts)
+}
+
+func TestProcess(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name  string
+		scrub *Scrubber
+		want  []string
+	}{
+		{"drops searches with personal data", New(), []string{"bus"}},
+		{"redacts personal data", New(Redact()), []string{"bus", "mail [email]"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, err := logsearch.NewSearchLoggerV2(logsearch.WithProcessor(logsearch.StageNormalize, tt.scrub))
+			require.NoError(t, err)
+			defer logger.Close()
+
+			require.NoError(t, logger.LogSearchV2(ctx, "user_1", "bus"))
+			require.NoError(t, logger.LogSearchV2(ctx, "user_1", "Mail John@Example.com"))
+
+			searches, err := logger.GetUserSearches(ctx, "user_1")
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tt.want, searches)
+		})
+	}
+}
+
+func TestProcessKeystrokes(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name string
+		opts []logsearch.Option
+	}{
+		{"drop", []logsearch.Option{logsearch.WithProcessor(logsearch.StageNormalize, New())}},
+		{"redact", []logsearch.Option{logsearch.WithProcessor(logsearch.StageNormalize, New(Redact()))}},
+		{"drop finalized", []logsearch.Option{
+			logsearch.WithProcessor(logsearch.StageNormalize, New()),
+			logsearch.WithFinalizePolicy(logsearch.FinalizePolicy{Idle: time.Hour}),
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, err := logsearch.NewSearchLoggerV2(tt.opts...)
+			require.NoError(t, err)
+			defer logger.Close()
+
+			// Every keystroke typing a card number is logged
+			card := "pay 4111 1111 1111 1111"
+			for i := 1; i <= len(card); i++ {
+				require.NoError(t, logger.LogSearchV2(ctx, "user_1", card[:i]))
+			}
+			require.NoError(t, logger.Flush(ctx))
+
+			// No stored word holds more of the number than a short prefix
+			searches, err := logger.GetUserSearches(ctx, "user_1")
+			require.NoError(t, err)
+			for _, search := range searches {
+				assert.Less(t, len(digits(search)), 6, search)
+			}
+		})
+	}
+}
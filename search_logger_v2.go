@@ -1,99 +1,733 @@
-package main
+// Package logsearch logs user searches and deduplicates progressive typing
+// so only the most complete form of a word is stored.
+//
+// SearchLoggerV2 deduplicates per user on top of a store.UserSearchStore,
+// the trie based Version 1 logger lives in the trie subpackage.
+package logsearch
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"strings"
+	"sort"
+	"sync"
 	"time"
+
+	"github.com/afanwang/logsearch/metrics"
+	"github.com/afanwang/logsearch/ngram"
+	"github.com/afanwang/logsearch/normalize"
+	"github.com/afanwang/logsearch/sketch"
+	"github.com/afanwang/logsearch/spell"
+	"github.com/afanwang/logsearch/store"
+	"github.com/afanwang/logsearch/tracing"
+	"github.com/afanwang/logsearch/trending"
 )
 
 // SearchLoggerV2 handles per-user search deduplication using database
 // This version removes in-memory trie cache and relies on database for deduplication
 type SearchLoggerV2 struct {
-	db *MockPostgresDBV2
+	db store.UserSearchStore
+	// out receives a trace of every dedup decision, discarded by default
+	out io.Writer
+	// cache holds per-user words to avoid a store lookup on every keystroke, nil when disabled
+	cache *userCache
+	// buffer coalesces store writes into batches, nil when disabled
+	buffer *writeBuffer
+	// metrics records the ingestion and flush pipeline, nil when disabled
+	metrics *metrics.Metrics
+	// tracer traces the ingestion and flush pipeline, nil when disabled
+	tracer *tracing.Tracer
+	// sessions holds words until their user stopped typing them, nil when disabled
+	sessions *sessionTracker
+	// gaps limits consolidation to the words of the current typing session, nil when disabled
+	gaps *sessionGaps
+	// branchMinShared is the shared prefix of a corrected branch, 0 when disabled, see WithBranchDetection
+	branchMinShared int
+	// quota caps the stored records of every user, 0 when disabled
+	quota int
+	// audience counts the distinct users of every word in the store, see WithAudience
+	audience bool
+	// regions keeps the region of every record in the store, see WithRegions
+	regions bool
+	// zeroResultStreak lists the words after this many submitted searches in a row without result, 0 when disabled
+	zeroResultStreak int
+	// abuse quarantines the searches of the users behaving like bots, nil when disabled
+	abuse *abuseDetector
+	// retention purges the searches not repeated within its window, nil when disabled
+	retention *retention
+	// rollups summarizes the stored searches into the rollup tables, nil when disabled
+	rollups *rollups
+	// validator bounds and sanitizes every raw search
+	validator *Validator
+	// normalizer maps every search to the form stored and compared
+	normalizer normalize.Normalizer
+	// heavy estimates the most searched words in bounded memory, nil when disabled
+	heavy *sketch.TopK
+	// trending counts the searches in rolling time buckets, nil when disabled
+	trending *trending.Tracker
+	// spell indexes the stored words for DidYouMean, nil when disabled
+	spell *spell.Index
+	// terms indexes the stored words for GetTermsContaining, nil when disabled
+	terms *ngram.Index
+	// indexes are the enabled in-memory indexes of the stored words, see counted
+	indexes []wordIndex
+	// processors are the custom stages of the ingest chain, by the built-in stage they precede
+	processors map[Stage][]Processor
+	// ingest is the ingest chain every search runs through, see WithProcessor
+	ingest Next
+	// store is the rest of the ingest chain from StageStore, which the
+	// searches held by the dedup run through once finished
+	store Next
+	// hooks are called after every word written to the store
+	hooks []WordFinalizedHook
+	// filters reject the words not eligible for storage
+	filters []Filter
+	// quarantineFilters reject the words quarantined for review, see WithQuarantineFilters
+	quarantineFilters []Filter
+	// stemmer reduces finalized searches to their stem, nil when disabled
+	stemmer normalize.Stemmer
+	// typos is the layout of WithTypoMerge, nil when disabled
+	typos *KeyboardLayout
+	// global and userWeight blend the popular completions into SuggestForUser, global is nil when disabled
+	global     GlobalSuggester
+	userWeight float64
+	// clickBoost weighs the click-through rate of the completions of SuggestForUser, 0 when disabled
+	clickBoost float64
+	// synonyms are the aliases of the words, nil when disabled, see WithSynonyms
+	synonyms *synonyms
+	// clientClock orders the searches of every user by their client clock, see WithClientClock
+	clientClock bool
+	// coalesce collapses the duplicates of a search, nil when disabled, see WithCoalescing
+	coalesce *coalescer
+	// results caches the answers of the read APIs, nil when disabled, see WithResultCache
+	results *results
+	// userLocks consolidates every search under the lock of its user in the store, see WithUserLocks
+	userLocks bool
+	// atomicConsolidation lets the store decide every search in one write, see WithAtomicConsolidation
+	atomicConsolidation bool
+	// cancel stops the background routines, wg waits for them to return
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Option configures optional SearchLoggerV2 behavior
+type Option func(*SearchLoggerV2)
+
+// WithUserCache keeps the stored words of recently active users in memory,
+// holding at most maxWords words in total and evicting the most idle users first.
+// The cache assumes this logger is the only writer for the users it serves
+// (e.g. sticky routing), otherwise other writers make it stale.
+func WithUserCache(maxWords int) Option {
+	return func(sl *SearchLoggerV2) {
+		if maxWords > 0 {
+			sl.cache = newUserCache(maxWords)
+		}
+	}
+}
+
+// WithMetrics records searches, dedup decisions, flushes and store writes in m
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(sl *SearchLoggerV2) {
+		sl.metrics = m
+	}
+}
+
+// WithTracing records a span for every search, flush and store call with t,
+// child of the span of the context passed to LogSearchV2
+func WithTracing(t *tracing.Tracer) Option {
+	return func(sl *SearchLoggerV2) {
+		sl.tracer = t
+	}
+}
+
+// WithValidator replaces DefaultValidator, e.g. with NewValidator(256, 64) to
+// accept shorter searches only
+func WithValidator(v *Validator) Option {
+	return func(sl *SearchLoggerV2) {
+		sl.validator = v
+	}
+}
+
+// WithNormalizer replaces the default trimming and lowercasing of searches,
+// e.g. with normalize.NewUnicode(normalize.WithDiacriticFolding()). Rows stored
+// before a normalizer change keep their old form.
+func WithNormalizer(n normalize.Normalizer) Option {
+	return func(sl *SearchLoggerV2) {
+		sl.normalizer = n
+	}
+}
+
+// WithHeavyHitters tracks the k most searched words in bounded memory with a
+// count-min sketch whose estimates exceed the true counts by at most epsilon
+// times the total count with probability 1-delta, e.g. WithHeavyHitters(100, 0.001, 0.01).
+// GetTopSearches then answers from the sketch instead of the store. Each search
+// counts once under the longest word it was extended to, and only searches
+// logged since the logger started are counted.
+func WithHeavyHitters(k int, epsilon, delta float64) Option {
+	return func(sl *SearchLoggerV2) {
+		if k > 0 {
+			sl.heavy = sketch.NewTopK(k, sketch.NewCountMinWithError(epsilon, delta))
+		}
+	}
 }
 
-func NewSearchLoggerV2() (*SearchLoggerV2, error) {
-	db := NewMockPostgresDBV2()
-	return NewSearchLoggerV2WithDB(db)
+func NewSearchLoggerV2(opts ...Option) (*SearchLoggerV2, error) {
+	db := store.NewMockPostgresDBV2()
+	return NewSearchLoggerV2WithDB(db, opts...)
 }
 
-func NewSearchLoggerV2WithDB(db *MockPostgresDBV2) (*SearchLoggerV2, error) {
-	// Create table using MockPostgresDBV2
-	if err := db.CreateTable(); err != nil {
-		return nil, fmt.Errorf("failed to create table: %w", err)
+// NewSearchLoggerV2WithDB creates a SearchLoggerV2 on top of any UserSearchStore
+func NewSearchLoggerV2WithDB(db store.UserSearchStore, opts ...Option) (*SearchLoggerV2, error) {
+	// Create table using the store
+	if err := db.CreateTable(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to create table: %w", store.Classify(err))
 	}
 
 	logger := &SearchLoggerV2{
-		db: db,
+		db:         db,
+		out:        io.Discard,
+		validator:  DefaultValidator,
+		normalizer: normalize.Default,
+	}
+	for _, opt := range opts {
+		opt(logger)
+	}
+	if _, ok := db.(store.UserSearchPurgeStore); logger.retention != nil && !ok {
+		return nil, errors.New("retention needs a store that supports purging searches")
+	}
+	if _, ok := db.(store.UserQuotaStore); logger.quota > 0 && !ok {
+		return nil, errors.New("user quotas need a store that supports trimming user searches")
+	}
+	if _, ok := db.(store.AudienceStore); logger.audience && !ok {
+		return nil, errors.New("audiences need a store that supports audiences")
+	}
+	if _, ok := db.(store.UserRegionStore); logger.regions && !ok {
+		return nil, errors.New("regions need a store that supports regions")
+	}
+	if _, ok := db.(store.QuarantineStore); logger.abuse != nil && !ok {
+		return nil, errors.New("abuse detection needs a store that supports quarantine")
+	}
+	if _, ok := db.(store.QuarantineStore); len(logger.quarantineFilters) > 0 && !ok {
+		return nil, errors.New("quarantine filters need a store that supports quarantine")
+	}
+	if _, ok := db.(store.SearchClickStore); logger.clickBoost > 0 && !ok {
+		return nil, errors.New("click boost needs a store that supports clicks")
+	}
+	if _, ok := db.(store.SynonymStore); logger.synonyms != nil && !ok {
+		return nil, errors.New("synonyms need a store that supports synonyms")
+	}
+	if _, ok := db.(store.ZeroResultStore); logger.zeroResultStreak > 0 && !ok {
+		return nil, errors.New("zero-result tracking needs a store that supports zero-result terms")
+	}
+	if _, ok := db.(store.UserClockStore); logger.clientClock && !ok {
+		return nil, errors.New("client clocks need a store that supports user clocks")
+	}
+	if _, ok := db.(store.RollupStore); logger.rollups != nil && !ok {
+		return nil, errors.New("rollups need a store that supports rollups")
+	}
+	if _, ok := db.(store.UserLockStore); logger.userLocks && !ok {
+		return nil, errors.New("user locks need a store that supports user locks")
+	}
+	if logger.userLocks && (logger.cache != nil || logger.buffer != nil) {
+		return nil, errors.New("user locks cannot be combined with the user cache or the write buffer")
+	}
+	if _, ok := db.(store.UserConsolidateStore); logger.atomicConsolidation && !ok {
+		return nil, errors.New("atomic consolidation needs a store that supports consolidating user searches")
+	}
+	if logger.atomicConsolidation && (logger.cache != nil || logger.buffer != nil || logger.typos != nil || logger.gaps != nil) {
+		return nil, errors.New("atomic consolidation cannot be combined with the user cache, the write buffer, typo merging or session gaps")
+	}
+	if logger.atomicConsolidation && logger.clientClock {
+		return nil, errors.New("atomic consolidation cannot be combined with client clocks")
+	}
+	if logger.stemmer != nil && logger.sessions == nil {
+		return nil, errors.New("stemming needs WithFinalizeTimeout")
+	}
+	if logger.branchMinShared > 0 && logger.gaps == nil {
+		return nil, errors.New("branch detection needs WithSessionGap")
+	}
+	if _, ok := db.(store.TopSearchStore); logger.terms != nil && !ok {
+		return nil, errors.New("substring search needs a store that supports top searches")
+	}
+	if err := logger.loadSynonyms(context.Background()); err != nil {
+		return nil, err
+	}
+	if err := logger.loadTerms(context.Background()); err != nil {
+		return nil, err
+	}
+	logger.indexes = logger.wordIndexes()
+	logger.ingest = logger.buildChain()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	logger.cancel = cancel
+	if logger.buffer != nil {
+		logger.buffer.heartbeat.Store(time.Now().UnixNano())
+		logger.wg.Add(1)
+		go logger.flushRoutine(ctx)
+	}
+	if logger.sessions != nil {
+		logger.sessions.heartbeat.Store(time.Now().UnixNano())
+		logger.wg.Add(1)
+		go logger.finalizeRoutine(ctx)
+	}
+	if logger.retention != nil {
+		logger.wg.Add(1)
+		go logger.retentionRoutine(ctx)
+	}
+	if logger.rollups != nil {
+		logger.wg.Add(1)
+		go logger.rollupRoutine(ctx)
 	}
 
 	return logger, nil
 }
 
-// LogSearchV2 processes a search term for a specific user
-func (sl *SearchLoggerV2) LogSearchV2(userIdentifier, word string) error {
-	if word == "" || userIdentifier == "" {
-		return fmt.Errorf("word and userIdentifier cannot be empty")
+// NewSearchLoggerV2WithPostgres creates a SearchLoggerV2 backed by a real PostgreSQL database
+func NewSearchLoggerV2WithPostgres(cfg store.PostgresConfig, opts ...Option) (*SearchLoggerV2, error) {
+	db, err := store.NewPostgresDBV2(cfg)
+	if err != nil {
+		return nil, err
 	}
 
-	word = strings.ToLower(strings.TrimSpace(word))
-	now := time.Now()
+	logger, err := NewSearchLoggerV2WithDB(db, opts...)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return logger, nil
+}
+
+// NewSearchLoggerV2WithSQLite creates a SearchLoggerV2 backed by an embedded SQLite database
+func NewSearchLoggerV2WithSQLite(cfg store.SQLiteConfig, opts ...Option) (*SearchLoggerV2, error) {
+	db, err := store.NewSQLiteDBV2(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	// Handle word extension and storage in a single operation
-	if err := sl.storeOrExtendUserSearch(userIdentifier, word, now); err != nil {
-		return fmt.Errorf("failed to store user search: %w", err)
+	logger, err := NewSearchLoggerV2WithDB(db, opts...)
+	if err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	return nil
+	return logger, nil
 }
 
-// storeOrExtendUserSearch handles both word extension and storage in a single operation
-func (sl *SearchLoggerV2) storeOrExtendUserSearch(userIdentifier, word string, timestamp time.Time) error {
+// SetOutput sets the writer receiving a trace of the dedup decisions,
+// e.g. " (extending 'bu' to 'bus')", used by the demo to show its progress
+func (sl *SearchLoggerV2) SetOutput(w io.Writer) {
+	sl.out = w
+}
+
+// LogSearchV2 processes a search term for a specific user. It returns ErrEmptyUser,
+// ErrEmptyWord, ErrWordTooLong or ErrInvalidInput for invalid input and
+// ErrStoreUnavailable, wrapped, when the store cannot be reached.
+func (sl *SearchLoggerV2) LogSearchV2(ctx context.Context, userIdentifier, word string) error {
+	return sl.LogSearchEvent(ctx, SearchEvent{UserIdentifier: userIdentifier, Query: word})
+}
+
+// LogSearchEvent processes a search like LogSearchV2, carrying the
+// idempotency key of its client request, see WithCoalescing
+func (sl *SearchLoggerV2) LogSearchEvent(ctx context.Context, event SearchEvent) (err error) {
+	ctx, span := sl.tracer.Start(ctx, "logsearch.LogSearchV2", tracing.KeyLogger.String(metrics.LoggerV2))
+	defer func() { tracing.End(span, err) }()
+
+	return sl.ingest(ctx, event)
+}
+
+// LogSearchBatch processes many searches at once, e.g. keystrokes collected by an edge service.
+// Every event is processed even if some fail, the returned error joins all failures.
+func (sl *SearchLoggerV2) LogSearchBatch(ctx context.Context, events []SearchEvent) (err error) {
+	ctx, span := sl.tracer.Start(ctx, "logsearch.LogSearchBatch", tracing.KeyLogger.String(metrics.LoggerV2), tracing.KeyBatchSize.Int(len(events)))
+	defer func() { tracing.End(span, err) }()
+
+	var errs []error
+	for _, event := range events {
+		if err := sl.LogSearchEvent(ctx, event); err != nil {
+			errs = append(errs, fmt.Errorf("search %q of %s: %w", event.Query, event.UserIdentifier, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// storeOrExtendUserSearch handles both word extension and storage in a single operation,
+// late tells a search sent before a search of the user already delivered, see WithClientClock,
+// region is where the search was made, see WithRegions
+func (sl *SearchLoggerV2) storeOrExtendUserSearch(ctx context.Context, userIdentifier, word string, timestamp time.Time, late bool, region store.Region) error {
+	if ok, err := sl.admitted(ctx, userIdentifier, word, timestamp); !ok {
+		return err
+	}
+	return sl.storeUserSearch(ctx, userIdentifier, word, timestamp, late, region)
+}
+
+// admitted reports whether the finished search of word passes the filters of
+// WithQuarantineFilters, a rejected search being quarantined. StageFilter
+// already dropped the searches rejected by WithFilters.
+func (sl *SearchLoggerV2) admitted(ctx context.Context, userIdentifier, word string, timestamp time.Time) (bool, error) {
+	if !Allowed(sl.quarantineFilters, word) {
+		return false, sl.quarantine(ctx, userIdentifier, word, ReasonFilter, timestamp)
+	}
+	return true, nil
+}
+
+// storeUserSearch stores the search of word passing the filters, extending or ignoring the stored words
+func (sl *SearchLoggerV2) storeUserSearch(ctx context.Context, userIdentifier, word string, timestamp time.Time, late bool, region store.Region) error {
+	// The dedup compares stems, the typed form is kept for display
+	surface := word
+	word = sl.stem(word)
+
+	if sl.buffer != nil {
+		return sl.bufferUserSearch(ctx, userIdentifier, word, surface, timestamp, late, region)
+	}
+	return sl.lockUser(ctx, userIdentifier, func(ctx context.Context) error {
+		if sl.atomicConsolidation {
+			return sl.consolidateInStore(ctx, userIdentifier, word, surface, timestamp, region)
+		}
+		return sl.consolidateUserSearch(ctx, userIdentifier, word, surface, timestamp, late, region)
+	})
+}
+
+// consolidateUserSearch stores the search of word against the stored words of the user
+func (sl *SearchLoggerV2) consolidateUserSearch(ctx context.Context, userIdentifier, word, surface string, timestamp time.Time, late bool, region store.Region) error {
 	// Get all existing searches for this user
-	existingWords, err := sl.db.GetUserSearches(userIdentifier)
+	existingWords, err := sl.sortedUserWords(ctx, userIdentifier)
 	if err != nil {
 		return err
 	}
+	// Only the words of the current session consolidate, older ones count anew
+	existingWords, previousWord := sl.enterSession(userIdentifier, word, existingWords, timestamp, late)
+
+	// Check if the new word extends an existing shorter word (forward extension)
+	if existingWord, ok := longestStoredPrefix(existingWords, word); ok {
+		fmt.Fprintf(sl.out, " (extending '%s' to '%s')", existingWord, word)
+		sl.decided(ctx, metrics.DecisionExtend)
+		if err := sl.renameUserSearch(ctx, userIdentifier, existingWord, word, timestamp); err != nil {
+			return err
+		}
+		sl.setSurface(ctx, userIdentifier, word, surface)
+		sl.setRegion(ctx, userIdentifier, word, region)
+		return nil
+	}
 
-	// Check if the new word extends any existing shorter word (forward extension)
-	for _, existingWord := range existingWords {
-		if len(existingWord) < len(word) && strings.HasPrefix(word, existingWord) {
-			fmt.Printf(" (extending '%s' to '%s')", existingWord, word)
+	// Check if the new word is a prefix of an existing longer word (out of order case)
+	if existingWord, ok := storedExtension(existingWords, word); ok {
+		fmt.Fprintf(sl.out, " (ignoring prefix of '%s')", existingWord)
+		sl.decided(ctx, metrics.DecisionIgnore)
+		return nil
+	}
 
-			// Update the shorter word to the new longer word
-			if err := sl.db.UpdateUserSearchByWord(userIdentifier, existingWord, word, timestamp); err != nil {
-				log.Printf("Error updating user search from '%s' to '%s': %v", existingWord, word, err)
+	// Check if the new word and a stored word only differ by a slipped key
+	if existingWord, ok := sl.storedTypo(existingWords, word); ok {
+		sl.decided(ctx, metrics.DecisionTypo)
+		if !late && sl.canonicalOf(existingWord, word) == word {
+			fmt.Fprintf(sl.out, " (fixing typo '%s' to '%s')", existingWord, word)
+			if err := sl.renameUserSearch(ctx, userIdentifier, existingWord, word, timestamp); err != nil {
 				return err
 			}
-
+			sl.setSurface(ctx, userIdentifier, word, surface)
+			sl.setRegion(ctx, userIdentifier, word, region)
 			return nil
 		}
+		fmt.Fprintf(sl.out, " (merging typo into '%s')", existingWord)
+		if err := sl.insertUserSearch(ctx, userIdentifier, existingWord, timestamp); err != nil {
+			return err
+		}
+		sl.setRegion(ctx, userIdentifier, existingWord, region)
+		return nil
 	}
 
-	// Check if the new word is a prefix of any existing longer word (out of order case)
-	for _, existingWord := range existingWords {
-		if len(word) < len(existingWord) && strings.HasPrefix(existingWord, word) {
-			fmt.Printf(" (ignoring prefix of '%s')", existingWord)
-			return nil
+	// Check if the search is a late branch the user already corrected
+	if existingWord, ok := sl.lateBranch(existingWords, word, late); ok {
+		fmt.Fprintf(sl.out, " (ignoring branch corrected to '%s')", existingWord)
+		sl.decided(ctx, metrics.DecisionBranch)
+		return nil
+	}
+
+	// Check if the user backspaced from the previous word and typed another branch
+	if existingWord, ok := sl.correctedBranch(existingWords, previousWord, word); ok {
+		fmt.Fprintf(sl.out, " (correcting '%s' to '%s')", existingWord, word)
+		sl.decided(ctx, metrics.DecisionBranch)
+		if err := sl.renameUserSearch(ctx, userIdentifier, existingWord, word, timestamp); err != nil {
+			return err
 		}
+		sl.setSurface(ctx, userIdentifier, word, surface)
+		sl.setRegion(ctx, userIdentifier, word, region)
+		return nil
 	}
 
 	// No extension found, store as new search or update existing
-	_, err = sl.db.InsertOrUpdateUserSearch(userIdentifier, word, timestamp, timestamp)
+	sl.decided(ctx, metrics.DecisionNew)
+	if err := sl.insertUserSearch(ctx, userIdentifier, word, timestamp); err != nil {
+		return err
+	}
+	sl.setSurface(ctx, userIdentifier, word, surface)
+	sl.setRegion(ctx, userIdentifier, word, region)
+	fmt.Fprintf(sl.out, " (new)")
+	return nil
+}
+
+// decided records the dedup decision of a search in the metrics and on its span
+func (sl *SearchLoggerV2) decided(ctx context.Context, decision string) {
+	sl.metrics.Decision(metrics.LoggerV2, decision)
+	tracing.SetDecision(ctx, decision)
+}
+
+// renameUserSearch replaces the user's stored existingWord with word, counting one more search
+func (sl *SearchLoggerV2) renameUserSearch(ctx context.Context, userIdentifier, existingWord, word string, timestamp time.Time) error {
+	writeCtx, span := sl.tracer.Start(ctx, "store.UpdateUserSearchByWord", tracing.KeyOp.String(metrics.OpUpdate))
+	start := time.Now()
+	err := sl.db.UpdateUserSearchByWord(writeCtx, userIdentifier, existingWord, word, timestamp)
+	sl.metrics.ObserveWrite(metrics.LoggerV2, metrics.OpUpdate, start, err)
+	tracing.End(span, err)
 	if err != nil {
+		log.Printf("Error updating user search from '%s' to '%s': %v", existingWord, word, err)
+		sl.cache.invalidate(userIdentifier)
+		sl.invalidateResults(ctx, userIdentifier)
 		return err
 	}
+	sl.renamed(ctx, userIdentifier, existingWord, word, timestamp)
+	return nil
+}
 
-	fmt.Printf(" (new)")
+// renamed updates the cache, results and counters once the user's existingWord was renamed to word in the store
+func (sl *SearchLoggerV2) renamed(ctx context.Context, userIdentifier, existingWord, word string, timestamp time.Time) {
+	sl.cache.replace(userIdentifier, existingWord, word)
+	sl.invalidateResults(ctx, userIdentifier)
+	sl.countAudience(ctx, []store.AudienceAdd{{Word: word, UserIdentifier: userIdentifier, At: timestamp}})
+	sl.moved(existingWord, word, timestamp)
+	sl.wordFinalized(FinalizedWord{UserIdentifier: userIdentifier, Word: word, ReplacedWords: []string{existingWord}, Count: 1, At: timestamp})
+}
+
+// insertUserSearch stores a search of word for the user, or counts one more of the stored word
+func (sl *SearchLoggerV2) insertUserSearch(ctx context.Context, userIdentifier, word string, timestamp time.Time) error {
+	writeCtx, span := sl.tracer.Start(ctx, "store.InsertOrUpdateUserSearch", tracing.KeyOp.String(metrics.OpInsert))
+	start := time.Now()
+	_, err := sl.db.InsertOrUpdateUserSearch(writeCtx, userIdentifier, word, timestamp, timestamp)
+	sl.metrics.ObserveWrite(metrics.LoggerV2, metrics.OpInsert, start, err)
+	tracing.End(span, err)
+	if err != nil {
+		sl.cache.invalidate(userIdentifier)
+		sl.invalidateResults(ctx, userIdentifier)
+		return err
+	}
+	sl.inserted(ctx, userIdentifier, word, timestamp)
 	return nil
 }
 
-func (sl *SearchLoggerV2) GetUserSearches(userIdentifier string) ([]string, error) {
-	return sl.db.GetUserSearches(userIdentifier)
+// inserted updates the cache, results and counters once a search of word was stored for the user
+func (sl *SearchLoggerV2) inserted(ctx context.Context, userIdentifier, word string, timestamp time.Time) {
+	sl.cache.add(userIdentifier, word)
+	sl.invalidateResults(ctx, userIdentifier)
+	sl.enforceQuota(ctx, userIdentifier)
+	sl.countAudience(ctx, []store.AudienceAdd{{Word: word, UserIdentifier: userIdentifier, At: timestamp}})
+	sl.counted(word, 1, timestamp)
+	sl.wordFinalized(FinalizedWord{UserIdentifier: userIdentifier, Word: word, Count: 1, At: timestamp})
+}
+
+// sortedUserWords returns the user's stored words in sorted order, from the cache when enabled
+func (sl *SearchLoggerV2) sortedUserWords(ctx context.Context, userIdentifier string) ([]string, error) {
+	if words, ok := sl.cache.get(userIdentifier); ok {
+		return words, nil
+	}
+
+	ctx, span := sl.tracer.Start(ctx, "store.GetUserSearches")
+	words, err := sl.db.GetUserSearches(ctx, userIdentifier)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, store.Classify(err)
+	}
+
+	sl.metrics.ObserveUserRecords(len(words))
+	sorted := append([]string(nil), words...)
+	sort.Strings(sorted)
+	sl.cache.set(userIdentifier, sorted)
+	return sorted, nil
+}
+
+func (sl *SearchLoggerV2) GetUserSearches(ctx context.Context, userIdentifier string) ([]string, error) {
+	if sl.buffer != nil {
+		return sl.bufferedUserSearches(ctx, userIdentifier)
+	}
+	return sl.storedUserSearches(ctx, userIdentifier)
+}
+
+// storedUserSearches returns the stored words of a user, from the result cache when enabled
+func (sl *SearchLoggerV2) storedUserSearches(ctx context.Context, userIdentifier string) ([]string, error) {
+	var words []string
+	if sl.cachedResult(ctx, metrics.QueryUserSearches, userKey(userIdentifier), userSearchesField, &words) {
+		return words, nil
+	}
+
+	words, err := sl.db.GetUserSearches(ctx, userIdentifier)
+	if err != nil {
+		return nil, store.Classify(err)
+	}
+	sl.cacheResult(ctx, userKey(userIdentifier), userSearchesField, words)
+	return words, nil
 }
 
+// GetUserSearchRecords returns the stored records of a user in word order, with
+// their counts, timestamps and surface forms. Buffered and pending searches are
+// not stored yet, call Flush first to include them. It fails unless the store
+// implements store.UserExportStore.
+func (sl *SearchLoggerV2) GetUserSearchRecords(ctx context.Context, userIdentifier string) ([]store.UserSearchRecord, error) {
+	exportStore, ok := sl.db.(store.UserExportStore)
+	if !ok {
+		return nil, errors.New("store does not support reading records")
+	}
+
+	records := make([]store.UserSearchRecord, 0)
+	err := exportStore.ForEachUserSearch(ctx, userIdentifier, func(record store.UserSearchRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return nil, store.Classify(err)
+	}
+	return records, nil
+}
+
+// GetTopSearches returns the limit most searched words over all users, most searched first.
+// Only stored searches count, not pending or buffered ones.
+func (sl *SearchLoggerV2) GetTopSearches(ctx context.Context, limit int) ([]store.WordCount, error) {
+	return sl.GetTopSearchesSince(ctx, time.Time{}, limit)
+}
+
+// GetTopSearchesSince is GetTopSearches restricted to words last searched at or after since.
+// With WithHeavyHitters a zero since is answered from the sketch, other windows from the store.
+func (sl *SearchLoggerV2) GetTopSearchesSince(ctx context.Context, since time.Time, limit int) ([]store.WordCount, error) {
+	if sl.heavy != nil && since.IsZero() {
+		return heavyHitters(sl.heavy, limit), nil
+	}
+
+	topStore, ok := sl.db.(store.TopSearchStore)
+	if !ok {
+		return nil, errors.New("store does not support top searches")
+	}
+	var counts []store.WordCount
+	var field string
+	if sl.results != nil {
+		field = sl.results.topField(since, limit)
+		if sl.cachedResult(ctx, metrics.QueryTop, topKey, field, &counts) {
+			return counts, nil
+		}
+	}
+
+	counts, err := topStore.TopSearches(ctx, since, limit)
+	if err != nil {
+		return nil, store.Classify(err)
+	}
+	sl.cacheResult(ctx, topKey, field, counts)
+	return counts, nil
+}
+
+// heavyHitters converts the words tracked by top to word counts
+func heavyHitters(top *sketch.TopK, limit int) []store.WordCount {
+	items := top.Top(limit)
+	counts := make([]store.WordCount, len(items))
+	for i, item := range items {
+		counts[i] = store.WordCount{Word: item.Word, Count: int(item.Count)}
+	}
+	return counts
+}
+
+// DeleteUserData erases a user for right-to-be-forgotten requests: the stored
+// records, the cached words and the searches still pending in memory. It returns
+// how many stored records were deleted.
+func (sl *SearchLoggerV2) DeleteUserData(ctx context.Context, userIdentifier string) (int64, error) {
+	if userIdentifier == "" {
+		return 0, ErrEmptyUser
+	}
+	deleteStore, ok := sl.db.(store.UserDeleteStore)
+	if !ok {
+		return 0, errors.New("store does not support deleting users")
+	}
+
+	if sl.sessions != nil {
+		sl.sessions.forget(userIdentifier)
+	}
+	sl.gaps.forget(userIdentifier)
+	if sl.buffer != nil {
+		// Holding the buffer keeps a concurrent flush from writing the user back
+		sl.buffer.mutex.Lock()
+		defer sl.buffer.mutex.Unlock()
+		sl.discarded(sl.buffer.forget(userIdentifier))
+	}
+
+	deleted, err := deleteStore.DeleteUserSearches(ctx, userIdentifier)
+	sl.cache.invalidate(userIdentifier)
+	sl.invalidateResults(ctx, userIdentifier)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete user searches: %w", store.Classify(err))
+	}
+	sl.removed(deleted)
+	if err := sl.forgetClock(ctx, userIdentifier); err != nil {
+		return int64(len(deleted)), err
+	}
+	if err := sl.forgetQuarantine(ctx, userIdentifier); err != nil {
+		return int64(len(deleted)), err
+	}
+	if err := sl.forgetClicks(ctx, userIdentifier); err != nil {
+		return int64(len(deleted)), err
+	}
+
+	return int64(len(deleted)), nil
+}
+
+// MergeIdentities stitches the history of a guest into the user they logged in
+// as. The records of anonID move to userID, merging the records of the same
+// word and merging a word into a longer word of the other history that extends
+// it, summing the counts and keeping the earliest first search. The pending
+// searches of anonID are handed over to userID too.
+func (sl *SearchLoggerV2) MergeIdentities(ctx context.Context, anonID, userID string) error {
+	if anonID == "" || userID == "" {
+		return ErrEmptyUser
+	}
+	if anonID == userID {
+		return nil
+	}
+	mergeStore, ok := sl.db.(store.UserMergeStore)
+	if !ok {
+		return errors.New("store does not support merging users")
+	}
+
+	if sl.sessions != nil {
+		sl.sessions.move(anonID, userID)
+	}
+	sl.gaps.forget(anonID)
+	if sl.buffer != nil {
+		// The buffered writes of both users must reach the store before it merges them
+		sl.buffer.mutex.Lock()
+		defer sl.buffer.mutex.Unlock()
+		if err := sl.flushLocked(ctx); err != nil {
+			return err
+		}
+	}
+
+	err := mergeStore.MergeUserSearches(ctx, anonID, userID)
+	sl.cache.invalidate(anonID)
+	sl.cache.invalidate(userID)
+	sl.invalidateResults(ctx, anonID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to merge user searches: %w", store.Classify(err))
+	}
+
+	// Both histories may fit their quota while the merged one does not
+	sl.enforceQuota(ctx, userID)
+	return nil
+}
+
+// Close finalizes pending words, flushes buffered searches and closes the store
 func (sl *SearchLoggerV2) Close() error {
+	// Cancel the background routines, aborting an in-flight periodic flush, then flush what is left
+	sl.cancel()
+	sl.wg.Wait()
+	if err := sl.Flush(context.Background()); err != nil {
+		log.Printf("Error flushing pending searches on close: %v", err)
+	}
 	return sl.db.Close()
 }
 
@@ -1,56 +1,71 @@
-package main
+package logsearch
 
 import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/afanwang/logsearch/metrics"
+	"github.com/afanwang/logsearch/normalize"
+	"github.com/afanwang/logsearch/spell"
+	"github.com/afanwang/logsearch/store"
+	"github.com/afanwang/logsearch/tracing"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestSearchLoggerV2_BasicProgressiveTyping(t *testing.T) {
+	ctx := context.Background()
 	logger, err := NewSearchLoggerV2()
 	assert.NoError(t, err)
 	defer logger.Close()
 
 	// Test progressive typing for user - should consolidate to final word
-	assert.NoError(t, logger.LogSearchV2("user_1", "b"))
-	assert.NoError(t, logger.LogSearchV2("user_1", "bu"))
-	assert.NoError(t, logger.LogSearchV2("user_1", "bus"))
-	assert.NoError(t, logger.LogSearchV2("user_1", "business"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "b"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "bu"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "bus"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "business"))
 
 	// User should only have "business" stored
-	searches, err := logger.GetUserSearches("user_1")
+	searches, err := logger.GetUserSearches(ctx, "user_1")
 	assert.NoError(t, err)
 	assert.Equal(t, []string{"business"}, searches)
 }
 
 func TestSearchLoggerV2_MultipleUsers(t *testing.T) {
+	ctx := context.Background()
 	logger, err := NewSearchLoggerV2()
 	assert.NoError(t, err)
 	defer logger.Close()
 
 	// User1 progressive typing
-	assert.NoError(t, logger.LogSearchV2("user_1", "c"))
-	assert.NoError(t, logger.LogSearchV2("user_1", "ca"))
-	assert.NoError(t, logger.LogSearchV2("user_1", "cat"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "c"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "ca"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "cat"))
 
 	// User2 different progressive typing
-	assert.NoError(t, logger.LogSearchV2("user_2", "d"))
-	assert.NoError(t, logger.LogSearchV2("user_2", "do"))
-	assert.NoError(t, logger.LogSearchV2("user_2", "dog"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_2", "d"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_2", "do"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_2", "dog"))
 
 	// Both users typing same word separately
-	assert.NoError(t, logger.LogSearchV2("user_1", "apple"))
-	assert.NoError(t, logger.LogSearchV2("user_2", "apple"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "apple"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_2", "apple"))
 
 	// Check user1 searches
-	user1Searches, err := logger.GetUserSearches("user_1")
+	user1Searches, err := logger.GetUserSearches(ctx, "user_1")
 	assert.NoError(t, err)
 	assert.Contains(t, user1Searches, "cat")
 	assert.Contains(t, user1Searches, "apple")
 	assert.Len(t, user1Searches, 2)
 
 	// Check user2 searches
-	user2Searches, err := logger.GetUserSearches("user_2")
+	user2Searches, err := logger.GetUserSearches(ctx, "user_2")
 	assert.NoError(t, err)
 	assert.Contains(t, user2Searches, "dog")
 	assert.Contains(t, user2Searches, "apple")
@@ -58,36 +73,37 @@ func TestSearchLoggerV2_MultipleUsers(t *testing.T) {
 }
 
 func TestSearchLoggerV2_InOrderVsOutOfOrder(t *testing.T) {
+	ctx := context.Background()
 	logger, err := NewSearchLoggerV2()
 	assert.NoError(t, err)
 	defer logger.Close()
 
 	// Test 1: In-order progressive typing (normal case)
-	assert.NoError(t, logger.LogSearchV2("user_inorder", "b"))
-	assert.NoError(t, logger.LogSearchV2("user_inorder", "bu"))
-	assert.NoError(t, logger.LogSearchV2("user_inorder", "bus"))
-	assert.NoError(t, logger.LogSearchV2("user_inorder", "busi"))
-	assert.NoError(t, logger.LogSearchV2("user_inorder", "busin"))
-	assert.NoError(t, logger.LogSearchV2("user_inorder", "busine"))
-	assert.NoError(t, logger.LogSearchV2("user_inorder", "busines"))
-	assert.NoError(t, logger.LogSearchV2("user_inorder", "business"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_inorder", "b"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_inorder", "bu"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_inorder", "bus"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_inorder", "busi"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_inorder", "busin"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_inorder", "busine"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_inorder", "busines"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_inorder", "business"))
 
 	// Test 2: Out-of-order typing (full word first, then shorter)
-	assert.NoError(t, logger.LogSearchV2("user_outorder", "business"))
-	assert.NoError(t, logger.LogSearchV2("user_outorder", "busines"))
-	assert.NoError(t, logger.LogSearchV2("user_outorder", "busine"))
-	assert.NoError(t, logger.LogSearchV2("user_outorder", "busin"))
-	assert.NoError(t, logger.LogSearchV2("user_outorder", "busi"))
-	assert.NoError(t, logger.LogSearchV2("user_outorder", "bus"))
-	assert.NoError(t, logger.LogSearchV2("user_outorder", "bu"))
-	assert.NoError(t, logger.LogSearchV2("user_outorder", "b"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_outorder", "business"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_outorder", "busines"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_outorder", "busine"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_outorder", "busin"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_outorder", "busi"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_outorder", "bus"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_outorder", "bu"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_outorder", "b"))
 
 	// Both users should end up with the same final result
-	inOrderSearches, err := logger.GetUserSearches("user_inorder")
+	inOrderSearches, err := logger.GetUserSearches(ctx, "user_inorder")
 	assert.NoError(t, err)
 	assert.Equal(t, []string{"business"}, inOrderSearches)
 
-	outOfOrderSearches, err := logger.GetUserSearches("user_outorder")
+	outOfOrderSearches, err := logger.GetUserSearches(ctx, "user_outorder")
 	assert.NoError(t, err)
 	assert.Equal(t, []string{"business"}, outOfOrderSearches)
 
@@ -95,3 +111,501 @@ func TestSearchLoggerV2_InOrderVsOutOfOrder(t *testing.T) {
 	assert.Len(t, inOrderSearches, 1, "In-order user should have exactly one record")
 	assert.Len(t, outOfOrderSearches, 1, "Out-of-order user should have exactly one record")
 }
+
+// countingStore wraps a UserSearchStore to check that any backend can be plugged in
+type countingStore struct {
+	store.UserSearchStore
+	lookups int
+}
+
+func (s *countingStore) GetUserSearches(ctx context.Context, userIdentifier string) ([]string, error) {
+	s.lookups++
+	return s.UserSearchStore.GetUserSearches(ctx, userIdentifier)
+}
+
+func TestSearchLoggerV2_CustomStore(t *testing.T) {
+	ctx := context.Background()
+	counting := &countingStore{UserSearchStore: store.NewMockPostgresDBV2()}
+	logger, err := NewSearchLoggerV2WithDB(counting)
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "c"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "cat"))
+
+	searches, err := logger.GetUserSearches(ctx, "user_1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cat"}, searches)
+	assert.Equal(t, 3, counting.lookups)
+}
+
+func TestSearchLoggerV2_UserCache(t *testing.T) {
+	ctx := context.Background()
+	counting := &countingStore{UserSearchStore: store.NewMockPostgresDBV2()}
+	logger, err := NewSearchLoggerV2WithDB(counting, WithUserCache(100))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	for _, word := range []string{"b", "bu", "bus", "business", "bus", "c", "cat"} {
+		assert.NoError(t, logger.LogSearchV2(ctx, "user_1", word))
+	}
+
+	// Only the first keystroke loads the user from the store
+	assert.Equal(t, 1, counting.lookups)
+
+	searches, err := logger.GetUserSearches(ctx, "user_1")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"business", "cat"}, searches)
+}
+
+func TestSearchLoggerV2_CancelledContext(t *testing.T) {
+	logger, err := NewSearchLoggerV2()
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = logger.LogSearchV2(ctx, "user_1", "cat")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestSearchLoggerV2_TopSearches(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLoggerV2()
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	for _, event := range []SearchEvent{
+		{UserIdentifier: "user_1", Query: "bus"},
+		{UserIdentifier: "user_1", Query: "bus"},
+		{UserIdentifier: "user_2", Query: "bus"},
+		{UserIdentifier: "user_2", Query: "cat"},
+		{UserIdentifier: "user_3", Query: "cat"},
+		{UserIdentifier: "user_3", Query: "dog"},
+	} {
+		assert.NoError(t, logger.LogSearchV2(ctx, event.UserIdentifier, event.Query))
+	}
+
+	// Counts are summed over users, ties are in word order
+	top, err := logger.GetTopSearches(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []store.WordCount{{Word: "bus", Count: 3}, {Word: "cat", Count: 2}}, top)
+
+	top, err = logger.GetTopSearchesSince(ctx, time.Now().Add(time.Hour), 10)
+	assert.NoError(t, err)
+	assert.Empty(t, top)
+}
+
+func TestSearchLoggerV2_HeavyHitters(t *testing.T) {
+	ctx := context.Background()
+	// The counting store cannot rank words, the sketch answers instead
+	counting := &countingStore{UserSearchStore: store.NewMockPostgresDBV2()}
+	logger, err := NewSearchLoggerV2WithDB(counting, WithHeavyHitters(3, 0.01, 0.01))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	for _, user := range []string{"user_1", "user_2", "user_3"} {
+		for _, word := range []string{"b", "bu", "bus"} {
+			assert.NoError(t, logger.LogSearchV2(ctx, user, word))
+		}
+	}
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_4", "cat"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "business"))
+
+	top, err := logger.GetTopSearches(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []store.WordCount{{Word: "bus", Count: 2}, {Word: "business", Count: 1}}, top)
+
+	_, err = logger.GetTopSearchesSince(ctx, time.Now().Add(-time.Hour), 2)
+	assert.Error(t, err, "Windows are still answered by the store")
+}
+
+func TestSearchLoggerV2_Trending(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLoggerV2(WithTrending(time.Minute, 60))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	for _, user := range []string{"user_1", "user_2"} {
+		for _, word := range []string{"b", "bu", "bus"} {
+			assert.NoError(t, logger.LogSearchV2(ctx, user, word))
+		}
+	}
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_3", "cat"))
+
+	trending, err := logger.GetTrending(time.Hour, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []store.WordCount{{Word: "bus", Count: 2}, {Word: "cat", Count: 1}}, trending)
+
+	disabled, err := NewSearchLoggerV2()
+	assert.NoError(t, err)
+	defer disabled.Close()
+	_, err = disabled.GetTrending(time.Hour, 10)
+	assert.Error(t, err)
+}
+
+func TestSearchLoggerV2_DidYouMean(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLoggerV2(WithSpellCorrection(2))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	for _, user := range []string{"user_1", "user_2", "user_3"} {
+		for _, word := range []string{"b", "bu", "bus"} {
+			assert.NoError(t, logger.LogSearchV2(ctx, user, word))
+		}
+	}
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_4", "bsu"))
+
+	suggestion, ok, err := logger.DidYouMean("BSU")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, spell.Suggestion{Word: "bus", Count: 3, Distance: 1}, suggestion)
+
+	// The extended prefixes are not in the vocabulary anymore, and the popular form has no correction
+	_, ok, err = logger.DidYouMean("bus")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	disabled, err := NewSearchLoggerV2()
+	assert.NoError(t, err)
+	defer disabled.Close()
+	_, _, err = disabled.DidYouMean("bsu")
+	assert.Error(t, err)
+}
+
+func TestSearchLoggerV2_Metrics(t *testing.T) {
+	ctx := context.Background()
+	m := metrics.New()
+	logger, err := NewSearchLoggerV2(WithMetrics(m))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	for _, word := range []string{"bus", "b", "busi", "cat"} {
+		assert.NoError(t, logger.LogSearchV2(ctx, "user_1", word))
+	}
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	assert.Contains(t, body, `logsearch_searches_logged_total{logger="v2"} 4`)
+	assert.Contains(t, body, `logsearch_dedup_decisions_total{decision="new",logger="v2"} 2`)
+	assert.Contains(t, body, `logsearch_dedup_decisions_total{decision="extend",logger="v2"} 1`)
+	assert.Contains(t, body, `logsearch_dedup_decisions_total{decision="ignore",logger="v2"} 1`)
+	assert.Contains(t, body, `logsearch_db_write_duration_seconds_count{logger="v2",op="insert",result="ok"} 2`)
+	assert.Contains(t, body, `logsearch_user_records_count 4`)
+}
+
+func TestSearchLoggerV2_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	logger, err := NewSearchLoggerV2(WithTracing(tracing.New(tp)))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	ctx, request := tp.Tracer("test").Start(context.Background(), "request")
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "bus"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "business"))
+	request.End()
+
+	// Each search loads the user's words and writes one, under the span of the request
+	var names []string
+	byName := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		names = append(names, span.Name())
+		byName[span.Name()] = span
+	}
+	assert.Equal(t, []string{
+		"store.GetUserSearches", "store.InsertOrUpdateUserSearch", "logsearch.LogSearchV2",
+		"store.GetUserSearches", "store.UpdateUserSearchByWord", "logsearch.LogSearchV2",
+		"request",
+	}, names)
+	search := byName["logsearch.LogSearchV2"]
+	assert.Equal(t, request.SpanContext().SpanID(), search.Parent().SpanID())
+	assert.Contains(t, search.Attributes(), tracing.KeyDecision.String(metrics.DecisionExtend))
+	assert.Equal(t, search.SpanContext().SpanID(), byName["store.UpdateUserSearchByWord"].Parent().SpanID())
+}
+
+func TestSearchLoggerV2_Normalizer(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLoggerV2(WithNormalizer(normalize.NewUnicode(normalize.WithDiacriticFolding())))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	// Accented, decomposed and unaccented spellings extend one record
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "Caf"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "Cafe\u0301"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "café"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "cafes"))
+
+	searches, err := logger.GetUserSearches(ctx, "user_1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cafes"}, searches)
+}
+
+func TestSearchLoggerV2_DeleteUserData(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLoggerV2(WithUserCache(100), WithWriteBuffer(100, time.Hour),
+		WithSpellCorrection(1), WithSubstringSearch(), WithTrending(time.Minute, 60))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	for _, event := range []SearchEvent{
+		{UserIdentifier: "user_1", Query: "bus"},
+		{UserIdentifier: "user_1", Query: "cat"},
+		{UserIdentifier: "user_2", Query: "dog"},
+	} {
+		assert.NoError(t, logger.LogSearchV2(ctx, event.UserIdentifier, event.Query))
+	}
+	assert.NoError(t, logger.Flush(ctx))
+
+	// Buffered searches not stored yet are discarded too
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "busy"))
+
+	deleted, err := logger.DeleteUserData(ctx, "user_1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	assert.NoError(t, logger.Flush(ctx))
+	searches, err := logger.GetUserSearches(ctx, "user_1")
+	assert.NoError(t, err)
+	assert.Empty(t, searches)
+
+	searches, err = logger.GetUserSearches(ctx, "user_2")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dog"}, searches)
+
+	// Nor do the in-memory indexes, stored and buffered words alike
+	for _, word := range []string{"bus", "busy", "cat"} {
+		assert.Zero(t, logger.spell.Count(word), word)
+		terms, err := logger.GetTermsContaining(word, 10)
+		assert.NoError(t, err)
+		assert.Empty(t, terms, word)
+	}
+	assert.Equal(t, int64(1), logger.spell.Count("dog"))
+	trending, err := logger.GetTrending(time.Hour, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []store.WordCount{{Word: "dog", Count: 1}}, trending)
+
+	// The cache no longer remembers the deleted words
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "bus"))
+	assert.NoError(t, logger.Flush(ctx))
+	searches, err = logger.GetUserSearches(ctx, "user_1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bus"}, searches)
+}
+
+func TestSearchLoggerV2_Purge(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDBV2()
+	m := metrics.New()
+	old := time.Now().Add(-48 * time.Hour)
+	_, err := db.InsertOrUpdateUserSearch(ctx, "user_1", "bus", old, old)
+	assert.NoError(t, err)
+	logger, err := NewSearchLoggerV2WithDB(db, WithUserCache(100), WithMetrics(m), WithSubstringSearch())
+	assert.NoError(t, err)
+	defer logger.Close()
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "cat"))
+
+	deleted, err := logger.Purge(ctx, time.Now().Add(-24*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	terms, err := logger.GetTermsContaining("bus", 10)
+	assert.NoError(t, err)
+	assert.Empty(t, terms, "The purged word leaves the indexes")
+
+	searches, err := logger.GetUserSearches(ctx, "user_1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cat"}, searches)
+
+	// The cache no longer remembers the purged word
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "bus"))
+	searches, err = logger.GetUserSearches(ctx, "user_1")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"bus", "cat"}, searches)
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), `logsearch_purged_records_total{logger="v2"} 1`)
+}
+
+func TestSearchLoggerV2_Retention(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLoggerV2(WithRetention(time.Millisecond, 10*time.Millisecond))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "bus"))
+	assert.Eventually(t, func() bool {
+		searches, err := logger.GetUserSearches(ctx, "user_1")
+		return err == nil && len(searches) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = NewSearchLoggerV2WithDB(&countingStore{UserSearchStore: store.NewMockPostgresDBV2()}, WithRetention(time.Hour, time.Minute))
+	assert.Error(t, err, "Stores that cannot purge should be rejected")
+}
+
+func TestSearchLoggerV2_UserQuota(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDBV2()
+	m := metrics.New()
+	old := time.Now().Add(-time.Hour)
+	_, err := db.InsertOrUpdateUserSearch(ctx, "user_1", "bus", old, old)
+	assert.NoError(t, err)
+	logger, err := NewSearchLoggerV2WithDB(db, WithUserCache(100), WithUserQuota(2), WithMetrics(m), WithSubstringSearch())
+	assert.NoError(t, err)
+	defer logger.Close()
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "cat"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "dog"))
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_2", "eel"))
+
+	// The least recently updated search of user_1 is evicted, user_2 is under quota
+	searches, err := logger.GetUserSearches(ctx, "user_1")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"cat", "dog"}, searches)
+	searches, err = logger.GetUserSearches(ctx, "user_2")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"eel"}, searches)
+	terms, err := logger.GetTermsContaining("bus", 10)
+	assert.NoError(t, err)
+	assert.Empty(t, terms, "The evicted word leaves the indexes")
+
+	// The cache no longer remembers the evicted word
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "bus"))
+	searches, err = logger.GetUserSearches(ctx, "user_1")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"bus", "dog"}, searches)
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), `logsearch_quota_evicted_records_total{logger="v2"} 2`)
+
+	_, err = NewSearchLoggerV2WithDB(&countingStore{UserSearchStore: store.NewMockPostgresDBV2()}, WithUserQuota(2))
+	assert.Error(t, err, "Stores that cannot trim should be rejected")
+}
+
+func TestSearchLoggerV2_MergeIdentities(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLoggerV2(WithUserCache(100), WithWriteBuffer(100, time.Hour))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	for _, event := range []SearchEvent{
+		{UserIdentifier: "anon_1", Query: "bus"},
+		{UserIdentifier: "anon_1", Query: "cat"},
+		{UserIdentifier: "user_1", Query: "business"},
+	} {
+		assert.NoError(t, logger.LogSearchV2(ctx, event.UserIdentifier, event.Query))
+	}
+	assert.NoError(t, logger.Flush(ctx))
+
+	// Still buffered when the guest logs in
+	assert.NoError(t, logger.LogSearchV2(ctx, "anon_1", "dog"))
+
+	assert.NoError(t, logger.MergeIdentities(ctx, "anon_1", "user_1"))
+
+	searches, err := logger.GetUserSearches(ctx, "user_1")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"business", "cat", "dog"}, searches)
+
+	searches, err = logger.GetUserSearches(ctx, "anon_1")
+	assert.NoError(t, err)
+	assert.Empty(t, searches)
+
+	// The cache knows the merged history, "cat" is extended rather than stored anew
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "cats"))
+	assert.NoError(t, logger.Flush(ctx))
+	searches, err = logger.GetUserSearches(ctx, "user_1")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"business", "cats", "dog"}, searches)
+
+	assert.NoError(t, logger.MergeIdentities(ctx, "user_1", "user_1"))
+	assert.Error(t, logger.MergeIdentities(ctx, "", "user_1"))
+}
+
+func TestSearchLoggerV2_MergeIdentitiesPending(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLoggerV2(WithFinalizeTimeout(time.Hour))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	assert.NoError(t, logger.LogSearchV2(ctx, "anon_1", "bu"))
+	assert.NoError(t, logger.MergeIdentities(ctx, "anon_1", "user_1"))
+
+	// The pending word of the guest is finalized for the user
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "bus"))
+	assert.NoError(t, logger.Flush(ctx))
+	searches, err := logger.GetUserSearches(ctx, "user_1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bus"}, searches)
+}
+
+func TestSearchLoggerV2_UserLocks(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDBV2()
+	// Both replicas read the words of the user before either one writes, unless locked
+	db.Faults().SetLatency("InsertOrUpdateUserSearch", 20*time.Millisecond)
+	db.Faults().SetLatency("UpdateUserSearchByWord", 20*time.Millisecond)
+	a, err := NewSearchLoggerV2WithDB(db, WithUserLocks())
+	require.NoError(t, err)
+	defer a.Close()
+	b, err := NewSearchLoggerV2WithDB(db, WithUserLocks())
+	require.NoError(t, err)
+	defer b.Close()
+
+	done := make(chan error, 2)
+	go func() { done <- a.LogSearchV2(ctx, "user_1", "bus") }()
+	go func() { done <- b.LogSearchV2(ctx, "user_1", "business") }()
+	require.NoError(t, <-done)
+	require.NoError(t, <-done)
+
+	// Whichever came first, the searches consolidate into one record
+	searches, err := db.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Len(t, searches, 1)
+
+	_, err = NewSearchLoggerV2WithDB(&countingStore{UserSearchStore: store.NewMockPostgresDBV2()}, WithUserLocks())
+	assert.Error(t, err, "Stores that cannot lock users should be rejected")
+	_, err = NewSearchLoggerV2WithDB(store.NewMockPostgresDBV2(), WithUserLocks(), WithUserCache(100))
+	assert.Error(t, err, "The user cache would be stale")
+}
+
+func TestSearchLoggerV2_AtomicConsolidation(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDBV2()
+	logger, err := NewSearchLoggerV2WithDB(db, WithAtomicConsolidation())
+	require.NoError(t, err)
+	defer logger.Close()
+	var out bytes.Buffer
+	logger.SetOutput(&out)
+
+	for _, word := range []string{"bu", "bus", "bu", "car"} {
+		require.NoError(t, logger.LogSearchV2(ctx, "user_1", word))
+	}
+	assert.Contains(t, out.String(), "(extending 'bu' to 'bus')")
+	assert.Contains(t, out.String(), "(ignoring prefix of 'bus')")
+	searches, err := logger.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"bus", "car"}, searches)
+
+	// Concurrent searches of a user are decided one at a time by the store
+	other, err := NewSearchLoggerV2WithDB(db, WithAtomicConsolidation())
+	require.NoError(t, err)
+	defer other.Close()
+	done := make(chan error, 2)
+	go func() { done <- logger.LogSearchV2(ctx, "user_2", "train") }()
+	go func() { done <- other.LogSearchV2(ctx, "user_2", "trains") }()
+	require.NoError(t, <-done)
+	require.NoError(t, <-done)
+	searches, err = db.GetUserSearches(ctx, "user_2")
+	require.NoError(t, err)
+	assert.Len(t, searches, 1)
+
+	_, err = NewSearchLoggerV2WithDB(&countingStore{UserSearchStore: store.NewMockPostgresDBV2()}, WithAtomicConsolidation())
+	assert.Error(t, err, "Stores that cannot consolidate should be rejected")
+	_, err = NewSearchLoggerV2WithDB(store.NewMockPostgresDBV2(), WithAtomicConsolidation(), WithTypoMerge(QWERTY))
+	assert.Error(t, err, "Typo merging decides from the words of the logger")
+	_, err = NewSearchLoggerV2WithDB(store.NewMockPostgresDBV2(), WithAtomicConsolidation(), WithClientClock())
+	assert.Error(t, err, "The store would consolidate late searches as new ones")
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/afanwang/logsearch"
+)
+
+// APIError is returned by Client for a response other than 2xx
+type APIError struct {
+	StatusCode int
+	// Message is the error of the response body, or its status text
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d %s", e.StatusCode, e.Message)
+}
+
+// Client calls the search API served by Handler, e.g. from operator tools
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	// actor is sent in the ActorHeader of every request unless it is empty
+	actor string
+}
+
+// NewClient creates a Client of the API at baseURL, e.g. http://localhost:8080.
+// httpClient may be nil to use http.DefaultClient.
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/"), httpClient: httpClient}
+}
+
+// SetActor names who makes the requests in the audit log of the server, e.g.
+// the operator running a tool. The server records the client address otherwise.
+func (c *Client) SetActor(actor string) {
+	c.actor = actor
+}
+
+// Top returns the limit most searched words, only those searched within window unless it is 0
+func (c *Client) Top(ctx context.Context, limit int, window time.Duration) ([]TopSearch, error) {
+	query := url.Values{"limit": {strconv.Itoa(limit)}}
+	if window > 0 {
+		query.Set("window", window.String())
+	}
+	var resp TopSearchesResponse
+	if err := c.do(ctx, http.MethodGet, "/search/top", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Searches, nil
+}
+
+// TopAudiences returns the limit words searched by the most distinct users,
+// only those searched within window unless it is 0
+func (c *Client) TopAudiences(ctx context.Context, limit int, window time.Duration) ([]TopSearch, error) {
+	query := url.Values{"limit": {strconv.Itoa(limit)}, "rank": {"users"}}
+	if window > 0 {
+		query.Set("window", window.String())
+	}
+	var resp TopSearchesResponse
+	if err := c.do(ctx, http.MethodGet, "/search/top", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Searches, nil
+}
+
+// Suggest returns the suggestions of prefix, blended with the searches of userID unless it is empty
+func (c *Client) Suggest(ctx context.Context, prefix string, limit int, userID string) ([]string, error) {
+	query := url.Values{"prefix": {prefix}, "limit": {strconv.Itoa(limit)}}
+	if userID != "" {
+		query.Set("user_id", userID)
+	}
+	var resp SuggestResponse
+	if err := c.do(ctx, http.MethodGet, "/search/suggest", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Suggestions, nil
+}
+
+// UserSearches returns the deduplicated searches of a user
+func (c *Client) UserSearches(ctx context.Context, userID string) ([]string, error) {
+	var resp UserSearchesResponse
+	if err := c.do(ctx, http.MethodGet, "/search/user", url.Values{"user_id": {userID}}, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Searches, nil
+}
+
+// DeleteUser erases all searches of a user and returns how many were deleted
+func (c *Client) DeleteUser(ctx context.Context, userID string) (int64, error) {
+	var resp DeleteUserResponse
+	if err := c.do(ctx, http.MethodDelete, "/search/user", url.Values{"user_id": {userID}}, nil, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Deleted, nil
+}
+
+// MergeWords merges the stored word from into to in both loggers
+func (c *Client) MergeWords(ctx context.Context, from, to string) error {
+	return c.do(ctx, http.MethodPost, "/search/words/merge", nil, jsonBody(CurateWordsRequest{From: from, To: to}), nil)
+}
+
+// MergeUsers merges the searches of the guest anonID into userID
+func (c *Client) MergeUsers(ctx context.Context, anonID, userID string) error {
+	return c.do(ctx, http.MethodPost, "/search/user/merge", nil, jsonBody(MergeUserRequest{AnonID: anonID, UserID: userID}), nil)
+}
+
+// Export writes the full search history of a user to w in format
+func (c *Client) Export(ctx context.Context, userID string, format logsearch.ExportFormat, w io.Writer) error {
+	query := url.Values{"user_id": {userID}, "format": {string(format)}}
+	resp, err := c.send(ctx, http.MethodGet, "/search/user/export", query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// RestoreUser writes back r, a JSON Lines export of Export, as the searches of
+// userID and returns how many were restored
+func (c *Client) RestoreUser(ctx context.Context, userID string, r io.Reader) (int, error) {
+	var resp RestoreUserResponse
+	if err := c.do(ctx, http.MethodPost, "/search/user/restore", url.Values{"user_id": {userID}}, r, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Restored, nil
+}
+
+// Import logs the searches of r, JSON Lines of LogSearchRequest, and returns how many were logged
+func (c *Client) Import(ctx context.Context, r io.Reader) (int64, error) {
+	var resp ImportResponse
+	if err := c.do(ctx, http.MethodPost, "/search/import", nil, r, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Imported, nil
+}
+
+// Purge deletes the searches last updated before cutoff from both loggers
+func (c *Client) Purge(ctx context.Context, cutoff time.Time) (PurgeResponse, error) {
+	var resp PurgeResponse
+	err := c.do(ctx, http.MethodPost, "/search/purge", url.Values{"before": {cutoff.Format(time.RFC3339)}}, nil, &resp)
+	return resp, err
+}
+
+// Audit returns the limit latest entries of the audit log, only those of actor
+// and action unless they are empty and at or after since unless it is zero
+func (c *Client) Audit(ctx context.Context, actor, action string, since time.Time, limit int) ([]AuditEntry, error) {
+	query := url.Values{"limit": {strconv.Itoa(limit)}}
+	if actor != "" {
+		query.Set("actor", actor)
+	}
+	if action != "" {
+		query.Set("action", action)
+	}
+	if !since.IsZero() {
+		query.Set("since", since.Format(time.RFC3339))
+	}
+	var resp AuditResponse
+	if err := c.do(ctx, http.MethodGet, "/search/audit", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Entries, nil
+}
+
+// do sends a request and decodes its JSON response into out unless it is nil
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body io.Reader, out any) error {
+	resp, err := c.send(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid response of %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// send sends a request, turning a response other than 2xx into an *APIError
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, body io.Reader) (*http.Response, error) {
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.actor != "" {
+		req.Header.Set(ActorHeader, c.actor)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	apiErr := &APIError{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+	var errResp ErrorResponse
+	if json.NewDecoder(resp.Body).Decode(&errResp) == nil && errResp.Error != "" {
+		apiErr.Message = errResp.Error
+	}
+	return nil, apiErr
+}
+
+// jsonBody encodes a request body, the types of this package always encode
+func jsonBody(v any) io.Reader {
+	data, _ := json.Marshal(v)
+	return bytes.NewReader(data)
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClientLogger implements every endpoint the Client calls
+type fakeClientLogger struct {
+	fakeTopLogger
+	fakeAuditor
+}
+
+func (f *fakeClientLogger) DeleteUserData(ctx context.Context, userIdentifier string) (int64, error) {
+	return (&fakeDeleteLogger{fakeLogger: f.fakeLogger}).DeleteUserData(ctx, userIdentifier)
+}
+
+func (f *fakeClientLogger) ExportUserSearches(ctx context.Context, userIdentifier string, format logsearch.ExportFormat, w io.Writer) error {
+	return (&fakeExportLogger{fakeLogger: f.fakeLogger}).ExportUserSearches(ctx, userIdentifier, format, w)
+}
+
+func TestClient(t *testing.T) {
+	logger := &fakeClientLogger{fakeTopLogger: fakeTopLogger{fakeLogger: fakeLogger{searches: map[string][]string{"user_1": {"bus", "cat"}}}}}
+	curator := &fakeCurator{stored: map[string]bool{"nyc": true}}
+	server := httptest.NewServer(NewHandler(logger, curator))
+	defer server.Close()
+	c := NewClient(server.URL+"/", nil)
+	c.SetActor("alice")
+	ctx := context.Background()
+
+	top, err := c.Top(ctx, 2, 24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, []TopSearch{{Word: "bus", Count: 3}, {Word: "cat", Count: 2}}, top)
+	assert.WithinDuration(t, time.Now().Add(-24*time.Hour), logger.since, time.Minute)
+
+	suggestions, err := c.Suggest(ctx, "b", 2, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ba", "bb"}, suggestions)
+
+	searches, err := c.UserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bus", "cat"}, searches)
+
+	var export bytes.Buffer
+	require.NoError(t, c.Export(ctx, "user_1", logsearch.ExportCSV, &export))
+	assert.Equal(t, "csv:bus\ncsv:cat\n", export.String())
+
+	imported, err := c.Import(ctx, strings.NewReader(`{"user_id":"user_2","query":"emu"}`+"\n"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), imported)
+	assert.Equal(t, []string{"emu"}, logger.searches["user_2"])
+
+	require.NoError(t, c.MergeWords(ctx, "nyc", "new york"))
+	assert.Equal(t, []string{"nyc>new york"}, curator.curated)
+
+	deleted, err := c.DeleteUser(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	entries, err := c.Audit(ctx, "", "delete-user", time.Time{}, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []AuditEntry{{ID: 2, Actor: "alice", Action: "delete-user", Target: "user_1", Before: "2 searches"}}, entries)
+
+	// The error of the response body is kept
+	err = c.MergeWords(ctx, "cat", "cats")
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	assert.Contains(t, apiErr.Message, "not found")
+
+	err = c.MergeUsers(ctx, "anon_1", "user_1")
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotImplemented, apiErr.StatusCode)
+	_, err = c.Purge(ctx, time.Now())
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotImplemented, apiErr.StatusCode)
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// HealthCheck returns an error when a component of the process is unhealthy
+type HealthCheck func(ctx context.Context) error
+
+// Pinger checks the connection to a store, implemented by both loggers
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Heartbeater reports when a background routine last completed a round,
+// implemented by both loggers
+type Heartbeater interface {
+	Heartbeat() time.Time
+}
+
+// QueueMeter reports the fill of an ingestion queue, implemented by the trie
+// based SearchLogger
+type QueueMeter interface {
+	QueueDepth() (depth, capacity int)
+}
+
+// PingCheck fails when the store of p cannot be reached
+func PingCheck(p Pinger) HealthCheck {
+	return p.Ping
+}
+
+// HeartbeatCheck fails when the heartbeat of h is older than maxAge, i.e. its
+// background routine is wedged. A zero heartbeat means no routine runs and passes.
+func HeartbeatCheck(h Heartbeater, maxAge time.Duration) HealthCheck {
+	return func(ctx context.Context) error {
+		beat := h.Heartbeat()
+		if beat.IsZero() {
+			return nil
+		}
+		if age := time.Since(beat); age > maxAge {
+			return fmt.Errorf("last heartbeat %s ago", age.Round(time.Millisecond))
+		}
+		return nil
+	}
+}
+
+// QueueCheck fails when the queue of q is filled beyond maxRatio of its
+// capacity, e.g. 0.9, so traffic goes to other instances while it drains.
+// A queue without capacity passes.
+func QueueCheck(q QueueMeter, maxRatio float64) HealthCheck {
+	return func(ctx context.Context) error {
+		depth, capacity := q.QueueDepth()
+		if capacity == 0 {
+			return nil
+		}
+		if float64(depth) > maxRatio*float64(capacity) {
+			return fmt.Errorf("queue holds %d of %d searches", depth, capacity)
+		}
+		return nil
+	}
+}
+
+// HealthResponse is the body of the liveness and readiness probes
+type HealthResponse struct {
+	// Status is "ok", or "unavailable" when a check failed
+	Status string `json:"status"`
+	// Checks maps the name of every check to "ok" or its error
+	Checks map[string]string `json:"checks"`
+}
+
+// Health serves the liveness and readiness probes of orchestrators. A failed
+// liveness probe asks for a restart of the process, e.g. with a wedged flushing
+// routine, a failed readiness probe only stops the traffic to it, e.g. while
+// the store is unreachable. Register the checks before serving the probes.
+type Health struct {
+	timeout   time.Duration
+	liveness  map[string]HealthCheck
+	readiness map[string]HealthCheck
+}
+
+// NewHealth creates a Health whose probes give up on their checks after timeout
+func NewHealth(timeout time.Duration) *Health {
+	return &Health{
+		timeout:   timeout,
+		liveness:  make(map[string]HealthCheck),
+		readiness: make(map[string]HealthCheck),
+	}
+}
+
+// AddLiveness registers a check of the liveness probe, the readiness probe runs it too
+func (h *Health) AddLiveness(name string, check HealthCheck) {
+	h.liveness[name] = check
+}
+
+// AddReadiness registers a check of the readiness probe only
+func (h *Health) AddReadiness(name string, check HealthCheck) {
+	h.readiness[name] = check
+}
+
+// LivenessHandler serves the liveness probe, mount it at /healthz
+func (h *Health) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.serve(w, r, h.liveness)
+	})
+}
+
+// ReadinessHandler serves the readiness probe, mount it at /readyz
+func (h *Health) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.serve(w, r, h.liveness, h.readiness)
+	})
+}
+
+// serve runs the checks and answers 200 when all of them passed, 503 otherwise
+func (h *Health) serve(w http.ResponseWriter, r *http.Request, groups ...map[string]HealthCheck) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeMethodNotAllowed(w, "GET, HEAD")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	resp := HealthResponse{Status: "ok", Checks: make(map[string]string)}
+	for _, checks := range groups {
+		names := make([]string, 0, len(checks))
+		for name := range checks {
+			names = append(names, name)
+		}
+		// Checks run in a stable order, so a slow one eats the timeout of the same ones
+		sort.Strings(names)
+		for _, name := range names {
+			if err := checks[name](ctx); err != nil {
+				resp.Status = "unavailable"
+				resp.Checks[name] = err.Error()
+				continue
+			}
+			resp.Checks[name] = "ok"
+		}
+	}
+
+	status := http.StatusOK
+	if resp.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, resp)
+}
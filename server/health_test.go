@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeHealth struct {
+	pingErr         error
+	heartbeat       time.Time
+	depth, capacity int
+}
+
+func (f *fakeHealth) Ping(ctx context.Context) error    { return f.pingErr }
+func (f *fakeHealth) Heartbeat() time.Time              { return f.heartbeat }
+func (f *fakeHealth) QueueDepth() (depth, capacity int) { return f.depth, f.capacity }
+
+// probe serves a probe and decodes its response
+func probe(t *testing.T, handler http.Handler) (int, HealthResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	var resp HealthResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	return rec.Code, resp
+}
+
+func TestHealthProbes(t *testing.T) {
+	fake := &fakeHealth{heartbeat: time.Now(), capacity: 10}
+	health := NewHealth(time.Second)
+	health.AddLiveness("flush", HeartbeatCheck(fake, time.Minute))
+	health.AddReadiness("store", PingCheck(fake))
+	health.AddReadiness("queue", QueueCheck(fake, 0.8))
+
+	code, resp := probe(t, health.ReadinessHandler())
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, HealthResponse{Status: "ok", Checks: map[string]string{"flush": "ok", "store": "ok", "queue": "ok"}}, resp)
+
+	// An unreachable store or a full queue only fail the readiness probe
+	fake.pingErr = errors.New("connection refused")
+	fake.depth = 9
+	code, resp = probe(t, health.ReadinessHandler())
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "unavailable", resp.Status)
+	assert.Equal(t, "connection refused", resp.Checks["store"])
+	assert.Equal(t, "queue holds 9 of 10 searches", resp.Checks["queue"])
+	code, resp = probe(t, health.LivenessHandler())
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]string{"flush": "ok"}, resp.Checks)
+
+	// A wedged routine fails both
+	fake.heartbeat = time.Now().Add(-time.Hour)
+	code, resp = probe(t, health.LivenessHandler())
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, resp.Checks["flush"], "last heartbeat")
+
+	rec := httptest.NewRecorder()
+	health.LivenessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestHealthChecksWithoutRoutineOrQueue(t *testing.T) {
+	fake := &fakeHealth{}
+	assert.NoError(t, HeartbeatCheck(fake, time.Millisecond)(context.Background()))
+	assert.NoError(t, QueueCheck(fake, 0)(context.Background()))
+}
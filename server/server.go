@@ -0,0 +1,2024 @@
+// Package server exposes the search loggers over HTTP.
+//
+// It only depends on small interfaces so it can be wired to SearchLoggerV2 for
+// per-user logging and to the trie based SearchLogger for autocomplete.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/spell"
+	"github.com/afanwang/logsearch/store"
+)
+
+const (
+	defaultSuggestLimit = 10
+	maxSuggestLimit     = 100
+	defaultTopLimit     = 10
+	maxTopLimit         = 100
+	defaultTrendWindow  = time.Hour
+	importBatchSize     = 500
+	maxAuditLimit       = 1000
+)
+
+// ActorHeader names who makes an administrative request, recorded in the audit log
+const ActorHeader = "X-Actor"
+
+// IdempotencyKeyHeader identifies a POST /search/log request, its retries carrying the same key are logged once
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// UserSearchLogger logs and returns per-user searches, implemented by SearchLoggerV2
+type UserSearchLogger interface {
+	LogSearchV2(ctx context.Context, userIdentifier, word string) error
+	GetUserSearches(ctx context.Context, userIdentifier string) ([]string, error)
+}
+
+// Suggester returns autocomplete suggestions for a prefix, implemented by the trie based SearchLogger
+type Suggester interface {
+	Suggest(prefix string, limit int) ([]string, error)
+}
+
+// CategorySearcher suggests and ranks the words searched in a category,
+// implemented by the trie based SearchLogger with WithCategories
+type CategorySearcher interface {
+	SuggestInCategory(prefix, category string, limit int) ([]string, error)
+	GetTopSearchesInCategory(ctx context.Context, category string, since time.Time, limit int) ([]store.WordCount, error)
+}
+
+// WordModerator reviews the stored words so that only verified ones are
+// suggested, implemented by the trie based SearchLogger
+type WordModerator interface {
+	SuggestVerified(prefix string, limit int) ([]string, error)
+	// SetVerified returns whether the word was verified before
+	SetVerified(ctx context.Context, word string, verified bool) (bool, error)
+	ListUnverified(ctx context.Context, limit int) ([]store.WordCount, error)
+}
+
+// WordCurator renames and merges stored words, implemented by both loggers.
+// The logger records its curation in its audit log, with the actor of
+// logsearch.ActorFrom.
+type WordCurator interface {
+	RenameWord(ctx context.Context, oldWord, newWord string) error
+	MergeWords(ctx context.Context, from, to string) error
+}
+
+// SearchCounter returns the search count of a stored word, implemented by
+// SearchLogger, whose curation the handler audits
+type SearchCounter interface {
+	SearchCount(ctx context.Context, word string) (int, error)
+}
+
+// StoredSearchQuerier filters, orders and pages the stored words, implemented by SearchLogger
+type StoredSearchQuerier interface {
+	QueryStoredSearches(ctx context.Context, query store.SearchQuery) ([]string, error)
+}
+
+// PersonalSuggester blends a user's own searches into the suggestions,
+// implemented by SearchLoggerV2
+type PersonalSuggester interface {
+	SuggestForUser(ctx context.Context, userIdentifier, prefix string, limit int) ([]string, error)
+}
+
+// TopSearcher ranks the most searched words, implemented by both loggers
+type TopSearcher interface {
+	GetTopSearchesSince(ctx context.Context, since time.Time, limit int) ([]store.WordCount, error)
+}
+
+// AudienceRanker ranks the words searched by the most distinct users, implemented by SearchLoggerV2 with WithAudience
+type AudienceRanker interface {
+	GetTopAudiences(ctx context.Context, since time.Time, limit int) ([]store.WordCount, error)
+}
+
+// RegionRanker ranks the words searched in a country or a locale, implemented by SearchLoggerV2 with WithRegions
+type RegionRanker interface {
+	GetTopSearchesByRegion(ctx context.Context, query store.RegionQuery) ([]store.WordCount, error)
+}
+
+// TrendingSearcher ranks the words searched the most recently, implemented by both loggers with WithTrending
+type TrendingSearcher interface {
+	GetTrending(window time.Duration, limit int) ([]store.WordCount, error)
+}
+
+// SpellCorrector proposes the popular form of a misspelled word, implemented by SearchLoggerV2 with WithSpellCorrection
+type SpellCorrector interface {
+	DidYouMean(word string) (spell.Suggestion, bool, error)
+}
+
+// TermSearcher finds the stored words containing a substring, implemented by SearchLoggerV2 with WithSubstringSearch
+type TermSearcher interface {
+	GetTermsContaining(substring string, limit int) ([]store.WordCount, error)
+}
+
+// SearchHistogrammer aggregates the searches over time, implemented by SearchLoggerV2
+type SearchHistogrammer interface {
+	GetSearchHistogram(ctx context.Context, from, to time.Time, granularity store.Granularity) ([]store.SearchBucket, error)
+}
+
+// SearchProfiler counts the searches of a word by hour of day and day of week, implemented by SearchLoggerV2
+type SearchProfiler interface {
+	GetSearchProfile(ctx context.Context, word string, from, to time.Time, loc *time.Location) (logsearch.SearchProfile, error)
+}
+
+// RollupReader reads the hourly and daily rollups, implemented by SearchLoggerV2 with WithRollups
+type RollupReader interface {
+	GetRollups(ctx context.Context, from, to time.Time, granularity store.Granularity) ([]store.SearchRollup, error)
+}
+
+// SearchFinalizer stores a word its user is done typing without waiting for the finalization policy, implemented by SearchLoggerV2
+type SearchFinalizer interface {
+	Finalize(ctx context.Context, userIdentifier, word string) error
+}
+
+// SearchClickLogger records the clicks on the results of the searches, implemented by SearchLoggerV2
+type SearchClickLogger interface {
+	LogSearchClick(ctx context.Context, userIdentifier, word, resultID string) error
+}
+
+// SynonymManager manages the aliases of the words, implemented by
+// SearchLoggerV2, which records the changes in its audit log with the actor of
+// logsearch.ActorFrom
+type SynonymManager interface {
+	AddSynonym(ctx context.Context, alias, canonical string) error
+	RemoveSynonym(ctx context.Context, alias string) error
+	GetSynonyms(ctx context.Context) ([]store.Synonym, error)
+}
+
+// ZeroResultLister lists the words whose submitted searches return no result, implemented by SearchLoggerV2
+type ZeroResultLister interface {
+	GetZeroResultTerms(ctx context.Context, limit int) ([]store.ZeroResultTerm, error)
+}
+
+// QuarantineLister lists the quarantined searches, implemented by SearchLoggerV2 with WithAbuseDetection or WithQuarantineFilters
+type QuarantineLister interface {
+	GetQuarantinedSearches(ctx context.Context, userIdentifier string) ([]store.QuarantinedSearch, error)
+}
+
+// QuarantineReviewer approves or purges the quarantined searches, implemented by SearchLoggerV2
+type QuarantineReviewer interface {
+	ApproveQuarantinedSearches(ctx context.Context, ids []int64) (int, error)
+	PurgeQuarantinedSearches(ctx context.Context, ids []int64) (int, error)
+}
+
+// UserDataDeleter erases all data of a user, implemented by SearchLoggerV2
+type UserDataDeleter interface {
+	DeleteUserData(ctx context.Context, userIdentifier string) (int64, error)
+}
+
+// UserSearchExporter streams the full search history of a user, implemented by SearchLoggerV2
+type UserSearchExporter interface {
+	ExportUserSearches(ctx context.Context, userIdentifier string, format logsearch.ExportFormat, w io.Writer) error
+}
+
+// UserSearchRestorer writes an export of a user back, implemented by SearchLoggerV2
+type UserSearchRestorer interface {
+	RestoreUserSearches(ctx context.Context, userIdentifier string, searches []logsearch.ExportedSearch) (int, error)
+}
+
+// IdentityMerger stitches the history of a guest into a logged-in user, implemented by SearchLoggerV2
+type IdentityMerger interface {
+	MergeIdentities(ctx context.Context, anonID, userID string) error
+}
+
+// SearchEventLogger logs a search with the idempotency key of its request, implemented by SearchLoggerV2
+type SearchEventLogger interface {
+	LogSearchEvent(ctx context.Context, event logsearch.SearchEvent) error
+}
+
+// BatchSearchLogger logs many searches at once, implemented by both loggers
+type BatchSearchLogger interface {
+	LogSearchBatch(ctx context.Context, events []logsearch.SearchEvent) error
+}
+
+// SearchPurger deletes the searches not repeated since a cutoff, implemented by both loggers
+type SearchPurger interface {
+	Purge(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// AuditLogger records the administrative mutations and lists them, implemented by SearchLoggerV2
+type AuditLogger interface {
+	RecordAudit(ctx context.Context, entry store.AuditEntry) (int64, error)
+	AuditLog(ctx context.Context, query store.AuditQuery) ([]store.AuditEntry, error)
+}
+
+// LogSearchRequest is the body of POST /search/log, and a line of POST /search/import
+type LogSearchRequest struct {
+	// UserID is the user_id for logged-in users or the anon_id for guests
+	UserID string `json:"user_id"`
+	Query  string `json:"query"`
+	// IdempotencyKey identifies the search, its retries are logged once, POST /search/log also takes it from IdempotencyKeyHeader
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// ClientTime and Sequence are when the client sent the search and its number among the searches of the user, both optional
+	ClientTime time.Time `json:"client_time,omitempty"`
+	Sequence   uint64    `json:"sequence,omitempty"`
+	// Category is the search box the search was typed in, e.g. products, optional
+	Category string `json:"category,omitempty"`
+	// Country and Locale are where the search was made, e.g. FR and fr-FR, both optional
+	Country string `json:"country,omitempty"`
+	Locale  string `json:"locale,omitempty"`
+	// Submitted marks a search the user submitted rather than typed, optional
+	Submitted bool `json:"submitted,omitempty"`
+	// ResultCount is how many results a submitted search returned, optional
+	ResultCount *int `json:"result_count,omitempty"`
+}
+
+// StatusResponse is returned by POST /search/log
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+// ImportResponse is returned by POST /search/import
+type ImportResponse struct {
+	Imported int64 `json:"imported"`
+}
+
+// PurgeResponse is returned by POST /search/purge
+type PurgeResponse struct {
+	Before time.Time `json:"before"`
+	// UserSearches is how many per-user records the logger deleted
+	UserSearches int64 `json:"user_searches"`
+	// Words is how many words the suggester deleted
+	Words int64 `json:"words"`
+}
+
+// UserSearchesResponse is returned by GET /search/user
+type UserSearchesResponse struct {
+	UserID   string   `json:"user_id"`
+	Searches []string `json:"searches"`
+}
+
+// FinalizeRequest is the body of POST /search/finalize
+type FinalizeRequest struct {
+	UserID string `json:"user_id"`
+	Query  string `json:"query"`
+}
+
+// ClickRequest is the body of POST /search/click
+type ClickRequest struct {
+	UserID string `json:"user_id"`
+	Query  string `json:"query"`
+	// ResultID identifies the clicked result, e.g. a product ID, optional
+	ResultID string `json:"result_id,omitempty"`
+}
+
+// MergeUserRequest is the body of POST /search/user/merge
+type MergeUserRequest struct {
+	// AnonID is the anon_id the guest searched as before logging in as UserID
+	AnonID string `json:"anon_id"`
+	UserID string `json:"user_id"`
+}
+
+// CurateWordsRequest is the body of POST /search/words/rename and POST /search/words/merge
+type CurateWordsRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// DeleteUserResponse is returned by DELETE /search/user
+type DeleteUserResponse struct {
+	UserID  string `json:"user_id"`
+	Deleted int64  `json:"deleted"`
+}
+
+// RestoreUserResponse is returned by POST /search/user/restore
+type RestoreUserResponse struct {
+	UserID   string `json:"user_id"`
+	Restored int    `json:"restored"`
+}
+
+// SuggestResponse is returned by GET /search/suggest
+type SuggestResponse struct {
+	Prefix      string   `json:"prefix"`
+	Suggestions []string `json:"suggestions"`
+}
+
+// DidYouMeanResponse is returned by GET /search/didyoumean, the correction
+// fields are omitted when no more popular word is close enough
+type DidYouMeanResponse struct {
+	Word       string `json:"word"`
+	Suggestion string `json:"suggestion,omitempty"`
+	Count      int64  `json:"count,omitempty"`
+	Distance   int    `json:"distance,omitempty"`
+}
+
+// TopSearch is a word and how many times it was searched
+type TopSearch struct {
+	Word  string `json:"word"`
+	Count int    `json:"count"`
+	// Score is the decayed count of loggers ranking by decay, see trie.WithDecay
+	Score float64 `json:"score,omitempty"`
+	// Users is the approximate number of distinct users who searched the word, set by rank=users
+	Users int `json:"users,omitempty"`
+}
+
+// TopSearchesResponse is returned by GET /search/top, GET /search/trending, GET /search/unverified and GET /search/terms
+type TopSearchesResponse struct {
+	// Window is the requested time window, empty for all time
+	Window   string      `json:"window,omitempty"`
+	Searches []TopSearch `json:"searches"`
+}
+
+// StoredSearchesResponse is returned by GET /search/stored
+type StoredSearchesResponse struct {
+	Searches []string `json:"searches"`
+	// NextOffset is the offset of the next page, omitted on the last page
+	NextOffset int `json:"next_offset,omitempty"`
+}
+
+// SearchBucket counts the searches of an hour or a day
+type SearchBucket struct {
+	Start       time.Time `json:"start"`
+	UniqueWords int       `json:"unique_words"`
+	Searches    int       `json:"searches"`
+}
+
+// SearchHistogramResponse is returned by GET /search/histogram
+type SearchHistogramResponse struct {
+	From        time.Time      `json:"from"`
+	To          time.Time      `json:"to"`
+	Granularity string         `json:"granularity"`
+	Buckets     []SearchBucket `json:"buckets"`
+}
+
+// SearchRollup is a row of the rollup of an hour or a day
+type SearchRollup struct {
+	Word        string    `json:"word"`
+	Bucket      time.Time `json:"bucket"`
+	TotalCount  int       `json:"total_count"`
+	UniqueUsers int       `json:"unique_users"`
+}
+
+// RollupsResponse is returned by GET /search/rollups
+type RollupsResponse struct {
+	From        time.Time      `json:"from"`
+	To          time.Time      `json:"to"`
+	Granularity string         `json:"granularity"`
+	Rollups     []SearchRollup `json:"rollups"`
+}
+
+// SearchProfileResponse is returned by GET /search/profile
+type SearchProfileResponse struct {
+	// Word is the profiled word, omitted for every word
+	Word     string    `json:"word,omitempty"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Timezone string    `json:"timezone"`
+	// Hours counts the searches of every hour of day, from midnight
+	Hours []int `json:"hours"`
+	// Weekdays counts the searches of every day of week, from Sunday
+	Weekdays []int `json:"weekdays"`
+	Searches int   `json:"searches"`
+}
+
+// QuarantinedSearch is a search quarantined instead of logged
+type QuarantinedSearch struct {
+	ID         int64     `json:"id"`
+	UserID     string    `json:"user_id"`
+	Word       string    `json:"word"`
+	Reason     string    `json:"reason"`
+	SearchedAt time.Time `json:"searched_at"`
+}
+
+// Synonym is an alias of a canonical word
+type Synonym struct {
+	Alias     string `json:"alias"`
+	Canonical string `json:"canonical"`
+}
+
+// SynonymsResponse is returned by GET /search/synonyms, in alphabetical order of the aliases
+type SynonymsResponse struct {
+	Synonyms []Synonym `json:"synonyms"`
+}
+
+// ZeroResultTerm is a word whose latest submitted searches returned no result
+type ZeroResultTerm struct {
+	Word        string    `json:"word"`
+	Streak      int       `json:"streak"`
+	FirstZeroAt time.Time `json:"first_zero_at"`
+	LastZeroAt  time.Time `json:"last_zero_at"`
+}
+
+// ZeroResultsResponse is returned by GET /search/zero-results, longest streaks first
+type ZeroResultsResponse struct {
+	Terms []ZeroResultTerm `json:"terms"`
+}
+
+// QuarantineResponse is returned by GET /search/quarantine, oldest searches first
+type QuarantineResponse struct {
+	Searches []QuarantinedSearch `json:"searches"`
+}
+
+// QuarantineReviewRequest is the body of POST /search/quarantine/approve and POST /search/quarantine/purge
+type QuarantineReviewRequest struct {
+	IDs []int64 `json:"ids"`
+}
+
+// QuarantineReviewResponse is returned by POST /search/quarantine/approve and POST /search/quarantine/purge
+type QuarantineReviewResponse struct {
+	// Searches is the number of searches approved or purged, the ids not quarantined are skipped
+	Searches int `json:"searches"`
+}
+
+// AuditEntry is an administrative mutation of the audit log
+type AuditEntry struct {
+	ID     int64     `json:"id"`
+	At     time.Time `json:"at"`
+	Actor  string    `json:"actor"`
+	Action string    `json:"action"`
+	Target string    `json:"target,omitempty"`
+	Before string    `json:"before,omitempty"`
+	After  string    `json:"after,omitempty"`
+}
+
+// AuditResponse is returned by GET /search/audit, newest entries first
+type AuditResponse struct {
+	Entries []AuditEntry `json:"entries"`
+}
+
+// ErrorResponse is returned on every failed request
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Handler serves the search API
+type Handler struct {
+	logger    UserSearchLogger
+	suggester Suggester
+	// personal is the logger when it implements PersonalSuggester, nil otherwise
+	personal PersonalSuggester
+	// top is the logger when it implements TopSearcher, nil otherwise
+	top TopSearcher
+	// audiences is the logger when it implements AudienceRanker, nil otherwise
+	audiences AudienceRanker
+	// regions is the logger when it implements RegionRanker, nil otherwise
+	regions RegionRanker
+	// trending is the logger when it implements TrendingSearcher, nil otherwise
+	trending TrendingSearcher
+	// speller is the logger when it implements SpellCorrector, nil otherwise
+	speller SpellCorrector
+	// terms is the logger when it implements TermSearcher, nil otherwise
+	terms TermSearcher
+	// histogrammer is the logger when it implements SearchHistogrammer, nil otherwise
+	histogrammer SearchHistogrammer
+	// profiler is the logger when it implements SearchProfiler, nil otherwise
+	profiler SearchProfiler
+	// rollups is the logger when it implements RollupReader, nil otherwise
+	rollups RollupReader
+	// finalizer is the logger when it implements SearchFinalizer, nil otherwise
+	finalizer SearchFinalizer
+	// clicks is the logger when it implements SearchClickLogger, nil otherwise
+	clicks SearchClickLogger
+	// synonyms is the logger when it implements SynonymManager, nil otherwise
+	synonyms SynonymManager
+	// zeroResults is the logger when it implements ZeroResultLister, nil otherwise
+	zeroResults ZeroResultLister
+	// quarantine is the logger when it implements QuarantineLister, nil otherwise
+	quarantine QuarantineLister
+	// reviewer is the logger when it implements QuarantineReviewer, nil otherwise
+	reviewer QuarantineReviewer
+	// deleter is the logger when it implements UserDataDeleter, nil otherwise
+	deleter UserDataDeleter
+	// exporter is the logger when it implements UserSearchExporter, nil otherwise
+	exporter UserSearchExporter
+	// restorer is the logger when it implements UserSearchRestorer, nil otherwise
+	restorer UserSearchRestorer
+	// merger is the logger when it implements IdentityMerger, nil otherwise
+	merger IdentityMerger
+	// events is the logger when it implements SearchEventLogger, nil otherwise
+	events SearchEventLogger
+	// batcher is the logger when it implements BatchSearchLogger, nil otherwise
+	batcher BatchSearchLogger
+	// userPurger is the logger and wordPurger the suggester when they implement SearchPurger, nil otherwise
+	userPurger SearchPurger
+	wordPurger SearchPurger
+	// categories is the suggester when it implements CategorySearcher, nil otherwise
+	categories CategorySearcher
+	// moderator is the suggester when it implements WordModerator, nil otherwise
+	moderator WordModerator
+	// querier is the suggester when it implements StoredSearchQuerier, nil otherwise
+	querier StoredSearchQuerier
+	// auditor is the logger when it implements AuditLogger, nil otherwise
+	auditor AuditLogger
+	// curators are the logger and the suggester implementing WordCurator
+	curators []WordCurator
+	mux      *http.ServeMux
+}
+
+// NewHandler creates the API handler, suggester may be nil in which case
+// /search/suggest answers 501 Not Implemented. /search/top does the same
+// unless logger implements TopSearcher.
+func NewHandler(logger UserSearchLogger, suggester Suggester) *Handler {
+	h := &Handler{
+		logger:    logger,
+		suggester: suggester,
+		mux:       http.NewServeMux(),
+	}
+	h.personal, _ = logger.(PersonalSuggester)
+	h.top, _ = logger.(TopSearcher)
+	h.audiences, _ = logger.(AudienceRanker)
+	h.regions, _ = logger.(RegionRanker)
+	h.trending, _ = logger.(TrendingSearcher)
+	h.speller, _ = logger.(SpellCorrector)
+	h.terms, _ = logger.(TermSearcher)
+	h.histogrammer, _ = logger.(SearchHistogrammer)
+	h.profiler, _ = logger.(SearchProfiler)
+	h.rollups, _ = logger.(RollupReader)
+	h.finalizer, _ = logger.(SearchFinalizer)
+	h.clicks, _ = logger.(SearchClickLogger)
+	h.synonyms, _ = logger.(SynonymManager)
+	h.zeroResults, _ = logger.(ZeroResultLister)
+	h.quarantine, _ = logger.(QuarantineLister)
+	h.reviewer, _ = logger.(QuarantineReviewer)
+	h.deleter, _ = logger.(UserDataDeleter)
+	h.exporter, _ = logger.(UserSearchExporter)
+	h.restorer, _ = logger.(UserSearchRestorer)
+	h.merger, _ = logger.(IdentityMerger)
+	h.events, _ = logger.(SearchEventLogger)
+	h.batcher, _ = logger.(BatchSearchLogger)
+	h.userPurger, _ = logger.(SearchPurger)
+	h.wordPurger, _ = suggester.(SearchPurger)
+	h.categories, _ = suggester.(CategorySearcher)
+	h.moderator, _ = suggester.(WordModerator)
+	h.querier, _ = suggester.(StoredSearchQuerier)
+	h.auditor, _ = logger.(AuditLogger)
+	// The suggester first, its rename may be refused before the logger renamed
+	for _, candidate := range []any{suggester, logger} {
+		if curator, ok := candidate.(WordCurator); ok {
+			h.curators = append(h.curators, curator)
+		}
+	}
+
+	h.mux.HandleFunc("/search/log", h.handleLog)
+	h.mux.HandleFunc("/search/finalize", h.handleFinalize)
+	h.mux.HandleFunc("/search/click", h.handleClick)
+	h.mux.HandleFunc("/search/import", h.handleImport)
+	h.mux.HandleFunc("/search/purge", h.handlePurge)
+	h.mux.HandleFunc("/search/user", h.handleUserSearches)
+	h.mux.HandleFunc("/search/user/export", h.handleExport)
+	h.mux.HandleFunc("/search/user/restore", h.handleRestore)
+	h.mux.HandleFunc("/search/user/merge", h.handleMerge)
+	h.mux.HandleFunc("/search/suggest", h.handleSuggest)
+	h.mux.HandleFunc("/search/top", h.handleTop)
+	h.mux.HandleFunc("/search/trending", h.handleTrending)
+	h.mux.HandleFunc("/search/didyoumean", h.handleDidYouMean)
+	h.mux.HandleFunc("/search/terms", h.handleTerms)
+	h.mux.HandleFunc("/search/histogram", h.handleHistogram)
+	h.mux.HandleFunc("/search/rollups", h.handleRollups)
+	h.mux.HandleFunc("/search/profile", h.handleProfile)
+	h.mux.HandleFunc("/search/zero-results", h.handleZeroResults)
+	h.mux.HandleFunc("/search/synonyms", h.handleSynonyms)
+	h.mux.HandleFunc("/search/quarantine", h.handleQuarantine)
+	h.mux.HandleFunc("/search/quarantine/approve", h.handleReview)
+	h.mux.HandleFunc("/search/quarantine/purge", h.handleReview)
+	h.mux.HandleFunc("/search/stored", h.handleStored)
+	h.mux.HandleFunc("/search/verified", h.handleVerified)
+	h.mux.HandleFunc("/search/unverified", h.handleUnverified)
+	h.mux.HandleFunc("/search/words/rename", h.handleCurate)
+	h.mux.HandleFunc("/search/words/merge", h.handleCurate)
+	h.mux.HandleFunc("/search/audit", h.handleAudit)
+
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// handleLog handles POST /search/log
+func (h *Handler) handleLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var req LogSearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	if strings.TrimSpace(req.UserID) == "" || strings.TrimSpace(req.Query) == "" {
+		writeError(w, http.StatusBadRequest, "user_id and query are required")
+		return
+	}
+
+	if req.IdempotencyKey == "" {
+		req.IdempotencyKey = r.Header.Get(IdempotencyKeyHeader)
+	}
+	if err := h.logEvent(r.Context(), req.event()); err != nil {
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, StatusResponse{Status: "ok"})
+}
+
+// handleFinalize handles POST /search/finalize, the signal of a client that
+// the user is done typing the query, e.g. when the search box loses focus
+func (h *Handler) handleFinalize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	if h.finalizer == nil {
+		writeError(w, http.StatusNotImplemented, "finalizing searches is not enabled")
+		return
+	}
+
+	var req FinalizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	if strings.TrimSpace(req.UserID) == "" || strings.TrimSpace(req.Query) == "" {
+		writeError(w, http.StatusBadRequest, "user_id and query are required")
+		return
+	}
+
+	if err := h.finalizer.Finalize(r.Context(), req.UserID, req.Query); err != nil {
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, StatusResponse{Status: "ok"})
+}
+
+// handleClick handles POST /search/click, a click of the user on a result of their search
+func (h *Handler) handleClick(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	if h.clicks == nil {
+		writeError(w, http.StatusNotImplemented, "click tracking is not enabled")
+		return
+	}
+
+	var req ClickRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	if strings.TrimSpace(req.UserID) == "" || strings.TrimSpace(req.Query) == "" {
+		writeError(w, http.StatusBadRequest, "user_id and query are required")
+		return
+	}
+
+	if err := h.clicks.LogSearchClick(r.Context(), req.UserID, req.Query, req.ResultID); err != nil {
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, StatusResponse{Status: "ok"})
+}
+
+// handleImport handles POST /search/import, logging a JSON Lines body of
+// LogSearchRequest in batches. The batches before a bad line stay logged, the
+// error tells how many searches were imported.
+func (h *Handler) handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var imported int64
+	batch := make([]logsearch.SearchEvent, 0, importBatchSize)
+	flush := func() error {
+		if err := h.logBatch(r.Context(), batch); err != nil {
+			return err
+		}
+		imported += int64(len(batch))
+		batch = batch[:0]
+		return nil
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	for line := 1; ; line++ {
+		var req LogSearchRequest
+		err := decoder.Decode(&req)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("search %d: invalid JSON, %d searches imported", line, imported))
+			return
+		}
+		if strings.TrimSpace(req.UserID) == "" || strings.TrimSpace(req.Query) == "" {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("search %d: user_id and query are required, %d searches imported", line, imported))
+			return
+		}
+		batch = append(batch, req.event())
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				writeError(w, statusForError(err), fmt.Sprintf("%v, %d searches imported", err, imported))
+				return
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		writeError(w, statusForError(err), fmt.Sprintf("%v, %d searches imported", err, imported))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ImportResponse{Imported: imported})
+}
+
+// logBatch logs events at once when the logger can, one by one otherwise
+func (h *Handler) logBatch(ctx context.Context, events []logsearch.SearchEvent) error {
+	if h.batcher != nil {
+		return h.batcher.LogSearchBatch(ctx, events)
+	}
+	for _, event := range events {
+		if err := h.logEvent(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logEvent logs event with its idempotency key when the logger takes it, ignoring the key otherwise
+func (h *Handler) logEvent(ctx context.Context, event logsearch.SearchEvent) error {
+	if h.events != nil {
+		return h.events.LogSearchEvent(ctx, event)
+	}
+	return h.logger.LogSearchV2(ctx, event.UserIdentifier, event.Query)
+}
+
+// event returns the search of the request
+func (req LogSearchRequest) event() logsearch.SearchEvent {
+	return logsearch.SearchEvent{
+		UserIdentifier: req.UserID,
+		Query:          req.Query,
+		IdempotencyKey: req.IdempotencyKey,
+		ClientTime:     req.ClientTime,
+		Sequence:       req.Sequence,
+		Category:       req.Category,
+		Country:        req.Country,
+		Locale:         req.Locale,
+		Submitted:      req.Submitted,
+		ResultCount:    req.ResultCount,
+	}
+}
+
+// handlePurge handles POST /search/purge?before={RFC 3339}|older_than={duration},
+// deleting the searches last updated before the cutoff from the logger and the suggester
+func (h *Handler) handlePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	if h.userPurger == nil && h.wordPurger == nil {
+		writeError(w, http.StatusNotImplemented, "purging searches is not enabled")
+		return
+	}
+
+	query := r.URL.Query()
+	before, olderThan := query.Get("before"), query.Get("older_than")
+	var cutoff time.Time
+	switch {
+	case before != "" && olderThan != "":
+		writeError(w, http.StatusBadRequest, "before and older_than are exclusive")
+		return
+	case before != "":
+		var err error
+		if cutoff, err = time.Parse(time.RFC3339, before); err != nil {
+			writeError(w, http.StatusBadRequest, "before must be an RFC 3339 time")
+			return
+		}
+	case olderThan != "":
+		d, err := time.ParseDuration(olderThan)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "older_than must be a positive duration such as 2160h")
+			return
+		}
+		cutoff = time.Now().Add(-d)
+	default:
+		// Purging everything is never what a caller forgetting the cutoff meant
+		writeError(w, http.StatusBadRequest, "before or older_than is required")
+		return
+	}
+
+	resp := PurgeResponse{Before: cutoff.UTC()}
+	var err error
+	if h.userPurger != nil {
+		if resp.UserSearches, err = h.userPurger.Purge(r.Context(), cutoff); err != nil {
+			writeError(w, statusForError(err), err.Error())
+			return
+		}
+	}
+	if h.wordPurger != nil {
+		if resp.Words, err = h.wordPurger.Purge(r.Context(), cutoff); err != nil {
+			writeError(w, statusForError(err), err.Error())
+			return
+		}
+	}
+
+	h.audit(r, "purge", "", resp.Before.Format(time.RFC3339),
+		fmt.Sprintf("%d user searches, %d words deleted", resp.UserSearches, resp.Words))
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleUserSearches handles GET and DELETE /search/user?user_id={id}
+func (h *Handler) handleUserSearches(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		writeMethodNotAllowed(w, http.MethodGet+", "+http.MethodDelete)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		writeError(w, http.StatusBadRequest, "user_id is required")
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		h.handleDeleteUser(w, r, userID)
+		return
+	}
+
+	searches, err := h.logger.GetUserSearches(r.Context(), userID)
+	if err != nil {
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+	if searches == nil {
+		searches = []string{}
+	}
+
+	writeJSON(w, http.StatusOK, UserSearchesResponse{UserID: userID, Searches: searches})
+}
+
+// handleDeleteUser handles DELETE /search/user?user_id={id}
+func (h *Handler) handleDeleteUser(w http.ResponseWriter, r *http.Request, userID string) {
+	if h.deleter == nil {
+		writeError(w, http.StatusNotImplemented, "deleting users is not enabled")
+		return
+	}
+
+	deleted, err := h.deleter.DeleteUserData(r.Context(), userID)
+	if err != nil {
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+
+	h.audit(r, "delete-user", userID, fmt.Sprintf("%d searches", deleted), "")
+	writeJSON(w, http.StatusOK, DeleteUserResponse{UserID: userID, Deleted: deleted})
+}
+
+// handleMerge handles POST /search/user/merge
+func (h *Handler) handleMerge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	if h.merger == nil {
+		writeError(w, http.StatusNotImplemented, "merging users is not enabled")
+		return
+	}
+
+	var req MergeUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	if strings.TrimSpace(req.AnonID) == "" || strings.TrimSpace(req.UserID) == "" {
+		writeError(w, http.StatusBadRequest, "anon_id and user_id are required")
+		return
+	}
+
+	if err := h.merger.MergeIdentities(r.Context(), req.AnonID, req.UserID); err != nil {
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+
+	h.audit(r, "merge-users", req.UserID, req.AnonID, req.UserID)
+	writeJSON(w, http.StatusOK, StatusResponse{Status: "ok"})
+}
+
+// exportContentTypes maps the export formats to their media types
+var exportContentTypes = map[logsearch.ExportFormat]string{
+	logsearch.ExportJSONLines: "application/x-ndjson",
+	logsearch.ExportCSV:       "text/csv",
+}
+
+// handleExport handles GET /search/user/export?user_id={id}&format={jsonl|csv}
+func (h *Handler) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	if h.exporter == nil {
+		writeError(w, http.StatusNotImplemented, "exporting users is not enabled")
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		writeError(w, http.StatusBadRequest, "user_id is required")
+		return
+	}
+
+	rawFormat := r.URL.Query().Get("format")
+	if rawFormat == "" {
+		rawFormat = string(logsearch.ExportJSONLines)
+	}
+	format, err := logsearch.ParseExportFormat(rawFormat)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// The records are streamed, a failure midway can only be logged
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="searches.%s"`, format))
+	if err := h.exporter.ExportUserSearches(r.Context(), userID, format, w); err != nil {
+		log.Printf("Error exporting searches of %s: %v", userID, err)
+	}
+}
+
+// handleRestore handles POST /search/user/restore?user_id={id}, writing back a
+// JSON Lines export of GET /search/user/export
+func (h *Handler) handleRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	if h.restorer == nil {
+		writeError(w, http.StatusNotImplemented, "restoring users is not enabled")
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		writeError(w, http.StatusBadRequest, "user_id is required")
+		return
+	}
+
+	var searches []logsearch.ExportedSearch
+	decoder := json.NewDecoder(r.Body)
+	for line := 1; ; line++ {
+		var search logsearch.ExportedSearch
+		err := decoder.Decode(&search)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("search %d: invalid JSON", line))
+			return
+		}
+		searches = append(searches, search)
+	}
+
+	restored, err := h.restorer.RestoreUserSearches(r.Context(), userID, searches)
+	if err != nil {
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+
+	h.audit(r, "restore-user", userID, "", fmt.Sprintf("%d searches", restored))
+	writeJSON(w, http.StatusOK, RestoreUserResponse{UserID: userID, Restored: restored})
+}
+
+// handleSuggest handles GET /search/suggest?prefix={prefix}&limit={limit}&user_id={user_id}&verified={bool}&category={category},
+// blending the searches of the user into the suggestions when user_id is set,
+// only suggesting the words a moderator verified when verified is true, or the
+// words searched in category when set
+func (h *Handler) handleSuggest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	userID := strings.TrimSpace(r.URL.Query().Get("user_id"))
+	verified := false
+	if raw := r.URL.Query().Get("verified"); raw != "" {
+		var err error
+		if verified, err = strconv.ParseBool(raw); err != nil {
+			writeError(w, http.StatusBadRequest, "verified must be a boolean")
+			return
+		}
+	}
+	category := strings.TrimSpace(r.URL.Query().Get("category"))
+	if verified && userID != "" {
+		writeError(w, http.StatusBadRequest, "verified suggestions are not personalized, drop user_id")
+		return
+	}
+	if category != "" && (verified || userID != "") {
+		writeError(w, http.StatusBadRequest, "category suggestions are neither verified nor personalized, drop verified and user_id")
+		return
+	}
+	if category != "" && h.categories == nil {
+		writeError(w, http.StatusNotImplemented, "categories are not enabled")
+		return
+	}
+	if verified && h.moderator == nil {
+		writeError(w, http.StatusNotImplemented, "verified suggestions are not enabled")
+		return
+	}
+	if userID != "" && h.personal == nil {
+		writeError(w, http.StatusNotImplemented, "personalized suggestions are not enabled")
+		return
+	}
+	if userID == "" && h.suggester == nil {
+		writeError(w, http.StatusNotImplemented, "suggestions are not enabled")
+		return
+	}
+
+	prefix := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("prefix")))
+	if prefix == "" {
+		writeError(w, http.StatusBadRequest, "prefix is required")
+		return
+	}
+
+	limit, err := parseLimit(r.URL.Query().Get("limit"), defaultSuggestLimit, maxSuggestLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var suggestions []string
+	if userID != "" {
+		suggestions, err = h.personal.SuggestForUser(r.Context(), userID, prefix, limit)
+		if err != nil {
+			writeError(w, statusForError(err), err.Error())
+			return
+		}
+	} else if category != "" {
+		suggestions, err = h.categories.SuggestInCategory(prefix, category, limit)
+		if err != nil {
+			writeError(w, statusForError(err), err.Error())
+			return
+		}
+	} else if verified {
+		suggestions, err = h.moderator.SuggestVerified(prefix, limit)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	} else {
+		suggestions, err = h.suggester.Suggest(prefix, limit)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if suggestions == nil {
+		suggestions = []string{}
+	}
+
+	writeJSON(w, http.StatusOK, SuggestResponse{Prefix: prefix, Suggestions: suggestions})
+}
+
+// handleTop handles GET /search/top?limit={limit}&window={duration}&rank={searches|users}&category={category}&country={country}&locale={locale},
+// ranking by searches by default or by the distinct users who searched the
+// words, only the words searched in category, or last searched in country
+// and locale, when set
+func (h *Handler) handleTop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	rank := r.URL.Query().Get("rank")
+	category := strings.TrimSpace(r.URL.Query().Get("category"))
+	region := store.RegionQuery{
+		Country: strings.TrimSpace(r.URL.Query().Get("country")),
+		Locale:  strings.TrimSpace(r.URL.Query().Get("locale")),
+	}
+	regional := region.Country != "" || region.Locale != ""
+	switch {
+	case rank != "" && rank != "searches" && rank != "users":
+		writeError(w, http.StatusBadRequest, "rank must be searches or users")
+		return
+	case regional && (rank == "users" || category != ""):
+		writeError(w, http.StatusBadRequest, "regions are ranked by searches over all categories, drop rank=users and category")
+		return
+	case regional && h.regions == nil:
+		writeError(w, http.StatusNotImplemented, "regions are not enabled")
+		return
+	case category != "" && rank == "users":
+		writeError(w, http.StatusBadRequest, "categories are ranked by searches, drop rank=users")
+		return
+	case category != "" && h.categories == nil:
+		writeError(w, http.StatusNotImplemented, "categories are not enabled")
+		return
+	case rank == "users" && h.audiences == nil:
+		writeError(w, http.StatusNotImplemented, "audiences are not enabled")
+		return
+	case !regional && category == "" && rank != "users" && h.top == nil:
+		writeError(w, http.StatusNotImplemented, "top searches are not enabled")
+		return
+	}
+
+	limit, err := parseLimit(r.URL.Query().Get("limit"), defaultTopLimit, maxTopLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// An optional window such as "1h" or "24h" only ranks recent searches
+	var since time.Time
+	window := r.URL.Query().Get("window")
+	if window != "" {
+		d, err := time.ParseDuration(window)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "window must be a positive duration such as 1h")
+			return
+		}
+		since = time.Now().Add(-d)
+	}
+
+	var top []store.WordCount
+	switch {
+	case rank == "users":
+		top, err = h.audiences.GetTopAudiences(r.Context(), since, limit)
+	case category != "":
+		top, err = h.categories.GetTopSearchesInCategory(r.Context(), category, since, limit)
+	case regional:
+		region.Since, region.Limit = since, limit
+		top, err = h.regions.GetTopSearchesByRegion(r.Context(), region)
+	default:
+		top, err = h.top.GetTopSearchesSince(r.Context(), since, limit)
+	}
+	if err != nil {
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+
+	searches := make([]TopSearch, 0, len(top))
+	for _, wordCount := range top {
+		searches = append(searches, TopSearch{Word: wordCount.Word, Count: wordCount.Count, Score: wordCount.Score, Users: wordCount.Users})
+	}
+
+	writeJSON(w, http.StatusOK, TopSearchesResponse{Window: window, Searches: searches})
+}
+
+// handleTrending handles GET /search/trending?window={duration}&limit={limit}
+func (h *Handler) handleTrending(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	if h.trending == nil {
+		writeError(w, http.StatusNotImplemented, "trending searches are not enabled")
+		return
+	}
+
+	limit, err := parseLimit(r.URL.Query().Get("limit"), defaultTopLimit, maxTopLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	window := defaultTrendWindow
+	if raw := r.URL.Query().Get("window"); raw != "" {
+		window, err = time.ParseDuration(raw)
+		if err != nil || window <= 0 {
+			writeError(w, http.StatusBadRequest, "window must be a positive duration such as 1h")
+			return
+		}
+	}
+
+	trending, err := h.trending.GetTrending(window, limit)
+	if err != nil {
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+
+	searches := make([]TopSearch, 0, len(trending))
+	for _, wordCount := range trending {
+		searches = append(searches, TopSearch{Word: wordCount.Word, Count: wordCount.Count})
+	}
+
+	writeJSON(w, http.StatusOK, TopSearchesResponse{Window: window.String(), Searches: searches})
+}
+
+// handleVerified handles PUT and DELETE /search/verified?word={word}, marking
+// a stored word as verified by a moderator or withdrawing the review
+func (h *Handler) handleVerified(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		writeMethodNotAllowed(w, http.MethodPut+", "+http.MethodDelete)
+		return
+	}
+
+	if h.moderator == nil {
+		writeError(w, http.StatusNotImplemented, "moderation is not enabled")
+		return
+	}
+
+	word := r.URL.Query().Get("word")
+	if strings.TrimSpace(word) == "" {
+		writeError(w, http.StatusBadRequest, "word is required")
+		return
+	}
+
+	verified := r.Method == http.MethodPut
+	previous, err := h.moderator.SetVerified(r.Context(), word, verified)
+	if err != nil {
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+
+	action := "verify"
+	if !verified {
+		action = "unverify"
+	}
+	h.audit(r, action, word, verifiedState(previous), verifiedState(verified))
+	writeJSON(w, http.StatusOK, StatusResponse{Status: "ok"})
+}
+
+// verifiedState names the verified flag of a word in the audit log
+func verifiedState(verified bool) string {
+	if verified {
+		return "verified"
+	}
+	return "unverified"
+}
+
+// handleUnverified handles GET /search/unverified?limit={limit}, the moderation
+// queue of the most searched words awaiting review
+func (h *Handler) handleUnverified(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	if h.moderator == nil {
+		writeError(w, http.StatusNotImplemented, "moderation is not enabled")
+		return
+	}
+
+	limit, err := parseLimit(r.URL.Query().Get("limit"), defaultTopLimit, maxTopLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	unverified, err := h.moderator.ListUnverified(r.Context(), limit)
+	if err != nil {
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+
+	searches := make([]TopSearch, 0, len(unverified))
+	for _, wordCount := range unverified {
+		searches = append(searches, TopSearch{Word: wordCount.Word, Count: wordCount.Count})
+	}
+
+	writeJSON(w, http.StatusOK, TopSearchesResponse{Searches: searches})
+}
+
+// granularities are the values of the granularity parameter of GET /search/histogram
+var granularities = map[string]store.Granularity{
+	"":     store.Hourly,
+	"hour": store.Hourly,
+	"day":  store.Daily,
+}
+
+// handleHistogram handles GET /search/histogram?from={RFC 3339}&to={RFC 3339}&granularity={hour|day},
+// the range defaulting to the last 24 hours
+func (h *Handler) handleHistogram(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	if h.histogrammer == nil {
+		writeError(w, http.StatusNotImplemented, "search analytics are not enabled")
+		return
+	}
+
+	granularity, from, to, err := parseTimeRange(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	buckets, err := h.histogrammer.GetSearchHistogram(r.Context(), from, to, granularity)
+	if err != nil {
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+
+	response := SearchHistogramResponse{From: from, To: to, Granularity: granularityName(granularity), Buckets: make([]SearchBucket, 0, len(buckets))}
+	for _, bucket := range buckets {
+		response.Buckets = append(response.Buckets, SearchBucket{Start: bucket.Start, UniqueWords: bucket.UniqueWords, Searches: bucket.Searches})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleRollups handles GET /search/rollups?from={RFC 3339}&to={RFC 3339}&granularity={hour|day},
+// the range defaulting to the last 24 hours
+func (h *Handler) handleRollups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	if h.rollups == nil {
+		writeError(w, http.StatusNotImplemented, "rollups are not enabled")
+		return
+	}
+
+	granularity, from, to, err := parseTimeRange(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rollups, err := h.rollups.GetRollups(r.Context(), from, to, granularity)
+	if err != nil {
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+
+	response := RollupsResponse{From: from, To: to, Granularity: granularityName(granularity), Rollups: make([]SearchRollup, 0, len(rollups))}
+	for _, rollup := range rollups {
+		response.Rollups = append(response.Rollups, SearchRollup{
+			Word:        rollup.Word,
+			Bucket:      rollup.Bucket,
+			TotalCount:  rollup.TotalCount,
+			UniqueUsers: rollup.UniqueUsers,
+		})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleProfile handles GET /search/profile?word={word}&from={RFC 3339}&to={RFC 3339}&tz={IANA name},
+// counting the searches of word, or of every word, by hour of day and day of
+// week in tz, UTC by default, the range defaulting to the last 7 days
+func (h *Handler) handleProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	if h.profiler == nil {
+		writeError(w, http.StatusNotImplemented, "search analytics are not enabled")
+		return
+	}
+
+	from, to, err := parseRange(r.URL.Query(), 7*24*time.Hour)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	loc, err := time.LoadLocation(r.URL.Query().Get("tz"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "tz must be an IANA time zone, e.g. Europe/Paris")
+		return
+	}
+
+	word := strings.TrimSpace(r.URL.Query().Get("word"))
+	profile, err := h.profiler.GetSearchProfile(r.Context(), word, from, to, loc)
+	if err != nil {
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SearchProfileResponse{
+		Word:     word,
+		From:     from,
+		To:       to,
+		Timezone: loc.String(),
+		Hours:    profile.Hours[:],
+		Weekdays: profile.Weekdays[:],
+		Searches: profile.Searches,
+	})
+}
+
+// handleZeroResults handles GET /search/zero-results?limit={n}, the words
+// whose submitted searches return no result
+func (h *Handler) handleZeroResults(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	if h.zeroResults == nil {
+		writeError(w, http.StatusNotImplemented, "zero-result tracking is not enabled")
+		return
+	}
+
+	limit, err := parseLimit(r.URL.Query().Get("limit"), defaultTopLimit, maxTopLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	terms, err := h.zeroResults.GetZeroResultTerms(r.Context(), limit)
+	if err != nil {
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+
+	response := ZeroResultsResponse{Terms: make([]ZeroResultTerm, 0, len(terms))}
+	for _, term := range terms {
+		response.Terms = append(response.Terms, ZeroResultTerm{
+			Word:        term.Word,
+			Streak:      term.Streak,
+			FirstZeroAt: term.FirstZeroAt,
+			LastZeroAt:  term.LastZeroAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleSynonyms handles GET /search/synonyms, listing the aliases, POST
+// /search/synonyms, adding an alias, and DELETE /search/synonyms?alias={alias},
+// removing it
+func (h *Handler) handleSynonyms(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		writeMethodNotAllowed(w, http.MethodGet+", "+http.MethodPost+", "+http.MethodDelete)
+		return
+	}
+
+	if h.synonyms == nil {
+		writeError(w, http.StatusNotImplemented, "synonyms are not enabled")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var req Synonym
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		if strings.TrimSpace(req.Alias) == "" || strings.TrimSpace(req.Canonical) == "" {
+			writeError(w, http.StatusBadRequest, "alias and canonical are required")
+			return
+		}
+		if err := h.synonyms.AddSynonym(actorContext(r), req.Alias, req.Canonical); err != nil {
+			writeError(w, statusForError(err), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, StatusResponse{Status: "ok"})
+		return
+
+	case http.MethodDelete:
+		alias := r.URL.Query().Get("alias")
+		if strings.TrimSpace(alias) == "" {
+			writeError(w, http.StatusBadRequest, "alias is required")
+			return
+		}
+		if err := h.synonyms.RemoveSynonym(actorContext(r), alias); err != nil {
+			writeError(w, statusForError(err), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, StatusResponse{Status: "ok"})
+		return
+	}
+
+	synonyms, err := h.synonyms.GetSynonyms(r.Context())
+	if err != nil {
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+
+	response := SynonymsResponse{Synonyms: make([]Synonym, 0, len(synonyms))}
+	for _, synonym := range synonyms {
+		response.Synonyms = append(response.Synonyms, Synonym{Alias: synonym.Alias, Canonical: synonym.Canonical})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleQuarantine handles GET /search/quarantine?user_id={user_id}, listing
+// the quarantined searches of the user, of every user without user_id
+func (h *Handler) handleQuarantine(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	if h.quarantine == nil {
+		writeError(w, http.StatusNotImplemented, "quarantine is not enabled")
+		return
+	}
+
+	searches, err := h.quarantine.GetQuarantinedSearches(r.Context(), strings.TrimSpace(r.URL.Query().Get("user_id")))
+	if err != nil {
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+
+	response := QuarantineResponse{Searches: make([]QuarantinedSearch, 0, len(searches))}
+	for _, search := range searches {
+		response.Searches = append(response.Searches, QuarantinedSearch{
+			ID:         search.ID,
+			UserID:     search.UserIdentifier,
+			Word:       search.Word,
+			Reason:     search.Reason,
+			SearchedAt: search.SearchedAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleReview handles POST /search/quarantine/approve, storing the
+// quarantined searches of the ids as searches of their users, and POST
+// /search/quarantine/purge, deleting them
+func (h *Handler) handleReview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	if h.reviewer == nil {
+		writeError(w, http.StatusNotImplemented, "quarantine is not enabled")
+		return
+	}
+
+	var req QuarantineReviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if len(req.IDs) == 0 {
+		writeError(w, http.StatusBadRequest, "ids are required")
+		return
+	}
+
+	review, action := h.reviewer.PurgeQuarantinedSearches, "purge-quarantine"
+	if r.URL.Path == "/search/quarantine/approve" {
+		review, action = h.reviewer.ApproveQuarantinedSearches, "approve-quarantine"
+	}
+	reviewed, err := review(r.Context(), req.IDs)
+	if reviewed > 0 {
+		// The searches reviewed before a failure stay reviewed
+		ids := make([]string, len(req.IDs))
+		for i, id := range req.IDs {
+			ids[i] = strconv.FormatInt(id, 10)
+		}
+		h.audit(r, action, strings.Join(ids, ","), "", fmt.Sprintf("%d searches", reviewed))
+	}
+	if err != nil {
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, QuarantineReviewResponse{Searches: reviewed})
+}
+
+// parseTimeRange reads the granularity, from and to parameters of GET
+// /search/histogram and GET /search/rollups, the range defaulting to the last 24 hours
+func parseTimeRange(values url.Values) (store.Granularity, time.Time, time.Time, error) {
+	granularity, ok := granularities[values.Get("granularity")]
+	if !ok {
+		return 0, time.Time{}, time.Time{}, errors.New("granularity must be hour or day")
+	}
+
+	from, to, err := parseRange(values, 24*time.Hour)
+	return granularity, from, to, err
+}
+
+// parseRange reads the from and to parameters, the range defaulting to the span before now
+func parseRange(values url.Values, span time.Duration) (time.Time, time.Time, error) {
+	to := time.Now().UTC()
+	if raw := values.Get("to"); raw != "" {
+		var err error
+		if to, err = time.Parse(time.RFC3339, raw); err != nil {
+			return time.Time{}, time.Time{}, errors.New("to must be an RFC 3339 time")
+		}
+	}
+	from := to.Add(-span)
+	if raw := values.Get("from"); raw != "" {
+		var err error
+		if from, err = time.Parse(time.RFC3339, raw); err != nil {
+			return time.Time{}, time.Time{}, errors.New("from must be an RFC 3339 time")
+		}
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, errors.New("from must be before to")
+	}
+	return from, to, nil
+}
+
+// granularityName is the granularity parameter value of a granularity
+func granularityName(granularity store.Granularity) string {
+	if granularity == store.Daily {
+		return "day"
+	}
+	return "hour"
+}
+
+// searchOrders are the values of the sort parameter of GET /search/stored
+var searchOrders = map[string]store.SearchOrder{
+	"":       store.OrderAlphabetical,
+	"alpha":  store.OrderAlphabetical,
+	"count":  store.OrderCount,
+	"recent": store.OrderRecent,
+}
+
+// handleStored handles GET /search/stored?prefix={prefix}&sort={alpha|count|recent}
+// &since={RFC 3339}&until={RFC 3339}&min_count={count}&limit={limit}&offset={offset}
+func (h *Handler) handleStored(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	if h.querier == nil {
+		writeError(w, http.StatusNotImplemented, "stored search queries are not enabled")
+		return
+	}
+
+	query, err := parseSearchQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// One more word tells whether a next page exists
+	limit := query.Limit
+	query.Limit++
+	searches, err := h.querier.QueryStoredSearches(r.Context(), query)
+	if err != nil {
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+
+	response := StoredSearchesResponse{Searches: searches}
+	if len(searches) > limit {
+		response.Searches = searches[:limit]
+		response.NextOffset = query.Offset + limit
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// parseSearchQuery reads the parameters of GET /search/stored
+func parseSearchQuery(values url.Values) (store.SearchQuery, error) {
+	query := store.SearchQuery{Prefix: values.Get("prefix")}
+
+	var err error
+	if query.Limit, err = parseLimit(values.Get("limit"), defaultTopLimit, maxTopLimit); err != nil {
+		return query, err
+	}
+
+	order, ok := searchOrders[values.Get("sort")]
+	if !ok {
+		return query, errors.New("sort must be alpha, count or recent")
+	}
+	query.Order = order
+
+	if raw := values.Get("offset"); raw != "" {
+		if query.Offset, err = strconv.Atoi(raw); err != nil || query.Offset < 0 {
+			return query, errors.New("offset must be a non-negative integer")
+		}
+	}
+	if raw := values.Get("min_count"); raw != "" {
+		if query.MinCount, err = strconv.Atoi(raw); err != nil || query.MinCount < 0 {
+			return query, errors.New("min_count must be a non-negative integer")
+		}
+	}
+	if raw := values.Get("since"); raw != "" {
+		if query.Since, err = time.Parse(time.RFC3339, raw); err != nil {
+			return query, errors.New("since must be an RFC 3339 time")
+		}
+	}
+	if raw := values.Get("until"); raw != "" {
+		if query.Until, err = time.Parse(time.RFC3339, raw); err != nil {
+			return query, errors.New("until must be an RFC 3339 time")
+		}
+	}
+	return query, nil
+}
+
+// handleCurate handles POST /search/words/rename and POST /search/words/merge,
+// applied to the logger and the suggester. A word stored by only one of them
+// is curated there, 404 means neither stored it.
+func (h *Handler) handleCurate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	if len(h.curators) == 0 {
+		writeError(w, http.StatusNotImplemented, "word curation is not enabled")
+		return
+	}
+
+	var req CurateWordsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if strings.TrimSpace(req.From) == "" || strings.TrimSpace(req.To) == "" {
+		writeError(w, http.StatusBadRequest, "from and to are required")
+		return
+	}
+
+	// The searches of the word before the curation, for the audit log
+	before := h.searchCount(r.Context(), req.From)
+	var notFound error
+	curated, recorded := false, false
+	for _, curator := range h.curators {
+		var err error
+		if r.URL.Path == "/search/words/rename" {
+			err = curator.RenameWord(actorContext(r), req.From, req.To)
+		} else {
+			err = curator.MergeWords(actorContext(r), req.From, req.To)
+		}
+		switch {
+		case errors.Is(err, logsearch.ErrWordNotFound):
+			notFound = err
+		case err != nil:
+			writeError(w, statusForError(err), err.Error())
+			return
+		default:
+			curated = true
+			// The logger records its curation with the searches it moved
+			recorded = recorded || any(curator) == any(h.logger)
+		}
+	}
+	if !curated {
+		writeError(w, http.StatusNotFound, notFound.Error())
+		return
+	}
+
+	if !recorded {
+		action := "merge-words"
+		if r.URL.Path == "/search/words/rename" {
+			action = "rename-word"
+		}
+		h.audit(r, action, req.From, before, req.To)
+	}
+	writeJSON(w, http.StatusOK, StatusResponse{Status: "ok"})
+}
+
+// searchCount returns the searches of word stored by the first curator
+// counting them, empty when none does or the word is not stored
+func (h *Handler) searchCount(ctx context.Context, word string) string {
+	for _, curator := range h.curators {
+		if counter, ok := curator.(SearchCounter); ok {
+			if count, err := counter.SearchCount(ctx, word); err == nil {
+				return fmt.Sprintf("%d searches", count)
+			}
+		}
+	}
+	return ""
+}
+
+// audit records an administrative mutation that succeeded. The mutation is
+// done, a failure to record it can only be logged.
+func (h *Handler) audit(r *http.Request, action, target, before, after string) {
+	if h.auditor == nil {
+		return
+	}
+
+	entry := store.AuditEntry{
+		Actor:  actor(r),
+		Action: action,
+		Target: target,
+		Before: before,
+		After:  after,
+	}
+	if _, err := h.auditor.RecordAudit(r.Context(), entry); err != nil {
+		log.Printf("Error recording %s of %q by %s: %v", action, target, entry.Actor, err)
+	}
+}
+
+// actorContext returns the context of the request naming its actor, for the
+// mutations the logger records in its audit log
+func actorContext(r *http.Request) context.Context {
+	return logsearch.WithActor(r.Context(), actor(r))
+}
+
+// actor returns who makes the request: the ActorHeader, or the remote address without it
+func actor(r *http.Request) string {
+	if name := strings.TrimSpace(r.Header.Get(ActorHeader)); name != "" {
+		return name
+	}
+	return r.RemoteAddr
+}
+
+// handleAudit handles GET /search/audit?actor={actor}&action={action}&since={RFC 3339}&limit={limit},
+// the audit log of the administrative mutations, newest first
+func (h *Handler) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	if h.auditor == nil {
+		writeError(w, http.StatusNotImplemented, "the audit log is not enabled")
+		return
+	}
+
+	values := r.URL.Query()
+	limit, err := parseLimit(values.Get("limit"), store.DefaultAuditLimit, maxAuditLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query := store.AuditQuery{Actor: values.Get("actor"), Action: values.Get("action"), Limit: limit}
+	if raw := values.Get("since"); raw != "" {
+		if query.Since, err = time.Parse(time.RFC3339, raw); err != nil {
+			writeError(w, http.StatusBadRequest, "since must be an RFC 3339 time")
+			return
+		}
+	}
+
+	entries, err := h.auditor.AuditLog(r.Context(), query)
+	if err != nil {
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+
+	resp := AuditResponse{Entries: make([]AuditEntry, 0, len(entries))}
+	for _, entry := range entries {
+		resp.Entries = append(resp.Entries, AuditEntry{
+			ID:     entry.ID,
+			At:     entry.At,
+			Actor:  entry.Actor,
+			Action: entry.Action,
+			Target: entry.Target,
+			Before: entry.Before,
+			After:  entry.After,
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleDidYouMean handles GET /search/didyoumean?word={word}
+func (h *Handler) handleDidYouMean(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	if h.speller == nil {
+		writeError(w, http.StatusNotImplemented, "spell correction is not enabled")
+		return
+	}
+
+	word := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("word")))
+	if word == "" {
+		writeError(w, http.StatusBadRequest, "word is required")
+		return
+	}
+
+	suggestion, ok, err := h.speller.DidYouMean(word)
+	if err != nil {
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+
+	resp := DidYouMeanResponse{Word: word}
+	if ok {
+		resp.Suggestion = suggestion.Word
+		resp.Count = suggestion.Count
+		resp.Distance = suggestion.Distance
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleTerms handles GET /search/terms?contains={substring}&limit={limit}
+func (h *Handler) handleTerms(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	if h.terms == nil {
+		writeError(w, http.StatusNotImplemented, "substring search is not enabled")
+		return
+	}
+
+	substring := r.URL.Query().Get("contains")
+	if strings.TrimSpace(substring) == "" {
+		writeError(w, http.StatusBadRequest, "contains is required")
+		return
+	}
+	limit, err := parseLimit(r.URL.Query().Get("limit"), defaultTopLimit, maxTopLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	terms, err := h.terms.GetTermsContaining(substring, limit)
+	if err != nil {
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+
+	searches := make([]TopSearch, 0, len(terms))
+	for _, wordCount := range terms {
+		searches = append(searches, TopSearch{Word: wordCount.Word, Count: wordCount.Count})
+	}
+	writeJSON(w, http.StatusOK, TopSearchesResponse{Searches: searches})
+}
+
+// parseLimit parses an optional positive limit, capping it to max
+func parseLimit(raw string, def, max int) (int, error) {
+	if raw == "" {
+		return def, nil
+	}
+
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit <= 0 {
+		return 0, errors.New("limit must be a positive integer")
+	}
+	if limit > max {
+		limit = max
+	}
+
+	return limit, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// statusForError maps the sentinel errors of the loggers to an HTTP status,
+// 503 tells clients that retrying later may succeed
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, logsearch.ErrEmptyWord), errors.Is(err, logsearch.ErrEmptyUser), errors.Is(err, logsearch.ErrWordTooLong),
+		errors.Is(err, logsearch.ErrInvalidInput), errors.Is(err, logsearch.ErrUnknownCategory):
+		return http.StatusBadRequest
+	case errors.Is(err, logsearch.ErrUserNotFound), errors.Is(err, logsearch.ErrWordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, logsearch.ErrWordExists):
+		return http.StatusConflict
+	case errors.Is(err, logsearch.ErrStoreUnavailable), errors.Is(err, logsearch.ErrQueueFull):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, ErrorResponse{Error: message})
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// Server is an http.Server running the search API with graceful shutdown
+type Server struct {
+	httpServer      *http.Server
+	shutdownTimeout time.Duration
+}
+
+// New creates a Server listening on addr
+func New(addr string, handler http.Handler) *Server {
+	return &Server{
+		httpServer: &http.Server{
+			Addr:              addr,
+			Handler:           handler,
+			ReadHeaderTimeout: 5 * time.Second,
+		},
+		shutdownTimeout: 10 * time.Second,
+	}
+}
+
+// Run serves requests until ctx is cancelled, then waits for in-flight
+// requests to finish before returning
+func (s *Server) Run(ctx context.Context) error {
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+
+	if err := s.httpServer.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+
+	if err := <-errChan; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
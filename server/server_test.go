@@ -0,0 +1,1475 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/spell"
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeLogger struct {
+	searches map[string][]string
+	err      error
+}
+
+func (f *fakeLogger) LogSearchV2(ctx context.Context, userIdentifier, word string) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.searches[userIdentifier] = append(f.searches[userIdentifier], strings.ToLower(word))
+	return nil
+}
+
+func (f *fakeLogger) GetUserSearches(ctx context.Context, userIdentifier string) ([]string, error) {
+	return f.searches[userIdentifier], nil
+}
+
+// fakePersonalLogger also suggests the searches of a user matching the prefix
+type fakePersonalLogger struct {
+	fakeLogger
+}
+
+func (f *fakePersonalLogger) SuggestForUser(ctx context.Context, userIdentifier, prefix string, limit int) ([]string, error) {
+	suggestions := []string{}
+	for _, word := range f.searches[userIdentifier] {
+		if strings.HasPrefix(word, prefix) && len(suggestions) < limit {
+			suggestions = append(suggestions, word)
+		}
+	}
+	return suggestions, nil
+}
+
+// fakeSpellLogger also corrects "bsu" to "bus"
+type fakeSpellLogger struct {
+	fakeLogger
+}
+
+func (f *fakeSpellLogger) DidYouMean(word string) (spell.Suggestion, bool, error) {
+	if word == "bsu" {
+		return spell.Suggestion{Word: "bus", Count: 3, Distance: 1}, true, nil
+	}
+	return spell.Suggestion{}, false, nil
+}
+
+// fakeTermLogger also finds the words containing a substring, recording the limit it was asked for
+type fakeTermLogger struct {
+	fakeLogger
+	limit int
+}
+
+func (f *fakeTermLogger) GetTermsContaining(substring string, limit int) ([]store.WordCount, error) {
+	f.limit = limit
+	var terms []store.WordCount
+	for _, word := range []string{"iphone case", "headphones"} {
+		if strings.Contains(word, substring) {
+			terms = append(terms, store.WordCount{Word: word, Count: 2})
+		}
+	}
+	return terms, nil
+}
+
+// fakeTopLogger also ranks searches, recording the window it was asked for
+type fakeTopLogger struct {
+	fakeLogger
+	since time.Time
+}
+
+func (f *fakeTopLogger) GetTopSearchesSince(ctx context.Context, since time.Time, limit int) ([]store.WordCount, error) {
+	f.since = since
+	top := []store.WordCount{{Word: "bus", Count: 3}, {Word: "cat", Count: 2}, {Word: "dog", Count: 1}}
+	if limit < len(top) {
+		top = top[:limit]
+	}
+	return top, nil
+}
+
+// fakeAudienceLogger also ranks the words by audience
+type fakeAudienceLogger struct {
+	fakeTopLogger
+}
+
+func (f *fakeAudienceLogger) GetTopAudiences(ctx context.Context, since time.Time, limit int) ([]store.WordCount, error) {
+	f.since = since
+	return []store.WordCount{{Word: "cat", Count: 2, Users: 2}, {Word: "bus", Count: 3, Users: 1}}, nil
+}
+
+// fakeRegionLogger also ranks the words by region, recording the query it was asked for
+type fakeRegionLogger struct {
+	fakeEventLogger
+	query store.RegionQuery
+}
+
+func (f *fakeRegionLogger) GetTopSearchesByRegion(ctx context.Context, query store.RegionQuery) ([]store.WordCount, error) {
+	f.query = query
+	return []store.WordCount{{Word: "velo", Count: 2}}, nil
+}
+
+// fakeTrendingLogger also ranks recent searches, recording the window it was asked for
+type fakeTrendingLogger struct {
+	fakeLogger
+	window time.Duration
+}
+
+func (f *fakeTrendingLogger) GetTrending(window time.Duration, limit int) ([]store.WordCount, error) {
+	f.window = window
+	return []store.WordCount{{Word: "emu", Count: 4}}, nil
+}
+
+// fakeDeleteLogger also erases users
+type fakeDeleteLogger struct {
+	fakeLogger
+}
+
+func (f *fakeDeleteLogger) DeleteUserData(ctx context.Context, userIdentifier string) (int64, error) {
+	deleted := int64(len(f.searches[userIdentifier]))
+	delete(f.searches, userIdentifier)
+	return deleted, nil
+}
+
+// fakeExportLogger also exports users, writing one line per search
+type fakeExportLogger struct {
+	fakeLogger
+}
+
+func (f *fakeExportLogger) ExportUserSearches(ctx context.Context, userIdentifier string, format logsearch.ExportFormat, w io.Writer) error {
+	for _, word := range f.searches[userIdentifier] {
+		fmt.Fprintf(w, "%s:%s\n", format, word)
+	}
+	return nil
+}
+
+// fakeRestoreLogger also restores users, appending the restored words
+type fakeRestoreLogger struct {
+	fakeLogger
+}
+
+func (f *fakeRestoreLogger) RestoreUserSearches(ctx context.Context, userIdentifier string, searches []logsearch.ExportedSearch) (int, error) {
+	for _, search := range searches {
+		f.searches[userIdentifier] = append(f.searches[userIdentifier], search.Word)
+	}
+	return len(searches), nil
+}
+
+// fakeMergeLogger also merges users, appending the searches of the guest
+type fakeMergeLogger struct {
+	fakeLogger
+}
+
+func (f *fakeMergeLogger) MergeIdentities(ctx context.Context, anonID, userID string) error {
+	f.searches[userID] = append(f.searches[userID], f.searches[anonID]...)
+	delete(f.searches, anonID)
+	return nil
+}
+
+type fakeSuggester struct{}
+
+func (fakeSuggester) Suggest(prefix string, limit int) ([]string, error) {
+	words := []string{prefix + "a", prefix + "b", prefix + "c"}
+	if limit < len(words) {
+		words = words[:limit]
+	}
+	return words, nil
+}
+
+// fakeModerator only suggests the verified words starting with the prefix
+type fakeModerator struct {
+	fakeSuggester
+	verified map[string]bool
+	counts   map[string]int
+}
+
+func (f *fakeModerator) SuggestVerified(prefix string, limit int) ([]string, error) {
+	var words []string
+	for word := range f.verified {
+		if strings.HasPrefix(word, prefix) && len(words) < limit {
+			words = append(words, word)
+		}
+	}
+	return words, nil
+}
+
+func (f *fakeModerator) SetVerified(ctx context.Context, word string, verified bool) (bool, error) {
+	if _, ok := f.counts[word]; !ok {
+		return false, fmt.Errorf("failed to set verified: %w", logsearch.ErrWordNotFound)
+	}
+	previous := f.verified[word]
+	if verified {
+		f.verified[word] = true
+	} else {
+		delete(f.verified, word)
+	}
+	return previous, nil
+}
+
+func (f *fakeModerator) ListUnverified(ctx context.Context, limit int) ([]store.WordCount, error) {
+	var counts []store.WordCount
+	for word, count := range f.counts {
+		if !f.verified[word] {
+			counts = append(counts, store.WordCount{Word: word, Count: count})
+		}
+	}
+	return counts, nil
+}
+
+func TestHandler_LogAndGetUserSearches(t *testing.T) {
+	h := NewHandler(&fakeLogger{searches: map[string][]string{}}, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/log", strings.NewReader(`{"user_id":"user_1","query":"Business"}`)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/user?user_id=user_1", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp UserSearchesResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, UserSearchesResponse{UserID: "user_1", Searches: []string{"business"}}, resp)
+}
+
+// fakeEventLogger also drops the searches whose idempotency key it saw
+type fakeEventLogger struct {
+	fakeLogger
+	keys map[string]bool
+	last logsearch.SearchEvent
+}
+
+func (f *fakeEventLogger) LogSearchEvent(ctx context.Context, event logsearch.SearchEvent) error {
+	f.last = event
+	if event.IdempotencyKey != "" && f.keys[event.IdempotencyKey] {
+		return nil
+	}
+	f.keys[event.IdempotencyKey] = true
+	return f.LogSearchV2(ctx, event.UserIdentifier, event.Query)
+}
+
+func TestHandler_LogIdempotencyKey(t *testing.T) {
+	logger := &fakeEventLogger{fakeLogger: fakeLogger{searches: map[string][]string{}}, keys: map[string]bool{}}
+	h := NewHandler(logger, nil)
+
+	logWith := func(body, key string) {
+		req := httptest.NewRequest(http.MethodPost, "/search/log", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	logWith(`{"user_id":"user_1","query":"bus"}`, "req-1")
+	logWith(`{"user_id":"user_1","query":"bus"}`, "req-1")
+	logWith(`{"user_id":"user_1","query":"bus","idempotency_key":"req-1"}`, "")
+	logWith(`{"user_id":"user_1","query":"bus","idempotency_key":"req-2"}`, "req-1")
+	assert.Equal(t, []string{"bus", "bus"}, logger.searches["user_1"])
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/import", strings.NewReader(`{"user_id":"user_2","query":"cat","idempotency_key":"req-3"}
+{"user_id":"user_2","query":"cat","idempotency_key":"req-3"}
+`)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"cat"}, logger.searches["user_2"])
+
+	logWith(`{"user_id":"user_3","query":"dog","client_time":"2024-05-01T10:00:00Z","sequence":7}`, "")
+	assert.Equal(t, logsearch.SearchEvent{
+		UserIdentifier: "user_3",
+		Query:          "dog",
+		ClientTime:     time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		Sequence:       7,
+	}, logger.last)
+
+	logWith(`{"user_id":"user_3","query":"dog","submitted":true,"result_count":0}`, "")
+	assert.True(t, logger.last.Submitted)
+	require.NotNil(t, logger.last.ResultCount)
+	assert.Zero(t, *logger.last.ResultCount)
+}
+
+func TestHandler_InvalidRequests(t *testing.T) {
+	h := NewHandler(&fakeLogger{searches: map[string][]string{}}, nil)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{"log wrong method", http.MethodGet, "/search/log", "", http.StatusMethodNotAllowed},
+		{"log bad json", http.MethodPost, "/search/log", "{", http.StatusBadRequest},
+		{"log missing query", http.MethodPost, "/search/log", `{"user_id":"user_1"}`, http.StatusBadRequest},
+		{"user missing id", http.MethodGet, "/search/user", "", http.StatusBadRequest},
+		{"user wrong method", http.MethodPut, "/search/user?user_id=user_1", "", http.StatusMethodNotAllowed},
+		{"delete disabled", http.MethodDelete, "/search/user?user_id=user_1", "", http.StatusNotImplemented},
+		{"export disabled", http.MethodGet, "/search/user/export?user_id=user_1", "", http.StatusNotImplemented},
+		{"merge disabled", http.MethodPost, "/search/user/merge", `{"anon_id":"anon_1","user_id":"user_1"}`, http.StatusNotImplemented},
+		{"suggest disabled", http.MethodGet, "/search/suggest?prefix=b", "", http.StatusNotImplemented},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+			assert.Equal(t, tt.status, rec.Code)
+		})
+	}
+}
+
+func TestHandler_ErrorStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{fmt.Errorf("%w: 2048 bytes", logsearch.ErrWordTooLong), http.StatusBadRequest},
+		{logsearch.ErrEmptyWord, http.StatusBadRequest},
+		{fmt.Errorf("%w: word is not valid UTF-8", logsearch.ErrInvalidInput), http.StatusBadRequest},
+		{fmt.Errorf("failed to store user search: %w", logsearch.ErrUserNotFound), http.StatusNotFound},
+		{fmt.Errorf("failed to store user search: %w", logsearch.ErrStoreUnavailable), http.StatusServiceUnavailable},
+		{logsearch.ErrQueueFull, http.StatusServiceUnavailable},
+		{fmt.Errorf("%w: \"blog\"", logsearch.ErrUnknownCategory), http.StatusBadRequest},
+		{errors.New("boom"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			h := NewHandler(&fakeLogger{searches: map[string][]string{}, err: tt.err}, nil)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/log", strings.NewReader(`{"user_id":"user_1","query":"bus"}`)))
+			assert.Equal(t, tt.status, rec.Code)
+		})
+	}
+}
+
+func TestHandler_DeleteUser(t *testing.T) {
+	logger := &fakeDeleteLogger{fakeLogger: fakeLogger{searches: map[string][]string{"user_1": {"bus", "cat"}}}}
+	h := NewHandler(logger, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/search/user?user_id=user_1", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp DeleteUserResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, DeleteUserResponse{UserID: "user_1", Deleted: 2}, resp)
+	assert.Empty(t, logger.searches)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/search/user", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestHandler_MergeUser(t *testing.T) {
+	logger := &fakeMergeLogger{fakeLogger: fakeLogger{searches: map[string][]string{"anon_1": {"bus"}, "user_1": {"cat"}}}}
+	h := NewHandler(logger, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/user/merge", strings.NewReader(`{"anon_id":"anon_1","user_id":"user_1"}`)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, map[string][]string{"user_1": {"cat", "bus"}}, logger.searches)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/user/merge", strings.NewReader(`{"user_id":"user_1"}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/user/merge", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+// fakeAuditor keeps an audit log in memory
+type fakeAuditor struct {
+	entries []store.AuditEntry
+}
+
+func (f *fakeAuditor) RecordAudit(ctx context.Context, entry store.AuditEntry) (int64, error) {
+	entry.ID = int64(len(f.entries) + 1)
+	f.entries = append(f.entries, entry)
+	return entry.ID, nil
+}
+
+func (f *fakeAuditor) AuditLog(ctx context.Context, query store.AuditQuery) ([]store.AuditEntry, error) {
+	entries := []store.AuditEntry{}
+	for i := len(f.entries) - 1; i >= 0; i-- {
+		if query.Action == "" || f.entries[i].Action == query.Action {
+			entries = append(entries, f.entries[i])
+		}
+	}
+	return entries, nil
+}
+
+// fakeAuditLogger also erases and merges users, recording them in the audit log
+type fakeAuditLogger struct {
+	fakeDeleteLogger
+	fakeAuditor
+}
+
+func (f *fakeAuditLogger) MergeIdentities(ctx context.Context, anonID, userID string) error {
+	return (&fakeMergeLogger{fakeLogger: f.fakeLogger}).MergeIdentities(ctx, anonID, userID)
+}
+
+func TestHandler_Audit(t *testing.T) {
+	logger := &fakeAuditLogger{fakeDeleteLogger: fakeDeleteLogger{fakeLogger: fakeLogger{searches: map[string][]string{"anon_1": {"bus"}, "user_1": {"cat"}}}}}
+	h := NewHandler(logger, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/search/user/merge", strings.NewReader(`{"anon_id":"anon_1","user_id":"user_1"}`))
+	req.Header.Set(ActorHeader, "alice")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// Without the header the actor is the client address
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/search/user?user_id=user_1", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// A failed mutation is not recorded
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/user/merge", strings.NewReader(`{"user_id":"user_1"}`)))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/audit", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp AuditResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, []AuditEntry{
+		{ID: 2, Actor: "192.0.2.1:1234", Action: "delete-user", Target: "user_1", Before: "2 searches"},
+		{ID: 1, Actor: "alice", Action: "merge-users", Target: "user_1", Before: "anon_1", After: "user_1"},
+	}, resp.Entries)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/audit?action=merge-users", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Len(t, resp.Entries, 1)
+
+	for _, target := range []string{"/search/audit?since=yesterday", "/search/audit?limit=0"} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+	}
+
+	rec = httptest.NewRecorder()
+	NewHandler(&fakeLogger{}, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/audit", nil))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+// fakeBatchLogger also logs batches, recording their sizes
+type fakeBatchLogger struct {
+	fakeLogger
+	batches []int
+}
+
+func (f *fakeBatchLogger) LogSearchBatch(ctx context.Context, events []logsearch.SearchEvent) error {
+	f.batches = append(f.batches, len(events))
+	for _, event := range events {
+		if err := f.LogSearchV2(ctx, event.UserIdentifier, event.Query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestHandler_Import(t *testing.T) {
+	logger := &fakeBatchLogger{fakeLogger: fakeLogger{searches: map[string][]string{}}}
+	h := NewHandler(logger, nil)
+
+	var body strings.Builder
+	for i := 0; i < importBatchSize+1; i++ {
+		fmt.Fprintf(&body, `{"user_id":"user_%d","query":"bus"}`+"\n", i%2)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/import", strings.NewReader(body.String())))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp ImportResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, int64(importBatchSize+1), resp.Imported)
+	assert.Equal(t, []int{importBatchSize, 1}, logger.batches)
+	assert.Len(t, logger.searches["user_1"], importBatchSize/2)
+
+	// The searches before a bad line are kept
+	plain := &fakeLogger{searches: map[string][]string{}}
+	rec = httptest.NewRecorder()
+	NewHandler(plain, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/import",
+		strings.NewReader(`{"user_id":"user_1","query":"bus"}`+"\n"+`{"user_id":"user_1"}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "search 2")
+	assert.Equal(t, map[string][]string{}, plain.searches)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/import", strings.NewReader("{")))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+// fakePurger records the cutoff it purged with, deleting n records
+type fakePurger struct {
+	fakeSuggester
+	n      int64
+	cutoff time.Time
+}
+
+func (f *fakePurger) Purge(ctx context.Context, cutoff time.Time) (int64, error) {
+	f.cutoff = cutoff
+	return f.n, nil
+}
+
+func TestHandler_Purge(t *testing.T) {
+	purger := &fakePurger{n: 3}
+	h := NewHandler(&fakeLogger{searches: map[string][]string{}}, purger)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/purge?before=2024-05-01T00:00:00Z", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp PurgeResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	cutoff := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, PurgeResponse{Before: cutoff, Words: 3}, resp)
+	assert.True(t, purger.cutoff.Equal(cutoff))
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/purge?older_than=1h", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.WithinDuration(t, time.Now().Add(-time.Hour), purger.cutoff, time.Minute)
+
+	for _, target := range []string{"/search/purge", "/search/purge?older_than=-1h", "/search/purge?before=yesterday",
+		"/search/purge?before=2024-05-01T00:00:00Z&older_than=1h"} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+	}
+
+	rec = httptest.NewRecorder()
+	NewHandler(&fakeLogger{searches: map[string][]string{}}, nil).ServeHTTP(rec,
+		httptest.NewRequest(http.MethodPost, "/search/purge?older_than=1h", nil))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+func TestHandler_ExportUser(t *testing.T) {
+	h := NewHandler(&fakeExportLogger{fakeLogger: fakeLogger{searches: map[string][]string{"user_1": {"bus", "cat"}}}}, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/user/export?user_id=user_1", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "jsonl:bus\njsonl:cat\n", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/user/export?user_id=user_1&format=csv", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/csv", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="searches.csv"`, rec.Header().Get("Content-Disposition"))
+
+	for _, target := range []string{"/search/user/export?user_id=user_1&format=xml", "/search/user/export"} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+	}
+}
+
+func TestHandler_RestoreUser(t *testing.T) {
+	logger := &fakeRestoreLogger{fakeLogger: fakeLogger{searches: map[string][]string{}}}
+	h := NewHandler(logger, nil)
+
+	body := `{"word":"bus","search_count":2}` + "\n" + `{"word":"cat","search_count":1}` + "\n"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/user/restore?user_id=user_1", strings.NewReader(body)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"user_id":"user_1","restored":2}`, rec.Body.String())
+	assert.Equal(t, []string{"bus", "cat"}, logger.searches["user_1"])
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/user/restore?user_id=user_1", strings.NewReader("{")))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/user/restore", strings.NewReader(body)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	NewHandler(&fakeLogger{}, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/user/restore?user_id=user_1", strings.NewReader(body)))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+func TestHandler_Suggest(t *testing.T) {
+	h := NewHandler(&fakeLogger{searches: map[string][]string{}}, fakeSuggester{})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/suggest?prefix=B&limit=2", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp SuggestResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, SuggestResponse{Prefix: "b", Suggestions: []string{"ba", "bb"}}, resp)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/suggest?prefix=b&limit=-1", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+// fakeCategorySearcher only knows the category "products", holding "bus"
+type fakeCategorySearcher struct {
+	fakeSuggester
+}
+
+func (fakeCategorySearcher) SuggestInCategory(prefix, category string, limit int) ([]string, error) {
+	if category != "products" {
+		return nil, fmt.Errorf("%w: %q", logsearch.ErrUnknownCategory, category)
+	}
+	return []string{"bus"}, nil
+}
+
+func (fakeCategorySearcher) GetTopSearchesInCategory(ctx context.Context, category string, since time.Time, limit int) ([]store.WordCount, error) {
+	if category != "products" {
+		return nil, fmt.Errorf("%w: %q", logsearch.ErrUnknownCategory, category)
+	}
+	return []store.WordCount{{Word: "bus", Count: 3}}, nil
+}
+
+func TestHandler_Categories(t *testing.T) {
+	h := NewHandler(&fakeLogger{searches: map[string][]string{}}, fakeCategorySearcher{})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/suggest?prefix=b&category=products", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var suggest SuggestResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&suggest))
+	assert.Equal(t, []string{"bus"}, suggest.Suggestions)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/top?category=products", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var top TopSearchesResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&top))
+	assert.Equal(t, []TopSearch{{Word: "bus", Count: 3}}, top.Searches)
+
+	for _, target := range []string{
+		"/search/suggest?prefix=b&category=blog",
+		"/search/suggest?prefix=b&category=products&verified=true",
+		"/search/top?category=blog",
+		"/search/top?category=products&rank=users",
+	} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+	}
+
+	// Without categories the parameter is refused rather than ignored
+	h = NewHandler(&fakeLogger{searches: map[string][]string{}}, fakeSuggester{})
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/suggest?prefix=b&category=products", nil))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+func TestHandler_Moderation(t *testing.T) {
+	moderator := &fakeModerator{verified: map[string]bool{}, counts: map[string]int{"bus": 3, "bsu": 1}}
+	logger := &fakeAuditLogger{fakeDeleteLogger: fakeDeleteLogger{fakeLogger: fakeLogger{searches: map[string][]string{}}}}
+	h := NewHandler(logger, moderator)
+
+	for _, method := range []string{http.MethodPut, http.MethodPut, http.MethodDelete, http.MethodPut} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, "/search/verified?word=bus", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/search/verified?word=missing", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// The audit log records the state the word was really in
+	require.Len(t, logger.entries, 4)
+	for i, want := range [][3]string{
+		{"verify", "unverified", "verified"},
+		{"verify", "verified", "verified"},
+		{"unverify", "verified", "unverified"},
+		{"verify", "unverified", "verified"},
+	} {
+		assert.Equal(t, want, [3]string{logger.entries[i].Action, logger.entries[i].Before, logger.entries[i].After}, i)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/unverified", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var top TopSearchesResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&top))
+	assert.Equal(t, []TopSearch{{Word: "bsu", Count: 1}}, top.Searches)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/suggest?prefix=b&verified=true", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp SuggestResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, []string{"bus"}, resp.Suggestions)
+
+	for _, target := range []string{"/search/suggest?prefix=b&verified=maybe", "/search/suggest?prefix=b&verified=true&user_id=user_1"} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+	}
+
+	// A suggester without moderation
+	h = NewHandler(&fakeLogger{searches: map[string][]string{}}, fakeSuggester{})
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/search/suggest?prefix=b&verified=true", nil),
+		httptest.NewRequest(http.MethodGet, "/search/unverified", nil),
+		httptest.NewRequest(http.MethodDelete, "/search/verified?word=bus", nil),
+	} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusNotImplemented, rec.Code, req.URL.String())
+	}
+}
+
+// fakeQuerier pages the sorted words of stored, only filtering by prefix
+type fakeQuerier struct {
+	fakeSuggester
+	stored []string
+	query  store.SearchQuery
+}
+
+func (f *fakeQuerier) QueryStoredSearches(ctx context.Context, query store.SearchQuery) ([]string, error) {
+	f.query = query
+	var words []string
+	for _, word := range f.stored {
+		if strings.HasPrefix(word, query.Prefix) {
+			words = append(words, word)
+		}
+	}
+	words = words[min(query.Offset, len(words)):]
+	return words[:min(query.Limit, len(words))], nil
+}
+
+func TestHandler_StoredSearches(t *testing.T) {
+	querier := &fakeQuerier{stored: []string{"bike", "bsu", "bus", "car"}}
+	h := NewHandler(&fakeLogger{searches: map[string][]string{}}, querier)
+
+	get := func(target string) StoredSearchesResponse {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		require.Equal(t, http.StatusOK, rec.Code, target)
+		var resp StoredSearchesResponse
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+		return resp
+	}
+
+	assert.Equal(t, StoredSearchesResponse{Searches: []string{"bike", "bsu"}, NextOffset: 2}, get("/search/stored?prefix=b&limit=2"))
+	assert.Equal(t, StoredSearchesResponse{Searches: []string{"bus"}}, get("/search/stored?prefix=b&limit=2&offset=2"))
+
+	get("/search/stored?sort=recent&min_count=2&since=2024-05-01T00:00:00Z&until=2024-05-02T00:00:00Z")
+	assert.Equal(t, store.SearchQuery{
+		Order:    store.OrderRecent,
+		MinCount: 2,
+		Since:    time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		Until:    time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC),
+		Limit:    defaultTopLimit + 1,
+	}, querier.query)
+
+	for _, target := range []string{
+		"/search/stored?sort=popular",
+		"/search/stored?offset=-1",
+		"/search/stored?min_count=x",
+		"/search/stored?since=yesterday",
+		"/search/stored?limit=0",
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+	}
+
+	rec := httptest.NewRecorder()
+	NewHandler(&fakeLogger{searches: map[string][]string{}}, fakeSuggester{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/stored", nil))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+// fakeHistogramLogger answers one bucket per day of the range
+type fakeHistogramLogger struct {
+	fakeLogger
+}
+
+func (f *fakeHistogramLogger) GetSearchHistogram(ctx context.Context, from, to time.Time, granularity store.Granularity) ([]store.SearchBucket, error) {
+	var buckets []store.SearchBucket
+	for start := granularity.Truncate(from); start.Before(to); start = start.Add(24 * time.Hour) {
+		buckets = append(buckets, store.SearchBucket{Start: start, UniqueWords: 1, Searches: 2})
+	}
+	return buckets, nil
+}
+
+func TestHandler_Histogram(t *testing.T) {
+	h := NewHandler(&fakeHistogramLogger{fakeLogger{searches: map[string][]string{}}}, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/histogram?from=2024-05-01T00:00:00Z&to=2024-05-03T00:00:00Z&granularity=day", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp SearchHistogramResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, SearchHistogramResponse{
+		From:        day,
+		To:          day.Add(48 * time.Hour),
+		Granularity: "day",
+		Buckets: []SearchBucket{
+			{Start: day, UniqueWords: 1, Searches: 2},
+			{Start: day.Add(24 * time.Hour), UniqueWords: 1, Searches: 2},
+		},
+	}, resp)
+
+	for _, target := range []string{
+		"/search/histogram?granularity=week",
+		"/search/histogram?from=yesterday",
+		"/search/histogram?from=2024-05-03T00:00:00Z&to=2024-05-01T00:00:00Z",
+	} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+	}
+
+	rec = httptest.NewRecorder()
+	NewHandler(&fakeLogger{searches: map[string][]string{}}, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/histogram", nil))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+// fakeRollupLogger answers a single rollup at the start of the range
+type fakeRollupLogger struct {
+	fakeLogger
+}
+
+func (f *fakeRollupLogger) GetRollups(ctx context.Context, from, to time.Time, granularity store.Granularity) ([]store.SearchRollup, error) {
+	return []store.SearchRollup{{Word: "cat", Bucket: granularity.Truncate(from), TotalCount: 3, UniqueUsers: 2}}, nil
+}
+
+func TestHandler_Rollups(t *testing.T) {
+	h := NewHandler(&fakeRollupLogger{fakeLogger{searches: map[string][]string{}}}, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/rollups?from=2024-05-01T09:30:00Z&to=2024-05-01T12:00:00Z", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp RollupsResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, "hour", resp.Granularity)
+	assert.Equal(t, []SearchRollup{{Word: "cat", Bucket: time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC), TotalCount: 3, UniqueUsers: 2}}, resp.Rollups)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/rollups?granularity=month", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	NewHandler(&fakeLogger{searches: map[string][]string{}}, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/rollups", nil))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+// fakeProfileLogger answers a profile of two searches at 9am on Wednesdays, recording the query it was asked for
+type fakeProfileLogger struct {
+	fakeLogger
+	word     string
+	from, to time.Time
+	loc      *time.Location
+}
+
+func (f *fakeProfileLogger) GetSearchProfile(ctx context.Context, word string, from, to time.Time, loc *time.Location) (logsearch.SearchProfile, error) {
+	f.word, f.from, f.to, f.loc = word, from, to, loc
+	var profile logsearch.SearchProfile
+	profile.Hours[9], profile.Weekdays[time.Wednesday], profile.Searches = 2, 2, 2
+	return profile, nil
+}
+
+func TestHandler_Profile(t *testing.T) {
+	logger := &fakeProfileLogger{fakeLogger: fakeLogger{searches: map[string][]string{}}}
+	h := NewHandler(logger, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/profile?word=cat&from=2024-05-01T00:00:00Z&to=2024-05-08T00:00:00Z&tz=UTC", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp SearchProfileResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, "cat", resp.Word)
+	assert.Equal(t, "UTC", resp.Timezone)
+	assert.Len(t, resp.Hours, 24)
+	assert.Equal(t, 2, resp.Hours[9])
+	assert.Equal(t, []int{0, 0, 0, 2, 0, 0, 0}, resp.Weekdays)
+	assert.Equal(t, 2, resp.Searches)
+	assert.Equal(t, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), logger.from)
+
+	// The range defaults to the last 7 days
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/profile", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "", logger.word)
+	assert.Equal(t, 7*24*time.Hour, logger.to.Sub(logger.from))
+
+	for _, target := range []string{"/search/profile?tz=Mars/Olympus", "/search/profile?from=yesterday"} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+	}
+
+	rec = httptest.NewRecorder()
+	NewHandler(&fakeLogger{searches: map[string][]string{}}, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/profile", nil))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+// fakeFinalizeLogger also finalizes searches, recording the last one
+type fakeFinalizeLogger struct {
+	fakeLogger
+	user, word string
+}
+
+func (f *fakeFinalizeLogger) Finalize(ctx context.Context, userIdentifier, word string) error {
+	f.user, f.word = userIdentifier, word
+	return nil
+}
+
+func TestHandler_Finalize(t *testing.T) {
+	logger := &fakeFinalizeLogger{fakeLogger: fakeLogger{searches: map[string][]string{}}}
+	h := NewHandler(logger, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/finalize", strings.NewReader(`{"user_id":"user_1","query":"bus"}`)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "user_1", logger.user)
+	assert.Equal(t, "bus", logger.word)
+
+	for _, body := range []string{`{"user_id":"user_1"}`, `not json`} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/finalize", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/finalize", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	rec = httptest.NewRecorder()
+	NewHandler(&fakeLogger{searches: map[string][]string{}}, nil).
+		ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/finalize", strings.NewReader(`{"user_id":"user_1","query":"bus"}`)))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+// fakeClickLogger also records clicks, keeping the last one
+type fakeClickLogger struct {
+	fakeLogger
+	user, word, resultID string
+}
+
+func (f *fakeClickLogger) LogSearchClick(ctx context.Context, userIdentifier, word, resultID string) error {
+	f.user, f.word, f.resultID = userIdentifier, word, resultID
+	return nil
+}
+
+func TestHandler_Click(t *testing.T) {
+	logger := &fakeClickLogger{fakeLogger: fakeLogger{searches: map[string][]string{}}}
+	h := NewHandler(logger, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/click", strings.NewReader(`{"user_id":"user_1","query":"bus","result_id":"line-42"}`)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, fakeClickLogger{fakeLogger: logger.fakeLogger, user: "user_1", word: "bus", resultID: "line-42"}, *logger)
+
+	for _, body := range []string{`{"user_id":"user_1","result_id":"line-42"}`, `not json`} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/click", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/click", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	rec = httptest.NewRecorder()
+	NewHandler(&fakeLogger{searches: map[string][]string{}}, nil).
+		ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/click", strings.NewReader(`{"user_id":"user_1","query":"bus"}`)))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+// fakeSynonymLogger also manages synonyms in memory, recording them in the audit log
+type fakeSynonymLogger struct {
+	fakeLogger
+	fakeAuditor
+	synonyms map[string]string
+}
+
+func (f *fakeSynonymLogger) AddSynonym(ctx context.Context, alias, canonical string) error {
+	previous := f.synonyms[alias]
+	f.synonyms[alias] = canonical
+	_, err := f.RecordAudit(ctx, store.AuditEntry{Actor: logsearch.ActorFrom(ctx), Action: "add-synonym", Target: alias, Before: previous, After: canonical})
+	return err
+}
+
+func (f *fakeSynonymLogger) RemoveSynonym(ctx context.Context, alias string) error {
+	previous, ok := f.synonyms[alias]
+	if !ok {
+		return fmt.Errorf("%w: %q is not an alias", logsearch.ErrWordNotFound, alias)
+	}
+	delete(f.synonyms, alias)
+	_, err := f.RecordAudit(ctx, store.AuditEntry{Actor: logsearch.ActorFrom(ctx), Action: "remove-synonym", Target: alias, Before: previous})
+	return err
+}
+
+func (f *fakeSynonymLogger) GetSynonyms(ctx context.Context) ([]store.Synonym, error) {
+	synonyms := []store.Synonym{}
+	for alias, canonical := range f.synonyms {
+		synonyms = append(synonyms, store.Synonym{Alias: alias, Canonical: canonical})
+	}
+	return synonyms, nil
+}
+
+func TestHandler_Synonyms(t *testing.T) {
+	logger := &fakeSynonymLogger{fakeLogger: fakeLogger{searches: map[string][]string{}}, synonyms: map[string]string{}}
+	h := NewHandler(logger, nil)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/search/synonyms", strings.NewReader(`{"alias":"tv","canonical":"television"}`))
+	req.Header.Set("X-Actor", "alice")
+	h.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/synonyms", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp SynonymsResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, []Synonym{{Alias: "tv", Canonical: "television"}}, resp.Synonyms)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/search/synonyms?alias=tv", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, logger.synonyms)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/search/synonyms?alias=tv", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// The logger records its own mutations, once each, with the actor of the request
+	require.Len(t, logger.entries, 2, "A failed mutation is not recorded")
+	assert.Equal(t, "add-synonym", logger.entries[0].Action)
+	assert.Equal(t, "alice", logger.entries[0].Actor)
+	assert.Equal(t, "television", logger.entries[0].After)
+	assert.Equal(t, "remove-synonym", logger.entries[1].Action)
+	assert.Equal(t, "tv", logger.entries[1].Target)
+	assert.Equal(t, "television", logger.entries[1].Before)
+
+	for _, tt := range []struct{ method, target, body string }{
+		{http.MethodPost, "/search/synonyms", `{"alias":"tv"}`},
+		{http.MethodPost, "/search/synonyms", `not json`},
+		{http.MethodDelete, "/search/synonyms", ""},
+	} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, tt.body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/search/synonyms", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	rec = httptest.NewRecorder()
+	NewHandler(&fakeLogger{searches: map[string][]string{}}, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/synonyms", nil))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+// fakeZeroResultLogger also lists zero-result terms, recording the limit it was asked for
+type fakeZeroResultLogger struct {
+	fakeLogger
+	limit int
+}
+
+func (f *fakeZeroResultLogger) GetZeroResultTerms(ctx context.Context, limit int) ([]store.ZeroResultTerm, error) {
+	f.limit = limit
+	at := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	return []store.ZeroResultTerm{{Word: "blue sofa", Streak: 3, FirstZeroAt: at, LastZeroAt: at.Add(time.Hour)}}, nil
+}
+
+func TestHandler_ZeroResults(t *testing.T) {
+	logger := &fakeZeroResultLogger{fakeLogger: fakeLogger{searches: map[string][]string{}}}
+	h := NewHandler(logger, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/zero-results?limit=5", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp ZeroResultsResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	at := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	assert.Equal(t, []ZeroResultTerm{{Word: "blue sofa", Streak: 3, FirstZeroAt: at, LastZeroAt: at.Add(time.Hour)}}, resp.Terms)
+	assert.Equal(t, 5, logger.limit)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/zero-results?limit=-1", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	NewHandler(&fakeLogger{searches: map[string][]string{}}, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/zero-results", nil))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+// fakeQuarantineLogger also lists quarantined searches, recording the user it was asked for
+type fakeQuarantineLogger struct {
+	fakeLogger
+	user string
+}
+
+func (f *fakeQuarantineLogger) GetQuarantinedSearches(ctx context.Context, userIdentifier string) ([]store.QuarantinedSearch, error) {
+	f.user = userIdentifier
+	return []store.QuarantinedSearch{{ID: 1, UserIdentifier: "bot", Word: "x7qk2m9vz4bw1pjh", Reason: logsearch.ReasonEntropy}}, nil
+}
+
+func TestHandler_Quarantine(t *testing.T) {
+	logger := &fakeQuarantineLogger{fakeLogger: fakeLogger{searches: map[string][]string{}}}
+	h := NewHandler(logger, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/quarantine?user_id=bot", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp QuarantineResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	require.Len(t, resp.Searches, 1)
+	assert.Equal(t, "bot", resp.Searches[0].UserID)
+	assert.Equal(t, "entropy", resp.Searches[0].Reason)
+	assert.Equal(t, "bot", logger.user)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/quarantine", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	rec = httptest.NewRecorder()
+	NewHandler(&fakeLogger{searches: map[string][]string{}}, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/quarantine", nil))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+// fakeReviewLogger also reviews quarantined searches, recording the ids approved and purged
+type fakeReviewLogger struct {
+	fakeQuarantineLogger
+	approved, purged []int64
+}
+
+func (f *fakeReviewLogger) ApproveQuarantinedSearches(ctx context.Context, ids []int64) (int, error) {
+	f.approved = append(f.approved, ids...)
+	return len(ids), nil
+}
+
+func (f *fakeReviewLogger) PurgeQuarantinedSearches(ctx context.Context, ids []int64) (int, error) {
+	f.purged = append(f.purged, ids...)
+	return len(ids) - 1, nil
+}
+
+func TestHandler_QuarantineReview(t *testing.T) {
+	logger := &fakeReviewLogger{fakeQuarantineLogger: fakeQuarantineLogger{fakeLogger: fakeLogger{searches: map[string][]string{}}}}
+	h := NewHandler(logger, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/quarantine/approve", strings.NewReader(`{"ids":[1,2]}`)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp QuarantineReviewResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, 2, resp.Searches)
+	assert.Equal(t, []int64{1, 2}, logger.approved)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/quarantine/purge", strings.NewReader(`{"ids":[3,4]}`)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, 1, resp.Searches)
+	assert.Equal(t, []int64{3, 4}, logger.purged)
+
+	for _, body := range []string{`{"ids":[]}`, `not json`} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/quarantine/purge", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/quarantine/approve", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	rec = httptest.NewRecorder()
+	NewHandler(&fakeQuarantineLogger{fakeLogger: fakeLogger{searches: map[string][]string{}}}, nil).
+		ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/quarantine/approve", strings.NewReader(`{"ids":[1]}`)))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+// fakeCurator records the curated words, only knowing the words of stored
+type fakeCurator struct {
+	fakeSuggester
+	stored  map[string]bool
+	curated []string
+}
+
+func (f *fakeCurator) RenameWord(ctx context.Context, oldWord, newWord string) error {
+	if f.stored[newWord] {
+		return fmt.Errorf("failed to curate: %w", logsearch.ErrWordExists)
+	}
+	return f.MergeWords(ctx, oldWord, newWord)
+}
+
+func (f *fakeCurator) MergeWords(ctx context.Context, from, to string) error {
+	if !f.stored[from] {
+		return fmt.Errorf("failed to curate: %w", logsearch.ErrWordNotFound)
+	}
+	f.curated = append(f.curated, from+">"+to)
+	return nil
+}
+
+// SearchCount counts the words curated from word so far plus one
+func (f *fakeCurator) SearchCount(ctx context.Context, word string) (int, error) {
+	if !f.stored[word] {
+		return 0, fmt.Errorf("failed to count: %w", logsearch.ErrWordNotFound)
+	}
+	count := 1
+	for _, curated := range f.curated {
+		if strings.HasPrefix(curated, word+">") {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func TestHandler_CurateWords(t *testing.T) {
+	curator := &fakeCurator{stored: map[string]bool{"nyc": true, "new york": true}}
+	logger := &fakeAuditLogger{fakeDeleteLogger: fakeDeleteLogger{fakeLogger: fakeLogger{searches: map[string][]string{}}}}
+	h := NewHandler(logger, curator)
+
+	for _, tt := range []struct {
+		target string
+		body   string
+		status int
+	}{
+		{"/search/words/merge", `{"from":"nyc","to":"new york"}`, http.StatusOK},
+		{"/search/words/rename", `{"from":"nyc","to":"new york"}`, http.StatusConflict},
+		{"/search/words/rename", `{"from":"nyc","to":"nyork"}`, http.StatusOK},
+		{"/search/words/merge", `{"from":"cat","to":"cats"}`, http.StatusNotFound},
+		{"/search/words/merge", `{"from":"nyc"}`, http.StatusBadRequest},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body)))
+		assert.Equal(t, tt.status, rec.Code, tt.body)
+	}
+	assert.Equal(t, []string{"nyc>new york", "nyc>nyork"}, curator.curated)
+
+	// Only the suggester curated, the handler audits the searches it counted before
+	require.Len(t, logger.entries, 2)
+	assert.Equal(t, "merge-words", logger.entries[0].Action)
+	assert.Equal(t, "nyc", logger.entries[0].Target)
+	assert.Equal(t, "1 searches", logger.entries[0].Before)
+	assert.Equal(t, "new york", logger.entries[0].After)
+	assert.Equal(t, "2 searches", logger.entries[1].Before)
+
+	rec := httptest.NewRecorder()
+	NewHandler(&fakeLogger{searches: map[string][]string{}}, nil).ServeHTTP(rec,
+		httptest.NewRequest(http.MethodPost, "/search/words/merge", strings.NewReader(`{"from":"nyc","to":"new york"}`)))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+func TestHandler_SuggestForUser(t *testing.T) {
+	logger := &fakePersonalLogger{fakeLogger: fakeLogger{searches: map[string][]string{"user_1": {"bus", "cat"}}}}
+	h := NewHandler(logger, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/suggest?prefix=b&user_id=user_1", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp SuggestResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, SuggestResponse{Prefix: "b", Suggestions: []string{"bus"}}, resp)
+
+	// Without a user the global suggester answers, which is not configured
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/suggest?prefix=b", nil))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+
+	// Loggers without personalization leave it disabled
+	rec = httptest.NewRecorder()
+	NewHandler(&fakeLogger{searches: map[string][]string{}}, fakeSuggester{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/suggest?prefix=b&user_id=user_1", nil))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+func TestHandler_DidYouMean(t *testing.T) {
+	h := NewHandler(&fakeSpellLogger{fakeLogger: fakeLogger{searches: map[string][]string{}}}, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/didyoumean?word=BSU", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp DidYouMeanResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, DidYouMeanResponse{Word: "bsu", Suggestion: "bus", Count: 3, Distance: 1}, resp)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/didyoumean?word=bus", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"word": "bus"}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/didyoumean", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	NewHandler(&fakeLogger{searches: map[string][]string{}}, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/didyoumean?word=bsu", nil))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+func TestHandler_Terms(t *testing.T) {
+	logger := &fakeTermLogger{fakeLogger: fakeLogger{searches: map[string][]string{}}}
+	h := NewHandler(logger, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/terms?contains=phone&limit=5", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp TopSearchesResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, []TopSearch{{Word: "iphone case", Count: 2}, {Word: "headphones", Count: 2}}, resp.Searches)
+	assert.Equal(t, 5, logger.limit)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/terms?contains=tablet", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"searches": []}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/terms", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/terms?contains=phone", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	rec = httptest.NewRecorder()
+	NewHandler(&fakeLogger{searches: map[string][]string{}}, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/terms?contains=phone", nil))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+func TestHandler_TopSearches(t *testing.T) {
+	logger := &fakeTopLogger{fakeLogger: fakeLogger{searches: map[string][]string{}}}
+	h := NewHandler(logger, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/top?limit=2", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp TopSearchesResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, []TopSearch{{Word: "bus", Count: 3}, {Word: "cat", Count: 2}}, resp.Searches)
+	assert.True(t, logger.since.IsZero())
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/top?window=1h", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.WithinDuration(t, time.Now().Add(-time.Hour), logger.since, time.Minute)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/top?window=soon", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	for _, target := range []string{"/search/top?rank=popularity", "/search/top?rank=users"} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.NotEqual(t, http.StatusOK, rec.Code, target)
+	}
+
+	// Loggers that cannot rank searches leave the endpoint disabled
+	rec = httptest.NewRecorder()
+	NewHandler(&fakeLogger{searches: map[string][]string{}}, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/top", nil))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+func TestHandler_TopAudiences(t *testing.T) {
+	logger := &fakeAudienceLogger{fakeTopLogger: fakeTopLogger{fakeLogger: fakeLogger{searches: map[string][]string{}}}}
+	h := NewHandler(logger, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/top?rank=users&window=24h", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp TopSearchesResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, []TopSearch{{Word: "cat", Count: 2, Users: 2}, {Word: "bus", Count: 3, Users: 1}}, resp.Searches)
+	assert.WithinDuration(t, time.Now().Add(-24*time.Hour), logger.since, time.Minute)
+
+	// rank=searches keeps ranking by searches
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/top?rank=searches&limit=1", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var bySearches TopSearchesResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&bySearches))
+	assert.Equal(t, []TopSearch{{Word: "bus", Count: 3}}, bySearches.Searches)
+}
+
+func TestHandler_Regions(t *testing.T) {
+	logger := &fakeRegionLogger{fakeEventLogger: fakeEventLogger{fakeLogger: fakeLogger{searches: map[string][]string{}}, keys: map[string]bool{}}}
+	h := NewHandler(logger, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/log", strings.NewReader(`{"user_id":"user_1","query":"velo","country":"FR","locale":"fr-FR"}`)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "FR", logger.last.Country)
+	assert.Equal(t, "fr-FR", logger.last.Locale)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/top?country=FR&limit=5&window=24h", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp TopSearchesResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, []TopSearch{{Word: "velo", Count: 2}}, resp.Searches)
+	assert.Equal(t, "FR", logger.query.Country)
+	assert.Equal(t, 5, logger.query.Limit)
+	assert.WithinDuration(t, time.Now().Add(-24*time.Hour), logger.query.Since, time.Minute)
+
+	for _, target := range []string{"/search/top?country=FR&rank=users", "/search/top?locale=fr-FR&category=products"} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+	}
+
+	// Without regions the parameters are refused rather than ignored
+	rec = httptest.NewRecorder()
+	NewHandler(&fakeTopLogger{fakeLogger: fakeLogger{searches: map[string][]string{}}}, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/top?locale=fr-FR", nil))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+func TestHandler_Trending(t *testing.T) {
+	logger := &fakeTrendingLogger{fakeLogger: fakeLogger{searches: map[string][]string{}}}
+	h := NewHandler(logger, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/trending", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp TopSearchesResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, TopSearchesResponse{Window: "1h0m0s", Searches: []TopSearch{{Word: "emu", Count: 4}}}, resp)
+	assert.Equal(t, time.Hour, logger.window)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/trending?window=15m", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 15*time.Minute, logger.window)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/trending?window=-1h", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	NewHandler(&fakeLogger{searches: map[string][]string{}}, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/trending", nil))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+func TestServer_GracefulShutdown(t *testing.T) {
+	srv := New("127.0.0.1:0", NewHandler(&fakeLogger{searches: map[string][]string{}}, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Run(ctx) }()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+}
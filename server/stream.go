@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxKeystrokeBytes bounds the messages of GET /search/stream
+const maxKeystrokeBytes = 4096
+
+// KeystrokeLogger logs the words typed over GET /search/stream, implemented by SearchLoggerV2
+type KeystrokeLogger interface {
+	LogSearchV2(ctx context.Context, userIdentifier, word string) error
+}
+
+// KeystrokeMessage is a message sent by the client of GET /search/stream,
+// the content of the search box after a keystroke
+type KeystrokeMessage struct {
+	Query string `json:"query"`
+}
+
+// StreamResponse is a message sent to the client of GET /search/stream, once
+// a word was logged or a message was refused
+type StreamResponse struct {
+	Logged string `json:"logged,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// keystroke is a message read from a connection, err set when it is invalid
+type keystroke struct {
+	query string
+	err   error
+}
+
+// StreamHandler serves GET /search/stream?user_id={id}, a WebSocket receiving
+// the keystrokes of a user as KeystrokeMessages. A connection is one typing
+// session: a keystroke extending or backspacing the pending word replaces
+// it, so "b", "bu", "bus" only log "bus", and the pending word is logged once
+// the user types an unrelated word, clears the box, stops typing for the idle
+// timeout or disconnects. The store is written once per word instead of once
+// per keystroke.
+type StreamHandler struct {
+	logger   KeystrokeLogger
+	idle     time.Duration
+	upgrader websocket.Upgrader
+
+	mutex   sync.Mutex
+	closed  bool
+	closing chan struct{}
+	conns   sync.WaitGroup
+}
+
+// NewStreamHandler creates the WebSocket handler logging the pending word of
+// a connection idle for idle
+func NewStreamHandler(logger KeystrokeLogger, idle time.Duration) *StreamHandler {
+	return &StreamHandler{
+		logger:  logger,
+		idle:    idle,
+		closing: make(chan struct{}),
+	}
+}
+
+// ServeHTTP upgrades the request to a WebSocket and consumes its keystrokes
+func (s *StreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	userID := r.URL.Query().Get("user_id")
+	if strings.TrimSpace(userID) == "" {
+		writeError(w, http.StatusBadRequest, "user_id is required")
+		return
+	}
+
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		writeError(w, http.StatusServiceUnavailable, "server is shutting down")
+		return
+	}
+	s.conns.Add(1)
+	s.mutex.Unlock()
+	defer s.conns.Done()
+
+	// The upgrader answers the failed handshakes itself
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(maxKeystrokeBytes)
+
+	// The words are logged after the client left, the request is only done once they are
+	s.consume(context.WithoutCancel(r.Context()), conn, userID)
+}
+
+// consume consolidates the keystrokes of a connection until it is closed
+func (s *StreamHandler) consume(ctx context.Context, conn *websocket.Conn, userID string) {
+	done := make(chan struct{})
+	defer close(done)
+	keystrokes := s.read(conn, done)
+
+	idle := time.NewTimer(s.idle)
+	idle.Stop()
+	defer idle.Stop()
+
+	var pending string
+	logPending := func() {
+		if pending == "" {
+			return
+		}
+		response := StreamResponse{Logged: pending}
+		if err := s.logger.LogSearchV2(ctx, userID, pending); err != nil {
+			response = StreamResponse{Error: err.Error()}
+		}
+		pending = ""
+		// A client gone cannot learn about the failure, the log still does
+		if err := conn.WriteJSON(response); err != nil && response.Error != "" {
+			log.Printf("Failed to log the streamed search of %s: %s", userID, response.Error)
+		}
+	}
+
+	for {
+		select {
+		case k, ok := <-keystrokes:
+			if !ok {
+				logPending()
+				return
+			}
+			if k.err != nil {
+				conn.WriteJSON(StreamResponse{Error: k.err.Error()})
+				continue
+			}
+			if !related(pending, k.query) {
+				logPending()
+			}
+			pending = k.query
+			idle.Reset(s.idle)
+		case <-idle.C:
+			logPending()
+		case <-s.closing:
+			logPending()
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is shutting down"), time.Now().Add(time.Second))
+			return
+		}
+	}
+}
+
+// read forwards the messages of conn until it fails or done is closed
+func (s *StreamHandler) read(conn *websocket.Conn, done <-chan struct{}) <-chan keystroke {
+	keystrokes := make(chan keystroke)
+	go func() {
+		defer close(keystrokes)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var message KeystrokeMessage
+			k := keystroke{}
+			if err := json.Unmarshal(data, &message); err != nil {
+				k.err = err
+			} else {
+				k.query = strings.TrimSpace(message.Query)
+			}
+			select {
+			case keystrokes <- k:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return keystrokes
+}
+
+// related reports whether one of two non-empty words extends the other
+func related(a, b string) bool {
+	return a != "" && b != "" && (strings.HasPrefix(a, b) || strings.HasPrefix(b, a))
+}
+
+// Close logs the pending words of the open connections and closes them,
+// waiting until ctx is done. New connections are refused.
+func (s *StreamHandler) Close(ctx context.Context) error {
+	s.mutex.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.closing)
+	}
+	s.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.conns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// keystrokeRecorder records the logged words, it is safe for concurrent use
+type keystrokeRecorder struct {
+	mutex sync.Mutex
+	words []string
+}
+
+func (k *keystrokeRecorder) LogSearchV2(ctx context.Context, userIdentifier, word string) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.words = append(k.words, userIdentifier+":"+word)
+	return nil
+}
+
+func (k *keystrokeRecorder) logged() []string {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return append([]string(nil), k.words...)
+}
+
+// dialStream opens a WebSocket to the stream handler behind server
+func dialStream(t *testing.T, server *httptest.Server, userID string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/?user_id="+userID, nil)
+	require.NoError(t, err)
+	return conn
+}
+
+// typeQueries sends a keystroke per query
+func typeQueries(t *testing.T, conn *websocket.Conn, queries ...string) {
+	t.Helper()
+	for _, query := range queries {
+		require.NoError(t, conn.WriteJSON(KeystrokeMessage{Query: query}))
+	}
+}
+
+func TestStreamHandler_ConsolidatesKeystrokes(t *testing.T) {
+	logger := &keystrokeRecorder{}
+	stream := NewStreamHandler(logger, time.Hour)
+	server := httptest.NewServer(stream)
+	defer server.Close()
+
+	conn := dialStream(t, server, "user_1")
+	// Backspacing and retyping replaces the pending word, an unrelated word logs it
+	typeQueries(t, conn, "b", "bu", "bus", "busi", "busin", "busi", "busia", "c", "ca", "cat", "", "dog")
+
+	var response StreamResponse
+	require.NoError(t, conn.ReadJSON(&response))
+	assert.Equal(t, StreamResponse{Logged: "busia"}, response)
+	require.NoError(t, conn.ReadJSON(&response))
+	assert.Equal(t, StreamResponse{Logged: "cat"}, response)
+
+	// The pending word is logged when the client disconnects
+	require.NoError(t, conn.Close())
+	assert.Eventually(t, func() bool {
+		return len(logger.logged()) == 3
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"user_1:busia", "user_1:cat", "user_1:dog"}, logger.logged())
+}
+
+func TestStreamHandler_LogsIdleWords(t *testing.T) {
+	logger := &keystrokeRecorder{}
+	stream := NewStreamHandler(logger, 10*time.Millisecond)
+	server := httptest.NewServer(stream)
+	defer server.Close()
+
+	conn := dialStream(t, server, "user_1")
+	defer conn.Close()
+	typeQueries(t, conn, "c", "ca", "cat")
+
+	var response StreamResponse
+	require.NoError(t, conn.ReadJSON(&response))
+	assert.Equal(t, StreamResponse{Logged: "cat"}, response)
+
+	// Typing on after the idle timeout starts a new word
+	typeQueries(t, conn, "cats")
+	require.NoError(t, conn.ReadJSON(&response))
+	assert.Equal(t, StreamResponse{Logged: "cats"}, response)
+	assert.Equal(t, []string{"user_1:cat", "user_1:cats"}, logger.logged())
+}
+
+func TestStreamHandler_InvalidMessages(t *testing.T) {
+	stream := NewStreamHandler(&keystrokeRecorder{}, time.Hour)
+	server := httptest.NewServer(stream)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/?user_id=")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	conn := dialStream(t, server, "user_1")
+	defer conn.Close()
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("not json")))
+	var response StreamResponse
+	require.NoError(t, conn.ReadJSON(&response))
+	assert.NotEmpty(t, response.Error)
+}
+
+func TestStreamHandler_Close(t *testing.T) {
+	logger := &keystrokeRecorder{}
+	stream := NewStreamHandler(logger, time.Hour)
+	server := httptest.NewServer(stream)
+	defer server.Close()
+
+	conn := dialStream(t, server, "user_1")
+	defer conn.Close()
+	typeQueries(t, conn, "c", "ca", "cat")
+	// Wait for the keystrokes to be consumed
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("not json")))
+	var response StreamResponse
+	require.NoError(t, conn.ReadJSON(&response))
+
+	require.NoError(t, stream.Close(context.Background()))
+	assert.Equal(t, []string{"user_1:cat"}, logger.logged())
+
+	// The server said goodbye after logging the pending word
+	response = StreamResponse{}
+	require.NoError(t, conn.ReadJSON(&response))
+	assert.Equal(t, StreamResponse{Logged: "cat"}, response)
+	_, _, err := conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), err)
+
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/?user_id=user_1", nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
@@ -0,0 +1,374 @@
+package logsearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/afanwang/logsearch/metrics"
+	"github.com/afanwang/logsearch/store"
+	"github.com/afanwang/logsearch/tracing"
+)
+
+// sessionTracker holds the words each user is still typing, the way the
+// trie of Version 1 does, and only hands a word to the store once its
+// FinalizePolicy deems it complete. A user pausing after "bus" before
+// typing "business" costs one store write instead of a write of "bus"
+// followed by an extension.
+type sessionTracker struct {
+	mutex  sync.Mutex
+	policy FinalizePolicy
+	// pending[userIdentifier][word] is when and where the user typed the
+	// word, no pending word of a user is a prefix of another one
+	pending map[string]map[string]pendingSearch
+	// heartbeat is the UnixNano time finalizeRoutine last completed a round
+	heartbeat atomic.Int64
+}
+
+// pendingSearch is when a pending word was first and last typed, and where
+type pendingSearch struct {
+	firstSeen time.Time
+	lastSeen  time.Time
+	region    store.Region
+}
+
+// sessionWord is a pending word due for finalization
+type sessionWord struct {
+	userIdentifier string
+	word           string
+	firstSeen      time.Time
+	lastSeen       time.Time
+	region         store.Region
+}
+
+// FinalizePolicy combines the signals deciding when a word held by
+// WithFinalizePolicy is complete, the first one firing finalizes it. A zero
+// field disables its signal, and Idle or MaxPending must be set so every word
+// is finalized eventually. SearchLoggerV2.Finalize is the explicit signal of
+// the client, always available.
+type FinalizePolicy struct {
+	// Idle finalizes a word its user has not extended for this long
+	Idle time.Duration
+	// MaxPending finalizes a word pending for this long since its user
+	// started typing it, even while they keep extending it, so a user typing
+	// without pause is not held forever. Typing on extends the stored word.
+	MaxPending time.Duration
+	// WordBoundaries finalizes the pending words of a user as soon as they
+	// type a search diverging from them at a word boundary: replacing whole
+	// words, e.g. "new york" then "new jersey", or starting over, e.g. "cat"
+	// then "dog". A search diverging within a word, e.g. "busines" then
+	// "businss", likely fixes a typo and keeps them pending.
+	WordBoundaries bool
+}
+
+// minFinalizeInterval bounds how often the pending words are checked, a
+// timeout under 2ns would otherwise give time.NewTicker a zero interval
+const minFinalizeInterval = time.Millisecond
+
+// interval returns how often the pending words are checked against the timeouts of the policy
+func (p FinalizePolicy) interval() time.Duration {
+	interval := p.Idle
+	if interval <= 0 || (p.MaxPending > 0 && p.MaxPending < interval) {
+		interval = p.MaxPending
+	}
+	return max(interval/2, minFinalizeInterval)
+}
+
+// WithFinalizePolicy holds every search in memory until policy deems it
+// complete, then stores it like LogSearchV2 would have right away. Pending
+// words are not returned by GetUserSearches until finalized, Flush and Close
+// finalize them early. The option is ignored when neither policy.Idle nor
+// policy.MaxPending is set.
+func WithFinalizePolicy(policy FinalizePolicy) Option {
+	return func(sl *SearchLoggerV2) {
+		if policy.Idle > 0 || policy.MaxPending > 0 {
+			sl.sessions = &sessionTracker{
+				policy:  policy,
+				pending: make(map[string]map[string]pendingSearch),
+			}
+		}
+	}
+}
+
+// WithFinalizeTimeout holds every search in memory until its user has not
+// extended it for idle, it is WithFinalizePolicy(FinalizePolicy{Idle: idle})
+func WithFinalizeTimeout(idle time.Duration) Option {
+	return WithFinalizePolicy(FinalizePolicy{Idle: idle})
+}
+
+// track records that the user typed word at timestamp in region, and returns
+// the pending words of the user it completes by the word boundaries of the
+// policy, removed from the pending words
+func (t *sessionTracker) track(userIdentifier, word string, timestamp time.Time, region store.Region) []sessionWord {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.addLocked(userIdentifier, word, pendingSearch{firstSeen: timestamp, lastSeen: timestamp, region: region}, t.policy.WordBoundaries)
+}
+
+// addLocked adds the pending word of the user, returning the pending words
+// it completes when boundaries applies the word boundaries. Caller must hold
+// the mutex.
+func (t *sessionTracker) addLocked(userIdentifier, word string, p pendingSearch, boundaries bool) []sessionWord {
+	words := t.pending[userIdentifier]
+	if words == nil {
+		words = make(map[string]pendingSearch)
+		t.pending[userIdentifier] = words
+	}
+
+	if pending, ok := words[word]; ok {
+		words[word] = pending.merge(p)
+		return nil
+	}
+
+	var completed []sessionWord
+	for pendingWord, pending := range words {
+		switch {
+		case strings.HasPrefix(word, pendingWord):
+			// The user kept typing, the shorter word is not final
+			delete(words, pendingWord)
+			region := p.region
+			p = p.merge(pending)
+			if !region.IsZero() {
+				p.region = region
+			}
+		case strings.HasPrefix(pendingWord, word):
+			// Out of order prefix, it still shows the user is typing
+			words[pendingWord] = pending.merge(p)
+			return completed
+		case boundaries && divergesAtWordBoundary(pendingWord, word):
+			delete(words, pendingWord)
+			completed = append(completed, pending.word(userIdentifier, pendingWord))
+		}
+	}
+	words[word] = p
+	return completed
+}
+
+// divergesAtWordBoundary reports whether next replaces whole words of pending
+// or starts over, rather than diverging from it within a word
+func divergesAtWordBoundary(pending, next string) bool {
+	shared := 0
+	for shared < len(pending) && shared < len(next) && pending[shared] == next[shared] {
+		shared++
+	}
+	return shared == 0 || pending[shared-1] == ' '
+}
+
+// merge returns p typed again as other, the region of the latest search known wins
+func (p pendingSearch) merge(other pendingSearch) pendingSearch {
+	if !other.region.IsZero() && (p.region.IsZero() || !other.lastSeen.Before(p.lastSeen)) {
+		p.region = other.region
+	}
+	p.firstSeen = earliest(p.firstSeen, other.firstSeen)
+	p.lastSeen = latest(p.lastSeen, other.lastSeen)
+	return p
+}
+
+// word returns p as the word of the user due for finalization
+func (p pendingSearch) word(userIdentifier, word string) sessionWord {
+	return sessionWord{userIdentifier: userIdentifier, word: word, firstSeen: p.firstSeen, lastSeen: p.lastSeen, region: p.region}
+}
+
+// due removes and returns the pending words last seen at or before
+// idleCutoff, or first seen at or before startCutoff, oldest first
+func (t *sessionTracker) due(idleCutoff, startCutoff time.Time) []sessionWord {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var due []sessionWord
+	for userIdentifier, words := range t.pending {
+		for word, pending := range words {
+			if pending.lastSeen.After(idleCutoff) && pending.firstSeen.After(startCutoff) {
+				continue
+			}
+			due = append(due, pending.word(userIdentifier, word))
+			delete(words, word)
+		}
+		if len(words) == 0 {
+			delete(t.pending, userIdentifier)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].lastSeen.Before(due[j].lastSeen)
+	})
+	return due
+}
+
+// take removes and returns the pending word of the user, if any
+func (t *sessionTracker) take(userIdentifier, word string) (sessionWord, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	pending, ok := t.pending[userIdentifier][word]
+	if !ok {
+		return sessionWord{}, false
+	}
+	delete(t.pending[userIdentifier], word)
+	if len(t.pending[userIdentifier]) == 0 {
+		delete(t.pending, userIdentifier)
+	}
+	return pending.word(userIdentifier, word), true
+}
+
+// drop removes the pending words of the user that word extends or is a prefix of
+func (t *sessionTracker) drop(userIdentifier, word string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	words := t.pending[userIdentifier]
+	for pendingWord := range words {
+		if strings.HasPrefix(word, pendingWord) || strings.HasPrefix(pendingWord, word) {
+			delete(words, pendingWord)
+		}
+	}
+	if len(words) == 0 {
+		delete(t.pending, userIdentifier)
+	}
+}
+
+// restore puts back a word that failed to be finalized, completing none of the pending words
+func (t *sessionTracker) restore(w sessionWord) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.addLocked(w.userIdentifier, w.word, pendingSearch{firstSeen: w.firstSeen, lastSeen: w.lastSeen, region: w.region}, false)
+}
+
+// forget drops the pending words of a user
+func (t *sessionTracker) forget(userIdentifier string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	delete(t.pending, userIdentifier)
+}
+
+// move hands the pending words of a user over to another user at once, so a
+// concurrent finalization never misses them between both users
+func (t *sessionTracker) move(fromUser, toUser string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	words := t.pending[fromUser]
+	delete(t.pending, fromUser)
+	// The words of the guest join those of the user as typed, neither completes the other
+	for word, pending := range words {
+		t.addLocked(toUser, word, pending, false)
+	}
+}
+
+// finalizeRoutine stores the words due by the timeouts of the policy until ctx is cancelled by Close
+func (sl *SearchLoggerV2) finalizeRoutine(ctx context.Context) {
+	defer sl.wg.Done()
+
+	ticker := time.NewTicker(sl.sessions.policy.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			// A zero cutoff finalizes nothing, the pending words were seen after it
+			var idleCutoff, startCutoff time.Time
+			if sl.sessions.policy.Idle > 0 {
+				idleCutoff = now.Add(-sl.sessions.policy.Idle)
+			}
+			if sl.sessions.policy.MaxPending > 0 {
+				startCutoff = now.Add(-sl.sessions.policy.MaxPending)
+			}
+			if err := sl.finalizeDue(ctx, idleCutoff, startCutoff); err != nil {
+				log.Printf("Error finalizing searches: %v", err)
+			}
+			sl.sessions.heartbeat.Store(time.Now().UnixNano())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// finalizeDue stores the pending words last seen at or before idleCutoff, or
+// first seen at or before startCutoff, it is a no-op without a finalization
+// policy
+func (sl *SearchLoggerV2) finalizeDue(ctx context.Context, idleCutoff, startCutoff time.Time) error {
+	if sl.sessions == nil {
+		return nil
+	}
+	return sl.finalizeWords(ctx, sl.sessions.due(idleCutoff, startCutoff))
+}
+
+// finalizeWords stores the words taken from the pending words. Words failing
+// to store stay pending and are retried by the next round.
+func (sl *SearchLoggerV2) finalizeWords(ctx context.Context, words []sessionWord) (err error) {
+	if len(words) == 0 {
+		return nil
+	}
+
+	ctx, span := sl.tracer.Start(ctx, "logsearch.finalize", tracing.KeyLogger.String(metrics.LoggerV2), tracing.KeyWrites.Int(len(words)))
+	defer func() { tracing.End(span, err) }()
+
+	var errs []error
+	for _, due := range words {
+		// The search is finished, its last word is no longer the prefix of a longer one
+		event := SearchEvent{
+			UserIdentifier: due.userIdentifier,
+			Query:          sl.synonyms.foldQuery(due.word),
+			Country:        due.region.Country,
+			Locale:         due.region.Locale,
+			at:             due.lastSeen,
+		}
+		if err := sl.store(ctx, event); err != nil {
+			sl.sessions.restore(due)
+			errs = append(errs, fmt.Errorf("search %q of %s: %w", due.word, due.userIdentifier, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Finalize is the explicit signal of a client that the user is done typing
+// word, e.g. when the search box loses focus: the word pending for the user
+// is stored right away instead of waiting for the FinalizePolicy. word runs
+// through the ingest chain like a logged search, without being logged, so it
+// matches the pending word of the same search. It is a no-op when word is not
+// pending for the user, e.g. already finalized, and without
+// WithFinalizePolicy, every search being stored as logged.
+func (sl *SearchLoggerV2) Finalize(ctx context.Context, userIdentifier, word string) (err error) {
+	ctx, span := sl.tracer.Start(ctx, "logsearch.Finalize", tracing.KeyLogger.String(metrics.LoggerV2))
+	defer func() { tracing.End(span, err) }()
+
+	return sl.ingest(ctx, SearchEvent{UserIdentifier: userIdentifier, Query: word, finalize: true})
+}
+
+// finalizeStage ends the chain of a Finalize signal, storing the pending word of the event
+func (sl *SearchLoggerV2) finalizeStage(ctx context.Context, event SearchEvent) error {
+	if sl.sessions == nil {
+		return nil
+	}
+	due, ok := sl.sessions.take(event.UserIdentifier, event.Query)
+	if !ok {
+		return nil
+	}
+	return sl.finalizeWords(ctx, []sessionWord{due})
+}
+
+// earliest returns the earlier of two timestamps
+func earliest(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+// latest returns the later of two timestamps
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
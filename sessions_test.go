@@ -0,0 +1,183 @@
+package logsearch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessions_FinalizeAfterIdle(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLoggerV2(WithFinalizeTimeout(50 * time.Millisecond))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	for _, word := range []string{"b", "bu", "bus"} {
+		require.NoError(t, logger.LogSearchV2(ctx, "user_1", word))
+	}
+
+	// Nothing is stored while the user may still be typing
+	searches, err := logger.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Empty(t, searches)
+
+	assert.Eventually(t, func() bool {
+		searches, err := logger.GetUserSearches(ctx, "user_1")
+		return err == nil && len(searches) == 1 && searches[0] == "bus"
+	}, time.Second, 10*time.Millisecond)
+
+	// Typing on after a pause extends the finalized word
+	require.NoError(t, logger.LogSearchV2(ctx, "user_1", "business"))
+	assert.Eventually(t, func() bool {
+		searches, err := logger.GetUserSearches(ctx, "user_1")
+		return err == nil && len(searches) == 1 && searches[0] == "business"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestSessions_FinalizeTinyTimeouts(t *testing.T) {
+	ctx := context.Background()
+	for _, policy := range []FinalizePolicy{{Idle: time.Nanosecond}, {MaxPending: time.Nanosecond}} {
+		logger, err := NewSearchLoggerV2(WithFinalizePolicy(policy))
+		require.NoError(t, err)
+
+		require.NoError(t, logger.LogSearchV2(ctx, "user_1", "bus"))
+		assert.Eventually(t, func() bool {
+			searches, err := logger.GetUserSearches(ctx, "user_1")
+			return err == nil && len(searches) == 1 && searches[0] == "bus"
+		}, time.Second, 10*time.Millisecond)
+		require.NoError(t, logger.Close())
+	}
+}
+
+func TestSessions_PendingWordsPerUser(t *testing.T) {
+	ctx := context.Background()
+	counting := &countingStore{UserSearchStore: store.NewMockPostgresDBV2()}
+	logger, err := NewSearchLoggerV2WithDB(counting, WithFinalizeTimeout(time.Hour))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	events := []SearchEvent{
+		{UserIdentifier: "user_1", Query: "c"},
+		{UserIdentifier: "user_1", Query: "cat"},
+		{UserIdentifier: "user_1", Query: "ca"},
+		{UserIdentifier: "user_2", Query: "d"},
+		{UserIdentifier: "user_2", Query: "dog"},
+		{UserIdentifier: "user_1", Query: "apple"},
+	}
+	require.NoError(t, logger.LogSearchBatch(ctx, events))
+	assert.Equal(t, 0, counting.lookups)
+
+	require.NoError(t, logger.Flush(ctx))
+
+	user1, err := logger.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"cat", "apple"}, user1)
+
+	user2, err := logger.GetUserSearches(ctx, "user_2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dog"}, user2)
+}
+
+func TestSessions_CloseFinalizesPendingWords(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDBV2()
+	logger, err := NewSearchLoggerV2WithDB(db, WithFinalizeTimeout(time.Hour), WithWriteBuffer(100, time.Hour))
+	require.NoError(t, err)
+
+	require.NoError(t, logger.LogSearchV2(ctx, "user_1", "go"))
+	require.NoError(t, logger.LogSearchV2(ctx, "user_1", "golang"))
+	require.NoError(t, logger.Close())
+
+	searches, err := db.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"golang"}, searches)
+}
+
+func TestSessions_FinalizePolicy(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tracker := &sessionTracker{
+		policy:  FinalizePolicy{Idle: time.Minute, MaxPending: 10 * time.Second, WordBoundaries: true},
+		pending: make(map[string]map[string]pendingSearch),
+	}
+
+	// Typing on keeps when the word was started
+	assert.Empty(t, tracker.track("user_1", "new", start, store.Region{}))
+	assert.Empty(t, tracker.track("user_1", "new york", start.Add(5*time.Second), store.Region{}))
+	assert.Empty(t, tracker.track("user_1", "new yorl", start.Add(6*time.Second), store.Region{}), "A typo fix keeps the word pending")
+
+	// Replacing a whole word completes the pending words
+	completed := tracker.track("user_1", "new jersey", start.Add(7*time.Second), store.Region{})
+	require.Len(t, completed, 2)
+	firstSeen := map[string]time.Time{completed[0].word: completed[0].firstSeen, completed[1].word: completed[1].firstSeen}
+	assert.Equal(t, map[string]time.Time{"new york": start, "new yorl": start.Add(6 * time.Second)}, firstSeen)
+
+	// Starting over too, for the user only
+	assert.Empty(t, tracker.track("user_2", "cat", start, store.Region{}))
+	completed = tracker.track("user_2", "dog", start.Add(time.Second), store.Region{})
+	require.Len(t, completed, 1)
+	assert.Equal(t, "cat", completed[0].word)
+
+	// MaxPending finalizes the words started long enough ago, however recently typed
+	assert.Empty(t, tracker.track("user_2", "dogs", start.Add(30*time.Second), store.Region{}))
+	assert.Empty(t, tracker.due(start.Add(-time.Minute), start))
+	due := tracker.due(start.Add(-time.Minute), start.Add(7*time.Second))
+	require.Len(t, due, 2)
+	assert.Equal(t, "new jersey", due[0].word)
+	assert.Equal(t, "dogs", due[1].word)
+
+	// Idle the words left alone
+	tracker.track("user_4", "cow", start, store.Region{})
+	due = tracker.due(start, time.Time{})
+	require.Len(t, due, 1)
+	assert.Equal(t, "cow", due[0].word)
+
+	// Without WordBoundaries diverging searches stay pending
+	tracker.policy.WordBoundaries = false
+	tracker.track("user_3", "cat", start, store.Region{})
+	assert.Empty(t, tracker.track("user_3", "dog", start, store.Region{}))
+}
+
+func TestSessions_Finalize(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDBV2()
+	logger, err := NewSearchLoggerV2WithDB(db, WithFinalizePolicy(FinalizePolicy{Idle: time.Hour, WordBoundaries: true}))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	for _, word := range []string{"b", "bu", "bus"} {
+		require.NoError(t, logger.LogSearchV2(ctx, "user_1", word))
+	}
+	require.NoError(t, logger.LogSearchV2(ctx, "user_2", "bus"))
+
+	// The explicit signal is normalized like the search, and only stores the word of the user
+	require.NoError(t, logger.Finalize(ctx, "user_1", " BUS "))
+	searches, err := db.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bus"}, searches)
+	searches, err = db.GetUserSearches(ctx, "user_2")
+	require.NoError(t, err)
+	assert.Empty(t, searches)
+
+	require.NoError(t, logger.Finalize(ctx, "user_1", "bus"), "A word not pending is a no-op")
+	require.NoError(t, logger.Finalize(ctx, "user_1", "train"))
+	assert.ErrorIs(t, logger.Finalize(ctx, "", "bus"), ErrEmptyUser)
+
+	// A search starting over stores the pending word right away
+	require.NoError(t, logger.LogSearchV2(ctx, "user_2", "train"))
+	searches, err = db.GetUserSearches(ctx, "user_2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bus"}, searches)
+
+	// Without a policy every search is stored as logged
+	plain, err := NewSearchLoggerV2WithDB(db)
+	require.NoError(t, err)
+	defer plain.Close()
+	require.NoError(t, plain.Finalize(ctx, "user_3", "bus"))
+	searches, err = db.GetUserSearches(ctx, "user_3")
+	require.NoError(t, err)
+	assert.Empty(t, searches)
+}
@@ -0,0 +1,99 @@
+package shard
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Map is the shard map file of the router, e.g.
+//
+//	virtual_nodes: 128
+//	shards:
+//	  - name: a
+//	    url: http://logsearch-a:8080
+//	  - name: b
+//	    url: http://logsearch-b:8080
+//	    weight: 2
+//	previous_shards:
+//	  - name: a
+//	    url: http://logsearch-a:8080
+//
+// PreviousShards is the ring before the latest change of Shards, set while
+// the users whose shard changed are moved to their new shard, and dropped
+// once every user was moved or the moves are no longer worth it.
+type Map struct {
+	VirtualNodes   int     `yaml:"virtual_nodes"`
+	Shards         []Shard `yaml:"shards"`
+	PreviousShards []Shard `yaml:"previous_shards"`
+}
+
+// LoadMap reads and validates a shard map file
+func LoadMap(path string) (Map, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Map{}, fmt.Errorf("failed to read shard map: %w", err)
+	}
+	var m Map
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	// An empty file is reported by Validate
+	if err := decoder.Decode(&m); err != nil && !errors.Is(err, io.EOF) {
+		return Map{}, fmt.Errorf("invalid shard map %s: %w", path, err)
+	}
+	if err := m.Validate(); err != nil {
+		return Map{}, fmt.Errorf("invalid shard map %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Validate reports every invalid setting of the map
+func (m Map) Validate() error {
+	var errs []error
+	if m.VirtualNodes < 0 {
+		errs = append(errs, errors.New("virtual_nodes must not be negative"))
+	}
+	if len(m.Shards) == 0 {
+		errs = append(errs, errors.New("shards must list at least one shard"))
+	}
+	errs = append(errs, validateShards("shards", m.Shards)...)
+	errs = append(errs, validateShards("previous_shards", m.PreviousShards)...)
+
+	// A shard kept across the change must keep its URL, or its users would
+	// be moved from a server which no longer holds them
+	urls := make(map[string]string, len(m.Shards))
+	for _, shard := range m.Shards {
+		urls[shard.Name] = shard.URL
+	}
+	for _, shard := range m.PreviousShards {
+		if u, ok := urls[shard.Name]; ok && u != shard.URL {
+			errs = append(errs, fmt.Errorf("previous_shards: shard %q must keep the url %s", shard.Name, u))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validateShards(key string, shards []Shard) []error {
+	var errs []error
+	names := make(map[string]bool, len(shards))
+	for i, shard := range shards {
+		if shard.Name == "" {
+			errs = append(errs, fmt.Errorf("%s[%d].name is required", key, i))
+		} else if names[shard.Name] {
+			errs = append(errs, fmt.Errorf("%s: shard %q is listed twice", key, shard.Name))
+		}
+		names[shard.Name] = true
+		if u, err := url.Parse(shard.URL); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s[%d].url must be an absolute URL, e.g. http://logsearch-a:8080", key, i))
+		}
+		if shard.Weight < 0 {
+			errs = append(errs, fmt.Errorf("%s[%d].weight must not be negative", key, i))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,57 @@
+package shard
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shards.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+virtual_nodes: 64
+shards:
+  - name: a
+    url: http://logsearch-a:8080
+  - name: b
+    url: http://logsearch-b:8080
+    weight: 2
+previous_shards:
+  - name: a
+    url: http://logsearch-a:8080
+`), 0o644))
+
+	m, err := LoadMap(path)
+	require.NoError(t, err)
+	assert.Equal(t, Map{
+		VirtualNodes:   64,
+		Shards:         []Shard{{Name: "a", URL: "http://logsearch-a:8080"}, {Name: "b", URL: "http://logsearch-b:8080", Weight: 2}},
+		PreviousShards: []Shard{{Name: "a", URL: "http://logsearch-a:8080"}},
+	}, m)
+
+	_, err = LoadMap(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+	require.NoError(t, os.WriteFile(path, []byte("shard:\n  - name: a\n"), 0o644))
+	_, err = LoadMap(path)
+	assert.Error(t, err, "unknown keys are rejected")
+}
+
+func TestMap_Validate(t *testing.T) {
+	for name, m := range map[string]Map{
+		"empty":           {},
+		"negative nodes":  {VirtualNodes: -1, Shards: []Shard{{Name: "a", URL: "http://a"}}},
+		"missing name":    {Shards: []Shard{{URL: "http://a"}}},
+		"duplicate name":  {Shards: []Shard{{Name: "a", URL: "http://a"}, {Name: "a", URL: "http://b"}}},
+		"relative url":    {Shards: []Shard{{Name: "a", URL: "logsearch-a:8080"}}},
+		"negative weight": {Shards: []Shard{{Name: "a", URL: "http://a", Weight: -1}}},
+		"moved url": {
+			Shards:         []Shard{{Name: "a", URL: "http://a"}},
+			PreviousShards: []Shard{{Name: "a", URL: "http://old-a"}},
+		},
+	} {
+		assert.Error(t, m.Validate(), name)
+	}
+}
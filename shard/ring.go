@@ -0,0 +1,114 @@
+// Package shard spreads the users over several logsearch-server instances so
+// the per-user consolidation scales out. A Ring assigns every user to one
+// shard by consistent hashing, and a Router in front of the shards forwards
+// every request of a user to their shard:
+//
+//	m, err := shard.LoadMap("shards.yaml")
+//	if err != nil {
+//		return err
+//	}
+//	router, err := shard.NewRouter(m, nil)
+//	http.ListenAndServe(":8080", router)
+//
+// All the searches of a user reach the same instance, so extending, ignoring
+// and merging their prefixes stays correct. Adding a shard only moves about
+// 1/N of the users, which the Router moves on their next request while the
+// map lists the previous shards.
+package shard
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// DefaultVirtualNodes is how many points a shard of weight 1 has on the ring
+// when the map does not say
+const DefaultVirtualNodes = 128
+
+// Shard is an instance of logsearch-server
+type Shard struct {
+	// Name identifies the shard on the ring, renaming a shard moves its users
+	Name string `yaml:"name"`
+	// URL is the base URL of its HTTP API, e.g. http://logsearch-a:8080
+	URL string `yaml:"url"`
+	// Weight scales the share of the users of the shard, 0 counts as 1
+	Weight int `yaml:"weight"`
+}
+
+// Ring maps keys to shards by consistent hashing, every shard owning
+// VirtualNodes times its weight points of the ring. It is immutable and safe
+// for concurrent use.
+type Ring struct {
+	shards []Shard
+	// points is sorted by hash, a key belongs to the first point at or after its hash
+	points []point
+}
+
+type point struct {
+	hash  uint64
+	shard int
+}
+
+// NewRing places the shards on a ring, virtualNodes 0 meaning DefaultVirtualNodes
+func NewRing(shards []Shard, virtualNodes int) (*Ring, error) {
+	if len(shards) == 0 {
+		return nil, errors.New("a ring needs at least one shard")
+	}
+	if virtualNodes <= 0 {
+		virtualNodes = DefaultVirtualNodes
+	}
+
+	r := &Ring{shards: append([]Shard(nil), shards...)}
+	names := make(map[string]bool, len(shards))
+	for i, shard := range shards {
+		if shard.Name == "" {
+			return nil, fmt.Errorf("shard %d has no name", i)
+		}
+		if names[shard.Name] {
+			return nil, fmt.Errorf("shard %q is listed twice", shard.Name)
+		}
+		names[shard.Name] = true
+		for v := 0; v < virtualNodes*max(shard.Weight, 1); v++ {
+			r.points = append(r.points, point{hash: hash(shard.Name + "#" + strconv.Itoa(v)), shard: i})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		return r.points[i].hash < r.points[j].hash
+	})
+	return r, nil
+}
+
+// Lookup returns the shard owning key, e.g. a user identifier
+func (r *Ring) Lookup(key string) Shard {
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.shards[r.points[i].shard]
+}
+
+// Shards returns the shards of the ring in the order they were given
+func (r *Ring) Shards() []Shard {
+	return append([]Shard(nil), r.shards...)
+}
+
+// hash is FNV-1a followed by the splitmix64 finalizer, which spreads the
+// close hashes of the similar virtual node names over the whole ring. It
+// never changes, or every router would move the users differently.
+func hash(key string) uint64 {
+	f := fnv.New64a()
+	f.Write([]byte(key))
+	x := f.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,64 @@
+package shard
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// owners counts the users of every shard of r
+func owners(r *Ring, users int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < users; i++ {
+		counts[r.Lookup(fmt.Sprintf("user_%d", i)).Name]++
+	}
+	return counts
+}
+
+func TestRing_Lookup(t *testing.T) {
+	shards := []Shard{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+	r, err := NewRing(shards, 0)
+	require.NoError(t, err)
+	assert.Equal(t, shards, r.Shards())
+
+	// The users spread evenly and always land on the same shard
+	for name, count := range owners(r, 30000) {
+		assert.InDelta(t, 10000, count, 1500, "shard %s", name)
+	}
+	assert.Equal(t, r.Lookup("user_1"), r.Lookup("user_1"))
+
+	// A shard of weight 2 gets twice the users
+	weighted, err := NewRing([]Shard{{Name: "a"}, {Name: "b", Weight: 2}}, 0)
+	require.NoError(t, err)
+	counts := owners(weighted, 30000)
+	assert.InDelta(t, 2, float64(counts["b"])/float64(counts["a"]), 0.4)
+}
+
+func TestRing_AddShard(t *testing.T) {
+	before, err := NewRing([]Shard{{Name: "a"}, {Name: "b"}, {Name: "c"}}, 0)
+	require.NoError(t, err)
+	after, err := NewRing([]Shard{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}}, 0)
+	require.NoError(t, err)
+
+	// Only the users of the new shard move, about a quarter of them
+	moved := 0
+	for i := 0; i < 20000; i++ {
+		user := fmt.Sprintf("user_%d", i)
+		if owner := after.Lookup(user); owner != before.Lookup(user) {
+			assert.Equal(t, "d", owner.Name)
+			moved++
+		}
+	}
+	assert.InDelta(t, 5000, moved, 1000)
+}
+
+func TestNewRing_Invalid(t *testing.T) {
+	_, err := NewRing(nil, 0)
+	assert.Error(t, err)
+	_, err = NewRing([]Shard{{Name: "a"}, {Name: ""}}, 0)
+	assert.Error(t, err)
+	_, err = NewRing([]Shard{{Name: "a"}, {Name: "a"}}, 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,616 @@
+package shard
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/server"
+)
+
+// maxRoutedBodyBytes bounds the bodies the router reads to find their users
+const maxRoutedBodyBytes = 1 << 20
+
+// routerActor names the router in the audit logs of the shards for the moves
+const routerActor = "logsearch-router"
+
+// Router serves the search API of logsearch-server in front of the shards:
+//
+//   - the requests of a user, found in their user_id, are forwarded to the
+//     shard owning the user: POST /search/log, GET and DELETE /search/user,
+//     /search/user/export, /search/user/restore, GET /search/suggest?user_id=
+//     and the WebSocket of GET /search/stream
+//   - POST /search/import is split by shard
+//   - POST /search/user/merge first moves the anon_id to the shard of the
+//     user_id when they differ
+//   - GET /search/top and GET /search/suggest without user_id merge the
+//     answers of every shard, the top counts being summed
+//   - POST /search/purge, /search/words/merge and /search/words/rename are
+//     applied by every shard
+//
+// The other endpoints answer 501, they are served by the shards themselves.
+//
+// While the map lists previous shards, a user whose shard changed is moved
+// from their previous shard before their first request is forwarded: their
+// history is exported, restored on the new shard and deleted from the old
+// one. The searches still pending on the old shard, typed just before the
+// move, are lost.
+type Router struct {
+	ring *Ring
+	// previous is the ring before the latest change, nil unless rebalancing
+	previous *Ring
+	// backends holds every shard of both rings by name
+	backends   map[string]*backend
+	httpClient *http.Client
+	mux        *http.ServeMux
+
+	// moved holds the users known to be on their current shard while rebalancing
+	moved sync.Map
+	// locks serializes the moves of a user, striped by user
+	locks [64]sync.Mutex
+}
+
+// backend forwards the requests of a shard
+type backend struct {
+	shard  Shard
+	target *url.URL
+	proxy  *httputil.ReverseProxy
+	client *server.Client
+}
+
+// NewRouter creates the Router of the shards of m. httpClient may be nil to
+// use http.DefaultClient, its transport also carrying the forwarded requests.
+func NewRouter(m Map, httpClient *http.Client) (*Router, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	ring, err := NewRing(m.Shards, m.VirtualNodes)
+	if err != nil {
+		return nil, err
+	}
+	r := &Router{ring: ring, backends: make(map[string]*backend), httpClient: httpClient}
+	if len(m.PreviousShards) > 0 {
+		if r.previous, err = NewRing(m.PreviousShards, m.VirtualNodes); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, shard := range append(append([]Shard(nil), m.Shards...), m.PreviousShards...) {
+		if _, ok := r.backends[shard.Name]; ok {
+			continue
+		}
+		target, err := url.Parse(strings.TrimSuffix(shard.URL, "/"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid url of shard %q: %w", shard.Name, err)
+		}
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		proxy.Transport = httpClient.Transport
+		proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+			log.Printf("Error forwarding %s %s to shard %s: %v", req.Method, req.URL.Path, shard.Name, err)
+			writeError(w, http.StatusBadGateway, fmt.Sprintf("shard %s is unavailable", shard.Name))
+		}
+		client := server.NewClient(shard.URL, httpClient)
+		client.SetActor(routerActor)
+		r.backends[shard.Name] = &backend{shard: shard, target: target, proxy: proxy, client: client}
+	}
+
+	r.mux = http.NewServeMux()
+	r.mux.HandleFunc("/search/log", r.handleLog)
+	r.mux.HandleFunc("/search/import", r.handleImport)
+	r.mux.HandleFunc("/search/purge", r.handlePurge)
+	r.mux.HandleFunc("/search/user", r.handleUser)
+	r.mux.HandleFunc("/search/user/export", r.handleUser)
+	r.mux.HandleFunc("/search/user/restore", r.handleUser)
+	r.mux.HandleFunc("/search/user/merge", r.handleMerge)
+	r.mux.HandleFunc("/search/stream", r.handleUser)
+	r.mux.HandleFunc("/search/suggest", r.handleSuggest)
+	r.mux.HandleFunc("/search/top", r.handleTop)
+	r.mux.HandleFunc("/search/words/rename", r.handleCurate)
+	r.mux.HandleFunc("/search/words/merge", r.handleCurate)
+	r.mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, server.StatusResponse{Status: "ok"})
+	})
+	r.mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		writeError(w, http.StatusNotImplemented, fmt.Sprintf("%s is not served by the shard router, query the shards", req.URL.Path))
+	})
+	return r, nil
+}
+
+// ServeHTTP implements http.Handler
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mux.ServeHTTP(w, req)
+}
+
+// Owner returns the shard owning the user on the current ring
+func (r *Router) Owner(userIdentifier string) Shard {
+	return r.ring.Lookup(userIdentifier)
+}
+
+// owner returns the backend of the shard owning the user, first moving the
+// user from their previous shard while rebalancing
+func (r *Router) owner(ctx context.Context, userIdentifier string) (*backend, error) {
+	current := r.backends[r.ring.Lookup(userIdentifier).Name]
+	if r.previous == nil {
+		return current, nil
+	}
+	previous := r.backends[r.previous.Lookup(userIdentifier).Name]
+	if previous == current {
+		return current, nil
+	}
+	if _, ok := r.moved.Load(userIdentifier); ok {
+		return current, nil
+	}
+
+	lock := &r.locks[hash(userIdentifier)%uint64(len(r.locks))]
+	lock.Lock()
+	defer lock.Unlock()
+	if _, ok := r.moved.Load(userIdentifier); ok {
+		return current, nil
+	}
+	if err := r.move(ctx, userIdentifier, previous, current); err != nil {
+		return nil, err
+	}
+	r.moved.Store(userIdentifier, struct{}{})
+	return current, nil
+}
+
+// move copies the history of a user from one shard to another then deletes it
+// from the first. Restoring is idempotent, so a move failing midway is
+// retried by the next request of the user.
+func (r *Router) move(ctx context.Context, userIdentifier string, from, to *backend) error {
+	var history bytes.Buffer
+	if err := from.client.Export(ctx, userIdentifier, logsearch.ExportJSONLines, &history); err != nil {
+		return fmt.Errorf("failed to export %s from shard %s: %w", userIdentifier, from.shard.Name, err)
+	}
+	if history.Len() == 0 {
+		return nil
+	}
+	restored, err := to.client.RestoreUser(ctx, userIdentifier, &history)
+	if err != nil {
+		return fmt.Errorf("failed to restore %s on shard %s: %w", userIdentifier, to.shard.Name, err)
+	}
+	if _, err := from.client.DeleteUser(ctx, userIdentifier); err != nil {
+		return fmt.Errorf("failed to delete %s from shard %s: %w", userIdentifier, from.shard.Name, err)
+	}
+	log.Printf("Moved %d searches of %s from shard %s to %s", restored, userIdentifier, from.shard.Name, to.shard.Name)
+	return nil
+}
+
+// forwardToOwner forwards the request to the shard of the user
+func (r *Router) forwardToOwner(w http.ResponseWriter, req *http.Request, userIdentifier string) {
+	b, err := r.owner(req.Context(), userIdentifier)
+	if err != nil {
+		log.Printf("Error routing %s %s: %v", req.Method, req.URL.Path, err)
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+	b.proxy.ServeHTTP(w, req)
+}
+
+// handleUser routes the requests naming their user in the user_id query parameter
+func (r *Router) handleUser(w http.ResponseWriter, req *http.Request) {
+	userID := req.URL.Query().Get("user_id")
+	if userID == "" {
+		writeError(w, http.StatusBadRequest, "user_id is required")
+		return
+	}
+	r.forwardToOwner(w, req, userID)
+}
+
+// handleLog routes POST /search/log by the user_id of its body
+func (r *Router) handleLog(w http.ResponseWriter, req *http.Request) {
+	var body server.LogSearchRequest
+	if !readBody(w, req, &body) {
+		return
+	}
+	if strings.TrimSpace(body.UserID) == "" {
+		writeError(w, http.StatusBadRequest, "user_id and query are required")
+		return
+	}
+	r.forwardToOwner(w, req, body.UserID)
+}
+
+// handleMerge handles POST /search/user/merge, moving the history of the
+// anon_id to the shard of the user_id before the shard merges them
+func (r *Router) handleMerge(w http.ResponseWriter, req *http.Request) {
+	var body server.MergeUserRequest
+	if !readBody(w, req, &body) {
+		return
+	}
+	if strings.TrimSpace(body.AnonID) == "" || strings.TrimSpace(body.UserID) == "" {
+		writeError(w, http.StatusBadRequest, "anon_id and user_id are required")
+		return
+	}
+
+	user, err := r.owner(req.Context(), body.UserID)
+	if err == nil {
+		var anon *backend
+		if anon, err = r.owner(req.Context(), body.AnonID); err == nil && anon != user {
+			err = r.move(req.Context(), body.AnonID, anon, user)
+		}
+	}
+	if err != nil {
+		log.Printf("Error routing %s %s: %v", req.Method, req.URL.Path, err)
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+	user.proxy.ServeHTTP(w, req)
+}
+
+// handleImport handles POST /search/import, importing the searches of every
+// shard in one request. The body is validated before any shard imports it.
+func (r *Router) handleImport(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	batches := make(map[*backend]*bytes.Buffer)
+	owners := make(map[string]*backend)
+	decoder := json.NewDecoder(req.Body)
+	for line := 1; ; line++ {
+		var search server.LogSearchRequest
+		err := decoder.Decode(&search)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("search %d: invalid JSON, 0 searches imported", line))
+			return
+		}
+		if strings.TrimSpace(search.UserID) == "" || strings.TrimSpace(search.Query) == "" {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("search %d: user_id and query are required, 0 searches imported", line))
+			return
+		}
+
+		b, ok := owners[search.UserID]
+		if !ok {
+			if b, err = r.owner(req.Context(), search.UserID); err != nil {
+				writeError(w, statusForError(err), fmt.Sprintf("%v, 0 searches imported", err))
+				return
+			}
+			owners[search.UserID] = b
+		}
+		if batches[b] == nil {
+			batches[b] = &bytes.Buffer{}
+		}
+		json.NewEncoder(batches[b]).Encode(search)
+	}
+
+	requests := make(map[*backend][]byte, len(batches))
+	for b, batch := range batches {
+		requests[b] = batch.Bytes()
+	}
+	var imported int64
+	var errs []error
+	for _, resp := range r.fanOut(req, requests) {
+		var body server.ImportResponse
+		if err := resp.decode(&body); err != nil {
+			errs = append(errs, fmt.Errorf("shard %s: %w", resp.backend.shard.Name, err))
+			continue
+		}
+		imported += body.Imported
+	}
+	if err := errors.Join(errs...); err != nil {
+		writeError(w, statusForError(err), fmt.Sprintf("%v, %d searches imported by the other shards", err, imported))
+		return
+	}
+	writeJSON(w, http.StatusOK, server.ImportResponse{Imported: imported})
+}
+
+// handleTop handles GET /search/top, summing the counts of every shard. Every
+// shard returns its own top words, so a word just below the limit of each
+// shard may be missing from the merged ranking.
+func (r *Router) handleTop(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	var merged server.TopSearchesResponse
+	byWord := make(map[string]*server.TopSearch)
+	scored := false
+	limit := 0
+	for _, resp := range r.fanOut(req, r.everyShard(nil)) {
+		var body server.TopSearchesResponse
+		if err := resp.decode(&body); err != nil {
+			resp.write(w, err)
+			return
+		}
+		merged.Window = body.Window
+		limit = max(limit, len(body.Searches))
+		for _, search := range body.Searches {
+			scored = scored || search.Score > 0
+			total, ok := byWord[search.Word]
+			if !ok {
+				total = &server.TopSearch{Word: search.Word}
+				byWord[search.Word] = total
+			}
+			// The users are partitioned by shard, their audiences add up
+			total.Count += search.Count
+			total.Score += search.Score
+			total.Users += search.Users
+		}
+	}
+
+	byUsers := req.URL.Query().Get("rank") == "users"
+	merged.Searches = make([]server.TopSearch, 0, len(byWord))
+	for _, search := range byWord {
+		merged.Searches = append(merged.Searches, *search)
+	}
+	sort.Slice(merged.Searches, func(i, j int) bool {
+		a, b := merged.Searches[i], merged.Searches[j]
+		switch {
+		case byUsers && a.Users != b.Users:
+			return a.Users > b.Users
+		case !byUsers && scored && a.Score != b.Score:
+			return a.Score > b.Score
+		case !byUsers && a.Count != b.Count:
+			return a.Count > b.Count
+		}
+		return a.Word < b.Word
+	})
+	if len(merged.Searches) > limit {
+		merged.Searches = merged.Searches[:limit]
+	}
+	writeJSON(w, http.StatusOK, merged)
+}
+
+// handleSuggest handles GET /search/suggest, forwarded to the shard of the
+// user with user_id, otherwise merging the suggestions of every shard rank by
+// rank
+func (r *Router) handleSuggest(w http.ResponseWriter, req *http.Request) {
+	if userID := strings.TrimSpace(req.URL.Query().Get("user_id")); userID != "" {
+		r.forwardToOwner(w, req, userID)
+		return
+	}
+	if req.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	var answers []server.SuggestResponse
+	limit := 0
+	for _, resp := range r.fanOut(req, r.everyShard(nil)) {
+		var body server.SuggestResponse
+		if err := resp.decode(&body); err != nil {
+			resp.write(w, err)
+			return
+		}
+		answers = append(answers, body)
+		limit = max(limit, len(body.Suggestions))
+	}
+
+	merged := server.SuggestResponse{Prefix: answers[0].Prefix, Suggestions: []string{}}
+	seen := make(map[string]bool)
+	for rank := 0; rank < limit && len(merged.Suggestions) < limit; rank++ {
+		for _, answer := range answers {
+			if rank < len(answer.Suggestions) && !seen[answer.Suggestions[rank]] && len(merged.Suggestions) < limit {
+				seen[answer.Suggestions[rank]] = true
+				merged.Suggestions = append(merged.Suggestions, answer.Suggestions[rank])
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, merged)
+}
+
+// handlePurge handles POST /search/purge, purging every shard
+func (r *Router) handlePurge(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var merged server.PurgeResponse
+	for _, resp := range r.fanOut(req, r.everyShard(nil)) {
+		var body server.PurgeResponse
+		if err := resp.decode(&body); err != nil {
+			resp.write(w, err)
+			return
+		}
+		merged.Before = body.Before
+		merged.UserSearches += body.UserSearches
+		merged.Words += body.Words
+	}
+	writeJSON(w, http.StatusOK, merged)
+}
+
+// handleCurate handles POST /search/words/rename and /search/words/merge,
+// curating the word on every shard. It succeeds once a shard stored the word,
+// 404 meaning that no shard did. A failing shard is reported after the others
+// applied the change, retrying is safe.
+func (r *Router) handleCurate(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxRoutedBodyBytes))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	var applied, notFound *shardResponse
+	for _, resp := range r.fanOut(req, r.everyShard(body)) {
+		resp := resp
+		err := resp.decode(nil)
+		var apiErr *server.APIError
+		switch {
+		case err == nil:
+			applied = &resp
+		case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+			notFound = &resp
+		default:
+			resp.write(w, err)
+			return
+		}
+	}
+	if applied == nil {
+		applied = notFound
+	}
+	applied.relay(w)
+}
+
+// shardResponse is the answer of a shard to a request fanned out by the router
+type shardResponse struct {
+	backend *backend
+	status  int
+	header  http.Header
+	body    []byte
+	err     error
+}
+
+// decode decodes a 2xx JSON answer into out unless it is nil, returning an
+// *server.APIError for the other statuses
+func (resp shardResponse) decode(out any) error {
+	if resp.err != nil {
+		return fmt.Errorf("shard %s is unavailable: %w", resp.backend.shard.Name, resp.err)
+	}
+	if resp.status/100 != 2 {
+		apiErr := &server.APIError{StatusCode: resp.status, Message: http.StatusText(resp.status)}
+		var errResp server.ErrorResponse
+		if json.Unmarshal(resp.body, &errResp) == nil && errResp.Error != "" {
+			apiErr.Message = errResp.Error
+		}
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(resp.body, out); err != nil {
+		return fmt.Errorf("invalid response of shard %s: %w", resp.backend.shard.Name, err)
+	}
+	return nil
+}
+
+// write answers the failure of a shard, relaying its own error responses
+func (resp shardResponse) write(w http.ResponseWriter, err error) {
+	var apiErr *server.APIError
+	if errors.As(err, &apiErr) {
+		resp.relay(w)
+		return
+	}
+	log.Printf("Error forwarding to shard %s: %v", resp.backend.shard.Name, err)
+	writeError(w, http.StatusBadGateway, err.Error())
+}
+
+// relay writes the answer of the shard as is
+func (resp shardResponse) relay(w http.ResponseWriter) {
+	if contentType := resp.header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(resp.status)
+	w.Write(resp.body)
+}
+
+// everyShard sends the same body to every shard of both rings, the users not
+// moved yet still living on their previous shard
+func (r *Router) everyShard(body []byte) map[*backend][]byte {
+	requests := make(map[*backend][]byte, len(r.backends))
+	for _, b := range r.backends {
+		requests[b] = body
+	}
+	return requests
+}
+
+// fanOut sends a copy of req with the given body to each shard concurrently,
+// the answers being sorted by shard name
+func (r *Router) fanOut(req *http.Request, requests map[*backend][]byte) []shardResponse {
+	responses := make([]shardResponse, 0, len(requests))
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for b, body := range requests {
+		wg.Add(1)
+		go func(b *backend, body []byte) {
+			defer wg.Done()
+			resp := r.send(req, b, body)
+			mutex.Lock()
+			responses = append(responses, resp)
+			mutex.Unlock()
+		}(b, body)
+	}
+	wg.Wait()
+	sort.Slice(responses, func(i, j int) bool {
+		return responses[i].backend.shard.Name < responses[j].backend.shard.Name
+	})
+	return responses
+}
+
+// send forwards a copy of req with body to a shard, keeping its headers such
+// as the actor of the audit log
+func (r *Router) send(req *http.Request, b *backend, body []byte) shardResponse {
+	target := *b.target
+	target.Path = b.target.Path + req.URL.Path
+	target.RawQuery = req.URL.RawQuery
+	out, err := http.NewRequestWithContext(req.Context(), req.Method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return shardResponse{backend: b, err: err}
+	}
+	out.Header = req.Header.Clone()
+	out.Header.Del("Content-Length")
+
+	resp, err := r.httpClient.Do(out)
+	if err != nil {
+		return shardResponse{backend: b, err: err}
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return shardResponse{backend: b, status: resp.StatusCode, header: resp.Header, body: data, err: err}
+}
+
+// readBody decodes the JSON body of a POST request forwarded to a shard and
+// rewinds it for the shard, answering the invalid requests
+func readBody(w http.ResponseWriter, req *http.Request, out any) bool {
+	if req.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return false
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxRoutedBodyBytes))
+	if err != nil || json.Unmarshal(body, out) != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return false
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	return true
+}
+
+// statusForError answers a failed move with the status of the shard for its
+// client errors, 502 otherwise
+func statusForError(err error) int {
+	var apiErr *server.APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode/100 == 4 {
+		return apiErr.StatusCode
+	}
+	return http.StatusBadGateway
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, server.ErrorResponse{Error: message})
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
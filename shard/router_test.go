@@ -0,0 +1,155 @@
+package shard
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/server"
+)
+
+// testShards starts a logsearch-server per name, returning their map and loggers
+func testShards(t *testing.T, names ...string) ([]Shard, map[string]*logsearch.SearchLoggerV2) {
+	var shards []Shard
+	loggers := make(map[string]*logsearch.SearchLoggerV2)
+	for _, name := range names {
+		logger, err := logsearch.NewSearchLoggerV2()
+		require.NoError(t, err)
+		t.Cleanup(func() { logger.Close() })
+		srv := httptest.NewServer(server.NewHandler(logger, nil))
+		t.Cleanup(srv.Close)
+		shards = append(shards, Shard{Name: name, URL: srv.URL})
+		loggers[name] = logger
+	}
+	return shards, loggers
+}
+
+// testRouter serves a Router of m, returning a client of its API
+func testRouter(t *testing.T, m Map) (*Router, *server.Client, string) {
+	router, err := NewRouter(m, nil)
+	require.NoError(t, err)
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return router, server.NewClient(srv.URL, nil), srv.URL
+}
+
+func logSearch(t *testing.T, routerURL, userID, query string) {
+	resp, err := http.Post(routerURL+"/search/log", "application/json",
+		strings.NewReader(fmt.Sprintf(`{"user_id":%q,"query":%q}`, userID, query)))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+// userSearches returns the searches of a user stored by every shard, sorted
+func userSearches(t *testing.T, loggers map[string]*logsearch.SearchLoggerV2, userID string) map[string][]string {
+	stored := make(map[string][]string)
+	for name, logger := range loggers {
+		searches, err := logger.GetUserSearches(context.Background(), userID)
+		require.NoError(t, err)
+		if len(searches) > 0 {
+			sort.Strings(searches)
+			stored[name] = searches
+		}
+	}
+	return stored
+}
+
+func TestRouter_RoutesByUser(t *testing.T) {
+	ctx := context.Background()
+	shards, loggers := testShards(t, "a", "b", "c")
+	router, client, routerURL := testRouter(t, Map{Shards: shards})
+
+	// The searches of a user only reach their shard, where their prefixes are consolidated
+	for i := 0; i < 12; i++ {
+		user := fmt.Sprintf("user_%d", i)
+		logSearch(t, routerURL, user, "bu")
+		logSearch(t, routerURL, user, "bus")
+		assert.Equal(t, map[string][]string{router.Owner(user).Name: {"bus"}}, userSearches(t, loggers, user))
+
+		searches, err := client.UserSearches(ctx, user)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"bus"}, searches)
+	}
+
+	// Importing splits the searches by shard
+	imported, err := client.Import(ctx, strings.NewReader(`{"user_id":"user_0","query":"cat"}`+"\n"+`{"user_id":"user_5","query":"cat"}`+"\n"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), imported)
+	assert.Equal(t, map[string][]string{router.Owner("user_5").Name: {"bus", "cat"}}, userSearches(t, loggers, "user_5"))
+
+	// The top searches add up the counts of the shards, the extended prefix bu counting for bus
+	top, err := client.Top(ctx, 10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []server.TopSearch{{Word: "bus", Count: 24}, {Word: "cat", Count: 2}}, top)
+
+	// A user deleted through the router is gone from their shard
+	deleted, err := client.DeleteUser(ctx, "user_0")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+	assert.Empty(t, userSearches(t, loggers, "user_0"))
+
+	resp, err := http.Get(routerURL + "/search/stored")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+	_, err = client.UserSearches(ctx, "")
+	assert.Error(t, err)
+}
+
+func TestRouter_MergeAcrossShards(t *testing.T) {
+	ctx := context.Background()
+	shards, loggers := testShards(t, "a", "b")
+	router, client, routerURL := testRouter(t, Map{Shards: shards})
+
+	// Find a guest living on another shard than the user
+	anonID := "anon_0"
+	for i := 1; router.Owner(anonID) == router.Owner("user_1"); i++ {
+		anonID = fmt.Sprintf("anon_%d", i)
+	}
+	logSearch(t, routerURL, anonID, "bus")
+	logSearch(t, routerURL, "user_1", "cat")
+
+	require.NoError(t, client.MergeUsers(ctx, anonID, "user_1"))
+	assert.Empty(t, userSearches(t, loggers, anonID))
+	stored := userSearches(t, loggers, "user_1")
+	assert.ElementsMatch(t, []string{"bus", "cat"}, stored[router.Owner("user_1").Name])
+	assert.Len(t, stored, 1)
+}
+
+func TestRouter_Rebalance(t *testing.T) {
+	ctx := context.Background()
+	shards, loggers := testShards(t, "a", "b", "c")
+	before, _, beforeURL := testRouter(t, Map{Shards: shards[:2]})
+	for i := 0; i < 20; i++ {
+		logSearch(t, beforeURL, fmt.Sprintf("user_%d", i), "bus")
+	}
+
+	// Adding shard c moves its users on their next request
+	after, client, _ := testRouter(t, Map{Shards: shards, PreviousShards: shards[:2]})
+	moved := 0
+	for i := 0; i < 20; i++ {
+		user := fmt.Sprintf("user_%d", i)
+		if after.Owner(user) != before.Owner(user) {
+			moved++
+		}
+		searches, err := client.UserSearches(ctx, user)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"bus"}, searches)
+		assert.Equal(t, map[string][]string{after.Owner(user).Name: {"bus"}}, userSearches(t, loggers, user))
+	}
+	assert.Positive(t, moved)
+
+	// The moved searches are counted once
+	top, err := client.Top(ctx, 10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []server.TopSearch{{Word: "bus", Count: 20}}, top)
+}
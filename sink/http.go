@@ -0,0 +1,90 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// StatusError is returned by the HTTP transports when the sink answered a non 2xx status
+type StatusError struct {
+	StatusCode int
+	// Body is the start of the response body, for the logs
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("sink answered %d: %s", e.StatusCode, e.Body)
+}
+
+// Temporary reports whether the batch may succeed later: on timeouts, rate
+// limits and server errors. Other client errors reject the batch for good.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// HTTPTransport POSTs every batch to a URL as JSON Lines, one event per line
+type HTTPTransport struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPTransport delivers the batches to url, client may be nil for http.DefaultClient
+func NewHTTPTransport(url string, client *http.Client) *HTTPTransport {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPTransport{url: url, client: client}
+}
+
+// NewClickHouseTransport inserts the batches into table through the HTTP
+// interface of ClickHouse at baseURL, e.g. http://localhost:8123, with
+// INSERT ... FORMAT JSONEachRow. The table needs the columns user_identifier
+// String, word String, replaced_words Array(String), count UInt32 and at
+// DateTime64(3).
+func NewClickHouseTransport(baseURL, table string, client *http.Client) (*HTTPTransport, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ClickHouse URL: %w", err)
+	}
+	query := u.Query()
+	query.Set("query", "INSERT INTO "+table+" FORMAT JSONEachRow")
+	// The times are RFC 3339
+	query.Set("date_time_input_format", "best_effort")
+	u.RawQuery = query.Encode()
+	return NewHTTPTransport(u.String(), client), nil
+}
+
+// Send POSTs the events, failing unless the sink answers 2xx
+func (t *HTTPTransport) Send(ctx context.Context, events []Event) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, event := range events {
+		if err := enc.Encode(event); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &StatusError{StatusCode: resp.StatusCode, Body: string(excerpt)}
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,228 @@
+// Package sink streams the words finalized by the loggers to an analytical
+// store, e.g. ClickHouse or any service accepting JSON batches over HTTP. A
+// Sink is registered as a hook of the loggers, which call it after the store
+// committed the word. It queues the events in memory and delivers them in
+// batches from its own goroutine, retrying failed batches with exponential
+// backoff: every event is delivered at least once, unless the process dies or
+// Close gives up first.
+package sink
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/afanwang/logsearch"
+)
+
+// Event is a finalized word as delivered to the sink
+type Event struct {
+	// UserIdentifier is the user who searched the word, empty for the global trie logger
+	UserIdentifier string    `json:"user_identifier"`
+	Word           string    `json:"word"`
+	ReplacedWords  []string  `json:"replaced_words"`
+	Count          int       `json:"count"`
+	At             time.Time `json:"at"`
+}
+
+// Transport delivers a batch of events. An error retries the batch, unless it
+// is a *StatusError that is not Temporary.
+type Transport interface {
+	Send(ctx context.Context, events []Event) error
+}
+
+// Sink batches the finalized words and delivers them through a Transport. It is safe for concurrent use.
+type Sink struct {
+	transport  Transport
+	batchSize  int
+	interval   time.Duration
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	queue chan Event
+	// closing is closed by Close, releasing the hooks blocked on a full queue
+	closing   chan struct{}
+	closeOnce sync.Once
+	// abort cancels the delivery once Close gave up
+	abort       context.Context
+	cancelAbort context.CancelFunc
+	done        chan struct{}
+}
+
+// Option configures optional Sink behavior
+type Option func(*Sink)
+
+// WithBatchSize sends a batch once it holds size events, 500 by default
+func WithBatchSize(size int) Option {
+	return func(s *Sink) {
+		if size > 0 {
+			s.batchSize = size
+		}
+	}
+}
+
+// WithFlushInterval sends the events queued for interval even if the batch is not full, 1s by default
+func WithFlushInterval(interval time.Duration) Option {
+	return func(s *Sink) {
+		if interval > 0 {
+			s.interval = interval
+		}
+	}
+}
+
+// WithQueueSize bounds the events waiting for delivery, 10000 by default. The
+// hook blocks while the queue is full rather than dropping events, slowing the
+// loggers down until the sink recovers or is closed.
+func WithQueueSize(size int) Option {
+	return func(s *Sink) {
+		if size > 0 {
+			s.queue = make(chan Event, size)
+		}
+	}
+}
+
+// WithBackoff waits min before retrying a failed batch, doubling the wait up to max, 100ms and 30s by default
+func WithBackoff(min, max time.Duration) Option {
+	return func(s *Sink) {
+		if min > 0 && max >= min {
+			s.minBackoff, s.maxBackoff = min, max
+		}
+	}
+}
+
+// New starts a sink delivering through transport, stop it with Close
+func New(transport Transport, opts ...Option) *Sink {
+	s := &Sink{
+		transport:  transport,
+		batchSize:  500,
+		interval:   time.Second,
+		minBackoff: 100 * time.Millisecond,
+		maxBackoff: 30 * time.Second,
+		queue:      make(chan Event, 10000),
+		closing:    make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.abort, s.cancelAbort = context.WithCancel(context.Background())
+
+	go s.run()
+	return s
+}
+
+// Hook returns the hook feeding the sink, register it with
+// logsearch.WithWordFinalizedHook and trie.WithWordFinalizedHook. Words
+// finalized after Close are dropped, as are those blocked on a full queue
+// when Close is called.
+func (s *Sink) Hook() logsearch.WordFinalizedHook {
+	return func(word logsearch.FinalizedWord) {
+		select {
+		case <-s.closing:
+			return
+		default:
+		}
+		event := Event{
+			UserIdentifier: word.UserIdentifier,
+			Word:           word.Word,
+			ReplacedWords:  word.ReplacedWords,
+			Count:          word.Count,
+			At:             word.At.UTC(),
+		}
+		select {
+		case s.queue <- event:
+		case <-s.closing:
+		}
+	}
+}
+
+// Close delivers the queued events and stops the sink. Once ctx is done it
+// stops retrying, drops what is left and returns the error of ctx. Close the
+// loggers first, so that their last words reach the sink.
+func (s *Sink) Close(ctx context.Context) error {
+	s.closeOnce.Do(func() { close(s.closing) })
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		s.cancelAbort()
+		<-s.done
+		return ctx.Err()
+	}
+}
+
+// run batches the queued events until the sink is closed
+func (s *Sink) run() {
+	defer close(s.done)
+	defer s.cancelAbort()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	batch := make([]Event, 0, s.batchSize)
+	for {
+		select {
+		case event := <-s.queue:
+			batch = append(batch, event)
+			if len(batch) < s.batchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-s.closing:
+			s.drain(batch)
+			return
+		}
+		s.deliver(batch)
+		batch = batch[:0]
+	}
+}
+
+// drain delivers batch and the events left in the queue once the sink is closed
+func (s *Sink) drain(batch []Event) {
+	for {
+		select {
+		case event := <-s.queue:
+			batch = append(batch, event)
+			if len(batch) < s.batchSize {
+				continue
+			}
+		default:
+			s.deliver(batch)
+			return
+		}
+		s.deliver(batch)
+		batch = batch[:0]
+	}
+}
+
+// deliver sends the batch until it succeeds, fails permanently or the sink is aborted
+func (s *Sink) deliver(batch []Event) {
+	if len(batch) == 0 {
+		return
+	}
+
+	backoff := s.minBackoff
+	for {
+		err := s.transport.Send(s.abort, batch)
+		if err == nil {
+			return
+		}
+		var status *StatusError
+		if errors.As(err, &status) && !status.Temporary() {
+			log.Printf("Dropping %d events rejected by the sink: %v", len(batch), err)
+			return
+		}
+		log.Printf("Failed to deliver %d events to the sink, retrying in %s: %v", len(batch), backoff, err)
+
+		select {
+		case <-time.After(backoff):
+			backoff = min(2*backoff, s.maxBackoff)
+		case <-s.abort.Done():
+			log.Printf("Dropping %d undelivered events on close", len(batch))
+			return
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// collector is an HTTP sink failing the first requests with status
+type collector struct {
+	mutex    sync.Mutex
+	failures int
+	status   int
+	words    []string
+	batches  int
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.failures > 0 {
+		c.failures--
+		w.WriteHeader(c.status)
+		return
+	}
+	c.batches++
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		c.words = append(c.words, event.Word)
+	}
+}
+
+func (c *collector) received() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]string(nil), c.words...)
+}
+
+func TestSinkRetriesUntilDelivered(t *testing.T) {
+	c := &collector{failures: 2, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	s := New(NewHTTPTransport(server.URL, nil), WithBatchSize(2), WithFlushInterval(time.Hour), WithBackoff(time.Millisecond, 5*time.Millisecond))
+	hook := s.Hook()
+	for _, word := range []string{"bus", "cat", "dog"} {
+		hook(logsearch.FinalizedWord{UserIdentifier: "user_1", Word: word, Count: 1, At: time.Now()})
+	}
+
+	// The full batch is retried until delivered, the last event waits for Close
+	assert.Eventually(t, func() bool { return len(c.received()) == 2 }, time.Second, time.Millisecond)
+	require.NoError(t, s.Close(context.Background()))
+	assert.Equal(t, []string{"bus", "cat", "dog"}, c.received())
+	assert.Equal(t, 2, c.batches)
+
+	// Words finalized after Close are dropped
+	hook(logsearch.FinalizedWord{Word: "late"})
+	assert.NoError(t, s.Close(context.Background()))
+}
+
+func TestSinkFlushInterval(t *testing.T) {
+	c := &collector{}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	s := New(NewHTTPTransport(server.URL, nil), WithFlushInterval(5*time.Millisecond))
+	defer s.Close(context.Background())
+	s.Hook()(logsearch.FinalizedWord{Word: "bus"})
+	assert.Eventually(t, func() bool { return len(c.received()) == 1 }, time.Second, time.Millisecond)
+}
+
+func TestSinkDropsRejectedBatches(t *testing.T) {
+	c := &collector{failures: 1, status: http.StatusBadRequest}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	s := New(NewHTTPTransport(server.URL, nil), WithBatchSize(1))
+	hook := s.Hook()
+	hook(logsearch.FinalizedWord{Word: "bad"})
+	hook(logsearch.FinalizedWord{Word: "good"})
+	require.NoError(t, s.Close(context.Background()))
+	assert.Equal(t, []string{"good"}, c.received())
+}
+
+func TestSinkCloseGivesUp(t *testing.T) {
+	c := &collector{failures: 1000, status: http.StatusInternalServerError}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	s := New(NewHTTPTransport(server.URL, nil), WithBackoff(time.Millisecond, time.Millisecond))
+	s.Hook()(logsearch.FinalizedWord{Word: "bus"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.True(t, errors.Is(s.Close(ctx), context.DeadlineExceeded))
+	assert.Empty(t, c.received())
+}
+
+func TestSinkCloseWithFullQueue(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	s := New(NewHTTPTransport(server.URL, nil), WithBatchSize(1), WithQueueSize(1), WithBackoff(time.Millisecond, time.Millisecond))
+	hook := s.Hook()
+	hook(logsearch.FinalizedWord{Word: "bus"})
+	hook(logsearch.FinalizedWord{Word: "cat"})
+	blocked := make(chan struct{})
+	go func() {
+		defer close(blocked)
+		hook(logsearch.FinalizedWord{Word: "dog"})
+	}()
+	// Let the hook block on the full queue
+	time.Sleep(10 * time.Millisecond)
+
+	// Close neither waits for the blocked hook nor for the dead endpoint
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	closed := make(chan error, 1)
+	go func() { closed <- s.Close(ctx) }()
+	select {
+	case err := <-closed:
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(time.Second):
+		t.Fatal("Close deadlocked")
+	}
+	select {
+	case <-blocked:
+	case <-time.After(time.Second):
+		t.Fatal("The hook is still blocked")
+	}
+}
+
+func TestClickHouseTransport(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("query")
+	}))
+	defer server.Close()
+
+	transport, err := NewClickHouseTransport(server.URL, "searches", nil)
+	require.NoError(t, err)
+	require.NoError(t, transport.Send(context.Background(), []Event{{Word: "bus"}}))
+	assert.Equal(t, "INSERT INTO searches FORMAT JSONEachRow", query)
+
+	_, err = NewClickHouseTransport("://bad", "searches", nil)
+	assert.Error(t, err)
+}
+
+func TestStatusErrorTemporary(t *testing.T) {
+	for status, temporary := range map[int]bool{400: false, 404: false, 408: true, 429: true, 500: true, 503: true} {
+		assert.Equal(t, temporary, (&StatusError{StatusCode: status}).Temporary(), status)
+	}
+}
@@ -0,0 +1,71 @@
+package sketch
+
+import (
+	"hash/fnv"
+	"math"
+	"sync/atomic"
+)
+
+// Bloom is a Bloom filter: Contains never misses an added item, and reports an
+// item never added with the false positive rate the filter was sized for.
+// Items cannot be removed. It is safe for concurrent use without locking.
+type Bloom struct {
+	bits   []atomic.Uint64
+	m      uint64
+	hashes int
+}
+
+// NewBloom sizes a filter for n items reported falsely with probability
+// falsePositiveRate, e.g. NewBloom(100000, 0.01) takes 117 KiB. Adding more
+// than n items raises the rate.
+func NewBloom(n int, falsePositiveRate float64) *Bloom {
+	n = max(n, 1)
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.01
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	m = max(m, 64)
+	hashes := int(math.Round(float64(m) / float64(n) * math.Ln2))
+	return &Bloom{
+		bits:   make([]atomic.Uint64, (m+63)/64),
+		m:      m,
+		hashes: max(hashes, 1),
+	}
+}
+
+// Add adds item
+func (b *Bloom) Add(item string) {
+	h1, h2 := bloomHashes(item)
+	for i := 0; i < b.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % b.m
+		word := &b.bits[bit/64]
+		mask := uint64(1) << (bit % 64)
+		for {
+			old := word.Load()
+			if old&mask != 0 || word.CompareAndSwap(old, old|mask) {
+				break
+			}
+		}
+	}
+}
+
+// Contains reports whether item may have been added, false means it never was
+func (b *Bloom) Contains(item string) bool {
+	h1, h2 := bloomHashes(item)
+	for i := 0; i < b.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % b.m
+		if b.bits[bit/64].Load()&(uint64(1)<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomHashes returns the two base hashes of the bit positions of item
+// (Kirsch-Mitzenmacher): the FNV-1a hash of item and its finalized form, made odd
+func bloomHashes(item string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(item))
+	sum := h.Sum64()
+	return sum, finalize(sum) | 1
+}
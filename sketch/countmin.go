@@ -0,0 +1,81 @@
+// Package sketch tracks the most searched words approximately, in memory that
+// does not grow with the number of distinct words: a count-min sketch estimates
+// the count of every word and a top-K heap keeps the heaviest hitters. A
+// HyperLogLog estimates how many distinct users searched a word, and a Bloom
+// filter tells the words already stored.
+//
+// A nil *TopK is valid and tracks nothing, so the loggers only pay for it when
+// built with their WithHeavyHitters option.
+package sketch
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// CountMin is a count-min sketch: depth rows of width counters, each row
+// indexed by its own hash of the item. An estimate is the smallest of the
+// item's counters, so it never falls below the true count as long as no
+// count goes negative, and exceeds it by at most epsilon times the total
+// count with probability 1-delta.
+type CountMin struct {
+	width    uint64
+	depth    int
+	counters []int64
+}
+
+// NewCountMin creates a sketch of depth rows of width counters
+func NewCountMin(width, depth int) *CountMin {
+	width = max(width, 1)
+	depth = max(depth, 1)
+	return &CountMin{
+		width:    uint64(width),
+		depth:    depth,
+		counters: make([]int64, width*depth),
+	}
+}
+
+// NewCountMinWithError creates a sketch whose estimates exceed the true count by at
+// most epsilon times the total count with probability 1-delta, e.g. 0.001 and 0.01
+func NewCountMinWithError(epsilon, delta float64) *CountMin {
+	width := int(math.Ceil(math.E / epsilon))
+	depth := int(math.Ceil(math.Log(1 / delta)))
+	return NewCountMin(width, depth)
+}
+
+// Add adds delta to the count of item and returns its new estimate.
+// A negative delta moves counts away from an item added before.
+func (s *CountMin) Add(item string, delta int64) int64 {
+	h1, h2 := hashes(item)
+	estimate := int64(math.MaxInt64)
+	for row := 0; row < s.depth; row++ {
+		i := s.index(row, h1, h2)
+		s.counters[i] += delta
+		estimate = min(estimate, s.counters[i])
+	}
+	return estimate
+}
+
+// Estimate returns the estimated count of item
+func (s *CountMin) Estimate(item string) int64 {
+	h1, h2 := hashes(item)
+	estimate := int64(math.MaxInt64)
+	for row := 0; row < s.depth; row++ {
+		estimate = min(estimate, s.counters[s.index(row, h1, h2)])
+	}
+	return estimate
+}
+
+// index returns the counter of item in row, deriving the row hashes from two
+// base hashes (Kirsch-Mitzenmacher) instead of hashing the item depth times
+func (s *CountMin) index(row int, h1, h2 uint64) int {
+	return row*int(s.width) + int((h1+uint64(row)*h2)%s.width)
+}
+
+// hashes returns two independent 32 bit halves of the 64 bit FNV-1a hash of item
+func hashes(item string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(item))
+	sum := h.Sum64()
+	return sum & math.MaxUint32, sum>>32 | 1
+}
@@ -0,0 +1,121 @@
+package sketch
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// HyperLogLog estimates how many distinct items were added, e.g. the users who
+// searched a word, in 2^precision bytes whatever their number. The standard
+// error of the estimate is 1.04/sqrt(2^precision), 3.25% for precision 10.
+// It is not safe for concurrent use.
+type HyperLogLog struct {
+	precision uint8
+	registers []uint8
+}
+
+const (
+	minPrecision = 4
+	maxPrecision = 16
+)
+
+// NewHyperLogLog creates an empty sketch of 2^precision registers, precision
+// is clamped between 4 and 16
+func NewHyperLogLog(precision uint8) *HyperLogLog {
+	precision = min(max(precision, minPrecision), maxPrecision)
+	return &HyperLogLog{precision: precision, registers: make([]uint8, 1<<precision)}
+}
+
+// Add adds item and reports whether the sketch changed. An item added before
+// never changes it.
+func (h *HyperLogLog) Add(item string) bool {
+	hash := mix(item)
+	index := hash >> (64 - h.precision)
+	// The rank is the position of the first 1 bit after the index bits
+	rank := uint8(bits.LeadingZeros64(hash<<h.precision|1<<(h.precision-1)) + 1)
+	if rank <= h.registers[index] {
+		return false
+	}
+	h.registers[index] = rank
+	return true
+}
+
+// Count returns the estimated number of distinct items added
+func (h *HyperLogLog) Count() uint64 {
+	m := float64(len(h.registers))
+	sum, zeros := 0.0, 0
+	for _, register := range h.registers {
+		sum += 1 / float64(uint64(1)<<register)
+		if register == 0 {
+			zeros++
+		}
+	}
+
+	estimate := alpha(len(h.registers)) * m * m / sum
+	// Few items leave registers empty, linear counting is more accurate there
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// Merge adds the items of other, which must have the same precision, so the
+// sketch estimates the union of both
+func (h *HyperLogLog) Merge(other *HyperLogLog) error {
+	if other.precision != h.precision {
+		return errors.New("sketch: merging HyperLogLogs of different precisions")
+	}
+	for i, register := range other.registers {
+		h.registers[i] = max(h.registers[i], register)
+	}
+	return nil
+}
+
+// MarshalBinary encodes the sketch as its precision followed by its registers
+func (h *HyperLogLog) MarshalBinary() ([]byte, error) {
+	return append([]byte{h.precision}, h.registers...), nil
+}
+
+// UnmarshalBinary decodes a sketch encoded by MarshalBinary
+func (h *HyperLogLog) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 || data[0] < minPrecision || data[0] > maxPrecision || len(data) != 1+1<<data[0] {
+		return errors.New("sketch: invalid HyperLogLog encoding")
+	}
+	h.precision = data[0]
+	h.registers = append([]uint8(nil), data[1:]...)
+	return nil
+}
+
+// alpha corrects the bias of the raw estimate of m registers
+func alpha(m int) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	}
+	return 0.7213 / (1 + 1.079/float64(m))
+}
+
+// mix returns the FNV-1a hash of item through the splitmix64 finalizer, so
+// the high bits used as the register index are well distributed even for
+// short items
+func mix(item string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(item))
+	return finalize(h.Sum64())
+}
+
+// finalize is the splitmix64 finalizer, spreading every bit of x over the result
+func finalize(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
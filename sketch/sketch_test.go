@@ -0,0 +1,189 @@
+package sketch
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountMin(t *testing.T) {
+	s := NewCountMinWithError(0.001, 0.01)
+	assert.Equal(t, uint64(2719), s.width)
+	assert.Equal(t, 5, s.depth)
+
+	assert.Equal(t, int64(1), s.Add("bus", 1))
+	assert.Equal(t, int64(3), s.Add("bus", 2))
+	assert.Equal(t, int64(2), s.Add("bus", -1))
+	assert.Equal(t, int64(2), s.Estimate("bus"))
+	assert.Equal(t, int64(0), s.Estimate("cat"))
+
+	// Estimates never fall below the true counts and stay within epsilon of them
+	rng := rand.New(rand.NewSource(1))
+	counts := make(map[string]int64)
+	var total int64
+	for i := 0; i < 100000; i++ {
+		word := fmt.Sprintf("word%d", rng.Intn(20000))
+		s.Add(word, 1)
+		counts[word]++
+		total++
+	}
+	for word, count := range counts {
+		estimate := s.Estimate(word)
+		assert.GreaterOrEqual(t, estimate, count, word)
+		assert.LessOrEqual(t, estimate, count+total/500, word)
+	}
+}
+
+func TestTopK(t *testing.T) {
+	top := NewTopK(3, NewCountMin(1000, 4))
+	for word, count := range map[string]int{"bus": 5, "cat": 3, "dog": 3, "emu": 1} {
+		for i := 0; i < count; i++ {
+			top.Add(word, 1)
+		}
+	}
+	assert.Equal(t, []Item{{"bus", 5}, {"cat", 3}, {"dog", 3}}, top.Top(10))
+	assert.Equal(t, []Item{{"bus", 5}}, top.Top(1))
+
+	// emu overtakes the lightest tracked word
+	for i := 0; i < 3; i++ {
+		top.Add("emu", 1)
+	}
+	assert.Equal(t, []Item{{"bus", 5}, {"emu", 4}, {"cat", 3}}, top.Top(10))
+
+	// Moving counts away drops a word once it reaches zero
+	for i := 0; i < 5; i++ {
+		top.Move("bus", "business")
+	}
+	assert.Equal(t, []Item{{"business", 5}, {"emu", 4}, {"cat", 3}}, top.Top(10))
+
+	// Words never counted give nothing away
+	top.Move("cow", "cows")
+	assert.Equal(t, int64(0), top.counts.Estimate("cow"))
+
+	// Merging moves the whole count
+	top.Merge("emu", "business")
+	assert.Equal(t, []Item{{"business", 9}, {"cat", 3}}, top.Top(10))
+
+	assert.Empty(t, top.Top(0))
+}
+
+func TestTopKHeavyHitters(t *testing.T) {
+	top := NewTopK(10, NewCountMinWithError(0.001, 0.01))
+	rng := rand.New(rand.NewSource(1))
+
+	// Ten heavy words hidden in a long tail of rare ones
+	for i := 0; i < 100000; i++ {
+		if i%4 == 0 {
+			top.Add(fmt.Sprintf("heavy%d", i%40/4), 1)
+		} else {
+			top.Add(fmt.Sprintf("rare%d", rng.Intn(50000)), 1)
+		}
+	}
+
+	items := top.Top(10)
+	assert.Len(t, items, 10)
+	for _, item := range items {
+		assert.Regexp(t, `^heavy\d$`, item.Word)
+		assert.InDelta(t, 2500, item.Count, 100)
+	}
+}
+
+func TestTopKConcurrent(t *testing.T) {
+	top := NewTopK(5, NewCountMin(1000, 4))
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				top.Add("bus", 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, []Item{{"bus", 8000}}, top.Top(5))
+}
+
+func TestNilTopK(t *testing.T) {
+	var top *TopK
+	top.Add("bus", 1)
+	top.Move("bus", "business")
+	assert.Empty(t, top.Top(10))
+}
+
+func TestHyperLogLog(t *testing.T) {
+	h := NewHyperLogLog(10)
+	assert.Equal(t, uint64(0), h.Count())
+	assert.True(t, h.Add("user_1"))
+	assert.False(t, h.Add("user_1"))
+	assert.Equal(t, uint64(1), h.Count())
+
+	// Estimates stay within 3 standard errors of the distinct count
+	for _, n := range []int{100, 1000, 50000} {
+		h := NewHyperLogLog(10)
+		for i := 0; i < n; i++ {
+			h.Add(fmt.Sprintf("user_%d", i))
+			h.Add(fmt.Sprintf("user_%d", i/2))
+		}
+		assert.InEpsilon(t, n, h.Count(), 3*0.0325, n)
+	}
+
+	// Merging estimates the union
+	a, b := NewHyperLogLog(12), NewHyperLogLog(12)
+	for i := 0; i < 2000; i++ {
+		a.Add(fmt.Sprintf("user_%d", i))
+		b.Add(fmt.Sprintf("user_%d", i+1000))
+	}
+	assert.NoError(t, a.Merge(b))
+	assert.InEpsilon(t, 3000, a.Count(), 0.05)
+	assert.Error(t, a.Merge(NewHyperLogLog(10)))
+
+	data, err := a.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Len(t, data, 1+4096)
+	var decoded HyperLogLog
+	assert.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, a.Count(), decoded.Count())
+	assert.Error(t, decoded.UnmarshalBinary(data[:100]))
+}
+
+func TestBloom(t *testing.T) {
+	b := NewBloom(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		b.Add(fmt.Sprintf("word%d", i))
+	}
+	for i := 0; i < 10000; i++ {
+		assert.True(t, b.Contains(fmt.Sprintf("word%d", i)))
+	}
+
+	// The false positive rate stays close to the rate the filter was sized for
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if b.Contains(fmt.Sprintf("other%d", i)) {
+			falsePositives++
+		}
+	}
+	assert.Less(t, falsePositives, 200)
+
+	// Concurrent adds are never lost
+	b = NewBloom(1000, 0.01)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < 1000; i += 4 {
+				b.Add(fmt.Sprintf("word%d", i))
+			}
+		}(g)
+	}
+	wg.Wait()
+	for i := 0; i < 1000; i++ {
+		assert.True(t, b.Contains(fmt.Sprintf("word%d", i)))
+	}
+}
@@ -0,0 +1,170 @@
+package sketch
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+)
+
+// Item is a tracked word and its estimated count
+type Item struct {
+	Word  string
+	Count int64
+}
+
+// TopK keeps the k words with the highest estimated counts. Every word is
+// counted by a CountMin, and a min-heap of the k heaviest words evicts the
+// lightest one when another word's estimate overtakes it. It is safe for
+// concurrent use.
+type TopK struct {
+	k      int
+	mutex  sync.Mutex
+	counts *CountMin
+	heap   itemHeap
+	index  map[string]*heapItem
+}
+
+// NewTopK tracks the k heaviest words of counts
+func NewTopK(k int, counts *CountMin) *TopK {
+	return &TopK{
+		k:      max(k, 1),
+		counts: counts,
+		index:  make(map[string]*heapItem),
+	}
+}
+
+// Add adds delta to the count of word, a negative delta moves counts away from it
+func (t *TopK) Add(word string, delta int64) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.addLocked(word, delta)
+}
+
+// Move transfers one count from the word from to the word to, e.g. when a
+// search is extended to a longer word. Nothing is taken from a word that was
+// never counted, e.g. one stored before the tracker started.
+func (t *TopK) Move(from, to string) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.counts.Estimate(from) > 0 {
+		t.addLocked(from, -1)
+	}
+	t.addLocked(to, 1)
+}
+
+// Merge transfers the whole estimated count of the word from to the word to,
+// e.g. when an operator merges two words
+func (t *TopK) Merge(from, to string) {
+	if t == nil || from == to {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if estimate := t.counts.Estimate(from); estimate > 0 {
+		t.addLocked(from, -estimate)
+		t.addLocked(to, estimate)
+	}
+}
+
+// addLocked adds delta to the count of word, caller must hold the mutex
+func (t *TopK) addLocked(word string, delta int64) {
+	estimate := t.counts.Add(word, delta)
+	if item, ok := t.index[word]; ok {
+		if estimate <= 0 {
+			heap.Remove(&t.heap, item.index)
+			delete(t.index, word)
+			return
+		}
+		item.count = estimate
+		heap.Fix(&t.heap, item.index)
+		return
+	}
+
+	if estimate <= 0 {
+		return
+	}
+	if len(t.heap) < t.k {
+		item := &heapItem{word: word, count: estimate}
+		heap.Push(&t.heap, item)
+		t.index[word] = item
+		return
+	}
+
+	// Replace the lightest tracked word once word overtakes it
+	lightest := t.heap[0]
+	if estimate <= lightest.count {
+		return
+	}
+	delete(t.index, lightest.word)
+	lightest.word = word
+	lightest.count = estimate
+	t.index[word] = lightest
+	heap.Fix(&t.heap, 0)
+}
+
+// Top returns up to limit tracked words, heaviest first and ties in word order
+func (t *TopK) Top(limit int) []Item {
+	if t == nil || limit <= 0 {
+		return []Item{}
+	}
+
+	t.mutex.Lock()
+	items := make([]Item, 0, len(t.heap))
+	for _, item := range t.heap {
+		items = append(items, Item{Word: item.word, Count: item.count})
+	}
+	t.mutex.Unlock()
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Word < items[j].Word
+	})
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return items
+}
+
+// heapItem is a tracked word, index is its position in the heap
+type heapItem struct {
+	word  string
+	count int64
+	index int
+}
+
+// itemHeap orders the tracked words lightest first
+type itemHeap []*heapItem
+
+func (h itemHeap) Len() int           { return len(h) }
+func (h itemHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h itemHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *itemHeap) Push(x any) {
+	item := x.(*heapItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *itemHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
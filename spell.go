@@ -0,0 +1,31 @@
+package logsearch
+
+import (
+	"errors"
+
+	"github.com/afanwang/logsearch/spell"
+)
+
+// WithSpellCorrection indexes the stored words for DidYouMean, finding the
+// words within maxDistance edits of each other, e.g. WithSpellCorrection(2).
+// Like WithHeavyHitters each search counts once under the longest word it was
+// extended to over all users, and only searches logged since the logger
+// started are counted.
+func WithSpellCorrection(maxDistance int) Option {
+	return func(sl *SearchLoggerV2) {
+		sl.spell = spell.New(maxDistance)
+	}
+}
+
+// DidYouMean proposes the closest word searched more often than word over all
+// users, so the rare typo variants of a query can be grouped under it. It
+// reports false when no word within the distance of WithSpellCorrection is
+// more popular, e.g. when word is the popular form itself.
+func (sl *SearchLoggerV2) DidYouMean(word string) (spell.Suggestion, bool, error) {
+	if sl.spell == nil {
+		return spell.Suggestion{}, false, errors.New("spell correction is not enabled")
+	}
+
+	suggestion, ok := sl.spell.Correct(sl.normalizer.Normalize(word))
+	return suggestion, ok, nil
+}
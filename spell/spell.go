@@ -0,0 +1,254 @@
+// Package spell proposes corrections of misspelled searches from a vocabulary
+// of counted words, e.g. "did you mean bus?" for "bsu". Neighbours are looked
+// up with the precomputed deletes of the SymSpell algorithm: every word is
+// indexed under each string obtained by deleting up to maxDistance of its
+// characters, so a lookup only generates the deletes of the query instead of
+// comparing it to the whole vocabulary.
+//
+// A nil *Index is valid and indexes nothing, so SearchLoggerV2 only pays for it
+// when built with WithSpellCorrection.
+package spell
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// prefixLength bounds the characters of a word whose deletes are indexed, so
+// long words do not blow up the index. Words sharing their first prefixLength
+// characters up to the distance are compared in full.
+const prefixLength = 7
+
+// Suggestion is a word of the vocabulary close to a looked up word
+type Suggestion struct {
+	Word  string
+	Count int64
+	// Distance is the number of insertions, deletions, substitutions and
+	// transpositions of adjacent characters turning the looked up word into Word
+	Distance int
+}
+
+// Index is a vocabulary of counted words indexed by their deletes. It is safe
+// for concurrent use.
+type Index struct {
+	mutex       sync.RWMutex
+	maxDistance int
+	counts      map[string]int64
+	// deletes maps every delete of the prefix of a word to the words having it
+	deletes map[string][]string
+}
+
+// New creates an index finding the words within maxDistance edits, 2 is
+// usual, values below 1 mean 1. Memory grows quickly with the distance.
+func New(maxDistance int) *Index {
+	if maxDistance < 1 {
+		maxDistance = 1
+	}
+	return &Index{
+		maxDistance: maxDistance,
+		counts:      make(map[string]int64),
+		deletes:     make(map[string][]string),
+	}
+}
+
+// Add adds delta to the count of word, indexing it when it first counts and
+// dropping it once its count is no longer positive
+func (ix *Index) Add(word string, delta int64) {
+	if ix == nil || word == "" || delta == 0 {
+		return
+	}
+
+	ix.mutex.Lock()
+	defer ix.mutex.Unlock()
+	ix.addLocked(word, delta)
+}
+
+// Move moves one count from a word to the longer word extending it
+func (ix *Index) Move(from, to string) {
+	if ix == nil {
+		return
+	}
+
+	ix.mutex.Lock()
+	defer ix.mutex.Unlock()
+	ix.addLocked(from, -1)
+	ix.addLocked(to, 1)
+}
+
+// Merge moves the whole count of a word to another word, e.g. when an operator merges two words
+func (ix *Index) Merge(from, to string) {
+	if ix == nil || from == to {
+		return
+	}
+
+	ix.mutex.Lock()
+	defer ix.mutex.Unlock()
+	if count := ix.counts[from]; count > 0 {
+		ix.addLocked(from, -count)
+		ix.addLocked(to, count)
+	}
+}
+
+// addLocked is Add, caller must hold the write lock
+func (ix *Index) addLocked(word string, delta int64) {
+	count, known := ix.counts[word]
+	count += delta
+	switch {
+	case count > 0 && known:
+		ix.counts[word] = count
+	case count > 0:
+		ix.counts[word] = count
+		for del := range ix.deletesOf(word) {
+			ix.deletes[del] = append(ix.deletes[del], word)
+		}
+	case known:
+		delete(ix.counts, word)
+		for del := range ix.deletesOf(word) {
+			ix.deletes[del] = remove(ix.deletes[del], word)
+			if len(ix.deletes[del]) == 0 {
+				delete(ix.deletes, del)
+			}
+		}
+	}
+}
+
+// remove returns words without word, not preserving the order
+func remove(words []string, word string) []string {
+	for i, w := range words {
+		if w == word {
+			words[i] = words[len(words)-1]
+			return words[:len(words)-1]
+		}
+	}
+	return words
+}
+
+// Count returns the count of word, 0 if it is not in the vocabulary
+func (ix *Index) Count(word string) int64 {
+	if ix == nil {
+		return 0
+	}
+
+	ix.mutex.RLock()
+	defer ix.mutex.RUnlock()
+	return ix.counts[word]
+}
+
+// Lookup returns up to limit words of the vocabulary within the maximum
+// distance of word, word itself excluded, closest first, then most counted,
+// then in alphabetical order
+func (ix *Index) Lookup(word string, limit int) []Suggestion {
+	if ix == nil || word == "" || limit <= 0 {
+		return nil
+	}
+
+	ix.mutex.RLock()
+	defer ix.mutex.RUnlock()
+
+	seen := map[string]bool{word: true}
+	var suggestions []Suggestion
+	for del := range ix.deletesOf(word) {
+		for _, candidate := range ix.deletes[del] {
+			if seen[candidate] {
+				continue
+			}
+			seen[candidate] = true
+
+			if distance := Distance(word, candidate); distance <= ix.maxDistance {
+				suggestions = append(suggestions, Suggestion{Word: candidate, Count: ix.counts[candidate], Distance: distance})
+			}
+		}
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		a, b := suggestions[i], suggestions[j]
+		if a.Distance != b.Distance {
+			return a.Distance < b.Distance
+		}
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Word < b.Word
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions
+}
+
+// Correct proposes the closest word searched more often than word, the most
+// counted among equally close ones, so rare typo variants point at the popular
+// query they misspell. It reports false when no such word is within the
+// maximum distance, e.g. for a word more popular than its neighbours.
+func (ix *Index) Correct(word string) (Suggestion, bool) {
+	if ix == nil {
+		return Suggestion{}, false
+	}
+
+	count := ix.Count(word)
+	for _, suggestion := range ix.Lookup(word, math.MaxInt) {
+		if suggestion.Count > count {
+			return suggestion, true
+		}
+	}
+	return Suggestion{}, false
+}
+
+// deletesOf returns the strings obtained by deleting up to the maximum
+// distance of characters from the prefix of word, the prefix itself included
+func (ix *Index) deletesOf(word string) map[string]struct{} {
+	runes := []rune(word)
+	if len(runes) > prefixLength {
+		runes = runes[:prefixLength]
+	}
+
+	deletes := map[string]struct{}{string(runes): {}}
+	frontier := [][]rune{runes}
+	for d := 0; d < ix.maxDistance; d++ {
+		var next [][]rune
+		for _, r := range frontier {
+			for i := range r {
+				del := append(append(make([]rune, 0, len(r)-1), r[:i]...), r[i+1:]...)
+				if _, ok := deletes[string(del)]; ok {
+					continue
+				}
+				deletes[string(del)] = struct{}{}
+				next = append(next, del)
+			}
+		}
+		frontier = next
+	}
+	return deletes
+}
+
+// Distance returns the optimal string alignment distance between a and b:
+// the number of insertions, deletions, substitutions and transpositions of
+// adjacent characters turning a into b, no substring being edited twice
+func Distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	// Three rows of the dynamic programming matrix are enough for transpositions
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,89 @@
+package spell
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDistance(t *testing.T) {
+	assert.Equal(t, 0, Distance("bus", "bus"))
+	assert.Equal(t, 1, Distance("bus", "bsu"), "An adjacent transposition is one edit")
+	assert.Equal(t, 1, Distance("bus", "buss"))
+	assert.Equal(t, 1, Distance("bus", "bu"))
+	assert.Equal(t, 1, Distance("bus", "bas"))
+	assert.Equal(t, 3, Distance("", "bus"))
+	assert.Equal(t, 2, Distance("café", "cfae"))
+	assert.Equal(t, 3, Distance("ca", "abc"), "No substring is edited twice")
+}
+
+func TestIndex(t *testing.T) {
+	ix := New(2)
+	ix.Add("business", 50)
+	ix.Add("bus", 20)
+	ix.Add("bud", 3)
+	ix.Add("bsu", 1)
+	ix.Add("cat", 7)
+
+	assert.Equal(t, []Suggestion{
+		{Word: "bus", Count: 20, Distance: 1},
+		{Word: "bud", Count: 3, Distance: 2},
+	}, ix.Lookup("bsu", 10))
+	assert.Equal(t, []Suggestion{{Word: "bus", Count: 20, Distance: 1}}, ix.Lookup("bsu", 1))
+
+	// Words longer than the indexed prefix are found too
+	assert.Equal(t, []Suggestion{{Word: "business", Count: 50, Distance: 1}}, ix.Lookup("busniess", 10))
+
+	suggestion, ok := ix.Correct("bsu")
+	assert.True(t, ok)
+	assert.Equal(t, Suggestion{Word: "bus", Count: 20, Distance: 1}, suggestion)
+
+	// A word more popular than its neighbours is not a typo
+	_, ok = ix.Correct("bus")
+	assert.False(t, ok)
+	_, ok = ix.Correct("dog")
+	assert.False(t, ok)
+
+	// Moving the last count of a word drops it from the vocabulary
+	ix.Move("bsu", "bsus")
+	assert.Equal(t, int64(0), ix.Count("bsu"))
+	assert.Equal(t, int64(1), ix.Count("bsus"))
+	assert.NotContains(t, ix.Lookup("bus", 10), Suggestion{Word: "bsu", Count: 1, Distance: 1})
+	ix.Add("bsus", -1)
+	ix.Add("bud", -3)
+	assert.Equal(t, []Suggestion{{Word: "bus", Count: 20, Distance: 1}}, ix.Lookup("bsu", 10))
+
+	// Merging moves the whole count
+	ix.Merge("cat", "bus")
+	assert.Equal(t, int64(0), ix.Count("cat"))
+	assert.Equal(t, int64(27), ix.Count("bus"))
+}
+
+func TestNilIndex(t *testing.T) {
+	var ix *Index
+	ix.Add("bus", 1)
+	ix.Move("bu", "bus")
+	assert.Zero(t, ix.Count("bus"))
+	assert.Nil(t, ix.Lookup("bus", 10))
+	_, ok := ix.Correct("bus")
+	assert.False(t, ok)
+}
+
+func TestIndexConcurrent(t *testing.T) {
+	ix := New(1)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				ix.Add("bus", 1)
+				ix.Lookup("bsu", 10)
+				ix.Correct("bsu")
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(800), ix.Count("bus"))
+}
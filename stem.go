@@ -0,0 +1,41 @@
+package logsearch
+
+import (
+	"context"
+	"log"
+
+	"github.com/afanwang/logsearch/normalize"
+	"github.com/afanwang/logsearch/store"
+)
+
+// WithStemmer stores the stem of every finalized search, e.g. from
+// normalize.NewSnowball, so "running" and "run" consolidate under "run". The
+// form the user typed last is kept as the surface word of the record for
+// display when the store supports it, see store.UserSurfaceStore. Stemming
+// needs WithFinalizeTimeout: the stems of successive keystrokes, e.g. "runnin"
+// and "running" stemmed to "run", no longer extend each other.
+func WithStemmer(s normalize.Stemmer) Option {
+	return func(sl *SearchLoggerV2) {
+		sl.stemmer = s
+	}
+}
+
+// stem returns the stem of a normalized search, the search itself without WithStemmer
+func (sl *SearchLoggerV2) stem(word string) string {
+	if sl.stemmer == nil {
+		return word
+	}
+	return normalize.StemWords(sl.stemmer, word)
+}
+
+// setSurface records the surface form of the user's stored word when stemming.
+// The surface is for display only, a failure is logged and the search stays stored.
+func (sl *SearchLoggerV2) setSurface(ctx context.Context, userIdentifier, word, surface string) {
+	surfaceStore, ok := sl.db.(store.UserSurfaceStore)
+	if sl.stemmer == nil || surface == "" || !ok {
+		return
+	}
+	if err := surfaceStore.SetSurfaceWord(ctx, userIdentifier, word, surface); err != nil {
+		log.Printf("Error setting surface '%s' of user search '%s': %v", surface, word, err)
+	}
+}
@@ -0,0 +1,66 @@
+package logsearch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/normalize"
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/language"
+)
+
+func TestSearchLoggerV2_Stemmer(t *testing.T) {
+	stemmer, err := normalize.NewSnowball(language.English)
+	require.NoError(t, err)
+
+	_, err = NewSearchLoggerV2(WithStemmer(stemmer))
+	assert.Error(t, err, "Stemming keystrokes breaks the prefix dedup")
+
+	for _, tt := range []struct {
+		name string
+		opts []Option
+	}{
+		{"direct", nil},
+		{"buffered", []Option{WithWriteBuffer(100, time.Hour)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := store.NewMockPostgresDBV2()
+			opts := append([]Option{WithStemmer(stemmer), WithFinalizeTimeout(time.Hour)}, tt.opts...)
+			logger, err := NewSearchLoggerV2WithDB(db, opts...)
+			require.NoError(t, err)
+			defer logger.Close()
+
+			records := func() []store.UserSearchRecord {
+				require.NoError(t, logger.Flush(ctx))
+				var records []store.UserSearchRecord
+				require.NoError(t, db.ForEachUserSearch(ctx, "user_1", func(record store.UserSearchRecord) error {
+					record.ID, record.FirstSearchedAt, record.LastUpdatedAt = 0, time.Time{}, time.Time{}
+					records = append(records, record)
+					return nil
+				}))
+				return records
+			}
+
+			for _, search := range []string{"r", "run", "runnin", "running"} {
+				require.NoError(t, logger.LogSearchV2(ctx, "user_1", search))
+			}
+			assert.Equal(t, []store.UserSearchRecord{
+				{UserIdentifier: "user_1", SearchWord: "run", SearchCount: 1, SurfaceWord: "running"},
+			}, records())
+
+			require.NoError(t, logger.LogSearchV2(ctx, "user_1", "Runs"))
+			assert.Equal(t, []store.UserSearchRecord{
+				{UserIdentifier: "user_1", SearchWord: "run", SearchCount: 2, SurfaceWord: "runs"},
+			}, records(), "The last typed form is kept for display")
+
+			require.NoError(t, logger.LogSearchV2(ctx, "user_1", "running shoes"))
+			assert.Equal(t, []store.UserSearchRecord{
+				{UserIdentifier: "user_1", SearchWord: "run shoe", SearchCount: 3, SurfaceWord: "running shoes"},
+			}, records())
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Granularity is the width of the buckets of a search histogram
+type Granularity int
+
+const (
+	// Hourly buckets the searches by hour
+	Hourly Granularity = iota
+	// Daily buckets the searches by day, in UTC
+	Daily
+)
+
+// Truncate returns the start of the bucket of t, in UTC
+func (g Granularity) Truncate(t time.Time) time.Time {
+	t = t.UTC()
+	if g == Daily {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return t.Truncate(time.Hour)
+}
+
+// unit is the date_trunc field of the granularity
+func (g Granularity) unit() string {
+	if g == Daily {
+		return "day"
+	}
+	return "hour"
+}
+
+// SearchBucket aggregates the searches of one bucket of a histogram
+type SearchBucket struct {
+	Start time.Time
+	// UniqueWords is the number of distinct words searched in the bucket
+	UniqueWords int
+	// Searches is the number of searches in the bucket, over all users
+	Searches int
+}
+
+// AnalyticsStore is a UserSearchStore that can aggregate its records over a
+// time range. A record keeps no history of its searches, so all of them count
+// at its last_updated_at.
+type AnalyticsStore interface {
+	UserSearchStore
+	// SearchesBetween returns the words last searched in [from, to) with their
+	// counts summed over all users, most searched first and ties in word order
+	SearchesBetween(ctx context.Context, from, to time.Time) ([]WordCount, error)
+	// SearchHistogram aggregates the records last searched in [from, to) by
+	// bucket, in time order, omitting the buckets without searches
+	SearchHistogram(ctx context.Context, from, to time.Time, granularity Granularity) ([]SearchBucket, error)
+}
+
+// histogram aggregates records into buckets for the stores that cannot group them in a query
+type histogram struct {
+	granularity Granularity
+	buckets     map[time.Time]*SearchBucket
+	words       map[time.Time]map[string]bool
+}
+
+func newHistogram(granularity Granularity) *histogram {
+	return &histogram{
+		granularity: granularity,
+		buckets:     make(map[time.Time]*SearchBucket),
+		words:       make(map[time.Time]map[string]bool),
+	}
+}
+
+// add counts count searches of word at at
+func (h *histogram) add(word string, at time.Time, count int) {
+	start := h.granularity.Truncate(at)
+	bucket := h.buckets[start]
+	if bucket == nil {
+		bucket = &SearchBucket{Start: start}
+		h.buckets[start] = bucket
+		h.words[start] = make(map[string]bool)
+	}
+	if !h.words[start][word] {
+		h.words[start][word] = true
+		bucket.UniqueWords++
+	}
+	bucket.Searches += count
+}
+
+// result returns the buckets in time order
+func (h *histogram) result() []SearchBucket {
+	buckets := make([]SearchBucket, 0, len(h.buckets))
+	for _, bucket := range h.buckets {
+		buckets = append(buckets, *bucket)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Start.Before(buckets[j].Start) })
+	return buckets
+}
+
+// inRange reports whether at is in [from, to)
+func inRange(at, from, to time.Time) bool {
+	return !at.Before(from) && at.Before(to)
+}
+
+// HourlySearchStore is a UserSearchStore that summarizes its records by hour
+// on the fly, like the hourly rollup of RollupStore without writing it, for
+// the hours not rolled up yet. A record counts at its last_updated_at.
+type HourlySearchStore interface {
+	UserSearchStore
+	// SearchesByHour summarizes the records of word last updated in [from,
+	// to) by hour, every word when word is empty, in hour order then most
+	// searched first and ties in word order
+	SearchesByHour(ctx context.Context, from, to time.Time, word string) ([]SearchRollup, error)
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchAnalytics(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		open func(t *testing.T) AnalyticsStore
+	}{
+		{"mock", func(t *testing.T) AnalyticsStore {
+			return NewMockPostgresDBV2()
+		}},
+		{"sqlite", func(t *testing.T) AnalyticsStore {
+			db, err := NewSQLiteDBV2(sqliteConfig(t))
+			require.NoError(t, err)
+			t.Cleanup(func() { db.Close() })
+			return db
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := tt.open(t)
+			require.NoError(t, db.CreateTable(ctx))
+
+			day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+			for _, search := range []struct {
+				user string
+				word string
+				at   time.Time
+			}{
+				{"user_1", "cat", day.Add(9*time.Hour + 10*time.Minute)},
+				{"user_1", "cat", day.Add(9*time.Hour + 20*time.Minute)},
+				{"user_2", "cat", day.Add(9*time.Hour + 30*time.Minute)},
+				{"user_2", "dog", day.Add(9*time.Hour + 40*time.Minute)},
+				{"user_1", "bus", day.Add(11 * time.Hour)},
+				{"user_3", "bus", day.Add(26 * time.Hour)},
+				{"user_3", "car", day.Add(50 * time.Hour)},
+			} {
+				_, err := db.InsertOrUpdateUserSearch(ctx, search.user, search.word, search.at, search.at)
+				require.NoError(t, err)
+			}
+
+			counts, err := db.SearchesBetween(ctx, day, day.Add(48*time.Hour))
+			require.NoError(t, err)
+			assert.Equal(t, []WordCount{{Word: "cat", Count: 3}, {Word: "bus", Count: 2}, {Word: "dog", Count: 1}}, counts)
+
+			buckets, err := db.SearchHistogram(ctx, day, day.Add(48*time.Hour), Hourly)
+			require.NoError(t, err)
+			assert.Equal(t, []SearchBucket{
+				{Start: day.Add(9 * time.Hour), UniqueWords: 2, Searches: 4},
+				{Start: day.Add(11 * time.Hour), UniqueWords: 1, Searches: 1},
+				{Start: day.Add(26 * time.Hour), UniqueWords: 1, Searches: 1},
+			}, buckets)
+
+			buckets, err = db.SearchHistogram(ctx, day, day.Add(72*time.Hour), Daily)
+			require.NoError(t, err)
+			assert.Equal(t, []SearchBucket{
+				{Start: day, UniqueWords: 3, Searches: 5},
+				{Start: day.Add(24 * time.Hour), UniqueWords: 1, Searches: 1},
+				{Start: day.Add(48 * time.Hour), UniqueWords: 1, Searches: 1},
+			}, buckets)
+
+			// The range excludes its end
+			buckets, err = db.SearchHistogram(ctx, day, day.Add(11*time.Hour), Hourly)
+			require.NoError(t, err)
+			assert.Len(t, buckets, 1)
+		})
+	}
+}
+
+func TestSearchesByHour(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		open func(t *testing.T) HourlySearchStore
+	}{
+		{"mock", func(t *testing.T) HourlySearchStore {
+			return NewMockPostgresDBV2()
+		}},
+		{"sqlite", func(t *testing.T) HourlySearchStore {
+			db, err := NewSQLiteDBV2(sqliteConfig(t))
+			require.NoError(t, err)
+			t.Cleanup(func() { db.Close() })
+			return db
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := tt.open(t)
+			require.NoError(t, db.CreateTable(ctx))
+
+			day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+			for _, search := range []struct {
+				user string
+				word string
+				at   time.Time
+			}{
+				{"user_1", "cat", day.Add(9*time.Hour + 10*time.Minute)},
+				{"user_1", "cat", day.Add(9*time.Hour + 20*time.Minute)},
+				{"user_2", "cat", day.Add(9*time.Hour + 30*time.Minute)},
+				{"user_2", "dog", day.Add(9*time.Hour + 40*time.Minute)},
+				{"user_1", "bus", day.Add(11 * time.Hour)},
+			} {
+				_, err := db.InsertOrUpdateUserSearch(ctx, search.user, search.word, search.at, search.at)
+				require.NoError(t, err)
+			}
+
+			hours, err := db.SearchesByHour(ctx, day, day.Add(24*time.Hour), "")
+			require.NoError(t, err)
+			assert.Equal(t, []SearchRollup{
+				{Word: "cat", Bucket: day.Add(9 * time.Hour), TotalCount: 3, UniqueUsers: 2},
+				{Word: "dog", Bucket: day.Add(9 * time.Hour), TotalCount: 1, UniqueUsers: 1},
+				{Word: "bus", Bucket: day.Add(11 * time.Hour), TotalCount: 1, UniqueUsers: 1},
+			}, hours)
+
+			hours, err = db.SearchesByHour(ctx, day, day.Add(24*time.Hour), "dog")
+			require.NoError(t, err)
+			assert.Equal(t, []SearchRollup{{Word: "dog", Bucket: day.Add(9 * time.Hour), TotalCount: 1, UniqueUsers: 1}}, hours)
+
+			hours, err = db.SearchesByHour(ctx, day, day.Add(9*time.Hour), "")
+			require.NoError(t, err)
+			assert.Empty(t, hours)
+		})
+	}
+}
+
+func TestGranularityTruncate(t *testing.T) {
+	at := time.Date(2024, 5, 1, 23, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+	assert.Equal(t, time.Date(2024, 5, 1, 21, 0, 0, 0, time.UTC), Hourly.Truncate(at))
+	assert.Equal(t, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), Daily.Truncate(at))
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/afanwang/logsearch/sketch"
+)
+
+// AudiencePrecision is the precision of the HyperLogLog of every word, 1 KiB
+// per word for a standard error of 3.25%
+const AudiencePrecision = 10
+
+// AudienceAdd is a search of Word by a user, counted in the audience of the word
+type AudienceAdd struct {
+	Word           string
+	UserIdentifier string
+	At             time.Time
+}
+
+// AudienceStore is a UserSearchStore keeping the approximate number of
+// distinct users who searched every word in the search_audiences table: a
+// HyperLogLog of the users persisted with its estimate, so the audience of a
+// word survives the records deleted, trimmed or merged away.
+type AudienceStore interface {
+	UserSearchStore
+	// AddAudiences adds the users to the HyperLogLogs of their words atomically
+	AddAudiences(ctx context.Context, adds []AudienceAdd) error
+	// TopAudiences returns the limit stored words searched by the most users,
+	// last searched at or after since, with their Users estimate and their
+	// search count over all users. Ties rank by count then in word order. A
+	// zero since covers all time.
+	TopAudiences(ctx context.Context, since time.Time, limit int) ([]WordCount, error)
+}
+
+// audienceUpdate is the users of AudienceAdds of one word
+type audienceUpdate struct {
+	word  string
+	users []string
+	at    time.Time
+}
+
+// groupAudiences groups the adds by word, in word order so concurrent
+// transactions lock the rows in the same order
+func groupAudiences(adds []AudienceAdd) []audienceUpdate {
+	byWord := make(map[string]*audienceUpdate)
+	for _, add := range adds {
+		update := byWord[add.Word]
+		if update == nil {
+			update = &audienceUpdate{word: add.Word}
+			byWord[add.Word] = update
+		}
+		update.users = append(update.users, add.UserIdentifier)
+		if add.At.After(update.at) {
+			update.at = add.At
+		}
+	}
+
+	updates := make([]audienceUpdate, 0, len(byWord))
+	for _, update := range byWord {
+		updates = append(updates, *update)
+	}
+	sort.Slice(updates, func(i, j int) bool { return updates[i].word < updates[j].word })
+	return updates
+}
+
+// addUsers adds users to the encoded HyperLogLog, a new one when data is
+// empty, and returns it encoded with its estimate
+func addUsers(data []byte, users []string) ([]byte, int64, error) {
+	h := sketch.NewHyperLogLog(AudiencePrecision)
+	if len(data) > 0 {
+		if err := h.UnmarshalBinary(data); err != nil {
+			return nil, 0, err
+		}
+	}
+	for _, user := range users {
+		h.Add(user)
+	}
+	data, err := h.MarshalBinary()
+	return data, int64(h.Count()), err
+}
+
+// scanAudiences reads the rows of a TopAudiences query: word, count and users
+func scanAudiences(rows *sql.Rows) ([]WordCount, error) {
+	top := make([]WordCount, 0)
+	for rows.Next() {
+		var wordCount WordCount
+		if err := rows.Scan(&wordCount.Word, &wordCount.Count, &wordCount.Users); err != nil {
+			return nil, err
+		}
+		top = append(top, wordCount)
+	}
+	return top, rows.Err()
+}
+
+// rankAudiences sorts the words by users, then count, then word, and keeps the limit first
+func rankAudiences(counts []WordCount, limit int) []WordCount {
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Users != counts[j].Users {
+			return counts[i].Users > counts[j].Users
+		}
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Word < counts[j].Word
+	})
+	if limit >= 0 && len(counts) > limit {
+		counts = counts[:limit]
+	}
+	return counts
+}
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// AuditEntry is a row of the append-only audit_log table: an administrative
+// mutation, who made it and the values it changed
+type AuditEntry struct {
+	ID int64
+	At time.Time
+	// Actor is who made the change, e.g. the operator named by the caller
+	Actor string
+	// Action is the operation, e.g. delete-user or merge-words
+	Action string
+	// Target is what the action applied to, e.g. a user or a word
+	Target string
+	// Before and After describe the changed values, empty when not applicable
+	Before string
+	After  string
+}
+
+// AuditQuery selects audit entries, newest first. The zero value lists the latest entries.
+type AuditQuery struct {
+	// Actor and Action keep the entries of that actor or action, any when empty
+	Actor  string
+	Action string
+	// Since keeps the entries at or after it, any when zero
+	Since time.Time
+	// Limit bounds the entries returned, DefaultAuditLimit when 0
+	Limit int
+}
+
+// DefaultAuditLimit is the number of entries of an AuditQuery without a limit
+const DefaultAuditLimit = 100
+
+// limit returns the limit of the query
+func (q AuditQuery) limit() int {
+	if q.Limit <= 0 {
+		return DefaultAuditLimit
+	}
+	return q.Limit
+}
+
+// matches reports whether entry is selected by the filters of the query
+func (q AuditQuery) matches(entry AuditEntry) bool {
+	return (q.Actor == "" || entry.Actor == q.Actor) &&
+		(q.Action == "" || entry.Action == q.Action) &&
+		!entry.At.Before(q.Since)
+}
+
+// AuditStore is a UserSearchStore that keeps the audit log of the
+// administrative mutations. The entries cannot be updated nor deleted.
+type AuditStore interface {
+	UserSearchStore
+	// AppendAudit stores an entry and returns its ID
+	AppendAudit(ctx context.Context, entry AuditEntry) (int64, error)
+	// ListAudit returns the entries selected by query, newest first
+	ListAudit(ctx context.Context, query AuditQuery) ([]AuditEntry, error)
+}
+
+// scanAuditEntries reads the rows of an audit_log query
+func scanAuditEntries(rows *sql.Rows, err error) ([]AuditEntry, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]AuditEntry, 0)
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(&entry.ID, &entry.At, &entry.Actor, &entry.Action, &entry.Target, &entry.Before, &entry.After); err != nil {
+			return nil, err
+		}
+		entry.At = entry.At.UTC()
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
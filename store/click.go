@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// SearchClick is a row of the search_clicks table: a click of a user on a
+// result of their search of a word
+type SearchClick struct {
+	UserIdentifier string
+	Word           string
+	// ResultID identifies the clicked result for the caller, e.g. a product ID
+	ResultID  string
+	ClickedAt time.Time
+}
+
+// ClickStats are the searches of a word by every user and the clicks on their results
+type ClickStats struct {
+	Searches int
+	Clicks   int
+}
+
+// ClickThrough returns the share of the searches followed by a click, from 0
+// to 1. A word clicked more than searched, e.g. clicked from a search that
+// was not stored, counts 1.
+func (s ClickStats) ClickThrough() float64 {
+	if s.Clicks == 0 {
+		return 0
+	}
+	return min(float64(s.Clicks)/float64(max(s.Searches, 1)), 1)
+}
+
+// SearchClickStore is a UserSearchStore that records the clicks on the
+// results of the searches in the search_clicks table
+type SearchClickStore interface {
+	UserSearchStore
+	// AddSearchClick records a click
+	AddSearchClick(ctx context.Context, click SearchClick) error
+	// ClickStats returns the stats of words over every user, leaving out the
+	// words neither stored nor clicked
+	ClickStats(ctx context.Context, words []string) (map[string]ClickStats, error)
+	// DeleteUserClicks deletes the clicks of the user and returns how many were deleted
+	DeleteUserClicks(ctx context.Context, userIdentifier string) (int64, error)
+}
+
+// scanWordTotals reads the rows of a search_word, count query
+func scanWordTotals(rows *sql.Rows, err error) (map[string]int, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := make(map[string]int)
+	for rows.Next() {
+		var word string
+		var total int
+		if err := rows.Scan(&word, &total); err != nil {
+			return nil, err
+		}
+		totals[word] = total
+	}
+	return totals, rows.Err()
+}
+
+// clickStats joins the search counts and the click counts of the words
+func clickStats(searches, clicks map[string]int) map[string]ClickStats {
+	stats := make(map[string]ClickStats, len(searches))
+	for word, count := range searches {
+		stats[word] = ClickStats{Searches: count}
+	}
+	for word, count := range clicks {
+		s := stats[word]
+		s.Clicks = count
+		stats[word] = s
+	}
+	return stats
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchClicks(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		open func(t *testing.T) SearchClickStore
+	}{
+		{"mock", func(t *testing.T) SearchClickStore {
+			return NewMockPostgresDBV2()
+		}},
+		{"sqlite", func(t *testing.T) SearchClickStore {
+			db, err := NewSQLiteDBV2(sqliteConfig(t))
+			require.NoError(t, err)
+			t.Cleanup(func() { db.Close() })
+			return db
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := tt.open(t)
+			require.NoError(t, db.CreateTable(ctx))
+
+			at := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+			for _, search := range []struct{ user, word string }{
+				{"user_1", "bus"}, {"user_1", "bus"}, {"user_2", "bus"}, {"user_2", "bus"}, {"user_1", "cat"},
+			} {
+				_, err := db.InsertOrUpdateUserSearch(ctx, search.user, search.word, at, at)
+				require.NoError(t, err)
+			}
+			for _, click := range []SearchClick{
+				{UserIdentifier: "user_1", Word: "bus", ResultID: "line-42", ClickedAt: at},
+				{UserIdentifier: "user_2", Word: "bus", ResultID: "line-7", ClickedAt: at},
+				{UserIdentifier: "user_2", Word: "dog", ResultID: "breed-1", ClickedAt: at},
+			} {
+				require.NoError(t, db.AddSearchClick(ctx, click))
+			}
+
+			stats, err := db.ClickStats(ctx, []string{"bus", "cat", "dog", "cow"})
+			require.NoError(t, err)
+			assert.Equal(t, map[string]ClickStats{
+				"bus": {Searches: 4, Clicks: 2},
+				"cat": {Searches: 1},
+				"dog": {Clicks: 1},
+			}, stats)
+			assert.Equal(t, 0.5, stats["bus"].ClickThrough())
+			assert.Zero(t, stats["cat"].ClickThrough())
+			assert.Equal(t, 1.0, stats["dog"].ClickThrough(), "A word clicked but not stored counts 1")
+
+			stats, err = db.ClickStats(ctx, nil)
+			require.NoError(t, err)
+			assert.Empty(t, stats)
+
+			deleted, err := db.DeleteUserClicks(ctx, "user_2")
+			require.NoError(t, err)
+			assert.Equal(t, int64(2), deleted)
+			stats, err = db.ClickStats(ctx, []string{"bus", "dog"})
+			require.NoError(t, err)
+			assert.Equal(t, map[string]ClickStats{"bus": {Searches: 4, Clicks: 1}}, stats)
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// UserClock is the client clock of a user's search: the timestamp the client
+// saw it at and the sequence number the client gave it, either may be zero
+type UserClock struct {
+	At       time.Time
+	Sequence uint64
+}
+
+// Before reports whether c was sent before other: by sequence number when
+// both have one, by timestamp otherwise
+func (c UserClock) Before(other UserClock) bool {
+	if c.Sequence != 0 && other.Sequence != 0 {
+		return c.Sequence < other.Sequence
+	}
+	return c.At.Before(other.At)
+}
+
+// IsZero reports whether c carries neither a timestamp nor a sequence number
+func (c UserClock) IsZero() bool {
+	return c.At.IsZero() && c.Sequence == 0
+}
+
+// merge returns the clock holding the latest timestamp and sequence number of c and other
+func (c UserClock) merge(other UserClock) UserClock {
+	if other.At.After(c.At) {
+		c.At = other.At
+	}
+	c.Sequence = max(c.Sequence, other.Sequence)
+	return c
+}
+
+// UserClockStore is a UserSearchStore keeping the latest client clock of
+// every user in the user_clocks table, so the searches delivered after a
+// later one of their user are told apart even across restarts.
+type UserClockStore interface {
+	UserSearchStore
+	// AdvanceUserClock raises the clock of the user to the latest timestamp
+	// and sequence number of its clock and clock, and returns its clock
+	// before, zero for a user without one
+	AdvanceUserClock(ctx context.Context, userIdentifier string, clock UserClock) (UserClock, error)
+	// DeleteUserClock forgets the clock of the user
+	DeleteUserClock(ctx context.Context, userIdentifier string) error
+}
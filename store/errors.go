@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/redis/go-redis/v9"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+var (
+	// ErrUserNotFound is returned wrapped when a user has no record of the word
+	// an update targets, e.g. because it was deleted or purged meanwhile. It is
+	// not retryable as is, the search should be logged again instead.
+	ErrUserNotFound = errors.New("user search not found")
+
+	// ErrWordNotFound is returned wrapped when no record of the word exists,
+	// e.g. a word still pending in the trie or purged meanwhile. It is not retryable.
+	ErrWordNotFound = errors.New("word not found")
+
+	// ErrWordExists is returned wrapped when a word is renamed to a stored word,
+	// which must be merged instead. It is not retryable.
+	ErrWordExists = errors.New("word already exists")
+
+	// ErrStoreUnavailable is returned wrapped by Classify when the database
+	// cannot be reached, timed out or is busy. It is retryable with backoff.
+	ErrStoreUnavailable = errors.New("store unavailable")
+)
+
+// PartialWriteError is returned by ApplyUserSearchWrites when the batch failed
+// after its first Applied writes reached the store. A retry must only send the
+// writes after them, the others would be counted twice.
+type PartialWriteError struct {
+	Applied int
+	Err     error
+}
+
+func (e *PartialWriteError) Error() string {
+	return fmt.Sprintf("%d writes applied: %v", e.Applied, e.Err)
+}
+
+func (e *PartialWriteError) Unwrap() error {
+	return e.Err
+}
+
+// Classify wraps err with ErrStoreUnavailable when it is a connectivity
+// failure of one of the stores: a network error, a broken or closed
+// connection, an expired deadline or a busy SQLite database. Other errors,
+// including nil, are returned unchanged.
+func Classify(err error) error {
+	if err == nil || errors.Is(err, ErrStoreUnavailable) || !unavailable(err) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrStoreUnavailable, err)
+}
+
+// unavailable reports whether err is a connectivity failure
+func unavailable(err error) bool {
+	var netErr net.Error
+	var connectErr *pgconn.ConnectError
+	var sqliteErr *sqlite.Error
+	switch {
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone),
+		errors.Is(err, context.DeadlineExceeded), errors.Is(err, redis.ErrClosed):
+		return true
+	case errors.As(err, &netErr), errors.As(err, &connectErr):
+		return true
+	case pgconn.SafeToRetry(err) || pgconn.Timeout(err):
+		return true
+	case errors.As(err, &sqliteErr):
+		code := sqliteErr.Code() & 0xff
+		return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+	}
+	return false
+}
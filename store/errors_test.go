@@ -0,0 +1,43 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassify(t *testing.T) {
+	assert.NoError(t, Classify(nil))
+
+	plain := errors.New("syntax error")
+	assert.Equal(t, plain, Classify(plain))
+
+	for _, err := range []error{
+		context.DeadlineExceeded,
+		fmt.Errorf("query: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}),
+	} {
+		classified := Classify(err)
+		assert.ErrorIs(t, classified, ErrStoreUnavailable)
+		assert.ErrorIs(t, classified, err)
+		assert.Equal(t, classified, Classify(classified), "Classifying twice should not wrap twice")
+	}
+}
+
+func TestClassifyUnreachableStore(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	db, err := NewRedisDBV2(RedisConfig{Addr: server.Addr(), QueryTimeout: 100 * time.Millisecond}, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	server.Close()
+	_, err = db.GetUserSearches(ctx, "user_1")
+	assert.ErrorIs(t, Classify(err), ErrStoreUnavailable)
+}
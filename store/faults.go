@@ -0,0 +1,127 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrInjectedFault is the failure injected by Faults.SetErrorRate, a broken
+// connection so Classify reports it as ErrStoreUnavailable
+var ErrInjectedFault = fmt.Errorf("injected fault: %w", driver.ErrBadConn)
+
+// Faults injects failures and latency into the calls of a mock store, so the
+// retry and flush recovery logic can be tested without a real database. The
+// operations are named after the store methods, e.g. "InsertOrReplace", and
+// the empty operation configures every operation without its own setting.
+// The zero Faults injects nothing, it is safe for concurrent use.
+type Faults struct {
+	mu sync.Mutex
+	// rates[op] is the share of the calls of op failing with ErrInjectedFault
+	rates map[string]float64
+	// latencies[op] is how long every call of op waits before running
+	latencies map[string]time.Duration
+	// closed fails every call with sql.ErrConnDone, like a closed connection pool
+	closed   bool
+	rng      *rand.Rand
+	injected int64
+}
+
+// SetErrorRate fails the share rate, between 0 and 1, of the calls of op with ErrInjectedFault
+func (f *Faults) SetErrorRate(op string, rate float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.rates == nil {
+		f.rates = make(map[string]float64)
+	}
+	f.rates[op] = min(max(rate, 0), 1)
+}
+
+// SetLatency delays every call of op by latency, a call whose context is
+// done meanwhile fails with the context error like a query timing out
+func (f *Faults) SetLatency(op string, latency time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.latencies == nil {
+		f.latencies = make(map[string]time.Duration)
+	}
+	f.latencies[op] = max(latency, 0)
+}
+
+// SetClosed fails every call with sql.ErrConnDone while closed, like a lost database
+func (f *Faults) SetClosed(closed bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = closed
+}
+
+// Seed makes the failures drawn by SetErrorRate reproducible
+func (f *Faults) Seed(seed int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rng = rand.New(rand.NewSource(seed))
+}
+
+// Reset stops injecting anything
+func (f *Faults) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rates, f.latencies, f.closed = nil, nil, false
+}
+
+// Injected returns how many calls failed because of the faults so far
+func (f *Faults) Injected() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.injected
+}
+
+// inject runs the faults of a call of op: it returns the error of ctx, waits
+// the latency of op, then fails the call when closed or drawn to fail
+func (f *Faults) inject(ctx context.Context, op string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	latency, ok := f.latencies[op]
+	if !ok {
+		latency = f.latencies[""]
+	}
+	f.mu.Unlock()
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		f.injected++
+		return sql.ErrConnDone
+	}
+	rate, ok := f.rates[op]
+	if !ok {
+		rate = f.rates[""]
+	}
+	if rate == 0 {
+		return nil
+	}
+	if f.rng == nil {
+		f.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	if f.rng.Float64() < rate {
+		f.injected++
+		return fmt.Errorf("%s: %w", op, ErrInjectedFault)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"sort"
+	"strings"
+)
+
+// mergeUserRecords stitches the records of two users into the records of toUser.
+// Records of the same word are merged, and every word that is a prefix of a
+// longer word of the combined history is merged into the longest such word, as
+// if the user had extended it. Merging sums the counts, keeps the earliest
+// first_searched_at, the latest last_updated_at and the ID of the record merged into,
+// preferring the records of toUser. The result is in word order.
+func mergeUserRecords(records []UserSearchRecord, toUser string) []UserSearchRecord {
+	// Records of toUser first, so same-word merges keep their ID
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].UserIdentifier == toUser && records[j].UserIdentifier != toUser
+	})
+
+	byWord := make(map[string]*UserSearchRecord)
+	for _, record := range records {
+		if existing, ok := byWord[record.SearchWord]; ok {
+			mergeRecordInto(existing, record)
+			continue
+		}
+		stitched := record
+		stitched.UserIdentifier = toUser
+		byWord[record.SearchWord] = &stitched
+	}
+
+	words := make([]string, 0, len(byWord))
+	for word := range byWord {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+
+	// A longest extension of a word cannot be extended itself, so one pass resolves every chain
+	for _, word := range words {
+		if target, ok := longestExtension(words, word); ok {
+			mergeRecordInto(byWord[target], *byWord[word])
+			delete(byWord, word)
+		}
+	}
+
+	merged := make([]UserSearchRecord, 0, len(byWord))
+	for _, word := range words {
+		if record, ok := byWord[word]; ok {
+			merged = append(merged, *record)
+		}
+	}
+	return merged
+}
+
+// mergeRecordInto merges the counts and timestamps of record into target, keeping the word and ID of target,
+// and its surface form unless it has none
+func mergeRecordInto(target *UserSearchRecord, record UserSearchRecord) {
+	target.SearchCount += record.SearchCount
+	if target.SurfaceWord == "" {
+		target.SurfaceWord = record.SurfaceWord
+	}
+	if record.FirstSearchedAt.Before(target.FirstSearchedAt) {
+		target.FirstSearchedAt = record.FirstSearchedAt
+	}
+	if record.LastUpdatedAt.After(target.LastUpdatedAt) {
+		target.LastUpdatedAt = record.LastUpdatedAt
+	}
+}
+
+// mergeWordRecords merges the records of from into the record of to of the same
+// user, or renames them to to for the users without one. It returns the records
+// to write back by ID and the IDs of the records to delete.
+func mergeWordRecords(records []UserSearchRecord, from, to string) ([]UserSearchRecord, []int64) {
+	targets := make(map[string]*UserSearchRecord)
+	for i := range records {
+		if records[i].SearchWord == to {
+			targets[records[i].UserIdentifier] = &records[i]
+		}
+	}
+
+	var updates []UserSearchRecord
+	var deletes []int64
+	for _, record := range records {
+		if record.SearchWord != from {
+			continue
+		}
+		if target, ok := targets[record.UserIdentifier]; ok {
+			mergeRecordInto(target, record)
+			updates = append(updates, *target)
+			deletes = append(deletes, record.ID)
+			continue
+		}
+		record.SearchWord = to
+		updates = append(updates, record)
+	}
+	return updates, deletes
+}
+
+// mergeSearchRecord merges the counts, timestamps and verified flag of record
+// into target, keeping the word and ID of target
+func mergeSearchRecord(target *SearchRecord, record SearchRecord) {
+	target.SearchCount += record.SearchCount
+	target.Verified = target.Verified || record.Verified
+	if record.FirstSearchedAt.Before(target.FirstSearchedAt) {
+		target.FirstSearchedAt = record.FirstSearchedAt
+	}
+	if record.LastUpdatedAt.After(target.LastUpdatedAt) {
+		target.LastUpdatedAt = record.LastUpdatedAt
+	}
+}
+
+// longestExtension returns the longest of the sorted words having word as a
+// proper prefix, the first in word order among equally long ones
+func longestExtension(sorted []string, word string) (string, bool) {
+	var longest string
+	// Every extension sorts right after word
+	for i := sort.SearchStrings(sorted, word); i < len(sorted) && strings.HasPrefix(sorted[i], word); i++ {
+		if len(sorted[i]) > len(longest) && sorted[i] != word {
+			longest = sorted[i]
+		}
+	}
+	return longest, longest != ""
+}
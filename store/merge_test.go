@@ -0,0 +1,208 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeUserSearches(t *testing.T) {
+	ctx := context.Background()
+	db := NewMockPostgresDBV2()
+	t0 := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	for _, search := range []struct {
+		user string
+		word string
+		at   time.Time
+	}{
+		{"anon_1", "bus", t0},
+		{"anon_1", "cat", t0.Add(time.Hour)},
+		{"anon_1", "doge", t0},
+		{"user_1", "business", t0.Add(2 * time.Hour)},
+		{"user_1", "cat", t0.Add(-time.Hour)},
+		{"user_1", "dog", t0.Add(3 * time.Hour)},
+		{"user_1", "dog", t0.Add(3 * time.Hour)},
+		{"user_2", "bus", t0},
+	} {
+		_, err := db.InsertOrUpdateUserSearch(ctx, search.user, search.word, search.at, search.at)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, db.SetSurfaceWord(ctx, "anon_1", "cat", "cats"))
+	require.NoError(t, db.MergeUserSearches(ctx, "anon_1", "user_1"))
+
+	var records []UserSearchRecord
+	require.NoError(t, db.ForEachUserSearch(ctx, "user_1", func(record UserSearchRecord) error {
+		records = append(records, record)
+		return nil
+	}))
+	for i := range records {
+		records[i].ID = 0
+	}
+
+	// "bus" merges into the longer "business" and "dog" into "doge" across both
+	// histories, the same word "cat" is summed keeping its earliest first search
+	// and the only surface form
+	assert.Equal(t, []UserSearchRecord{
+		{UserIdentifier: "user_1", SearchWord: "business", FirstSearchedAt: t0, LastUpdatedAt: t0.Add(2 * time.Hour), SearchCount: 2},
+		{UserIdentifier: "user_1", SearchWord: "cat", FirstSearchedAt: t0.Add(-time.Hour), LastUpdatedAt: t0.Add(time.Hour), SearchCount: 2, SurfaceWord: "cats"},
+		{UserIdentifier: "user_1", SearchWord: "doge", FirstSearchedAt: t0, LastUpdatedAt: t0.Add(3 * time.Hour), SearchCount: 3},
+	}, records)
+
+	searches, err := db.GetUserSearches(ctx, "anon_1")
+	require.NoError(t, err)
+	assert.Empty(t, searches)
+
+	searches, err = db.GetUserSearches(ctx, "user_2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bus"}, searches, "Other users are left alone")
+}
+
+func TestLongestExtension(t *testing.T) {
+	words := []string{"bu", "bus", "bush", "business", "busy", "cat"}
+
+	longest, ok := longestExtension(words, "bu")
+	assert.True(t, ok)
+	assert.Equal(t, "business", longest)
+
+	longest, ok = longestExtension(words, "bus")
+	assert.True(t, ok)
+	assert.Equal(t, "business", longest)
+
+	_, ok = longestExtension(words, "busy")
+	assert.False(t, ok)
+
+	_, ok = longestExtension(words, "ca")
+	assert.True(t, ok)
+}
+
+func TestMergeUserWords(t *testing.T) {
+	type curationStore interface {
+		UserCurationStore
+		UserExportStore
+	}
+	for _, tt := range []struct {
+		name string
+		open func(t *testing.T) curationStore
+	}{
+		{"mock", func(t *testing.T) curationStore {
+			return NewMockPostgresDBV2()
+		}},
+		{"sqlite", func(t *testing.T) curationStore {
+			db, err := NewSQLiteDBV2(sqliteConfig(t))
+			require.NoError(t, err)
+			t.Cleanup(func() { db.Close() })
+			return db
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := tt.open(t)
+			require.NoError(t, db.CreateTable(ctx))
+			t0 := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+			for _, search := range []struct {
+				user string
+				word string
+				at   time.Time
+			}{
+				{"user_1", "nyc", t0},
+				{"user_1", "nyc", t0.Add(time.Hour)},
+				{"user_1", "new york", t0.Add(-time.Hour)},
+				{"user_2", "nyc", t0},
+				{"user_3", "new york", t0},
+			} {
+				_, err := db.InsertOrUpdateUserSearch(ctx, search.user, search.word, search.at, search.at)
+				require.NoError(t, err)
+			}
+
+			moved, err := db.MergeUserWords(ctx, "nyc", "new york")
+			require.NoError(t, err)
+			assert.Equal(t, int64(2), moved)
+
+			// user_1 sums both records, user_2 has "nyc" renamed, user_3 is left alone
+			for user, want := range map[string]UserSearchRecord{
+				"user_1": {UserIdentifier: "user_1", SearchWord: "new york", FirstSearchedAt: t0.Add(-time.Hour), LastUpdatedAt: t0.Add(time.Hour), SearchCount: 3},
+				"user_2": {UserIdentifier: "user_2", SearchWord: "new york", FirstSearchedAt: t0, LastUpdatedAt: t0, SearchCount: 1},
+				"user_3": {UserIdentifier: "user_3", SearchWord: "new york", FirstSearchedAt: t0, LastUpdatedAt: t0, SearchCount: 1},
+			} {
+				var records []UserSearchRecord
+				require.NoError(t, db.ForEachUserSearch(ctx, user, func(record UserSearchRecord) error {
+					record.ID = 0
+					record.FirstSearchedAt = record.FirstSearchedAt.UTC()
+					record.LastUpdatedAt = record.LastUpdatedAt.UTC()
+					records = append(records, record)
+					return nil
+				}))
+				assert.Equal(t, []UserSearchRecord{want}, records, user)
+			}
+
+			moved, err = db.MergeUserWords(ctx, "nyc", "new york")
+			require.NoError(t, err)
+			assert.Zero(t, moved)
+		})
+	}
+}
+
+func TestMergeWords(t *testing.T) {
+	type curationStore interface {
+		SearchCurationStore
+		VerifiedSearchStore
+		TopSearchStore
+	}
+	for _, tt := range []struct {
+		name string
+		open func(t *testing.T) curationStore
+	}{
+		{"mock", func(t *testing.T) curationStore {
+			return NewMockPostgresDB()
+		}},
+		{"sqlite", func(t *testing.T) curationStore {
+			db, err := NewSQLiteDB(sqliteConfig(t))
+			require.NoError(t, err)
+			t.Cleanup(func() { db.Close() })
+			return db
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := tt.open(t)
+			require.NoError(t, db.CreateTable(ctx))
+			now := time.Now()
+
+			for _, word := range []string{"nyc", "nyc", "new york", "bsu", "bus"} {
+				_, err := db.InsertOrReplace(ctx, word, now, now)
+				require.NoError(t, err)
+			}
+			require.NoError(t, db.SetVerified(ctx, "nyc", true))
+
+			_, err := db.RenameWord(ctx, "bsu", "bus")
+			assert.ErrorIs(t, err, ErrWordExists)
+			_, err = db.RenameWord(ctx, "cat", "cats")
+			assert.ErrorIs(t, err, ErrWordNotFound)
+			_, err = db.MergeWords(ctx, "cat", "cats")
+			assert.ErrorIs(t, err, ErrWordNotFound)
+
+			renamed, err := db.RenameWord(ctx, "bus", "buses")
+			require.NoError(t, err)
+			merged, err := db.MergeWords(ctx, "bsu", "buses")
+			require.NoError(t, err)
+			assert.Equal(t, renamed, merged)
+			_, err = db.MergeWords(ctx, "nyc", "new york")
+			require.NoError(t, err)
+
+			top, err := db.TopSearches(ctx, time.Time{}, 10)
+			require.NoError(t, err)
+			assert.Equal(t, []WordCount{{Word: "new york", Count: 3}, {Word: "buses", Count: 2}}, top)
+
+			// The merged record is verified if either was
+			verified, err := db.GetVerifiedWords(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"new york"}, verified)
+		})
+	}
+}
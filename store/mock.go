@@ -0,0 +1,614 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MockPostgresDB simulates PostgreSQL database operations
+// Instead of using a real database, it uses an in-memory map
+// so we can focus on the core business logic, the logging function
+type MockPostgresDB struct {
+	// map[serialID]SearchRecord
+	searches map[int64]SearchRecord
+	// displays are the display forms by record ID, see SearchDisplayStore
+	displays map[int64]string
+	// metadata are the payloads by record ID, see SearchMetadataStore
+	metadata map[int64]json.RawMessage
+	// categories are the category tags by record ID, see SearchCategoryStore
+	categories map[int64]map[string]bool
+	nextID     int64
+	mutex      sync.RWMutex
+	// faults are injected into every call, see Faults
+	faults Faults
+}
+
+type SearchRecord struct {
+	ID              int64
+	Word            string
+	FirstSearchedAt time.Time
+	LastUpdatedAt   time.Time
+	SearchCount     int
+	// Verified is set once a moderator reviewed the word, see VerifiedSearchStore
+	Verified bool
+}
+
+// NewMockPostgresDB creates a new mock PostgreSQL database
+func NewMockPostgresDB() *MockPostgresDB {
+	return &MockPostgresDB{
+		searches:   make(map[int64]SearchRecord),
+		displays:   make(map[int64]string),
+		metadata:   make(map[int64]json.RawMessage),
+		categories: make(map[int64]map[string]bool),
+		nextID:     1,
+	}
+}
+
+// CreateTable simulates creating the searches table
+func (db *MockPostgresDB) CreateTable(ctx context.Context) error {
+	if err := db.faults.inject(ctx, "CreateTable"); err != nil {
+		return err
+	}
+
+	log.Println("Mock PostgreSQL: CREATE TABLE searches (id SERIAL PRIMARY KEY, word VARCHAR UNIQUE, first_searched_at TIMESTAMP, last_updated_at TIMESTAMP, search_count INTEGER DEFAULT 1, verified BOOLEAN DEFAULT FALSE)")
+	return nil
+}
+
+// InsertOrReplace simulates INSERT ... ON CONFLICT UPDATE
+func (db *MockPostgresDB) InsertOrReplace(ctx context.Context, word string, firstSearched, lastUpdated time.Time) (int64, error) {
+	if err := db.faults.inject(ctx, "InsertOrReplace"); err != nil {
+		return 0, err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	return db.insertOrReplace(word, firstSearched, lastUpdated), nil
+}
+
+// InsertOrReplaceBatch simulates a multi-row INSERT ... ON CONFLICT UPDATE
+func (db *MockPostgresDB) InsertOrReplaceBatch(ctx context.Context, words []string, firstSearched, lastUpdated time.Time) ([]int64, error) {
+	if err := db.faults.inject(ctx, "InsertOrReplaceBatch"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	ids := make([]int64, len(words))
+	for i, word := range words {
+		ids[i] = db.insertOrReplace(word, firstSearched, lastUpdated)
+	}
+
+	log.Printf("Mock PostgreSQL: batch INSERT INTO searches ... ON CONFLICT UPDATE - %d rows", len(words))
+	return ids, nil
+}
+
+// insertOrReplace inserts or bumps a word, caller must hold the write lock
+func (db *MockPostgresDB) insertOrReplace(word string, firstSearched, lastUpdated time.Time) int64 {
+	// Check if word already exists
+	for id, record := range db.searches {
+		if record.Word == word {
+			record.LastUpdatedAt = lastUpdated
+			record.SearchCount++
+			db.searches[id] = record
+			log.Printf("Mock PostgreSQL: UPDATE searches SET last_updated_at='%s', search_count=%d WHERE word='%s'",
+				lastUpdated.Format(time.RFC3339), record.SearchCount, word)
+			return id
+		}
+	}
+
+	// Insert new record
+	id := db.nextID
+	db.nextID++
+
+	db.searches[id] = SearchRecord{
+		ID:              id,
+		Word:            word,
+		FirstSearchedAt: firstSearched,
+		LastUpdatedAt:   lastUpdated,
+		SearchCount:     1,
+	}
+
+	log.Printf("Mock PostgreSQL: INSERT INTO searches (word, first_searched_at, last_updated_at) VALUES ('%s', '%s', '%s') RETURNING id=%d",
+		word, firstSearched.Format(time.RFC3339), lastUpdated.Format(time.RFC3339), id)
+
+	return id
+}
+
+// Update simulates updating an existing record
+func (db *MockPostgresDB) Update(ctx context.Context, id int64, newWord string, lastUpdated time.Time) error {
+	if err := db.faults.inject(ctx, "Update"); err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	record, exists := db.searches[id]
+	if !exists {
+		return fmt.Errorf("record with id %d not found", id)
+	}
+
+	record.Word = newWord
+	record.LastUpdatedAt = lastUpdated
+	record.SearchCount++
+	db.searches[id] = record
+
+	log.Printf("Mock PostgreSQL: UPDATE searches SET word='%s', last_updated_at='%s', search_count=%d WHERE id=%d",
+		newWord, lastUpdated.Format(time.RFC3339), record.SearchCount, id)
+
+	return nil
+}
+
+// UpdateBatch simulates a batch of UPDATE statements in one transaction
+func (db *MockPostgresDB) UpdateBatch(ctx context.Context, updates []WordUpdate) error {
+	if err := db.faults.inject(ctx, "UpdateBatch"); err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	for _, update := range updates {
+		if _, exists := db.searches[update.ID]; !exists {
+			return fmt.Errorf("record with id %d not found", update.ID)
+		}
+	}
+
+	for _, update := range updates {
+		record := db.searches[update.ID]
+		record.Word = update.Word
+		record.LastUpdatedAt = update.LastUpdatedAt
+		record.SearchCount += update.Count
+		db.searches[update.ID] = record
+	}
+
+	log.Printf("Mock PostgreSQL: batch UPDATE searches SET word, last_updated_at, search_count - %d rows", len(updates))
+	return nil
+}
+
+// GetAllSearchedWords simulates SELECT word FROM searches ORDER BY word
+func (db *MockPostgresDB) GetAllSearchedWords(ctx context.Context) ([]string, error) {
+	if err := db.faults.inject(ctx, "GetAllSearchedWords"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	words := make([]string, 0, len(db.searches))
+	for _, record := range db.searches {
+		words = append(words, record.Word)
+	}
+
+	log.Printf("Mock PostgreSQL: SELECT word FROM searches ORDER BY word - returned %d records", len(words))
+	return words, nil
+}
+
+// GetAllRecords simulates SELECT * FROM searches ORDER BY id
+func (db *MockPostgresDB) GetAllRecords(ctx context.Context) ([]SearchRecord, error) {
+	if err := db.faults.inject(ctx, "GetAllRecords"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	records := make([]SearchRecord, 0, len(db.searches))
+	for _, record := range db.searches {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+
+	log.Printf("Mock PostgreSQL: SELECT * FROM searches - returned %d records", len(records))
+	return records, nil
+}
+
+// CountSearches returns the number of records and the sum of their search counts
+func (db *MockPostgresDB) CountSearches(ctx context.Context) (records, searches int64, err error) {
+	if err := db.faults.inject(ctx, "CountSearches"); err != nil {
+		return 0, 0, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	for _, record := range db.searches {
+		searches += int64(record.SearchCount)
+	}
+	return int64(len(db.searches)), searches, nil
+}
+
+// TopSearches simulates SELECT word, search_count ... ORDER BY search_count DESC LIMIT
+func (db *MockPostgresDB) TopSearches(ctx context.Context, since time.Time, limit int) ([]WordCount, error) {
+	if err := db.faults.inject(ctx, "TopSearches"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	counts := make(map[string]int)
+	for _, record := range db.searches {
+		if !record.LastUpdatedAt.Before(since) {
+			counts[record.Word] += record.SearchCount
+		}
+	}
+
+	log.Printf("Mock PostgreSQL: SELECT word, search_count FROM searches ORDER BY search_count DESC LIMIT %d", limit)
+	return topWordCounts(counts, limit), nil
+}
+
+// PurgeSearches simulates DELETE FROM searches WHERE last_updated_at < $1 RETURNING word
+func (db *MockPostgresDB) PurgeSearches(ctx context.Context, cutoff time.Time) ([]string, error) {
+	if err := db.faults.inject(ctx, "PurgeSearches"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	var words []string
+	for id, record := range db.searches {
+		if record.LastUpdatedAt.Before(cutoff) {
+			delete(db.searches, id)
+			words = append(words, record.Word)
+		}
+	}
+
+	log.Printf("Mock PostgreSQL: DELETE FROM searches WHERE last_updated_at < %s - deleted %d records", cutoff.Format(time.RFC3339), len(words))
+	return words, nil
+}
+
+// QuerySearches simulates SELECT ... FROM searches WHERE ... ORDER BY ... LIMIT ... OFFSET
+func (db *MockPostgresDB) QuerySearches(ctx context.Context, query SearchQuery) ([]SearchRecord, error) {
+	if err := db.faults.inject(ctx, "QuerySearches"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	records := make([]SearchRecord, 0)
+	for _, record := range db.searches {
+		if query.matches(record) {
+			records = append(records, record)
+		}
+	}
+
+	log.Printf("Mock PostgreSQL: SELECT * FROM searches WHERE ... LIMIT %d OFFSET %d", query.Limit, query.Offset)
+	return query.sortAndPage(records), nil
+}
+
+// ForEachSearch simulates streaming SELECT ... FROM searches WHERE ... ORDER BY ...
+func (db *MockPostgresDB) ForEachSearch(ctx context.Context, query SearchQuery, fn func(SearchRecord) error) error {
+	// Copy the records so fn runs without holding the lock
+	records, err := db.QuerySearches(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetVerified simulates UPDATE searches SET verified = $1 WHERE word = $2
+func (db *MockPostgresDB) SetVerified(ctx context.Context, word string, verified bool) error {
+	if err := db.faults.inject(ctx, "SetVerified"); err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	for id, record := range db.searches {
+		if record.Word == word {
+			record.Verified = verified
+			db.searches[id] = record
+			log.Printf("Mock PostgreSQL: UPDATE searches SET verified=%t WHERE word='%s'", verified, word)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", ErrWordNotFound, word)
+}
+
+// SetDisplayWord simulates UPDATE searches SET display_word = $1 WHERE word = $2
+func (db *MockPostgresDB) SetDisplayWord(ctx context.Context, word, display string) error {
+	if err := db.faults.inject(ctx, "SetDisplayWord"); err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	for id, record := range db.searches {
+		if record.Word == word {
+			db.displays[id] = display
+			log.Printf("Mock PostgreSQL: UPDATE searches SET display_word='%s' WHERE word='%s'", display, word)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", ErrWordNotFound, word)
+}
+
+// GetDisplayWords simulates SELECT word, display_word FROM searches WHERE display_word IS NOT NULL
+func (db *MockPostgresDB) GetDisplayWords(ctx context.Context) (map[string]string, error) {
+	if err := db.faults.inject(ctx, "GetDisplayWords"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	displays := make(map[string]string, len(db.displays))
+	for id, display := range db.displays {
+		// The display forms of deleted records are left behind
+		if record, ok := db.searches[id]; ok {
+			displays[record.Word] = display
+		}
+	}
+
+	log.Printf("Mock PostgreSQL: SELECT word, display_word FROM searches WHERE display_word IS NOT NULL - returned %d records", len(displays))
+	return displays, nil
+}
+
+// SetMetadata simulates UPDATE searches SET metadata = $1 WHERE word = $2
+func (db *MockPostgresDB) SetMetadata(ctx context.Context, word string, metadata json.RawMessage) error {
+	if err := db.faults.inject(ctx, "SetMetadata"); err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	record, ok := db.lookup(word)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrWordNotFound, word)
+	}
+	if len(metadata) == 0 {
+		delete(db.metadata, record.ID)
+	} else {
+		db.metadata[record.ID] = append(json.RawMessage(nil), metadata...)
+	}
+	log.Printf("Mock PostgreSQL: UPDATE searches SET metadata='%s' WHERE word='%s'", metadata, word)
+	return nil
+}
+
+// GetMetadata simulates SELECT word, metadata FROM searches WHERE metadata IS NOT NULL
+func (db *MockPostgresDB) GetMetadata(ctx context.Context) (map[string]json.RawMessage, error) {
+	if err := db.faults.inject(ctx, "GetMetadata"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	metadata := make(map[string]json.RawMessage, len(db.metadata))
+	for id, payload := range db.metadata {
+		// The payloads of deleted records are left behind
+		if record, ok := db.searches[id]; ok {
+			metadata[record.Word] = append(json.RawMessage(nil), payload...)
+		}
+	}
+
+	log.Printf("Mock PostgreSQL: SELECT word, metadata FROM searches WHERE metadata IS NOT NULL - returned %d records", len(metadata))
+	return metadata, nil
+}
+
+// TagCategories simulates INSERT INTO search_categories ... ON CONFLICT DO NOTHING
+func (db *MockPostgresDB) TagCategories(ctx context.Context, word string, categories []string) error {
+	if err := db.faults.inject(ctx, "TagCategories"); err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	record, ok := db.lookup(word)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrWordNotFound, word)
+	}
+	tags := db.categories[record.ID]
+	if tags == nil {
+		tags = make(map[string]bool)
+		db.categories[record.ID] = tags
+	}
+	for _, category := range categories {
+		tags[category] = true
+	}
+	log.Printf("Mock PostgreSQL: INSERT INTO search_categories %v FOR word='%s'", categories, word)
+	return nil
+}
+
+// GetCategories simulates SELECT word, category FROM searches JOIN search_categories
+func (db *MockPostgresDB) GetCategories(ctx context.Context) (map[string][]string, error) {
+	if err := db.faults.inject(ctx, "GetCategories"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	categories := make(map[string][]string, len(db.categories))
+	for id, tags := range db.categories {
+		// The tags of deleted records are left behind
+		record, ok := db.searches[id]
+		if !ok {
+			continue
+		}
+		for category := range tags {
+			categories[record.Word] = append(categories[record.Word], category)
+		}
+		sort.Strings(categories[record.Word])
+	}
+
+	log.Printf("Mock PostgreSQL: SELECT word, category FROM searches JOIN search_categories - returned %d records", len(categories))
+	return categories, nil
+}
+
+// TopSearchesInCategory simulates TopSearches joined with the search_categories of category
+func (db *MockPostgresDB) TopSearchesInCategory(ctx context.Context, category string, since time.Time, limit int) ([]WordCount, error) {
+	if err := db.faults.inject(ctx, "TopSearchesInCategory"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	counts := make(map[string]int)
+	for id, record := range db.searches {
+		if db.categories[id][category] && !record.LastUpdatedAt.Before(since) {
+			counts[record.Word] += record.SearchCount
+		}
+	}
+
+	log.Printf("Mock PostgreSQL: SELECT word, search_count FROM searches JOIN search_categories WHERE category='%s' ORDER BY search_count DESC LIMIT %d", category, limit)
+	return topWordCounts(counts, limit), nil
+}
+
+// GetVerifiedWords simulates SELECT word FROM searches WHERE verified
+func (db *MockPostgresDB) GetVerifiedWords(ctx context.Context) ([]string, error) {
+	if err := db.faults.inject(ctx, "GetVerifiedWords"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	words := make([]string, 0)
+	for _, record := range db.searches {
+		if record.Verified {
+			words = append(words, record.Word)
+		}
+	}
+	sort.Strings(words)
+
+	log.Printf("Mock PostgreSQL: SELECT word FROM searches WHERE verified - returned %d records", len(words))
+	return words, nil
+}
+
+// ListUnverified simulates SELECT word, search_count ... WHERE NOT verified ORDER BY search_count DESC LIMIT
+func (db *MockPostgresDB) ListUnverified(ctx context.Context, limit int) ([]WordCount, error) {
+	if err := db.faults.inject(ctx, "ListUnverified"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	counts := make(map[string]int)
+	for _, record := range db.searches {
+		if !record.Verified {
+			counts[record.Word] += record.SearchCount
+		}
+	}
+
+	log.Printf("Mock PostgreSQL: SELECT word, search_count FROM searches WHERE NOT verified ORDER BY search_count DESC LIMIT %d", limit)
+	return topWordCounts(counts, limit), nil
+}
+
+// RenameWord simulates UPDATE searches SET word = $2 WHERE word = $1 RETURNING id
+func (db *MockPostgresDB) RenameWord(ctx context.Context, oldWord, newWord string) (int64, error) {
+	if err := db.faults.inject(ctx, "RenameWord"); err != nil {
+		return 0, err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if _, ok := db.lookup(newWord); ok {
+		return 0, fmt.Errorf("%w: %q", ErrWordExists, newWord)
+	}
+	record, ok := db.lookup(oldWord)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrWordNotFound, oldWord)
+	}
+	record.Word = newWord
+	db.searches[record.ID] = record
+
+	log.Printf("Mock PostgreSQL: UPDATE searches SET word='%s' WHERE word='%s'", newWord, oldWord)
+	return record.ID, nil
+}
+
+// MergeWords simulates a transaction updating the record of to and deleting the record of from
+func (db *MockPostgresDB) MergeWords(ctx context.Context, from, to string) (int64, error) {
+	if err := db.faults.inject(ctx, "MergeWords"); err != nil {
+		return 0, err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	record, ok := db.lookup(from)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrWordNotFound, from)
+	}
+	target, ok := db.lookup(to)
+	if !ok {
+		target = record
+		target.Word = to
+	} else {
+		mergeSearchRecord(&target, record)
+		delete(db.searches, record.ID)
+	}
+	db.searches[target.ID] = target
+
+	log.Printf("Mock PostgreSQL: BEGIN; UPDATE searches SET search_count=%d WHERE word='%s'; DELETE FROM searches WHERE word='%s'; COMMIT", target.SearchCount, to, from)
+	return target.ID, nil
+}
+
+// lookup returns the record of word, caller must hold the mutex
+func (db *MockPostgresDB) lookup(word string) (SearchRecord, bool) {
+	for _, record := range db.searches {
+		if record.Word == word {
+			return record, true
+		}
+	}
+	return SearchRecord{}, false
+}
+
+// topWordCounts ranks words by count, most searched first and ties in word order
+func topWordCounts(counts map[string]int, limit int) []WordCount {
+	top := make([]WordCount, 0, len(counts))
+	for word, count := range counts {
+		top = append(top, WordCount{Word: word, Count: count})
+	}
+
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Word < top[j].Word
+	})
+
+	if limit >= 0 && len(top) > limit {
+		top = top[:limit]
+	}
+	return top
+}
+
+// Ping succeeds unless faults are injected, the mock has no connection
+func (db *MockPostgresDB) Ping(ctx context.Context) error {
+	return db.faults.inject(ctx, "Ping")
+}
+
+// Faults returns the failures and latency injected into the calls of db
+func (db *MockPostgresDB) Faults() *Faults {
+	return &db.faults
+}
+
+// Close simulates closing database connections
+func (db *MockPostgresDB) Close() error {
+	log.Println("Mock PostgreSQL: Database connection closed")
+	return nil
+}
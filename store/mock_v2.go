@@ -0,0 +1,1116 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MockPostgresDBV2 simulates PostgreSQL database operations for Version 2
+type MockPostgresDBV2 struct {
+	// map[recordId]UserSearchRecord
+	userSearches map[string]UserSearchRecord
+	// byUser indexes the record IDs by user and word like the UNIQUE(user_identifier, search_word) index
+	byUser map[string]map[string]int64
+	nextID int64
+	// rollups are the rollup tables, their rows by bucket
+	rollups map[Granularity]map[time.Time][]SearchRollup
+	// rolledUpUntil is the search_rollup_watermarks table
+	rolledUpUntil map[Granularity]time.Time
+	// audiences is the search_audiences table by word
+	audiences map[string]*mockAudience
+	// clocks is the user_clocks table by user
+	clocks map[string]UserClock
+	// regions are the country and locale columns by record ID, see UserRegionStore
+	regions map[int64]Region
+	// audit is the audit_log table in ID order
+	audit []AuditEntry
+	// quarantine is the quarantined_searches table in ID order
+	quarantine       []QuarantinedSearch
+	nextQuarantineID int64
+	// clicks is the search_clicks table in insertion order
+	clicks []SearchClick
+	// zeroResults is the zero_result_terms table by word
+	zeroResults map[string]ZeroResultTerm
+	// synonyms is the search_synonyms table, the canonical words by alias
+	synonyms map[string]string
+	mutex    sync.RWMutex
+	// faults are injected into every call, see Faults
+	faults Faults
+	// userLocks are the locks of WithUserLock by user, each one a channel holding a token while free
+	userLocksMutex sync.Mutex
+	userLocks      map[string]*mockUserLock
+}
+
+// mockUserLock is the lock of a user, dropped once nobody holds or waits for it
+type mockUserLock struct {
+	free  chan struct{}
+	users int
+}
+
+// mockAudience is a row of the search_audiences table
+type mockAudience struct {
+	registers     []byte
+	users         int64
+	lastUpdatedAt time.Time
+}
+
+type UserSearchRecord struct {
+	ID int64
+	// user_id for logged-in; anon_id for guest
+	UserIdentifier  string
+	SearchWord      string
+	FirstSearchedAt time.Time
+	LastUpdatedAt   time.Time
+	SearchCount     int
+	// SurfaceWord is the form the user typed when SearchWord is a stem, empty otherwise
+	SurfaceWord string
+}
+
+// NewMockPostgresDBV2 creates a new mock PostgreSQL database for Version 2
+func NewMockPostgresDBV2() *MockPostgresDBV2 {
+	return &MockPostgresDBV2{
+		userSearches: make(map[string]UserSearchRecord),
+		byUser:       make(map[string]map[string]int64),
+		nextID:       1,
+		rollups: map[Granularity]map[time.Time][]SearchRollup{
+			Hourly: make(map[time.Time][]SearchRollup),
+			Daily:  make(map[time.Time][]SearchRollup),
+		},
+		rolledUpUntil: make(map[Granularity]time.Time),
+		audiences:     make(map[string]*mockAudience),
+		clocks:        make(map[string]UserClock),
+		regions:       make(map[int64]Region),
+		zeroResults:   make(map[string]ZeroResultTerm),
+		synonyms:      make(map[string]string),
+	}
+}
+
+// lookup finds the record of a user's word through the byUser index, caller must hold the mutex
+func (db *MockPostgresDBV2) lookup(userIdentifier, word string) (UserSearchRecord, bool) {
+	id, ok := db.byUser[userIdentifier][word]
+	if !ok {
+		return UserSearchRecord{}, false
+	}
+	return db.userSearches[fmt.Sprintf("%d", id)], true
+}
+
+// userRecords returns the records of a user through the byUser index, caller must hold the mutex
+func (db *MockPostgresDBV2) userRecords(userIdentifier string) []UserSearchRecord {
+	words := db.byUser[userIdentifier]
+	records := make([]UserSearchRecord, 0, len(words))
+	for _, id := range words {
+		records = append(records, db.userSearches[fmt.Sprintf("%d", id)])
+	}
+	return records
+}
+
+// put stores a record and indexes it, caller must hold the write lock
+func (db *MockPostgresDBV2) put(record UserSearchRecord) {
+	db.userSearches[fmt.Sprintf("%d", record.ID)] = record
+	words, ok := db.byUser[record.UserIdentifier]
+	if !ok {
+		words = make(map[string]int64)
+		db.byUser[record.UserIdentifier] = words
+	}
+	words[record.SearchWord] = record.ID
+}
+
+// remove deletes a record and its index entry, caller must hold the write lock
+func (db *MockPostgresDBV2) remove(record UserSearchRecord) {
+	delete(db.userSearches, fmt.Sprintf("%d", record.ID))
+	words := db.byUser[record.UserIdentifier]
+	delete(words, record.SearchWord)
+	if len(words) == 0 {
+		delete(db.byUser, record.UserIdentifier)
+	}
+}
+
+// CreateTable simulates creating the user_searches table
+func (db *MockPostgresDBV2) CreateTable(ctx context.Context) error {
+	if err := db.faults.inject(ctx, "CreateTable"); err != nil {
+		return err
+	}
+
+	// log.Println("CREATE TABLE user_searches (id SERIAL PRIMARY KEY, user_identifier VARCHAR, search_word VARCHAR, first_searched_at TIMESTAMP, last_updated_at TIMESTAMP, search_count INTEGER DEFAULT 1, UNIQUE(user_identifier, search_word)); CREATE INDEX user_searches_last_updated_at_idx ON user_searches (last_updated_at)")
+	return nil
+}
+
+// InsertOrUpdateUserSearch simulates INSERT ... ON CONFLICT UPDATE
+func (db *MockPostgresDBV2) InsertOrUpdateUserSearch(ctx context.Context, userIdentifier, word string, firstSearched, lastUpdated time.Time) (int64, error) {
+	if err := db.faults.inject(ctx, "InsertOrUpdateUserSearch"); err != nil {
+		return 0, err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	return db.insertOrUpdateLocked(userIdentifier, word, firstSearched, lastUpdated), nil
+}
+
+// insertOrUpdateLocked is InsertOrUpdateUserSearch, caller must hold the write lock
+func (db *MockPostgresDBV2) insertOrUpdateLocked(userIdentifier, word string, firstSearched, lastUpdated time.Time) int64 {
+	// Check if this user-word combination already exists
+	if record, ok := db.lookup(userIdentifier, word); ok {
+		// Update existing record
+		record.LastUpdatedAt = lastUpdated
+		record.SearchCount++
+		db.put(record)
+
+		// log.Printf("UPDATE user_searches SET last_updated_at='%s', search_count=%d WHERE user_identifier='%s' AND search_word='%s'",
+		//	lastUpdated.Format(time.RFC3339), record.SearchCount, userIdentifier, word)
+
+		return record.ID
+	}
+
+	// Insert new record
+	id := db.nextID
+	db.nextID++
+
+	db.put(UserSearchRecord{
+		ID:              id,
+		UserIdentifier:  userIdentifier,
+		SearchWord:      word,
+		FirstSearchedAt: firstSearched,
+		LastUpdatedAt:   lastUpdated,
+		SearchCount:     1,
+	})
+
+	// log.Printf("INSERT INTO user_searches (user_identifier, search_word, first_searched_at, last_updated_at) VALUES ('%s', '%s', '%s', '%s') RETURNING id=%d",
+	//	userIdentifier, word, firstSearched.Format(time.RFC3339), lastUpdated.Format(time.RFC3339), id)
+
+	return id
+}
+
+// GetUserSearches returns all searches for a specific user
+func (db *MockPostgresDBV2) GetUserSearches(ctx context.Context, userIdentifier string) ([]string, error) {
+	if err := db.faults.inject(ctx, "GetUserSearches"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	var words []string
+	for word := range db.byUser[userIdentifier] {
+		words = append(words, word)
+	}
+
+	// log.Printf("SELECT search_word FROM user_searches WHERE user_identifier='%s' ORDER BY search_word - returned %d records", userIdentifier, len(words))
+
+	return words, nil
+}
+
+// ForEachUserSearch simulates SELECT * FROM user_searches WHERE user_identifier = $1 ORDER BY search_word
+func (db *MockPostgresDBV2) ForEachUserSearch(ctx context.Context, userIdentifier string, fn func(UserSearchRecord) error) error {
+	if err := db.faults.inject(ctx, "ForEachUserSearch"); err != nil {
+		return err
+	}
+
+	// Copy the records so fn runs without holding the lock
+	db.mutex.RLock()
+	records := db.userRecords(userIdentifier)
+	db.mutex.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool { return records[i].SearchWord < records[j].SearchWord })
+	for _, record := range records {
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateUserSearchByWord updates a user's search record from old word to new word
+func (db *MockPostgresDBV2) UpdateUserSearchByWord(ctx context.Context, userIdentifier, oldWord, newWord string, lastUpdated time.Time) error {
+	if err := db.faults.inject(ctx, "UpdateUserSearchByWord"); err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	return db.updateByWordLocked(userIdentifier, oldWord, newWord, lastUpdated)
+}
+
+// updateByWordLocked is UpdateUserSearchByWord, caller must hold the write lock
+func (db *MockPostgresDBV2) updateByWordLocked(userIdentifier, oldWord, newWord string, lastUpdated time.Time) error {
+	// Find the record with the old word
+	oldRecord, ok := db.lookup(userIdentifier, oldWord)
+	if !ok {
+		return fmt.Errorf("%w: no record for user %s with word %s", ErrUserNotFound, userIdentifier, oldWord)
+	}
+
+	// Check if there's already a record with the new word
+	existingRecord, exists := db.lookup(userIdentifier, newWord)
+
+	// Remove the old record
+	db.remove(oldRecord)
+
+	if exists {
+		// Merge with existing record
+		mergedRecord := UserSearchRecord{
+			ID:              existingRecord.ID, // Keep existing record's ID
+			UserIdentifier:  userIdentifier,
+			SearchWord:      newWord,
+			FirstSearchedAt: existingRecord.FirstSearchedAt, // Keep earlier timestamp
+			LastUpdatedAt:   lastUpdated,
+			SearchCount:     existingRecord.SearchCount + oldRecord.SearchCount,
+			SurfaceWord:     existingRecord.SurfaceWord,
+		}
+
+		if oldRecord.FirstSearchedAt.Before(existingRecord.FirstSearchedAt) {
+			mergedRecord.FirstSearchedAt = oldRecord.FirstSearchedAt
+		}
+
+		db.put(mergedRecord)
+	} else {
+		// No existing record with new word, just update the old record
+		db.put(UserSearchRecord{
+			ID:              oldRecord.ID,
+			UserIdentifier:  userIdentifier,
+			SearchWord:      newWord,
+			FirstSearchedAt: oldRecord.FirstSearchedAt,
+			LastUpdatedAt:   lastUpdated,
+			SearchCount:     oldRecord.SearchCount + 1,
+			SurfaceWord:     oldRecord.SurfaceWord,
+		})
+	}
+
+	// log.Printf("UPDATE user_searches SET search_word='%s', last_updated_at='%s', search_count=%d WHERE user_identifier='%s' AND search_word='%s'",
+	//	newWord, lastUpdated.Format(time.RFC3339), oldRecord.SearchCount+1, userIdentifier, oldWord)
+
+	return nil
+}
+
+// ConsolidateUserSearch extends, ignores or stores word under the write lock,
+// as the single statement of PostgreSQL does
+func (db *MockPostgresDBV2) ConsolidateUserSearch(ctx context.Context, userIdentifier, word string, at time.Time) (UserConsolidation, error) {
+	if err := db.faults.inject(ctx, "ConsolidateUserSearch"); err != nil {
+		return UserConsolidation{}, err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	var consolidation UserConsolidation
+	for stored := range db.byUser[userIdentifier] {
+		switch {
+		case stored == word:
+		case strings.HasPrefix(word, stored):
+			if len(stored) > len(consolidation.Extended) {
+				consolidation.Extended = stored
+			}
+		case strings.HasPrefix(stored, word):
+			if consolidation.Extension == "" || stored < consolidation.Extension {
+				consolidation.Extension = stored
+			}
+		}
+	}
+
+	switch {
+	case consolidation.Extended != "":
+		consolidation.Extension = ""
+		if err := db.updateByWordLocked(userIdentifier, consolidation.Extended, word, at); err != nil {
+			return UserConsolidation{}, err
+		}
+	case consolidation.Extension == "":
+		db.insertOrUpdateLocked(userIdentifier, word, at, at)
+	}
+	return consolidation, nil
+}
+
+// SetSurfaceWord simulates UPDATE user_searches SET surface_word = $3 WHERE user_identifier = $1 AND search_word = $2
+func (db *MockPostgresDBV2) SetSurfaceWord(ctx context.Context, userIdentifier, word, surface string) error {
+	if err := db.faults.inject(ctx, "SetSurfaceWord"); err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if record, ok := db.lookup(userIdentifier, word); ok {
+		record.SurfaceWord = surface
+		db.put(record)
+	}
+	return nil
+}
+
+// SetUserSearchRegion simulates UPDATE user_searches SET country = $3, locale = $4 WHERE user_identifier = $1 AND search_word = $2
+func (db *MockPostgresDBV2) SetUserSearchRegion(ctx context.Context, userIdentifier, word string, region Region) error {
+	if err := db.faults.inject(ctx, "SetUserSearchRegion"); err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if record, ok := db.lookup(userIdentifier, word); ok {
+		db.regions[record.ID] = region
+	}
+	return nil
+}
+
+// TopSearchesByRegion simulates TopSearches restricted to the records whose country and locale match
+func (db *MockPostgresDBV2) TopSearchesByRegion(ctx context.Context, query RegionQuery) ([]WordCount, error) {
+	if err := db.faults.inject(ctx, "TopSearchesByRegion"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	counts := make(map[string]int)
+	for _, record := range db.userSearches {
+		// The regions of deleted records are left behind, a new ID never reuses them
+		if query.matches(db.regions[record.ID], record.LastUpdatedAt) {
+			counts[record.SearchWord] += record.SearchCount
+		}
+	}
+
+	return topWordCounts(counts, query.Limit), nil
+}
+
+// ApplyUserSearchWrites simulates applying a batch of coalesced writes in one transaction
+func (db *MockPostgresDBV2) ApplyUserSearchWrites(ctx context.Context, writes []UserSearchWrite) error {
+	if err := db.faults.inject(ctx, "ApplyUserSearchWrites"); err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	for _, write := range writes {
+		firstSearched := write.FirstSearchedAt
+		count := write.Count
+		var reuseID int64
+
+		// Merge the replaced prefixes into the new word
+		for _, word := range write.ReplaceWords {
+			record, ok := db.lookup(write.UserIdentifier, word)
+			if !ok {
+				continue
+			}
+			if record.FirstSearchedAt.Before(firstSearched) {
+				firstSearched = record.FirstSearchedAt
+			}
+			if reuseID == 0 {
+				reuseID = record.ID
+			}
+			count += record.SearchCount
+			db.remove(record)
+		}
+
+		// Merge with an existing record of the word
+		if record, ok := db.lookup(write.UserIdentifier, write.Word); ok {
+			if firstSearched.Before(record.FirstSearchedAt) {
+				record.FirstSearchedAt = firstSearched
+			}
+			record.LastUpdatedAt = write.LastUpdatedAt
+			record.SearchCount += count
+			db.put(record)
+			continue
+		}
+
+		// Keep the ID of an extended prefix like UpdateUserSearchByWord does
+		id := reuseID
+		if id == 0 {
+			id = db.nextID
+			db.nextID++
+		}
+		db.put(UserSearchRecord{
+			ID:              id,
+			UserIdentifier:  write.UserIdentifier,
+			SearchWord:      write.Word,
+			FirstSearchedAt: firstSearched,
+			LastUpdatedAt:   write.LastUpdatedAt,
+			SearchCount:     count,
+		})
+	}
+
+	// log.Printf("BEGIN; DELETE FROM user_searches ... RETURNING ...; INSERT INTO user_searches ... ON CONFLICT UPDATE; COMMIT - %d writes", len(writes))
+
+	return nil
+}
+
+// TopSearches simulates SELECT search_word, SUM(search_count) ... GROUP BY search_word ORDER BY 2 DESC LIMIT
+func (db *MockPostgresDBV2) TopSearches(ctx context.Context, since time.Time, limit int) ([]WordCount, error) {
+	if err := db.faults.inject(ctx, "TopSearches"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	counts := make(map[string]int)
+	for _, record := range db.userSearches {
+		if !record.LastUpdatedAt.Before(since) {
+			counts[record.SearchWord] += record.SearchCount
+		}
+	}
+
+	// log.Printf("SELECT search_word, SUM(search_count) FROM user_searches GROUP BY search_word ORDER BY 2 DESC LIMIT %d", limit)
+	return topWordCounts(counts, limit), nil
+}
+
+// AddAudiences simulates SELECT registers FROM search_audiences WHERE word = $1 FOR UPDATE, then UPDATE search_audiences ...
+func (db *MockPostgresDBV2) AddAudiences(ctx context.Context, adds []AudienceAdd) error {
+	if err := db.faults.inject(ctx, "AddAudiences"); err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	// Every word is computed before any is written, so a failure leaves the table unchanged
+	updates := groupAudiences(adds)
+	rows := make([]mockAudience, len(updates))
+	for i, update := range updates {
+		var registers []byte
+		if audience := db.audiences[update.word]; audience != nil {
+			registers = audience.registers
+			rows[i].lastUpdatedAt = audience.lastUpdatedAt
+		}
+		var err error
+		if rows[i].registers, rows[i].users, err = addUsers(registers, update.users); err != nil {
+			return err
+		}
+		if update.at.After(rows[i].lastUpdatedAt) {
+			rows[i].lastUpdatedAt = update.at
+		}
+	}
+	for i, update := range updates {
+		row := rows[i]
+		db.audiences[update.word] = &row
+		// log.Printf("UPDATE search_audiences SET users = %d WHERE word = '%s'", row.users, update.word)
+	}
+	return nil
+}
+
+// TopAudiences simulates SELECT word, SUM(search_count), users FROM search_audiences JOIN user_searches ... ORDER BY users DESC LIMIT
+func (db *MockPostgresDBV2) TopAudiences(ctx context.Context, since time.Time, limit int) ([]WordCount, error) {
+	if err := db.faults.inject(ctx, "TopAudiences"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	counts := make(map[string]int)
+	for _, record := range db.userSearches {
+		if _, ok := db.audiences[record.SearchWord]; ok {
+			counts[record.SearchWord] += record.SearchCount
+		}
+	}
+	top := make([]WordCount, 0, len(counts))
+	for word, count := range counts {
+		if audience := db.audiences[word]; !audience.lastUpdatedAt.Before(since) {
+			top = append(top, WordCount{Word: word, Count: count, Users: int(audience.users)})
+		}
+	}
+
+	// log.Printf("SELECT word, SUM(search_count), users FROM search_audiences JOIN user_searches ... ORDER BY users DESC LIMIT %d", limit)
+	return rankAudiences(top, limit), nil
+}
+
+// SearchesBetween simulates SELECT search_word, SUM(search_count) ... WHERE last_updated_at >= $1 AND last_updated_at < $2
+func (db *MockPostgresDBV2) SearchesBetween(ctx context.Context, from, to time.Time) ([]WordCount, error) {
+	if err := db.faults.inject(ctx, "SearchesBetween"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	counts := make(map[string]int)
+	for _, record := range db.userSearches {
+		if inRange(record.LastUpdatedAt, from, to) {
+			counts[record.SearchWord] += record.SearchCount
+		}
+	}
+	return topWordCounts(counts, -1), nil
+}
+
+// SearchHistogram simulates SELECT date_trunc(...), COUNT(DISTINCT search_word), SUM(search_count) ... GROUP BY 1
+func (db *MockPostgresDBV2) SearchHistogram(ctx context.Context, from, to time.Time, granularity Granularity) ([]SearchBucket, error) {
+	if err := db.faults.inject(ctx, "SearchHistogram"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	h := newHistogram(granularity)
+	for _, record := range db.userSearches {
+		if inRange(record.LastUpdatedAt, from, to) {
+			h.add(record.SearchWord, record.LastUpdatedAt, record.SearchCount)
+		}
+	}
+	return h.result(), nil
+}
+
+// SearchesByHour summarizes the records of word by hour without writing a rollup
+func (db *MockPostgresDBV2) SearchesByHour(ctx context.Context, from, to time.Time, word string) ([]SearchRollup, error) {
+	if err := db.faults.inject(ctx, "SearchesByHour"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	var records []UserSearchRecord
+	for _, record := range db.userSearches {
+		if inRange(record.LastUpdatedAt, from, to) && (word == "" || record.SearchWord == word) {
+			records = append(records, record)
+		}
+	}
+	return rollupRecords(records, Hourly), nil
+}
+
+// RollupSearches simulates replacing the rows of [from, to) of a rollup table
+// with INSERT INTO ... SELECT ... FROM user_searches GROUP BY bucket, search_word
+func (db *MockPostgresDBV2) RollupSearches(ctx context.Context, from, to time.Time, granularity Granularity) (int64, error) {
+	if err := db.faults.inject(ctx, "RollupSearches"); err != nil {
+		return 0, err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	var records []UserSearchRecord
+	for _, record := range db.userSearches {
+		if inRange(record.LastUpdatedAt, from, to) {
+			records = append(records, record)
+		}
+	}
+
+	table := db.rollups[granularity]
+	for bucket := range table {
+		if inRange(bucket, from, to) {
+			delete(table, bucket)
+		}
+	}
+	rows := rollupRecords(records, granularity)
+	for _, row := range rows {
+		table[row.Bucket] = append(table[row.Bucket], row)
+	}
+	if to.After(db.rolledUpUntil[granularity]) {
+		db.rolledUpUntil[granularity] = to
+	}
+	return int64(len(rows)), nil
+}
+
+// RolledUpUntil simulates SELECT rolled_up_until FROM search_rollup_watermarks WHERE granularity = $1
+func (db *MockPostgresDBV2) RolledUpUntil(ctx context.Context, granularity Granularity) (time.Time, error) {
+	if err := db.faults.inject(ctx, "RolledUpUntil"); err != nil {
+		return time.Time{}, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return db.rolledUpUntil[granularity], nil
+}
+
+// GetRollups simulates SELECT * FROM search_rollups_... WHERE bucket >= $1 AND bucket < $2
+func (db *MockPostgresDBV2) GetRollups(ctx context.Context, from, to time.Time, granularity Granularity) ([]SearchRollup, error) {
+	if err := db.faults.inject(ctx, "GetRollups"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	rollups := make([]SearchRollup, 0)
+	for bucket, rows := range db.rollups[granularity] {
+		if inRange(bucket, from, to) {
+			rollups = append(rollups, rows...)
+		}
+	}
+	sortRollups(rollups)
+	return rollups, nil
+}
+
+// AppendAudit simulates INSERT INTO audit_log (at, actor, action, target, before_value, after_value) ... RETURNING id
+func (db *MockPostgresDBV2) AppendAudit(ctx context.Context, entry AuditEntry) (int64, error) {
+	if err := db.faults.inject(ctx, "AppendAudit"); err != nil {
+		return 0, err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	entry.ID = int64(len(db.audit) + 1)
+	entry.At = entry.At.UTC()
+	db.audit = append(db.audit, entry)
+	// log.Printf("INSERT INTO audit_log VALUES (%d, '%s', '%s', '%s', '%s')", entry.ID, entry.Actor, entry.Action, entry.Target, entry.At)
+	return entry.ID, nil
+}
+
+// ListAudit simulates SELECT * FROM audit_log WHERE ... ORDER BY id DESC LIMIT $1
+func (db *MockPostgresDBV2) ListAudit(ctx context.Context, query AuditQuery) ([]AuditEntry, error) {
+	if err := db.faults.inject(ctx, "ListAudit"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	entries := make([]AuditEntry, 0)
+	for i := len(db.audit) - 1; i >= 0 && len(entries) < query.limit(); i-- {
+		if query.matches(db.audit[i]) {
+			entries = append(entries, db.audit[i])
+		}
+	}
+	return entries, nil
+}
+
+// QuarantineSearch simulates INSERT INTO quarantined_searches (user_identifier, search_word, reason, searched_at) ... RETURNING id
+func (db *MockPostgresDBV2) QuarantineSearch(ctx context.Context, search QuarantinedSearch) (int64, error) {
+	if err := db.faults.inject(ctx, "QuarantineSearch"); err != nil {
+		return 0, err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	db.nextQuarantineID++
+	search.ID = db.nextQuarantineID
+	search.SearchedAt = search.SearchedAt.UTC()
+	db.quarantine = append(db.quarantine, search)
+	return search.ID, nil
+}
+
+// ListQuarantinedSearches simulates SELECT * FROM quarantined_searches WHERE ($1 = ” OR user_identifier = $1) ORDER BY id
+func (db *MockPostgresDBV2) ListQuarantinedSearches(ctx context.Context, userIdentifier string) ([]QuarantinedSearch, error) {
+	if err := db.faults.inject(ctx, "ListQuarantinedSearches"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	searches := make([]QuarantinedSearch, 0)
+	for _, search := range db.quarantine {
+		if userIdentifier == "" || search.UserIdentifier == userIdentifier {
+			searches = append(searches, search)
+		}
+	}
+	return searches, nil
+}
+
+// TakeQuarantinedSearches simulates DELETE FROM quarantined_searches WHERE id = ANY($1) RETURNING *
+func (db *MockPostgresDBV2) TakeQuarantinedSearches(ctx context.Context, ids []int64) ([]QuarantinedSearch, error) {
+	if err := db.faults.inject(ctx, "TakeQuarantinedSearches"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	taken := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		taken[id] = true
+	}
+	searches := make([]QuarantinedSearch, 0)
+	kept := db.quarantine[:0]
+	for _, search := range db.quarantine {
+		if taken[search.ID] {
+			searches = append(searches, search)
+		} else {
+			kept = append(kept, search)
+		}
+	}
+	db.quarantine = kept
+	return searches, nil
+}
+
+// DeleteUserQuarantine simulates DELETE FROM quarantined_searches WHERE user_identifier = $1
+func (db *MockPostgresDBV2) DeleteUserQuarantine(ctx context.Context, userIdentifier string) (int64, error) {
+	if err := db.faults.inject(ctx, "DeleteUserQuarantine"); err != nil {
+		return 0, err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	kept := db.quarantine[:0]
+	for _, search := range db.quarantine {
+		if search.UserIdentifier != userIdentifier {
+			kept = append(kept, search)
+		}
+	}
+	deleted := int64(len(db.quarantine) - len(kept))
+	db.quarantine = kept
+	return deleted, nil
+}
+
+// AddSearchClick simulates INSERT INTO search_clicks (user_identifier, search_word, result_id, clicked_at)
+func (db *MockPostgresDBV2) AddSearchClick(ctx context.Context, click SearchClick) error {
+	if err := db.faults.inject(ctx, "AddSearchClick"); err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	click.ClickedAt = click.ClickedAt.UTC()
+	db.clicks = append(db.clicks, click)
+	return nil
+}
+
+// ClickStats simulates SELECT search_word, SUM(search_count) FROM user_searches WHERE search_word = ANY($1) GROUP BY search_word
+// and SELECT search_word, COUNT(*) FROM search_clicks WHERE search_word = ANY($1) GROUP BY search_word
+func (db *MockPostgresDBV2) ClickStats(ctx context.Context, words []string) (map[string]ClickStats, error) {
+	if err := db.faults.inject(ctx, "ClickStats"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	wanted := make(map[string]bool, len(words))
+	for _, word := range words {
+		wanted[word] = true
+	}
+	stats := make(map[string]ClickStats)
+	for _, record := range db.userSearches {
+		if wanted[record.SearchWord] {
+			s := stats[record.SearchWord]
+			s.Searches += record.SearchCount
+			stats[record.SearchWord] = s
+		}
+	}
+	for _, click := range db.clicks {
+		if wanted[click.Word] {
+			s := stats[click.Word]
+			s.Clicks++
+			stats[click.Word] = s
+		}
+	}
+	return stats, nil
+}
+
+// DeleteUserClicks simulates DELETE FROM search_clicks WHERE user_identifier = $1
+func (db *MockPostgresDBV2) DeleteUserClicks(ctx context.Context, userIdentifier string) (int64, error) {
+	if err := db.faults.inject(ctx, "DeleteUserClicks"); err != nil {
+		return 0, err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	kept := db.clicks[:0]
+	for _, click := range db.clicks {
+		if click.UserIdentifier != userIdentifier {
+			kept = append(kept, click)
+		}
+	}
+	deleted := int64(len(db.clicks) - len(kept))
+	db.clicks = kept
+	return deleted, nil
+}
+
+// RecordSearchResults simulates INSERT INTO zero_result_terms ... ON CONFLICT (search_word) DO UPDATE SET streak = streak + 1
+// for a search without result, DELETE FROM zero_result_terms WHERE search_word = $1 otherwise
+func (db *MockPostgresDBV2) RecordSearchResults(ctx context.Context, word string, results int, at time.Time) error {
+	if err := db.faults.inject(ctx, "RecordSearchResults"); err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if results != 0 {
+		delete(db.zeroResults, word)
+		return nil
+	}
+	term, ok := db.zeroResults[word]
+	if !ok {
+		term = ZeroResultTerm{Word: word, FirstZeroAt: at.UTC()}
+	}
+	term.Streak++
+	term.LastZeroAt = at.UTC()
+	db.zeroResults[word] = term
+	return nil
+}
+
+// ZeroResultTerms simulates SELECT * FROM zero_result_terms WHERE streak >= $1 ORDER BY streak DESC, last_zero_at DESC, search_word LIMIT $2
+func (db *MockPostgresDBV2) ZeroResultTerms(ctx context.Context, minStreak, limit int) ([]ZeroResultTerm, error) {
+	if err := db.faults.inject(ctx, "ZeroResultTerms"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	terms := make([]ZeroResultTerm, 0)
+	for _, term := range db.zeroResults {
+		if term.Streak >= minStreak {
+			terms = append(terms, term)
+		}
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		a, b := terms[i], terms[j]
+		if a.Streak != b.Streak {
+			return a.Streak > b.Streak
+		}
+		if !a.LastZeroAt.Equal(b.LastZeroAt) {
+			return a.LastZeroAt.After(b.LastZeroAt)
+		}
+		return a.Word < b.Word
+	})
+	if len(terms) > limit {
+		terms = terms[:max(limit, 0)]
+	}
+	return terms, nil
+}
+
+// PutSynonym simulates INSERT INTO search_synonyms (alias, canonical) ... ON CONFLICT (alias) DO UPDATE
+func (db *MockPostgresDBV2) PutSynonym(ctx context.Context, alias, canonical string) error {
+	if err := db.faults.inject(ctx, "PutSynonym"); err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	db.synonyms[alias] = canonical
+	return nil
+}
+
+// DeleteSynonym simulates DELETE FROM search_synonyms WHERE alias = $1
+func (db *MockPostgresDBV2) DeleteSynonym(ctx context.Context, alias string) (bool, error) {
+	if err := db.faults.inject(ctx, "DeleteSynonym"); err != nil {
+		return false, err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	_, ok := db.synonyms[alias]
+	delete(db.synonyms, alias)
+	return ok, nil
+}
+
+// ListSynonyms simulates SELECT alias, canonical FROM search_synonyms ORDER BY alias
+func (db *MockPostgresDBV2) ListSynonyms(ctx context.Context) ([]Synonym, error) {
+	if err := db.faults.inject(ctx, "ListSynonyms"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	synonyms := make([]Synonym, 0, len(db.synonyms))
+	for alias, canonical := range db.synonyms {
+		synonyms = append(synonyms, Synonym{Alias: alias, Canonical: canonical})
+	}
+	sort.Slice(synonyms, func(i, j int) bool { return synonyms[i].Alias < synonyms[j].Alias })
+	return synonyms, nil
+}
+
+// AdvanceUserClock simulates SELECT client_at, sequence FROM user_clocks WHERE user_identifier = $1 FOR UPDATE, then UPDATE user_clocks ...
+func (db *MockPostgresDBV2) AdvanceUserClock(ctx context.Context, userIdentifier string, clock UserClock) (UserClock, error) {
+	if err := db.faults.inject(ctx, "AdvanceUserClock"); err != nil {
+		return UserClock{}, err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	previous := db.clocks[userIdentifier]
+	db.clocks[userIdentifier] = previous.merge(clock)
+	// log.Printf("UPDATE user_clocks SET client_at = GREATEST(client_at, '%v'), sequence = GREATEST(sequence, %d) WHERE user_identifier = '%s'", clock.At, clock.Sequence, userIdentifier)
+	return previous, nil
+}
+
+// DeleteUserClock simulates DELETE FROM user_clocks WHERE user_identifier = $1
+func (db *MockPostgresDBV2) DeleteUserClock(ctx context.Context, userIdentifier string) error {
+	if err := db.faults.inject(ctx, "DeleteUserClock"); err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	delete(db.clocks, userIdentifier)
+	// log.Printf("DELETE FROM user_clocks WHERE user_identifier = '%s'", userIdentifier)
+	return nil
+}
+
+// DeleteUserSearches simulates DELETE FROM user_searches WHERE user_identifier = $1
+func (db *MockPostgresDBV2) DeleteUserSearches(ctx context.Context, userIdentifier string) ([]WordCount, error) {
+	if err := db.faults.inject(ctx, "DeleteUserSearches"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	deleted := make([]WordCount, 0)
+	for _, record := range db.userRecords(userIdentifier) {
+		db.remove(record)
+		deleted = append(deleted, WordCount{Word: record.SearchWord, Count: record.SearchCount})
+	}
+
+	// log.Printf("DELETE FROM user_searches WHERE user_identifier = '%s' - %d rows", userIdentifier, len(deleted))
+	return deleted, nil
+}
+
+// PurgeUserSearches simulates DELETE FROM user_searches WHERE last_updated_at < $1
+func (db *MockPostgresDBV2) PurgeUserSearches(ctx context.Context, cutoff time.Time) ([]WordCount, error) {
+	if err := db.faults.inject(ctx, "PurgeUserSearches"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	deleted := make([]WordCount, 0)
+	for _, record := range db.userSearches {
+		if record.LastUpdatedAt.Before(cutoff) {
+			db.remove(record)
+			deleted = append(deleted, WordCount{Word: record.SearchWord, Count: record.SearchCount})
+		}
+	}
+
+	// log.Printf("DELETE FROM user_searches WHERE last_updated_at < '%s' - %d rows", cutoff, len(deleted))
+	return deleted, nil
+}
+
+// TrimUserSearches simulates DELETE FROM user_searches WHERE id IN (SELECT id FROM user_searches
+// WHERE user_identifier = $1 ORDER BY last_updated_at DESC, id DESC OFFSET $2)
+func (db *MockPostgresDBV2) TrimUserSearches(ctx context.Context, userIdentifier string, maxRecords int) ([]WordCount, error) {
+	if err := db.faults.inject(ctx, "TrimUserSearches"); err != nil {
+		return nil, err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	records := db.userRecords(userIdentifier)
+	if len(records) <= maxRecords {
+		return []WordCount{}, nil
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].LastUpdatedAt.Equal(records[j].LastUpdatedAt) {
+			return records[i].LastUpdatedAt.After(records[j].LastUpdatedAt)
+		}
+		return records[i].ID > records[j].ID
+	})
+	deleted := make([]WordCount, 0, len(records)-maxRecords)
+	for _, record := range records[maxRecords:] {
+		db.remove(record)
+		deleted = append(deleted, WordCount{Word: record.SearchWord, Count: record.SearchCount})
+	}
+
+	// log.Printf("DELETE FROM user_searches WHERE user_identifier = '%s' beyond %d records - %d rows", userIdentifier, maxRecords, len(deleted))
+	return deleted, nil
+}
+
+// MergeUserSearches simulates moving the records of fromUser to toUser in one transaction
+func (db *MockPostgresDBV2) MergeUserSearches(ctx context.Context, fromUser, toUser string) error {
+	if err := db.faults.inject(ctx, "MergeUserSearches"); err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	records := append(db.userRecords(fromUser), db.userRecords(toUser)...)
+	for _, record := range records {
+		db.remove(record)
+	}
+
+	for _, record := range mergeUserRecords(records, toUser) {
+		db.put(record)
+	}
+
+	// log.Printf("BEGIN; SELECT ... FOR UPDATE; DELETE FROM user_searches WHERE user_identifier IN ('%s', '%s'); INSERT ...; COMMIT", fromUser, toUser)
+	return nil
+}
+
+// MergeUserWords simulates a transaction merging the records of from of every user into their records of to
+func (db *MockPostgresDBV2) MergeUserWords(ctx context.Context, from, to string) (int64, error) {
+	if err := db.faults.inject(ctx, "MergeUserWords"); err != nil {
+		return 0, err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	var records []UserSearchRecord
+	for _, record := range db.userSearches {
+		if record.SearchWord == from || record.SearchWord == to {
+			records = append(records, record)
+		}
+	}
+
+	// Merged records keep the ID of the record of to, so putting them back overwrites it
+	updates, _ := mergeWordRecords(records, from, to)
+	for _, record := range records {
+		if record.SearchWord == from {
+			db.remove(record)
+		}
+	}
+	for _, record := range updates {
+		db.put(record)
+	}
+
+	// log.Printf("BEGIN; SELECT ... FOR UPDATE; UPDATE user_searches ...; DELETE FROM user_searches WHERE search_word='%s'; COMMIT", from)
+	return int64(len(updates)), nil
+}
+
+// Ping succeeds unless faults are injected, the mock has no connection
+func (db *MockPostgresDBV2) Ping(ctx context.Context) error {
+	return db.faults.inject(ctx, "Ping")
+}
+
+// Faults returns the failures and latency injected into the calls of db
+func (db *MockPostgresDBV2) Faults() *Faults {
+	return &db.faults
+}
+
+// WithUserLock runs fn while holding the lock of the user within the process,
+// like the advisory locks of PostgresDBV2 do across processes
+func (db *MockPostgresDBV2) WithUserLock(ctx context.Context, userIdentifier string, fn func(ctx context.Context) error) error {
+	if err := db.faults.inject(ctx, "WithUserLock"); err != nil {
+		return err
+	}
+
+	db.userLocksMutex.Lock()
+	if db.userLocks == nil {
+		db.userLocks = make(map[string]*mockUserLock)
+	}
+	lock, ok := db.userLocks[userIdentifier]
+	if !ok {
+		lock = &mockUserLock{free: make(chan struct{}, 1)}
+		lock.free <- struct{}{}
+		db.userLocks[userIdentifier] = lock
+	}
+	lock.users++
+	db.userLocksMutex.Unlock()
+
+	defer func() {
+		db.userLocksMutex.Lock()
+		if lock.users--; lock.users == 0 {
+			delete(db.userLocks, userIdentifier)
+		}
+		db.userLocksMutex.Unlock()
+	}()
+
+	select {
+	case <-lock.free:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { lock.free <- struct{}{} }()
+	return fn(ctx)
+}
+
+// Close simulates closing the database connection
+func (db *MockPostgresDBV2) Close() error {
+	// log.Println("Database connection closed")
+	return nil
+}
@@ -0,0 +1,206 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// assertIndexed checks that the byUser index matches the records exactly
+func assertIndexed(t *testing.T, db *MockPostgresDBV2) {
+	indexed := 0
+	for user, words := range db.byUser {
+		assert.NotEmpty(t, words, user)
+		for word, id := range words {
+			record, ok := db.lookup(user, word)
+			require.True(t, ok, "%s/%s", user, word)
+			assert.Equal(t, id, record.ID)
+			assert.Equal(t, user, record.UserIdentifier)
+			assert.Equal(t, word, record.SearchWord)
+			indexed++
+		}
+	}
+	assert.Equal(t, len(db.userSearches), indexed)
+}
+
+func TestMockV2Index(t *testing.T) {
+	ctx := context.Background()
+	db := NewMockPostgresDBV2()
+	now := time.Now()
+
+	_, err := db.InsertOrUpdateUserSearch(ctx, "user_1", "bu", now, now)
+	require.NoError(t, err)
+	id, err := db.InsertOrUpdateUserSearch(ctx, "user_1", "cat", now, now)
+	require.NoError(t, err)
+	sameID, err := db.InsertOrUpdateUserSearch(ctx, "user_1", "cat", now, now)
+	require.NoError(t, err)
+	assert.Equal(t, id, sameID)
+	_, err = db.InsertOrUpdateUserSearch(ctx, "user_2", "bu", now.Add(-time.Hour), now.Add(-time.Hour))
+	require.NoError(t, err)
+	assertIndexed(t, db)
+
+	require.NoError(t, db.UpdateUserSearchByWord(ctx, "user_1", "bu", "bus", now))
+	require.NoError(t, db.ApplyUserSearchWrites(ctx, []UserSearchWrite{
+		{UserIdentifier: "user_1", Word: "business", ReplaceWords: []string{"bus"}, FirstSearchedAt: now, LastUpdatedAt: now, Count: 1},
+		{UserIdentifier: "user_1", Word: "cat", FirstSearchedAt: now, LastUpdatedAt: now, Count: 1},
+	}))
+	assertIndexed(t, db)
+
+	searches, err := db.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"business", "cat"}, searches)
+	record, ok := db.lookup("user_1", "cat")
+	require.True(t, ok)
+	assert.Equal(t, 3, record.SearchCount)
+
+	purged, err := db.PurgeUserSearches(ctx, now.Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, []WordCount{{Word: "bu", Count: 1}}, purged)
+	assertIndexed(t, db)
+	assert.NotContains(t, db.byUser, "user_2")
+
+	require.NoError(t, db.MergeUserSearches(ctx, "user_1", "user_3"))
+	assertIndexed(t, db)
+	assert.NotContains(t, db.byUser, "user_1")
+
+	deleted, err := db.DeleteUserSearches(ctx, "user_3")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []WordCount{{Word: "business", Count: 3}, {Word: "cat", Count: 3}}, deleted)
+	assertIndexed(t, db)
+	assert.Empty(t, db.byUser)
+}
+
+func TestMockFaults(t *testing.T) {
+	ctx := context.Background()
+	db := NewMockPostgresDBV2()
+	require.NoError(t, db.CreateTable(ctx))
+
+	// A lost database fails every call as unavailable until it is back
+	db.Faults().SetClosed(true)
+	_, err := db.InsertOrUpdateUserSearch(ctx, "user_1", "bus", time.Now(), time.Now())
+	assert.ErrorIs(t, Classify(err), ErrStoreUnavailable)
+	assert.Error(t, db.Ping(ctx))
+	db.Faults().SetClosed(false)
+	_, err = db.InsertOrUpdateUserSearch(ctx, "user_1", "bus", time.Now(), time.Now())
+	assert.NoError(t, err)
+
+	// Error rates apply per operation, the empty operation to the others
+	db.Faults().Seed(1)
+	db.Faults().SetErrorRate("", 1)
+	db.Faults().SetErrorRate("GetUserSearches", 0)
+	_, err = db.GetUserSearches(ctx, "user_1")
+	assert.NoError(t, err)
+	err = db.UpdateUserSearchByWord(ctx, "user_1", "bus", "business", time.Now())
+	assert.ErrorIs(t, err, ErrInjectedFault)
+	assert.ErrorIs(t, Classify(err), ErrStoreUnavailable)
+
+	failed := 0
+	db.Faults().SetErrorRate("", 0.3)
+	for i := 0; i < 1000; i++ {
+		if db.Ping(ctx) != nil {
+			failed++
+		}
+	}
+	assert.InDelta(t, 300, failed, 60)
+	assert.Equal(t, int64(3+failed), db.Faults().Injected())
+
+	// Latency past the deadline of the caller times the call out
+	db.Faults().Reset()
+	db.Faults().SetLatency("GetUserSearches", time.Second)
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = db.GetUserSearches(timeoutCtx, "user_1")
+	assert.ErrorIs(t, Classify(err), ErrStoreUnavailable)
+	assert.NoError(t, db.Ping(ctx))
+}
+
+// testUserLock checks that the holders of the lock of a user run one at a time
+func testUserLock(t *testing.T, db UserLockStore, user string) {
+	ctx := context.Background()
+	var holders, maxHolders atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, db.WithUserLock(ctx, user, func(context.Context) error {
+				n := holders.Add(1)
+				for m := maxHolders.Load(); n > m && !maxHolders.CompareAndSwap(m, n); m = maxHolders.Load() {
+				}
+				time.Sleep(time.Millisecond)
+				holders.Add(-1)
+				return nil
+			}))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), maxHolders.Load())
+
+	// The lock of another user is free meanwhile, and fn's error is returned
+	err := db.WithUserLock(ctx, user, func(ctx context.Context) error {
+		return db.WithUserLock(ctx, user+"_other", func(context.Context) error { return ErrUserNotFound })
+	})
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestMockV2UserLock(t *testing.T) {
+	db := NewMockPostgresDBV2()
+	testUserLock(t, db, "user_1")
+	assert.Empty(t, db.userLocks, "the locks nobody holds are dropped")
+
+	// A waiter gives up with its context
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := db.WithUserLock(context.Background(), "user_1", func(context.Context) error {
+		return db.WithUserLock(ctx, "user_1", func(context.Context) error { return nil })
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// testConsolidate checks the decisions of ConsolidateUserSearch for a user without records
+func testConsolidate(t *testing.T, db UserConsolidateStore, user string) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, step := range []struct {
+		word string
+		want UserConsolidation
+	}{
+		{"bu", UserConsolidation{}},
+		{"bus", UserConsolidation{Extended: "bu"}},
+		{"bu", UserConsolidation{Extension: "bus"}},
+		{"bus", UserConsolidation{}},
+		{"car", UserConsolidation{}},
+	} {
+		got, err := db.ConsolidateUserSearch(ctx, user, step.word, now)
+		require.NoError(t, err, step.word)
+		assert.Equal(t, step.want, got, step.word)
+	}
+
+	// A prefix stored next to the word is merged into it
+	_, err := db.InsertOrUpdateUserSearch(ctx, user, "ca", now.Add(-time.Hour), now)
+	require.NoError(t, err)
+	got, err := db.ConsolidateUserSearch(ctx, user, "car", now)
+	require.NoError(t, err)
+	assert.Equal(t, UserConsolidation{Extended: "ca"}, got)
+
+	counts := map[string]int{}
+	require.NoError(t, db.(UserExportStore).ForEachUserSearch(ctx, user, func(record UserSearchRecord) error {
+		counts[record.SearchWord] = record.SearchCount
+		if record.SearchWord == "car" {
+			assert.True(t, record.FirstSearchedAt.Equal(now.Add(-time.Hour)), "the merged record keeps the earliest first search")
+		}
+		return nil
+	}))
+	assert.Equal(t, map[string]int{"bus": 3, "car": 2}, counts)
+}
+
+func TestMockV2ConsolidateUserSearch(t *testing.T) {
+	db := NewMockPostgresDBV2()
+	testConsolidate(t, db, "user_1")
+	assertIndexed(t, db)
+}
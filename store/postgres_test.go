@@ -0,0 +1,127 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// postgresConfig returns the test database config, skipping unless LOGSEARCH_POSTGRES_DSN is set
+func postgresConfig(t *testing.T) PostgresConfig {
+	dsn := os.Getenv("LOGSEARCH_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("LOGSEARCH_POSTGRES_DSN not set")
+	}
+	return PostgresConfig{DSN: dsn, MaxOpenConns: 4}
+}
+
+// TestPostgresStorage runs against a real database when LOGSEARCH_POSTGRES_DSN is set
+func TestPostgresStorage(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewPostgresDB(postgresConfig(t))
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.CreateTable(ctx))
+	_, err = db.db.Exec(`DELETE FROM searches WHERE word IN ('pgtest', 'pgtesting')`)
+	require.NoError(t, err)
+
+	now := time.Now()
+	id, err := db.InsertOrReplace(ctx, "pgtest", now, now)
+	require.NoError(t, err)
+
+	sameID, err := db.InsertOrReplace(ctx, "pgtest", now, now)
+	require.NoError(t, err)
+	assert.Equal(t, id, sameID, "Conflicting insert should return the existing record")
+
+	require.NoError(t, db.Update(ctx, id, "pgtesting", now))
+
+	words, err := db.GetAllSearchedWords(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, words, "pgtesting")
+	assert.NotContains(t, words, "pgtest")
+
+	require.NoError(t, db.SetVerified(ctx, "pgtesting", true))
+	assert.ErrorIs(t, db.SetVerified(ctx, "pgtest", true), ErrWordNotFound)
+	verified, err := db.GetVerifiedWords(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, verified, "pgtesting")
+	require.NoError(t, db.SetDisplayWord(ctx, "pgtesting", "PGTesting"))
+	assert.ErrorIs(t, db.SetDisplayWord(ctx, "pgtest", "PGTest"), ErrWordNotFound)
+	displays, err := db.GetDisplayWords(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "PGTesting", displays["pgtesting"])
+
+	require.NoError(t, db.SetMetadata(ctx, "pgtesting", json.RawMessage(`{"category": "tests"}`)))
+	assert.ErrorIs(t, db.SetMetadata(ctx, "pgtest", json.RawMessage(`{}`)), ErrWordNotFound)
+	metadata, err := db.GetMetadata(ctx)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"category": "tests"}`, string(metadata["pgtesting"]))
+
+	require.NoError(t, db.TagCategories(ctx, "pgtesting", []string{"tests"}))
+	assert.ErrorIs(t, db.TagCategories(ctx, "pgtest", []string{"tests"}), ErrWordNotFound)
+	categories, err := db.GetCategories(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tests"}, categories["pgtesting"])
+	top, err := db.TopSearchesInCategory(ctx, "tests", now.Add(-time.Minute), 10)
+	require.NoError(t, err)
+	assert.Contains(t, top, WordCount{Word: "pgtesting", Count: 2})
+}
+
+// TestPostgresV2Storage runs against a real database when LOGSEARCH_POSTGRES_DSN is set
+func TestPostgresV2Storage(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewPostgresDBV2(postgresConfig(t))
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.CreateTable(ctx))
+	user := "pg_test_user"
+	_, err = db.db.Exec(`DELETE FROM user_searches WHERE user_identifier = $1`, user)
+	require.NoError(t, err)
+
+	now := time.Now()
+	_, err = db.InsertOrUpdateUserSearch(ctx, user, "bu", now, now)
+	require.NoError(t, err)
+	_, err = db.InsertOrUpdateUserSearch(ctx, user, "bus", now, now)
+	require.NoError(t, err)
+
+	// Extending "bu" to "bus" merges into the existing "bus" record
+	require.NoError(t, db.UpdateUserSearchByWord(ctx, user, "bu", "bus", now))
+
+	searches, err := db.GetUserSearches(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bus"}, searches)
+
+	require.NoError(t, db.SetUserSearchRegion(ctx, user, "bus", Region{Country: "ZZ", Locale: "zz-ZZ"}))
+	top, err := db.TopSearchesByRegion(ctx, RegionQuery{Country: "ZZ", Locale: "zz-ZZ", Since: now.Add(-time.Minute), Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, top, 1)
+	assert.Equal(t, "bus", top[0].Word)
+}
+
+// TestPostgresV2UserLock runs against a real database when LOGSEARCH_POSTGRES_DSN is set
+func TestPostgresV2UserLock(t *testing.T) {
+	db, err := NewPostgresDBV2(postgresConfig(t))
+	require.NoError(t, err)
+	defer db.Close()
+	testUserLock(t, db, "pg_lock_user")
+}
+
+// TestPostgresV2ConsolidateUserSearch runs against a real database when LOGSEARCH_POSTGRES_DSN is set
+func TestPostgresV2ConsolidateUserSearch(t *testing.T) {
+	db, err := NewPostgresDBV2(postgresConfig(t))
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.CreateTable(context.Background()))
+	user := "pg_consolidate_user"
+	_, err = db.db.Exec(`DELETE FROM user_searches WHERE user_identifier = $1`, user)
+	require.NoError(t, err)
+	testConsolidate(t, db, user)
+}
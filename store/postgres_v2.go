@@ -0,0 +1,1093 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PostgresDBV2 implements the same operations as MockPostgresDBV2 against a real PostgreSQL database
+type PostgresDBV2 struct {
+	db       *sql.DB
+	replicas *replicaSet
+	// locks holds the connections of the advisory locks of WithUserLock apart
+	// from db, so the lock holders never wait for a connection taken by a waiter
+	locks        *sql.DB
+	queryTimeout time.Duration
+}
+
+// NewPostgresDBV2 opens a pooled connection to PostgreSQL and to its read
+// replicas, and verifies them with a ping
+func NewPostgresDBV2(cfg PostgresConfig) (*PostgresDBV2, error) {
+	db, queryTimeout, err := openPostgres(cfg)
+	if err != nil {
+		return nil, err
+	}
+	replicas, err := openReplicas(cfg, queryTimeout)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	// Connects on the first WithUserLock only
+	locks, err := sql.Open("pgx", cfg.DSN)
+	if err != nil {
+		replicas.close()
+		db.Close()
+		return nil, fmt.Errorf("failed to open postgres connection: %w", err)
+	}
+	if cfg.MaxOpenConns > 0 {
+		locks.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+
+	return &PostgresDBV2{db: db, replicas: replicas, locks: locks, queryTimeout: queryTimeout}, nil
+}
+
+// queryContext bounds the caller's context by the configured query timeout
+func (db *PostgresDBV2) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, db.queryTimeout)
+}
+
+// CreateTable creates the user_searches table and its indexes if they do not exist yet.
+// The UNIQUE(user_identifier, search_word) index serves the per-user lookups, the
+// last_updated_at index the time-bounded top searches and the retention purge.
+func (db *PostgresDBV2) CreateTable(ctx context.Context) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	if _, err := db.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS user_searches (
+		id SERIAL PRIMARY KEY,
+		user_identifier VARCHAR NOT NULL,
+		search_word VARCHAR NOT NULL,
+		first_searched_at TIMESTAMP NOT NULL,
+		last_updated_at TIMESTAMP NOT NULL,
+		search_count INTEGER NOT NULL DEFAULT 1,
+		UNIQUE(user_identifier, search_word)
+	)`); err != nil {
+		return err
+	}
+
+	if _, err := db.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS user_searches_last_updated_at_idx
+		ON user_searches (last_updated_at)`); err != nil {
+		return err
+	}
+
+	// Added after the first release, tables created before get it here
+	if _, err := db.db.ExecContext(ctx, `ALTER TABLE user_searches ADD COLUMN IF NOT EXISTS surface_word VARCHAR`); err != nil {
+		return err
+	}
+	if _, err := db.db.ExecContext(ctx, `ALTER TABLE user_searches ADD COLUMN IF NOT EXISTS country VARCHAR,
+		ADD COLUMN IF NOT EXISTS locale VARCHAR`); err != nil {
+		return err
+	}
+
+	for _, granularity := range []Granularity{Hourly, Daily} {
+		if _, err := db.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+granularity.table()+` (
+			bucket TIMESTAMP NOT NULL,
+			search_word VARCHAR NOT NULL,
+			total_count INTEGER NOT NULL,
+			unique_users INTEGER NOT NULL,
+			PRIMARY KEY (bucket, search_word)
+		)`); err != nil {
+			return err
+		}
+	}
+	if _, err := db.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS search_rollup_watermarks (
+		granularity VARCHAR PRIMARY KEY,
+		rolled_up_until TIMESTAMP NOT NULL
+	)`); err != nil {
+		return err
+	}
+
+	if _, err := db.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS search_audiences (
+		word VARCHAR PRIMARY KEY,
+		registers BYTEA NOT NULL,
+		users BIGINT NOT NULL,
+		last_updated_at TIMESTAMP NOT NULL
+	)`); err != nil {
+		return err
+	}
+	// Serves the join of the audiences to the stored words
+	if _, err := db.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS user_searches_search_word_idx
+		ON user_searches (search_word)`); err != nil {
+		return err
+	}
+
+	if _, err := db.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS user_clocks (
+		user_identifier VARCHAR PRIMARY KEY,
+		client_at TIMESTAMP NOT NULL,
+		sequence BIGINT NOT NULL
+	)`); err != nil {
+		return err
+	}
+
+	if _, err := db.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS audit_log (
+		id BIGSERIAL PRIMARY KEY,
+		at TIMESTAMP NOT NULL,
+		actor VARCHAR NOT NULL,
+		action VARCHAR NOT NULL,
+		target VARCHAR NOT NULL,
+		before_value VARCHAR NOT NULL,
+		after_value VARCHAR NOT NULL
+	)`); err != nil {
+		return err
+	}
+	if _, err := db.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS quarantined_searches (
+		id BIGSERIAL PRIMARY KEY,
+		user_identifier VARCHAR NOT NULL,
+		search_word VARCHAR NOT NULL,
+		reason VARCHAR NOT NULL,
+		searched_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS quarantined_searches_user_identifier_idx ON quarantined_searches (user_identifier)`); err != nil {
+		return err
+	}
+	if _, err := db.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS search_clicks (
+		id BIGSERIAL PRIMARY KEY,
+		user_identifier VARCHAR NOT NULL,
+		search_word VARCHAR NOT NULL,
+		result_id VARCHAR NOT NULL,
+		clicked_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS search_clicks_search_word_idx ON search_clicks (search_word);
+	CREATE INDEX IF NOT EXISTS search_clicks_user_identifier_idx ON search_clicks (user_identifier)`); err != nil {
+		return err
+	}
+	if _, err := db.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS search_synonyms (
+		alias VARCHAR PRIMARY KEY,
+		canonical VARCHAR NOT NULL
+	)`); err != nil {
+		return err
+	}
+	if _, err := db.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS zero_result_terms (
+		search_word VARCHAR PRIMARY KEY,
+		streak INTEGER NOT NULL,
+		first_zero_at TIMESTAMP NOT NULL,
+		last_zero_at TIMESTAMP NOT NULL
+	)`); err != nil {
+		return err
+	}
+
+	// The rules keep the audit log append-only
+	_, err := db.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS audit_log_at_idx ON audit_log (at);
+		CREATE OR REPLACE RULE audit_log_no_update AS ON UPDATE TO audit_log DO INSTEAD NOTHING;
+		CREATE OR REPLACE RULE audit_log_no_delete AS ON DELETE TO audit_log DO INSTEAD NOTHING`)
+	return err
+}
+
+// InsertOrUpdateUserSearch inserts a user's word or bumps its count with INSERT ... ON CONFLICT UPDATE
+func (db *PostgresDBV2) InsertOrUpdateUserSearch(ctx context.Context, userIdentifier, word string, firstSearched, lastUpdated time.Time) (int64, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	var id int64
+	err := db.db.QueryRowContext(ctx, `INSERT INTO user_searches (user_identifier, search_word, first_searched_at, last_updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_identifier, search_word) DO UPDATE
+		SET last_updated_at = EXCLUDED.last_updated_at, search_count = user_searches.search_count + 1
+		RETURNING id`,
+		userIdentifier, word, firstSearched, lastUpdated).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetUserSearches returns all searches for a specific user
+func (db *PostgresDBV2) GetUserSearches(ctx context.Context, userIdentifier string) ([]string, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `SELECT search_word FROM user_searches
+		WHERE user_identifier = $1 ORDER BY search_word`, userIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var words []string
+	for rows.Next() {
+		var word string
+		if err := rows.Scan(&word); err != nil {
+			return nil, err
+		}
+		words = append(words, word)
+	}
+
+	return words, rows.Err()
+}
+
+// ForEachUserSearch streams every record of the user in word order
+func (db *PostgresDBV2) ForEachUserSearch(ctx context.Context, userIdentifier string, fn func(UserSearchRecord) error) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.replicas.reader(db.db).QueryContext(ctx, `SELECT id, user_identifier, search_word, first_searched_at, last_updated_at, search_count, surface_word
+		FROM user_searches WHERE user_identifier = $1 ORDER BY search_word`, userIdentifier)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record, err := scanUserSearchRecord(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// TopSearches returns the most searched words over all users last updated at or after since
+func (db *PostgresDBV2) TopSearches(ctx context.Context, since time.Time, limit int) ([]WordCount, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.replicas.reader(db.db).QueryContext(ctx, `SELECT search_word, SUM(search_count) FROM user_searches
+		WHERE last_updated_at >= $1
+		GROUP BY search_word
+		ORDER BY 2 DESC, search_word LIMIT $2`, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWordCounts(rows)
+}
+
+// SearchesBetween returns the words over all users last updated in [from, to)
+func (db *PostgresDBV2) SearchesBetween(ctx context.Context, from, to time.Time) ([]WordCount, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `SELECT search_word, SUM(search_count) FROM user_searches
+		WHERE last_updated_at >= $1 AND last_updated_at < $2
+		GROUP BY search_word
+		ORDER BY 2 DESC, search_word`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWordCounts(rows)
+}
+
+// SearchHistogram counts the words and searches last updated in [from, to) by bucket
+func (db *PostgresDBV2) SearchHistogram(ctx context.Context, from, to time.Time, granularity Granularity) ([]SearchBucket, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.replicas.reader(db.db).QueryContext(ctx, `SELECT date_trunc($1, last_updated_at), COUNT(DISTINCT search_word), SUM(search_count)
+		FROM user_searches
+		WHERE last_updated_at >= $2 AND last_updated_at < $3
+		GROUP BY 1 ORDER BY 1`, granularity.unit(), from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := make([]SearchBucket, 0)
+	for rows.Next() {
+		var bucket SearchBucket
+		if err := rows.Scan(&bucket.Start, &bucket.UniqueWords, &bucket.Searches); err != nil {
+			return nil, err
+		}
+		bucket.Start = bucket.Start.UTC()
+		buckets = append(buckets, bucket)
+	}
+	return buckets, rows.Err()
+}
+
+// RollupSearches replaces the rows of [from, to) of the rollup table with the
+// records grouped by bucket and word, and moves the watermark, in one transaction
+func (db *PostgresDBV2) RollupSearches(ctx context.Context, from, to time.Time, granularity Granularity) (int64, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM `+granularity.table()+` WHERE bucket >= $1 AND bucket < $2`, from, to); err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(ctx, `INSERT INTO `+granularity.table()+` (bucket, search_word, total_count, unique_users)
+		SELECT date_trunc($1, last_updated_at), search_word, SUM(search_count), COUNT(*)
+		FROM user_searches
+		WHERE last_updated_at >= $2 AND last_updated_at < $3
+		GROUP BY 1, 2`, granularity.unit(), from, to)
+	if err != nil {
+		return 0, err
+	}
+	written, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO search_rollup_watermarks (granularity, rolled_up_until) VALUES ($1, $2)
+		ON CONFLICT (granularity) DO UPDATE
+		SET rolled_up_until = GREATEST(search_rollup_watermarks.rolled_up_until, EXCLUDED.rolled_up_until)`,
+		granularity.unit(), to); err != nil {
+		return 0, err
+	}
+
+	return written, tx.Commit()
+}
+
+// SearchesByHour groups the records of word by hour like RollupSearches without writing them
+func (db *PostgresDBV2) SearchesByHour(ctx context.Context, from, to time.Time, word string) ([]SearchRollup, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	return scanRollups(db.replicas.reader(db.db).QueryContext(ctx, `SELECT date_trunc('hour', last_updated_at), search_word, SUM(search_count), COUNT(*)
+		FROM user_searches
+		WHERE last_updated_at >= $1 AND last_updated_at < $2 AND ($3 = '' OR search_word = $3)
+		GROUP BY 1, 2
+		ORDER BY 1, 3 DESC, 2`, from, to, word))
+}
+
+// RolledUpUntil returns the watermark of the granularity
+func (db *PostgresDBV2) RolledUpUntil(ctx context.Context, granularity Granularity) (time.Time, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	var until time.Time
+	err := db.db.QueryRowContext(ctx, `SELECT rolled_up_until FROM search_rollup_watermarks WHERE granularity = $1`,
+		granularity.unit()).Scan(&until)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return until.UTC(), err
+}
+
+// GetRollups returns the rows of the rollup table in [from, to)
+func (db *PostgresDBV2) GetRollups(ctx context.Context, from, to time.Time, granularity Granularity) ([]SearchRollup, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	return scanRollups(db.replicas.reader(db.db).QueryContext(ctx, `SELECT bucket, search_word, total_count, unique_users FROM `+granularity.table()+`
+		WHERE bucket >= $1 AND bucket < $2
+		ORDER BY bucket, total_count DESC, search_word`, from, to))
+}
+
+// scanRollups reads the rows of a rollup table query
+func scanRollups(rows *sql.Rows, err error) ([]SearchRollup, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rollups := make([]SearchRollup, 0)
+	for rows.Next() {
+		var rollup SearchRollup
+		if err := rows.Scan(&rollup.Bucket, &rollup.Word, &rollup.TotalCount, &rollup.UniqueUsers); err != nil {
+			return nil, err
+		}
+		rollup.Bucket = rollup.Bucket.UTC()
+		rollups = append(rollups, rollup)
+	}
+	return rollups, rows.Err()
+}
+
+// UpdateUserSearchByWord updates a user's search record from old word to new word,
+// merging into an existing record of the new word the same way MockPostgresDBV2 does
+func (db *PostgresDBV2) UpdateUserSearchByWord(ctx context.Context, userIdentifier, oldWord, newWord string, lastUpdated time.Time) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldID int64
+	var oldFirstSearched time.Time
+	var oldCount int
+	err = tx.QueryRowContext(ctx, `SELECT id, first_searched_at, search_count FROM user_searches
+		WHERE user_identifier = $1 AND search_word = $2 FOR UPDATE`,
+		userIdentifier, oldWord).Scan(&oldID, &oldFirstSearched, &oldCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: no record for user %s with word %s", ErrUserNotFound, userIdentifier, oldWord)
+	}
+	if err != nil {
+		return err
+	}
+
+	var existingID int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM user_searches
+		WHERE user_identifier = $1 AND search_word = $2 FOR UPDATE`,
+		userIdentifier, newWord).Scan(&existingID)
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// No existing record with new word, just update the old record
+		_, err = tx.ExecContext(ctx, `UPDATE user_searches
+			SET search_word = $1, last_updated_at = $2, search_count = search_count + 1
+			WHERE id = $3`,
+			newWord, lastUpdated, oldID)
+	case err == nil:
+		// Merge with existing record, keeping the earlier first_searched_at
+		if _, err = tx.ExecContext(ctx, `DELETE FROM user_searches WHERE id = $1`, oldID); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE user_searches
+			SET last_updated_at = $1,
+				search_count = search_count + $2,
+				first_searched_at = LEAST(first_searched_at, $3)
+			WHERE id = $4`,
+			lastUpdated, oldCount, oldFirstSearched, existingID)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ConsolidateUserSearch decides and writes in a single statement, seeing
+// the records of the user in one snapshot. Concurrent statements writing the
+// same word are arbitrated by its unique index, those of different words of
+// the same user may still both be inserted, see UserLockStore.
+func (db *PostgresDBV2) ConsolidateUserSearch(ctx context.Context, userIdentifier, word string, at time.Time) (UserConsolidation, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	var extended, extension sql.NullString
+	err := db.db.QueryRowContext(ctx, `WITH existing AS (
+			SELECT id, search_word, first_searched_at, search_count FROM user_searches
+			WHERE user_identifier = $1
+		), prefix AS (
+			SELECT * FROM existing
+			WHERE search_word <> $2 AND starts_with($2, search_word)
+			ORDER BY length(search_word) DESC LIMIT 1
+		), extension AS (
+			SELECT search_word FROM existing
+			WHERE search_word <> $2 AND starts_with(search_word, $2) AND NOT EXISTS (SELECT 1 FROM prefix)
+			ORDER BY search_word LIMIT 1
+		), stored AS (
+			SELECT id FROM existing WHERE search_word = $2
+		), renamed AS (
+			-- The prefix becomes the word, counting one more search
+			UPDATE user_searches SET search_word = $2, last_updated_at = $3, search_count = search_count + 1
+			WHERE id = (SELECT id FROM prefix) AND NOT EXISTS (SELECT 1 FROM stored)
+		), merged AS (
+			-- The prefix is merged into the stored word below
+			DELETE FROM user_searches
+			WHERE id = (SELECT id FROM prefix) AND EXISTS (SELECT 1 FROM stored)
+		), written AS (
+			INSERT INTO user_searches (user_identifier, search_word, first_searched_at, last_updated_at, search_count)
+			SELECT $1, $2, COALESCE((SELECT first_searched_at FROM prefix), $3), $3, COALESCE((SELECT search_count FROM prefix), 1)
+			WHERE NOT EXISTS (SELECT 1 FROM extension)
+				AND NOT (EXISTS (SELECT 1 FROM prefix) AND NOT EXISTS (SELECT 1 FROM stored))
+			ON CONFLICT (user_identifier, search_word) DO UPDATE
+			SET last_updated_at = EXCLUDED.last_updated_at,
+				search_count = user_searches.search_count + EXCLUDED.search_count,
+				first_searched_at = LEAST(user_searches.first_searched_at, EXCLUDED.first_searched_at)
+		)
+		SELECT (SELECT search_word FROM prefix), (SELECT search_word FROM extension)`,
+		userIdentifier, word, at).Scan(&extended, &extension)
+	if err != nil {
+		return UserConsolidation{}, err
+	}
+
+	return UserConsolidation{Extended: extended.String, Extension: extension.String}, nil
+}
+
+// SetSurfaceWord sets the surface form of the user's record of word
+func (db *PostgresDBV2) SetSurfaceWord(ctx context.Context, userIdentifier, word, surface string) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	_, err := db.db.ExecContext(ctx, `UPDATE user_searches SET surface_word = $3
+		WHERE user_identifier = $1 AND search_word = $2`, userIdentifier, word, surface)
+	return err
+}
+
+// SetUserSearchRegion sets the country and locale of the user's record of word
+func (db *PostgresDBV2) SetUserSearchRegion(ctx context.Context, userIdentifier, word string, region Region) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	_, err := db.db.ExecContext(ctx, `UPDATE user_searches SET country = NULLIF($3, ''), locale = NULLIF($4, '')
+		WHERE user_identifier = $1 AND search_word = $2`, userIdentifier, word, region.Country, region.Locale)
+	return err
+}
+
+// TopSearchesByRegion returns the most searched words over the records of the region of query
+func (db *PostgresDBV2) TopSearchesByRegion(ctx context.Context, query RegionQuery) ([]WordCount, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.replicas.reader(db.db).QueryContext(ctx, `SELECT search_word, SUM(search_count) FROM user_searches
+		WHERE ($1 = '' OR country = $1) AND ($2 = '' OR locale = $2) AND last_updated_at >= $3
+		GROUP BY search_word
+		ORDER BY 2 DESC, search_word LIMIT $4`, query.Country, query.Locale, query.Since, query.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWordCounts(rows)
+}
+
+// ApplyUserSearchWrites applies a batch of coalesced writes in one transaction:
+// a single DELETE ... RETURNING removes the replaced prefixes, then a single
+// multi-row INSERT ... ON CONFLICT UPDATE upserts the words with the merged counts
+func (db *PostgresDBV2) ApplyUserSearchWrites(ctx context.Context, writes []UserSearchWrite) error {
+	if len(writes) == 0 {
+		return nil
+	}
+
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Index every replaced prefix by the write it is merged into
+	merged := make([]UserSearchWrite, len(writes))
+	copy(merged, writes)
+	targets := make(map[[2]string]int)
+	var deleteUsers, deleteWords []string
+	for i, write := range writes {
+		for _, word := range write.ReplaceWords {
+			targets[[2]string{write.UserIdentifier, word}] = i
+			deleteUsers = append(deleteUsers, write.UserIdentifier)
+			deleteWords = append(deleteWords, word)
+		}
+	}
+
+	if len(deleteWords) > 0 {
+		rows, err := tx.QueryContext(ctx, `DELETE FROM user_searches AS u
+			USING unnest($1::varchar[], $2::varchar[]) AS d(user_identifier, search_word)
+			WHERE u.user_identifier = d.user_identifier AND u.search_word = d.search_word
+			RETURNING u.user_identifier, u.search_word, u.first_searched_at, u.search_count`,
+			deleteUsers, deleteWords)
+		if err != nil {
+			return err
+		}
+
+		for rows.Next() {
+			var userIdentifier, word string
+			var firstSearched time.Time
+			var count int
+			if err := rows.Scan(&userIdentifier, &word, &firstSearched, &count); err != nil {
+				rows.Close()
+				return err
+			}
+
+			target := &merged[targets[[2]string{userIdentifier, word}]]
+			target.Count += count
+			if firstSearched.Before(target.FirstSearchedAt) {
+				target.FirstSearchedAt = firstSearched
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	users := make([]string, len(merged))
+	words := make([]string, len(merged))
+	firstSearched := make([]time.Time, len(merged))
+	lastUpdated := make([]time.Time, len(merged))
+	counts := make([]int64, len(merged))
+	for i, write := range merged {
+		users[i] = write.UserIdentifier
+		words[i] = write.Word
+		firstSearched[i] = write.FirstSearchedAt
+		lastUpdated[i] = write.LastUpdatedAt
+		counts[i] = int64(write.Count)
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO user_searches (user_identifier, search_word, first_searched_at, last_updated_at, search_count)
+		SELECT * FROM unnest($1::varchar[], $2::varchar[], $3::timestamp[], $4::timestamp[], $5::int[])
+		ON CONFLICT (user_identifier, search_word) DO UPDATE
+		SET first_searched_at = LEAST(user_searches.first_searched_at, EXCLUDED.first_searched_at),
+			last_updated_at = GREATEST(user_searches.last_updated_at, EXCLUDED.last_updated_at),
+			search_count = user_searches.search_count + EXCLUDED.search_count`,
+		users, words, firstSearched, lastUpdated, counts)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PurgeUserSearches deletes the records last updated before cutoff
+func (db *PostgresDBV2) PurgeUserSearches(ctx context.Context, cutoff time.Time) ([]WordCount, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `DELETE FROM user_searches WHERE last_updated_at < $1
+		RETURNING search_word, search_count`, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWordCounts(rows)
+}
+
+// AddAudiences adds the users to the HyperLogLogs of their words in one
+// transaction. The rows are created empty first and locked in word order, so
+// concurrent writers of a word merge into it instead of overwriting each other.
+func (db *PostgresDBV2) AddAudiences(ctx context.Context, adds []AudienceAdd) error {
+	if len(adds) == 0 {
+		return nil
+	}
+
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, update := range groupAudiences(adds) {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO search_audiences (word, registers, users, last_updated_at)
+			VALUES ($1, '', 0, $2) ON CONFLICT (word) DO NOTHING`, update.word, update.at.UTC()); err != nil {
+			return err
+		}
+		var registers []byte
+		if err := tx.QueryRowContext(ctx, `SELECT registers FROM search_audiences WHERE word = $1 FOR UPDATE`,
+			update.word).Scan(&registers); err != nil {
+			return err
+		}
+		registers, users, err := addUsers(registers, update.users)
+		if err != nil {
+			return fmt.Errorf("audience of %q: %w", update.word, err)
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE search_audiences
+			SET registers = $2, users = $3, last_updated_at = GREATEST(last_updated_at, $4)
+			WHERE word = $1`, update.word, registers, users, update.at.UTC()); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// TopAudiences returns the stored words searched by the most users last searched at or after since
+func (db *PostgresDBV2) TopAudiences(ctx context.Context, since time.Time, limit int) ([]WordCount, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.replicas.reader(db.db).QueryContext(ctx, `SELECT a.word, SUM(s.search_count), a.users FROM search_audiences a
+		JOIN user_searches s ON s.search_word = a.word
+		WHERE a.last_updated_at >= $1
+		GROUP BY a.word, a.users
+		ORDER BY a.users DESC, 2 DESC, a.word LIMIT $2`, since.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAudiences(rows)
+}
+
+// AppendAudit inserts an entry into the audit_log table
+func (db *PostgresDBV2) AppendAudit(ctx context.Context, entry AuditEntry) (int64, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	var id int64
+	err := db.db.QueryRowContext(ctx, `INSERT INTO audit_log (at, actor, action, target, before_value, after_value)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		entry.At.UTC(), entry.Actor, entry.Action, entry.Target, entry.Before, entry.After).Scan(&id)
+	return id, err
+}
+
+// ListAudit returns the audit_log entries selected by query, newest first
+func (db *PostgresDBV2) ListAudit(ctx context.Context, query AuditQuery) ([]AuditEntry, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	return scanAuditEntries(db.replicas.reader(db.db).QueryContext(ctx, `SELECT id, at, actor, action, target, before_value, after_value FROM audit_log
+		WHERE ($1 = '' OR actor = $1) AND ($2 = '' OR action = $2) AND at >= $3
+		ORDER BY id DESC LIMIT $4`,
+		query.Actor, query.Action, query.Since.UTC(), query.limit()))
+}
+
+// QuarantineSearch inserts a search into the quarantined_searches table
+func (db *PostgresDBV2) QuarantineSearch(ctx context.Context, search QuarantinedSearch) (int64, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	var id int64
+	err := db.db.QueryRowContext(ctx, `INSERT INTO quarantined_searches (user_identifier, search_word, reason, searched_at)
+		VALUES ($1, $2, $3, $4) RETURNING id`,
+		search.UserIdentifier, search.Word, search.Reason, search.SearchedAt.UTC()).Scan(&id)
+	return id, err
+}
+
+// ListQuarantinedSearches returns the quarantined searches of the user, of every user when empty, in ID order
+func (db *PostgresDBV2) ListQuarantinedSearches(ctx context.Context, userIdentifier string) ([]QuarantinedSearch, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	return scanQuarantinedSearches(db.replicas.reader(db.db).QueryContext(ctx, `SELECT id, user_identifier, search_word, reason, searched_at
+		FROM quarantined_searches WHERE ($1 = '' OR user_identifier = $1) ORDER BY id`, userIdentifier))
+}
+
+// TakeQuarantinedSearches deletes the quarantined searches of ids and returns them in ID order
+func (db *PostgresDBV2) TakeQuarantinedSearches(ctx context.Context, ids []int64) ([]QuarantinedSearch, error) {
+	if len(ids) == 0 {
+		return []QuarantinedSearch{}, nil
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	return sortQuarantinedSearches(scanQuarantinedSearches(db.db.QueryContext(ctx, `DELETE FROM quarantined_searches WHERE id = ANY($1)
+		RETURNING id, user_identifier, search_word, reason, searched_at`, ids)))
+}
+
+// DeleteUserQuarantine deletes the quarantined searches of the user
+func (db *PostgresDBV2) DeleteUserQuarantine(ctx context.Context, userIdentifier string) (int64, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	result, err := db.db.ExecContext(ctx, `DELETE FROM quarantined_searches WHERE user_identifier = $1`, userIdentifier)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// AddSearchClick inserts a click into the search_clicks table
+func (db *PostgresDBV2) AddSearchClick(ctx context.Context, click SearchClick) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	_, err := db.db.ExecContext(ctx, `INSERT INTO search_clicks (user_identifier, search_word, result_id, clicked_at)
+		VALUES ($1, $2, $3, $4)`, click.UserIdentifier, click.Word, click.ResultID, click.ClickedAt.UTC())
+	return err
+}
+
+// ClickStats sums the search counts of words in user_searches and counts their clicks in search_clicks
+func (db *PostgresDBV2) ClickStats(ctx context.Context, words []string) (map[string]ClickStats, error) {
+	if len(words) == 0 {
+		return map[string]ClickStats{}, nil
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	reader := db.replicas.reader(db.db)
+	searches, err := scanWordTotals(reader.QueryContext(ctx, `SELECT search_word, SUM(search_count) FROM user_searches
+		WHERE search_word = ANY($1) GROUP BY search_word`, words))
+	if err != nil {
+		return nil, err
+	}
+	clicks, err := scanWordTotals(reader.QueryContext(ctx, `SELECT search_word, COUNT(*) FROM search_clicks
+		WHERE search_word = ANY($1) GROUP BY search_word`, words))
+	if err != nil {
+		return nil, err
+	}
+	return clickStats(searches, clicks), nil
+}
+
+// DeleteUserClicks deletes the clicks of the user
+func (db *PostgresDBV2) DeleteUserClicks(ctx context.Context, userIdentifier string) (int64, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	result, err := db.db.ExecContext(ctx, `DELETE FROM search_clicks WHERE user_identifier = $1`, userIdentifier)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RecordSearchResults extends the streak of word in zero_result_terms with INSERT ... ON CONFLICT UPDATE
+// for a search without result, and deletes it otherwise
+func (db *PostgresDBV2) RecordSearchResults(ctx context.Context, word string, results int, at time.Time) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	if results != 0 {
+		_, err := db.db.ExecContext(ctx, `DELETE FROM zero_result_terms WHERE search_word = $1`, word)
+		return err
+	}
+	_, err := db.db.ExecContext(ctx, `INSERT INTO zero_result_terms (search_word, streak, first_zero_at, last_zero_at)
+		VALUES ($1, 1, $2, $2)
+		ON CONFLICT (search_word) DO UPDATE SET streak = zero_result_terms.streak + 1, last_zero_at = EXCLUDED.last_zero_at`,
+		word, at.UTC())
+	return err
+}
+
+// ZeroResultTerms returns the words of zero_result_terms with a streak of at least minStreak
+func (db *PostgresDBV2) ZeroResultTerms(ctx context.Context, minStreak, limit int) ([]ZeroResultTerm, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	return scanZeroResultTerms(db.replicas.reader(db.db).QueryContext(ctx, `SELECT search_word, streak, first_zero_at, last_zero_at
+		FROM zero_result_terms WHERE streak >= $1
+		ORDER BY streak DESC, last_zero_at DESC, search_word LIMIT $2`, minStreak, limit))
+}
+
+// PutSynonym maps alias to canonical with INSERT ... ON CONFLICT UPDATE
+func (db *PostgresDBV2) PutSynonym(ctx context.Context, alias, canonical string) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	_, err := db.db.ExecContext(ctx, `INSERT INTO search_synonyms (alias, canonical) VALUES ($1, $2)
+		ON CONFLICT (alias) DO UPDATE SET canonical = EXCLUDED.canonical`, alias, canonical)
+	return err
+}
+
+// DeleteSynonym deletes the alias from search_synonyms
+func (db *PostgresDBV2) DeleteSynonym(ctx context.Context, alias string) (bool, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	result, err := db.db.ExecContext(ctx, `DELETE FROM search_synonyms WHERE alias = $1`, alias)
+	if err != nil {
+		return false, err
+	}
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
+
+// ListSynonyms returns the rows of search_synonyms by alias
+func (db *PostgresDBV2) ListSynonyms(ctx context.Context) ([]Synonym, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	return scanSynonyms(db.db.QueryContext(ctx, `SELECT alias, canonical FROM search_synonyms ORDER BY alias`))
+}
+
+// TrimUserSearches deletes the records of the user beyond the maxRecords most recently updated
+func (db *PostgresDBV2) TrimUserSearches(ctx context.Context, userIdentifier string, maxRecords int) ([]WordCount, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `DELETE FROM user_searches WHERE id IN (
+		SELECT id FROM user_searches WHERE user_identifier = $1
+		ORDER BY last_updated_at DESC, id DESC OFFSET $2)
+		RETURNING search_word, search_count`, userIdentifier, maxRecords)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWordCounts(rows)
+}
+
+// MergeUserSearches moves the records of fromUser to toUser in one transaction:
+// both histories are locked and read, merged in memory, deleted and inserted back
+func (db *PostgresDBV2) MergeUserSearches(ctx context.Context, fromUser, toUser string) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, user_identifier, search_word, first_searched_at, last_updated_at, search_count, surface_word
+		FROM user_searches WHERE user_identifier IN ($1, $2) FOR UPDATE`, fromUser, toUser)
+	if err != nil {
+		return err
+	}
+	records, err := scanUserSearchRecords(rows)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_searches WHERE user_identifier IN ($1, $2)`, fromUser, toUser); err != nil {
+		return err
+	}
+	for _, record := range mergeUserRecords(records, toUser) {
+		_, err := tx.ExecContext(ctx, `INSERT INTO user_searches (id, user_identifier, search_word, first_searched_at, last_updated_at, search_count, surface_word)
+			VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))`,
+			record.ID, record.UserIdentifier, record.SearchWord, record.FirstSearchedAt, record.LastUpdatedAt, record.SearchCount, record.SurfaceWord)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// MergeUserWords merges the records of from of every user into their records of
+// to in one transaction: the records of both words are locked and read, merged
+// in memory, then the merged records of from are deleted and the others updated
+func (db *PostgresDBV2) MergeUserWords(ctx context.Context, from, to string) (int64, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, user_identifier, search_word, first_searched_at, last_updated_at, search_count, surface_word
+		FROM user_searches WHERE search_word IN ($1, $2) FOR UPDATE`, from, to)
+	if err != nil {
+		return 0, err
+	}
+	records, err := scanUserSearchRecords(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	updates, deletes := mergeWordRecords(records, from, to)
+	for _, id := range deletes {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_searches WHERE id = $1`, id); err != nil {
+			return 0, err
+		}
+	}
+	for _, record := range updates {
+		_, err := tx.ExecContext(ctx, `UPDATE user_searches
+			SET search_word = $1, first_searched_at = $2, last_updated_at = $3, search_count = $4, surface_word = NULLIF($5, '')
+			WHERE id = $6`,
+			record.SearchWord, record.FirstSearchedAt, record.LastUpdatedAt, record.SearchCount, record.SurfaceWord, record.ID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return int64(len(updates)), tx.Commit()
+}
+
+// AdvanceUserClock raises the clock of the user in one transaction and returns its clock before
+func (db *PostgresDBV2) AdvanceUserClock(ctx context.Context, userIdentifier string, clock UserClock) (UserClock, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return UserClock{}, err
+	}
+	defer tx.Rollback()
+
+	// The zero row locks a user without clock like any other
+	if _, err := tx.ExecContext(ctx, `INSERT INTO user_clocks (user_identifier, client_at, sequence)
+		VALUES ($1, $2, 0) ON CONFLICT (user_identifier) DO NOTHING`, userIdentifier, time.Time{}); err != nil {
+		return UserClock{}, err
+	}
+	var previous UserClock
+	var sequence int64
+	if err := tx.QueryRowContext(ctx, `SELECT client_at, sequence FROM user_clocks WHERE user_identifier = $1 FOR UPDATE`,
+		userIdentifier).Scan(&previous.At, &sequence); err != nil {
+		return UserClock{}, err
+	}
+	previous.At, previous.Sequence = previous.At.UTC(), uint64(sequence)
+
+	next := previous.merge(clock)
+	if _, err := tx.ExecContext(ctx, `UPDATE user_clocks SET client_at = $2, sequence = $3 WHERE user_identifier = $1`,
+		userIdentifier, next.At.UTC(), int64(next.Sequence)); err != nil {
+		return UserClock{}, err
+	}
+
+	return previous, tx.Commit()
+}
+
+// DeleteUserClock removes the clock of the user
+func (db *PostgresDBV2) DeleteUserClock(ctx context.Context, userIdentifier string) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	_, err := db.db.ExecContext(ctx, `DELETE FROM user_clocks WHERE user_identifier = $1`, userIdentifier)
+	return err
+}
+
+// DeleteUserSearches removes every record of the user
+func (db *PostgresDBV2) DeleteUserSearches(ctx context.Context, userIdentifier string) ([]WordCount, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `DELETE FROM user_searches WHERE user_identifier = $1
+		RETURNING search_word, search_count`, userIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWordCounts(rows)
+}
+
+// scanUserSearchRecords reads full user_searches rows and closes rows
+func scanUserSearchRecords(rows *sql.Rows) ([]UserSearchRecord, error) {
+	defer rows.Close()
+
+	var records []UserSearchRecord
+	for rows.Next() {
+		record, err := scanUserSearchRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// scanUserSearchRecord reads the current full user_searches row, a NULL surface_word reads as empty
+func scanUserSearchRecord(rows *sql.Rows) (UserSearchRecord, error) {
+	var record UserSearchRecord
+	var surface sql.NullString
+	err := rows.Scan(&record.ID, &record.UserIdentifier, &record.SearchWord, &record.FirstSearchedAt, &record.LastUpdatedAt, &record.SearchCount, &surface)
+	record.SurfaceWord = surface.String
+	return record, err
+}
+
+// userLockSpace is the first key of the advisory locks of the users, the second one hashing the user
+const userLockSpace = 0x75736572
+
+// WithUserLock runs fn while holding a session advisory lock of the user on a
+// connection of its own, waiting up to the query timeout for its holder. The
+// lock is released by PostgreSQL if the process dies while holding it.
+func (db *PostgresDBV2) WithUserLock(ctx context.Context, userIdentifier string, fn func(ctx context.Context) error) error {
+	lockCtx, cancel := db.queryContext(ctx)
+	defer cancel()
+	conn, err := db.locks.Conn(lockCtx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(lockCtx, `SELECT pg_advisory_lock($1, hashtext($2))`, userLockSpace, userIdentifier); err != nil {
+		// The lock may have been granted as the wait was cancelled
+		conn.Raw(func(any) error { return driver.ErrBadConn })
+		return fmt.Errorf("failed to lock user %s: %w", userIdentifier, err)
+	}
+
+	defer func() {
+		unlockCtx, cancel := db.queryContext(context.WithoutCancel(ctx))
+		defer cancel()
+		if _, err := conn.ExecContext(unlockCtx, `SELECT pg_advisory_unlock($1, hashtext($2))`, userLockSpace, userIdentifier); err != nil {
+			// Dropping the connection releases the lock, a pooled one would keep it
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+	}()
+	return fn(ctx)
+}
+
+// Ping checks the connection to the database
+func (db *PostgresDBV2) Ping(ctx context.Context) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	return db.db.PingContext(ctx)
+}
+
+// Close closes the connection pool
+func (db *PostgresDBV2) Close() error {
+	return errors.Join(db.replicas.close(), db.locks.Close(), db.db.Close())
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+)
+
+// QuarantinedSearch is a row of the quarantined_searches table: a search kept
+// out of user_searches because it looked abusive or was filtered, until it is
+// approved or purged
+type QuarantinedSearch struct {
+	ID             int64
+	UserIdentifier string
+	Word           string
+	// Reason is why the search was quarantined, e.g. rate
+	Reason     string
+	SearchedAt time.Time
+}
+
+// QuarantineStore is a UserSearchStore that keeps the suspicious searches in
+// the quarantined_searches table, apart from the records of user_searches
+type QuarantineStore interface {
+	UserSearchStore
+	// QuarantineSearch stores a search and returns its ID
+	QuarantineSearch(ctx context.Context, search QuarantinedSearch) (int64, error)
+	// ListQuarantinedSearches returns the quarantined searches of the user,
+	// of every user when empty, in ID order
+	ListQuarantinedSearches(ctx context.Context, userIdentifier string) ([]QuarantinedSearch, error)
+	// TakeQuarantinedSearches deletes the quarantined searches of ids and
+	// returns them in ID order, skipping the ids not found, so a search is
+	// taken once by concurrent reviews
+	TakeQuarantinedSearches(ctx context.Context, ids []int64) ([]QuarantinedSearch, error)
+	// DeleteUserQuarantine deletes the quarantined searches of the user and returns how many were deleted
+	DeleteUserQuarantine(ctx context.Context, userIdentifier string) (int64, error)
+}
+
+// scanQuarantinedSearches reads the rows of a quarantined_searches query
+func scanQuarantinedSearches(rows *sql.Rows, err error) ([]QuarantinedSearch, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	searches := make([]QuarantinedSearch, 0)
+	for rows.Next() {
+		var search QuarantinedSearch
+		if err := rows.Scan(&search.ID, &search.UserIdentifier, &search.Word, &search.Reason, &search.SearchedAt); err != nil {
+			return nil, err
+		}
+		search.SearchedAt = search.SearchedAt.UTC()
+		searches = append(searches, search)
+	}
+	return searches, rows.Err()
+}
+
+// sortQuarantinedSearches sorts the rows of a DELETE ... RETURNING in ID order
+func sortQuarantinedSearches(searches []QuarantinedSearch, err error) ([]QuarantinedSearch, error) {
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(searches, func(i, j int) bool { return searches[i].ID < searches[j].ID })
+	return searches, nil
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuarantine(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		open func(t *testing.T) QuarantineStore
+	}{
+		{"mock", func(t *testing.T) QuarantineStore {
+			return NewMockPostgresDBV2()
+		}},
+		{"sqlite", func(t *testing.T) QuarantineStore {
+			db, err := NewSQLiteDBV2(sqliteConfig(t))
+			require.NoError(t, err)
+			t.Cleanup(func() { db.Close() })
+			return db
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := tt.open(t)
+			require.NoError(t, db.CreateTable(ctx))
+
+			at := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+			for _, search := range []QuarantinedSearch{
+				{UserIdentifier: "bot_1", Word: "xq7zk2", Reason: "entropy", SearchedAt: at},
+				{UserIdentifier: "bot_2", Word: "cheap pills", Reason: "rate", SearchedAt: at},
+				{UserIdentifier: "bot_1", Word: "buy now", Reason: "rate", SearchedAt: at.Add(time.Second)},
+			} {
+				_, err := db.QuarantineSearch(ctx, search)
+				require.NoError(t, err)
+			}
+
+			searches, err := db.ListQuarantinedSearches(ctx, "bot_1")
+			require.NoError(t, err)
+			require.Len(t, searches, 2)
+			assert.Equal(t, QuarantinedSearch{ID: searches[0].ID, UserIdentifier: "bot_1", Word: "xq7zk2", Reason: "entropy", SearchedAt: at}, searches[0])
+			assert.Equal(t, "buy now", searches[1].Word)
+
+			searches, err = db.ListQuarantinedSearches(ctx, "")
+			require.NoError(t, err)
+			assert.Len(t, searches, 3)
+
+			// A search is taken once, the unknown ids are skipped
+			taken, err := db.TakeQuarantinedSearches(ctx, []int64{searches[2].ID, searches[0].ID, 999})
+			require.NoError(t, err)
+			require.Len(t, taken, 2)
+			assert.Equal(t, "xq7zk2", taken[0].Word)
+			assert.Equal(t, "buy now", taken[1].Word)
+			taken, err = db.TakeQuarantinedSearches(ctx, []int64{searches[0].ID})
+			require.NoError(t, err)
+			assert.Empty(t, taken)
+			_, err = db.QuarantineSearch(ctx, QuarantinedSearch{UserIdentifier: "bot_1", Word: "buy now", Reason: "rate", SearchedAt: at})
+			require.NoError(t, err)
+
+			deleted, err := db.DeleteUserQuarantine(ctx, "bot_1")
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), deleted)
+			searches, err = db.ListQuarantinedSearches(ctx, "")
+			require.NoError(t, err)
+			require.Len(t, searches, 1)
+			assert.Equal(t, "bot_2", searches[0].UserIdentifier)
+
+			// The user searches are left alone
+			words, err := db.GetUserSearches(ctx, "bot_2")
+			require.NoError(t, err)
+			assert.Empty(t, words)
+		})
+	}
+}
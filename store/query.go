@@ -0,0 +1,131 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SearchOrder orders the results of a SearchQuery
+type SearchOrder int
+
+const (
+	// OrderAlphabetical orders the words alphabetically
+	OrderAlphabetical SearchOrder = iota
+	// OrderCount orders the most searched words first, ties alphabetically
+	OrderCount
+	// OrderRecent orders the most recently searched words first, ties alphabetically
+	OrderRecent
+)
+
+// SearchQuery selects, orders and pages the records of the searches table.
+// The zero value returns every record alphabetically.
+type SearchQuery struct {
+	// Prefix keeps the words starting with it
+	Prefix string
+	// Since and Until keep the records last updated at or after Since and
+	// before Until, zero values leave the range open
+	Since time.Time
+	Until time.Time
+	// MinCount keeps the words searched at least MinCount times
+	MinCount int
+	Order    SearchOrder
+	// Limit bounds the number of records returned, 0 returns every record after Offset
+	Limit  int
+	Offset int
+}
+
+// QuerySearchStore is a SearchStore that can filter, order and page its records
+type QuerySearchStore interface {
+	SearchStore
+	// QuerySearches returns the records matching the query in its order
+	QuerySearches(ctx context.Context, query SearchQuery) ([]SearchRecord, error)
+}
+
+// SearchExportStore is a SearchStore that can stream its records, e.g. to
+// dump a table of millions of rows without holding them in memory
+type SearchExportStore interface {
+	SearchStore
+	// ForEachSearch calls fn with every record matching the query in its
+	// order, stopping at and returning the first error of fn. The records may
+	// be streamed from an open query, so fn must not use the store.
+	ForEachSearch(ctx context.Context, query SearchQuery, fn func(SearchRecord) error) error
+}
+
+// matches reports whether a record passes the filters of the query
+func (q SearchQuery) matches(record SearchRecord) bool {
+	return strings.HasPrefix(record.Word, q.Prefix) &&
+		record.SearchCount >= q.MinCount &&
+		!record.LastUpdatedAt.Before(q.Since) &&
+		(q.Until.IsZero() || record.LastUpdatedAt.Before(q.Until))
+}
+
+// sortAndPage orders the records matching the query and returns its page of them
+func (q SearchQuery) sortAndPage(records []SearchRecord) []SearchRecord {
+	sort.Slice(records, func(i, j int) bool {
+		switch {
+		case q.Order == OrderCount && records[i].SearchCount != records[j].SearchCount:
+			return records[i].SearchCount > records[j].SearchCount
+		case q.Order == OrderRecent && !records[i].LastUpdatedAt.Equal(records[j].LastUpdatedAt):
+			return records[i].LastUpdatedAt.After(records[j].LastUpdatedAt)
+		}
+		return records[i].Word < records[j].Word
+	})
+
+	records = records[min(max(q.Offset, 0), len(records)):]
+	if q.Limit > 0 && len(records) > q.Limit {
+		records = records[:q.Limit]
+	}
+	return records
+}
+
+// sql builds the SELECT of the query, placeholder returning the n-th parameter
+// marker of the database and arg converting the times to its column format
+func (q SearchQuery) sql(placeholder func(n int) string, timeArg func(time.Time) any) (string, []any) {
+	var where []string
+	var args []any
+	param := func(arg any) string {
+		args = append(args, arg)
+		return placeholder(len(args))
+	}
+
+	// substr and length count characters on both PostgreSQL and SQLite, unlike LIKE it needs no escaping
+	if q.Prefix != "" {
+		where = append(where, fmt.Sprintf("substr(word, 1, length(%s)) = %s", param(q.Prefix), param(q.Prefix)))
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "last_updated_at >= "+param(timeArg(q.Since)))
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "last_updated_at < "+param(timeArg(q.Until)))
+	}
+	if q.MinCount > 0 {
+		where = append(where, "search_count >= "+param(q.MinCount))
+	}
+
+	query := `SELECT id, word, first_searched_at, last_updated_at, search_count, verified FROM searches`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	switch q.Order {
+	case OrderCount:
+		query += " ORDER BY search_count DESC, word"
+	case OrderRecent:
+		query += " ORDER BY last_updated_at DESC, word"
+	default:
+		query += " ORDER BY word"
+	}
+	// SQLite needs a LIMIT before an OFFSET, the largest one is accepted by both
+	if q.Limit > 0 {
+		query += " LIMIT " + param(q.Limit)
+	} else if q.Offset > 0 {
+		query += " LIMIT " + param(int64(math.MaxInt64))
+	}
+	if q.Offset > 0 {
+		query += " OFFSET " + param(q.Offset)
+	}
+	return query, args
+}
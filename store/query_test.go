@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuerySearches(t *testing.T) {
+	for _, open := range []struct {
+		name string
+		open func(t *testing.T) QuerySearchStore
+	}{
+		{"mock", func(t *testing.T) QuerySearchStore {
+			return NewMockPostgresDB()
+		}},
+		{"sqlite", func(t *testing.T) QuerySearchStore {
+			db, err := NewSQLiteDB(sqliteConfig(t))
+			require.NoError(t, err)
+			t.Cleanup(func() { db.Close() })
+			return db
+		}},
+	} {
+		t.Run(open.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := open.open(t)
+			require.NoError(t, db.CreateTable(ctx))
+
+			t0 := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+			for i, word := range []string{"cat", "cats", "car", "cat", "dog", "cat", "car", "café"} {
+				at := t0.Add(time.Duration(i) * time.Hour)
+				_, err := db.InsertOrReplace(ctx, word, at, at)
+				require.NoError(t, err)
+			}
+
+			for _, tt := range []struct {
+				name  string
+				query SearchQuery
+				want  []string
+			}{
+				{"all", SearchQuery{}, []string{"café", "car", "cat", "cats", "dog"}},
+				{"prefix", SearchQuery{Prefix: "ca"}, []string{"café", "car", "cat", "cats"}},
+				{"multibyte prefix", SearchQuery{Prefix: "caf"}, []string{"café"}},
+				{"count", SearchQuery{Order: OrderCount}, []string{"cat", "car", "café", "cats", "dog"}},
+				{"recent", SearchQuery{Order: OrderRecent}, []string{"café", "car", "cat", "dog", "cats"}},
+				{"min count", SearchQuery{MinCount: 2}, []string{"car", "cat"}},
+				{"since", SearchQuery{Since: t0.Add(5 * time.Hour)}, []string{"café", "car", "cat"}},
+				{"until", SearchQuery{Until: t0.Add(4 * time.Hour)}, []string{"cats"}},
+				{"page", SearchQuery{Limit: 2, Offset: 1}, []string{"car", "cat"}},
+				{"offset only", SearchQuery{Offset: 3}, []string{"cats", "dog"}},
+				{"past the end", SearchQuery{Offset: 10}, []string{}},
+				{"combined", SearchQuery{Prefix: "ca", MinCount: 2, Order: OrderCount, Limit: 1}, []string{"cat"}},
+			} {
+				records, err := db.QuerySearches(ctx, tt.query)
+				require.NoError(t, err, tt.name)
+				words := make([]string, 0, len(records))
+				for _, record := range records {
+					words = append(words, record.Word)
+				}
+				assert.Equal(t, tt.want, words, tt.name)
+			}
+		})
+	}
+}
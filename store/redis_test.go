@@ -0,0 +1,204 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisV2Storage(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	db, err := NewRedisDBV2(RedisConfig{Addr: server.Addr()}, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.CreateTable(ctx))
+	user := "redis_test_user"
+
+	now := time.Now()
+	id, err := db.InsertOrUpdateUserSearch(ctx, user, "bu", now, now)
+	require.NoError(t, err)
+	_, err = db.InsertOrUpdateUserSearch(ctx, user, "bus", now, now)
+	require.NoError(t, err)
+
+	sameID, err := db.InsertOrUpdateUserSearch(ctx, user, "bu", now, now)
+	require.NoError(t, err)
+	assert.Equal(t, id, sameID, "Repeated search should keep its record")
+
+	// Extending "bu" to "bus" merges into the existing "bus" record
+	require.NoError(t, db.UpdateUserSearchByWord(ctx, user, "bu", "bus", now))
+	assert.ErrorIs(t, db.UpdateUserSearchByWord(ctx, user, "bu", "bus", now), ErrUserNotFound)
+
+	searches, err := db.GetUserSearches(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bus"}, searches)
+
+	// "bus" is replaced by "business", "cat" is new and "dog" is searched by another user
+	require.NoError(t, db.ApplyUserSearchWrites(ctx, []UserSearchWrite{
+		{UserIdentifier: user, Word: "business", ReplaceWords: []string{"bus"}, FirstSearchedAt: now, LastUpdatedAt: now, Count: 1},
+		{UserIdentifier: user, Word: "cat", FirstSearchedAt: now, LastUpdatedAt: now, Count: 2},
+		{UserIdentifier: "other_user", Word: "cat", FirstSearchedAt: now, LastUpdatedAt: now, Count: 1},
+		{UserIdentifier: "other_user", Word: "dog", FirstSearchedAt: now, LastUpdatedAt: now, Count: 1},
+	}))
+
+	searches, err = db.GetUserSearches(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"business", "cat"}, searches)
+
+	searches, err = db.GetUserSearches(ctx, "unknown_user")
+	require.NoError(t, err)
+	assert.Empty(t, searches)
+
+	top, err := db.TopSearches(ctx, time.Time{}, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []WordCount{{Word: "business", Count: 4}, {Word: "cat", Count: 3}, {Word: "dog", Count: 1}}, top)
+
+	top, err = db.TopSearches(ctx, time.Time{}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []WordCount{{Word: "business", Count: 4}}, top)
+
+	// Windowed rankings need a backing store
+	_, err = db.TopSearches(ctx, now, 10)
+	assert.Error(t, err)
+
+	// Replaced prefixes no longer count nor show up in suggestions
+	suggestions, err := db.Suggest(ctx, "bu", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"business"}, suggestions)
+
+	suggestions, err = db.Suggest(ctx, "", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"business", "cat"}, suggestions)
+
+	// Deleting a user removes their counts from the rankings and suggestions
+	deleted, err := db.DeleteUserSearches(ctx, user)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []WordCount{{Word: "business", Count: 4}, {Word: "cat", Count: 2}}, deleted)
+	assert.False(t, server.Exists(db.userKey(user)))
+
+	top, err = db.TopSearches(ctx, time.Time{}, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []WordCount{{Word: "cat", Count: 1}, {Word: "dog", Count: 1}}, top)
+
+	suggestions, err = db.Suggest(ctx, "bu", 10)
+	require.NoError(t, err)
+	assert.Empty(t, suggestions)
+}
+
+func TestRedisV2BackingStore(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	backing := NewMockPostgresDBV2()
+	db, err := NewRedisDBV2(RedisConfig{Addr: server.Addr(), KeyPrefix: "test:"}, backing)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.CreateTable(ctx))
+	user := "redis_backing_user"
+
+	now := time.Now()
+	_, err = db.InsertOrUpdateUserSearch(ctx, user, "ca", now, now)
+	require.NoError(t, err)
+	require.NoError(t, db.UpdateUserSearchByWord(ctx, user, "ca", "cat", now))
+	require.NoError(t, db.ApplyUserSearchWrites(ctx, []UserSearchWrite{
+		{UserIdentifier: user, Word: "dog", FirstSearchedAt: now, LastUpdatedAt: now, Count: 1},
+	}))
+
+	// Every write reaches the backing store
+	searches, err := backing.GetUserSearches(ctx, user)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"cat", "dog"}, searches)
+
+	assert.True(t, server.Exists("test:user:"+user))
+
+	// Windowed rankings are answered by the backing store
+	top, err := db.TopSearches(ctx, now.Add(-time.Minute), 10)
+	require.NoError(t, err)
+	assert.Equal(t, []WordCount{{Word: "cat", Count: 2}, {Word: "dog", Count: 1}}, top)
+
+	// Deleting a user erases the backing store too
+	deleted, err := db.DeleteUserSearches(ctx, user)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []WordCount{{Word: "cat", Count: 2}, {Word: "dog", Count: 1}}, deleted)
+	searches, err = backing.GetUserSearches(ctx, user)
+	require.NoError(t, err)
+	assert.Empty(t, searches)
+}
+
+// flakyUserStore is a plain UserSearchStore, without batches, failing the inserts of failWord
+type flakyUserStore struct {
+	UserSearchStore
+	failWord string
+}
+
+func (s *flakyUserStore) InsertOrUpdateUserSearch(ctx context.Context, userIdentifier, word string, firstSearched, lastUpdated time.Time) (int64, error) {
+	if word == s.failWord {
+		return 0, errors.New("backing store down")
+	}
+	return s.UserSearchStore.InsertOrUpdateUserSearch(ctx, userIdentifier, word, firstSearched, lastUpdated)
+}
+
+func TestRedisV2BackingStoreRetry(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	mock := NewMockPostgresDBV2()
+	backing := &flakyUserStore{UserSearchStore: mock, failWord: "dog"}
+	db, err := NewRedisDBV2(RedisConfig{Addr: server.Addr(), KeyPrefix: "test:"}, backing)
+	require.NoError(t, err)
+	defer db.Close()
+
+	user := "redis_retry_user"
+	now := time.Now()
+	write := func(word string) UserSearchWrite {
+		return UserSearchWrite{UserIdentifier: user, Word: word, FirstSearchedAt: now, LastUpdatedAt: now, Count: 1}
+	}
+
+	// The backing store fails the second write, only the first reaches Redis
+	writes := []UserSearchWrite{write("cat"), write("dog")}
+	var partial *PartialWriteError
+	require.ErrorAs(t, db.ApplyUserSearchWrites(ctx, writes), &partial)
+	assert.Equal(t, 1, partial.Applied)
+	searches, err := db.GetUserSearches(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cat"}, searches)
+
+	// Retrying the writes not applied counts every search once
+	backing.failWord = ""
+	require.NoError(t, db.ApplyUserSearchWrites(ctx, writes[partial.Applied:]))
+
+	// Redis fails after the backing store took the write, which reaches Redis with the next batch
+	server.SetError("redis down")
+	require.ErrorAs(t, db.ApplyUserSearchWrites(ctx, []UserSearchWrite{write("eel")}), &partial)
+	assert.Equal(t, 1, partial.Applied)
+	server.SetError("")
+	require.NoError(t, db.ApplyUserSearchWrites(ctx, []UserSearchWrite{write("fox")}))
+
+	want := []WordCount{{Word: "cat", Count: 1}, {Word: "dog", Count: 1}, {Word: "eel", Count: 1}, {Word: "fox", Count: 1}}
+	top, err := mock.TopSearches(ctx, time.Time{}, 10)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, want, top)
+	for _, count := range want {
+		score, err := server.ZScore("test:user:"+user, count.Word)
+		require.NoError(t, err)
+		assert.Equal(t, float64(count.Count), score, count.Word)
+	}
+}
+
+func TestNewRedisDBV2_Unreachable(t *testing.T) {
+	_, err := NewRedisDBV2(RedisConfig{}, nil)
+	assert.Error(t, err)
+
+	server := miniredis.NewMiniRedis()
+	require.NoError(t, server.Start())
+	addr := server.Addr()
+	server.Close()
+
+	_, err = NewRedisDBV2(RedisConfig{Addr: addr, QueryTimeout: 100 * time.Millisecond}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,518 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisConfig holds the connection settings of a Redis server
+type RedisConfig struct {
+	// Addr is the host:port of the server, e.g. localhost:6379
+	Addr     string
+	Password string
+	DB       int
+	// KeyPrefix namespaces every key, defaults to "logsearch:"
+	KeyPrefix string
+	// QueryTimeout bounds every single command, defaults to 1 second
+	QueryTimeout time.Duration
+}
+
+// RedisDBV2 implements UserSearchStore on Redis. Every user has a sorted set of
+// their words scored by search count, and global sorted sets rank the words by
+// total search count and list them for prefix suggestions, so top-N and suggest
+// reads cost a single command.
+//
+// With a backing store, e.g. a PostgresDBV2, every write is mirrored to it so
+// the relational tables stay complete for analytics, while Redis serves the
+// reads in front of it. Batches reach the backing store first.
+type RedisDBV2 struct {
+	client       *redis.Client
+	prefix       string
+	queryTimeout time.Duration
+	backing      UserSearchStore
+
+	// unmirrored holds the batch writes that reached the backing store but
+	// not Redis, applied to Redis before the next batch
+	mirrorMutex sync.Mutex
+	unmirrored  []UserSearchWrite
+}
+
+// NewRedisDBV2 connects to Redis and verifies the connection with a ping,
+// backing may be nil to keep the searches in Redis only
+func NewRedisDBV2(cfg RedisConfig, backing UserSearchStore) (*RedisDBV2, error) {
+	if cfg.Addr == "" {
+		return nil, fmt.Errorf("redis address cannot be empty")
+	}
+
+	prefix := cfg.KeyPrefix
+	if prefix == "" {
+		prefix = "logsearch:"
+	}
+	queryTimeout := cfg.QueryTimeout
+	if queryTimeout <= 0 {
+		queryTimeout = time.Second
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: cfg.Addr, Password: cfg.Password, DB: cfg.DB})
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+
+	return &RedisDBV2{client: client, prefix: prefix, queryTimeout: queryTimeout, backing: backing}, nil
+}
+
+// queryContext bounds the caller's context by the configured query timeout
+func (db *RedisDBV2) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, db.queryTimeout)
+}
+
+// Keys of the data, all under the configured prefix
+func (db *RedisDBV2) userKey(userIdentifier string) string {
+	return db.prefix + "user:" + userIdentifier
+}
+
+func (db *RedisDBV2) userIDsKey(userIdentifier string) string {
+	return db.prefix + "user:" + userIdentifier + ":ids"
+}
+
+func (db *RedisDBV2) topKey() string {
+	return db.prefix + "top"
+}
+
+func (db *RedisDBV2) wordsKey() string {
+	return db.prefix + "words"
+}
+
+func (db *RedisDBV2) idsKey() string {
+	return db.prefix + "ids"
+}
+
+// upsertScript adds count searches of a word to a user, merging the records of
+// the replaced words into it, and keeps the global sets in sync. The top set is
+// scored by the negated count so ZRANGE returns ties in word order.
+//
+// KEYS: user set, user ids hash, top set, words set, id counter
+// ARGV: word, count, mode, replaced words...
+// In "extend" mode the first replaced word must exist and no search is added
+// when the word already had a record, like UpdateUserSearchByWord.
+var upsertScript = redis.NewScript(`
+local user, ids, top, words, counter = KEYS[1], KEYS[2], KEYS[3], KEYS[4], KEYS[5]
+local word, count, mode = ARGV[1], tonumber(ARGV[2]), ARGV[3]
+
+if mode == 'extend' then
+	if not redis.call('ZSCORE', user, ARGV[4]) then
+		return redis.error_reply('record not found')
+	end
+	if redis.call('ZSCORE', user, word) then
+		count = 0
+	end
+end
+
+local moved = 0
+for i = 4, #ARGV do
+	local old = ARGV[i]
+	local oldCount = redis.call('ZSCORE', user, old)
+	if old ~= word and oldCount then
+		oldCount = tonumber(oldCount)
+		moved = moved + oldCount
+		redis.call('ZREM', user, old)
+		local oldID = redis.call('HGET', ids, old)
+		redis.call('HDEL', ids, old)
+		if oldID and redis.call('HEXISTS', ids, word) == 0 then
+			redis.call('HSET', ids, word, oldID)
+		end
+		if tonumber(redis.call('ZINCRBY', top, oldCount, old)) >= 0 then
+			redis.call('ZREM', top, old)
+			redis.call('ZREM', words, old)
+		end
+	end
+end
+
+redis.call('ZINCRBY', user, count + moved, word)
+redis.call('ZINCRBY', top, -(count + moved), word)
+redis.call('ZADD', words, 0, word)
+
+local id = redis.call('HGET', ids, word)
+if not id then
+	id = redis.call('INCR', counter)
+	redis.call('HSET', ids, word, id)
+end
+return tonumber(id)
+`)
+
+// deleteUserScript removes a user's searches and their counts from the global
+// sets, returning the words and counts removed
+//
+// KEYS: user set, user ids hash, top set, words set
+var deleteUserScript = redis.NewScript(`
+local user, ids, top, words = KEYS[1], KEYS[2], KEYS[3], KEYS[4]
+local entries = redis.call('ZRANGE', user, 0, -1, 'WITHSCORES')
+for i = 1, #entries, 2 do
+	local word = entries[i]
+	if tonumber(redis.call('ZINCRBY', top, entries[i + 1], word)) >= 0 then
+		redis.call('ZREM', top, word)
+		redis.call('ZREM', words, word)
+	end
+end
+redis.call('DEL', user, ids)
+return entries
+`)
+
+// upsertKeys returns the keys and arguments of upsertScript for a user
+func (db *RedisDBV2) upsertKeys(userIdentifier, word string, count int, mode string, replaceWords []string) ([]string, []any) {
+	keys := []string{db.userKey(userIdentifier), db.userIDsKey(userIdentifier), db.topKey(), db.wordsKey(), db.idsKey()}
+	args := []any{word, count, mode}
+	for _, oldWord := range replaceWords {
+		args = append(args, oldWord)
+	}
+	return keys, args
+}
+
+// upsert runs upsertScript for a user
+func (db *RedisDBV2) upsert(ctx context.Context, userIdentifier, word string, count int, mode string, replaceWords []string) *redis.Cmd {
+	keys, args := db.upsertKeys(userIdentifier, word, count, mode, replaceWords)
+	return upsertScript.Run(ctx, db.client, keys, args...)
+}
+
+// CreateTable creates the table of the backing store, Redis needs no schema
+func (db *RedisDBV2) CreateTable(ctx context.Context) error {
+	if db.backing != nil {
+		return db.backing.CreateTable(ctx)
+	}
+	return nil
+}
+
+// InsertOrUpdateUserSearch adds a search of a user's word and returns the ID of its record in Redis
+func (db *RedisDBV2) InsertOrUpdateUserSearch(ctx context.Context, userIdentifier, word string, firstSearched, lastUpdated time.Time) (int64, error) {
+	redisCtx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	id, err := db.upsert(redisCtx, userIdentifier, word, 1, "insert", nil).Int64()
+	if err != nil {
+		return 0, err
+	}
+
+	if db.backing != nil {
+		if _, err := db.backing.InsertOrUpdateUserSearch(ctx, userIdentifier, word, firstSearched, lastUpdated); err != nil {
+			return 0, fmt.Errorf("backing store: %w", err)
+		}
+	}
+	return id, nil
+}
+
+// GetUserSearches returns all searches of a user in word order, from Redis
+func (db *RedisDBV2) GetUserSearches(ctx context.Context, userIdentifier string) ([]string, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	words, err := db.client.ZRange(ctx, db.userKey(userIdentifier), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	sort.Strings(words)
+	return words, nil
+}
+
+// UpdateUserSearchByWord updates a user's search record from old word to new word,
+// merging into an existing record of the new word the same way MockPostgresDBV2 does
+func (db *RedisDBV2) UpdateUserSearchByWord(ctx context.Context, userIdentifier, oldWord, newWord string, lastUpdated time.Time) error {
+	redisCtx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	err := db.upsert(redisCtx, userIdentifier, newWord, 1, "extend", []string{oldWord}).Err()
+	if err != nil && strings.Contains(err.Error(), "record not found") {
+		return fmt.Errorf("%w: no record for user %s with word %s", ErrUserNotFound, userIdentifier, oldWord)
+	}
+	if err != nil {
+		return err
+	}
+
+	if db.backing != nil {
+		if err := db.backing.UpdateUserSearchByWord(ctx, userIdentifier, oldWord, newWord, lastUpdated); err != nil {
+			return fmt.Errorf("backing store: %w", err)
+		}
+	}
+	return nil
+}
+
+// ApplyUserSearchWrites writes the batch to the backing store first, then
+// applies the writes it took to Redis atomically in one MULTI/EXEC round trip.
+// The writes that reached the backing store but not Redis are kept and applied
+// to Redis before the next batch, and reported applied with a
+// *PartialWriteError, so a retry never applies a write twice.
+func (db *RedisDBV2) ApplyUserSearchWrites(ctx context.Context, writes []UserSearchWrite) error {
+	if len(writes) == 0 {
+		return nil
+	}
+
+	applied, err := writes, error(nil)
+	if db.backing != nil {
+		n, backingErr := applyWritesTo(ctx, db.backing, writes)
+		if backingErr != nil {
+			applied, err = writes[:n], fmt.Errorf("backing store: %w", backingErr)
+		}
+	}
+
+	db.mirrorMutex.Lock()
+	defer db.mirrorMutex.Unlock()
+	mirror := append(db.unmirrored, applied...)
+	if redisErr := db.applyToRedis(ctx, mirror); redisErr != nil {
+		if db.backing == nil {
+			return redisErr
+		}
+		db.unmirrored = mirror
+		err = errors.Join(err, redisErr)
+	} else {
+		db.unmirrored = nil
+	}
+
+	switch {
+	case err == nil:
+		return nil
+	case len(applied) == 0:
+		return err
+	}
+	return &PartialWriteError{Applied: len(applied), Err: err}
+}
+
+// applyToRedis applies writes to Redis atomically in one MULTI/EXEC round trip
+func (db *RedisDBV2) applyToRedis(ctx context.Context, writes []UserSearchWrite) error {
+	if len(writes) == 0 {
+		return nil
+	}
+
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	// Scripts must be loaded before they can run inside MULTI/EXEC
+	if err := upsertScript.Load(ctx, db.client).Err(); err != nil {
+		return err
+	}
+	_, err := db.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, write := range writes {
+			keys, args := db.upsertKeys(write.UserIdentifier, write.Word, write.Count, "insert", write.ReplaceWords)
+			upsertScript.EvalSha(ctx, pipe, keys, args...)
+		}
+		return nil
+	})
+	return err
+}
+
+// applyWritesTo applies writes as a batch when s supports it, or one by one
+// otherwise, and returns how many of the first writes were applied
+func applyWritesTo(ctx context.Context, s UserSearchStore, writes []UserSearchWrite) (int, error) {
+	if batchStore, ok := s.(BatchUserSearchStore); ok {
+		err := batchStore.ApplyUserSearchWrites(ctx, writes)
+		var partial *PartialWriteError
+		switch {
+		case err == nil:
+			return len(writes), nil
+		case errors.As(err, &partial):
+			return partial.Applied, err
+		}
+		return 0, err
+	}
+
+	for i, write := range writes {
+		if len(write.ReplaceWords) == 0 {
+			if _, err := s.InsertOrUpdateUserSearch(ctx, write.UserIdentifier, write.Word, write.FirstSearchedAt, write.LastUpdatedAt); err != nil {
+				return i, err
+			}
+			continue
+		}
+		for _, oldWord := range write.ReplaceWords {
+			if err := s.UpdateUserSearchByWord(ctx, write.UserIdentifier, oldWord, write.Word, write.LastUpdatedAt); err != nil {
+				return i, err
+			}
+		}
+	}
+	return len(writes), nil
+}
+
+// DeleteUserSearches removes every search of the user from Redis and the backing
+// store, returning the records Redis held
+func (db *RedisDBV2) DeleteUserSearches(ctx context.Context, userIdentifier string) ([]WordCount, error) {
+	deleteStore, ok := db.backing.(UserDeleteStore)
+	if db.backing != nil && !ok {
+		return nil, errors.New("backing store does not support deleting users")
+	}
+
+	redisCtx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	keys := []string{db.userKey(userIdentifier), db.userIDsKey(userIdentifier), db.topKey(), db.wordsKey()}
+	entries, err := deleteUserScript.Run(redisCtx, db.client, keys).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+	deleted := make([]WordCount, 0, len(entries)/2)
+	for i := 0; i+1 < len(entries); i += 2 {
+		count, err := strconv.ParseFloat(entries[i+1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("count of %q: %w", entries[i], err)
+		}
+		deleted = append(deleted, WordCount{Word: entries[i], Count: int(count)})
+	}
+
+	if deleteStore != nil {
+		if _, err := deleteStore.DeleteUserSearches(ctx, userIdentifier); err != nil {
+			return nil, fmt.Errorf("backing store: %w", err)
+		}
+	}
+	return deleted, nil
+}
+
+// ForEachUserSearch reads the full records from the backing store, Redis only
+// keeps the words and their counts
+func (db *RedisDBV2) ForEachUserSearch(ctx context.Context, userIdentifier string, fn func(UserSearchRecord) error) error {
+	exportStore, ok := db.backing.(UserExportStore)
+	if !ok {
+		return errors.New("redis store only exports users with a backing store that supports it")
+	}
+	return exportStore.ForEachUserSearch(ctx, userIdentifier, fn)
+}
+
+// SetSurfaceWord sets the surface form in the backing store, Redis only keeps
+// the words so it is dropped without one that supports it
+func (db *RedisDBV2) SetSurfaceWord(ctx context.Context, userIdentifier, word, surface string) error {
+	if surfaceStore, ok := db.backing.(UserSurfaceStore); ok {
+		return surfaceStore.SetSurfaceWord(ctx, userIdentifier, word, surface)
+	}
+	return nil
+}
+
+// TopSearches returns the most searched words over all users from the global
+// sorted set. Redis only ranks all time, a non zero since is answered by the
+// backing store.
+func (db *RedisDBV2) TopSearches(ctx context.Context, since time.Time, limit int) ([]WordCount, error) {
+	if !since.IsZero() {
+		if topStore, ok := db.backing.(TopSearchStore); ok {
+			return topStore.TopSearches(ctx, since, limit)
+		}
+		return nil, errors.New("redis store only ranks all time searches without a backing store")
+	}
+	if limit == 0 {
+		return []WordCount{}, nil
+	}
+
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	entries, err := db.client.ZRangeWithScores(ctx, db.topKey(), 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	top := make([]WordCount, 0, len(entries))
+	for _, entry := range entries {
+		top = append(top, WordCount{Word: entry.Member.(string), Count: int(-entry.Score)})
+	}
+	return top, nil
+}
+
+// SearchesBetween is answered by the backing store, Redis keeps no timestamps
+func (db *RedisDBV2) SearchesBetween(ctx context.Context, from, to time.Time) ([]WordCount, error) {
+	analyticsStore, ok := db.backing.(AnalyticsStore)
+	if !ok {
+		return nil, errors.New("redis store only aggregates searches over time with a backing store")
+	}
+	return analyticsStore.SearchesBetween(ctx, from, to)
+}
+
+// SearchHistogram is answered by the backing store, Redis keeps no timestamps
+func (db *RedisDBV2) SearchHistogram(ctx context.Context, from, to time.Time, granularity Granularity) ([]SearchBucket, error) {
+	analyticsStore, ok := db.backing.(AnalyticsStore)
+	if !ok {
+		return nil, errors.New("redis store only aggregates searches over time with a backing store")
+	}
+	return analyticsStore.SearchHistogram(ctx, from, to, granularity)
+}
+
+// SearchesByHour is answered by the backing store, Redis keeps no timestamps
+func (db *RedisDBV2) SearchesByHour(ctx context.Context, from, to time.Time, word string) ([]SearchRollup, error) {
+	hourlyStore, ok := db.backing.(HourlySearchStore)
+	if !ok {
+		return nil, errors.New("redis store only aggregates searches over time with a backing store")
+	}
+	return hourlyStore.SearchesByHour(ctx, from, to, word)
+}
+
+// RollupSearches is answered by the backing store, which keeps the rollup tables
+func (db *RedisDBV2) RollupSearches(ctx context.Context, from, to time.Time, granularity Granularity) (int64, error) {
+	rollupStore, ok := db.backing.(RollupStore)
+	if !ok {
+		return 0, errors.New("redis store only rolls searches up with a backing store")
+	}
+	return rollupStore.RollupSearches(ctx, from, to, granularity)
+}
+
+// RolledUpUntil is answered by the backing store
+func (db *RedisDBV2) RolledUpUntil(ctx context.Context, granularity Granularity) (time.Time, error) {
+	rollupStore, ok := db.backing.(RollupStore)
+	if !ok {
+		return time.Time{}, errors.New("redis store only rolls searches up with a backing store")
+	}
+	return rollupStore.RolledUpUntil(ctx, granularity)
+}
+
+// GetRollups is answered by the backing store
+func (db *RedisDBV2) GetRollups(ctx context.Context, from, to time.Time, granularity Granularity) ([]SearchRollup, error) {
+	rollupStore, ok := db.backing.(RollupStore)
+	if !ok {
+		return nil, errors.New("redis store only rolls searches up with a backing store")
+	}
+	return rollupStore.GetRollups(ctx, from, to, granularity)
+}
+
+// Suggest returns up to limit searched words starting with prefix, in alphabetical order
+func (db *RedisDBV2) Suggest(ctx context.Context, prefix string, limit int) ([]string, error) {
+	if limit <= 0 {
+		return []string{}, nil
+	}
+
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	// "\xff" sorts after every UTF-8 continuation, closing the range of words starting with prefix
+	return db.client.ZRangeByLex(ctx, db.wordsKey(), &redis.ZRangeBy{
+		Min:   "[" + prefix,
+		Max:   "[" + prefix + "\xff",
+		Count: int64(limit),
+	}).Result()
+}
+
+// Ping checks the connections to Redis and to the backing store if any
+func (db *RedisDBV2) Ping(ctx context.Context) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	err := db.client.Ping(ctx).Err()
+	if pinger, ok := db.backing.(Pinger); ok {
+		err = errors.Join(err, pinger.Ping(ctx))
+	}
+	return err
+}
+
+// Close closes the Redis client and the backing store
+func (db *RedisDBV2) Close() error {
+	err := db.client.Close()
+	if db.backing != nil {
+		err = errors.Join(err, db.backing.Close())
+	}
+	return err
+}
@@ -0,0 +1,48 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Region is where a search was made, both fields are optional
+type Region struct {
+	// Country is an ISO 3166-1 alpha-2 code, e.g. "FR"
+	Country string
+	// Locale is a BCP 47 language tag, e.g. "fr-FR"
+	Locale string
+}
+
+// IsZero reports whether neither the country nor the locale is known
+func (r Region) IsZero() bool {
+	return r.Country == "" && r.Locale == ""
+}
+
+// RegionQuery selects the records ranked by TopSearchesByRegion, an empty
+// Country or Locale matches any, a zero Since covers all time
+type RegionQuery struct {
+	Country string
+	Locale  string
+	Since   time.Time
+	Limit   int
+}
+
+// matches reports whether a record of region last updated at passes the query
+func (q RegionQuery) matches(region Region, at time.Time) bool {
+	return (q.Country == "" || region.Country == q.Country) &&
+		(q.Locale == "" || region.Locale == q.Locale) &&
+		!at.Before(q.Since)
+}
+
+// UserRegionStore is a UserSearchStore keeping next to every record the
+// country and locale of its last search, in the country and locale columns
+// of user_searches, and ranking the words by region
+type UserRegionStore interface {
+	UserSearchStore
+	// SetUserSearchRegion sets the region of the user's record of word, a missing record is left alone
+	SetUserSearchRegion(ctx context.Context, userIdentifier, word string, region Region) error
+	// TopSearchesByRegion returns the limit most searched words over the
+	// records of the region of query, with their search count over those
+	// records. Ties rank in word order.
+	TopSearchesByRegion(ctx context.Context, query RegionQuery) ([]WordCount, error)
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// replicaLagQuery returns how far a standby replays behind its primary, zero
+// when it replayed everything it received so an idle primary is not mistaken
+// for lag
+const replicaLagQuery = `SELECT CASE
+	WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+	END`
+
+// replicaCheckInterval is how often the lag of the replicas is measured
+const replicaCheckInterval = time.Second
+
+// replica is a read replica and its last measured state
+type replica struct {
+	db *sql.DB
+	// lag is the replay lag in nanoseconds measured last
+	lag atomic.Int64
+	// healthy is false while the last measure failed
+	healthy atomic.Bool
+}
+
+// replicaSet spreads the read queries over the read replicas of a primary in
+// turn, skipping the replicas that are down or lag more than maxLag
+type replicaSet struct {
+	replicas []*replica
+	maxLag   time.Duration
+	next     atomic.Uint64
+	stop     chan struct{}
+	done     sync.WaitGroup
+}
+
+// openReplicas opens and pings a pool per DSN of cfg.ReplicaDSNs with the pool
+// settings of cfg, and starts measuring their lag. It returns nil without replicas.
+func openReplicas(cfg PostgresConfig, queryTimeout time.Duration) (*replicaSet, error) {
+	if len(cfg.ReplicaDSNs) == 0 {
+		return nil, nil
+	}
+
+	set := &replicaSet{maxLag: cfg.MaxReplicaLag, stop: make(chan struct{})}
+	for i, dsn := range cfg.ReplicaDSNs {
+		replicaCfg := cfg
+		replicaCfg.DSN, replicaCfg.QueryTimeout = dsn, queryTimeout
+		db, _, err := openPostgres(replicaCfg)
+		if err != nil {
+			set.closeReplicas()
+			return nil, fmt.Errorf("replica %d: %w", i, err)
+		}
+		set.replicas = append(set.replicas, &replica{db: db})
+	}
+
+	set.measure(queryTimeout)
+	set.done.Add(1)
+	go set.watch(queryTimeout)
+	return set, nil
+}
+
+// reader returns the database to run a read query on: the next usable
+// replica, or primary when none is. It is safe on a nil set.
+func (s *replicaSet) reader(primary *sql.DB) *sql.DB {
+	if s == nil {
+		return primary
+	}
+	start := s.next.Add(1)
+	for i := range s.replicas {
+		r := s.replicas[(start+uint64(i))%uint64(len(s.replicas))]
+		if s.usable(r) {
+			return r.db
+		}
+	}
+	return primary
+}
+
+// usable reports whether r answered its last measure within the staleness tolerance
+func (s *replicaSet) usable(r *replica) bool {
+	if !r.healthy.Load() {
+		return false
+	}
+	return s.maxLag <= 0 || time.Duration(r.lag.Load()) <= s.maxLag
+}
+
+// watch measures the replicas every replicaCheckInterval until close
+func (s *replicaSet) watch(queryTimeout time.Duration) {
+	defer s.done.Done()
+	ticker := time.NewTicker(replicaCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.measure(queryTimeout)
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// measure refreshes the lag and health of every replica
+func (s *replicaSet) measure(queryTimeout time.Duration) {
+	for i, r := range s.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+		var seconds float64
+		err := r.db.QueryRowContext(ctx, replicaLagQuery).Scan(&seconds)
+		cancel()
+		if err != nil {
+			if r.healthy.Swap(false) {
+				log.Printf("Replica %d is down, reading from the primary: %v", i, err)
+			}
+			continue
+		}
+		r.lag.Store(int64(seconds * float64(time.Second)))
+		r.healthy.Store(true)
+	}
+}
+
+// close stops measuring the replicas and closes them, it is safe on a nil set
+func (s *replicaSet) close() error {
+	if s == nil {
+		return nil
+	}
+	close(s.stop)
+	s.done.Wait()
+	return s.closeReplicas()
+}
+
+// closeReplicas closes the pools of the replicas
+func (s *replicaSet) closeReplicas() error {
+	var errs []error
+	for _, r := range s.replicas {
+		errs = append(errs, r.db.Close())
+	}
+	return errors.Join(errs...)
+}
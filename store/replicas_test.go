@@ -0,0 +1,56 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicaSetReader(t *testing.T) {
+	open := func() *sql.DB {
+		// sql.Open does not connect, the pools only tell the databases apart
+		db, err := sql.Open("pgx", "postgres://127.0.0.1:1/logsearch")
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		return db
+	}
+	primary := open()
+	var nilSet *replicaSet
+	assert.Same(t, primary, nilSet.reader(primary), "without replicas the reads go to the primary")
+	assert.NoError(t, nilSet.close())
+
+	set := &replicaSet{maxLag: time.Second}
+	for i := 0; i < 3; i++ {
+		r := &replica{db: open()}
+		r.healthy.Store(true)
+		set.replicas = append(set.replicas, r)
+	}
+
+	// The reads take the replicas in turn
+	seen := make(map[*sql.DB]int)
+	for i := 0; i < 6; i++ {
+		seen[set.reader(primary)]++
+	}
+	assert.Len(t, seen, 3)
+	for _, r := range set.replicas {
+		assert.Equal(t, 2, seen[r.db])
+	}
+
+	// The lagging and failed replicas are skipped
+	set.replicas[0].lag.Store(int64(2 * time.Second))
+	set.replicas[1].healthy.Store(false)
+	for i := 0; i < 3; i++ {
+		assert.Same(t, set.replicas[2].db, set.reader(primary))
+	}
+
+	// The reads fall back to the primary when no replica is usable
+	set.replicas[2].lag.Store(int64(time.Minute))
+	assert.Same(t, primary, set.reader(primary))
+
+	// Without a staleness tolerance any lag is accepted
+	set.maxLag = 0
+	assert.NotSame(t, primary, set.reader(primary))
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// SearchRollup is a row of a rollup table: the searches of a word in an hour or a day
+type SearchRollup struct {
+	Word   string
+	Bucket time.Time
+	// TotalCount is the number of searches of the word in the bucket, over all users
+	TotalCount int
+	// UniqueUsers is the number of users who searched the word in the bucket
+	UniqueUsers int
+}
+
+// RollupStore is a UserSearchStore that summarizes its records into the
+// search_rollups_hourly and search_rollups_daily tables, so the summaries
+// outlive the records purged from the hot user_searches table. Like
+// AnalyticsStore, a record counts at its last_updated_at.
+type RollupStore interface {
+	UserSearchStore
+	// RollupSearches summarizes the records last updated in [from, to) into
+	// the rollup table of the granularity and returns the number of rows
+	// written. from and to are bucket boundaries, the rows of their buckets
+	// are replaced so a rollup can be run again.
+	RollupSearches(ctx context.Context, from, to time.Time, granularity Granularity) (int64, error)
+	// RolledUpUntil returns the end of the latest range rolled up with the granularity, zero if none
+	RolledUpUntil(ctx context.Context, granularity Granularity) (time.Time, error)
+	// GetRollups returns the rows of the buckets in [from, to), in bucket
+	// order then most searched first and ties in word order
+	GetRollups(ctx context.Context, from, to time.Time, granularity Granularity) ([]SearchRollup, error)
+}
+
+// table is the rollup table of the granularity
+func (g Granularity) table() string {
+	if g == Daily {
+		return "search_rollups_daily"
+	}
+	return "search_rollups_hourly"
+}
+
+// rollupRecords summarizes the records by bucket and word for the stores that
+// cannot group them in a query. A user has one record per word, so every
+// record is one more unique user.
+func rollupRecords(records []UserSearchRecord, granularity Granularity) []SearchRollup {
+	type key struct {
+		bucket time.Time
+		word   string
+	}
+	rows := make(map[key]*SearchRollup)
+	for _, record := range records {
+		k := key{bucket: granularity.Truncate(record.LastUpdatedAt), word: record.SearchWord}
+		row := rows[k]
+		if row == nil {
+			row = &SearchRollup{Word: k.word, Bucket: k.bucket}
+			rows[k] = row
+		}
+		row.TotalCount += record.SearchCount
+		row.UniqueUsers++
+	}
+
+	rollups := make([]SearchRollup, 0, len(rows))
+	for _, row := range rows {
+		rollups = append(rollups, *row)
+	}
+	sortRollups(rollups)
+	return rollups
+}
+
+// sortRollups orders rollup rows like GetRollups returns them
+func sortRollups(rollups []SearchRollup) {
+	sort.Slice(rollups, func(i, j int) bool {
+		a, b := rollups[i], rollups[j]
+		switch {
+		case !a.Bucket.Equal(b.Bucket):
+			return a.Bucket.Before(b.Bucket)
+		case a.TotalCount != b.TotalCount:
+			return a.TotalCount > b.TotalCount
+		}
+		return a.Word < b.Word
+	})
+}
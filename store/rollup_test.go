@@ -0,0 +1,102 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollupSearches(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		open func(t *testing.T) RollupStore
+	}{
+		{"mock", func(t *testing.T) RollupStore {
+			return NewMockPostgresDBV2()
+		}},
+		{"sqlite", func(t *testing.T) RollupStore {
+			db, err := NewSQLiteDBV2(sqliteConfig(t))
+			require.NoError(t, err)
+			t.Cleanup(func() { db.Close() })
+			return db
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := tt.open(t)
+			require.NoError(t, db.CreateTable(ctx))
+
+			until, err := db.RolledUpUntil(ctx, Hourly)
+			require.NoError(t, err)
+			assert.True(t, until.IsZero())
+
+			day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+			for _, search := range []struct {
+				user string
+				word string
+				at   time.Time
+			}{
+				{"user_1", "cat", day.Add(9*time.Hour + 10*time.Minute)},
+				{"user_1", "cat", day.Add(9*time.Hour + 20*time.Minute)},
+				{"user_2", "cat", day.Add(9*time.Hour + 30*time.Minute)},
+				{"user_2", "dog", day.Add(9*time.Hour + 40*time.Minute)},
+				{"user_1", "bus", day.Add(11 * time.Hour)},
+				{"user_3", "bus", day.Add(26 * time.Hour)},
+			} {
+				_, err := db.InsertOrUpdateUserSearch(ctx, search.user, search.word, search.at, search.at)
+				require.NoError(t, err)
+			}
+
+			written, err := db.RollupSearches(ctx, time.Time{}, day.Add(24*time.Hour), Hourly)
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), written)
+			written, err = db.RollupSearches(ctx, day, day.Add(48*time.Hour), Daily)
+			require.NoError(t, err)
+			assert.Equal(t, int64(4), written)
+
+			until, err = db.RolledUpUntil(ctx, Hourly)
+			require.NoError(t, err)
+			assert.Equal(t, day.Add(24*time.Hour), until)
+
+			// The rollups outlive the purged records
+			require.NoError(t, purge(ctx, db, day.Add(48*time.Hour)))
+
+			hourly, err := db.GetRollups(ctx, day, day.Add(48*time.Hour), Hourly)
+			require.NoError(t, err)
+			assert.Equal(t, []SearchRollup{
+				{Word: "cat", Bucket: day.Add(9 * time.Hour), TotalCount: 3, UniqueUsers: 2},
+				{Word: "dog", Bucket: day.Add(9 * time.Hour), TotalCount: 1, UniqueUsers: 1},
+				{Word: "bus", Bucket: day.Add(11 * time.Hour), TotalCount: 1, UniqueUsers: 1},
+			}, hourly)
+
+			daily, err := db.GetRollups(ctx, day, day.Add(48*time.Hour), Daily)
+			require.NoError(t, err)
+			assert.Equal(t, []SearchRollup{
+				{Word: "cat", Bucket: day, TotalCount: 3, UniqueUsers: 2},
+				{Word: "bus", Bucket: day, TotalCount: 1, UniqueUsers: 1},
+				{Word: "dog", Bucket: day, TotalCount: 1, UniqueUsers: 1},
+				{Word: "bus", Bucket: day.Add(24 * time.Hour), TotalCount: 1, UniqueUsers: 1},
+			}, daily)
+
+			// Rolling up again replaces the rows of the range and keeps the watermark
+			written, err = db.RollupSearches(ctx, day.Add(9*time.Hour), day.Add(10*time.Hour), Hourly)
+			require.NoError(t, err)
+			assert.Zero(t, written)
+			hourly, err = db.GetRollups(ctx, day, day.Add(48*time.Hour), Hourly)
+			require.NoError(t, err)
+			assert.Len(t, hourly, 1)
+			until, err = db.RolledUpUntil(ctx, Hourly)
+			require.NoError(t, err)
+			assert.Equal(t, day.Add(24*time.Hour), until)
+		})
+	}
+}
+
+// purge deletes the records last updated before cutoff
+func purge(ctx context.Context, db RollupStore, cutoff time.Time) error {
+	_, err := db.(UserSearchPurgeStore).PurgeUserSearches(ctx, cutoff)
+	return err
+}
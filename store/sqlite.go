@@ -0,0 +1,610 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	// Register the pure Go SQLite driver with database/sql under the name "sqlite"
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteConfig holds the settings of an embedded SQLite database
+type SQLiteConfig struct {
+	// Path is the database file, created if missing, or ":memory:" for a database living as long as the store
+	Path string
+	// QueryTimeout bounds every single query, defaults to 5 seconds
+	QueryTimeout time.Duration
+}
+
+// SQLiteDB implements the same operations as PostgresDB on an embedded SQLite
+// database, for single-node deployments and tests without a PostgreSQL server
+type SQLiteDB struct {
+	db           *sql.DB
+	queryTimeout time.Duration
+}
+
+// NewSQLiteDB opens the SQLite database file
+func NewSQLiteDB(cfg SQLiteConfig) (*SQLiteDB, error) {
+	db, queryTimeout, err := openSQLite(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SQLiteDB{db: db, queryTimeout: queryTimeout}, nil
+}
+
+// openSQLite opens and pings the database file configured by cfg
+func openSQLite(cfg SQLiteConfig) (*sql.DB, time.Duration, error) {
+	if cfg.Path == "" {
+		return nil, 0, fmt.Errorf("sqlite path cannot be empty")
+	}
+
+	// Timestamps are written as sortable text so they can be compared in SQL
+	dsn := "file:" + cfg.Path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_time_format=sqlite"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+
+	// SQLite serializes writers anyway, a single connection also keeps
+	// ":memory:" databases from being one database per connection
+	db.SetMaxOpenConns(1)
+
+	queryTimeout := cfg.QueryTimeout
+	if queryTimeout <= 0 {
+		queryTimeout = 5 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, 0, fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+
+	return db, queryTimeout, nil
+}
+
+// sqliteMigration is a schema change applied once per database file
+type sqliteMigration struct {
+	name string
+	sql  string
+}
+
+// searchesMigrations evolve the searches table, append new ones and never edit applied ones
+var searchesMigrations = []sqliteMigration{
+	{
+		name: "searches/001_create",
+		sql: `CREATE TABLE IF NOT EXISTS searches (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			word TEXT UNIQUE NOT NULL,
+			first_searched_at TIMESTAMP NOT NULL,
+			last_updated_at TIMESTAMP NOT NULL,
+			search_count INTEGER NOT NULL DEFAULT 1
+		)`,
+	},
+	{
+		name: "searches/002_verified",
+		sql:  `ALTER TABLE searches ADD COLUMN verified BOOLEAN NOT NULL DEFAULT FALSE`,
+	},
+	{
+		name: "searches/003_display_word",
+		sql:  `ALTER TABLE searches ADD COLUMN display_word TEXT`,
+	},
+	{
+		name: "searches/004_metadata",
+		sql:  `ALTER TABLE searches ADD COLUMN metadata TEXT`,
+	},
+	{
+		name: "searches/005_categories",
+		sql: `CREATE TABLE IF NOT EXISTS search_categories (
+			search_id INTEGER NOT NULL REFERENCES searches (id) ON DELETE CASCADE,
+			category TEXT NOT NULL,
+			PRIMARY KEY (search_id, category)
+		)`,
+	},
+}
+
+// migrateSQLite applies the migrations missing from the schema_migrations table, each in its own transaction
+func migrateSQLite(ctx context.Context, db *sql.DB, migrations []sqliteMigration) error {
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		name TEXT PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`); err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		var applied int
+		err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migrations WHERE name = ?`, migration.name).Scan(&applied)
+		if err == nil && applied == 0 {
+			if _, err = tx.ExecContext(ctx, migration.sql); err == nil {
+				_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (name, applied_at) VALUES (?, ?)`,
+					migration.name, time.Now().UTC())
+			}
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s: %w", migration.name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// queryContext bounds the caller's context by the configured query timeout
+func (db *SQLiteDB) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, db.queryTimeout)
+}
+
+// CreateTable creates or migrates the searches table
+func (db *SQLiteDB) CreateTable(ctx context.Context) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	return migrateSQLite(ctx, db.db, searchesMigrations)
+}
+
+// InsertOrReplace inserts a word or bumps its count with INSERT ... ON CONFLICT UPDATE
+func (db *SQLiteDB) InsertOrReplace(ctx context.Context, word string, firstSearched, lastUpdated time.Time) (int64, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	return insertOrReplaceSQLite(ctx, db.db, word, firstSearched, lastUpdated)
+}
+
+// sqliteExecer is a *sql.DB or a *sql.Tx
+type sqliteExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func insertOrReplaceSQLite(ctx context.Context, db sqliteExecer, word string, firstSearched, lastUpdated time.Time) (int64, error) {
+	var id int64
+	err := db.QueryRowContext(ctx, `INSERT INTO searches (word, first_searched_at, last_updated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT (word) DO UPDATE
+		SET last_updated_at = excluded.last_updated_at, search_count = searches.search_count + 1
+		RETURNING id`,
+		word, firstSearched.UTC(), lastUpdated.UTC()).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// Update replaces the word of an existing record
+func (db *SQLiteDB) Update(ctx context.Context, id int64, newWord string, lastUpdated time.Time) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	return updateSQLite(ctx, db.db, WordUpdate{ID: id, Word: newWord, LastUpdatedAt: lastUpdated, Count: 1})
+}
+
+func updateSQLite(ctx context.Context, db sqliteExecer, update WordUpdate) error {
+	result, err := db.ExecContext(ctx, `UPDATE searches
+		SET word = ?, last_updated_at = ?, search_count = search_count + ?
+		WHERE id = ?`,
+		update.Word, update.LastUpdatedAt.UTC(), update.Count, update.ID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("record with id %d not found", update.ID)
+	}
+
+	return nil
+}
+
+// InsertOrReplaceBatch inserts or bumps every word in a single transaction
+func (db *SQLiteDB) InsertOrReplaceBatch(ctx context.Context, words []string, firstSearched, lastUpdated time.Time) ([]int64, error) {
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ids := make([]int64, len(words))
+	for i, word := range words {
+		if ids[i], err = insertOrReplaceSQLite(ctx, tx, word, firstSearched, lastUpdated); err != nil {
+			return nil, err
+		}
+	}
+
+	return ids, tx.Commit()
+}
+
+// UpdateBatch applies every update in a single transaction
+func (db *SQLiteDB) UpdateBatch(ctx context.Context, updates []WordUpdate) error {
+	if len(updates) == 0 {
+		return nil
+	}
+
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, update := range updates {
+		if err := updateSQLite(ctx, tx, update); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetAllSearchedWords runs SELECT word FROM searches ORDER BY word
+func (db *SQLiteDB) GetAllSearchedWords(ctx context.Context) ([]string, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `SELECT word FROM searches ORDER BY word`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	words := make([]string, 0)
+	for rows.Next() {
+		var word string
+		if err := rows.Scan(&word); err != nil {
+			return nil, err
+		}
+		words = append(words, word)
+	}
+
+	return words, rows.Err()
+}
+
+// GetAllRecords returns all stored search records
+func (db *SQLiteDB) GetAllRecords(ctx context.Context) ([]SearchRecord, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `SELECT id, word, first_searched_at, last_updated_at, search_count, verified
+		FROM searches ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]SearchRecord, 0)
+	for rows.Next() {
+		var record SearchRecord
+		if err := rows.Scan(&record.ID, &record.Word, &record.FirstSearchedAt, &record.LastUpdatedAt, &record.SearchCount, &record.Verified); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// CountSearches returns the number of records and the sum of their search counts
+func (db *SQLiteDB) CountSearches(ctx context.Context) (records, searches int64, err error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	err = db.db.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(SUM(search_count), 0) FROM searches`).Scan(&records, &searches)
+	return records, searches, err
+}
+
+// PurgeSearches deletes the records last updated before cutoff and returns their words
+func (db *SQLiteDB) PurgeSearches(ctx context.Context, cutoff time.Time) ([]string, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `DELETE FROM searches WHERE last_updated_at < ? RETURNING word`, cutoff.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var words []string
+	for rows.Next() {
+		var word string
+		if err := rows.Scan(&word); err != nil {
+			return nil, err
+		}
+		words = append(words, word)
+	}
+
+	return words, rows.Err()
+}
+
+// TopSearches returns the most searched words last updated at or after since
+func (db *SQLiteDB) TopSearches(ctx context.Context, since time.Time, limit int) ([]WordCount, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `SELECT word, search_count FROM searches
+		WHERE last_updated_at >= ?
+		ORDER BY search_count DESC, word LIMIT ?`, since.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWordCounts(rows)
+}
+
+// QuerySearches returns the records matching the query
+func (db *SQLiteDB) QuerySearches(ctx context.Context, query SearchQuery) ([]SearchRecord, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	sql, args := query.sql(func(int) string { return "?" }, func(t time.Time) any { return t.UTC() })
+	return scanSearchRecords(db.db.QueryContext(ctx, sql, args...))
+}
+
+// ForEachSearch streams the records matching the query. It holds the query
+// open while fn runs, so the query timeout does not apply, only ctx.
+func (db *SQLiteDB) ForEachSearch(ctx context.Context, query SearchQuery, fn func(SearchRecord) error) error {
+	sql, args := query.sql(func(int) string { return "?" }, func(t time.Time) any { return t.UTC() })
+	rows, err := db.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	return forEachSearchRecord(rows, fn)
+}
+
+// SetVerified marks or unmarks the record of word as verified
+func (db *SQLiteDB) SetVerified(ctx context.Context, word string, verified bool) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	result, err := db.db.ExecContext(ctx, `UPDATE searches SET verified = ? WHERE word = ?`, verified, word)
+	if err != nil {
+		return err
+	}
+	return wordAffected(result, word)
+}
+
+// SetDisplayWord sets the display form of the record of word
+func (db *SQLiteDB) SetDisplayWord(ctx context.Context, word, display string) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	result, err := db.db.ExecContext(ctx, `UPDATE searches SET display_word = ? WHERE word = ?`, display, word)
+	if err != nil {
+		return err
+	}
+	return wordAffected(result, word)
+}
+
+// GetDisplayWords runs SELECT word, display_word FROM searches WHERE display_word IS NOT NULL
+func (db *SQLiteDB) GetDisplayWords(ctx context.Context) (map[string]string, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `SELECT word, display_word FROM searches WHERE display_word IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanDisplayWords(rows)
+}
+
+// SetMetadata sets the payload of the record of word, NULL when metadata is empty
+func (db *SQLiteDB) SetMetadata(ctx context.Context, word string, metadata json.RawMessage) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	result, err := db.db.ExecContext(ctx, `UPDATE searches SET metadata = ? WHERE word = ?`, nullableJSON(metadata), word)
+	if err != nil {
+		return err
+	}
+	return wordAffected(result, word)
+}
+
+// GetMetadata runs SELECT word, metadata FROM searches WHERE metadata IS NOT NULL
+func (db *SQLiteDB) GetMetadata(ctx context.Context) (map[string]json.RawMessage, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `SELECT word, metadata FROM searches WHERE metadata IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanMetadata(rows)
+}
+
+// TagCategories adds categories to the tags of the record of word in one transaction
+func (db *SQLiteDB) TagCategories(ctx context.Context, word string, categories []string) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM searches WHERE word = ?`, word).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %q", ErrWordNotFound, word)
+	}
+	if err != nil {
+		return err
+	}
+	for _, category := range categories {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO search_categories (search_id, category) VALUES (?, ?)
+			ON CONFLICT DO NOTHING`, id, category); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetCategories runs SELECT word, category FROM searches JOIN search_categories
+func (db *SQLiteDB) GetCategories(ctx context.Context) (map[string][]string, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `SELECT s.word, c.category
+		FROM searches s JOIN search_categories c ON c.search_id = s.id
+		ORDER BY s.word, c.category`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanCategories(rows)
+}
+
+// TopSearchesInCategory ranks the words tagged with category by search count
+func (db *SQLiteDB) TopSearchesInCategory(ctx context.Context, category string, since time.Time, limit int) ([]WordCount, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `SELECT s.word, s.search_count
+		FROM searches s JOIN search_categories c ON c.search_id = s.id
+		WHERE c.category = ? AND s.last_updated_at >= ?
+		ORDER BY s.search_count DESC, s.word LIMIT ?`, category, since.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWordCounts(rows)
+}
+
+// GetVerifiedWords runs SELECT word FROM searches WHERE verified ORDER BY word
+func (db *SQLiteDB) GetVerifiedWords(ctx context.Context) ([]string, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `SELECT word FROM searches WHERE verified ORDER BY word`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWords(rows)
+}
+
+// ListUnverified returns the most searched words awaiting review
+func (db *SQLiteDB) ListUnverified(ctx context.Context, limit int) ([]WordCount, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `SELECT word, search_count FROM searches
+		WHERE NOT verified
+		ORDER BY search_count DESC, word LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWordCounts(rows)
+}
+
+// RenameWord renames the record of oldWord, failing if newWord is stored
+func (db *SQLiteDB) RenameWord(ctx context.Context, oldWord, newWord string) (int64, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	records, err := querySearchRecords(ctx, tx, `SELECT id, word, first_searched_at, last_updated_at, search_count, verified
+		FROM searches WHERE word IN (?, ?)`, oldWord, newWord)
+	if err != nil {
+		return 0, err
+	}
+	record, err := renamedRecord(records, oldWord, newWord)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE searches SET word = ? WHERE id = ?`, newWord, record.ID); err != nil {
+		return 0, err
+	}
+	return record.ID, tx.Commit()
+}
+
+// MergeWords merges the record of from into the record of to in one transaction
+func (db *SQLiteDB) MergeWords(ctx context.Context, from, to string) (int64, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	records, err := querySearchRecords(ctx, tx, `SELECT id, word, first_searched_at, last_updated_at, search_count, verified
+		FROM searches WHERE word IN (?, ?)`, from, to)
+	if err != nil {
+		return 0, err
+	}
+	target, remove, err := mergedRecord(records, from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	if remove != 0 {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM searches WHERE id = ?`, remove); err != nil {
+			return 0, err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE searches
+		SET word = ?, first_searched_at = ?, last_updated_at = ?, search_count = ?, verified = ?
+		WHERE id = ?`,
+		target.Word, target.FirstSearchedAt.UTC(), target.LastUpdatedAt.UTC(), target.SearchCount, target.Verified, target.ID)
+	if err != nil {
+		return 0, err
+	}
+	return target.ID, tx.Commit()
+}
+
+// Ping checks the connection to the database
+func (db *SQLiteDB) Ping(ctx context.Context) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	return db.db.PingContext(ctx)
+}
+
+// Close closes the database
+func (db *SQLiteDB) Close() error {
+	return db.db.Close()
+}
@@ -0,0 +1,374 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sqliteConfig returns the config of a fresh database file removed after the test
+func sqliteConfig(t *testing.T) SQLiteConfig {
+	return SQLiteConfig{Path: filepath.Join(t.TempDir(), "logsearch.db")}
+}
+
+func TestSQLiteStorage(t *testing.T) {
+	ctx := context.Background()
+	cfg := sqliteConfig(t)
+	db, err := NewSQLiteDB(cfg)
+	require.NoError(t, err)
+
+	require.NoError(t, db.CreateTable(ctx))
+	// Migrations already applied are skipped
+	require.NoError(t, db.CreateTable(ctx))
+	require.NoError(t, db.Ping(ctx))
+
+	now := time.Now()
+	id, err := db.InsertOrReplace(ctx, "sqltest", now, now)
+	require.NoError(t, err)
+
+	sameID, err := db.InsertOrReplace(ctx, "sqltest", now, now)
+	require.NoError(t, err)
+	assert.Equal(t, id, sameID, "Conflicting insert should return the existing record")
+
+	require.NoError(t, db.Update(ctx, id, "sqltesting", now))
+	assert.Error(t, db.Update(ctx, id+100, "missing", now))
+
+	ids, err := db.InsertOrReplaceBatch(ctx, []string{"cat", "dog", "cat"}, now, now)
+	require.NoError(t, err)
+	require.Len(t, ids, 3)
+	assert.Equal(t, ids[0], ids[2])
+	require.NoError(t, db.UpdateBatch(ctx, []WordUpdate{{ID: ids[1], Word: "doge", LastUpdatedAt: now, Count: 2}}))
+
+	records, err := db.GetAllRecords(ctx)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, SearchRecord{ID: id, Word: "sqltesting", SearchCount: 3}, SearchRecord{ID: records[0].ID, Word: records[0].Word, SearchCount: records[0].SearchCount})
+	assert.WithinDuration(t, now, records[0].LastUpdatedAt, time.Millisecond)
+
+	top, err := db.TopSearches(ctx, now.Add(-time.Minute), 2)
+	require.NoError(t, err)
+	assert.Equal(t, []WordCount{{Word: "doge", Count: 3}, {Word: "sqltesting", Count: 3}}, top)
+
+	top, err = db.TopSearches(ctx, now.Add(time.Minute), 10)
+	require.NoError(t, err)
+	assert.Empty(t, top)
+
+	_, err = db.InsertOrReplace(ctx, "stale", now.Add(-2*time.Hour), now.Add(-2*time.Hour))
+	require.NoError(t, err)
+	purged, err := db.PurgeSearches(ctx, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"stale"}, purged)
+
+	require.NoError(t, db.SetVerified(ctx, "doge", true))
+	assert.ErrorIs(t, db.SetVerified(ctx, "missing", true), ErrWordNotFound)
+	require.NoError(t, db.SetDisplayWord(ctx, "doge", "DoGe"))
+	assert.ErrorIs(t, db.SetDisplayWord(ctx, "missing", "Missing"), ErrWordNotFound)
+	require.NoError(t, db.SetMetadata(ctx, "doge", json.RawMessage(`{"category":"pets"}`)))
+	require.NoError(t, db.SetMetadata(ctx, "cat", json.RawMessage(`{"category":"pets"}`)))
+	require.NoError(t, db.SetMetadata(ctx, "cat", nil))
+	assert.ErrorIs(t, db.SetMetadata(ctx, "missing", json.RawMessage(`{}`)), ErrWordNotFound)
+	require.NoError(t, db.TagCategories(ctx, "doge", []string{"pets", "memes"}))
+	require.NoError(t, db.TagCategories(ctx, "cat", []string{"pets"}))
+	require.NoError(t, db.TagCategories(ctx, "cat", []string{"pets"}))
+	assert.ErrorIs(t, db.TagCategories(ctx, "missing", []string{"pets"}), ErrWordNotFound)
+	top, err = db.TopSearchesInCategory(ctx, "pets", now.Add(-time.Minute), 10)
+	require.NoError(t, err)
+	assert.Equal(t, []WordCount{{Word: "doge", Count: 3}, {Word: "cat", Count: 2}}, top)
+	unverified, err := db.ListUnverified(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []WordCount{{Word: "sqltesting", Count: 3}, {Word: "cat", Count: 2}}, unverified)
+
+	recordCount, searches, err := db.CountSearches(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), recordCount)
+	assert.Equal(t, int64(8), searches)
+
+	// The data outlives the store
+	require.NoError(t, db.Close())
+	assert.Error(t, db.Ping(ctx))
+	db, err = NewSQLiteDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.CreateTable(ctx))
+
+	words, err := db.GetAllSearchedWords(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cat", "doge", "sqltesting"}, words)
+
+	verified, err := db.GetVerifiedWords(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"doge"}, verified)
+	displays, err := db.GetDisplayWords(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"doge": "DoGe"}, displays)
+
+	metadata, err := db.GetMetadata(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]json.RawMessage{"doge": json.RawMessage(`{"category":"pets"}`)}, metadata)
+
+	categories, err := db.GetCategories(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"cat": {"pets"}, "doge": {"memes", "pets"}}, categories)
+}
+
+func TestSQLiteV2Storage(t *testing.T) {
+	ctx := context.Background()
+	cfg := sqliteConfig(t)
+	db, err := NewSQLiteDBV2(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.CreateTable(ctx))
+	user := "sqlite_test_user"
+
+	var indexes int
+	require.NoError(t, db.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'index' AND name = 'user_searches_last_updated_at_idx'`).Scan(&indexes))
+	assert.Equal(t, 1, indexes)
+
+	now := time.Now()
+	_, err = db.InsertOrUpdateUserSearch(ctx, user, "bu", now.Add(-time.Hour), now)
+	require.NoError(t, err)
+	_, err = db.InsertOrUpdateUserSearch(ctx, user, "bus", now, now)
+	require.NoError(t, err)
+
+	// Extending "bu" to "bus" merges into the existing "bus" record
+	require.NoError(t, db.UpdateUserSearchByWord(ctx, user, "bu", "bus", now))
+	assert.ErrorIs(t, db.UpdateUserSearchByWord(ctx, user, "bu", "bus", now), ErrUserNotFound)
+
+	searches, err := db.GetUserSearches(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bus"}, searches)
+
+	// "bus" is replaced by "business", "cat" is new and "dog" is searched by another user
+	require.NoError(t, db.ApplyUserSearchWrites(ctx, []UserSearchWrite{
+		{UserIdentifier: user, Word: "business", ReplaceWords: []string{"bus"}, FirstSearchedAt: now, LastUpdatedAt: now, Count: 1},
+		{UserIdentifier: user, Word: "cat", FirstSearchedAt: now, LastUpdatedAt: now, Count: 2},
+		{UserIdentifier: "other_user", Word: "cat", FirstSearchedAt: now, LastUpdatedAt: now, Count: 1},
+	}))
+
+	searches, err = db.GetUserSearches(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"business", "cat"}, searches)
+
+	top, err := db.TopSearches(ctx, time.Time{}, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []WordCount{{Word: "business", Count: 3}, {Word: "cat", Count: 3}}, top)
+
+	var records []UserSearchRecord
+	require.NoError(t, db.ForEachUserSearch(ctx, user, func(record UserSearchRecord) error {
+		records = append(records, record)
+		return nil
+	}))
+	require.Len(t, records, 2)
+	assert.Equal(t, "business", records[0].SearchWord)
+	assert.Equal(t, 3, records[0].SearchCount)
+	assert.WithinDuration(t, now.Add(-time.Hour), records[0].FirstSearchedAt, time.Millisecond)
+	assert.Equal(t, "cat", records[1].SearchWord)
+	assert.Empty(t, records[0].SurfaceWord)
+
+	require.NoError(t, db.SetSurfaceWord(ctx, user, "business", "Businesses"))
+
+	_, err = db.InsertOrUpdateUserSearch(ctx, "other_user", "stale", now.Add(-2*time.Hour), now.Add(-2*time.Hour))
+	require.NoError(t, err)
+	purged, err := db.PurgeUserSearches(ctx, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []WordCount{{Word: "stale", Count: 1}}, purged)
+
+	// The guest's "cat" merges into the user's, "busi" into the user's "business"
+	_, err = db.InsertOrUpdateUserSearch(ctx, "anon_user", "cat", now, now)
+	require.NoError(t, err)
+	_, err = db.InsertOrUpdateUserSearch(ctx, "anon_user", "busi", now, now)
+	require.NoError(t, err)
+	require.NoError(t, db.MergeUserSearches(ctx, "anon_user", user))
+
+	top, err = db.TopSearches(ctx, time.Time{}, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []WordCount{{Word: "business", Count: 4}, {Word: "cat", Count: 4}}, top)
+	searches, err = db.GetUserSearches(ctx, "anon_user")
+	require.NoError(t, err)
+	assert.Empty(t, searches)
+
+	// The surface form survives the merge
+	records = nil
+	require.NoError(t, db.ForEachUserSearch(ctx, user, func(record UserSearchRecord) error {
+		records = append(records, record)
+		return nil
+	}))
+	require.Len(t, records, 2)
+	assert.Equal(t, "Businesses", records[0].SurfaceWord)
+
+	// The quota evicts the least recently updated record
+	_, err = db.InsertOrUpdateUserSearch(ctx, user, "idle", now.Add(-30*time.Minute), now.Add(-30*time.Minute))
+	require.NoError(t, err)
+	trimmed, err := db.TrimUserSearches(ctx, user, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []WordCount{{Word: "idle", Count: 1}}, trimmed)
+	searches, err = db.GetUserSearches(ctx, user)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"business", "cat"}, searches)
+
+	deleted, err := db.DeleteUserSearches(ctx, user)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []WordCount{{Word: "business", Count: 4}, {Word: "cat", Count: 3}}, deleted)
+
+	searches, err = db.GetUserSearches(ctx, user)
+	require.NoError(t, err)
+	assert.Empty(t, searches)
+
+	// V1 and V2 can share a database file
+	v1, err := NewSQLiteDB(cfg)
+	require.NoError(t, err)
+	defer v1.Close()
+	require.NoError(t, v1.CreateTable(ctx))
+}
+
+func TestSQLiteV2UserClocks(t *testing.T) {
+	ctx := context.Background()
+	cfg := sqliteConfig(t)
+	db, err := NewSQLiteDBV2(cfg)
+	require.NoError(t, err)
+	require.NoError(t, db.CreateTable(ctx))
+
+	now := time.Now().UTC().Truncate(time.Second)
+	previous, err := db.AdvanceUserClock(ctx, "user_1", UserClock{At: now, Sequence: 5})
+	require.NoError(t, err)
+	assert.True(t, previous.IsZero())
+
+	// An older clock leaves the latest one in place
+	previous, err = db.AdvanceUserClock(ctx, "user_1", UserClock{At: now.Add(-time.Minute), Sequence: 3})
+	require.NoError(t, err)
+	assert.Equal(t, UserClock{At: now, Sequence: 5}, previous)
+	require.NoError(t, db.Close())
+
+	// The clock survives a restart
+	db, err = NewSQLiteDBV2(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.CreateTable(ctx))
+	previous, err = db.AdvanceUserClock(ctx, "user_1", UserClock{Sequence: 6})
+	require.NoError(t, err)
+	assert.Equal(t, UserClock{At: now, Sequence: 5}, previous)
+	assert.True(t, UserClock{Sequence: 4}.Before(previous))
+	assert.True(t, UserClock{At: now.Add(-time.Second)}.Before(previous))
+
+	require.NoError(t, db.DeleteUserClock(ctx, "user_1"))
+	previous, err = db.AdvanceUserClock(ctx, "user_1", UserClock{Sequence: 1})
+	require.NoError(t, err)
+	assert.True(t, previous.IsZero())
+}
+
+func TestSQLiteV2Audit(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewSQLiteDBV2(sqliteConfig(t))
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.CreateTable(ctx))
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, entry := range []AuditEntry{
+		{At: now.Add(-time.Hour), Actor: "alice", Action: "delete-user", Target: "user_1", Before: "3 searches"},
+		{At: now, Actor: "bob", Action: "merge-words", Target: "nyc", Before: "nyc", After: "new york"},
+		{At: now, Actor: "alice", Action: "verify", Target: "bus", Before: "unverified", After: "verified"},
+	} {
+		_, err := db.AppendAudit(ctx, entry)
+		require.NoError(t, err)
+	}
+
+	entries, err := db.ListAudit(ctx, AuditQuery{})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, AuditEntry{ID: 3, At: now, Actor: "alice", Action: "verify", Target: "bus", Before: "unverified", After: "verified"}, entries[0])
+
+	entries, err = db.ListAudit(ctx, AuditQuery{Actor: "alice", Since: now.Add(-time.Minute)})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "verify", entries[0].Action)
+
+	entries, err = db.ListAudit(ctx, AuditQuery{Action: "delete-user", Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "user_1", entries[0].Target)
+
+	// The audit log is append-only
+	_, err = db.db.ExecContext(ctx, `UPDATE audit_log SET actor = 'mallory'`)
+	assert.ErrorContains(t, err, "append-only")
+	_, err = db.db.ExecContext(ctx, `DELETE FROM audit_log`)
+	assert.ErrorContains(t, err, "append-only")
+}
+
+func TestSQLiteV2Audiences(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewSQLiteDBV2(sqliteConfig(t))
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.CreateTable(ctx))
+
+	now := time.Now()
+	for _, search := range []struct{ user, word string }{
+		{"user_1", "bus"}, {"user_1", "bus"}, {"user_1", "bus"}, {"user_1", "cat"}, {"user_2", "cat"},
+	} {
+		_, err := db.InsertOrUpdateUserSearch(ctx, search.user, search.word, now, now)
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.AddAudiences(ctx, []AudienceAdd{
+		{Word: "bus", UserIdentifier: "user_1", At: now},
+		{Word: "cat", UserIdentifier: "user_1", At: now},
+		{Word: "cat", UserIdentifier: "user_2", At: now},
+		{Word: "emu", UserIdentifier: "user_3", At: now},
+	}))
+	// A user counts once however many times they searched the word
+	require.NoError(t, db.AddAudiences(ctx, []AudienceAdd{{Word: "bus", UserIdentifier: "user_1", At: now.Add(time.Minute)}}))
+
+	// cat has the largest audience, bus the most searches, emu is not stored
+	top, err := db.TopAudiences(ctx, time.Time{}, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []WordCount{{Word: "cat", Count: 2, Users: 2}, {Word: "bus", Count: 3, Users: 1}}, top)
+
+	top, err = db.TopAudiences(ctx, now.Add(30*time.Second), 10)
+	require.NoError(t, err)
+	assert.Equal(t, []WordCount{{Word: "bus", Count: 3, Users: 1}}, top)
+}
+
+func TestSQLiteV2Regions(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewSQLiteDBV2(sqliteConfig(t))
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.CreateTable(ctx))
+
+	now := time.Now()
+	for _, search := range []struct {
+		user, word string
+		region     Region
+	}{
+		{"user_1", "bus", Region{Country: "FR", Locale: "fr-FR"}},
+		{"user_1", "bus", Region{Country: "FR", Locale: "fr-FR"}},
+		{"user_2", "bus", Region{Country: "BE", Locale: "fr-BE"}},
+		{"user_2", "cat", Region{Country: "BE", Locale: "nl-BE"}},
+		{"user_3", "cat", Region{}},
+	} {
+		_, err := db.InsertOrUpdateUserSearch(ctx, search.user, search.word, now, now)
+		require.NoError(t, err)
+		require.NoError(t, db.SetUserSearchRegion(ctx, search.user, search.word, search.region))
+	}
+	require.NoError(t, db.SetUserSearchRegion(ctx, "user_4", "emu", Region{Country: "AU"}), "A missing record is left alone")
+
+	top, err := db.TopSearchesByRegion(ctx, RegionQuery{Country: "BE", Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, []WordCount{{Word: "bus", Count: 1}, {Word: "cat", Count: 1}}, top)
+	top, err = db.TopSearchesByRegion(ctx, RegionQuery{Locale: "fr-FR", Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, []WordCount{{Word: "bus", Count: 2}}, top)
+	top, err = db.TopSearchesByRegion(ctx, RegionQuery{Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, []WordCount{{Word: "bus", Count: 3}, {Word: "cat", Count: 2}}, top, "An empty query ranks every record")
+	top, err = db.TopSearchesByRegion(ctx, RegionQuery{Country: "FR", Since: now.Add(time.Minute), Limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, top)
+}
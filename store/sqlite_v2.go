@@ -0,0 +1,950 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SQLiteDBV2 implements the same operations as PostgresDBV2 on an embedded SQLite database
+type SQLiteDBV2 struct {
+	db           *sql.DB
+	queryTimeout time.Duration
+}
+
+// NewSQLiteDBV2 opens the SQLite database file, which may be shared with a SQLiteDB
+func NewSQLiteDBV2(cfg SQLiteConfig) (*SQLiteDBV2, error) {
+	db, queryTimeout, err := openSQLite(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SQLiteDBV2{db: db, queryTimeout: queryTimeout}, nil
+}
+
+// userSearchesMigrations evolve the user_searches table, append new ones and never edit applied ones
+var userSearchesMigrations = []sqliteMigration{
+	{
+		name: "user_searches/001_create",
+		sql: `CREATE TABLE IF NOT EXISTS user_searches (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_identifier TEXT NOT NULL,
+			search_word TEXT NOT NULL,
+			first_searched_at TIMESTAMP NOT NULL,
+			last_updated_at TIMESTAMP NOT NULL,
+			search_count INTEGER NOT NULL DEFAULT 1,
+			UNIQUE(user_identifier, search_word)
+		)`,
+	},
+	{
+		// The unique index already serves the per-user lookups
+		name: "user_searches/002_last_updated_at_index",
+		sql:  `CREATE INDEX IF NOT EXISTS user_searches_last_updated_at_idx ON user_searches (last_updated_at)`,
+	},
+	{
+		name: "user_searches/003_surface_word",
+		sql:  `ALTER TABLE user_searches ADD COLUMN surface_word TEXT`,
+	},
+	{
+		name: "user_searches/004_region",
+		sql: `ALTER TABLE user_searches ADD COLUMN country TEXT;
+		ALTER TABLE user_searches ADD COLUMN locale TEXT`,
+	},
+	{
+		name: "search_rollups/001_create",
+		sql: `CREATE TABLE IF NOT EXISTS search_rollups_hourly (
+			bucket TIMESTAMP NOT NULL,
+			search_word TEXT NOT NULL,
+			total_count INTEGER NOT NULL,
+			unique_users INTEGER NOT NULL,
+			PRIMARY KEY (bucket, search_word)
+		);
+		CREATE TABLE IF NOT EXISTS search_rollups_daily (
+			bucket TIMESTAMP NOT NULL,
+			search_word TEXT NOT NULL,
+			total_count INTEGER NOT NULL,
+			unique_users INTEGER NOT NULL,
+			PRIMARY KEY (bucket, search_word)
+		);
+		CREATE TABLE IF NOT EXISTS search_rollup_watermarks (
+			granularity TEXT PRIMARY KEY,
+			rolled_up_until TIMESTAMP NOT NULL
+		)`,
+	},
+	{
+		// The triggers keep the audit log append-only
+		name: "audit_log/001_create",
+		sql: `CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			at TIMESTAMP NOT NULL,
+			actor TEXT NOT NULL,
+			action TEXT NOT NULL,
+			target TEXT NOT NULL,
+			before_value TEXT NOT NULL,
+			after_value TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS audit_log_at_idx ON audit_log (at);
+		CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+		BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END;
+		CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+		BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END`,
+	},
+	{
+		// The index serves the join of the audiences to the stored words
+		name: "search_audiences/001_create",
+		sql: `CREATE TABLE IF NOT EXISTS search_audiences (
+			word TEXT PRIMARY KEY,
+			registers BLOB NOT NULL,
+			users INTEGER NOT NULL,
+			last_updated_at TIMESTAMP NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS user_searches_search_word_idx ON user_searches (search_word)`,
+	},
+	{
+		name: "user_clocks/001_create",
+		sql: `CREATE TABLE IF NOT EXISTS user_clocks (
+			user_identifier TEXT PRIMARY KEY,
+			client_at TIMESTAMP NOT NULL,
+			sequence INTEGER NOT NULL
+		)`,
+	},
+	{
+		name: "quarantined_searches/001_create",
+		sql: `CREATE TABLE IF NOT EXISTS quarantined_searches (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_identifier TEXT NOT NULL,
+			search_word TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			searched_at TIMESTAMP NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS quarantined_searches_user_identifier_idx ON quarantined_searches (user_identifier)`,
+	},
+	{
+		name: "search_clicks/001_create",
+		sql: `CREATE TABLE IF NOT EXISTS search_clicks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_identifier TEXT NOT NULL,
+			search_word TEXT NOT NULL,
+			result_id TEXT NOT NULL,
+			clicked_at TIMESTAMP NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS search_clicks_search_word_idx ON search_clicks (search_word);
+		CREATE INDEX IF NOT EXISTS search_clicks_user_identifier_idx ON search_clicks (user_identifier)`,
+	},
+	{
+		name: "search_synonyms/001_create",
+		sql: `CREATE TABLE IF NOT EXISTS search_synonyms (
+			alias TEXT PRIMARY KEY,
+			canonical TEXT NOT NULL
+		)`,
+	},
+	{
+		name: "zero_result_terms/001_create",
+		sql: `CREATE TABLE IF NOT EXISTS zero_result_terms (
+			search_word TEXT PRIMARY KEY,
+			streak INTEGER NOT NULL,
+			first_zero_at TIMESTAMP NOT NULL,
+			last_zero_at TIMESTAMP NOT NULL
+		)`,
+	},
+}
+
+// queryContext bounds the caller's context by the configured query timeout
+func (db *SQLiteDBV2) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, db.queryTimeout)
+}
+
+// CreateTable creates or migrates the user_searches table
+func (db *SQLiteDBV2) CreateTable(ctx context.Context) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	return migrateSQLite(ctx, db.db, userSearchesMigrations)
+}
+
+// InsertOrUpdateUserSearch inserts a user's word or bumps its count with INSERT ... ON CONFLICT UPDATE
+func (db *SQLiteDBV2) InsertOrUpdateUserSearch(ctx context.Context, userIdentifier, word string, firstSearched, lastUpdated time.Time) (int64, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	var id int64
+	err := db.db.QueryRowContext(ctx, `INSERT INTO user_searches (user_identifier, search_word, first_searched_at, last_updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_identifier, search_word) DO UPDATE
+		SET last_updated_at = excluded.last_updated_at, search_count = user_searches.search_count + 1
+		RETURNING id`,
+		userIdentifier, word, firstSearched.UTC(), lastUpdated.UTC()).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetUserSearches returns all searches for a specific user
+func (db *SQLiteDBV2) GetUserSearches(ctx context.Context, userIdentifier string) ([]string, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `SELECT search_word FROM user_searches
+		WHERE user_identifier = ? ORDER BY search_word`, userIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var words []string
+	for rows.Next() {
+		var word string
+		if err := rows.Scan(&word); err != nil {
+			return nil, err
+		}
+		words = append(words, word)
+	}
+
+	return words, rows.Err()
+}
+
+// ForEachUserSearch streams every record of the user in word order
+func (db *SQLiteDBV2) ForEachUserSearch(ctx context.Context, userIdentifier string, fn func(UserSearchRecord) error) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `SELECT id, user_identifier, search_word, first_searched_at, last_updated_at, search_count, surface_word
+		FROM user_searches WHERE user_identifier = ? ORDER BY search_word`, userIdentifier)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record, err := scanUserSearchRecord(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// TopSearches returns the most searched words over all users last updated at or after since
+func (db *SQLiteDBV2) TopSearches(ctx context.Context, since time.Time, limit int) ([]WordCount, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `SELECT search_word, SUM(search_count) FROM user_searches
+		WHERE last_updated_at >= ?
+		GROUP BY search_word
+		ORDER BY 2 DESC, search_word LIMIT ?`, since.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWordCounts(rows)
+}
+
+// TopSearchesByRegion returns the most searched words over the records of the region of query
+func (db *SQLiteDBV2) TopSearchesByRegion(ctx context.Context, query RegionQuery) ([]WordCount, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `SELECT search_word, SUM(search_count) FROM user_searches
+		WHERE (?1 = '' OR country = ?1) AND (?2 = '' OR locale = ?2) AND last_updated_at >= ?3
+		GROUP BY search_word
+		ORDER BY 2 DESC, search_word LIMIT ?4`, query.Country, query.Locale, query.Since.UTC(), query.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWordCounts(rows)
+}
+
+// AddAudiences adds the users to the HyperLogLogs of their words in one transaction
+func (db *SQLiteDBV2) AddAudiences(ctx context.Context, adds []AudienceAdd) error {
+	if len(adds) == 0 {
+		return nil
+	}
+
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, update := range groupAudiences(adds) {
+		var registers []byte
+		err := tx.QueryRowContext(ctx, `SELECT registers FROM search_audiences WHERE word = ?`, update.word).Scan(&registers)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		registers, users, err := addUsers(registers, update.users)
+		if err != nil {
+			return fmt.Errorf("audience of %q: %w", update.word, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO search_audiences (word, registers, users, last_updated_at)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT (word) DO UPDATE SET registers = excluded.registers, users = excluded.users,
+				last_updated_at = MAX(search_audiences.last_updated_at, excluded.last_updated_at)`,
+			update.word, registers, users, update.at.UTC()); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// TopAudiences returns the stored words searched by the most users last searched at or after since
+func (db *SQLiteDBV2) TopAudiences(ctx context.Context, since time.Time, limit int) ([]WordCount, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `SELECT a.word, SUM(s.search_count), a.users FROM search_audiences a
+		JOIN user_searches s ON s.search_word = a.word
+		WHERE a.last_updated_at >= ?
+		GROUP BY a.word, a.users
+		ORDER BY a.users DESC, 2 DESC, a.word LIMIT ?`, since.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAudiences(rows)
+}
+
+// SearchesBetween returns the words over all users last updated in [from, to)
+func (db *SQLiteDBV2) SearchesBetween(ctx context.Context, from, to time.Time) ([]WordCount, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `SELECT search_word, SUM(search_count) FROM user_searches
+		WHERE last_updated_at >= ? AND last_updated_at < ?
+		GROUP BY search_word
+		ORDER BY 2 DESC, search_word`, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWordCounts(rows)
+}
+
+// SearchHistogram counts the words and searches last updated in [from, to) by
+// bucket. SQLite stores the times as text, so the rows are bucketed here.
+func (db *SQLiteDBV2) SearchHistogram(ctx context.Context, from, to time.Time, granularity Granularity) ([]SearchBucket, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `SELECT search_word, last_updated_at, search_count FROM user_searches
+		WHERE last_updated_at >= ? AND last_updated_at < ?`, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	h := newHistogram(granularity)
+	for rows.Next() {
+		var word string
+		var lastUpdated time.Time
+		var count int
+		if err := rows.Scan(&word, &lastUpdated, &count); err != nil {
+			return nil, err
+		}
+		h.add(word, lastUpdated, count)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return h.result(), nil
+}
+
+// RollupSearches replaces the rows of [from, to) of the rollup table with the
+// records grouped by bucket and word, and moves the watermark, in one
+// transaction. SQLite stores the times as text, so the records are grouped here.
+func (db *SQLiteDBV2) RollupSearches(ctx context.Context, from, to time.Time, granularity Granularity) (int64, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, user_identifier, search_word, first_searched_at, last_updated_at, search_count, surface_word
+		FROM user_searches WHERE last_updated_at >= ? AND last_updated_at < ?`, from.UTC(), to.UTC())
+	if err != nil {
+		return 0, err
+	}
+	records, err := scanUserSearchRecords(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM `+granularity.table()+` WHERE bucket >= ? AND bucket < ?`, from.UTC(), to.UTC()); err != nil {
+		return 0, err
+	}
+	rollups := rollupRecords(records, granularity)
+	for _, rollup := range rollups {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO `+granularity.table()+` (bucket, search_word, total_count, unique_users) VALUES (?, ?, ?, ?)`,
+			rollup.Bucket, rollup.Word, rollup.TotalCount, rollup.UniqueUsers); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO search_rollup_watermarks (granularity, rolled_up_until) VALUES (?, ?)
+		ON CONFLICT (granularity) DO UPDATE
+		SET rolled_up_until = max(search_rollup_watermarks.rolled_up_until, excluded.rolled_up_until)`,
+		granularity.unit(), to.UTC()); err != nil {
+		return 0, err
+	}
+
+	return int64(len(rollups)), tx.Commit()
+}
+
+// SearchesByHour summarizes the records of word by hour like RollupSearches without writing them
+func (db *SQLiteDBV2) SearchesByHour(ctx context.Context, from, to time.Time, word string) ([]SearchRollup, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `SELECT id, user_identifier, search_word, first_searched_at, last_updated_at, search_count, surface_word
+		FROM user_searches WHERE last_updated_at >= ?1 AND last_updated_at < ?2 AND (?3 = '' OR search_word = ?3)`, from.UTC(), to.UTC(), word)
+	if err != nil {
+		return nil, err
+	}
+	records, err := scanUserSearchRecords(rows)
+	if err != nil {
+		return nil, err
+	}
+	return rollupRecords(records, Hourly), nil
+}
+
+// RolledUpUntil returns the watermark of the granularity
+func (db *SQLiteDBV2) RolledUpUntil(ctx context.Context, granularity Granularity) (time.Time, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	var until time.Time
+	err := db.db.QueryRowContext(ctx, `SELECT rolled_up_until FROM search_rollup_watermarks WHERE granularity = ?`,
+		granularity.unit()).Scan(&until)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return until.UTC(), err
+}
+
+// GetRollups returns the rows of the rollup table in [from, to)
+func (db *SQLiteDBV2) GetRollups(ctx context.Context, from, to time.Time, granularity Granularity) ([]SearchRollup, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	return scanRollups(db.db.QueryContext(ctx, `SELECT bucket, search_word, total_count, unique_users FROM `+granularity.table()+`
+		WHERE bucket >= ? AND bucket < ?
+		ORDER BY bucket, total_count DESC, search_word`, from.UTC(), to.UTC()))
+}
+
+// UpdateUserSearchByWord updates a user's search record from old word to new word,
+// merging into an existing record of the new word the same way MockPostgresDBV2 does
+func (db *SQLiteDBV2) UpdateUserSearchByWord(ctx context.Context, userIdentifier, oldWord, newWord string, lastUpdated time.Time) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldID int64
+	var oldFirstSearched time.Time
+	var oldCount int
+	err = tx.QueryRowContext(ctx, `SELECT id, first_searched_at, search_count FROM user_searches
+		WHERE user_identifier = ? AND search_word = ?`,
+		userIdentifier, oldWord).Scan(&oldID, &oldFirstSearched, &oldCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: no record for user %s with word %s", ErrUserNotFound, userIdentifier, oldWord)
+	}
+	if err != nil {
+		return err
+	}
+
+	var existingID int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM user_searches
+		WHERE user_identifier = ? AND search_word = ?`,
+		userIdentifier, newWord).Scan(&existingID)
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// No existing record with new word, just update the old record
+		_, err = tx.ExecContext(ctx, `UPDATE user_searches
+			SET search_word = ?, last_updated_at = ?, search_count = search_count + 1
+			WHERE id = ?`,
+			newWord, lastUpdated.UTC(), oldID)
+	case err == nil:
+		// Merge with existing record, keeping the earlier first_searched_at
+		if _, err = tx.ExecContext(ctx, `DELETE FROM user_searches WHERE id = ?`, oldID); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE user_searches
+			SET last_updated_at = ?,
+				search_count = search_count + ?,
+				first_searched_at = MIN(first_searched_at, ?)
+			WHERE id = ?`,
+			lastUpdated.UTC(), oldCount, oldFirstSearched.UTC(), existingID)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetUserSearchRegion sets the country and locale of the user's record of word
+func (db *SQLiteDBV2) SetUserSearchRegion(ctx context.Context, userIdentifier, word string, region Region) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	_, err := db.db.ExecContext(ctx, `UPDATE user_searches SET country = NULLIF(?, ''), locale = NULLIF(?, '')
+		WHERE user_identifier = ? AND search_word = ?`, region.Country, region.Locale, userIdentifier, word)
+	return err
+}
+
+// SetSurfaceWord sets the surface form of the user's record of word
+func (db *SQLiteDBV2) SetSurfaceWord(ctx context.Context, userIdentifier, word, surface string) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	_, err := db.db.ExecContext(ctx, `UPDATE user_searches SET surface_word = ?
+		WHERE user_identifier = ? AND search_word = ?`, surface, userIdentifier, word)
+	return err
+}
+
+// ApplyUserSearchWrites applies a batch of coalesced writes in one transaction:
+// the replaced prefixes are deleted first, merging their counts into the writes,
+// then every word is upserted with the merged counts
+func (db *SQLiteDBV2) ApplyUserSearchWrites(ctx context.Context, writes []UserSearchWrite) error {
+	if len(writes) == 0 {
+		return nil
+	}
+
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	merged := make([]UserSearchWrite, len(writes))
+	copy(merged, writes)
+	for i := range merged {
+		target := &merged[i]
+		for _, word := range target.ReplaceWords {
+			var firstSearched time.Time
+			var count int
+			err := tx.QueryRowContext(ctx, `DELETE FROM user_searches
+				WHERE user_identifier = ? AND search_word = ?
+				RETURNING first_searched_at, search_count`,
+				target.UserIdentifier, word).Scan(&firstSearched, &count)
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			target.Count += count
+			if firstSearched.Before(target.FirstSearchedAt) {
+				target.FirstSearchedAt = firstSearched
+			}
+		}
+	}
+
+	for _, write := range merged {
+		_, err := tx.ExecContext(ctx, `INSERT INTO user_searches (user_identifier, search_word, first_searched_at, last_updated_at, search_count)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (user_identifier, search_word) DO UPDATE
+			SET first_searched_at = MIN(user_searches.first_searched_at, excluded.first_searched_at),
+				last_updated_at = MAX(user_searches.last_updated_at, excluded.last_updated_at),
+				search_count = user_searches.search_count + excluded.search_count`,
+			write.UserIdentifier, write.Word, write.FirstSearchedAt.UTC(), write.LastUpdatedAt.UTC(), write.Count)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// PurgeUserSearches deletes the records last updated before cutoff
+func (db *SQLiteDBV2) PurgeUserSearches(ctx context.Context, cutoff time.Time) ([]WordCount, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `DELETE FROM user_searches WHERE last_updated_at < ?
+		RETURNING search_word, search_count`, cutoff.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWordCounts(rows)
+}
+
+// AppendAudit inserts an entry into the audit_log table
+func (db *SQLiteDBV2) AppendAudit(ctx context.Context, entry AuditEntry) (int64, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	result, err := db.db.ExecContext(ctx, `INSERT INTO audit_log (at, actor, action, target, before_value, after_value)
+		VALUES (?, ?, ?, ?, ?, ?)`, entry.At.UTC(), entry.Actor, entry.Action, entry.Target, entry.Before, entry.After)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// ListAudit returns the audit_log entries selected by query, newest first
+func (db *SQLiteDBV2) ListAudit(ctx context.Context, query AuditQuery) ([]AuditEntry, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	return scanAuditEntries(db.db.QueryContext(ctx, `SELECT id, at, actor, action, target, before_value, after_value FROM audit_log
+		WHERE (? = '' OR actor = ?) AND (? = '' OR action = ?) AND at >= ?
+		ORDER BY id DESC LIMIT ?`,
+		query.Actor, query.Actor, query.Action, query.Action, query.Since.UTC(), query.limit()))
+}
+
+// QuarantineSearch inserts a search into the quarantined_searches table
+func (db *SQLiteDBV2) QuarantineSearch(ctx context.Context, search QuarantinedSearch) (int64, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	result, err := db.db.ExecContext(ctx, `INSERT INTO quarantined_searches (user_identifier, search_word, reason, searched_at)
+		VALUES (?, ?, ?, ?)`, search.UserIdentifier, search.Word, search.Reason, search.SearchedAt.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// ListQuarantinedSearches returns the quarantined searches of the user, of every user when empty, in ID order
+func (db *SQLiteDBV2) ListQuarantinedSearches(ctx context.Context, userIdentifier string) ([]QuarantinedSearch, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	return scanQuarantinedSearches(db.db.QueryContext(ctx, `SELECT id, user_identifier, search_word, reason, searched_at
+		FROM quarantined_searches WHERE (?1 = '' OR user_identifier = ?1) ORDER BY id`, userIdentifier))
+}
+
+// TakeQuarantinedSearches deletes the quarantined searches of ids and returns them in ID order
+func (db *SQLiteDBV2) TakeQuarantinedSearches(ctx context.Context, ids []int64) ([]QuarantinedSearch, error) {
+	if len(ids) == 0 {
+		return []QuarantinedSearch{}, nil
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	return sortQuarantinedSearches(scanQuarantinedSearches(db.db.QueryContext(ctx, `DELETE FROM quarantined_searches WHERE id IN (`+placeholders+`)
+		RETURNING id, user_identifier, search_word, reason, searched_at`, args...)))
+}
+
+// DeleteUserQuarantine deletes the quarantined searches of the user
+func (db *SQLiteDBV2) DeleteUserQuarantine(ctx context.Context, userIdentifier string) (int64, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	result, err := db.db.ExecContext(ctx, `DELETE FROM quarantined_searches WHERE user_identifier = ?`, userIdentifier)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// AddSearchClick inserts a click into the search_clicks table
+func (db *SQLiteDBV2) AddSearchClick(ctx context.Context, click SearchClick) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	_, err := db.db.ExecContext(ctx, `INSERT INTO search_clicks (user_identifier, search_word, result_id, clicked_at)
+		VALUES (?, ?, ?, ?)`, click.UserIdentifier, click.Word, click.ResultID, click.ClickedAt.UTC())
+	return err
+}
+
+// ClickStats sums the search counts of words in user_searches and counts their clicks in search_clicks
+func (db *SQLiteDBV2) ClickStats(ctx context.Context, words []string) (map[string]ClickStats, error) {
+	if len(words) == 0 {
+		return map[string]ClickStats{}, nil
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	args := make([]any, len(words))
+	for i, word := range words {
+		args[i] = word
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(words)), ", ")
+	searches, err := scanWordTotals(db.db.QueryContext(ctx, `SELECT search_word, SUM(search_count) FROM user_searches
+		WHERE search_word IN (`+placeholders+`) GROUP BY search_word`, args...))
+	if err != nil {
+		return nil, err
+	}
+	clicks, err := scanWordTotals(db.db.QueryContext(ctx, `SELECT search_word, COUNT(*) FROM search_clicks
+		WHERE search_word IN (`+placeholders+`) GROUP BY search_word`, args...))
+	if err != nil {
+		return nil, err
+	}
+	return clickStats(searches, clicks), nil
+}
+
+// DeleteUserClicks deletes the clicks of the user
+func (db *SQLiteDBV2) DeleteUserClicks(ctx context.Context, userIdentifier string) (int64, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	result, err := db.db.ExecContext(ctx, `DELETE FROM search_clicks WHERE user_identifier = ?`, userIdentifier)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// RecordSearchResults extends the streak of word in zero_result_terms with INSERT ... ON CONFLICT UPDATE
+// for a search without result, and deletes it otherwise
+func (db *SQLiteDBV2) RecordSearchResults(ctx context.Context, word string, results int, at time.Time) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	if results != 0 {
+		_, err := db.db.ExecContext(ctx, `DELETE FROM zero_result_terms WHERE search_word = ?`, word)
+		return err
+	}
+	_, err := db.db.ExecContext(ctx, `INSERT INTO zero_result_terms (search_word, streak, first_zero_at, last_zero_at)
+		VALUES (?1, 1, ?2, ?2)
+		ON CONFLICT (search_word) DO UPDATE SET streak = streak + 1, last_zero_at = excluded.last_zero_at`,
+		word, at.UTC())
+	return err
+}
+
+// ZeroResultTerms returns the words of zero_result_terms with a streak of at least minStreak
+func (db *SQLiteDBV2) ZeroResultTerms(ctx context.Context, minStreak, limit int) ([]ZeroResultTerm, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	return scanZeroResultTerms(db.db.QueryContext(ctx, `SELECT search_word, streak, first_zero_at, last_zero_at
+		FROM zero_result_terms WHERE streak >= ?
+		ORDER BY streak DESC, last_zero_at DESC, search_word LIMIT ?`, minStreak, limit))
+}
+
+// PutSynonym maps alias to canonical with INSERT ... ON CONFLICT UPDATE
+func (db *SQLiteDBV2) PutSynonym(ctx context.Context, alias, canonical string) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	_, err := db.db.ExecContext(ctx, `INSERT INTO search_synonyms (alias, canonical) VALUES (?, ?)
+		ON CONFLICT (alias) DO UPDATE SET canonical = excluded.canonical`, alias, canonical)
+	return err
+}
+
+// DeleteSynonym deletes the alias from search_synonyms
+func (db *SQLiteDBV2) DeleteSynonym(ctx context.Context, alias string) (bool, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	result, err := db.db.ExecContext(ctx, `DELETE FROM search_synonyms WHERE alias = ?`, alias)
+	if err != nil {
+		return false, err
+	}
+
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
+
+// ListSynonyms returns the rows of search_synonyms by alias
+func (db *SQLiteDBV2) ListSynonyms(ctx context.Context) ([]Synonym, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	return scanSynonyms(db.db.QueryContext(ctx, `SELECT alias, canonical FROM search_synonyms ORDER BY alias`))
+}
+
+// TrimUserSearches deletes the records of the user beyond the maxRecords most recently updated
+func (db *SQLiteDBV2) TrimUserSearches(ctx context.Context, userIdentifier string, maxRecords int) ([]WordCount, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `DELETE FROM user_searches WHERE id IN (
+		SELECT id FROM user_searches WHERE user_identifier = ?
+		ORDER BY last_updated_at DESC, id DESC LIMIT -1 OFFSET ?)
+		RETURNING search_word, search_count`, userIdentifier, maxRecords)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWordCounts(rows)
+}
+
+// MergeUserSearches moves the records of fromUser to toUser in one transaction,
+// the same way PostgresDBV2 does
+func (db *SQLiteDBV2) MergeUserSearches(ctx context.Context, fromUser, toUser string) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, user_identifier, search_word, first_searched_at, last_updated_at, search_count, surface_word
+		FROM user_searches WHERE user_identifier IN (?, ?)`, fromUser, toUser)
+	if err != nil {
+		return err
+	}
+	records, err := scanUserSearchRecords(rows)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_searches WHERE user_identifier IN (?, ?)`, fromUser, toUser); err != nil {
+		return err
+	}
+	for _, record := range mergeUserRecords(records, toUser) {
+		_, err := tx.ExecContext(ctx, `INSERT INTO user_searches (id, user_identifier, search_word, first_searched_at, last_updated_at, search_count, surface_word)
+			VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, ''))`,
+			record.ID, record.UserIdentifier, record.SearchWord, record.FirstSearchedAt.UTC(), record.LastUpdatedAt.UTC(), record.SearchCount, record.SurfaceWord)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// MergeUserWords merges the records of from of every user into their records of
+// to in one transaction, the same way PostgresDBV2 does
+func (db *SQLiteDBV2) MergeUserWords(ctx context.Context, from, to string) (int64, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, user_identifier, search_word, first_searched_at, last_updated_at, search_count, surface_word
+		FROM user_searches WHERE search_word IN (?, ?)`, from, to)
+	if err != nil {
+		return 0, err
+	}
+	records, err := scanUserSearchRecords(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	updates, deletes := mergeWordRecords(records, from, to)
+	for _, id := range deletes {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_searches WHERE id = ?`, id); err != nil {
+			return 0, err
+		}
+	}
+	for _, record := range updates {
+		_, err := tx.ExecContext(ctx, `UPDATE user_searches
+			SET search_word = ?, first_searched_at = ?, last_updated_at = ?, search_count = ?, surface_word = NULLIF(?, '')
+			WHERE id = ?`,
+			record.SearchWord, record.FirstSearchedAt.UTC(), record.LastUpdatedAt.UTC(), record.SearchCount, record.SurfaceWord, record.ID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return int64(len(updates)), tx.Commit()
+}
+
+// AdvanceUserClock raises the clock of the user in one transaction and returns its clock before
+func (db *SQLiteDBV2) AdvanceUserClock(ctx context.Context, userIdentifier string, clock UserClock) (UserClock, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return UserClock{}, err
+	}
+	defer tx.Rollback()
+
+	var previous UserClock
+	var sequence int64
+	err = tx.QueryRowContext(ctx, `SELECT client_at, sequence FROM user_clocks WHERE user_identifier = ?`,
+		userIdentifier).Scan(&previous.At, &sequence)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return UserClock{}, err
+	}
+	previous.At, previous.Sequence = previous.At.UTC(), uint64(sequence)
+
+	next := previous.merge(clock)
+	if _, err := tx.ExecContext(ctx, `INSERT INTO user_clocks (user_identifier, client_at, sequence) VALUES (?, ?, ?)
+		ON CONFLICT (user_identifier) DO UPDATE
+		SET client_at = max(user_clocks.client_at, excluded.client_at), sequence = max(user_clocks.sequence, excluded.sequence)`,
+		userIdentifier, next.At.UTC(), int64(next.Sequence)); err != nil {
+		return UserClock{}, err
+	}
+
+	return previous, tx.Commit()
+}
+
+// DeleteUserClock removes the clock of the user
+func (db *SQLiteDBV2) DeleteUserClock(ctx context.Context, userIdentifier string) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	_, err := db.db.ExecContext(ctx, `DELETE FROM user_clocks WHERE user_identifier = ?`, userIdentifier)
+	return err
+}
+
+// DeleteUserSearches removes every record of the user
+func (db *SQLiteDBV2) DeleteUserSearches(ctx context.Context, userIdentifier string) ([]WordCount, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `DELETE FROM user_searches WHERE user_identifier = ?
+		RETURNING search_word, search_count`, userIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWordCounts(rows)
+}
+
+// Ping checks the connection to the database
+func (db *SQLiteDBV2) Ping(ctx context.Context) error {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	return db.db.PingContext(ctx)
+}
+
+// Close closes the database
+func (db *SQLiteDBV2) Close() error {
+	return db.db.Close()
+}
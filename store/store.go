@@ -0,0 +1,406 @@
+// Package store defines the storage backends used by the search loggers:
+// SearchStore for the global trie based logger and UserSearchStore for the
+// per-user logger, with in-memory mocks and PostgreSQL implementations.
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// SearchStore is the storage backend used by trie.SearchLogger.
+// MockPostgresDB and PostgresDB implement it, and any other backend
+// (SQLite, Redis, ...) can be plugged in through trie.NewSearchLoggerWithDB.
+type SearchStore interface {
+	// CreateTable prepares the searches table, it must be idempotent
+	CreateTable(ctx context.Context) error
+	// InsertOrReplace inserts a word, or bumps the count of an already stored word, and returns its ID
+	InsertOrReplace(ctx context.Context, word string, firstSearched, lastUpdated time.Time) (int64, error)
+	// Update replaces the word of the record with the given ID
+	Update(ctx context.Context, id int64, newWord string, lastUpdated time.Time) error
+	// GetAllSearchedWords returns every stored word
+	GetAllSearchedWords(ctx context.Context) ([]string, error)
+	// Close releases the underlying connection
+	Close() error
+}
+
+// UserSearchStore is the storage backend used by logsearch.SearchLoggerV2.
+// MockPostgresDBV2 and PostgresDBV2 implement it, and any other backend
+// (SQLite, Redis, ...) can be plugged in through logsearch.NewSearchLoggerV2WithDB.
+type UserSearchStore interface {
+	// CreateTable prepares the user_searches table, it must be idempotent
+	CreateTable(ctx context.Context) error
+	// InsertOrUpdateUserSearch inserts a user's word, or bumps the count of an already stored one, and returns its ID
+	InsertOrUpdateUserSearch(ctx context.Context, userIdentifier, word string, firstSearched, lastUpdated time.Time) (int64, error)
+	// GetUserSearches returns every word stored for the user
+	GetUserSearches(ctx context.Context, userIdentifier string) ([]string, error)
+	// UpdateUserSearchByWord replaces oldWord with newWord, merging into an existing newWord record if any
+	UpdateUserSearchByWord(ctx context.Context, userIdentifier, oldWord, newWord string, lastUpdated time.Time) error
+	// Close releases the underlying connection
+	Close() error
+}
+
+// WordUpdate is a coalesced update of a searches record: the record is renamed
+// to Word and its search count grows by Count
+type WordUpdate struct {
+	ID            int64
+	Word          string
+	LastUpdatedAt time.Time
+	Count         int
+}
+
+// SearchRecordStore is a SearchStore that can read its full records
+type SearchRecordStore interface {
+	SearchStore
+	// GetAllRecords returns every record by ID, i.e. in insertion order
+	GetAllRecords(ctx context.Context) ([]SearchRecord, error)
+}
+
+// SearchCountStore is a SearchStore that can count its records without reading them
+type SearchCountStore interface {
+	SearchStore
+	// CountSearches returns the number of records and the sum of their search counts
+	CountSearches(ctx context.Context) (records, searches int64, err error)
+}
+
+// BatchSearchStore is a SearchStore that can write many records in one round trip
+type BatchSearchStore interface {
+	SearchStore
+	// InsertOrReplaceBatch behaves like InsertOrReplace for every word and returns their IDs in order
+	InsertOrReplaceBatch(ctx context.Context, words []string, firstSearched, lastUpdated time.Time) ([]int64, error)
+	// UpdateBatch applies every update, failing if a record does not exist
+	UpdateBatch(ctx context.Context, updates []WordUpdate) error
+}
+
+// UserSearchWrite is a coalesced write of a user_searches record. Count searches
+// are added to the user's Word record, and the records of ReplaceWords (shorter
+// prefixes the user extended) are merged into it, summing their counts and
+// keeping the earliest first_searched_at.
+type UserSearchWrite struct {
+	UserIdentifier  string
+	Word            string
+	ReplaceWords    []string
+	FirstSearchedAt time.Time
+	LastUpdatedAt   time.Time
+	Count           int
+	// SurfaceWord is the typed form when Word is a stem. Batches do not write
+	// it, the logger sets it with UserSurfaceStore.SetSurfaceWord afterwards.
+	SurfaceWord string
+	// Region is where the word was last searched. Batches do not write it
+	// either, the logger sets it with UserRegionStore.SetUserSearchRegion.
+	Region Region
+}
+
+// BatchUserSearchStore is a UserSearchStore that can apply many writes in one round trip
+type BatchUserSearchStore interface {
+	UserSearchStore
+	// ApplyUserSearchWrites applies every write atomically, a (user, word) pair
+	// must appear at most once as a Word in a batch. A store that cannot returns
+	// a *PartialWriteError when only the first writes were applied.
+	ApplyUserSearchWrites(ctx context.Context, writes []UserSearchWrite) error
+}
+
+// WordCount is a word and how many times it was searched
+type WordCount struct {
+	Word  string
+	Count int
+	// Score is the exact decayed count when the words are ranked by decay, 0 otherwise
+	Score float64
+	// Users is the approximate number of distinct users who searched the word
+	// when the words are ranked by audience, 0 otherwise
+	Users int
+}
+
+// TopSearchStore is implemented by stores that can rank words by search count.
+// The user_searches stores sum the counts of every user per word.
+type TopSearchStore interface {
+	// TopSearches returns the limit most searched words last updated at or after
+	// since, most searched first and ties in word order. A zero since covers all time.
+	TopSearches(ctx context.Context, since time.Time, limit int) ([]WordCount, error)
+}
+
+// UserDeleteStore is a UserSearchStore that can erase a user, e.g. for
+// right-to-be-forgotten requests
+type UserDeleteStore interface {
+	UserSearchStore
+	// DeleteUserSearches removes every record of the user and returns their
+	// words and counts, one per removed record
+	DeleteUserSearches(ctx context.Context, userIdentifier string) ([]WordCount, error)
+}
+
+// UserExportStore is a UserSearchStore that can read the full records of a user
+type UserExportStore interface {
+	UserSearchStore
+	// ForEachUserSearch calls fn with every record of the user in word order,
+	// stopping at and returning the first error of fn. The records may be
+	// streamed from an open query, so fn must not use the store.
+	ForEachUserSearch(ctx context.Context, userIdentifier string, fn func(UserSearchRecord) error) error
+}
+
+// UserSurfaceStore is a UserSearchStore keeping next to a stored word the form
+// the user typed for display, e.g. "running" for the stem "run"
+type UserSurfaceStore interface {
+	UserSearchStore
+	// SetSurfaceWord sets the surface form of the user's record of word, a missing record is left alone
+	SetSurfaceWord(ctx context.Context, userIdentifier, word, surface string) error
+}
+
+// UserMergeStore is a UserSearchStore that can stitch the history of one user
+// into another's, e.g. when a guest logs in
+type UserMergeStore interface {
+	UserSearchStore
+	// MergeUserSearches atomically moves every record of fromUser to toUser. Records of
+	// the same word are merged, and a word that is a prefix of a longer word of the
+	// combined history is merged into it, summing the counts and keeping the
+	// earliest first_searched_at.
+	MergeUserSearches(ctx context.Context, fromUser, toUser string) error
+}
+
+// VerifiedSearchStore is a SearchStore whose words can be reviewed by a
+// moderator, so that only verified words are suggested
+type VerifiedSearchStore interface {
+	SearchStore
+	// SetVerified marks or unmarks the record of word as verified, returning ErrWordNotFound wrapped if there is none
+	SetVerified(ctx context.Context, word string, verified bool) error
+	// GetVerifiedWords returns every verified word
+	GetVerifiedWords(ctx context.Context) ([]string, error)
+	// ListUnverified returns the limit most searched words awaiting review, most searched first and ties in word order
+	ListUnverified(ctx context.Context, limit int) ([]WordCount, error)
+}
+
+// SearchDisplayStore is a SearchStore keeping next to a stored word the form
+// it is displayed with, e.g. "iPhone" for "iphone"
+type SearchDisplayStore interface {
+	SearchStore
+	// SetDisplayWord sets the display form of the record of word, returning ErrWordNotFound wrapped if there is none
+	SetDisplayWord(ctx context.Context, word, display string) error
+	// GetDisplayWords returns the display form of every stored word that has one, by word
+	GetDisplayWords(ctx context.Context) (map[string]string, error)
+}
+
+// SearchMetadataStore is a SearchStore keeping a JSON payload of the
+// application next to a stored word, e.g. its category or its source
+type SearchMetadataStore interface {
+	SearchStore
+	// SetMetadata sets the payload of the record of word, clearing it when
+	// metadata is empty, returning ErrWordNotFound wrapped if there is none
+	SetMetadata(ctx context.Context, word string, metadata json.RawMessage) error
+	// GetMetadata returns the payload of every stored word that has one, by word
+	GetMetadata(ctx context.Context) (map[string]json.RawMessage, error)
+}
+
+// SearchCategoryStore is a SearchStore tagging its records with the
+// categories they were searched in, e.g. the search box of the products or
+// of the help center
+type SearchCategoryStore interface {
+	SearchStore
+	// TagCategories adds categories to the tags of the record of word, returning ErrWordNotFound wrapped if there is none
+	TagCategories(ctx context.Context, word string, categories []string) error
+	// GetCategories returns the categories of every tagged word, by word
+	GetCategories(ctx context.Context) (map[string][]string, error)
+	// TopSearchesInCategory is TopSearches restricted to the words tagged with category
+	TopSearchesInCategory(ctx context.Context, category string, since time.Time, limit int) ([]WordCount, error)
+}
+
+// SearchCurationStore is a SearchStore whose vocabulary an operator can
+// curate, e.g. merging "nyc" into "new york"
+type SearchCurationStore interface {
+	SearchStore
+	// RenameWord renames the record of oldWord and returns its ID, returning
+	// ErrWordNotFound wrapped if there is none and ErrWordExists wrapped if newWord is stored
+	RenameWord(ctx context.Context, oldWord, newWord string) (int64, error)
+	// MergeWords merges the record of from into the record of to, summing the
+	// counts, keeping the earliest first_searched_at, the latest last_updated_at
+	// and the verified flag of either, and returns the ID of the record of to.
+	// A missing to is renamed from from. It returns ErrWordNotFound wrapped if from is not stored.
+	MergeWords(ctx context.Context, from, to string) (int64, error)
+}
+
+// UserCurationStore is a UserSearchStore whose vocabulary an operator can
+// curate across all users
+type UserCurationStore interface {
+	UserSearchStore
+	// MergeUserWords atomically merges the record of from of every user into
+	// their record of to, like MergeUserSearches merges two records, renaming it
+	// for the users without one, and returns how many records of from were moved
+	MergeUserWords(ctx context.Context, from, to string) (int64, error)
+}
+
+// SearchPurgeStore is a SearchStore that can delete the words nobody searched for a while
+type SearchPurgeStore interface {
+	SearchStore
+	// PurgeSearches deletes the records last updated before cutoff and returns their words
+	PurgeSearches(ctx context.Context, cutoff time.Time) ([]string, error)
+}
+
+// UserSearchPurgeStore is a UserSearchStore that can delete the searches nobody repeated for a while
+type UserSearchPurgeStore interface {
+	UserSearchStore
+	// PurgeUserSearches deletes the records last updated before cutoff and
+	// returns their words and counts, one per deleted record
+	PurgeUserSearches(ctx context.Context, cutoff time.Time) ([]WordCount, error)
+}
+
+// UserQuotaStore is a UserSearchStore that can cap the records of a user
+type UserQuotaStore interface {
+	UserSearchStore
+	// TrimUserSearches keeps the maxRecords most recently updated records of the
+	// user, deleting the others, ties broken by ID, and returns their words and
+	// counts, one per deleted record
+	TrimUserSearches(ctx context.Context, userIdentifier string, maxRecords int) ([]WordCount, error)
+}
+
+// UserLockStore is a UserSearchStore that can lock a user across the
+// processes sharing it, so their read-then-write consolidations of the same
+// user do not interleave
+type UserLockStore interface {
+	UserSearchStore
+	// WithUserLock runs fn while holding the lock of the user, waiting for its
+	// holder meanwhile, and returns the error of fn
+	WithUserLock(ctx context.Context, userIdentifier string, fn func(ctx context.Context) error) error
+}
+
+// UserConsolidation is the outcome of a ConsolidateUserSearch, neither
+// field set when the word was inserted or counted once more
+type UserConsolidation struct {
+	// Extended is the stored prefix of the word renamed to it
+	Extended string
+	// Extension is the stored word the word is a strict prefix of, the word was ignored
+	Extension string
+}
+
+// UserConsolidateStore is a UserSearchStore that can consolidate a search of
+// a user against their stored words in one atomic write, rather than the
+// logger reading them and then writing its decision
+type UserConsolidateStore interface {
+	UserSearchStore
+	// ConsolidateUserSearch renames the longest stored strict prefix of word to
+	// word, like UpdateUserSearchByWord, ignores word when it is the strict
+	// prefix of a stored word, or else behaves like InsertOrUpdateUserSearch
+	ConsolidateUserSearch(ctx context.Context, userIdentifier, word string, at time.Time) (UserConsolidation, error)
+}
+
+// Pinger is a store that can check its connection, for readiness probes
+type Pinger interface {
+	// Ping returns an error when the store cannot be reached
+	Ping(ctx context.Context) error
+}
+
+var (
+	_ AnalyticsStore       = (*MockPostgresDBV2)(nil)
+	_ AnalyticsStore       = (*PostgresDBV2)(nil)
+	_ AnalyticsStore       = (*SQLiteDBV2)(nil)
+	_ AnalyticsStore       = (*RedisDBV2)(nil)
+	_ BatchSearchStore     = (*MockPostgresDB)(nil)
+	_ BatchSearchStore     = (*PostgresDB)(nil)
+	_ BatchUserSearchStore = (*MockPostgresDBV2)(nil)
+	_ BatchUserSearchStore = (*PostgresDBV2)(nil)
+	_ BatchSearchStore     = (*SQLiteDB)(nil)
+	_ BatchUserSearchStore = (*SQLiteDBV2)(nil)
+	_ BatchUserSearchStore = (*RedisDBV2)(nil)
+	_ TopSearchStore       = (*MockPostgresDB)(nil)
+	_ TopSearchStore       = (*PostgresDB)(nil)
+	_ TopSearchStore       = (*MockPostgresDBV2)(nil)
+	_ TopSearchStore       = (*PostgresDBV2)(nil)
+	_ TopSearchStore       = (*SQLiteDB)(nil)
+	_ TopSearchStore       = (*SQLiteDBV2)(nil)
+	_ TopSearchStore       = (*RedisDBV2)(nil)
+	_ UserDeleteStore      = (*MockPostgresDBV2)(nil)
+	_ UserDeleteStore      = (*PostgresDBV2)(nil)
+	_ UserDeleteStore      = (*SQLiteDBV2)(nil)
+	_ UserDeleteStore      = (*RedisDBV2)(nil)
+	_ UserExportStore      = (*MockPostgresDBV2)(nil)
+	_ UserExportStore      = (*PostgresDBV2)(nil)
+	_ UserExportStore      = (*SQLiteDBV2)(nil)
+	_ UserExportStore      = (*RedisDBV2)(nil)
+	_ UserRegionStore      = (*MockPostgresDBV2)(nil)
+	_ UserRegionStore      = (*PostgresDBV2)(nil)
+	_ UserRegionStore      = (*SQLiteDBV2)(nil)
+	_ UserSurfaceStore     = (*MockPostgresDBV2)(nil)
+	_ UserSurfaceStore     = (*PostgresDBV2)(nil)
+	_ UserSurfaceStore     = (*SQLiteDBV2)(nil)
+	_ UserSurfaceStore     = (*RedisDBV2)(nil)
+	_ SearchDisplayStore   = (*MockPostgresDB)(nil)
+	_ SearchDisplayStore   = (*PostgresDB)(nil)
+	_ SearchDisplayStore   = (*SQLiteDB)(nil)
+	_ SearchMetadataStore  = (*MockPostgresDB)(nil)
+	_ SearchMetadataStore  = (*PostgresDB)(nil)
+	_ SearchMetadataStore  = (*SQLiteDB)(nil)
+	_ SearchCategoryStore  = (*MockPostgresDB)(nil)
+	_ SearchCategoryStore  = (*PostgresDB)(nil)
+	_ SearchCategoryStore  = (*SQLiteDB)(nil)
+	_ VerifiedSearchStore  = (*MockPostgresDB)(nil)
+	_ VerifiedSearchStore  = (*PostgresDB)(nil)
+	_ VerifiedSearchStore  = (*SQLiteDB)(nil)
+	_ QuerySearchStore     = (*MockPostgresDB)(nil)
+	_ QuerySearchStore     = (*PostgresDB)(nil)
+	_ QuerySearchStore     = (*SQLiteDB)(nil)
+	_ HourlySearchStore    = (*MockPostgresDBV2)(nil)
+	_ HourlySearchStore    = (*PostgresDBV2)(nil)
+	_ HourlySearchStore    = (*SQLiteDBV2)(nil)
+	_ HourlySearchStore    = (*RedisDBV2)(nil)
+	_ QuarantineStore      = (*MockPostgresDBV2)(nil)
+	_ QuarantineStore      = (*PostgresDBV2)(nil)
+	_ QuarantineStore      = (*SQLiteDBV2)(nil)
+	_ SearchClickStore     = (*MockPostgresDBV2)(nil)
+	_ SearchClickStore     = (*PostgresDBV2)(nil)
+	_ SearchClickStore     = (*SQLiteDBV2)(nil)
+	_ ZeroResultStore      = (*MockPostgresDBV2)(nil)
+	_ ZeroResultStore      = (*PostgresDBV2)(nil)
+	_ ZeroResultStore      = (*SQLiteDBV2)(nil)
+	_ SynonymStore         = (*MockPostgresDBV2)(nil)
+	_ SynonymStore         = (*PostgresDBV2)(nil)
+	_ SynonymStore         = (*SQLiteDBV2)(nil)
+	_ RollupStore          = (*MockPostgresDBV2)(nil)
+	_ RollupStore          = (*PostgresDBV2)(nil)
+	_ RollupStore          = (*SQLiteDBV2)(nil)
+	_ RollupStore          = (*RedisDBV2)(nil)
+	_ SearchExportStore    = (*MockPostgresDB)(nil)
+	_ SearchExportStore    = (*PostgresDB)(nil)
+	_ SearchExportStore    = (*SQLiteDB)(nil)
+	_ SearchRecordStore    = (*MockPostgresDB)(nil)
+	_ SearchRecordStore    = (*PostgresDB)(nil)
+	_ SearchRecordStore    = (*SQLiteDB)(nil)
+	_ SearchCurationStore  = (*MockPostgresDB)(nil)
+	_ SearchCurationStore  = (*PostgresDB)(nil)
+	_ SearchCurationStore  = (*SQLiteDB)(nil)
+	_ UserCurationStore    = (*MockPostgresDBV2)(nil)
+	_ UserCurationStore    = (*PostgresDBV2)(nil)
+	_ UserCurationStore    = (*SQLiteDBV2)(nil)
+	_ UserMergeStore       = (*MockPostgresDBV2)(nil)
+	_ UserMergeStore       = (*PostgresDBV2)(nil)
+	_ UserMergeStore       = (*SQLiteDBV2)(nil)
+	_ SearchPurgeStore     = (*MockPostgresDB)(nil)
+	_ SearchPurgeStore     = (*PostgresDB)(nil)
+	_ SearchPurgeStore     = (*SQLiteDB)(nil)
+	_ UserSearchPurgeStore = (*MockPostgresDBV2)(nil)
+	_ UserSearchPurgeStore = (*PostgresDBV2)(nil)
+	_ UserSearchPurgeStore = (*SQLiteDBV2)(nil)
+	_ UserLockStore        = (*MockPostgresDBV2)(nil)
+	_ UserLockStore        = (*PostgresDBV2)(nil)
+	_ UserConsolidateStore = (*MockPostgresDBV2)(nil)
+	_ UserConsolidateStore = (*PostgresDBV2)(nil)
+	_ AudienceStore        = (*MockPostgresDBV2)(nil)
+	_ AudienceStore        = (*PostgresDBV2)(nil)
+	_ AudienceStore        = (*SQLiteDBV2)(nil)
+	_ UserClockStore       = (*MockPostgresDBV2)(nil)
+	_ UserClockStore       = (*PostgresDBV2)(nil)
+	_ UserClockStore       = (*SQLiteDBV2)(nil)
+	_ AuditStore           = (*MockPostgresDBV2)(nil)
+	_ AuditStore           = (*PostgresDBV2)(nil)
+	_ AuditStore           = (*SQLiteDBV2)(nil)
+	_ UserQuotaStore       = (*MockPostgresDBV2)(nil)
+	_ UserQuotaStore       = (*PostgresDBV2)(nil)
+	_ UserQuotaStore       = (*SQLiteDBV2)(nil)
+	_ SearchCountStore     = (*MockPostgresDB)(nil)
+	_ SearchCountStore     = (*PostgresDB)(nil)
+	_ SearchCountStore     = (*SQLiteDB)(nil)
+	_ Pinger               = (*MockPostgresDB)(nil)
+	_ Pinger               = (*MockPostgresDBV2)(nil)
+	_ Pinger               = (*PostgresDB)(nil)
+	_ Pinger               = (*PostgresDBV2)(nil)
+	_ Pinger               = (*SQLiteDB)(nil)
+	_ Pinger               = (*SQLiteDBV2)(nil)
+	_ Pinger               = (*RedisDBV2)(nil)
+)
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Synonym is a row of the search_synonyms table: an alias of a canonical
+// word, e.g. "tv" of "television"
+type Synonym struct {
+	Alias     string
+	Canonical string
+}
+
+// SynonymStore is a UserSearchStore that persists the aliases of the words
+// in the search_synonyms table
+type SynonymStore interface {
+	UserSearchStore
+	// PutSynonym maps alias to canonical, replacing its previous canonical word
+	PutSynonym(ctx context.Context, alias, canonical string) error
+	// DeleteSynonym deletes the alias and reports whether it existed
+	DeleteSynonym(ctx context.Context, alias string) (bool, error)
+	// ListSynonyms returns every alias in alphabetical order
+	ListSynonyms(ctx context.Context) ([]Synonym, error)
+}
+
+// scanSynonyms reads the rows of a search_synonyms query
+func scanSynonyms(rows *sql.Rows, err error) ([]Synonym, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	synonyms := make([]Synonym, 0)
+	for rows.Next() {
+		var synonym Synonym
+		if err := rows.Scan(&synonym.Alias, &synonym.Canonical); err != nil {
+			return nil, err
+		}
+		synonyms = append(synonyms, synonym)
+	}
+	return synonyms, rows.Err()
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSynonyms(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		open func(t *testing.T) SynonymStore
+	}{
+		{"mock", func(t *testing.T) SynonymStore {
+			return NewMockPostgresDBV2()
+		}},
+		{"sqlite", func(t *testing.T) SynonymStore {
+			db, err := NewSQLiteDBV2(sqliteConfig(t))
+			require.NoError(t, err)
+			t.Cleanup(func() { db.Close() })
+			return db
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := tt.open(t)
+			require.NoError(t, db.CreateTable(ctx))
+
+			synonyms, err := db.ListSynonyms(ctx)
+			require.NoError(t, err)
+			assert.Empty(t, synonyms)
+
+			require.NoError(t, db.PutSynonym(ctx, "tv", "telly"))
+			require.NoError(t, db.PutSynonym(ctx, "nyc", "new york"))
+			require.NoError(t, db.PutSynonym(ctx, "tv", "television"))
+			synonyms, err = db.ListSynonyms(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, []Synonym{{Alias: "nyc", Canonical: "new york"}, {Alias: "tv", Canonical: "television"}}, synonyms)
+
+			deleted, err := db.DeleteSynonym(ctx, "nyc")
+			require.NoError(t, err)
+			assert.True(t, deleted)
+			deleted, err = db.DeleteSynonym(ctx, "nyc")
+			require.NoError(t, err)
+			assert.False(t, deleted)
+			synonyms, err = db.ListSynonyms(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, []Synonym{{Alias: "tv", Canonical: "television"}}, synonyms)
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// ZeroResultTerm is a row of the zero_result_terms table: a word whose latest
+// searches returned no result, a gap of the searched content
+type ZeroResultTerm struct {
+	Word string
+	// Streak is how many searches in a row returned no result, the latest included
+	Streak int
+	// FirstZeroAt and LastZeroAt are the first and the latest search of the streak
+	FirstZeroAt time.Time
+	LastZeroAt  time.Time
+}
+
+// ZeroResultStore is a UserSearchStore that tracks the words returning no
+// result in the zero_result_terms table, over every user
+type ZeroResultStore interface {
+	UserSearchStore
+	// RecordSearchResults records how many results a search of word at at
+	// returned: none extends the streak of the word, any other count ends it
+	RecordSearchResults(ctx context.Context, word string, results int, at time.Time) error
+	// ZeroResultTerms returns up to limit words with a streak of at least
+	// minStreak, longest streak first, ties by latest search first
+	ZeroResultTerms(ctx context.Context, minStreak, limit int) ([]ZeroResultTerm, error)
+}
+
+// scanZeroResultTerms reads the rows of a zero_result_terms query
+func scanZeroResultTerms(rows *sql.Rows, err error) ([]ZeroResultTerm, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	terms := make([]ZeroResultTerm, 0)
+	for rows.Next() {
+		var term ZeroResultTerm
+		if err := rows.Scan(&term.Word, &term.Streak, &term.FirstZeroAt, &term.LastZeroAt); err != nil {
+			return nil, err
+		}
+		term.FirstZeroAt, term.LastZeroAt = term.FirstZeroAt.UTC(), term.LastZeroAt.UTC()
+		terms = append(terms, term)
+	}
+	return terms, rows.Err()
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZeroResultTerms(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		open func(t *testing.T) ZeroResultStore
+	}{
+		{"mock", func(t *testing.T) ZeroResultStore {
+			return NewMockPostgresDBV2()
+		}},
+		{"sqlite", func(t *testing.T) ZeroResultStore {
+			db, err := NewSQLiteDBV2(sqliteConfig(t))
+			require.NoError(t, err)
+			t.Cleanup(func() { db.Close() })
+			return db
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := tt.open(t)
+			require.NoError(t, db.CreateTable(ctx))
+
+			at := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+			for i, search := range []struct {
+				word    string
+				results int
+			}{
+				{"blue sofa", 0}, {"red sofa", 0}, {"blue sofa", 0}, {"bus", 0}, {"bus", 12}, {"cat", 0}, {"blue sofa", 0},
+			} {
+				require.NoError(t, db.RecordSearchResults(ctx, search.word, search.results, at.Add(time.Duration(i)*time.Minute)))
+			}
+
+			terms, err := db.ZeroResultTerms(ctx, 1, 10)
+			require.NoError(t, err)
+			assert.Equal(t, []ZeroResultTerm{
+				{Word: "blue sofa", Streak: 3, FirstZeroAt: at, LastZeroAt: at.Add(6 * time.Minute)},
+				{Word: "cat", Streak: 1, FirstZeroAt: at.Add(5 * time.Minute), LastZeroAt: at.Add(5 * time.Minute)},
+				{Word: "red sofa", Streak: 1, FirstZeroAt: at.Add(time.Minute), LastZeroAt: at.Add(time.Minute)},
+			}, terms, "A search with results ends the streak")
+
+			terms, err = db.ZeroResultTerms(ctx, 2, 10)
+			require.NoError(t, err)
+			require.Len(t, terms, 1)
+			assert.Equal(t, "blue sofa", terms[0].Word)
+
+			terms, err = db.ZeroResultTerms(ctx, 1, 2)
+			require.NoError(t, err)
+			assert.Len(t, terms, 2)
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package logsearch
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/afanwang/logsearch/metrics"
+	"github.com/afanwang/logsearch/store"
+)
+
+// LogSearchSubmitted logs a search the user submitted, e.g. by pressing
+// Enter, rather than a keystroke. It runs through the ingest chain like
+// LogSearchV2, then bypasses the FinalizePolicy and the consolidation: the
+// pending keystrokes of the search are dropped, and the word is stored right
+// away as searched once more, even when a stored word of the user extends it,
+// e.g. "bus" submitted after "business" was stored. The keystrokes keep the
+// consolidation, so a stored prefix is still extended by the next ones.
+func (sl *SearchLoggerV2) LogSearchSubmitted(ctx context.Context, userIdentifier, word string) error {
+	return sl.LogSearchEvent(ctx, SearchEvent{UserIdentifier: userIdentifier, Query: word, Submitted: true})
+}
+
+// submit stores the submitted search of word received at now in region, counting one more search of it,
+// and records its result count when known
+func (sl *SearchLoggerV2) submit(ctx context.Context, userIdentifier, word string, now time.Time, region store.Region, results *int) error {
+	if ok, err := sl.admitted(ctx, userIdentifier, word, now); !ok {
+		return err
+	}
+
+	surface := word
+	word = sl.stem(sl.synonyms.foldQuery(word))
+	sl.decided(ctx, metrics.DecisionSubmitted)
+	fmt.Fprintf(sl.out, " (submitted)")
+	sl.recordResults(ctx, word, results, now)
+
+	if b := sl.buffer; b != nil {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+
+		b.insert(userIdentifier, word, now)
+		b.setSurface(userIdentifier, word, surface)
+		b.setRegion(userIdentifier, word, region)
+		sl.counted(word, 1, now)
+		if b.size >= b.maxSize {
+			return sl.flushLocked(ctx)
+		}
+		return nil
+	}
+
+	err := sl.lockUser(ctx, userIdentifier, func(ctx context.Context) error {
+		if err := sl.insertUserSearch(ctx, userIdentifier, word, now); err != nil {
+			return err
+		}
+		sl.setSurface(ctx, userIdentifier, word, surface)
+		sl.setRegion(ctx, userIdentifier, word, region)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store submitted search: %w", store.Classify(err))
+	}
+	return nil
+}
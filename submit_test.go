@@ -0,0 +1,75 @@
+package logsearch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchLoggerV2_LogSearchSubmitted(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts []Option
+	}{
+		{"direct", nil},
+		{"buffered", []Option{WithWriteBuffer(100, time.Hour)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := store.NewMockPostgresDBV2()
+			logger, err := NewSearchLoggerV2WithDB(db, tt.opts...)
+			require.NoError(t, err)
+			defer logger.Close()
+
+			for _, word := range []string{"b", "bu", "bus", "busi", "business"} {
+				require.NoError(t, logger.LogSearchV2(ctx, "user_1", word))
+			}
+			// A keystroke prefix of a stored word is ignored, a submitted one is stored
+			require.NoError(t, logger.LogSearchV2(ctx, "user_1", "bus"))
+			require.NoError(t, logger.LogSearchSubmitted(ctx, "user_1", "Bus"))
+			require.NoError(t, logger.LogSearchSubmitted(ctx, "user_1", "bus"))
+			require.NoError(t, logger.Flush(ctx))
+
+			assert.Equal(t, 2, searchCount(t, logger, "user_1", "bus"), "Every submission counts")
+
+			// The keystrokes after a submission consolidate as usual
+			require.NoError(t, logger.LogSearchV2(ctx, "user_1", "bus s"))
+			require.NoError(t, logger.Flush(ctx))
+			searches, err := logger.GetUserSearches(ctx, "user_1")
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{"bus s", "business"}, searches)
+
+			assert.ErrorIs(t, logger.LogSearchSubmitted(ctx, "user_1", " "), ErrEmptyWord)
+		})
+	}
+}
+
+func TestSearchLoggerV2_LogSearchSubmittedPending(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDBV2()
+	logger, err := NewSearchLoggerV2WithDB(db, WithFinalizeTimeout(time.Hour), WithFilters(MinLength(3)))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	for _, word := range []string{"t", "tr", "tra", "train"} {
+		require.NoError(t, logger.LogSearchV2(ctx, "user_1", word))
+	}
+	require.NoError(t, logger.LogSearchV2(ctx, "user_1", "cat"))
+
+	// Stored without waiting for the timeout, the pending keystrokes of the search are dropped
+	require.NoError(t, logger.LogSearchSubmitted(ctx, "user_1", "tram"))
+	searches, err := db.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tram"}, searches)
+
+	// The filters still apply, a pending word extending the submitted one is a draft left behind
+	require.NoError(t, logger.LogSearchSubmitted(ctx, "user_1", "tr"))
+	require.NoError(t, logger.Flush(ctx))
+	searches, err = db.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"cat", "tram"}, searches)
+}
@@ -0,0 +1,59 @@
+package logsearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/afanwang/logsearch/ngram"
+	"github.com/afanwang/logsearch/store"
+)
+
+// WithSubstringSearch indexes the stored words by their trigrams for
+// GetTermsContaining, e.g. for the admin dashboards. The stored words are
+// loaded on startup, then like WithSpellCorrection each search counts once
+// under the longest word it was extended to over all users. The store must
+// implement store.TopSearchStore.
+func WithSubstringSearch() Option {
+	return func(sl *SearchLoggerV2) {
+		sl.terms = ngram.New()
+	}
+}
+
+// loadTerms indexes the stored words of every user on startup
+func (sl *SearchLoggerV2) loadTerms(ctx context.Context) error {
+	if sl.terms == nil {
+		return nil
+	}
+
+	counts, err := sl.db.(store.TopSearchStore).TopSearches(ctx, time.Time{}, math.MaxInt32)
+	if err != nil {
+		return fmt.Errorf("failed to load the stored words: %w", store.Classify(err))
+	}
+	for _, count := range counts {
+		sl.terms.Add(count.Word, int64(count.Count))
+	}
+	return nil
+}
+
+// GetTermsContaining returns up to limit stored words containing substring
+// over all users, most searched first, e.g. "iphone case" and "headphones"
+// for "phone". substring is normalized like a search.
+func (sl *SearchLoggerV2) GetTermsContaining(substring string, limit int) ([]store.WordCount, error) {
+	if sl.terms == nil {
+		return nil, errors.New("substring search is not enabled")
+	}
+	substring = sl.normalizer.Normalize(substring)
+	if substring == "" {
+		return nil, ErrEmptyWord
+	}
+
+	terms := sl.terms.Containing(substring, limit)
+	counts := make([]store.WordCount, len(terms))
+	for i, term := range terms {
+		counts[i] = store.WordCount{Word: term.Word, Count: int(term.Count)}
+	}
+	return counts, nil
+}
@@ -0,0 +1,58 @@
+package logsearch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchLoggerV2_GetTermsContaining(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDBV2()
+	require.NoError(t, db.CreateTable(ctx))
+	at := time.Now()
+	for _, word := range []string{"headphones", "headphones", "phone"} {
+		_, err := db.InsertOrUpdateUserSearch(ctx, "user_1", word, at, at)
+		require.NoError(t, err)
+	}
+
+	logger, err := NewSearchLoggerV2WithDB(db, WithSubstringSearch())
+	require.NoError(t, err)
+	defer logger.Close()
+
+	terms, err := logger.GetTermsContaining("phone", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []store.WordCount{{Word: "headphones", Count: 2}, {Word: "phone", Count: 1}}, terms, "The stored words are loaded on startup")
+
+	for _, word := range []string{"iph", "iphone case"} {
+		require.NoError(t, logger.LogSearchV2(ctx, "user_2", word))
+	}
+	terms, err = logger.GetTermsContaining(" PHONE", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []store.WordCount{{Word: "headphones", Count: 2}, {Word: "iphone case", Count: 1}, {Word: "phone", Count: 1}}, terms)
+
+	terms, err = logger.GetTermsContaining("iph", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []store.WordCount{{Word: "iphone case", Count: 1}}, terms, "The extended prefix is no longer a term")
+
+	require.NoError(t, logger.MergeWords(ctx, "phone", "headphones"))
+	terms, err = logger.GetTermsContaining("phone", 1)
+	require.NoError(t, err)
+	assert.Equal(t, []store.WordCount{{Word: "headphones", Count: 3}}, terms)
+
+	_, err = logger.GetTermsContaining(" ", 10)
+	assert.ErrorIs(t, err, ErrEmptyWord)
+
+	counting := &countingStore{UserSearchStore: store.NewMockPostgresDBV2()}
+	_, err = NewSearchLoggerV2WithDB(counting, WithSubstringSearch())
+	assert.Error(t, err, "Stores without top searches should be rejected")
+	plain, err := NewSearchLoggerV2WithDB(counting)
+	require.NoError(t, err)
+	defer plain.Close()
+	_, err = plain.GetTermsContaining("phone", 10)
+	assert.Error(t, err)
+}
@@ -0,0 +1,168 @@
+package logsearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// WithSynonyms manages a table of aliases of words, e.g. "tv" of
+// "television", persisted in the store and loaded on startup, see
+// AddSynonym. SuggestForUser expands the aliases of a prefix, so "tv st" also
+// completes to the stored "television stand". With fold, every word of a
+// finished search that is an alias is also replaced by its canonical word, so
+// "tv stand" is stored as "television stand". A search is finished when the
+// FinalizePolicy finalizes it, or when it is submitted or clicked: without a
+// policy, the keystrokes are stored as typed. The store must implement
+// store.SynonymStore.
+func WithSynonyms(fold bool) Option {
+	return func(sl *SearchLoggerV2) {
+		sl.synonyms = &synonyms{canonical: make(map[string]string), fold: fold}
+	}
+}
+
+// synonyms is the alias table of WithSynonyms, nil when disabled
+type synonyms struct {
+	mutex sync.RWMutex
+	// canonical is the canonical word of every alias
+	canonical map[string]string
+	// fold replaces the aliases of the logged searches
+	fold bool
+}
+
+// replace returns text with every word that is an alias replaced by its
+// canonical word, once: the alias of a canonical word is not followed
+func (s *synonyms) replace(text string) string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if len(s.canonical) == 0 {
+		return text
+	}
+	words := strings.Split(text, " ")
+	replaced := false
+	for i, word := range words {
+		if canonical, ok := s.canonical[word]; ok {
+			words[i] = canonical
+			replaced = true
+		}
+	}
+	if !replaced {
+		return text
+	}
+	return strings.Join(words, " ")
+}
+
+// foldQuery replaces the aliases of a finished search with
+// WithSynonyms(true), when it is finalized, submitted or clicked. A search
+// being typed is never folded, its last word may be the prefix of a longer
+// one, e.g. "ny" of "nylon".
+func (s *synonyms) foldQuery(query string) string {
+	if s == nil || !s.fold {
+		return query
+	}
+	return s.replace(query)
+}
+
+// expand returns prefix, followed by prefix with its aliases replaced when it has any
+func (s *synonyms) expand(prefix string) []string {
+	if s == nil {
+		return []string{prefix}
+	}
+	if expanded := s.replace(prefix); expanded != prefix {
+		return []string{prefix, expanded}
+	}
+	return []string{prefix}
+}
+
+// loadSynonyms reads the alias table from the store on startup
+func (sl *SearchLoggerV2) loadSynonyms(ctx context.Context) error {
+	if sl.synonyms == nil {
+		return nil
+	}
+
+	synonyms, err := sl.db.(store.SynonymStore).ListSynonyms(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load synonyms: %w", store.Classify(err))
+	}
+	sl.synonyms.mutex.Lock()
+	defer sl.synonyms.mutex.Unlock()
+	for _, synonym := range synonyms {
+		sl.synonyms.canonical[synonym.Alias] = synonym.Canonical
+	}
+	return nil
+}
+
+// AddSynonym makes the word alias an alias of canonical, replacing its
+// previous canonical word, both normalized. An alias must be a single word,
+// canonical may be several, e.g. "nyc" of "new york". It applies to the
+// searches logged from now on, use MergeWords to fold the stored ones. Other
+// instances load it on startup.
+func (sl *SearchLoggerV2) AddSynonym(ctx context.Context, alias, canonical string) error {
+	if sl.synonyms == nil {
+		return errors.New("synonyms are not enabled")
+	}
+	alias, canonical = sl.normalizer.Normalize(alias), sl.normalizer.Normalize(canonical)
+	if alias == "" || canonical == "" {
+		return ErrEmptyWord
+	}
+	if strings.Contains(alias, " ") {
+		return fmt.Errorf("%w: alias %q is not a single word", ErrInvalidInput, alias)
+	}
+	if alias == canonical {
+		return fmt.Errorf("%w: %q cannot be an alias of itself", ErrInvalidInput, alias)
+	}
+
+	if err := sl.db.(store.SynonymStore).PutSynonym(ctx, alias, canonical); err != nil {
+		return fmt.Errorf("failed to add synonym '%s': %w", alias, store.Classify(err))
+	}
+	sl.synonyms.mutex.Lock()
+	previous := sl.synonyms.canonical[alias]
+	sl.synonyms.canonical[alias] = canonical
+	sl.synonyms.mutex.Unlock()
+	// The cached suggestions of any user may complete the alias
+	sl.clearResults(ctx)
+	sl.audit(ctx, "add-synonym", alias, previous, canonical)
+	return nil
+}
+
+// RemoveSynonym removes the alias added by AddSynonym. It returns
+// ErrWordNotFound wrapped when alias is not an alias.
+func (sl *SearchLoggerV2) RemoveSynonym(ctx context.Context, alias string) error {
+	if sl.synonyms == nil {
+		return errors.New("synonyms are not enabled")
+	}
+	alias = sl.normalizer.Normalize(alias)
+	if alias == "" {
+		return ErrEmptyWord
+	}
+
+	deleted, err := sl.db.(store.SynonymStore).DeleteSynonym(ctx, alias)
+	if err != nil {
+		return fmt.Errorf("failed to remove synonym '%s': %w", alias, store.Classify(err))
+	}
+	sl.synonyms.mutex.Lock()
+	previous := sl.synonyms.canonical[alias]
+	delete(sl.synonyms.canonical, alias)
+	sl.synonyms.mutex.Unlock()
+	if !deleted {
+		return fmt.Errorf("%w: %q is not an alias", ErrWordNotFound, alias)
+	}
+	sl.clearResults(ctx)
+	sl.audit(ctx, "remove-synonym", alias, previous, "")
+	return nil
+}
+
+// GetSynonyms returns every alias with its canonical word, in alphabetical order of the aliases
+func (sl *SearchLoggerV2) GetSynonyms(ctx context.Context) ([]store.Synonym, error) {
+	if sl.synonyms == nil {
+		return nil, errors.New("synonyms are not enabled")
+	}
+
+	synonyms, err := sl.db.(store.SynonymStore).ListSynonyms(ctx)
+	return synonyms, store.Classify(err)
+}
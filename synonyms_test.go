@@ -0,0 +1,91 @@
+package logsearch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchLoggerV2_Synonyms(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDBV2()
+	logger, err := NewSearchLoggerV2WithDB(db, WithSynonyms(true), WithGlobalSuggestions(fixedSuggester{"telescope", "television"}, 0.5),
+		WithFinalizePolicy(FinalizePolicy{Idle: time.Hour}))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	require.NoError(t, logger.AddSynonym(ctx, "TV", "telly"))
+	require.NoError(t, logger.AddSynonym(ctx, "tv", "Television"))
+	require.NoError(t, logger.AddSynonym(ctx, "nyc", "new york"))
+	synonyms, err := logger.GetSynonyms(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []store.Synonym{{Alias: "nyc", Canonical: "new york"}, {Alias: "tv", Canonical: "television"}}, synonyms)
+
+	// Folded once finished, every word of the search
+	for _, word := range []string{"t", "tv", "tv ", "tv s", "tv stand"} {
+		require.NoError(t, logger.LogSearchV2(ctx, "user_1", word))
+	}
+	require.NoError(t, logger.Finalize(ctx, "user_1", "tv stand"))
+	require.NoError(t, logger.LogSearchSubmitted(ctx, "user_1", "hotels nyc"))
+	searches, err := logger.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"television stand", "hotels new york"}, searches)
+
+	// A prefix of a longer word is never folded while typing
+	for _, word := range []string{"n", "ny", "nyl", "nylo", "nylon"} {
+		require.NoError(t, logger.LogSearchV2(ctx, "user_4", word))
+	}
+	require.NoError(t, logger.Finalize(ctx, "user_4", "nylon"))
+	searches, err = logger.GetUserSearches(ctx, "user_4")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"nylon"}, searches)
+	require.NoError(t, logger.LogSearchV2(ctx, "user_5", "nyc"))
+	require.NoError(t, logger.Finalize(ctx, "user_5", "nyc"))
+	searches, err = logger.GetUserSearches(ctx, "user_5")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"new york"}, searches, "The finished alias is folded")
+
+	// Expanded at suggest, for the user's searches and the global completions
+	suggestions, err := logger.SuggestForUser(ctx, "user_1", "tv", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"television", "television stand"}, suggestions)
+	suggestions, err = logger.SuggestForUser(ctx, "user_1", "te", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"telescope", "television stand", "television"}, suggestions)
+
+	// Loaded on startup
+	reloaded, err := NewSearchLoggerV2WithDB(db, WithSynonyms(false))
+	require.NoError(t, err)
+	defer reloaded.Close()
+	require.NoError(t, reloaded.LogSearchV2(ctx, "user_2", "tv"))
+	require.NoError(t, reloaded.LogSearchV2(ctx, "user_2", "television"))
+	searches, err = reloaded.GetUserSearches(ctx, "user_2")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"tv", "television"}, searches, "Without fold the aliases are stored as searched")
+	suggestions, err = reloaded.SuggestForUser(ctx, "user_2", "tv", 10)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"tv", "television"}, suggestions)
+
+	require.NoError(t, logger.RemoveSynonym(ctx, "tv"))
+	assert.ErrorIs(t, logger.RemoveSynonym(ctx, "tv"), ErrWordNotFound)
+	require.NoError(t, logger.LogSearchSubmitted(ctx, "user_3", "tv"))
+	searches, err = logger.GetUserSearches(ctx, "user_3")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tv"}, searches)
+
+	assert.ErrorIs(t, logger.AddSynonym(ctx, "big apple", "new york"), ErrInvalidInput)
+	assert.ErrorIs(t, logger.AddSynonym(ctx, "nyc", "NYC"), ErrInvalidInput)
+	assert.ErrorIs(t, logger.AddSynonym(ctx, " ", "new york"), ErrEmptyWord)
+
+	plain, err := NewSearchLoggerV2()
+	require.NoError(t, err)
+	defer plain.Close()
+	assert.Error(t, plain.AddSynonym(ctx, "tv", "television"), "Synonyms are not enabled")
+
+	_, err = NewSearchLoggerV2WithDB(&countingStore{UserSearchStore: store.NewMockPostgresDBV2()}, WithSynonyms(true))
+	assert.Error(t, err, "Stores without synonyms should be rejected")
+}
@@ -0,0 +1,99 @@
+// Package tracing instruments the search loggers with OpenTelemetry spans, so
+// a slow search can be followed from the HTTP request through the dedup logic
+// down to the store calls.
+//
+// A nil *Tracer is valid and records nothing, so the loggers only pay for
+// instrumentation when built with their WithTracing option. The spans never
+// carry the searched words or the user identifiers, only their sizes.
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// ScopeName is the instrumentation scope of the spans
+const ScopeName = "github.com/afanwang/logsearch"
+
+// Attributes of the spans
+const (
+	// KeyLogger is the logger of the span, metrics.LoggerTrie or metrics.LoggerV2
+	KeyLogger = attribute.Key("logsearch.logger")
+	// KeyDecision is what the dedup logic did with a search, one of the metrics.Decision values
+	KeyDecision = attribute.Key("logsearch.decision")
+	// KeyOp is the kind of a store write, one of the metrics.Op values
+	KeyOp = attribute.Key("logsearch.op")
+	// KeyWordLength is the length in bytes of a search
+	KeyWordLength = attribute.Key("logsearch.word_length")
+	// KeyWrites is the number of words written by a flush or a batch
+	KeyWrites = attribute.Key("logsearch.writes")
+	// KeyBatchSize is the number of searches logged by a batch
+	KeyBatchSize = attribute.Key("logsearch.batch_size")
+)
+
+// Tracer starts the spans of the loggers
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// New creates a Tracer exporting its spans through tp, e.g. an sdktrace.TracerProvider
+func New(tp trace.TracerProvider) *Tracer {
+	return &Tracer{
+		tracer:     tp.Tracer(ScopeName),
+		propagator: propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}),
+	}
+}
+
+// Start starts a span named name, child of the span of ctx if any. End it with End.
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if t == nil {
+		return ctx, noop.Span{}
+	}
+	return t.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends a span, marking it failed when err is not nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// SetDecision records the dedup decision of a search on the span of ctx
+func SetDecision(ctx context.Context, decision string) {
+	trace.SpanFromContext(ctx).SetAttributes(KeyDecision.String(decision))
+}
+
+// SetWordLength records the length in bytes of a sanitized search on the span of ctx
+func SetWordLength(ctx context.Context, length int) {
+	trace.SpanFromContext(ctx).SetAttributes(KeyWordLength.Int(length))
+}
+
+// Middleware starts a server span for every request, continuing the trace of
+// the W3C traceparent header of the caller, so the spans of the loggers
+// called with the request context join the trace of the client
+func (t *Tracer) Middleware(next http.Handler) http.Handler {
+	if t == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := t.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := t.tracer.Start(ctx, r.Method+" "+r.URL.Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+			))
+		defer span.End()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
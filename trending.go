@@ -0,0 +1,40 @@
+package logsearch
+
+import (
+	"errors"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/afanwang/logsearch/trending"
+)
+
+// WithTrending counts the searches in buckets rolling buckets of the given
+// width, e.g. WithTrending(5*time.Minute, 288) to rank over windows up to 24
+// hours, so GetTrending can show what is searched now rather than the all time
+// top searches. Like WithHeavyHitters each search counts once under the
+// longest word it was extended to, and only searches logged since the logger
+// started are counted.
+func WithTrending(width time.Duration, buckets int) Option {
+	return func(sl *SearchLoggerV2) {
+		sl.trending = trending.New(width, buckets)
+	}
+}
+
+// GetTrending returns the limit words searched the most within the last
+// window over all users, most searched first. The window is rounded up to
+// whole buckets and capped at the span of WithTrending.
+func (sl *SearchLoggerV2) GetTrending(window time.Duration, limit int) ([]store.WordCount, error) {
+	if sl.trending == nil {
+		return nil, errors.New("trending is not enabled")
+	}
+	return trendingCounts(sl.trending.Top(window, limit, time.Now())), nil
+}
+
+// trendingCounts converts the items of a trending.Tracker to word counts
+func trendingCounts(items []trending.Item) []store.WordCount {
+	counts := make([]store.WordCount, len(items))
+	for i, item := range items {
+		counts[i] = store.WordCount{Word: item.Word, Count: int(item.Count)}
+	}
+	return counts
+}
@@ -0,0 +1,198 @@
+// Package trending counts searches in rolling time buckets to rank the words
+// searched the most recently, e.g. over the last hour, as opposed to the all
+// time most searched words of the store.
+//
+// A nil *Tracker is valid and tracks nothing, so the loggers only pay for it
+// when built with their WithTrending option.
+package trending
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Item is a word and its count within a window
+type Item struct {
+	Word  string
+	Count int64
+}
+
+// bucket counts the searches of one period of the ring
+type bucket struct {
+	start  time.Time
+	counts map[string]int64
+}
+
+// Tracker counts searches in a ring of fixed width buckets, the oldest bucket
+// being reused once the ring wraps around. It is safe for concurrent use.
+type Tracker struct {
+	mutex   sync.Mutex
+	width   time.Duration
+	buckets []bucket
+}
+
+// New creates a tracker of n buckets of the given width, e.g. New(5*time.Minute, 288)
+// to rank over windows up to 24 hours at a 5 minute granularity
+func New(width time.Duration, n int) *Tracker {
+	if width <= 0 {
+		width = 5 * time.Minute
+	}
+	if n <= 0 {
+		n = 1
+	}
+	return &Tracker{width: width, buckets: make([]bucket, n)}
+}
+
+// Span is the longest window the tracker ranks
+func (t *Tracker) Span() time.Duration {
+	if t == nil {
+		return 0
+	}
+	return t.width * time.Duration(len(t.buckets))
+}
+
+// slot returns the bucket of at, resetting it when it still counts an older
+// period, or nil when at is older than the period the bucket counts now.
+// Caller must hold the mutex.
+func (t *Tracker) slot(at time.Time) *bucket {
+	start := at.Truncate(t.width)
+	index := (start.UnixNano() / int64(t.width)) % int64(len(t.buckets))
+	if index < 0 {
+		index += int64(len(t.buckets))
+	}
+
+	b := &t.buckets[index]
+	if !b.start.Equal(start) || b.counts == nil {
+		if start.Before(b.start) {
+			return nil
+		}
+		b.start = start
+		b.counts = make(map[string]int64)
+	}
+	return b
+}
+
+// add adds delta to the count of word in the bucket of at, caller must hold the mutex
+func (t *Tracker) add(word string, delta int64, at time.Time) {
+	b := t.slot(at)
+	if b == nil {
+		return
+	}
+
+	b.counts[word] += delta
+	if b.counts[word] == 0 {
+		delete(b.counts, word)
+	}
+}
+
+// Add counts delta searches of word at at. Searches older than the span are ignored.
+func (t *Tracker) Add(word string, delta int64, at time.Time) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.add(word, delta, at)
+}
+
+// Move moves one count from a stored word to the word extending it at at,
+// so "bu" then "bus" count as one search of "bus"
+func (t *Tracker) Move(from, to string, at time.Time) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.add(from, -1, at)
+	t.add(to, 1, at)
+}
+
+// Merge moves every count of the word from to the word to in their buckets,
+// e.g. when an operator merges two words
+func (t *Tracker) Merge(from, to string) {
+	if t == nil || from == to {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, b := range t.buckets {
+		if count, ok := b.counts[from]; ok {
+			b.counts[to] += count
+			delete(b.counts, from)
+		}
+	}
+}
+
+// Remove takes up to count searches of word away from its buckets, the most
+// recent first, e.g. once the records holding them were deleted
+func (t *Tracker) Remove(word string, count int64) {
+	if t == nil || count <= 0 {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	holding := make([]bucket, 0, len(t.buckets))
+	for _, b := range t.buckets {
+		if b.counts[word] > 0 {
+			holding = append(holding, b)
+		}
+	}
+	sort.Slice(holding, func(i, j int) bool { return holding[i].start.After(holding[j].start) })
+	for _, b := range holding {
+		taken := min(count, b.counts[word])
+		b.counts[word] -= taken
+		if b.counts[word] == 0 {
+			delete(b.counts, word)
+		}
+		count -= taken
+		if count == 0 {
+			return
+		}
+	}
+}
+
+// Top returns the limit words searched the most within window before now,
+// most searched first, ties broken alphabetically. The window is rounded up
+// to whole buckets and capped at the span.
+func (t *Tracker) Top(window time.Duration, limit int, now time.Time) []Item {
+	if t == nil || limit <= 0 || window <= 0 {
+		return []Item{}
+	}
+
+	t.mutex.Lock()
+	cutoff := now.Add(-window)
+	counts := make(map[string]int64)
+	for _, b := range t.buckets {
+		// Skip the buckets ending before the window or starting after now
+		if b.counts == nil || !b.start.Add(t.width).After(cutoff) || b.start.After(now) {
+			continue
+		}
+		for word, count := range b.counts {
+			counts[word] += count
+		}
+	}
+	t.mutex.Unlock()
+
+	// A word extended within the window but searched before it has a negative count
+	items := make([]Item, 0, len(counts))
+	for word, count := range counts {
+		if count > 0 {
+			items = append(items, Item{Word: word, Count: count})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Word < items[j].Word
+	})
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return items
+}
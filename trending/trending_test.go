@@ -0,0 +1,80 @@
+package trending
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker(t *testing.T) {
+	tracker := New(5*time.Minute, 12)
+	assert.Equal(t, time.Hour, tracker.Span())
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tracker.Add("bus", 3, now.Add(-50*time.Minute))
+	tracker.Add("cat", 2, now.Add(-10*time.Minute))
+	tracker.Add("dog", 1, now.Add(-2*time.Minute))
+	tracker.Add("dog", 1, now.Add(-1*time.Minute))
+
+	assert.Equal(t, []Item{{"bus", 3}, {"cat", 2}, {"dog", 2}}, tracker.Top(time.Hour, 10, now))
+	assert.Equal(t, []Item{{"cat", 2}, {"dog", 2}}, tracker.Top(15*time.Minute, 10, now))
+	assert.Equal(t, []Item{{"dog", 2}}, tracker.Top(5*time.Minute, 10, now))
+	assert.Equal(t, []Item{{"bus", 3}}, tracker.Top(time.Hour, 1, now))
+
+	// Extending a word moves its count, the earlier searches of cat are out of the window
+	tracker.Move("ca", "cat", now)
+	tracker.Move("do", "dog", now)
+	assert.Equal(t, []Item{{"dog", 3}, {"cat", 1}}, tracker.Top(5*time.Minute, 10, now))
+
+	// Merging moves the counts of every bucket
+	tracker.Merge("bus", "dog")
+	assert.Equal(t, []Item{{"dog", 6}, {"cat", 3}}, tracker.Top(time.Hour, 10, now))
+
+	// Removing takes the most recent searches first
+	tracker.Remove("dog", 4)
+	assert.Equal(t, []Item{{"cat", 3}}, tracker.Top(15*time.Minute, 10, now))
+	assert.Equal(t, []Item{{"cat", 3}, {"dog", 2}}, tracker.Top(time.Hour, 10, now))
+	tracker.Remove("dog", 5)
+	assert.Equal(t, []Item{{"cat", 3}}, tracker.Top(time.Hour, 10, now))
+
+	// Once the ring wraps around the old buckets are reused
+	later := now.Add(time.Hour)
+	tracker.Add("emu", 1, later)
+	assert.Equal(t, []Item{{"emu", 1}}, tracker.Top(5*time.Minute, 10, later))
+	assert.Equal(t, []Item{{"emu", 1}}, tracker.Top(30*time.Minute, 10, later))
+
+	// Searches older than the span are ignored
+	tracker.Add("fox", 1, later.Add(-2*time.Hour))
+	assert.NotContains(t, tracker.Top(time.Hour, 10, later), Item{"fox", 1})
+
+	assert.Empty(t, tracker.Top(time.Hour, 0, now))
+}
+
+func TestTrackerConcurrent(t *testing.T) {
+	tracker := New(time.Minute, 10)
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				tracker.Add("bus", 1, now)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, []Item{{"bus", 8000}}, tracker.Top(time.Minute, 10, now))
+}
+
+func TestNilTracker(t *testing.T) {
+	var tracker *Tracker
+	tracker.Add("bus", 1, time.Now())
+	tracker.Move("bus", "business", time.Now())
+	assert.Empty(t, tracker.Top(time.Hour, 10, time.Now()))
+	assert.Zero(t, tracker.Span())
+}
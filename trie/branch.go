@@ -0,0 +1,54 @@
+package trie
+
+import "time"
+
+// WithBranchDetection drops a timed-out word when the user backspaced from it
+// and typed another branch within the timeout: after "busin", "busi" and
+// "busia" only "busia" is stored. Both words must share at least minShared
+// characters, so "cat" typed shortly before "dog" is stored as well. The
+// trie is global, so unlike the per-user V2 detection a branch typed by
+// another user within the timeout also supersedes the word.
+func WithBranchDetection(minShared int) Option {
+	return func(sl *SearchLogger) {
+		sl.branchMinShared = max(minShared, 1)
+	}
+}
+
+// supersededBranch reports whether a sibling branch of word sharing at least
+// branchMinShared characters with it was typed after node and within the
+// timeout, see WithBranchDetection. Only the subtrees of the path below that
+// depth are walked.
+func (sl *SearchLogger) supersededBranch(word string, node *TrieNode) bool {
+	if sl.branchMinShared == 0 {
+		return false
+	}
+
+	deadline := node.lastSeen.Add(sl.timeout)
+	current := sl.trieRoot
+	for depth, char := range graphemes(word) {
+		next := current.children.get(char)
+		if next == nil {
+			return false
+		}
+		if depth >= sl.branchMinShared {
+			superseded := !current.children.each(func(sibling string, child *TrieNode) bool {
+				return sibling == char || !typedWithin(child, node, deadline)
+			})
+			if superseded {
+				return true
+			}
+		}
+		current = next
+	}
+	return false
+}
+
+// typedWithin reports whether a node of the subtree was typed after node and not after deadline
+func typedWithin(subtree, node *TrieNode, deadline time.Time) bool {
+	if subtree.lastSeen.After(node.lastSeen) && !subtree.lastSeen.After(deadline) {
+		return true
+	}
+	return !subtree.children.each(func(_ string, child *TrieNode) bool {
+		return !typedWithin(child, node, deadline)
+	})
+}
@@ -0,0 +1,85 @@
+package trie
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/afanwang/logsearch/metrics"
+)
+
+// WithNodeBudget bounds the memory of the trie to about maxNodes nodes, each
+// taking roughly RuntimeStats.EstimatedBytes / Nodes. Once a flush cycle
+// leaves more nodes, the least recently searched words the store already
+// holds are dropped from the trie, their records staying in the store, until
+// the trie is back to 90% of the budget. The words being typed and the
+// verified words are kept, so the trie may stay over budget when they alone
+// exceed it. The dropped words are forgotten like those of WithPruning, and
+// counted by Stats.Evicted and the trie_evicted_nodes_total metric.
+func WithNodeBudget(maxNodes int) Option {
+	return func(sl *SearchLogger) {
+		if maxNodes > 0 {
+			sl.maxNodes = maxNodes
+		}
+	}
+}
+
+// coldWord is a stored word of the trie that may be evicted
+type coldWord struct {
+	word     string
+	lastSeen time.Time
+}
+
+// enforceBudgetLocked evicts the coldest words until the trie fits in 90% of
+// its budget, caller must hold the write lock. Words typed after cutoff keep
+// their paths.
+func (sl *SearchLogger) enforceBudgetLocked(cutoff time.Time) {
+	if sl.maxNodes == 0 || sl.nodes <= sl.maxNodes {
+		return
+	}
+	target := sl.maxNodes - sl.maxNodes/10
+	before := sl.nodes
+
+	// Evicting a leaf may turn its parent into a cold leaf, hence the rounds
+	pending := sl.pendingNodesLocked()
+	for sl.nodes > target {
+		cold := sl.coldWordsLocked(pending, cutoff)
+		if len(cold) == 0 {
+			break
+		}
+		sort.Slice(cold, func(i, j int) bool { return cold[i].lastSeen.Before(cold[j].lastSeen) })
+		for _, c := range cold {
+			if sl.nodes <= target {
+				break
+			}
+			sl.pruneLocked(c.word, cutoff)
+		}
+	}
+
+	evicted := before - sl.nodes
+	sl.evicted += int64(evicted)
+	sl.metrics.TrieEvicted(metrics.LoggerTrie, evicted)
+	sl.metrics.SetTrieNodes(sl.nodes)
+}
+
+// coldWordsLocked returns the leaves of the trie holding a word neither
+// pending, verified nor typed after cutoff, caller must hold the lock
+func (sl *SearchLogger) coldWordsLocked(pending map[*TrieNode]bool, cutoff time.Time) []coldWord {
+	var cold []coldWord
+	var chars []string
+	var walk func(node *TrieNode)
+	walk = func(node *TrieNode) {
+		node.children.each(func(char string, child *TrieNode) bool {
+			chars = append(chars, char)
+			if child.children.len() > 0 {
+				walk(child)
+			} else if (child.isEndOfWord || child.dbID != nil) && !child.verified && !pending[child] && !child.lastSeen.After(cutoff) {
+				cold = append(cold, coldWord{word: strings.Join(chars, ""), lastSeen: child.lastSeen})
+			}
+			chars = chars[:len(chars)-1]
+			return true
+		})
+	}
+	walk(sl.trieRoot)
+	return cold
+}
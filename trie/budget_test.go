@@ -0,0 +1,50 @@
+package trie
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeBudget(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDB()
+	logger, err := NewSearchLoggerWithDB(time.Hour, db, WithNodeBudget(10))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	// Stored oldest first, "dog" is verified and "cow" is still being typed
+	now := time.Now()
+	for i, word := range []string{"apple", "dog", "melon", "kiwi"} {
+		require.NoError(t, logger.logSearchAt(ctx, word, now.Add(time.Duration(i-10)*time.Minute)))
+	}
+	logger.mutex.Lock()
+	require.NoError(t, logger.processTimedOutWordsLocked(ctx, now.Add(-time.Minute), 0))
+	logger.findLocked("dog").verified = true
+	logger.mutex.Unlock()
+	require.NoError(t, logger.logSearchAt(ctx, "cow", now))
+	assert.Equal(t, 20, logger.nodes)
+
+	logger.mutex.Lock()
+	logger.enforceBudgetLocked(now.Add(-time.Minute))
+	logger.mutex.Unlock()
+
+	// Evicting apple and melon leaves 10 nodes, kiwi goes too to get down to 9
+	assert.Equal(t, 6, logger.nodes, "Only dog and cow should be left")
+	assert.NotNil(t, logger.findLocked("dog"))
+	assert.Nil(t, logger.findLocked("apple"))
+	stats, err := logger.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(14), stats.Evicted)
+	assert.Equal(t, int64(4), stats.StoredRecords, "Evicted words stay in the store")
+
+	// Within budget nothing is evicted
+	logger.mutex.Lock()
+	logger.enforceBudgetLocked(now)
+	logger.mutex.Unlock()
+	assert.Equal(t, 6, logger.nodes)
+}
@@ -0,0 +1,183 @@
+package trie
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/bits"
+	"time"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/store"
+)
+
+// maxCategories is how many categories fit in the tag bits of a node
+const maxCategories = 64
+
+// WithCategories declares the categories the searches may be tagged with by
+// LogSearchEvent, e.g. the search boxes "products" and "help-center" of one
+// deployment, so SuggestInCategory and GetTopSearchesInCategory answer each
+// box with the words searched in it. A word searched in several categories is
+// stored once, tagged with all of them, and its tags are written next to its
+// record once it times out and loaded back on startup. Up to 64 categories are
+// kept, the others are ignored. The store must implement
+// store.SearchCategoryStore.
+func WithCategories(names ...string) Option {
+	return func(sl *SearchLogger) {
+		for _, name := range names {
+			if _, ok := sl.categories[name]; ok || name == "" || len(sl.categoryNames) == maxCategories {
+				continue
+			}
+			if sl.categories == nil {
+				sl.categories = make(map[string]uint64)
+			}
+			sl.categories[name] = 1 << len(sl.categoryNames)
+			sl.categoryNames = append(sl.categoryNames, name)
+		}
+	}
+}
+
+// categoryTag returns the tag bit of category, 0 for no category, and
+// ErrUnknownCategory wrapped for a category not declared by WithCategories
+func (sl *SearchLogger) categoryTag(category string) (uint64, error) {
+	if category == "" {
+		return 0, nil
+	}
+	tag, ok := sl.categories[category]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", logsearch.ErrUnknownCategory, category)
+	}
+	return tag, nil
+}
+
+// categoryNamesOf returns the names of the categories of tags, in declaration order
+func (sl *SearchLogger) categoryNamesOf(tags uint64) []string {
+	names := make([]string, 0, bits.OnesCount64(tags))
+	for tags != 0 {
+		names = append(names, sl.categoryNames[bits.TrailingZeros64(tags)])
+		tags &= tags - 1
+	}
+	return names
+}
+
+// LogSearchEvent is LogSearch tagging the word with the category of event,
+// it returns ErrUnknownCategory of the logsearch package for a category not
+// declared by WithCategories. The user of event is ignored since the trie is
+// global.
+func (sl *SearchLogger) LogSearchEvent(ctx context.Context, event logsearch.SearchEvent) error {
+	tags, err := sl.categoryTag(event.Category)
+	if err != nil {
+		return err
+	}
+	return sl.logSearch(ctx, event.Query, tags)
+}
+
+// SuggestInCategory is Suggest restricted to the words searched in category
+func (sl *SearchLogger) SuggestInCategory(prefix, category string, limit int) ([]string, error) {
+	tag, err := sl.categoryTag(category)
+	if err != nil {
+		return nil, err
+	}
+	return sl.suggest(prefix, limit, wordMatch{tag: tag})
+}
+
+// GetTopSearchesInCategory is GetTopSearchesSince restricted to the words
+// searched in category. With WithDecay a zero since is ranked by decayed
+// score, other windows are answered from the store.
+func (sl *SearchLogger) GetTopSearchesInCategory(ctx context.Context, category string, since time.Time, limit int) ([]store.WordCount, error) {
+	tag, err := sl.categoryTag(category)
+	if err != nil {
+		return nil, err
+	}
+	if sl.halfLife > 0 && since.IsZero() {
+		return sl.topDecayed(limit, tag), nil
+	}
+	if tag == 0 {
+		return sl.GetTopSearchesSince(ctx, since, limit)
+	}
+
+	categoryStore, ok := sl.db.(store.SearchCategoryStore)
+	if !ok {
+		return nil, errors.New("store does not support categories")
+	}
+
+	sl.mutex.RLock()
+	counts, err := categoryStore.TopSearchesInCategory(ctx, category, since, limit)
+	sl.mutex.RUnlock()
+	if err != nil {
+		return nil, store.Classify(err)
+	}
+	return sl.displayCounts(counts), nil
+}
+
+// storeCategoriesLocked writes the tags of the stored words among expired
+// that were not written yet, caller must hold the write lock. A failure is
+// logged and retried the next time the word times out.
+func (sl *SearchLogger) storeCategoriesLocked(ctx context.Context, expired []expiryEntry) {
+	categoryStore, ok := sl.db.(store.SearchCategoryStore)
+	if len(sl.categoryNames) == 0 || !ok {
+		return
+	}
+	for _, entry := range expired {
+		node := entry.node
+		tags := node.tags &^ node.storedTags
+		if node.dbID == nil || tags == 0 {
+			continue
+		}
+		err := categoryStore.TagCategories(ctx, entry.word, sl.categoryNamesOf(tags))
+		if errors.Is(err, store.ErrWordNotFound) {
+			// A buffered rename to the word has not reached the store yet
+			continue
+		}
+		if err != nil {
+			log.Printf("Error tagging '%s' with categories: %v", entry.word, err)
+			continue
+		}
+		node.storedTags |= tags
+	}
+}
+
+// loadCategories sets the stored tags on the words of the trie, the
+// categories not declared anymore are ignored
+func (sl *SearchLogger) loadCategories(ctx context.Context) error {
+	categoryStore, ok := sl.db.(store.SearchCategoryStore)
+	if len(sl.categoryNames) == 0 || !ok {
+		return nil
+	}
+	categories, err := categoryStore.GetCategories(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get categories: %w", store.Classify(err))
+	}
+	for word, names := range categories {
+		node := sl.findLocked(sl.normalizer.Normalize(word))
+		if node == nil || !node.isEndOfWord {
+			continue
+		}
+		for _, name := range names {
+			node.tags |= sl.categories[name]
+		}
+		node.storedTags = node.tags
+	}
+	return nil
+}
+
+// moveTags moves the tags of from to to, to is left with the union of both
+func moveTags(to, from *TrieNode) {
+	to.tags |= from.tags
+	to.storedTags |= from.storedTags
+	from.tags, from.storedTags = 0, 0
+}
+
+// wordMatch selects the stored words returned by suggest
+type wordMatch struct {
+	// verifiedOnly only matches the words marked verified
+	verifiedOnly bool
+	// tag only matches the words searched in its category, 0 matches any
+	tag uint64
+}
+
+// matches reports whether node is a stored word selected by m
+func (m wordMatch) matches(node *TrieNode) bool {
+	return node.isEndOfWord && (node.verified || !m.verifiedOnly) && (m.tag == 0 || node.tags&m.tag != 0)
+}
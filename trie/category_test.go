@@ -0,0 +1,84 @@
+package trie
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCategories(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDB()
+	logger, err := NewSearchLoggerWithDB(time.Hour, db, WithCategories("products", "help-center"))
+	require.NoError(t, err)
+
+	log := func(word, category string) {
+		require.NoError(t, logger.LogSearchEvent(ctx, logsearch.SearchEvent{Query: word, Category: category}))
+	}
+	log("iphone", "products")
+	log("ipad", "products")
+	log("install", "help-center")
+	log("iphone", "help-center")
+	log("invoice", "")
+	assert.ErrorIs(t, logger.LogSearchEvent(ctx, logsearch.SearchEvent{Query: "ink", Category: "blog"}), logsearch.ErrUnknownCategory)
+	require.NoError(t, logger.Flush(ctx))
+
+	suggestions, err := logger.SuggestInCategory("i", "products", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ipad", "iphone"}, suggestions)
+	suggestions, err = logger.SuggestInCategory("i", "help-center", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"install", "iphone"}, suggestions)
+	suggestions, err = logger.SuggestInCategory("i", "", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"install", "invoice", "ipad", "iphone"}, suggestions, "No category suggests every word")
+	_, err = logger.SuggestInCategory("i", "blog", 10)
+	assert.ErrorIs(t, err, logsearch.ErrUnknownCategory)
+
+	top, err := logger.GetTopSearchesInCategory(ctx, "help-center", time.Time{}, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []store.WordCount{{Word: "install", Count: 1}, {Word: "iphone", Count: 1}}, top)
+
+	// The tags follow the record to the longer word extending it
+	log("iphone pro", "")
+	require.NoError(t, logger.Flush(ctx))
+	suggestions, err = logger.SuggestInCategory("iph", "help-center", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"iphone pro"}, suggestions)
+	require.NoError(t, logger.Close())
+
+	// The tags outlive the logger
+	logger, err = NewSearchLoggerWithDB(time.Hour, db, WithCategories("products", "help-center"))
+	require.NoError(t, err)
+	defer logger.Close()
+	suggestions, err = logger.SuggestInCategory("i", "products", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ipad", "iphone pro"}, suggestions)
+
+	_, err = NewSearchLoggerWithDB(time.Hour, &discardStore{}, WithCategories("products"))
+	assert.Error(t, err, "Stores without categories should be rejected")
+}
+
+func TestCategoriesKnownWords(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDB()
+	logger, err := NewSearchLoggerWithDB(time.Hour, db, WithCategories("products", "help-center"), WithKnownWords(100, 0.01))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	require.NoError(t, logger.LogSearchEvent(ctx, logsearch.SearchEvent{Query: "iphone", Category: "products"}))
+	require.NoError(t, logger.Flush(ctx))
+
+	// A stored word searched in a new category takes the slow path to be tagged
+	assert.True(t, logger.logKnownSearch(ctx, "iphone", logger.categories["products"], time.Now()))
+	assert.False(t, logger.logKnownSearch(ctx, "iphone", logger.categories["help-center"], time.Now()))
+	require.NoError(t, logger.LogSearchEvent(ctx, logsearch.SearchEvent{Query: "iphone", Category: "help-center"}))
+	suggestions, err := logger.SuggestInCategory("i", "help-center", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"iphone"}, suggestions)
+}
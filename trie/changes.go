@@ -0,0 +1,14 @@
+package trie
+
+import "github.com/afanwang/logsearch/changes"
+
+// WithChangeStream emits to stream every mutation the store committed: the
+// insert of a word once it timed out, the rename of a stored word to the
+// longer word extending it, curation, reviews and purged words. The changes
+// are emitted under the logger's lock in the order of the writes, a stream
+// blocking while its queue is full slows the logger down.
+func WithChangeStream(stream *changes.Stream) Option {
+	return func(sl *SearchLogger) {
+		sl.changes = stream
+	}
+}
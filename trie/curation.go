@@ -0,0 +1,114 @@
+package trie
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/changes"
+	"github.com/afanwang/logsearch/store"
+)
+
+// RenameWord renames a stored word, e.g. to fix its spelling, keeping its
+// counts. It returns store.ErrWordExists wrapped when newWord is stored, which
+// MergeWords combines instead, and store.ErrWordNotFound wrapped when oldWord
+// is not stored. The store must implement store.SearchCurationStore.
+func (sl *SearchLogger) RenameWord(ctx context.Context, oldWord, newWord string) error {
+	return sl.curate(ctx, oldWord, newWord, false)
+}
+
+// MergeWords merges a stored word into another, e.g. "nyc" into "new york",
+// summing their counts in the store and their scores in the trie, so that
+// suggestions and rankings only know to. A missing to is renamed from from.
+// It returns store.ErrWordNotFound wrapped when from is not stored. A later
+// search of from is logged as a new word.
+func (sl *SearchLogger) MergeWords(ctx context.Context, from, to string) error {
+	return sl.curate(ctx, from, to, true)
+}
+
+// curate renames from to to in the store, merging both records if merge, then
+// moves the record link, score and verified flag of from to to in the trie
+func (sl *SearchLogger) curate(ctx context.Context, from, to string, merge bool) error {
+	curationStore, ok := sl.db.(store.SearchCurationStore)
+	if !ok {
+		return errors.New("store does not support curating words")
+	}
+	from, to = sl.normalizer.Normalize(from), sl.normalizer.Normalize(to)
+	if from == "" || to == "" {
+		return logsearch.ErrEmptyWord
+	}
+	if from == to {
+		return nil
+	}
+
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	// The buffered updates may rename the record of either word
+	if err := sl.flushUpdatesLocked(ctx); err != nil {
+		return err
+	}
+
+	var id int64
+	var err error
+	if merge {
+		id, err = curationStore.MergeWords(ctx, from, to)
+	} else {
+		id, err = curationStore.RenameWord(ctx, from, to)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to curate '%s': %w", from, store.Classify(err))
+	}
+	sl.changes.Emit(changes.Change{Op: changes.OpUpdate, ID: id, Word: to, OldWord: from, At: time.Now()})
+
+	target := sl.pathLocked(to)
+	// A renamed record keeps its payload, a merged one the payload of to
+	renamed := target.dbID == nil
+	target.isEndOfWord = true
+	target.dbID = &id
+	sl.rememberStored(to)
+	if source := sl.findLocked(from); source != nil {
+		target.score = mergeScores(target.score, source.score)
+		target.verified = target.verified || source.verified
+		source.score = 0
+		target.tags |= source.tags
+		if renamed {
+			target.metadata = source.metadata
+			target.storedTags = source.storedTags
+		}
+		if target.tags != target.storedTags {
+			// The tags gained by the record are written once it times out
+			sl.expiry.track(to, target)
+		}
+	}
+	sl.removeWordLocked(from, time.Now())
+	sl.metrics.SetTrieNodes(sl.nodes)
+
+	sl.heavy.Merge(from, to)
+	sl.trending.Merge(from, to)
+	return nil
+}
+
+// pathLocked returns the node of word, creating the missing nodes of its path,
+// caller must hold the write lock
+func (sl *SearchLogger) pathLocked(word string) *TrieNode {
+	node := sl.trieRoot
+	for _, char := range graphemes(word) {
+		node = sl.childLocked(node, char)
+	}
+	return node
+}
+
+// childLocked returns the child of node for the grapheme char, adding it when
+// missing, caller must hold the write lock
+func (sl *SearchLogger) childLocked(node *TrieNode, char string) *TrieNode {
+	if child := node.children.get(char); child != nil {
+		return child
+	}
+	child := sl.arena.alloc()
+	node.children.add(char, child)
+	sl.nodes++
+	return child
+}
@@ -0,0 +1,135 @@
+package trie
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// WithDecay ranks Suggest and the all-time GetTopSearches by an exponentially
+// decayed search count: every search of a word adds 1 to its score, and the
+// score halves every halfLife, so once popular words nobody searches anymore
+// sink below the current ones. Extending a stored word moves its score to the
+// longer word. Scores live in the trie only, the words loaded from the store
+// start at 0. It takes precedence over WithHeavyHitters for GetTopSearches.
+func WithDecay(halfLife time.Duration) Option {
+	return func(sl *SearchLogger) {
+		if halfLife > 0 {
+			sl.halfLife = halfLife
+		}
+	}
+}
+
+// decayScore is a decayed search count kept as the base 2 logarithm of the
+// count projected back to the Unix epoch. Projecting every score to the same
+// instant keeps them comparable without ever rescaling them, and the logarithm
+// keeps the projection from overflowing. The zero decayScore counts no search.
+type decayScore float64
+
+// epochHalfLives returns how many half-lives passed between the Unix epoch and at
+func (sl *SearchLogger) epochHalfLives(at time.Time) float64 {
+	return float64(at.UnixNano()) / float64(sl.halfLife)
+}
+
+// bumpScore adds a search at at to the score of node, a no-op without WithDecay
+func (sl *SearchLogger) bumpScore(node *TrieNode, at time.Time) {
+	if sl.halfLife == 0 {
+		return
+	}
+	node.score = mergeScores(node.score, decayScore(sl.epochHalfLives(at)))
+}
+
+// mergeScores returns the score counting the searches of both a and b
+func mergeScores(a, b decayScore) decayScore {
+	if a == 0 {
+		return b
+	}
+	if b == 0 {
+		return a
+	}
+	hi, lo := math.Max(float64(a), float64(b)), math.Min(float64(a), float64(b))
+	return decayScore(hi + math.Log2(1+math.Exp2(lo-hi)))
+}
+
+// decayedCount returns the value of score at now
+func (sl *SearchLogger) decayedCount(score decayScore, now time.Time) float64 {
+	if score == 0 {
+		return 0
+	}
+	return math.Exp2(float64(score) - sl.epochHalfLives(now))
+}
+
+// scoredWord is a stored word of the trie with its decay score
+type scoredWord struct {
+	word  string
+	score decayScore
+}
+
+// collectScored appends the stored words of the subtree of node selected by match to result
+func collectScored(node *TrieNode, currentWord string, match wordMatch, result *[]scoredWord) {
+	if match.matches(node) {
+		*result = append(*result, scoredWord{word: node.forms.display(currentWord), score: node.score})
+	}
+	node.children.each(func(char string, child *TrieNode) bool {
+		collectScored(child, currentWord+char, match, result)
+		return true
+	})
+}
+
+// rankScored sorts words by decreasing score, ties in alphabetical order, and keeps the first limit
+func rankScored(words []scoredWord, limit int) []scoredWord {
+	sort.Slice(words, func(i, j int) bool {
+		if words[i].score != words[j].score {
+			return words[i].score > words[j].score
+		}
+		return words[i].word < words[j].word
+	})
+	if len(words) > limit {
+		words = words[:max(limit, 0)]
+	}
+	return words
+}
+
+// suggestDecayedLocked returns up to limit stored words below node by decayed
+// score, only the ones selected by match, caller must hold the read lock unless
+// node belongs to a snapshot
+func (sl *SearchLogger) suggestDecayedLocked(node *TrieNode, prefix string, limit int, match wordMatch) []string {
+	var words []scoredWord
+	collectScored(node, prefix, match, &words)
+
+	ranked := rankScored(words, limit)
+	suggestions := make([]string, len(ranked))
+	for i, scored := range ranked {
+		suggestions[i] = scored.word
+	}
+	return suggestions
+}
+
+// topDecayed returns the limit stored words with the highest decayed score,
+// leaving out the words not searched since startup, only the ones searched in
+// the category of tag unless 0. Count is the decayed count rounded, Score the
+// exact one.
+func (sl *SearchLogger) topDecayed(limit int, tag uint64) []store.WordCount {
+	root, release := sl.readRoot()
+	var words []scoredWord
+	collectScored(root, "", wordMatch{tag: tag}, &words)
+	release()
+
+	searched := words[:0]
+	for _, scored := range words {
+		if scored.score != 0 {
+			searched = append(searched, scored)
+		}
+	}
+
+	now := time.Now()
+	ranked := rankScored(searched, limit)
+	counts := make([]store.WordCount, len(ranked))
+	for i, scored := range ranked {
+		value := sl.decayedCount(scored.score, now)
+		counts[i] = store.WordCount{Word: scored.word, Count: int(math.Round(value)), Score: value}
+	}
+	return counts
+}
@@ -0,0 +1,175 @@
+package trie
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// WithDisplayForms keeps the forms the users type a word in, before
+// normalization, and answers Suggest and GetTopSearches with the most typed
+// one, e.g. "iPhone" rather than the key "iphone" the trie dedups on. The
+// display form of a stored word is written next to it once it times out and
+// loaded back on startup. The searches taking the WithKnownWords fast path
+// are not counted. The store must implement store.SearchDisplayStore.
+func WithDisplayForms() Option {
+	return func(sl *SearchLogger) {
+		sl.displayForms = true
+	}
+}
+
+// maxForms is how many distinct forms of a word are counted, enough for the
+// few casings a word is commonly typed in
+const maxForms = 4
+
+// formCount counts the searches of a form of a word
+type formCount struct {
+	form  string
+	count int
+}
+
+// displayForms counts the forms of a word with the space-saving algorithm: a
+// new form replaces the least typed one once maxForms are counted, inheriting
+// its count, so a form typed more often than the others is never lost
+type displayForms struct {
+	counts []formCount
+	// stored is the display form last written to the store
+	stored string
+}
+
+// add counts a search of form
+func (f *displayForms) add(form string) {
+	for i := range f.counts {
+		if f.counts[i].form == form {
+			f.counts[i].count++
+			return
+		}
+	}
+	if len(f.counts) < maxForms {
+		f.counts = append(f.counts, formCount{form: form, count: 1})
+		return
+	}
+	least := 0
+	for i := range f.counts {
+		if f.counts[i].count < f.counts[least].count {
+			least = i
+		}
+	}
+	f.counts[least] = formCount{form: form, count: f.counts[least].count + 1}
+}
+
+// display returns the most typed form, the first counted on ties, or word
+// when no form was counted
+func (f *displayForms) display(word string) string {
+	if f == nil || len(f.counts) == 0 {
+		return word
+	}
+	best := f.counts[0]
+	for _, c := range f.counts[1:] {
+		if c.count > best.count {
+			best = c
+		}
+	}
+	return best.form
+}
+
+// frozen returns a copy holding only the display form, for a snapshot
+func (f *displayForms) frozen() *displayForms {
+	if f == nil || len(f.counts) == 0 {
+		return nil
+	}
+	return &displayForms{counts: []formCount{{form: f.display(""), count: 1}}}
+}
+
+// addFormLocked counts a search of node typed as raw, caller must hold the write lock
+func (sl *SearchLogger) addFormLocked(node *TrieNode, raw string) {
+	if node.forms == nil {
+		node.forms = &displayForms{}
+	}
+	node.forms.add(strings.TrimSpace(raw))
+}
+
+// storeDisplayFormsLocked writes the display forms of the stored words among
+// expired that changed since last written, caller must hold the write lock.
+// The display form is for display only, a failure is logged and retried the
+// next time the word times out.
+func (sl *SearchLogger) storeDisplayFormsLocked(ctx context.Context, expired []expiryEntry) {
+	displayStore, ok := sl.db.(store.SearchDisplayStore)
+	if !sl.displayForms || !ok {
+		return
+	}
+	for _, entry := range expired {
+		node := entry.node
+		if node.dbID == nil || node.forms == nil {
+			continue
+		}
+		display := node.forms.display(entry.word)
+		if display == node.forms.stored || (node.forms.stored == "" && display == entry.word) {
+			continue
+		}
+		err := displayStore.SetDisplayWord(ctx, entry.word, display)
+		if errors.Is(err, store.ErrWordNotFound) {
+			// A buffered rename to the word has not reached the store yet
+			continue
+		}
+		if err != nil {
+			log.Printf("Error setting display form '%s' of '%s': %v", display, entry.word, err)
+			continue
+		}
+		node.forms.stored = display
+	}
+}
+
+// loadDisplayForms sets the stored display forms on the words of the trie
+func (sl *SearchLogger) loadDisplayForms(ctx context.Context) error {
+	displayStore, ok := sl.db.(store.SearchDisplayStore)
+	if !sl.displayForms || !ok {
+		return nil
+	}
+	displays, err := displayStore.GetDisplayWords(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get display forms: %w", store.Classify(err))
+	}
+	for word, display := range displays {
+		word = sl.normalizer.Normalize(word)
+		// A record renamed since keeps the display form of its former word
+		if sl.normalizer.Normalize(display) != word {
+			continue
+		}
+		if node := sl.findLocked(word); node != nil && node.isEndOfWord {
+			node.forms = &displayForms{counts: []formCount{{form: display, count: 1}}, stored: display}
+		}
+	}
+	return nil
+}
+
+// displayCounts replaces the words of counts with their display forms
+func (sl *SearchLogger) displayCounts(counts []store.WordCount) []store.WordCount {
+	if !sl.displayForms {
+		return counts
+	}
+	root, release := sl.readRoot()
+	defer release()
+
+	for i := range counts {
+		if node := findFrom(root, counts[i].Word); node != nil {
+			counts[i].Word = node.forms.display(counts[i].Word)
+		}
+	}
+	return counts
+}
+
+// findFrom returns the node of word below root, nil if none
+func findFrom(root *TrieNode, word string) *TrieNode {
+	node := root
+	for _, char := range graphemes(word) {
+		if node = node.children.get(char); node == nil {
+			return nil
+		}
+	}
+	return node
+}
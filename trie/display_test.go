@@ -0,0 +1,62 @@
+package trie
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisplayForms(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDB()
+	logger, err := NewSearchLoggerWithDB(time.Hour, db, WithDisplayForms())
+	require.NoError(t, err)
+
+	for _, word := range []string{"iPhone", "iphone", "iPhone", "IPHONE", "ipad"} {
+		require.NoError(t, logger.LogSearch(ctx, word))
+	}
+	require.NoError(t, logger.Flush(ctx))
+
+	// The trie dedups on the lowercase key and displays the most typed form
+	stored, err := logger.GetStoredSearches(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"iphone", "ipad"}, stored)
+	suggestions, err := logger.Suggest("IP", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ipad", "iPhone"}, suggestions)
+	top, err := logger.GetTopSearches(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, top, 2)
+	assert.ElementsMatch(t, []string{"ipad", "iPhone"}, []string{top[0].Word, top[1].Word})
+
+	// Only the forms other than the key are written
+	displays, err := db.GetDisplayWords(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"iphone": "iPhone"}, displays)
+	require.NoError(t, logger.Close())
+
+	// The display forms outlive the logger
+	logger, err = NewSearchLoggerWithDB(time.Hour, db, WithDisplayForms())
+	require.NoError(t, err)
+	defer logger.Close()
+	suggestions, err = logger.Suggest("iph", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"iPhone"}, suggestions)
+
+	_, err = NewSearchLoggerWithDB(time.Hour, &discardStore{}, WithDisplayForms())
+	assert.Error(t, err, "Stores without display words should be rejected")
+}
+
+func TestDisplayFormsSpaceSaving(t *testing.T) {
+	forms := &displayForms{}
+	for _, form := range []string{"a", "a", "a", "B", "C", "D", "E", "F"} {
+		forms.add(form)
+	}
+	assert.Len(t, forms.counts, maxForms)
+	assert.Equal(t, "a", forms.display("x"), "The most typed form is never evicted")
+	assert.Equal(t, "x", (*displayForms)(nil).display("x"))
+}
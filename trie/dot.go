@@ -0,0 +1,121 @@
+package trie
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/afanwang/logsearch"
+)
+
+// ExportTrieDOT renders the trie as a Graphviz DOT digraph, e.g. piped to
+// `dot -Tsvg`, down to maxDepth characters below the root, 0 rendering all of it
+func (sl *SearchLogger) ExportTrieDOT(w io.Writer, maxDepth int) error {
+	return sl.ExportSubtrieDOT(w, "", maxDepth)
+}
+
+// ExportSubtrieDOT renders the subtree of the words starting with prefix like
+// ExportTrieDOT, maxDepth counting from the prefix. Every node is labelled
+// with the prefix it stands for:
+//
+//   - a stored word is a double circle with the ID of its record
+//   - a pending word, typed but not stored yet, is dashed
+//   - a verified word is filled
+//   - the subtrees cut by maxDepth are summarized by a "+N" node counting their nodes
+//
+// It returns logsearch.ErrWordNotFound, wrapped, when no word starts with prefix.
+func (sl *SearchLogger) ExportSubtrieDOT(w io.Writer, prefix string, maxDepth int) error {
+	prefix = sl.normalizer.Normalize(prefix)
+
+	sl.mutex.RLock()
+	defer sl.mutex.RUnlock()
+
+	node := sl.trieRoot
+	for _, char := range graphemes(prefix) {
+		if node = node.children.get(char); node == nil {
+			return fmt.Errorf("no word starts with %q: %w", prefix, logsearch.ErrWordNotFound)
+		}
+	}
+
+	d := &dotWriter{w: bufio.NewWriter(w), pending: sl.pendingNodesLocked(), maxDepth: maxDepth}
+	d.printf("digraph trie {\n\tnode [shape=circle, fontname=\"Helvetica\"];\n")
+	d.node(node, prefix, 0)
+	d.printf("}\n")
+	if d.err != nil {
+		return d.err
+	}
+	return d.w.Flush()
+}
+
+// dotWriter writes the nodes of a DOT export, keeping the first write error
+type dotWriter struct {
+	w        *bufio.Writer
+	pending  map[*TrieNode]bool
+	maxDepth int
+	nextID   int
+	err      error
+}
+
+func (d *dotWriter) printf(format string, args ...any) {
+	if d.err == nil {
+		_, d.err = fmt.Fprintf(d.w, format, args...)
+	}
+}
+
+// node writes node, standing for word at depth below the exported root, and
+// its subtree, returning the DOT identifier of node
+func (d *dotWriter) node(node *TrieNode, word string, depth int) string {
+	id := fmt.Sprintf("n%d", d.nextID)
+	d.nextID++
+
+	label := word
+	if depth == 0 && word == "" {
+		label = "(root)"
+	}
+	if node.dbID != nil {
+		label += fmt.Sprintf("\n#%d", *node.dbID)
+	}
+	attrs := []string{"label=" + dotQuote(label)}
+	if node.dbID != nil {
+		attrs = append(attrs, "shape=doublecircle")
+	}
+	switch {
+	case node.verified:
+		attrs = append(attrs, "style=filled", "fillcolor=palegreen")
+	case d.pending[node]:
+		attrs = append(attrs, "style=dashed")
+	}
+	d.printf("\t%s [%s];\n", id, strings.Join(attrs, ", "))
+
+	if d.maxDepth > 0 && depth >= d.maxDepth && node.children.len() > 0 {
+		hidden := countNodes(node) - 1
+		cut := fmt.Sprintf("n%d", d.nextID)
+		d.nextID++
+		d.printf("\t%s [label=\"+%d\", shape=plaintext];\n\t%s -> %s [style=dotted];\n", cut, hidden, id, cut)
+		return id
+	}
+	node.children.each(func(char string, child *TrieNode) bool {
+		childID := d.node(child, word+char, depth+1)
+		d.printf("\t%s -> %s [label=%s];\n", id, childID, dotQuote(char))
+		return true
+	})
+	return id
+}
+
+// countNodes counts node and the nodes below it
+func countNodes(node *TrieNode) int {
+	count := 1
+	node.children.each(func(_ string, child *TrieNode) bool {
+		count += countNodes(child)
+		return true
+	})
+	return count
+}
+
+// dotQuote quotes s as a DOT string, where a newline is written \n
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + strings.ReplaceAll(s, "\n", `\n`) + `"`
+}
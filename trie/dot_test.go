@@ -0,0 +1,61 @@
+package trie
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportTrieDOT(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLogger(time.Hour)
+	require.NoError(t, err)
+	defer logger.Close()
+
+	require.NoError(t, logger.LogSearch(ctx, "cat"))
+	require.NoError(t, logger.Flush(ctx))
+	require.NoError(t, logger.MarkVerified(ctx, "cat"))
+	require.NoError(t, logger.LogSearch(ctx, `bu"s`))
+
+	var out strings.Builder
+	require.NoError(t, logger.ExportTrieDOT(&out, 0))
+	assert.Equal(t, `digraph trie {
+	node [shape=circle, fontname="Helvetica"];
+	n0 [label="(root)"];
+	n1 [label="b"];
+	n2 [label="bu"];
+	n3 [label="bu\""];
+	n4 [label="bu\"s", style=dashed];
+	n3 -> n4 [label="s"];
+	n2 -> n3 [label="\""];
+	n1 -> n2 [label="u"];
+	n0 -> n1 [label="b"];
+	n5 [label="c"];
+	n6 [label="ca"];
+	n7 [label="cat\n#1", shape=doublecircle, style=filled, fillcolor=palegreen];
+	n6 -> n7 [label="t"];
+	n5 -> n6 [label="a"];
+	n0 -> n5 [label="c"];
+}
+`, out.String())
+
+	// The subtree of "b" cut one character below it
+	out.Reset()
+	require.NoError(t, logger.ExportSubtrieDOT(&out, "B", 1))
+	assert.Equal(t, `digraph trie {
+	node [shape=circle, fontname="Helvetica"];
+	n0 [label="b"];
+	n1 [label="bu"];
+	n2 [label="+2", shape=plaintext];
+	n1 -> n2 [style=dotted];
+	n0 -> n1 [label="u"];
+}
+`, out.String())
+
+	assert.ErrorIs(t, logger.ExportSubtrieDOT(&out, "dog", 0), logsearch.ErrWordNotFound)
+}
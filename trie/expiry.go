@@ -0,0 +1,58 @@
+package trie
+
+import (
+	"container/heap"
+	"time"
+)
+
+// expiryEntry is a word the user typed at lastSeen. Entries are never updated
+// in place, typing the word again pushes a new entry and the older one goes
+// stale once its lastSeen no longer matches the node's.
+type expiryEntry struct {
+	node     *TrieNode
+	word     string
+	lastSeen time.Time
+}
+
+// stale reports whether the word was typed again after this entry was pushed
+func (e expiryEntry) stale() bool {
+	return !e.node.lastSeen.Equal(e.lastSeen)
+}
+
+// expiryHeap is a min-heap of entries on lastSeen, so a flush cycle only
+// touches the words that timed out instead of walking the whole trie
+type expiryHeap []expiryEntry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].lastSeen.Before(h[j].lastSeen) }
+func (h expiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *expiryHeap) Push(x any) {
+	*h = append(*h, x.(expiryEntry))
+}
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = expiryEntry{}
+	*h = old[:n-1]
+	return entry
+}
+
+// track schedules the node of word to time out after its lastSeen
+func (h *expiryHeap) track(word string, node *TrieNode) {
+	heap.Push(h, expiryEntry{node: node, word: word, lastSeen: node.lastSeen})
+}
+
+// popExpired removes and returns the live entries last seen at or before cutoff, oldest first
+func (h *expiryHeap) popExpired(cutoff time.Time) []expiryEntry {
+	var expired []expiryEntry
+	for h.Len() > 0 && !(*h)[0].lastSeen.After(cutoff) {
+		entry := heap.Pop(h).(expiryEntry)
+		if !entry.stale() {
+			expired = append(expired, entry)
+		}
+	}
+	return expired
+}
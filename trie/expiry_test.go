@@ -0,0 +1,124 @@
+package trie
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiryHeap_SkipsStaleEntries(t *testing.T) {
+	var h expiryHeap
+	now := time.Now()
+	bus := &TrieNode{lastSeen: now.Add(-3 * time.Second)}
+	cat := &TrieNode{lastSeen: now.Add(-2 * time.Second)}
+	h.track("bus", bus)
+	h.track("cat", cat)
+
+	// Typing "bus" again leaves its first entry stale
+	bus.lastSeen = now
+	h.track("bus", bus)
+
+	expired := h.popExpired(now.Add(-time.Second))
+	assert.Len(t, expired, 1)
+	assert.Equal(t, "cat", expired[0].word)
+	assert.Equal(t, 1, h.Len())
+
+	expired = h.popExpired(now.Add(time.Second))
+	assert.Len(t, expired, 1)
+	assert.Equal(t, "bus", expired[0].word)
+}
+
+// discardStore assigns IDs without storing anything, so benchmarks measure the trie alone
+type discardStore struct {
+	nextID int64
+}
+
+func (s *discardStore) CreateTable(ctx context.Context) error { return nil }
+
+func (s *discardStore) InsertOrReplace(ctx context.Context, word string, firstSearched, lastUpdated time.Time) (int64, error) {
+	s.nextID++
+	return s.nextID, nil
+}
+
+func (s *discardStore) Update(ctx context.Context, id int64, newWord string, lastUpdated time.Time) error {
+	return nil
+}
+
+func (s *discardStore) GetAllSearchedWords(ctx context.Context) ([]string, error) { return nil, nil }
+
+func (s *discardStore) Close() error { return nil }
+
+// newBenchmarkLogger returns a logger whose trie holds stored words already flushed
+func newBenchmarkLogger(b *testing.B, stored int, opts ...Option) *SearchLogger {
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	ctx := context.Background()
+	logger, err := NewSearchLoggerWithDB(time.Hour, &discardStore{}, opts...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { logger.Close() })
+
+	for i := 0; i < stored; i++ {
+		if err := logger.LogSearch(ctx, fmt.Sprintf("stored%d", i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	logger.Flush(ctx)
+	return logger
+}
+
+// BenchmarkProcessTimedOutWords measures a flush cycle storing 10 new words
+// next to a large trie of words stored by earlier cycles
+func BenchmarkProcessTimedOutWords(b *testing.B) {
+	for _, stored := range []int{1_000, 100_000} {
+		b.Run(fmt.Sprintf("heap/stored=%d", stored), func(b *testing.B) {
+			logger := newBenchmarkLogger(b, stored)
+			ctx := context.Background()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < 10; j++ {
+					logger.LogSearch(ctx, fmt.Sprintf("new%d_%d", i, j))
+				}
+				logger.Flush(ctx)
+			}
+		})
+
+		// fullScan is the flush cycle before the expiry heap, walking the whole trie
+		b.Run(fmt.Sprintf("fullscan/stored=%d", stored), func(b *testing.B) {
+			logger := newBenchmarkLogger(b, stored)
+			ctx := context.Background()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < 10; j++ {
+					logger.LogSearch(ctx, fmt.Sprintf("new%d_%d", i, j))
+				}
+				logger.mutex.Lock()
+				timedOut := make(map[string]*TrieNode)
+				fullScan(logger.trieRoot, "", time.Now().Add(time.Hour), timedOut)
+				logger.mutex.Unlock()
+				logger.Flush(ctx)
+			}
+		})
+	}
+}
+
+// fullScan collects every node last seen before cutoff, like the flush cycle used to
+func fullScan(node *TrieNode, word string, cutoff time.Time, result map[string]*TrieNode) {
+	if !node.lastSeen.IsZero() && node.lastSeen.Before(cutoff) {
+		result[word] = node
+	}
+	node.children.each(func(char string, child *TrieNode) bool {
+		fullScan(child, word+char, cutoff, result)
+		return true
+	})
+}
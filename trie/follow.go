@@ -0,0 +1,126 @@
+package trie
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/afanwang/logsearch/changes"
+)
+
+// Follow keeps the trie a warm copy of the trie of a primary instance sharing
+// its store, so a standby can take over without a cold rebuild: it reloads
+// the trie from the store once it knows the last change of the primary, then
+// applies the changes served by the primary's changes.Hub as they come. A
+// gap in the changes, e.g. after a restart of the primary, reloads the trie
+// again. It returns once ctx is done, e.g. to promote the standby, which then
+// logs searches as usual. The standby must not log searches while following.
+//
+// The trie only holds the stored words: the words the primary had not stored
+// yet when it died are lost, unless its WAL is replayed.
+func (sl *SearchLogger) Follow(ctx context.Context, f *changes.Follower) error {
+	for {
+		after, err := f.LastSeq(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get the last change of the primary: %w", err)
+		}
+		// The changes after the snapshot of the store are applied again, which they tolerate
+		if err := sl.reload(ctx); err != nil {
+			return err
+		}
+		log.Printf("Following the changes of the primary after change %d", after)
+
+		err = f.Follow(ctx, after, sl.ApplyChange)
+		if !errors.Is(err, changes.ErrGap) {
+			return err
+		}
+		log.Printf("Reloading the trie from the store: %v", err)
+	}
+}
+
+// reload replaces the trie with the words of the store. Unlike on startup,
+// the loaded words are not scheduled to time out, a standby does not store
+// them again.
+func (sl *SearchLogger) reload(ctx context.Context) error {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	sl.trieRoot = &TrieNode{}
+	sl.nodes = 0
+	sl.arena.reset()
+	if sl.known != nil {
+		sl.known.mu.Lock()
+		clear(sl.known.hits)
+		sl.known.mu.Unlock()
+	}
+	if err := sl.loadExistingWords(ctx); err != nil {
+		return fmt.Errorf("failed to reload words: %w", err)
+	}
+	sl.expiry = nil
+	return nil
+}
+
+// ApplyChange applies a change of the store made by another instance to the
+// trie, without writing the store. Applying a change the trie already holds,
+// e.g. one replayed after a reload, leaves its words as they were.
+func (sl *SearchLogger) ApplyChange(change changes.Change) error {
+	if change.Word == "" {
+		return fmt.Errorf("change %d has no word", change.Seq)
+	}
+	word := sl.normalizer.Normalize(change.Word)
+
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	switch change.Op {
+	case changes.OpInsert:
+		sl.applyStoredLocked(word, change)
+		sl.heavy.Add(word, int64(change.Count))
+		sl.trending.Add(word, int64(change.Count), change.At)
+	case changes.OpUpdate:
+		if change.OldWord == "" {
+			// A review of the word
+			if node := sl.findLocked(word); node != nil && change.Verified != nil {
+				node.verified = *change.Verified
+			}
+			break
+		}
+		from := sl.normalizer.Normalize(change.OldWord)
+		target := sl.applyStoredLocked(word, change)
+		if source := sl.findLocked(from); source != nil && source != target {
+			target.score = mergeScores(target.score, source.score)
+			target.verified = target.verified || source.verified
+			source.score = 0
+			sl.removeWordLocked(from, time.Now())
+		}
+		// A renamed extended prefix counts one search, a curated word all of them
+		if change.Count > 0 {
+			sl.heavy.Move(from, word)
+			sl.trending.Move(from, word, change.At)
+		} else {
+			sl.heavy.Merge(from, word)
+			sl.trending.Merge(from, word)
+		}
+	case changes.OpDelete:
+		sl.removeWordLocked(word, time.Now())
+	default:
+		return fmt.Errorf("change %d has unknown op %q", change.Seq, change.Op)
+	}
+	sl.metrics.SetTrieNodes(sl.nodes)
+	return nil
+}
+
+// applyStoredLocked marks word stored by change, caller must hold the write lock
+func (sl *SearchLogger) applyStoredLocked(word string, change changes.Change) *TrieNode {
+	node := sl.pathLocked(word)
+	node.isEndOfWord = true
+	if change.ID != 0 {
+		id := change.ID
+		node.dbID = &id
+	}
+	node.lastSeen = maxTime(node.lastSeen, change.At)
+	sl.rememberStored(word)
+	return node
+}
@@ -0,0 +1,33 @@
+package trie
+
+import (
+	"context"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// Ping checks the connection to the store, it succeeds for stores that cannot check it
+func (sl *SearchLogger) Ping(ctx context.Context) error {
+	pinger, ok := sl.db.(store.Pinger)
+	if !ok {
+		return nil
+	}
+	return store.Classify(pinger.Ping(ctx))
+}
+
+// Heartbeat returns when the flushing routine last completed a round, which
+// happens every timeout/2. A heartbeat older than a few rounds means the
+// routine is wedged, e.g. on a store call that never returns or on the trie lock.
+func (sl *SearchLogger) Heartbeat() time.Time {
+	return time.Unix(0, sl.heartbeat.Load())
+}
+
+// QueueDepth returns the number of searches waiting in the async queue and its
+// capacity, both 0 without WithAsync
+func (sl *SearchLogger) QueueDepth() (depth, capacity int) {
+	if sl.queue == nil {
+		return 0, 0
+	}
+	return len(sl.queue.searches), cap(sl.queue.searches)
+}
@@ -0,0 +1,49 @@
+package trie
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeartbeat(t *testing.T) {
+	logger, err := NewSearchLogger(20 * time.Millisecond)
+	require.NoError(t, err)
+	defer logger.Close()
+	require.NoError(t, logger.Ping(context.Background()))
+
+	started := logger.Heartbeat()
+	assert.Eventually(t, func() bool { return logger.Heartbeat().After(started) }, time.Second, time.Millisecond)
+
+	// A routine stuck on the trie lock stops beating
+	logger.mutex.Lock()
+	time.Sleep(60 * time.Millisecond)
+	assert.Greater(t, time.Since(logger.Heartbeat()), 40*time.Millisecond)
+	logger.mutex.Unlock()
+	assert.Eventually(t, func() bool { return time.Since(logger.Heartbeat()) < 40*time.Millisecond }, time.Second, time.Millisecond)
+}
+
+func TestQueueDepth(t *testing.T) {
+	logger, err := NewSearchLogger(time.Hour)
+	require.NoError(t, err)
+	defer logger.Close()
+	depth, capacity := logger.QueueDepth()
+	assert.Zero(t, depth)
+	assert.Zero(t, capacity)
+
+	async, err := NewSearchLogger(time.Hour, WithAsync(1, 1, Drop))
+	require.NoError(t, err)
+	defer async.Close()
+
+	async.mutex.Lock()
+	logUntilRejected(t, async, func() (context.Context, context.CancelFunc) {
+		return context.WithCancel(context.Background())
+	})
+	depth, capacity = async.QueueDepth()
+	async.mutex.Unlock()
+	assert.Equal(t, 1, depth)
+	assert.Equal(t, 1, capacity)
+}
@@ -0,0 +1,24 @@
+package trie
+
+import "github.com/afanwang/logsearch"
+
+// WithWordFinalizedHook calls hook after every word written to the store: a
+// word stored once it timed out, a stored word renamed to the longer word
+// extending it or, with WithWriteBuffer, every coalesced rename of a flush.
+// The user of the finalized words is always empty. Hooks run under the
+// logger's lock, see logsearch.WordFinalizedHook. It may be given several
+// times to register several hooks.
+func WithWordFinalizedHook(hook logsearch.WordFinalizedHook) Option {
+	return func(sl *SearchLogger) {
+		if hook != nil {
+			sl.hooks = append(sl.hooks, hook)
+		}
+	}
+}
+
+// wordFinalized calls every registered hook with word
+func (sl *SearchLogger) wordFinalized(word logsearch.FinalizedWord) {
+	for _, hook := range sl.hooks {
+		hook(word)
+	}
+}
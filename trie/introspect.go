@@ -0,0 +1,136 @@
+package trie
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// nodeBytes roughly estimates the memory of a trie node: the TrieNode and its
+// edge in the children of its parent, the maps of the few wide nodes aside
+var nodeBytes = int64(unsafe.Sizeof(TrieNode{})) + int64(unsafe.Sizeof(trieEdge{}))
+
+// meteredMutex is the trie lock, counting the acquisitions that had to wait
+// for another goroutine and for how long, and the writes to the trie
+type meteredMutex struct {
+	sync.RWMutex
+	acquisitions atomic.Int64
+	contended    atomic.Int64
+	waitNanos    atomic.Int64
+	// writes counts the released write locks, telling whether the trie may have changed
+	writes atomic.Uint64
+}
+
+func (m *meteredMutex) Lock() {
+	m.acquisitions.Add(1)
+	if m.RWMutex.TryLock() {
+		return
+	}
+	start := time.Now()
+	m.RWMutex.Lock()
+	m.contended.Add(1)
+	m.waitNanos.Add(int64(time.Since(start)))
+}
+
+func (m *meteredMutex) Unlock() {
+	m.writes.Add(1)
+	m.RWMutex.Unlock()
+}
+
+func (m *meteredMutex) RLock() {
+	m.acquisitions.Add(1)
+	if m.RWMutex.TryRLock() {
+		return
+	}
+	start := time.Now()
+	m.RWMutex.RLock()
+	m.contended.Add(1)
+	m.waitNanos.Add(int64(time.Since(start)))
+}
+
+// LockStats counts the acquisitions of the trie lock since the logger started
+type LockStats struct {
+	Acquisitions int64 `json:"acquisitions"`
+	// Contended counts the acquisitions that waited for another goroutine, Wait is their total wait
+	Contended int64         `json:"contended"`
+	Wait      time.Duration `json:"wait_ns"`
+}
+
+// FlushStats describes the flush cycles that wrote timed out words to the
+// store since the logger started, the cycles without words are not counted
+type FlushStats struct {
+	Cycles int64 `json:"cycles"`
+	Failed int64 `json:"failed"`
+	// Words counts the words written or attempted by the cycles
+	Words         int64         `json:"words"`
+	Last          time.Time     `json:"last"`
+	LastDuration  time.Duration `json:"last_duration_ns"`
+	MaxDuration   time.Duration `json:"max_duration_ns"`
+	TotalDuration time.Duration `json:"total_duration_ns"`
+}
+
+// observe records a flush cycle of words that started at start
+func (f *FlushStats) observe(words int, start time.Time, err error) {
+	duration := time.Since(start)
+	f.Cycles++
+	if err != nil {
+		f.Failed++
+	}
+	f.Words += int64(words)
+	f.Last = start
+	f.LastDuration = duration
+	f.MaxDuration = max(f.MaxDuration, duration)
+	f.TotalDuration += duration
+}
+
+// RuntimeStats is a snapshot of the internals of a SearchLogger for operators
+type RuntimeStats struct {
+	Nodes int `json:"nodes"`
+	// EstimatedBytes roughly estimates the memory of the trie and of the flush schedule
+	EstimatedBytes int64 `json:"estimated_bytes"`
+	// PendingWords counts the typed words not stored yet, waiting for their timeout
+	PendingWords int `json:"pending_words"`
+	// PendingUpdates counts the renames buffered by WithWriteBuffer
+	PendingUpdates int `json:"pending_updates"`
+	// QueueDepth counts the searches waiting in the WithAsync queue
+	QueueDepth int `json:"queue_depth"`
+	// SnapshotAge is how long ago the snapshot read by Suggest was taken, 0 without WithSnapshots
+	SnapshotAge time.Duration `json:"snapshot_age_ns"`
+	// SnapshotNodes counts the nodes of that snapshot, shared ones once with WithSnapshotCompaction
+	SnapshotNodes int        `json:"snapshot_nodes"`
+	Lock          LockStats  `json:"lock"`
+	Flushes       FlushStats `json:"flushes"`
+}
+
+// RuntimeStats returns a snapshot of the internals of the logger. It walks the
+// flush schedule under the read lock, so it suits an ops endpoint rather than
+// the request path.
+func (sl *SearchLogger) RuntimeStats() RuntimeStats {
+	// Read before taking the lock, so the snapshot does not count itself
+	lock := LockStats{
+		Acquisitions: sl.mutex.acquisitions.Load(),
+		Contended:    sl.mutex.contended.Load(),
+		Wait:         time.Duration(sl.mutex.waitNanos.Load()),
+	}
+
+	sl.mutex.RLock()
+	defer sl.mutex.RUnlock()
+
+	stats := RuntimeStats{
+		Nodes:          sl.nodes,
+		EstimatedBytes: int64(sl.nodes+1)*nodeBytes + int64(cap(sl.expiry))*int64(unsafe.Sizeof(expiryEntry{})),
+		Lock:           lock,
+		PendingWords:   len(sl.pendingNodesLocked()),
+		Flushes:        sl.flushes,
+	}
+	if sl.updates != nil {
+		stats.PendingUpdates = len(sl.updates.pending)
+	}
+	stats.QueueDepth, _ = sl.QueueDepth()
+	if snapshot := sl.snapshot.Load(); snapshot != nil {
+		stats.SnapshotAge = time.Since(snapshot.at)
+		stats.SnapshotNodes = snapshot.nodes
+	}
+	return stats
+}
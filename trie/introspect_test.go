@@ -0,0 +1,29 @@
+package trie
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuntimeStatsLockContention(t *testing.T) {
+	logger, err := NewSearchLogger(time.Hour)
+	require.NoError(t, err)
+	defer logger.Close()
+
+	logger.mutex.Lock()
+	done := make(chan error)
+	go func() { done <- logger.LogSearch(context.Background(), "bus") }()
+	time.Sleep(20 * time.Millisecond)
+	logger.mutex.Unlock()
+	require.NoError(t, <-done)
+
+	stats := logger.RuntimeStats()
+	assert.Equal(t, int64(1), stats.Lock.Contended)
+	assert.GreaterOrEqual(t, stats.Lock.Wait, 10*time.Millisecond)
+	assert.Equal(t, 3, stats.Nodes)
+	assert.Equal(t, 1, stats.PendingWords)
+}
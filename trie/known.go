@@ -0,0 +1,117 @@
+package trie
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/afanwang/logsearch/metrics"
+	"github.com/afanwang/logsearch/sketch"
+	"github.com/afanwang/logsearch/tracing"
+)
+
+// knownWords lets the repeated searches of stored words skip the write lock
+type knownWords struct {
+	// bloom holds every word that was linked to a store record, it never forgets one
+	bloom *sketch.Bloom
+	mu    sync.Mutex
+	// hits[node] accumulates the searches of the stored word of node since the last flush cycle
+	hits map[*TrieNode]*knownHit
+}
+
+// knownHit is the effect of the fast path searches of a stored word, applied by applyKnownHitsLocked
+type knownHit struct {
+	lastSeen time.Time
+	score    decayScore
+}
+
+// WithKnownWords lets a search of a word already stored skip the write lock:
+// a Bloom filter sized for expected words with falsePositiveRate tells the
+// words that may be stored, and a walk of the trie under the read lock
+// confirms it. Such a search only refreshes the last seen time and decayed
+// score of the word, which the slow path would do too, without building
+// nodes, scanning for extensions or appending to the WAL. Those updates land
+// on the next flush cycle, Flush or Purge. The filter fills past expected
+// words, raising the share of searches confirmed in vain.
+func WithKnownWords(expected int, falsePositiveRate float64) Option {
+	return func(sl *SearchLogger) {
+		if expected > 0 {
+			sl.known = &knownWords{
+				bloom: sketch.NewBloom(expected, falsePositiveRate),
+				hits:  make(map[*TrieNode]*knownHit),
+			}
+		}
+	}
+}
+
+// rememberStored adds a normalized word linked to a store record to the known words, a no-op without WithKnownWords
+func (sl *SearchLogger) rememberStored(word string) {
+	if sl.known != nil {
+		sl.known.bloom.Add(word)
+	}
+}
+
+// logKnownSearch records a search at now of a stored word under the read
+// lock, it returns false when word is not stored or not tagged with tags yet
+// and takes the slow path
+func (sl *SearchLogger) logKnownSearch(ctx context.Context, word string, tags uint64, now time.Time) bool {
+	if sl.known == nil {
+		return false
+	}
+	word = sl.normalizer.Normalize(word)
+	if !sl.known.bloom.Contains(word) {
+		return false
+	}
+
+	sl.mutex.RLock()
+	node := sl.findLocked(word)
+	stored := node != nil && node.isEndOfWord && node.dbID != nil && tags&^node.tags == 0
+	sl.mutex.RUnlock()
+	if !stored {
+		return false
+	}
+
+	var score decayScore
+	if sl.halfLife > 0 {
+		score = decayScore(sl.epochHalfLives(now))
+	}
+	sl.known.mu.Lock()
+	if hit, ok := sl.known.hits[node]; ok {
+		hit.lastSeen = maxTime(hit.lastSeen, now)
+		hit.score = mergeScores(hit.score, score)
+	} else {
+		sl.known.hits[node] = &knownHit{lastSeen: now, score: score}
+	}
+	sl.known.mu.Unlock()
+
+	sl.metrics.SearchLogged(metrics.LoggerTrie)
+	sl.metrics.Decision(metrics.LoggerTrie, metrics.DecisionKnown)
+	tracing.SetDecision(ctx, metrics.DecisionKnown)
+	return true
+}
+
+// applyKnownHitsLocked moves the accumulated fast path searches to their
+// nodes, caller must hold the write lock. A node detached from the trie
+// meanwhile takes them harmlessly.
+func (sl *SearchLogger) applyKnownHitsLocked() {
+	if sl.known == nil {
+		return
+	}
+	sl.known.mu.Lock()
+	hits := sl.known.hits
+	sl.known.hits = make(map[*TrieNode]*knownHit)
+	sl.known.mu.Unlock()
+
+	for node, hit := range hits {
+		node.lastSeen = maxTime(node.lastSeen, hit.lastSeen)
+		node.score = mergeScores(node.score, hit.score)
+	}
+}
+
+// maxTime returns the later of a and b
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
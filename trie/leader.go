@@ -0,0 +1,43 @@
+package trie
+
+import (
+	"context"
+	"log"
+
+	"github.com/afanwang/logsearch/leader"
+)
+
+// WithFlushLeader runs each cycle of the flushing routine, storing the timed
+// out words, flushing the buffered updates or purging the expired words, only
+// while holding lock, for replicas sharing one store whose cycles would race
+// on the same records. A replica finding the lock held skips the cycle, its
+// words stay pending until a later one. Flush and Shutdown do not take the lock.
+func WithFlushLeader(lock leader.Lock) Option {
+	return func(sl *SearchLogger) {
+		sl.flushLeader = lock
+	}
+}
+
+// lead runs cycle, unless another replica leads the flush cycles, see WithFlushLeader
+func (sl *SearchLogger) lead(ctx context.Context, cycle func()) {
+	if sl.flushLeader == nil {
+		cycle()
+		return
+	}
+
+	taken, err := sl.flushLeader.TryLock(ctx)
+	if err != nil {
+		log.Printf("Skipping a flush cycle, failed to take the flush lock: %v", err)
+		return
+	}
+	if !taken {
+		return
+	}
+	defer func() {
+		// Released even when the routine stops meanwhile
+		if err := sl.flushLeader.Unlock(context.WithoutCancel(ctx)); err != nil {
+			log.Printf("Error releasing the flush lock: %v", err)
+		}
+	}()
+	cycle()
+}
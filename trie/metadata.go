@@ -0,0 +1,118 @@
+package trie
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// SetMetadata attaches a JSON payload of the application to a stored word,
+// e.g. its category or its source, replacing its previous one, and writes it
+// next to the record so it is loaded back on startup. An empty payload clears
+// it. It returns store.ErrWordNotFound wrapped when word is not stored. The
+// payload follows the record: it moves to the longer word extending it and
+// RenameWord keeps it, MergeWords keeps the one of the word merged into. The
+// store must implement store.SearchMetadataStore. See Metadata for typed
+// payloads.
+func (sl *SearchLogger) SetMetadata(ctx context.Context, word string, metadata json.RawMessage) error {
+	metadataStore, ok := sl.db.(store.SearchMetadataStore)
+	if !ok {
+		return errors.New("store does not support metadata")
+	}
+	if len(metadata) > 0 && !json.Valid(metadata) {
+		return errors.New("metadata is not valid JSON")
+	}
+	word = sl.normalizer.Normalize(word)
+
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	node := sl.findLocked(word)
+	if node == nil || node.dbID == nil {
+		return fmt.Errorf("%w: %q", store.ErrWordNotFound, word)
+	}
+	// The buffered updates may rename the record to word
+	if err := sl.flushUpdatesLocked(ctx); err != nil {
+		return err
+	}
+	if err := metadataStore.SetMetadata(ctx, word, metadata); err != nil {
+		return fmt.Errorf("failed to set metadata of '%s': %w", word, store.Classify(err))
+	}
+	node.metadata = string(metadata)
+	return nil
+}
+
+// GetMetadata returns the payload of a stored word, false when it has none
+func (sl *SearchLogger) GetMetadata(word string) (json.RawMessage, bool) {
+	word = sl.normalizer.Normalize(word)
+
+	sl.mutex.RLock()
+	defer sl.mutex.RUnlock()
+
+	node := sl.findLocked(word)
+	if node == nil || node.metadata == "" {
+		return nil, false
+	}
+	return json.RawMessage(node.metadata), true
+}
+
+// loadMetadata sets the stored payloads on the words of the trie, when the store keeps them
+func (sl *SearchLogger) loadMetadata(ctx context.Context) error {
+	metadataStore, ok := sl.db.(store.SearchMetadataStore)
+	if !ok {
+		return nil
+	}
+	metadata, err := metadataStore.GetMetadata(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get metadata: %w", store.Classify(err))
+	}
+	for word, payload := range metadata {
+		if node := sl.findLocked(sl.normalizer.Normalize(word)); node != nil && node.isEndOfWord {
+			node.metadata = string(payload)
+		}
+	}
+	return nil
+}
+
+// Metadata reads and writes the payloads of the words of a SearchLogger as
+// values of type T, encoded as JSON, e.g.
+//
+//	type Term struct {
+//		Category string `json:"category"`
+//	}
+//	terms := trie.MetadataOf[Term](trieLogger)
+//	err := terms.Set(ctx, "iphone", Term{Category: "products"})
+type Metadata[T any] struct {
+	sl *SearchLogger
+}
+
+// MetadataOf returns the typed payloads of the words of sl
+func MetadataOf[T any](sl *SearchLogger) Metadata[T] {
+	return Metadata[T]{sl: sl}
+}
+
+// Set attaches value to a stored word, see SearchLogger.SetMetadata
+func (m Metadata[T]) Set(ctx context.Context, word string, value T) error {
+	metadata, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	return m.sl.SetMetadata(ctx, word, metadata)
+}
+
+// Get returns the value attached to a word, false when it has none, and an
+// error when its payload does not decode into T
+func (m Metadata[T]) Get(word string) (T, bool, error) {
+	var value T
+	metadata, ok := m.sl.GetMetadata(word)
+	if !ok {
+		return value, false, nil
+	}
+	if err := json.Unmarshal(metadata, &value); err != nil {
+		return value, false, fmt.Errorf("failed to decode metadata of '%s': %w", word, err)
+	}
+	return value, true, nil
+}
@@ -0,0 +1,72 @@
+package trie
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testTerm struct {
+	Category string  `json:"category"`
+	Score    float64 `json:"score,omitempty"`
+}
+
+func TestMetadata(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDB()
+	logger, err := NewSearchLoggerWithDB(time.Hour, db)
+	require.NoError(t, err)
+
+	for _, word := range []string{"ip", "ca", "cat"} {
+		require.NoError(t, logger.LogSearch(ctx, word))
+	}
+	require.NoError(t, logger.Flush(ctx))
+
+	terms := MetadataOf[testTerm](logger)
+	require.NoError(t, terms.Set(ctx, "IP", testTerm{Category: "products", Score: 0.5}))
+	require.NoError(t, terms.Set(ctx, "cat", testTerm{Category: "pets"}))
+	assert.ErrorIs(t, terms.Set(ctx, "ca", testTerm{}), store.ErrWordNotFound, "Prefixes are not stored")
+	assert.ErrorIs(t, logger.SetMetadata(ctx, "dog", json.RawMessage(`{}`)), store.ErrWordNotFound)
+	assert.Error(t, logger.SetMetadata(ctx, "cat", json.RawMessage(`{`)), "Invalid JSON should be rejected")
+
+	term, ok, err := terms.Get("cat")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, testTerm{Category: "pets"}, term)
+	_, ok, err = terms.Get("dog")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, _, err = MetadataOf[int](logger).Get("cat")
+	assert.Error(t, err, "A payload of another type should not decode")
+
+	// The payload follows the record to the longer word extending it
+	require.NoError(t, logger.LogSearch(ctx, "iphone"))
+	require.NoError(t, logger.Flush(ctx))
+	_, ok = logger.GetMetadata("ip")
+	assert.False(t, ok)
+	term, ok, err = terms.Get("iphone")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, testTerm{Category: "products", Score: 0.5}, term)
+
+	// An empty payload clears it
+	require.NoError(t, logger.SetMetadata(ctx, "cat", nil))
+	_, ok = logger.GetMetadata("cat")
+	assert.False(t, ok)
+	require.NoError(t, logger.Close())
+
+	// The payloads outlive the logger
+	logger, err = NewSearchLoggerWithDB(time.Hour, db)
+	require.NoError(t, err)
+	defer logger.Close()
+	metadata, ok := logger.GetMetadata("iphone")
+	assert.True(t, ok)
+	assert.JSONEq(t, `{"category": "products", "score": 0.5}`, string(metadata))
+	_, ok = logger.GetMetadata("cat")
+	assert.False(t, ok)
+}
@@ -0,0 +1,148 @@
+package trie
+
+import "sort"
+
+// maxEdges is the fanout up to which the children of a node are kept in a
+// slice sorted by grapheme, smaller than a map and faster to scan at that
+// size. Most nodes have a handful of children, the wider ones use a map.
+const maxEdges = 8
+
+// trieEdge leads from a node to its child for a grapheme
+type trieEdge struct {
+	char  string
+	child *TrieNode
+}
+
+// children are the children of a trie node by grapheme, the zero value has none
+type children struct {
+	// edges are sorted by grapheme while the node is narrow
+	edges []trieEdge
+	// wide holds the children once they outgrow maxEdges, edges is nil then
+	wide map[string]*TrieNode
+}
+
+// get returns the child for char, nil if none
+func (c *children) get(char string) *TrieNode {
+	if c.wide != nil {
+		return c.wide[char]
+	}
+	for i := range c.edges {
+		if c.edges[i].char == char {
+			return c.edges[i].child
+		}
+	}
+	return nil
+}
+
+// add adds child for char, which must not have a child yet
+func (c *children) add(char string, child *TrieNode) {
+	if c.wide != nil {
+		c.wide[char] = child
+		return
+	}
+	if len(c.edges) == maxEdges {
+		c.wide = make(map[string]*TrieNode, 2*maxEdges)
+		for _, edge := range c.edges {
+			c.wide[edge.char] = edge.child
+		}
+		c.wide[char] = child
+		c.edges = nil
+		return
+	}
+
+	i := sort.Search(len(c.edges), func(i int) bool { return c.edges[i].char >= char })
+	c.edges = append(c.edges, trieEdge{})
+	copy(c.edges[i+1:], c.edges[i:])
+	c.edges[i] = trieEdge{char: char, child: child}
+}
+
+// remove removes the child for char, if any. A wide node stays wide.
+func (c *children) remove(char string) {
+	if c.wide != nil {
+		delete(c.wide, char)
+		return
+	}
+	for i := range c.edges {
+		if c.edges[i].char == char {
+			last := len(c.edges) - 1
+			copy(c.edges[i:], c.edges[i+1:])
+			c.edges[last] = trieEdge{}
+			c.edges = c.edges[:last]
+			return
+		}
+	}
+}
+
+// len returns the number of children
+func (c *children) len() int {
+	if c.wide != nil {
+		return len(c.wide)
+	}
+	return len(c.edges)
+}
+
+// each calls fn with every child in grapheme order until fn returns false,
+// and reports whether it went through all of them
+func (c *children) each(fn func(char string, child *TrieNode) bool) bool {
+	if c.wide == nil {
+		for _, edge := range c.edges {
+			if !fn(edge.char, edge.child) {
+				return false
+			}
+		}
+		return true
+	}
+
+	chars := make([]string, 0, len(c.wide))
+	for char := range c.wide {
+		chars = append(chars, char)
+	}
+	sort.Strings(chars)
+	for _, char := range chars {
+		if !fn(char, c.wide[char]) {
+			return false
+		}
+	}
+	return true
+}
+
+// WithNodeArena allocates the trie nodes chunkSize at a time rather than one
+// by one, cutting the allocations and the GC work when millions of nodes are
+// live. A chunk is only freed once none of its nodes is in the trie anymore,
+// so the nodes removed by retention keep their memory while their chunk
+// holds other nodes. Chunks of a few hundred nodes suit most tries.
+func WithNodeArena(chunkSize int) Option {
+	return func(sl *SearchLogger) {
+		if chunkSize > 0 {
+			sl.arena = &nodeArena{chunkSize: chunkSize}
+		}
+	}
+}
+
+// nodeArena allocates the trie nodes in chunks, so millions of live nodes
+// cost a few thousand allocations and the GC tracks a few thousand objects,
+// see WithNodeArena. It is guarded by the write lock of the trie.
+type nodeArena struct {
+	chunkSize int
+	chunk     []TrieNode
+}
+
+// alloc returns a zeroed node
+func (a *nodeArena) alloc() *TrieNode {
+	if a == nil {
+		return &TrieNode{}
+	}
+	if len(a.chunk) == 0 {
+		a.chunk = make([]TrieNode, a.chunkSize)
+	}
+	node := &a.chunk[0]
+	a.chunk = a.chunk[1:]
+	return node
+}
+
+// reset drops the rest of the current chunk, once the trie it served is discarded
+func (a *nodeArena) reset() {
+	if a != nil {
+		a.chunk = nil
+	}
+}
@@ -0,0 +1,73 @@
+package trie
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// childChars returns the graphemes of the children in the order each visits them
+func childChars(c *children) []string {
+	var chars []string
+	c.each(func(char string, _ *TrieNode) bool {
+		chars = append(chars, char)
+		return true
+	})
+	return chars
+}
+
+func TestChildren(t *testing.T) {
+	var c children
+	nodes := map[string]*TrieNode{}
+	for _, char := range []string{"d", "b", "é", "a", "c"} {
+		nodes[char] = &TrieNode{}
+		c.add(char, nodes[char])
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d", "é"}, childChars(&c))
+	assert.Same(t, nodes["é"], c.get("é"))
+	assert.Nil(t, c.get("e"))
+
+	c.remove("b")
+	c.remove("x")
+	assert.Equal(t, []string{"a", "c", "d", "é"}, childChars(&c))
+	assert.Nil(t, c.get("b"))
+
+	// Outgrowing maxEdges switches to a map, still visited in order
+	for i := 0; i < maxEdges; i++ {
+		c.add(fmt.Sprint(i), &TrieNode{})
+	}
+	assert.NotNil(t, c.wide)
+	assert.Equal(t, maxEdges+4, c.len())
+	chars := childChars(&c)
+	assert.IsIncreasing(t, chars)
+	assert.Same(t, nodes["d"], c.get("d"))
+
+	visited := 0
+	assert.False(t, c.each(func(string, *TrieNode) bool {
+		visited++
+		return visited < 3
+	}))
+	assert.Equal(t, 3, visited)
+}
+
+func TestNodeArena(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLogger(time.Hour, WithNodeArena(4))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	for _, word := range []string{"business", "bus", "cat"} {
+		require.NoError(t, logger.LogSearch(ctx, word))
+	}
+	require.NoError(t, logger.Flush(ctx))
+	suggestions, err := logger.Suggest("b", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"business"}, suggestions)
+	stats, err := logger.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 11, stats.Nodes)
+}
@@ -0,0 +1,38 @@
+package trie
+
+import "time"
+
+// WithPruning drops the path of every word from the trie once it is stored,
+// unless the path is shared with words still being typed, so the trie only
+// holds the recent searches instead of every word ever stored. A stored word
+// typed again is stored again once it times out and the store counts one more
+// search of its record, the Bloom filter of WithKnownWords telling nothing
+// since the word is no longer in the trie. The trie forgets what it pruned:
+// a longer word extending a pruned one is stored as a word of its own rather
+// than replacing it, and Suggest only completes the words still in the trie.
+// Verified words are kept.
+func WithPruning() Option {
+	return func(sl *SearchLogger) {
+		sl.pruning = true
+	}
+}
+
+// pruneLocked drops the stored word and the nodes of its path left without a
+// purpose, unless it was typed after cutoff, was verified or prefixes another
+// word, caller must hold the write lock. Its record stays in the store.
+func (sl *SearchLogger) pruneLocked(word string, cutoff time.Time) {
+	path, chars := sl.wordPathLocked(word)
+	if path == nil {
+		return
+	}
+	node := path[len(path)-1]
+	if node.verified || node.children.len() > 0 || node.lastSeen.After(cutoff) {
+		return
+	}
+
+	node.dbID = nil
+	node.isEndOfWord = false
+	node.metadata = ""
+	node.tags, node.storedTags = 0, 0
+	sl.detachLocked(path, chars, cutoff)
+}
@@ -0,0 +1,50 @@
+package trie
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPruning(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDB()
+	logger, err := NewSearchLoggerWithDB(time.Hour, db, WithPruning())
+	require.NoError(t, err)
+	defer logger.Close()
+
+	// "cat" timed out, "car" is still being typed
+	now := time.Now()
+	for _, word := range []string{"c", "ca", "cat"} {
+		require.NoError(t, logger.logSearchAt(ctx, word, now.Add(-10*time.Second)))
+	}
+	require.NoError(t, logger.logSearchAt(ctx, "car", now))
+	logger.mutex.Lock()
+	require.NoError(t, logger.processTimedOutWordsLocked(ctx, now.Add(-5*time.Second), 0))
+	logger.mutex.Unlock()
+
+	stored, err := logger.GetStoredSearches(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cat"}, stored)
+	assert.Equal(t, 3, logger.nodes, "Only the path of car should be left")
+	suggestions, err := logger.Suggest("ca", 10)
+	require.NoError(t, err)
+	assert.Empty(t, suggestions, "Pruned words are not suggested")
+
+	// A pruned word typed again counts one more search of its record
+	require.NoError(t, logger.LogSearch(ctx, "cat"))
+	require.NoError(t, logger.Flush(ctx))
+	assert.Zero(t, logger.nodes)
+	records, err := db.GetAllRecords(ctx)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	counts := map[string]int{}
+	for _, record := range records {
+		counts[record.Word] = record.SearchCount
+	}
+	assert.Equal(t, map[string]int{"cat": 2, "car": 1}, counts)
+}
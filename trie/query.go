@@ -0,0 +1,63 @@
+package trie
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// QueryStoredSearches is GetStoredSearches filtered, ordered and paged by the
+// query, e.g. the 20 most searched words starting with "ca". The prefix is
+// normalized like the searches. It fails unless the store implements
+// store.QuerySearchStore.
+func (sl *SearchLogger) QueryStoredSearches(ctx context.Context, query store.SearchQuery) ([]string, error) {
+	records, err := sl.QueryStoredRecords(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	words := make([]string, len(records))
+	for i, record := range records {
+		words[i] = record.Word
+	}
+	return words, nil
+}
+
+// QueryStoredRecords is QueryStoredSearches returning the full records, see GetStoredRecords
+func (sl *SearchLogger) QueryStoredRecords(ctx context.Context, query store.SearchQuery) ([]store.SearchRecord, error) {
+	queryStore, ok := sl.db.(store.QuerySearchStore)
+	if !ok {
+		return nil, errors.New("store does not support search queries")
+	}
+	if query.Prefix != "" {
+		query.Prefix = sl.normalizer.Normalize(query.Prefix)
+	}
+
+	sl.mutex.RLock()
+	defer sl.mutex.RUnlock()
+
+	records, err := queryStore.QuerySearches(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stored searches: %w", store.Classify(err))
+	}
+	return records, nil
+}
+
+// SearchCount returns the search count of the stored record of word, or
+// store.ErrWordNotFound wrapped when it is not stored. It fails unless the
+// store implements store.QuerySearchStore.
+func (sl *SearchLogger) SearchCount(ctx context.Context, word string) (int, error) {
+	word = sl.normalizer.Normalize(word)
+	records, err := sl.QueryStoredRecords(ctx, store.SearchQuery{Prefix: word})
+	if err != nil {
+		return 0, err
+	}
+	for _, record := range records {
+		if record.Word == word {
+			return record.SearchCount, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", store.ErrWordNotFound, word)
+}
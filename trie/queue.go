@@ -0,0 +1,186 @@
+package trie
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/metrics"
+	"github.com/afanwang/logsearch/tracing"
+)
+
+// QueuePolicy decides what LogSearch does when the async queue is full
+type QueuePolicy int
+
+const (
+	// Block makes LogSearch wait for room in the queue, or for its ctx to be done
+	Block QueuePolicy = iota
+	// Drop discards the search and makes LogSearch return logsearch.ErrQueueFull
+	Drop
+)
+
+// errQueueClosed is returned by LogSearch once Shutdown stopped the async queue
+var errQueueClosed = errors.New("search logger is shut down")
+
+// WithAsync makes LogSearch enqueue the searches in a queue of size slots
+// consumed by workers goroutines, so bursts of searches do not wait on the trie
+// mutex. policy decides whether a full queue blocks or drops the search.
+// Searches are validated before they are queued, store failures are only
+// logged since the caller is gone. Queued searches are not in the WAL yet, a
+// crash loses them. Flush waits for the queue to empty and Shutdown drains it.
+func WithAsync(size, workers int, policy QueuePolicy) Option {
+	return func(sl *SearchLogger) {
+		if size <= 0 || workers <= 0 {
+			return
+		}
+		sl.queue = &asyncQueue{
+			searches: make(chan queuedSearch, size),
+			workers:  workers,
+			policy:   policy,
+		}
+		sl.queue.idle = sync.NewCond(&sl.queue.mutex)
+	}
+}
+
+// queuedSearch is a search waiting in the async queue, tags are its
+// categories, at is when LogSearch received it and span the span of its
+// LogSearch call
+type queuedSearch struct {
+	word string
+	tags uint64
+	at   time.Time
+	span trace.SpanContext
+}
+
+// asyncQueue hands the searches of LogSearch to the worker goroutines
+type asyncQueue struct {
+	searches chan queuedSearch
+	workers  int
+	policy   QueuePolicy
+	wg       sync.WaitGroup
+
+	// mutex guards closed and inflight, idle is signalled when inflight drops to 0
+	mutex  sync.Mutex
+	idle   *sync.Cond
+	closed bool
+	// inflight counts the searches enqueued and not processed yet
+	inflight int
+}
+
+// startWorkers starts the goroutines consuming the async queue
+func (sl *SearchLogger) startWorkers(ctx context.Context) {
+	if sl.queue == nil {
+		return
+	}
+
+	for i := 0; i < sl.queue.workers; i++ {
+		sl.queue.wg.Add(1)
+		go sl.worker(ctx)
+	}
+}
+
+// worker logs the queued searches until the queue is closed and empty
+func (sl *SearchLogger) worker(ctx context.Context) {
+	defer sl.queue.wg.Done()
+
+	for search := range sl.queue.searches {
+		sl.metrics.SetQueueDepth(metrics.LoggerTrie, len(sl.queue.searches))
+		// The span continues the trace of the LogSearch call that queued the search
+		searchCtx, span := sl.tracer.Start(trace.ContextWithSpanContext(ctx, search.span), "trie.logQueued",
+			tracing.KeyLogger.String(metrics.LoggerTrie), tracing.KeyWordLength.Int(len(search.word)))
+		err := sl.logTaggedAt(searchCtx, search.word, search.tags, search.at)
+		if err != nil {
+			log.Printf("Error logging queued search '%s': %v", search.word, err)
+		}
+		tracing.End(span, err)
+		sl.queue.done()
+	}
+}
+
+// enqueue queues a search according to the queue policy
+func (sl *SearchLogger) enqueue(ctx context.Context, word string, tags uint64, at time.Time) error {
+	q := sl.queue
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		return errQueueClosed
+	}
+	q.inflight++
+	q.mutex.Unlock()
+
+	search := queuedSearch{word: word, tags: tags, at: at, span: trace.SpanContextFromContext(ctx)}
+	select {
+	case q.searches <- search:
+		sl.metrics.SetQueueDepth(metrics.LoggerTrie, len(q.searches))
+		return nil
+	default:
+	}
+
+	if q.policy == Drop {
+		q.done()
+		sl.metrics.SearchDropped(metrics.LoggerTrie)
+		return logsearch.ErrQueueFull
+	}
+
+	select {
+	case q.searches <- search:
+		sl.metrics.SetQueueDepth(metrics.LoggerTrie, len(q.searches))
+		return nil
+	case <-ctx.Done():
+		q.done()
+		return ctx.Err()
+	}
+}
+
+// done marks a queued search as processed or abandoned
+func (q *asyncQueue) done() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.inflight--
+	if q.inflight == 0 {
+		q.idle.Broadcast()
+	}
+}
+
+// wait blocks until every search enqueued so far is processed, a nil queue returns at once
+func (q *asyncQueue) wait() {
+	if q == nil {
+		return
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for q.inflight > 0 {
+		q.idle.Wait()
+	}
+}
+
+// stop rejects new searches and waits for the workers to drain the queue.
+// Senders blocked on a full queue hold an inflight slot, so the channel is
+// only closed once they are through.
+func (q *asyncQueue) stop() {
+	if q == nil {
+		return
+	}
+
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		return
+	}
+	q.closed = true
+	for q.inflight > 0 {
+		q.idle.Wait()
+	}
+	q.mutex.Unlock()
+
+	close(q.searches)
+	q.wg.Wait()
+}
@@ -0,0 +1,108 @@
+package trie
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsyncQueue(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLogger(time.Hour, WithAsync(16, 4, Block))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for _, word := range []string{"b", "bu", "bus"} {
+				assert.NoError(t, logger.LogSearch(ctx, fmt.Sprintf("%s%d", word, g)))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// Flush waits for the queued searches before storing the pending words
+	require.NoError(t, logger.Flush(ctx))
+	stored, err := logger.GetStoredSearches(ctx)
+	require.NoError(t, err)
+	assert.Len(t, stored, 24)
+	assert.Zero(t, len(logger.queue.searches))
+}
+
+// logUntilRejected logs searches while the workers are stuck on the trie mutex
+// until one is rejected, a queue of one slot and one worker takes at most two
+func logUntilRejected(t *testing.T, logger *SearchLogger, newCtx func() (context.Context, context.CancelFunc)) (int, error) {
+	for i := 0; i < 3; i++ {
+		ctx, cancel := newCtx()
+		err := logger.LogSearch(ctx, fmt.Sprintf("word%d", i))
+		cancel()
+		if err != nil {
+			return i, err
+		}
+	}
+	t.Fatal("the full queue accepted every search")
+	return 0, nil
+}
+
+func TestAsyncQueuePolicies(t *testing.T) {
+	t.Run("drop", func(t *testing.T) {
+		logger, err := NewSearchLogger(time.Hour, WithAsync(1, 1, Drop))
+		require.NoError(t, err)
+		defer logger.Close()
+
+		logger.mutex.Lock()
+		accepted, err := logUntilRejected(t, logger, func() (context.Context, context.CancelFunc) {
+			return context.WithCancel(context.Background())
+		})
+		logger.mutex.Unlock()
+		assert.ErrorIs(t, err, logsearch.ErrQueueFull)
+
+		// The accepted searches are logged once the workers catch up
+		require.NoError(t, logger.Flush(context.Background()))
+		stored, err := logger.GetStoredSearches(context.Background())
+		require.NoError(t, err)
+		assert.Len(t, stored, accepted)
+	})
+
+	t.Run("block", func(t *testing.T) {
+		logger, err := NewSearchLogger(time.Hour, WithAsync(1, 1, Block))
+		require.NoError(t, err)
+		defer logger.Close()
+
+		logger.mutex.Lock()
+		_, err = logUntilRejected(t, logger, func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 20*time.Millisecond)
+		})
+		logger.mutex.Unlock()
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestAsyncQueueShutdown(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDB()
+	logger, err := NewSearchLoggerWithDB(time.Hour, db, WithAsync(100, 2, Block))
+	require.NoError(t, err)
+
+	for _, word := range []string{"bus", "cat", "dog"} {
+		require.NoError(t, logger.LogSearch(ctx, word))
+	}
+
+	// Close drains the queue into the pending words, then stores them
+	require.NoError(t, logger.Close())
+	stored, err := db.GetAllSearchedWords(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"bus", "cat", "dog"}, stored)
+
+	assert.Error(t, logger.LogSearch(ctx, "emu"), "A shut down logger should reject searches")
+}
@@ -0,0 +1,115 @@
+package trie
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/afanwang/logsearch/changes"
+	"github.com/afanwang/logsearch/metrics"
+	"github.com/afanwang/logsearch/store"
+)
+
+// WithRetention deletes the stored words nobody searched within window from
+// the store and the trie, checking every interval, e.g.
+// WithRetention(90*24*time.Hour, time.Hour). The store must implement
+// store.SearchPurgeStore.
+func WithRetention(window, interval time.Duration) Option {
+	return func(sl *SearchLogger) {
+		if window > 0 && interval > 0 {
+			sl.retention = window
+			sl.retentionInterval = interval
+		}
+	}
+}
+
+// Purge deletes the stored words last updated before cutoff from the store
+// and the trie, and returns how many records were deleted. The retention
+// reaper calls it on its own, it is exported for one-off purges.
+func (sl *SearchLogger) Purge(ctx context.Context, cutoff time.Time) (int64, error) {
+	purgeStore, ok := sl.db.(store.SearchPurgeStore)
+	if !ok {
+		return 0, errors.New("store does not support purging searches")
+	}
+
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	// The searches of the fast path keep their words from expiring
+	sl.applyKnownHitsLocked()
+
+	// Buffered renames carry newer timestamps, they must land before the records are judged
+	if err := sl.flushUpdatesLocked(ctx); err != nil {
+		return 0, fmt.Errorf("failed to flush updates before purging: %w", err)
+	}
+
+	words, err := purgeStore.PurgeSearches(ctx, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge searches: %w", store.Classify(err))
+	}
+
+	now := time.Now()
+	for _, word := range words {
+		sl.removeWordLocked(sl.normalizer.Normalize(word), cutoff)
+		sl.changes.Emit(changes.Change{Op: changes.OpDelete, Word: word, At: now})
+	}
+	sl.metrics.SetTrieNodes(sl.nodes)
+	sl.metrics.Purged(metrics.LoggerTrie, int64(len(words)))
+	return int64(len(words)), nil
+}
+
+// removeWordLocked detaches a purged word from the trie and prunes the nodes
+// left without a purpose, caller must hold the write lock. A word typed again
+// after cutoff stays in the trie, it is stored anew once it times out.
+func (sl *SearchLogger) removeWordLocked(word string, cutoff time.Time) {
+	path, chars := sl.wordPathLocked(word)
+	if path == nil {
+		return
+	}
+	node := path[len(path)-1]
+
+	node.dbID = nil
+	node.verified = false
+	node.metadata = ""
+	node.tags, node.storedTags = 0, 0
+	if node.lastSeen.After(cutoff) {
+		return
+	}
+	node.isEndOfWord = false
+	sl.detachLocked(path, chars, cutoff)
+}
+
+// wordPathLocked returns the nodes from the root to the node of word and the
+// graphemes between them, nil if word is not in the trie, caller must hold the lock
+func (sl *SearchLogger) wordPathLocked(word string) ([]*TrieNode, []string) {
+	path := []*TrieNode{sl.trieRoot}
+	chars := []string{}
+	node := sl.trieRoot
+	for _, char := range graphemes(word) {
+		node = node.children.get(char)
+		if node == nil {
+			return nil, nil
+		}
+		path = append(path, node)
+		chars = append(chars, char)
+	}
+	return path, chars
+}
+
+// detachLocked removes the last node of path, no longer a word, and then its
+// ancestors left without a purpose, up to the first one still typed after
+// cutoff, holding a word or other children, caller must hold the write lock
+func (sl *SearchLogger) detachLocked(path []*TrieNode, chars []string, cutoff time.Time) {
+	// Zeroing lastSeen turns the expiry entries of removed words stale
+	path[len(path)-1].lastSeen = time.Time{}
+	for i := len(path) - 1; i > 0; i-- {
+		child := path[i]
+		if child.children.len() > 0 || child.isEndOfWord || child.dbID != nil || child.lastSeen.After(cutoff) {
+			return
+		}
+		child.lastSeen = time.Time{}
+		path[i-1].children.remove(chars[i-1])
+		sl.nodes--
+	}
+}
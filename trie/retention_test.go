@@ -0,0 +1,91 @@
+package trie
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPurge(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLogger(time.Hour)
+	require.NoError(t, err)
+	defer logger.Close()
+
+	for _, word := range []string{"bus", "busy"} {
+		require.NoError(t, logger.LogSearch(ctx, word))
+	}
+	require.NoError(t, logger.LogSearch(ctx, "dog"))
+	require.NoError(t, logger.Flush(ctx))
+	time.Sleep(10 * time.Millisecond)
+	cutoff := time.Now()
+
+	require.NoError(t, logger.LogSearch(ctx, "cat"))
+	require.NoError(t, logger.Flush(ctx))
+
+	purged, err := logger.Purge(ctx, cutoff)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+
+	stored, err := logger.GetStoredSearches(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cat"}, stored)
+
+	suggestions, err := logger.Suggest("", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cat"}, suggestions)
+	assert.Equal(t, 3, logger.nodes, "Only the nodes of cat should be left")
+
+	// A purged word searched again is stored anew
+	require.NoError(t, logger.LogSearch(ctx, "dog"))
+	require.NoError(t, logger.Flush(ctx))
+	stored, err = logger.GetStoredSearches(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"cat", "dog"}, stored)
+}
+
+func TestRetentionReaper(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLogger(time.Hour, WithRetention(time.Millisecond, 10*time.Millisecond))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	require.NoError(t, logger.LogSearch(ctx, "bus"))
+	require.NoError(t, logger.Flush(ctx))
+
+	assert.Eventually(t, func() bool {
+		stored, err := logger.GetStoredSearches(ctx)
+		return err == nil && len(stored) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = NewSearchLoggerWithDB(time.Hour, &discardStore{}, WithRetention(time.Hour, time.Minute))
+	assert.Error(t, err, "Stores that cannot purge should be rejected")
+}
+
+func TestPurgeFlushesBufferedUpdates(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDB()
+	logger, err := NewSearchLoggerWithDB(time.Hour, db, WithWriteBuffer(100, time.Hour))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	require.NoError(t, logger.LogSearch(ctx, "bus"))
+	require.NoError(t, logger.Flush(ctx))
+	time.Sleep(10 * time.Millisecond)
+	cutoff := time.Now()
+
+	// The rename to "business" is buffered with a timestamp after cutoff
+	require.NoError(t, logger.LogSearch(ctx, "business"))
+
+	purged, err := logger.Purge(ctx, cutoff)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), purged)
+
+	stored, err := db.GetAllSearchedWords(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"business"}, stored)
+}
@@ -0,0 +1,870 @@
+// Package trie implements the Version 1 search logger: a global trie of
+// search prefixes where words are only stored after a timeout, once the user
+// is assumed to have finished typing.
+package trie
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/rivo/uniseg"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/changes"
+	"github.com/afanwang/logsearch/leader"
+	"github.com/afanwang/logsearch/metrics"
+	"github.com/afanwang/logsearch/normalize"
+	"github.com/afanwang/logsearch/sketch"
+	"github.com/afanwang/logsearch/store"
+	"github.com/afanwang/logsearch/tracing"
+	"github.com/afanwang/logsearch/trending"
+	"github.com/afanwang/logsearch/wal"
+)
+
+// TrieNode represents a node in the trie structure, its children are keyed by
+// grapheme cluster so a multi-rune character is never split across nodes
+type TrieNode struct {
+	children    children
+	isEndOfWord bool
+	lastSeen    time.Time
+	// ID of the record in DB if stored
+	dbID *int64
+	// seq is the WAL sequence number of the last search of the word, 0 without WAL
+	seq uint64
+	// score is the decayed search count of the word, 0 without WithDecay
+	score decayScore
+	// verified is set once a moderator reviewed the stored word, see MarkVerified
+	verified bool
+	// forms counts the forms the word was typed in, nil without WithDisplayForms
+	forms *displayForms
+	// metadata is the JSON payload attached to the stored word, see SetMetadata
+	metadata string
+	// tags are the bits of the categories the word was searched in, storedTags
+	// the ones written to the store, see WithCategories
+	tags, storedTags uint64
+}
+
+// SearchLogger handles search deduplication and storage
+type SearchLogger struct {
+	trieRoot *TrieNode
+	db       store.SearchStore
+	mutex    meteredMutex
+	// timeout is how long to wait before considering a word "complete"
+	timeout time.Duration
+	// cancel stops the flushing routine and aborts its in-flight DB writes, done is closed once it returned
+	cancel context.CancelFunc
+	done   chan struct{}
+	// heartbeat is the UnixNano time the flushing routine last completed a round
+	heartbeat atomic.Int64
+	// updates buffers the renames of extended words, nil when disabled
+	updates *updateBuffer
+	// expiry schedules the typed words by lastSeen for the flushing routine
+	expiry expiryHeap
+	// metrics records the ingestion and flush pipeline, nil when disabled
+	metrics *metrics.Metrics
+	// tracer traces the ingestion and flush pipeline, nil when disabled
+	tracer *tracing.Tracer
+	// nodes counts the trie nodes below the root
+	nodes int
+	// flushes describes the flush cycles, guarded by mutex
+	flushes FlushStats
+	// drainMinLength and drainDeadline configure Close, see WithDrain
+	drainMinLength int
+	drainDeadline  time.Duration
+	// validator bounds and sanitizes every raw search
+	validator *logsearch.Validator
+	// normalizer maps every search, suggest prefix and loaded word to the form kept in the trie
+	normalizer normalize.Normalizer
+	// retention and retentionInterval configure the reaper, see WithRetention
+	retention         time.Duration
+	retentionInterval time.Duration
+	// heavy estimates the most searched words in bounded memory, nil when disabled
+	heavy *sketch.TopK
+	// trending counts the stored words in rolling time buckets, nil when disabled
+	trending *trending.Tracker
+	// wal records every search before it touches the trie, nil when disabled
+	wal *wal.Log
+	// hooks are called after every word written to the store
+	hooks []logsearch.WordFinalizedHook
+	// changes receives the mutations of the store, nil when disabled
+	changes *changes.Stream
+	// filters reject the words not eligible for storage
+	filters []logsearch.Filter
+	// queue hands the searches of LogSearch to worker goroutines, nil when disabled
+	queue *asyncQueue
+	// halfLife is the half-life of the decayed scores, 0 when disabled, see WithDecay
+	halfLife time.Duration
+	// cjkBigrams segments CJK runs into bigrams for prefix consolidation, see WithCJKBigrams
+	cjkBigrams bool
+	// branchMinShared is the shared prefix of a superseded branch, 0 when disabled, see WithBranchDetection
+	branchMinShared int
+	// known takes the searches of stored words under the read lock, nil when disabled, see WithKnownWords
+	known *knownWords
+	// flushLeader elects the replica running a flush cycle, nil when disabled, see WithFlushLeader
+	flushLeader leader.Lock
+	// pruning drops the paths of the stored words, see WithPruning
+	pruning bool
+	// displayForms counts the typed forms of the words, see WithDisplayForms
+	displayForms bool
+	// snapshotInterval is how often the snapshot read by Suggest is rebuilt, 0 when disabled, see WithSnapshots
+	snapshotInterval time.Duration
+	snapshot         atomic.Pointer[trieSnapshot]
+	// compactSnapshots shares the equal subtrees of the snapshots, see WithSnapshotCompaction
+	compactSnapshots bool
+	// categories maps the categories declared by WithCategories to their tag bit, categoryNames by bit
+	categories    map[string]uint64
+	categoryNames []string
+	// maxNodes is the node budget of the trie, 0 when unbounded, see WithNodeBudget
+	maxNodes int
+	// evicted counts the nodes evicted to honor the budget, guarded by mutex
+	evicted int64
+	// arena allocates the trie nodes in chunks, nil when disabled, see WithNodeArena
+	arena *nodeArena
+	// clusters and path are reused by every search to split its word and walk its nodes, under the write lock
+	clusters []string
+	path     []*TrieNode
+}
+
+// WithMetrics records searches, dedup decisions, flushes, store writes and the trie size in m
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(sl *SearchLogger) {
+		sl.metrics = m
+	}
+}
+
+// WithTracing records a span for every search, flush and store write with t,
+// child of the span of the context passed to LogSearch
+func WithTracing(t *tracing.Tracer) Option {
+	return func(sl *SearchLogger) {
+		sl.tracer = t
+	}
+}
+
+// WithDrain configures how Close persists the pending words: words shorter than
+// minLength characters are dropped instead of stored, and Close gives up on the
+// words left after deadline. Zero values store every pending word however long
+// the store takes.
+func WithDrain(minLength int, deadline time.Duration) Option {
+	return func(sl *SearchLogger) {
+		sl.drainMinLength = minLength
+		sl.drainDeadline = deadline
+	}
+}
+
+// WithValidator replaces logsearch.DefaultValidator, see logsearch.WithValidator
+func WithValidator(v *logsearch.Validator) Option {
+	return func(sl *SearchLogger) {
+		sl.validator = v
+	}
+}
+
+// WithNormalizer replaces the default trimming and lowercasing of searches,
+// e.g. with normalize.NewUnicode(normalize.WithDiacriticFolding()). The words
+// loaded from the store are normalized too, so variants stored before the
+// change share one trie path.
+func WithNormalizer(n normalize.Normalizer) Option {
+	return func(sl *SearchLogger) {
+		sl.normalizer = n
+	}
+}
+
+// WithHeavyHitters tracks the k most searched words in bounded memory with a
+// count-min sketch, see logsearch.WithHeavyHitters. GetTopSearches then answers
+// from the sketch instead of the store. A completed word counts once, and
+// extending a stored word moves one count to the longer word.
+func WithHeavyHitters(k int, epsilon, delta float64) Option {
+	return func(sl *SearchLogger) {
+		if k > 0 {
+			sl.heavy = sketch.NewTopK(k, sketch.NewCountMinWithError(epsilon, delta))
+		}
+	}
+}
+
+// WithFilters keeps the words rejected by any of filters out of the store,
+// e.g. logsearch.MinLength(2). A timed out word they reject is dropped from
+// the pending words, and a stored word is not extended to a rejected one.
+func WithFilters(filters ...logsearch.Filter) Option {
+	return func(sl *SearchLogger) {
+		sl.filters = append(sl.filters, filters...)
+	}
+}
+
+// NewSearchLogger creates a new SearchLogger instance
+// It will be called by the http server which hosts
+// api /Query={word}&Limit={limit}&Verified={bool}
+func NewSearchLogger(timeout time.Duration, opts ...Option) (*SearchLogger, error) {
+	db := store.NewMockPostgresDB()
+	return NewSearchLoggerWithDB(timeout, db, opts...)
+}
+
+// NewSearchLoggerWithDB creates a new SearchLogger on top of any SearchStore
+func NewSearchLoggerWithDB(timeout time.Duration, db store.SearchStore, opts ...Option) (*SearchLogger, error) {
+	// ctx lives until Close and is cancelled to stop the flushing routine
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Create table using the store
+	if err := db.CreateTable(ctx); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to create table: %w", store.Classify(err))
+	}
+
+	logger := &SearchLogger{
+		trieRoot:   &TrieNode{},
+		db:         db,
+		timeout:    timeout,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+		validator:  logsearch.DefaultValidator,
+		normalizer: normalize.Default,
+	}
+	for _, opt := range opts {
+		opt(logger)
+	}
+
+	if _, ok := db.(store.SearchPurgeStore); logger.retention > 0 && !ok {
+		cancel()
+		return nil, errors.New("retention needs a store that supports purging searches")
+	}
+	if _, ok := db.(store.SearchDisplayStore); logger.displayForms && !ok {
+		cancel()
+		return nil, errors.New("display forms need a store that supports display words")
+	}
+	if _, ok := db.(store.SearchCategoryStore); len(logger.categoryNames) > 0 && !ok {
+		cancel()
+		return nil, errors.New("categories need a store that supports categories")
+	}
+	if logger.compactSnapshots && logger.snapshotInterval == 0 {
+		cancel()
+		return nil, errors.New("snapshot compaction needs WithSnapshots")
+	}
+
+	// Load existing words from database and build the prefix tree
+	if err := logger.loadExistingWords(ctx); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to load existing words: %w", err)
+	}
+
+	// Searches whose words had not reached the store before a crash
+	if err := logger.replayWAL(ctx); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to replay wal: %w", err)
+	}
+
+	// Reads never fall back to the trie lock once the first snapshot is published
+	if logger.snapshotInterval > 0 {
+		logger.refreshSnapshot()
+	}
+
+	// Start flushCompletedWordToDB goroutine
+	logger.heartbeat.Store(time.Now().UnixNano())
+	go logger.flushCompletedWordToDBRoutine(ctx)
+	logger.startWorkers(ctx)
+
+	return logger, nil
+}
+
+// NewSearchLoggerWithPostgres creates a new SearchLogger backed by a real PostgreSQL database
+func NewSearchLoggerWithPostgres(timeout time.Duration, cfg store.PostgresConfig, opts ...Option) (*SearchLogger, error) {
+	db, err := store.NewPostgresDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	logger, err := NewSearchLoggerWithDB(timeout, db, opts...)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return logger, nil
+}
+
+// NewSearchLoggerWithSQLite creates a new SearchLogger backed by an embedded SQLite database
+func NewSearchLoggerWithSQLite(timeout time.Duration, cfg store.SQLiteConfig, opts ...Option) (*SearchLogger, error) {
+	db, err := store.NewSQLiteDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	logger, err := NewSearchLoggerWithDB(timeout, db, opts...)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return logger, nil
+}
+
+// LogSearch processes a search term and stores it. It returns ErrEmptyWord,
+// ErrWordTooLong or ErrInvalidInput of the logsearch package for invalid input
+// and ErrStoreUnavailable, wrapped, when the store cannot be reached.
+func (sl *SearchLogger) LogSearch(ctx context.Context, word string) error {
+	return sl.logSearch(ctx, word, 0)
+}
+
+// logSearch implements LogSearch and LogSearchEvent, tagging the word with tags
+func (sl *SearchLogger) logSearch(ctx context.Context, word string, tags uint64) (err error) {
+	ctx, span := sl.tracer.Start(ctx, "trie.LogSearch", tracing.KeyLogger.String(metrics.LoggerTrie))
+	defer func() { tracing.End(span, err) }()
+
+	word, err = sl.validator.Sanitize(word)
+	if err != nil {
+		return err
+	}
+	span.SetAttributes(tracing.KeyWordLength.Int(len(word)))
+	if err := sl.checkNormalized(word); err != nil {
+		return err
+	}
+
+	if sl.logKnownSearch(ctx, word, tags, time.Now()) {
+		return nil
+	}
+	if sl.queue != nil {
+		return sl.enqueue(ctx, word, tags, time.Now())
+	}
+	return sl.logTaggedAt(ctx, word, tags, time.Now())
+}
+
+// checkNormalized returns ErrEmptyWord when normalization leaves nothing of
+// word, e.g. a word made of spaces or of emoji stripped by the normalizer
+func (sl *SearchLogger) checkNormalized(word string) error {
+	if sl.normalizer.Normalize(word) == "" {
+		return logsearch.ErrEmptyWord
+	}
+	return nil
+}
+
+// logSearchAt records a search received at now in the wal and the trie
+func (sl *SearchLogger) logSearchAt(ctx context.Context, word string, now time.Time) error {
+	return sl.logTaggedAt(ctx, word, 0, now)
+}
+
+// logTaggedAt is logSearchAt tagging the word with tags
+func (sl *SearchLogger) logTaggedAt(ctx context.Context, word string, tags uint64, now time.Time) error {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+	// The wait for the lock is the time between the start of the span and this event
+	trace.SpanFromContext(ctx).AddEvent("trie lock acquired")
+
+	seq, err := sl.appendWAL(word, tags, now)
+	if err != nil {
+		return err
+	}
+	return sl.logSearchLocked(ctx, word, tags, now, seq)
+}
+
+// LogSearchBatch processes many searches under a single lock acquisition,
+// the user of every event is ignored since the trie is global, its category
+// tags the word as with LogSearchEvent.
+// Every event is processed even if some fail, the returned error joins all failures.
+func (sl *SearchLogger) LogSearchBatch(ctx context.Context, events []logsearch.SearchEvent) (err error) {
+	ctx, span := sl.tracer.Start(ctx, "trie.LogSearchBatch", tracing.KeyLogger.String(metrics.LoggerTrie), tracing.KeyBatchSize.Int(len(events)))
+	defer func() { tracing.End(span, err) }()
+
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+	span.AddEvent("trie lock acquired")
+
+	now := time.Now()
+	var errs []error
+	for _, event := range events {
+		tags, err := sl.categoryTag(event.Category)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("search %q: %w", event.Query, err))
+			continue
+		}
+		word, err := sl.validator.Sanitize(event.Query)
+		if err == nil {
+			err = sl.checkNormalized(word)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("search %q: %w", event.Query, err))
+			continue
+		}
+		seq, err := sl.appendWAL(word, tags, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("search %q: %w", event.Query, err))
+			continue
+		}
+		if err := sl.logSearchLocked(ctx, word, tags, now, seq); err != nil {
+			errs = append(errs, fmt.Errorf("search %q: %w", event.Query, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// logSearchLocked adds a search with the WAL sequence number seq to the trie,
+// tagging the word with tags, caller must hold the write lock
+func (sl *SearchLogger) logSearchLocked(ctx context.Context, word string, tags uint64, now time.Time, seq uint64) error {
+	raw := word
+	word = sl.normalizer.Normalize(word)
+	node := sl.trieRoot
+	sl.metrics.SearchLogged(metrics.LoggerTrie)
+
+	// Traverse/build the trie, keeping the path for handleWordExtension
+	sl.clusters = appendGraphemes(sl.clusters[:0], word)
+	sl.path = sl.path[:0]
+	for _, char := range sl.clusters {
+		node = sl.childLocked(node, char)
+		sl.path = append(sl.path, node)
+	}
+	sl.metrics.SetTrieNodes(sl.nodes)
+
+	// Update the last seen timestamp for this node
+	node.lastSeen = now
+	node.seq = seq
+	node.tags |= tags
+	sl.bumpScore(node, now)
+	if sl.displayForms {
+		sl.addFormLocked(node, raw)
+	}
+	sl.expiry.track(word, node)
+
+	// Check if this word extends an existing stored word
+	if err := sl.handleWordExtension(ctx, word, sl.clusters, sl.path); err != nil {
+		return fmt.Errorf("failed to handle word extension: %w", err)
+	}
+
+	return nil
+}
+
+// handleWordExtension checks if this word extends a previously stored shorter
+// word, clusters are the graphemes of the word and path the nodes they lead to
+func (sl *SearchLogger) handleWordExtension(ctx context.Context, word string, clusters []string, path []*TrieNode) error {
+	// The stored prefix is kept rather than renamed to a rejected word
+	if !logsearch.Allowed(sl.filters, word) {
+		return nil
+	}
+
+	// Look for shorter prefixes that might be stored in DB
+	end := 0
+	for i, node := range path {
+		end += len(clusters[i])
+
+		// If we find a shorter word that's stored in DB, need to update it
+		if node.isEndOfWord && node.dbID != nil && !node.verified && i < len(clusters)-1 && sl.segmentBoundary(clusters, i+1) {
+			prefix := word[:end]
+			currentNode := path[len(path)-1]
+			log.Printf("Found shorter stored word '%s', will replace with '%s'", prefix, word)
+			sl.metrics.Decision(metrics.LoggerTrie, metrics.DecisionExtend)
+			tracing.SetDecision(ctx, metrics.DecisionExtend)
+
+			// Update the existing record
+			if err := sl.updateStoredWord(ctx, *node.dbID, prefix, word, currentNode.seq); err != nil {
+				return fmt.Errorf("failed to update stored word: %w", store.Classify(err))
+			}
+			sl.heavy.Move(prefix, word)
+			sl.trending.Move(prefix, word, currentNode.lastSeen)
+			if sl.updates == nil {
+				sl.wordFinalized(logsearch.FinalizedWord{Word: word, ReplacedWords: []string{prefix}, Count: 1, At: currentNode.lastSeen})
+			}
+
+			// Move the DB ID and the score to the current (longer) word, the prefix is not stored anymore
+			currentNode.dbID = node.dbID
+			currentNode.isEndOfWord = true
+			sl.rememberStored(word)
+			node.dbID = nil
+			node.isEndOfWord = false
+			currentNode.score = mergeScores(currentNode.score, node.score)
+			node.score = 0
+			currentNode.metadata, node.metadata = node.metadata, ""
+			moveTags(currentNode, node)
+		}
+	}
+
+	return nil
+}
+
+// updateStoredWord renames an existing record in the database from oldWord to
+// newWord, or queues the update when buffering
+func (sl *SearchLogger) updateStoredWord(ctx context.Context, id int64, oldWord, newWord string, seq uint64) error {
+	if sl.updates != nil {
+		return sl.queueUpdate(ctx, id, oldWord, newWord, time.Now(), seq)
+	}
+
+	ctx, span := sl.tracer.Start(ctx, "store.Update", tracing.KeyOp.String(metrics.OpUpdate))
+	start := time.Now()
+	err := sl.db.Update(ctx, id, newWord, start)
+	sl.metrics.ObserveWrite(metrics.LoggerTrie, metrics.OpUpdate, start, err)
+	tracing.End(span, err)
+	if err == nil {
+		sl.changes.Emit(changes.Change{Op: changes.OpUpdate, ID: id, Word: newWord, OldWord: oldWord, Count: 1, At: start})
+	}
+	return err
+}
+
+// storeWordToDB stores a word to the database
+func (sl *SearchLogger) storeWordToDB(ctx context.Context, word string, node *TrieNode) error {
+	ctx, span := sl.tracer.Start(ctx, "store.InsertOrReplace", tracing.KeyOp.String(metrics.OpInsert))
+	now := time.Now()
+	id, err := sl.db.InsertOrReplace(ctx, word, now, now)
+	sl.metrics.ObserveWrite(metrics.LoggerTrie, metrics.OpInsert, now, err)
+	tracing.End(span, err)
+	if err != nil {
+		return err
+	}
+
+	node.dbID = &id
+	sl.rememberStored(word)
+	log.Printf("Stored word '%s' to database with ID %d", word, id)
+	sl.wordFinalized(logsearch.FinalizedWord{Word: word, Count: 1, At: now})
+	sl.changes.Emit(changes.Change{Op: changes.OpInsert, ID: id, Word: word, Count: 1, At: now})
+	return nil
+}
+
+// flushCompletedWordToDBRoutine runs periodically to store words that haven't been extended
+func (sl *SearchLogger) flushCompletedWordToDBRoutine(ctx context.Context) {
+	defer close(sl.done)
+
+	ticker := time.NewTicker(sl.timeout / 2)
+	defer ticker.Stop()
+
+	// Buffered updates are flushed on their own interval
+	var updatesTick <-chan time.Time
+	if sl.updates != nil {
+		updatesTicker := time.NewTicker(sl.updates.interval)
+		defer updatesTicker.Stop()
+		updatesTick = updatesTicker.C
+	}
+
+	// The snapshot is rebuilt on every replica, leader or not
+	var snapshotTick <-chan time.Time
+	if sl.snapshotInterval > 0 {
+		snapshotTicker := time.NewTicker(sl.snapshotInterval)
+		defer snapshotTicker.Stop()
+		snapshotTick = snapshotTicker.C
+	}
+
+	// Expired words are purged on their own interval
+	var retentionTick <-chan time.Time
+	if sl.retention > 0 {
+		retentionTicker := time.NewTicker(sl.retentionInterval)
+		defer retentionTicker.Stop()
+		retentionTick = retentionTicker.C
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			sl.lead(ctx, func() {
+				sl.mutex.Lock()
+				defer sl.mutex.Unlock()
+				sl.applyKnownHitsLocked()
+				if err := sl.processTimedOutWordsLocked(ctx, time.Now().Add(-sl.timeout), 0); err != nil {
+					log.Printf("Error storing timed out words: %v", err)
+				}
+				sl.enforceBudgetLocked(time.Now().Add(-sl.timeout))
+				if err := sl.checkpointWALLocked(); err != nil {
+					log.Printf("Error checkpointing wal: %v", err)
+				}
+			})
+		case <-updatesTick:
+			sl.lead(ctx, func() {
+				sl.mutex.Lock()
+				defer sl.mutex.Unlock()
+				if err := sl.flushUpdatesLocked(ctx); err != nil {
+					log.Printf("Error flushing buffered updates: %v", err)
+				}
+				if err := sl.checkpointWALLocked(); err != nil {
+					log.Printf("Error checkpointing wal: %v", err)
+				}
+			})
+		case <-retentionTick:
+			sl.lead(ctx, func() {
+				if _, err := sl.Purge(ctx, time.Now().Add(-sl.retention)); err != nil {
+					log.Printf("Error purging expired words: %v", err)
+				}
+			})
+		case <-snapshotTick:
+			sl.refreshSnapshot()
+		case <-ctx.Done():
+			return
+		}
+		sl.heartbeat.Store(time.Now().UnixNano())
+	}
+}
+
+// processTimedOutWordsLocked stores the words that haven't been extended since cutoff,
+// dropping those shorter than minLength characters, caller must hold the write lock
+//
+// Why we skip words with children:
+// Consider user types: "B" → "Bu" → "Bus" → "Business", then stops typing.
+// After timeout, ALL words become "timed out":
+//
+//	expired = {"B": node, "Bu": node, "Bus": node, "Business": node}
+//
+// A node with children is a prefix of a longer word, we only want the most complete form "Business":
+//
+//	"B" → skipped (has children: 'u')
+//	"Bu" → skipped (has children: 's')
+//	"Bus" → skipped (has children: 'i')
+//	"Business" → stored (no children)
+func (sl *SearchLogger) processTimedOutWordsLocked(ctx context.Context, cutoff time.Time, minLength int) error {
+	expired := sl.expiry.popExpired(cutoff)
+	if len(expired) == 0 {
+		return nil
+	}
+
+	// Store words that are not prefixes of any other word
+	var words []string
+	var nodes []*TrieNode
+	queued := make(map[*TrieNode]bool)
+	for _, entry := range expired {
+		node := entry.node
+		if node.dbID != nil || queued[node] {
+			continue
+		}
+
+		if node.children.len() > 0 || uniseg.GraphemeClusterCount(entry.word) < minLength {
+			sl.metrics.Decision(metrics.LoggerTrie, metrics.DecisionIgnore)
+			continue
+		}
+		if !logsearch.Allowed(sl.filters, entry.word) {
+			sl.metrics.Decision(metrics.LoggerTrie, metrics.DecisionFilter)
+			continue
+		}
+		if sl.supersededBranch(entry.word, node) {
+			log.Printf("Dropping '%s', the user corrected it to another branch", entry.word)
+			sl.metrics.Decision(metrics.LoggerTrie, metrics.DecisionBranch)
+			continue
+		}
+
+		node.isEndOfWord = true
+		words = append(words, entry.word)
+		nodes = append(nodes, node)
+		queued[node] = true
+	}
+
+	if len(words) == 0 {
+		sl.storeDisplayFormsLocked(ctx, expired)
+		sl.storeCategoriesLocked(ctx, expired)
+		return nil
+	}
+
+	ctx, span := sl.tracer.Start(ctx, "trie.flush", tracing.KeyLogger.String(metrics.LoggerTrie), tracing.KeyWrites.Int(len(words)))
+	start := time.Now()
+	err := sl.storeWordsToDB(ctx, words, nodes)
+	sl.metrics.ObserveFlush(metrics.LoggerTrie, len(words), start, err)
+	sl.flushes.observe(len(words), start, err)
+	tracing.End(span, err)
+	sl.storeDisplayFormsLocked(ctx, expired)
+	sl.storeCategoriesLocked(ctx, expired)
+
+	// Words that failed to store time out again on the next flush cycle
+	for i, node := range nodes {
+		if node.dbID == nil {
+			sl.expiry.track(words[i], node)
+		} else if sl.pruning {
+			sl.pruneLocked(words[i], cutoff)
+		}
+	}
+	sl.metrics.SetTrieNodes(sl.nodes)
+	return store.Classify(err)
+}
+
+// Flush synchronously stores every pending word, whether it timed out or the user
+// may still be typing it, and writes the buffered updates. A word extended after
+// a flush replaces its stored form like any other stored word. With WithAsync
+// it first waits for the queued searches to be processed.
+func (sl *SearchLogger) Flush(ctx context.Context) error {
+	sl.queue.wait()
+
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	sl.applyKnownHitsLocked()
+	return errors.Join(
+		sl.processTimedOutWordsLocked(ctx, time.Now(), 0),
+		sl.flushUpdatesLocked(ctx),
+		sl.checkpointWALLocked(),
+	)
+}
+
+// Shutdown stops background routines, stores the pending words and buffered
+// updates while ctx allows and closes the database connection. Words still
+// pending once ctx is done are lost, the returned error reports them.
+func (sl *SearchLogger) Shutdown(ctx context.Context) error {
+	// The queued searches become pending words before the drain
+	sl.queue.stop()
+	sl.cancel()
+	<-sl.done
+
+	sl.mutex.Lock()
+	err := errors.Join(
+		sl.processTimedOutWordsLocked(ctx, time.Now(), sl.drainMinLength),
+		sl.flushUpdatesLocked(ctx),
+	)
+	sl.mutex.Unlock()
+	if err != nil {
+		log.Printf("Error draining pending words on shutdown: %v", err)
+	}
+
+	return errors.Join(err, sl.closeWAL(), sl.db.Close())
+}
+
+// Close drains the pending words as configured by WithDrain, see Shutdown
+func (sl *SearchLogger) Close() error {
+	ctx := context.Background()
+	if sl.drainDeadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sl.drainDeadline)
+		defer cancel()
+	}
+
+	return sl.Shutdown(ctx)
+}
+
+// GetStoredSearches returns all stored searches
+func (sl *SearchLogger) GetStoredSearches(ctx context.Context) ([]string, error) {
+	sl.mutex.RLock()
+	defer sl.mutex.RUnlock()
+
+	return sl.db.GetAllSearchedWords(ctx)
+}
+
+// GetStoredRecords returns all stored search records in insertion order, with
+// their counts, timestamps and verified flags. Pending words are not stored
+// yet, call Flush first to include them. It fails unless the store implements
+// store.SearchRecordStore.
+func (sl *SearchLogger) GetStoredRecords(ctx context.Context) ([]store.SearchRecord, error) {
+	recordStore, ok := sl.db.(store.SearchRecordStore)
+	if !ok {
+		return nil, errors.New("store does not support reading records")
+	}
+
+	sl.mutex.RLock()
+	defer sl.mutex.RUnlock()
+
+	records, err := recordStore.GetAllRecords(ctx)
+	return records, store.Classify(err)
+}
+
+// GetTopSearches returns the limit most searched stored words, most searched first
+func (sl *SearchLogger) GetTopSearches(ctx context.Context, limit int) ([]store.WordCount, error) {
+	return sl.GetTopSearchesSince(ctx, time.Time{}, limit)
+}
+
+// GetTopSearchesSince is GetTopSearches restricted to words last searched at or after since.
+// With WithDecay a zero since is ranked by decayed score, with WithHeavyHitters it is
+// answered from the sketch, other windows from the store.
+func (sl *SearchLogger) GetTopSearchesSince(ctx context.Context, since time.Time, limit int) ([]store.WordCount, error) {
+	if sl.halfLife > 0 && since.IsZero() {
+		return sl.topDecayed(limit, 0), nil
+	}
+	if sl.heavy != nil && since.IsZero() {
+		items := sl.heavy.Top(limit)
+		counts := make([]store.WordCount, len(items))
+		for i, item := range items {
+			counts[i] = store.WordCount{Word: item.Word, Count: int(item.Count)}
+		}
+		return sl.displayCounts(counts), nil
+	}
+
+	topStore, ok := sl.db.(store.TopSearchStore)
+	if !ok {
+		return nil, errors.New("store does not support top searches")
+	}
+
+	sl.mutex.RLock()
+	counts, err := topStore.TopSearches(ctx, since, limit)
+	sl.mutex.RUnlock()
+	if err != nil {
+		return nil, store.Classify(err)
+	}
+	return sl.displayCounts(counts), nil
+}
+
+// Suggest returns up to limit stored words starting with prefix, in alphabetical
+// order, or by decreasing decayed score with WithDecay
+func (sl *SearchLogger) Suggest(prefix string, limit int) ([]string, error) {
+	return sl.suggest(prefix, limit, wordMatch{})
+}
+
+// suggest implements Suggest, only returning the words selected by match
+func (sl *SearchLogger) suggest(prefix string, limit int, match wordMatch) ([]string, error) {
+	prefix = sl.normalizer.Normalize(prefix)
+	if limit <= 0 {
+		return []string{}, nil
+	}
+
+	root, release := sl.readRoot()
+	defer release()
+
+	// Navigate to the prefix node
+	node := root
+	for _, char := range graphemes(prefix) {
+		if node = node.children.get(char); node == nil {
+			return []string{}, nil
+		}
+	}
+
+	if sl.halfLife > 0 {
+		return sl.suggestDecayedLocked(node, prefix, limit, match), nil
+	}
+
+	suggestions := make([]string, 0, limit)
+	sl.collectWords(node, prefix, limit, match, &suggestions)
+	return suggestions, nil
+}
+
+// collectWords walks the subtree in alphabetical order and collects the stored
+// words selected by match until limit is reached
+func (sl *SearchLogger) collectWords(node *TrieNode, currentWord string, limit int, match wordMatch, result *[]string) {
+	if len(*result) >= limit {
+		return
+	}
+
+	if match.matches(node) {
+		*result = append(*result, node.forms.display(currentWord))
+	}
+
+	node.children.each(func(char string, child *TrieNode) bool {
+		sl.collectWords(child, currentWord+char, limit, match, result)
+		return len(*result) < limit
+	})
+}
+
+// loadExistingWords loads all words from database and builds the trie
+func (sl *SearchLogger) loadExistingWords(ctx context.Context) error {
+	words, err := sl.db.GetAllSearchedWords(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get words from database: %w", store.Classify(err))
+	}
+
+	log.Printf("Loading %d words from database into trie", len(words))
+
+	for _, word := range words {
+		if err := sl.buildTrieFromWord(sl.normalizer.Normalize(word)); err != nil {
+			log.Printf("Error building trie for word '%s': %v", word, err)
+			continue
+		}
+	}
+
+	if err := sl.loadVerifiedWords(ctx); err != nil {
+		return err
+	}
+	if err := sl.loadMetadata(ctx); err != nil {
+		return err
+	}
+	if err := sl.loadCategories(ctx); err != nil {
+		return err
+	}
+	return sl.loadDisplayForms(ctx)
+}
+
+// buildTrieFromWord builds trie path for a stored word. The word is not
+// pending, so it is left out of the expiry heap: only a new search of it
+// stores it again.
+func (sl *SearchLogger) buildTrieFromWord(word string) error {
+	node := sl.pathLocked(word)
+	sl.metrics.SetTrieNodes(sl.nodes)
+
+	node.isEndOfWord = true
+	node.lastSeen = time.Now()
+	return nil
+}
@@ -0,0 +1,989 @@
+package trie
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/changes"
+	"github.com/afanwang/logsearch/leader"
+	"github.com/afanwang/logsearch/normalize"
+	"github.com/afanwang/logsearch/store"
+	"github.com/afanwang/logsearch/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// TestBasicFunctionality tests the core function
+func TestBasicFunctionality(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLogger(200 * time.Millisecond)
+	assert.NoError(t, err, "Failed to create search logger")
+	defer logger.Close()
+
+	// Log a search
+	err = logger.LogSearch(ctx, "test")
+	assert.NoError(t, err, "Failed to log search")
+
+	// Wait for timeout and force flush
+	time.Sleep(250 * time.Millisecond)
+	assert.NoError(t, err, "Failed to force flush")
+
+	// Verify storage
+	stored, err := logger.GetStoredSearches(ctx)
+	assert.NoError(t, err, "Failed to get stored searches")
+	assert.Equal(t, 1, len(stored), "Expected 1 stored search, got %d", len(stored))
+	assert.Equal(t, "test", stored[0], "Expected stored search to be 'test', got: %v", stored[0])
+}
+
+// TestInvalidWords tests that invalid searches are rejected with the sentinel errors
+func TestInvalidWords(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLogger(time.Hour)
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	assert.ErrorIs(t, logger.LogSearch(ctx, ""), logsearch.ErrEmptyWord)
+	assert.ErrorIs(t, logger.LogSearch(ctx, strings.Repeat("a", logsearch.MaxWordBytes+1)), logsearch.ErrWordTooLong)
+
+	assert.ErrorIs(t, logger.LogSearch(ctx, "bus\xff"), logsearch.ErrInvalidInput)
+
+	err = logger.LogSearchBatch(ctx, []logsearch.SearchEvent{{Query: "bus"}, {Query: ""}, {Query: "\x00\x01"}, {Query: "ca\x1bt"}})
+	assert.ErrorIs(t, err, logsearch.ErrEmptyWord)
+	assert.ErrorIs(t, err, logsearch.ErrInvalidInput)
+	assert.NoError(t, logger.Flush(ctx))
+	stored, err := logger.GetStoredSearches(ctx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"bus", "cat"}, stored, "Control characters are stripped")
+
+	// A word normalization leaves nothing of is rejected too, even once stored words are known
+	known, err := NewSearchLogger(time.Hour, WithKnownWords(100, 0.01))
+	assert.NoError(t, err)
+	defer known.Close()
+	assert.NoError(t, known.LogSearch(ctx, "bus"))
+	assert.NoError(t, known.Flush(ctx))
+	assert.ErrorIs(t, known.LogSearch(ctx, "   "), logsearch.ErrEmptyWord)
+	assert.ErrorIs(t, known.LogSearchBatch(ctx, []logsearch.SearchEvent{{Query: " \t "}}), logsearch.ErrEmptyWord)
+	assert.NoError(t, known.Flush(ctx))
+	stored, err = known.GetStoredSearches(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bus"}, stored)
+
+	// Limits are configurable
+	short, err := NewSearchLogger(time.Hour, WithValidator(logsearch.NewValidator(0, 3)))
+	assert.NoError(t, err)
+	defer short.Close()
+	assert.ErrorIs(t, short.LogSearch(ctx, "busy"), logsearch.ErrWordTooLong)
+}
+
+// TestWordProgression tests incremental word building
+func TestWordProgression(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLogger(150 * time.Millisecond)
+	assert.NoError(t, err, "Failed to create search logger")
+	defer logger.Close()
+
+	// Log progressive searches quickly
+	words := []string{"a", "ap", "app"}
+	for _, word := range words {
+		err := logger.LogSearch(ctx, word)
+		assert.NoError(t, err, "Failed to log '%s'", word)
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// Wait for timeout
+	time.Sleep(200 * time.Millisecond)
+
+	// Check results
+	stored, err := logger.GetStoredSearches(ctx)
+	assert.NoError(t, err, "Failed to get stored searches")
+
+	assert.Equal(t, 1, len(stored), "Expected 1 stored searches, got %d", len(stored))
+	assert.Equal(t, "app", stored[0], "Expected stored search to be 'app'")
+	t.Logf("Stored searches: %v", stored)
+}
+
+// TestSuggest tests autocomplete over stored words
+func TestSuggest(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDB()
+	now := time.Now()
+	for _, word := range []string{"band", "banana", "apple", "bandana"} {
+		_, err := db.InsertOrReplace(ctx, word, now, now)
+		assert.NoError(t, err)
+	}
+
+	logger, err := NewSearchLoggerWithDB(time.Second, db)
+	assert.NoError(t, err, "Failed to create search logger")
+	defer logger.Close()
+
+	suggestions, err := logger.Suggest("Ban", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"banana", "band", "bandana"}, suggestions)
+
+	suggestions, err = logger.Suggest("ban", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"banana", "band"}, suggestions)
+
+	suggestions, err = logger.Suggest("x", 10)
+	assert.NoError(t, err)
+	assert.Empty(t, suggestions)
+}
+
+// TestNormalizer tests that searches, suggest prefixes and loaded words share one normalized trie path
+func TestNormalizer(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDB()
+	now := time.Now()
+	_, err := db.InsertOrReplace(ctx, "crème", now, now)
+	assert.NoError(t, err)
+
+	logger, err := NewSearchLoggerWithDB(time.Second, db, WithNormalizer(normalize.NewUnicode(normalize.WithDiacriticFolding())))
+	assert.NoError(t, err, "Failed to create search logger")
+	defer logger.Close()
+
+	assert.NoError(t, logger.LogSearch(ctx, "Cre\u0300me Brûlée"))
+	assert.NoError(t, logger.Flush(ctx))
+
+	suggestions, err := logger.Suggest("CRÈ", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"creme", "creme brulee"}, suggestions)
+}
+
+// TestEmojiStrip tests that stripped emoji leave the word alone and reject a search made only of emoji
+func TestEmojiStrip(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLogger(time.Hour, WithNormalizer(normalize.EmojiFolding(normalize.Default, normalize.EmojiStrip)))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	assert.NoError(t, logger.LogSearch(ctx, "Pizza 🍕"))
+	assert.ErrorIs(t, logger.LogSearch(ctx, "🍕 🇫🇷"), logsearch.ErrEmptyWord)
+	assert.NoError(t, logger.Flush(ctx))
+
+	stored, err := logger.GetStoredSearches(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pizza"}, stored)
+	suggestions, err := logger.Suggest("pi🍕", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pizza"}, suggestions)
+}
+
+func TestVerified(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDB()
+	now := time.Now()
+	for _, word := range []string{"bus", "bsu", "bsu", "bike"} {
+		_, err := db.InsertOrReplace(ctx, word, now, now)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, db.SetVerified(ctx, "bike", true))
+
+	logger, err := NewSearchLoggerWithDB(time.Hour, db)
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	assert.NoError(t, logger.MarkVerified(ctx, "BUS"))
+	assert.ErrorIs(t, logger.MarkVerified(ctx, "car"), store.ErrWordNotFound)
+
+	suggestions, err := logger.SuggestVerified("b", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bike", "bus"}, suggestions)
+	suggestions, err = logger.Suggest("b", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bike", "bsu", "bus"}, suggestions)
+
+	unverified, err := logger.ListUnverified(ctx, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []store.WordCount{{Word: "bsu", Count: 2}}, unverified)
+
+	// A verified word is kept when a search extends it
+	assert.NoError(t, logger.LogSearch(ctx, "car"))
+	assert.NoError(t, logger.Flush(ctx))
+	assert.NoError(t, logger.MarkVerified(ctx, "car"))
+	assert.NoError(t, logger.LogSearch(ctx, "cars"))
+	assert.NoError(t, logger.Flush(ctx))
+	assert.NoError(t, logger.UnmarkVerified(ctx, "bike"))
+	suggestions, err = logger.SuggestVerified("", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bus", "car"}, suggestions)
+	stored, err := logger.GetStoredSearches(ctx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"bike", "bsu", "bus", "car", "cars"}, stored)
+
+	// SetVerified reports the previous flag
+	previous, err := logger.SetVerified(ctx, "bus", true)
+	assert.NoError(t, err)
+	assert.True(t, previous)
+	previous, err = logger.SetVerified(ctx, "bike", true)
+	assert.NoError(t, err)
+	assert.False(t, previous)
+}
+
+func TestQueryStoredSearches(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDB()
+	now := time.Now()
+	for _, word := range []string{"bus", "bsu", "bsu", "bike", "car"} {
+		_, err := db.InsertOrReplace(ctx, word, now, now)
+		assert.NoError(t, err)
+	}
+
+	logger, err := NewSearchLoggerWithDB(time.Hour, db)
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	words, err := logger.QueryStoredSearches(ctx, store.SearchQuery{Prefix: " B ", Order: store.OrderCount, Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bsu", "bike"}, words)
+
+	words, err = logger.QueryStoredSearches(ctx, store.SearchQuery{Offset: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bus", "car"}, words)
+
+	records, err := logger.QueryStoredRecords(ctx, store.SearchQuery{Prefix: "bs"})
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, 2, records[0].SearchCount)
+
+	count, err := logger.SearchCount(ctx, " BSU ")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	_, err = logger.SearchCount(ctx, "bs")
+	assert.ErrorIs(t, err, store.ErrWordNotFound)
+}
+
+func TestGetStoredRecords(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDB()
+	start := time.Now().Add(-time.Hour)
+	logger, err := NewSearchLoggerWithDB(10*time.Millisecond, db)
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	assert.NoError(t, logger.logSearchAt(ctx, "bike", start))
+	assert.NoError(t, logger.logSearchAt(ctx, "bus", start.Add(time.Minute)))
+	assert.NoError(t, logger.Flush(ctx))
+	assert.NoError(t, logger.MarkVerified(ctx, "bus"))
+
+	records, err := logger.GetStoredRecords(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, "bike", records[0].Word)
+	assert.Equal(t, 1, records[0].SearchCount)
+	assert.False(t, records[0].Verified)
+	assert.Equal(t, "bus", records[1].Word)
+	assert.True(t, records[1].Verified)
+}
+
+func TestCurateWords(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDB()
+	logger, err := NewSearchLoggerWithDB(time.Hour, db)
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	for _, word := range []string{"nyc", "new york", "bsu", "bus"} {
+		assert.NoError(t, logger.LogSearch(ctx, word))
+		assert.NoError(t, logger.Flush(ctx))
+	}
+
+	assert.ErrorIs(t, logger.RenameWord(ctx, "bsu", "bus"), store.ErrWordExists)
+	assert.ErrorIs(t, logger.MergeWords(ctx, "cat", "cats"), store.ErrWordNotFound)
+	assert.NoError(t, logger.MergeWords(ctx, "NYC", "new york"))
+	assert.NoError(t, logger.MergeWords(ctx, "bsu", "bus"))
+	assert.NoError(t, logger.RenameWord(ctx, "bus", "buses"))
+
+	suggestions, err := logger.Suggest("", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"buses", "new york"}, suggestions)
+	top, err := logger.GetTopSearches(ctx, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []store.WordCount{{Word: "buses", Count: 2}, {Word: "new york", Count: 2}}, top)
+
+	// The trie links the curated word to its record, extending it renames the record
+	assert.NoError(t, logger.LogSearch(ctx, "buses to boston"))
+	assert.NoError(t, logger.Flush(ctx))
+	stored, err := logger.GetStoredSearches(ctx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"buses to boston", "new york"}, stored)
+}
+
+// TestGraphemes tests that multi-byte and multi-rune characters consolidate as whole characters
+func TestGraphemes(t *testing.T) {
+	ctx := context.Background()
+	var replaced []string
+	logger, err := NewSearchLogger(time.Hour, WithWordFinalizedHook(func(word logsearch.FinalizedWord) {
+		replaced = append(replaced, word.ReplacedWords...)
+	}))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	for _, word := range []string{"日本", "e", "東"} {
+		assert.NoError(t, logger.LogSearch(ctx, word))
+		assert.NoError(t, logger.Flush(ctx))
+	}
+	assert.NoError(t, logger.LogSearch(ctx, "日本語"))
+	// The combining accent belongs to the character "é", "e" is no prefix of it
+	assert.NoError(t, logger.LogSearch(ctx, "e\u0301cole"))
+	assert.NoError(t, logger.LogSearch(ctx, "東京"))
+	assert.NoError(t, logger.Flush(ctx))
+
+	assert.Equal(t, []string{"日本", "東"}, replaced)
+	stored, err := logger.GetStoredSearches(ctx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"日本語", "e", "e\u0301cole", "東京"}, stored)
+
+	suggestions, err := logger.Suggest("e\u0301", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"e\u0301cole"}, suggestions)
+}
+
+// TestCJKBigrams tests that a lone CJK character is kept apart from the longer words it starts
+func TestCJKBigrams(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLogger(time.Hour, WithCJKBigrams())
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	for _, word := range []string{"東", "東京", "bus"} {
+		assert.NoError(t, logger.LogSearch(ctx, word))
+		assert.NoError(t, logger.Flush(ctx))
+	}
+	for _, word := range []string{"東京都", "business"} {
+		assert.NoError(t, logger.LogSearch(ctx, word))
+	}
+	assert.NoError(t, logger.Flush(ctx))
+
+	// "東京" ends on a bigram and consolidates, other scripts are unaffected
+	stored, err := logger.GetStoredSearches(ctx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"東", "東京都", "business"}, stored)
+
+	suggestions, err := logger.Suggest("東", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"東", "東京都"}, suggestions)
+}
+
+func TestBranchDetection(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLogger(time.Minute, WithBranchDetection(3))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	start := time.Now().Add(-time.Hour)
+	for i, word := range []string{"b", "bu", "bus", "busi", "busin", "busi", "busia", "cat", "dog"} {
+		assert.NoError(t, logger.logSearchAt(ctx, word, start.Add(time.Duration(i)*time.Second)))
+	}
+	// "bux" was typed long after "busia" timed out
+	assert.NoError(t, logger.logSearchAt(ctx, "bux", start.Add(30*time.Minute)))
+	assert.NoError(t, logger.Flush(ctx))
+
+	// "busin" was corrected to "busia", "cat" and "dog" share no prefix
+	stored, err := logger.GetStoredSearches(ctx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"busia", "cat", "dog", "bux"}, stored)
+}
+
+func TestTopSearches(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDB()
+	now := time.Now()
+	for _, word := range []string{"band", "apple", "band", "banana", "apple", "band"} {
+		_, err := db.InsertOrReplace(ctx, word, now, now)
+		assert.NoError(t, err)
+	}
+
+	logger, err := NewSearchLoggerWithDB(time.Second, db)
+	assert.NoError(t, err, "Failed to create search logger")
+	defer logger.Close()
+
+	top, err := logger.GetTopSearches(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []store.WordCount{{Word: "band", Count: 3}, {Word: "apple", Count: 2}}, top)
+
+	top, err = logger.GetTopSearchesSince(ctx, now.Add(time.Minute), 10)
+	assert.NoError(t, err)
+	assert.Empty(t, top)
+}
+
+func TestHeavyHitters(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLogger(time.Hour, WithHeavyHitters(10, 0.01, 0.01))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	for _, word := range []string{"b", "bu", "bus", "cat"} {
+		assert.NoError(t, logger.LogSearch(ctx, word))
+	}
+	assert.NoError(t, logger.Flush(ctx))
+
+	// Extending the stored "bus" moves its count to "business"
+	assert.NoError(t, logger.LogSearch(ctx, "business"))
+	assert.NoError(t, logger.LogSearch(ctx, "dog"))
+	assert.NoError(t, logger.Flush(ctx))
+
+	top, err := logger.GetTopSearches(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []store.WordCount{{Word: "business", Count: 1}, {Word: "cat", Count: 1}}, top)
+
+	// Windows are still answered by the store
+	top, err = logger.GetTopSearchesSince(ctx, time.Now().Add(time.Minute), 10)
+	assert.NoError(t, err)
+	assert.Empty(t, top)
+}
+
+func TestTrending(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLogger(time.Hour, WithTrending(time.Minute, 60))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	for _, word := range []string{"b", "bu", "bus", "cat"} {
+		assert.NoError(t, logger.LogSearch(ctx, word))
+	}
+	trending, err := logger.GetTrending(time.Hour, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, trending, "Pending words do not count yet")
+
+	assert.NoError(t, logger.Flush(ctx))
+	assert.NoError(t, logger.LogSearch(ctx, "business"))
+	trending, err = logger.GetTrending(time.Hour, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []store.WordCount{{Word: "business", Count: 1}, {Word: "cat", Count: 1}}, trending)
+}
+
+func TestDecay(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLogger(time.Hour, WithDecay(time.Hour))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	// "cat" was popular ten half-lives ago, "car" and "cab" are searched now
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, logger.logSearchAt(ctx, "cat", now.Add(-10*time.Hour)))
+	}
+	assert.NoError(t, logger.logSearchAt(ctx, "car", now))
+	assert.NoError(t, logger.logSearchAt(ctx, "cab", now.Add(-time.Hour)))
+	assert.NoError(t, logger.logSearchAt(ctx, "cab", now.Add(-time.Hour)))
+	assert.NoError(t, logger.Flush(ctx))
+
+	suggestions, err := logger.Suggest("ca", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cab", "car", "cat"}, suggestions)
+
+	top, err := logger.GetTopSearches(ctx, 2)
+	assert.NoError(t, err)
+	assert.Len(t, top, 2)
+	assert.Equal(t, "cab", top[0].Word)
+	assert.InDelta(t, 1, top[0].Score, 0.01)
+	assert.Equal(t, "car", top[1].Word)
+	assert.Equal(t, 1, top[1].Count)
+
+	// Extending a stored word moves its score
+	assert.NoError(t, logger.LogSearch(ctx, "cars"))
+	assert.NoError(t, logger.Flush(ctx))
+	top, err = logger.GetTopSearches(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "cars", top[0].Word)
+	assert.InDelta(t, 2, top[0].Score, 0.01)
+}
+
+func TestKnownWords(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLogger(time.Hour, WithKnownWords(100, 0.01), WithDecay(time.Hour))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	assert.NoError(t, logger.LogSearch(ctx, "bus"))
+	assert.NoError(t, logger.Flush(ctx))
+
+	// Searches of the stored word are accumulated apart from the trie until the next flush
+	assert.NoError(t, logger.LogSearch(ctx, "bus"))
+	assert.NoError(t, logger.LogSearch(ctx, "BUS"))
+	logger.known.mu.Lock()
+	assert.Len(t, logger.known.hits, 1)
+	logger.known.mu.Unlock()
+
+	// A prefix of the stored word and a new word take the slow path
+	assert.False(t, logger.logKnownSearch(ctx, "bu", 0, time.Now()))
+	assert.False(t, logger.logKnownSearch(ctx, "car", 0, time.Now()))
+
+	assert.NoError(t, logger.Flush(ctx))
+	top, err := logger.GetTopSearches(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "bus", top[0].Word)
+	assert.Equal(t, 3, top[0].Count)
+
+	// Extending the stored word still goes through the slow path, and the longer word becomes known
+	assert.NoError(t, logger.LogSearch(ctx, "business"))
+	assert.True(t, logger.logKnownSearch(ctx, "business", 0, time.Now()))
+	assert.False(t, logger.logKnownSearch(ctx, "bus", 0, time.Now()))
+	assert.NoError(t, logger.Flush(ctx))
+
+	stored, err := logger.GetStoredSearches(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"business"}, stored)
+}
+
+func TestWordFinalizedHook(t *testing.T) {
+	ctx := context.Background()
+	var finalized []logsearch.FinalizedWord
+	logger, err := NewSearchLogger(time.Hour, WithWordFinalizedHook(func(word logsearch.FinalizedWord) {
+		finalized = append(finalized, word)
+	}))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	for _, word := range []string{"b", "bu", "bus"} {
+		assert.NoError(t, logger.LogSearch(ctx, word))
+	}
+	assert.Empty(t, finalized, "Pending words are not finalized")
+
+	assert.NoError(t, logger.Flush(ctx))
+	assert.NoError(t, logger.LogSearch(ctx, "business"))
+
+	assert.Len(t, finalized, 2)
+	assert.Equal(t, "bus", finalized[0].Word)
+	assert.Equal(t, 1, finalized[0].Count)
+	assert.Equal(t, "business", finalized[1].Word)
+	assert.Equal(t, []string{"bus"}, finalized[1].ReplacedWords)
+}
+
+func TestChangeStream(t *testing.T) {
+	ctx := context.Background()
+	published := make(chan changes.Change, 100)
+	stream := changes.New(changes.Channel(published))
+	logger, err := NewSearchLogger(time.Hour, WithChangeStream(stream))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	require.NoError(t, logger.LogSearch(ctx, "bus"))
+	require.NoError(t, logger.Flush(ctx))
+	require.NoError(t, logger.LogSearch(ctx, "business"))
+	require.NoError(t, logger.MarkVerified(ctx, "business"))
+	require.NoError(t, logger.MergeWords(ctx, "business", "bus"))
+	_, err = logger.Purge(ctx, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, stream.Close(ctx))
+	close(published)
+
+	var got []changes.Change
+	for change := range published {
+		assert.False(t, change.At.IsZero())
+		change.At = time.Time{}
+		got = append(got, change)
+	}
+	verified := true
+	assert.Equal(t, []changes.Change{
+		{Seq: 1, Op: changes.OpInsert, ID: 1, Word: "bus", Count: 1},
+		{Seq: 2, Op: changes.OpUpdate, ID: 1, Word: "business", OldWord: "bus", Count: 1},
+		{Seq: 3, Op: changes.OpUpdate, ID: 1, Word: "business", Verified: &verified},
+		{Seq: 4, Op: changes.OpUpdate, ID: 1, Word: "bus", OldWord: "business"},
+		{Seq: 5, Op: changes.OpDelete, Word: "bus"},
+	}, got)
+}
+
+func TestFollow(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDB()
+	hub := changes.NewHub()
+	defer hub.Close()
+	srv := httptest.NewServer(hub)
+	defer srv.Close()
+
+	stream := changes.New(hub, changes.WithFlushInterval(time.Millisecond))
+	primary, err := NewSearchLoggerWithDB(time.Hour, db, WithChangeStream(stream))
+	require.NoError(t, err)
+	defer primary.Close()
+	require.NoError(t, primary.LogSearch(ctx, "cat"))
+	require.NoError(t, primary.Flush(ctx))
+
+	standby, err := NewSearchLoggerWithDB(time.Hour, db)
+	require.NoError(t, err)
+	defer standby.Close()
+	follower, err := changes.NewFollower(srv.URL, nil)
+	require.NoError(t, err)
+	followCtx, cancel := context.WithCancel(ctx)
+	followed := make(chan error, 1)
+	go func() { followed <- standby.Follow(followCtx, follower) }()
+
+	suggest := func(prefix string) []string {
+		suggestions, err := standby.Suggest(prefix, 10)
+		require.NoError(t, err)
+		return suggestions
+	}
+
+	// The standby sees the words stored by the primary, and the renames of their prefixes
+	require.NoError(t, primary.LogSearch(ctx, "bus"))
+	require.NoError(t, primary.Flush(ctx))
+	assert.Eventually(t, func() bool { return len(suggest("bu")) == 1 }, time.Second, 5*time.Millisecond)
+	require.NoError(t, primary.LogSearch(ctx, "business"))
+	require.NoError(t, primary.Flush(ctx))
+	assert.Eventually(t, func() bool { return assert.ObjectsAreEqual([]string{"business"}, suggest("bu")) }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{"cat"}, suggest("c"), "the words stored before following are loaded from the store")
+
+	// Once promoted, the standby stores nothing again and goes on from the followed records
+	cancel()
+	assert.ErrorIs(t, <-followed, context.Canceled)
+	require.NoError(t, standby.Flush(ctx))
+	stored, err := db.GetAllSearchedWords(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"cat", "business"}, stored)
+}
+
+func TestApplyChange(t *testing.T) {
+	logger, err := NewSearchLogger(time.Hour)
+	require.NoError(t, err)
+	defer logger.Close()
+
+	verified := true
+	for _, change := range []changes.Change{
+		{Op: changes.OpInsert, ID: 1, Word: "bus", Count: 1},
+		{Op: changes.OpInsert, ID: 2, Word: "cat", Count: 1},
+		{Op: changes.OpUpdate, ID: 1, Word: "business", OldWord: "bus", Count: 1},
+		{Op: changes.OpUpdate, ID: 1, Word: "business", Verified: &verified},
+		{Op: changes.OpDelete, Word: "cat"},
+		// Replayed after a reload
+		{Op: changes.OpInsert, ID: 2, Word: "cat", Count: 1},
+		{Op: changes.OpDelete, Word: "cat"},
+	} {
+		require.NoError(t, logger.ApplyChange(change))
+	}
+
+	suggestions, err := logger.Suggest("", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"business"}, suggestions)
+	suggestions, err = logger.SuggestVerified("bu", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"business"}, suggestions)
+	assert.Error(t, logger.ApplyChange(changes.Change{Op: "upsert", Word: "bus"}))
+	assert.Error(t, logger.ApplyChange(changes.Change{Op: changes.OpInsert}))
+}
+
+func TestFlushLeader(t *testing.T) {
+	ctx := context.Background()
+	lock := leader.NewLocal()
+	logger, err := NewSearchLogger(20*time.Millisecond, WithFlushLeader(lock))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	// While another replica leads, the words stay pending
+	taken, err := lock.TryLock(ctx)
+	require.NoError(t, err)
+	require.True(t, taken)
+	require.NoError(t, logger.LogSearch(ctx, "bus"))
+	time.Sleep(100 * time.Millisecond)
+	stored, err := logger.GetStoredSearches(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, stored)
+
+	// They are stored on a cycle once the lock is released
+	require.NoError(t, lock.Unlock(ctx))
+	assert.Eventually(t, func() bool {
+		stored, err := logger.GetStoredSearches(ctx)
+		return err == nil && len(stored) == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestFilters(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLogger(time.Hour, WithFilters(logsearch.MinLength(2), logsearch.StopWords("the")))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	// Abandoned single letters and stop words are not stored
+	for _, word := range []string{"b", "the", "c", "ca", "cat"} {
+		assert.NoError(t, logger.LogSearch(ctx, word))
+	}
+	assert.NoError(t, logger.Flush(ctx))
+
+	stored, err := logger.GetStoredSearches(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cat"}, stored)
+}
+
+// TestBatchAndBufferedUpdates tests batch ingestion and coalesced word extension updates
+func TestBatchAndBufferedUpdates(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDB()
+	logger, err := NewSearchLoggerWithDB(100*time.Millisecond, db, WithWriteBuffer(10, time.Hour))
+	assert.NoError(t, err, "Failed to create search logger")
+	defer logger.Close()
+
+	events := []logsearch.SearchEvent{{Query: "c"}, {Query: "ca"}, {Query: "cat"}, {Query: "d"}, {Query: "dog"}}
+	assert.NoError(t, logger.LogSearchBatch(ctx, events))
+
+	time.Sleep(200 * time.Millisecond)
+
+	stored, err := logger.GetStoredSearches(ctx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"cat", "dog"}, stored)
+
+	// Extensions are buffered until flushed
+	assert.NoError(t, logger.LogSearch(ctx, "cats"))
+	stored, err = logger.GetStoredSearches(ctx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"cat", "dog"}, stored)
+
+	logger.mutex.Lock()
+	assert.NoError(t, logger.flushUpdatesLocked(ctx))
+	logger.mutex.Unlock()
+
+	stored, err = logger.GetStoredSearches(ctx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"cats", "dog"}, stored)
+}
+
+func TestFlushRecoversFromStoreOutage(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDB()
+	logger, err := NewSearchLoggerWithDB(time.Hour, db)
+	require.NoError(t, err)
+	defer logger.Close()
+
+	// The words failing to store while the database is lost stay pending
+	db.Faults().SetClosed(true)
+	assert.NoError(t, logger.LogSearch(ctx, "cat"))
+	assert.NoError(t, logger.LogSearch(ctx, "dog"))
+	assert.ErrorIs(t, logger.Flush(ctx), store.ErrStoreUnavailable)
+
+	db.Faults().SetClosed(false)
+	stored, err := logger.GetStoredSearches(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, stored)
+
+	// Some of them fail again, every one is stored once the database is back
+	db.Faults().Seed(1)
+	db.Faults().SetErrorRate("InsertOrReplace", 0.5)
+	db.Faults().SetErrorRate("InsertOrReplaceBatch", 0.5)
+	for i := 0; i < 10 && len(stored) < 2; i++ {
+		_ = logger.Flush(ctx)
+		stored, err = db.GetAllSearchedWords(ctx)
+		require.NoError(t, err)
+	}
+	assert.ElementsMatch(t, []string{"cat", "dog"}, stored)
+	assert.Positive(t, db.Faults().Injected())
+}
+
+func TestFlushAndClose(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDB()
+	logger, err := NewSearchLoggerWithDB(time.Hour, db)
+	assert.NoError(t, err, "Failed to create search logger")
+
+	for _, word := range []string{"b", "bu", "bus"} {
+		assert.NoError(t, logger.LogSearch(ctx, word))
+	}
+
+	// Nothing timed out yet, Flush stores the pending word anyway
+	assert.NoError(t, logger.Flush(ctx))
+	words, err := logger.GetStoredSearches(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bus"}, words)
+
+	// Close stores what is still pending, extending the flushed word
+	assert.NoError(t, logger.LogSearch(ctx, "business"))
+	assert.NoError(t, logger.LogSearch(ctx, "cat"))
+	assert.NoError(t, logger.Close())
+
+	words, err = db.GetAllSearchedWords(ctx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"business", "cat"}, words)
+}
+
+func TestReopenKeepsCounts(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDB()
+	for cycle := 0; cycle < 3; cycle++ {
+		logger, err := NewSearchLoggerWithDB(time.Hour, db)
+		require.NoError(t, err)
+		if cycle == 0 {
+			require.NoError(t, logger.LogSearch(ctx, "cat"))
+		}
+		// The words loaded from the store are not pending, closing does not store them again
+		require.NoError(t, logger.Flush(ctx))
+		require.NoError(t, logger.Close())
+	}
+
+	records, err := db.GetAllRecords(ctx)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, 1, records[0].SearchCount)
+}
+
+// blockingStore never finishes an insert before its context is done
+type blockingStore struct {
+	*store.MockPostgresDB
+}
+
+func (s *blockingStore) InsertOrReplace(ctx context.Context, word string, firstSearched, lastUpdated time.Time) (int64, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+func TestCloseDrainsPendingWords(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDB()
+	logger, err := NewSearchLoggerWithDB(time.Hour, db, WithDrain(3, time.Second))
+	assert.NoError(t, err, "Failed to create search logger")
+
+	for _, word := range []string{"go", "c", "ca", "cat", "über"} {
+		assert.NoError(t, logger.LogSearch(ctx, word))
+	}
+	assert.NoError(t, logger.Close())
+
+	// "go" is shorter than the minimum length, "über" is 4 characters
+	words, err := db.GetAllSearchedWords(ctx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"cat", "über"}, words)
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLoggerWithDB(time.Hour, &blockingStore{MockPostgresDB: store.NewMockPostgresDB()})
+	assert.NoError(t, err, "Failed to create search logger")
+	assert.NoError(t, logger.LogSearch(ctx, "cat"))
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = logger.Shutdown(shutdownCtx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	logger, err := NewSearchLogger(time.Hour, WithTracing(tracing.New(tp)), WithAsync(4, 1, Block))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	ctx, request := tp.Tracer("test").Start(context.Background(), "request")
+	require.NoError(t, logger.LogSearch(ctx, "bus"))
+	request.End()
+	require.NoError(t, logger.Flush(context.Background()))
+
+	byName := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		byName[span.Name()] = span
+	}
+	require.Contains(t, byName, "trie.LogSearch")
+	assert.Equal(t, request.SpanContext().SpanID(), byName["trie.LogSearch"].Parent().SpanID())
+	// The queued search is logged by a worker within the trace of the request
+	require.Contains(t, byName, "trie.logQueued")
+	assert.Equal(t, request.SpanContext().TraceID(), byName["trie.logQueued"].SpanContext().TraceID())
+	assert.Equal(t, byName["trie.LogSearch"].SpanContext().SpanID(), byName["trie.logQueued"].Parent().SpanID())
+
+	// The flush starts its own trace
+	require.Contains(t, byName, "trie.flush")
+	assert.Contains(t, byName["trie.flush"].Attributes(), tracing.KeyWrites.Int(1))
+	assert.Equal(t, byName["trie.flush"].SpanContext().SpanID(), byName["store.InsertOrReplace"].Parent().SpanID())
+}
+
+// benchmarkVocabulary returns n distinct pseudo-random words of 4 to 12
+// letters, sharing prefixes like real searches do
+func benchmarkVocabulary(n int) []string {
+	rng := rand.New(rand.NewSource(1))
+	seen := make(map[string]struct{}, n)
+	words := make([]string, 0, n)
+	buf := make([]byte, 12)
+	for len(words) < n {
+		length := 4 + rng.Intn(9)
+		for i := range buf[:length] {
+			// Skewed towards the first letters so the words share prefixes
+			buf[i] = 'a' + byte(rng.Intn(26)*rng.Intn(26)/25)
+		}
+		word := string(buf[:length])
+		if _, ok := seen[word]; !ok {
+			seen[word] = struct{}{}
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// BenchmarkLogSearch measures LogSearch on the hot path, without store round
+// trips: the flushing routine never runs within the hour of timeout
+func BenchmarkLogSearch(b *testing.B) {
+	ctx := context.Background()
+
+	b.Run("single", func(b *testing.B) {
+		logger := newBenchmarkLogger(b, 0)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			logger.LogSearch(ctx, "business")
+		}
+	})
+
+	// Every prefix of a word is searched as it is typed
+	b.Run("typing", func(b *testing.B) {
+		logger := newBenchmarkLogger(b, 0)
+		words := benchmarkVocabulary(10_000)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; {
+			word := words[i%len(words)]
+			for end := 1; end <= len(word) && i < b.N; end++ {
+				logger.LogSearch(ctx, word[:end])
+				i++
+			}
+		}
+	})
+
+	for _, size := range []int{10_000, 1_000_000} {
+		b.Run(fmt.Sprintf("vocabulary=%d", size), func(b *testing.B) {
+			logger := newBenchmarkLogger(b, 0)
+			words := benchmarkVocabulary(size)
+			for _, word := range words {
+				logger.LogSearch(ctx, word)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				logger.LogSearch(ctx, words[i%len(words)])
+			}
+		})
+	}
+
+	// The nodes of a large vocabulary allocated in chunks
+	b.Run("vocabulary=1000000/arena=256", func(b *testing.B) {
+		logger := newBenchmarkLogger(b, 0, WithNodeArena(256))
+		words := benchmarkVocabulary(1_000_000)
+		for _, word := range words {
+			logger.LogSearch(ctx, word)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			logger.LogSearch(ctx, words[i%len(words)])
+		}
+	})
+
+	b.Run("goroutines=64", func(b *testing.B) {
+		logger := newBenchmarkLogger(b, 0)
+		words := benchmarkVocabulary(100_000)
+		b.SetParallelism(max(64/runtime.GOMAXPROCS(0), 1))
+		b.ReportAllocs()
+		b.ResetTimer()
+		var next atomic.Int64
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				logger.LogSearch(ctx, words[int(next.Add(1))%len(words)])
+			}
+		})
+	})
+}
@@ -0,0 +1,73 @@
+package trie
+
+import (
+	"unicode"
+
+	"github.com/rivo/uniseg"
+)
+
+// WithCJKBigrams segments runs of Chinese, Japanese and Korean characters into
+// overlapping bigrams, the usual unit of CJK search engines, before deciding
+// whether a stored word is a prefix of a longer search. CJK scripts write
+// words without spaces and a single character is often a query of its own,
+// so a stored lone "東" is kept apart from "東京" while "東京" still
+// consolidates into "東京都" one character at a time. Suggest is unaffected.
+func WithCJKBigrams() Option {
+	return func(sl *SearchLogger) {
+		sl.cjkBigrams = true
+	}
+}
+
+// graphemes splits a word into its user-perceived characters, so a letter and
+// its combining marks, or an emoji sequence, form a single edge of the trie
+func graphemes(word string) []string {
+	return appendGraphemes(nil, word)
+}
+
+// appendGraphemes appends the graphemes of word to clusters, see graphemes
+func appendGraphemes(clusters []string, word string) []string {
+	if printableASCII(word) {
+		// Every printable ASCII character is a grapheme of its own
+		for i := 0; i < len(word); i++ {
+			clusters = append(clusters, word[i:i+1])
+		}
+		return clusters
+	}
+
+	state := -1
+	for word != "" {
+		var cluster string
+		cluster, word, _, state = uniseg.FirstGraphemeClusterInString(word, state)
+		clusters = append(clusters, cluster)
+	}
+	return clusters
+}
+
+// printableASCII reports whether word only holds printable ASCII characters
+func printableASCII(word string) bool {
+	for i := 0; i < len(word); i++ {
+		if word[i] < ' ' || word[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// isCJK reports whether a grapheme is written in a Chinese, Japanese or Korean script
+func isCJK(grapheme string) bool {
+	for _, r := range grapheme {
+		return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+	}
+	return false
+}
+
+// segmentBoundary reports whether the first n graphemes of a word end on a
+// boundary of its segments: always, unless WithCJKBigrams is enabled and they
+// end on a lone CJK character whose run goes on, which only starts a bigram
+func (sl *SearchLogger) segmentBoundary(clusters []string, n int) bool {
+	if !sl.cjkBigrams || n >= len(clusters) {
+		return true
+	}
+	lone := isCJK(clusters[n-1]) && (n == 1 || !isCJK(clusters[n-2]))
+	return !lone || !isCJK(clusters[n])
+}
@@ -0,0 +1,186 @@
+package trie
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// WithSnapshots answers Suggest, SuggestVerified and the decayed
+// GetTopSearches from an immutable copy of the stored words of the trie,
+// without the trie lock, so reads never wait for the searches being logged
+// nor slow them down. The copy is rebuilt every interval once the trie
+// changed, under the read lock, so reads lag the trie by up to interval,
+// e.g. a word is suggested up to interval after it was stored. The copy
+// holds the paths of the stored words, up to doubling the memory of the trie.
+func WithSnapshots(interval time.Duration) Option {
+	return func(sl *SearchLogger) {
+		if interval > 0 {
+			sl.snapshotInterval = interval
+		}
+	}
+}
+
+// WithSnapshotCompaction builds the snapshots of WithSnapshots as a directed
+// acyclic word graph: the subtrees holding the same words, typically common
+// endings such as "ing" or "tion", are kept once and shared by every path
+// leading to them, like in a minimal automaton. Suggest reads it the same way,
+// so only the memory of the snapshot changes, at the cost of a longer rebuild
+// hashing every node. Subtrees are only shared when their stored words also
+// have the same verified mark, categories, display form and decayed score, so
+// the gain shrinks with WithDisplayForms and WithDecay. It needs WithSnapshots.
+func WithSnapshotCompaction() Option {
+	return func(sl *SearchLogger) {
+		sl.compactSnapshots = true
+	}
+}
+
+// trieSnapshot is an immutable copy of the trie, see WithSnapshots
+type trieSnapshot struct {
+	root *TrieNode
+	// nodes is the count of nodes of the copy, shared ones counted once
+	nodes int
+	// writes is the count of write locks of the trie copied
+	writes uint64
+	at     time.Time
+}
+
+// refreshSnapshot publishes a new copy of the trie, unless it did not change since the last one
+func (sl *SearchLogger) refreshSnapshot() {
+	sl.mutex.RLock()
+	defer sl.mutex.RUnlock()
+
+	// No writer holds the lock, writes counts every completed one
+	writes := sl.mutex.writes.Load()
+	if last := sl.snapshot.Load(); last != nil && last.writes == writes {
+		return
+	}
+
+	var root *TrieNode
+	var count int
+	if sl.compactSnapshots {
+		c := newCompactor()
+		root, count = c.compact(sl.trieRoot), len(c.ids)
+	} else {
+		// Every copied node lives in a single allocation, freed with the snapshot
+		nodes := make([]TrieNode, 0, sl.nodes+1)
+		root = copyWords(sl.trieRoot, &nodes)
+		count = len(nodes)
+	}
+	if root == nil {
+		root, count = &TrieNode{}, 1
+	}
+	sl.snapshot.Store(&trieSnapshot{root: root, nodes: count, writes: writes, at: time.Now()})
+}
+
+// copyWords copies the subtree of node holding stored words into nodes, nil
+// when it holds none, with the fields read by Suggest. The copy is never
+// written once published.
+func copyWords(node *TrieNode, nodes *[]TrieNode) *TrieNode {
+	*nodes = append(*nodes, TrieNode{isEndOfWord: node.isEndOfWord, verified: node.verified, score: node.score, forms: node.forms.frozen(), tags: node.tags})
+	copied := &(*nodes)[len(*nodes)-1]
+	node.children.each(func(char string, child *TrieNode) bool {
+		if copiedChild := copyWords(child, nodes); copiedChild != nil {
+			copied.children.add(char, copiedChild)
+		}
+		return true
+	})
+	if !copied.isEndOfWord && copied.children.len() == 0 {
+		// Its slot is reused by the next sibling
+		*nodes = (*nodes)[:len(*nodes)-1]
+		return nil
+	}
+	return copied
+}
+
+// compactChunk is how many nodes of a compacted snapshot are allocated at a
+// time, its size is not known before the build
+const compactChunk = 1024
+
+// compactor builds a compacted snapshot bottom up, see WithSnapshotCompaction.
+// Every node is looked up by its signature once its children are compacted,
+// so equal subtrees end up as one node.
+type compactor struct {
+	arena nodeArena
+	// register maps the signature of every node built to the node
+	register map[string]*TrieNode
+	// ids numbers the nodes built, for the signatures of their parents
+	ids map[*TrieNode]uint64
+	// key is the buffer the signatures are encoded in
+	key []byte
+}
+
+// newCompactor creates a compactor for a single snapshot
+func newCompactor() *compactor {
+	return &compactor{
+		arena:    nodeArena{chunkSize: compactChunk},
+		register: make(map[string]*TrieNode),
+		ids:      make(map[*TrieNode]uint64),
+	}
+}
+
+// compact returns the node of the compacted snapshot equal to the subtree of
+// node holding stored words, nil when it holds none. Like copyWords it only
+// keeps the fields read by Suggest.
+func (c *compactor) compact(node *TrieNode) *TrieNode {
+	var edges []trieEdge
+	node.children.each(func(char string, child *TrieNode) bool {
+		if compacted := c.compact(child); compacted != nil {
+			edges = append(edges, trieEdge{char: char, child: compacted})
+		}
+		return true
+	})
+	if !node.isEndOfWord && len(edges) == 0 {
+		return nil
+	}
+
+	forms := node.forms.frozen()
+	key := c.signature(node, forms.display(""), edges)
+	if shared, ok := c.register[key]; ok {
+		return shared
+	}
+	compacted := c.arena.alloc()
+	*compacted = TrieNode{isEndOfWord: node.isEndOfWord, verified: node.verified, score: node.score, forms: forms, tags: node.tags}
+	for _, edge := range edges {
+		compacted.children.add(edge.char, edge.child)
+	}
+	c.ids[compacted] = uint64(len(c.ids))
+	c.register[key] = compacted
+	return compacted
+}
+
+// signature encodes the fields of node read by Suggest and its compacted
+// children, two nodes with the same signature hold the same words
+func (c *compactor) signature(node *TrieNode, form string, edges []trieEdge) string {
+	key := c.key[:0]
+	flags := byte(0)
+	if node.isEndOfWord {
+		flags |= 1
+	}
+	if node.verified {
+		flags |= 2
+	}
+	key = append(key, flags)
+	key = binary.AppendUvarint(key, math.Float64bits(float64(node.score)))
+	key = binary.AppendUvarint(key, node.tags)
+	key = binary.AppendUvarint(key, uint64(len(form)))
+	key = append(key, form...)
+	for _, edge := range edges {
+		key = binary.AppendUvarint(key, uint64(len(edge.char)))
+		key = append(key, edge.char...)
+		key = binary.AppendUvarint(key, c.ids[edge.child])
+	}
+	c.key = key
+	return string(key)
+}
+
+// readRoot returns the root to read the stored words from and a function
+// releasing it: the root of the snapshot, or the root of the trie under the
+// read lock without WithSnapshots
+func (sl *SearchLogger) readRoot() (*TrieNode, func()) {
+	if snapshot := sl.snapshot.Load(); snapshot != nil {
+		return snapshot.root, func() {}
+	}
+	sl.mutex.RLock()
+	return sl.trieRoot, sl.mutex.RUnlock
+}
@@ -0,0 +1,101 @@
+package trie
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshots(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLogger(time.Hour, WithSnapshots(time.Hour))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	for _, word := range []string{"ca", "cat", "car"} {
+		require.NoError(t, logger.LogSearch(ctx, word))
+	}
+	require.NoError(t, logger.Flush(ctx))
+	suggestions, err := logger.Suggest("ca", 10)
+	require.NoError(t, err)
+	assert.Empty(t, suggestions, "Reads lag the trie until the next snapshot")
+
+	logger.refreshSnapshot()
+	first := logger.snapshot.Load()
+	suggestions, err = logger.Suggest("ca", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"car", "cat"}, suggestions)
+	assert.Positive(t, logger.RuntimeStats().SnapshotAge)
+
+	// An unchanged trie keeps its snapshot
+	logger.refreshSnapshot()
+	assert.Same(t, first, logger.snapshot.Load())
+
+	// Reads do not wait for the writers
+	logger.mutex.Lock()
+	done := make(chan []string)
+	go func() {
+		suggestions, _ := logger.Suggest("c", 10)
+		done <- suggestions
+	}()
+	select {
+	case suggestions = <-done:
+		assert.Equal(t, []string{"car", "cat"}, suggestions)
+	case <-time.After(5 * time.Second):
+		t.Error("Suggest waited for the write lock")
+	}
+	logger.mutex.Unlock()
+}
+
+func TestSnapshotCompaction(t *testing.T) {
+	ctx := context.Background()
+	words := []string{"walking", "walked", "talking", "talked", "stalking", "run"}
+	open := func(opts ...Option) *SearchLogger {
+		logger, err := NewSearchLogger(time.Hour, append([]Option{WithSnapshots(time.Hour)}, opts...)...)
+		require.NoError(t, err)
+		t.Cleanup(func() { logger.Close() })
+		for _, word := range words {
+			require.NoError(t, logger.LogSearch(ctx, word))
+		}
+		require.NoError(t, logger.Flush(ctx))
+		logger.refreshSnapshot()
+		return logger
+	}
+	plain, compacted := open(), open(WithSnapshotCompaction())
+
+	for _, prefix := range []string{"", "t", "walk", "stalk", "r", "x"} {
+		want, err := plain.Suggest(prefix, 10)
+		require.NoError(t, err)
+		got, err := compacted.Suggest(prefix, 10)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, "Suggest(%q)", prefix)
+	}
+	assert.Less(t, compacted.RuntimeStats().SnapshotNodes, plain.RuntimeStats().SnapshotNodes)
+	// "alking" and "alked" are shared by walk and talk, "ing" by stalk too
+	node := func(path string) *TrieNode {
+		node := compacted.snapshot.Load().root
+		for _, char := range graphemes(path) {
+			node = node.children.get(char)
+		}
+		return node
+	}
+	assert.Same(t, node("w"), node("t"))
+	assert.Same(t, node("walki"), node("stalki"))
+	assert.NotSame(t, node("walk"), node("stalk"))
+
+	// A verified word no longer shares its ending with the unverified ones
+	require.NoError(t, compacted.MarkVerified(ctx, "talking"))
+	compacted.refreshSnapshot()
+	suggestions, err := compacted.SuggestVerified("", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"talking"}, suggestions)
+	suggestions, err = compacted.Suggest("walk", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"walked", "walking"}, suggestions)
+
+	_, err = NewSearchLogger(time.Hour, WithSnapshotCompaction())
+	assert.Error(t, err, "Compaction needs snapshots")
+}
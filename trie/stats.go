@@ -0,0 +1,81 @@
+package trie
+
+import (
+	"context"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// Stats summarizes the trie and its store, so applications embedding the
+// logger can surface its state without Prometheus
+type Stats struct {
+	Nodes int `json:"nodes"`
+	// Words counts the distinct words of the trie, stored or pending
+	Words int `json:"words"`
+	// MaxDepth is the length in characters of the longest word of the trie
+	MaxDepth int `json:"max_depth"`
+	// PendingWords counts the typed words not stored yet, waiting for their timeout
+	PendingWords int `json:"pending_words"`
+	// Evicted counts the nodes dropped to honor WithNodeBudget
+	Evicted int64      `json:"evicted"`
+	Flushes FlushStats `json:"flushes"`
+	// StoredRecords counts the records of the store and StoredSearches sums
+	// their counts, 0 when the store does not implement store.SearchCountStore
+	StoredRecords  int64 `json:"stored_records"`
+	StoredSearches int64 `json:"stored_searches"`
+}
+
+// Stats walks the trie under the read lock, then counts the stored records
+// with store.SearchCountStore, or reads the stored words of other stores. The
+// figures of the trie are returned even when the store fails.
+func (sl *SearchLogger) Stats(ctx context.Context) (Stats, error) {
+	stats := sl.trieStats()
+
+	if countStore, ok := sl.db.(store.SearchCountStore); ok {
+		records, searches, err := countStore.CountSearches(ctx)
+		if err != nil {
+			return stats, store.Classify(err)
+		}
+		stats.StoredRecords, stats.StoredSearches = records, searches
+		return stats, nil
+	}
+	words, err := sl.db.GetAllSearchedWords(ctx)
+	if err != nil {
+		return stats, store.Classify(err)
+	}
+	stats.StoredRecords = int64(len(words))
+	return stats, nil
+}
+
+// trieStats computes the figures of Stats held in memory
+func (sl *SearchLogger) trieStats() Stats {
+	sl.mutex.RLock()
+	defer sl.mutex.RUnlock()
+
+	pending := sl.pendingNodesLocked()
+	stats := Stats{Nodes: sl.nodes, PendingWords: len(pending), Evicted: sl.evicted, Flushes: sl.flushes}
+	var walk func(node *TrieNode, depth int)
+	walk = func(node *TrieNode, depth int) {
+		if node.isEndOfWord || pending[node] {
+			stats.Words++
+		}
+		stats.MaxDepth = max(stats.MaxDepth, depth)
+		node.children.each(func(_ string, child *TrieNode) bool {
+			walk(child, depth+1)
+			return true
+		})
+	}
+	walk(sl.trieRoot, 0)
+	return stats
+}
+
+// pendingNodesLocked returns the nodes of the typed words not stored yet, caller must hold the lock
+func (sl *SearchLogger) pendingNodesLocked() map[*TrieNode]bool {
+	pending := make(map[*TrieNode]bool)
+	for _, entry := range sl.expiry {
+		if !entry.stale() && entry.node.dbID == nil {
+			pending[entry.node] = true
+		}
+	}
+	return pending
+}
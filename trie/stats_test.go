@@ -0,0 +1,46 @@
+package trie
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLogger(time.Hour)
+	require.NoError(t, err)
+	defer logger.Close()
+
+	for _, word := range []string{"b", "bu", "bus", "cat", "cat"} {
+		require.NoError(t, logger.LogSearch(ctx, word))
+	}
+	stats, err := logger.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Stats{Nodes: 6, Words: 4, MaxDepth: 3, PendingWords: 4}, stats)
+
+	// Only the leaves are stored, then "business" extends the stored "bus"
+	require.NoError(t, logger.Flush(ctx))
+	require.NoError(t, logger.LogSearch(ctx, "business"))
+	require.NoError(t, logger.Flush(ctx))
+
+	stats, err = logger.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 11, stats.Nodes)
+	assert.Equal(t, 2, stats.Words)
+	assert.Equal(t, 8, stats.MaxDepth)
+	assert.Zero(t, stats.PendingWords)
+	// The extension was written right away, not by a flush cycle
+	assert.Equal(t, int64(1), stats.Flushes.Cycles)
+	assert.Equal(t, int64(2), stats.StoredRecords)
+	records, err := logger.GetStoredRecords(ctx)
+	require.NoError(t, err)
+	var searches int64
+	for _, record := range records {
+		searches += int64(record.SearchCount)
+	}
+	assert.Equal(t, searches, stats.StoredSearches)
+}
@@ -0,0 +1,34 @@
+package trie
+
+import (
+	"errors"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/afanwang/logsearch/trending"
+)
+
+// WithTrending counts the completed words in buckets rolling buckets of the
+// given width for GetTrending, see logsearch.WithTrending. A word counts when
+// it is stored, at the time it was last searched, and extending a stored word
+// moves its count to the longer word.
+func WithTrending(width time.Duration, buckets int) Option {
+	return func(sl *SearchLogger) {
+		sl.trending = trending.New(width, buckets)
+	}
+}
+
+// GetTrending returns the limit words searched the most within the last
+// window, most searched first. Words still pending in the trie do not count yet.
+func (sl *SearchLogger) GetTrending(window time.Duration, limit int) ([]store.WordCount, error) {
+	if sl.trending == nil {
+		return nil, errors.New("trending is not enabled")
+	}
+
+	items := sl.trending.Top(window, limit, time.Now())
+	counts := make([]store.WordCount, len(items))
+	for i, item := range items {
+		counts[i] = store.WordCount{Word: item.Word, Count: int(item.Count)}
+	}
+	return counts, nil
+}
@@ -0,0 +1,101 @@
+package trie
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/afanwang/logsearch/changes"
+	"github.com/afanwang/logsearch/store"
+)
+
+// MarkVerified records that a moderator reviewed the stored word, so that
+// SuggestVerified returns it. A verified word is kept as is: a longer search
+// extending it is stored next to it instead of replacing it. It returns
+// store.ErrWordNotFound wrapped when the word is not stored, e.g. still
+// pending, and fails unless the store implements store.VerifiedSearchStore.
+func (sl *SearchLogger) MarkVerified(ctx context.Context, word string) error {
+	_, err := sl.SetVerified(ctx, word, true)
+	return err
+}
+
+// UnmarkVerified withdraws the review of a stored word, see MarkVerified
+func (sl *SearchLogger) UnmarkVerified(ctx context.Context, word string) error {
+	_, err := sl.SetVerified(ctx, word, false)
+	return err
+}
+
+// SetVerified updates the verified flag of word in the store, then in the
+// trie, like MarkVerified and UnmarkVerified. It returns whether the word was
+// verified before, e.g. for an audit log.
+func (sl *SearchLogger) SetVerified(ctx context.Context, word string, verified bool) (bool, error) {
+	verifiedStore, ok := sl.db.(store.VerifiedSearchStore)
+	if !ok {
+		return false, errors.New("store does not support verified words")
+	}
+	word = sl.normalizer.Normalize(word)
+
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	if err := verifiedStore.SetVerified(ctx, word, verified); err != nil {
+		return false, fmt.Errorf("failed to set verified: %w", store.Classify(err))
+	}
+	change := changes.Change{Op: changes.OpUpdate, Word: word, Verified: &verified, At: time.Now()}
+	previous := false
+	if node := sl.findLocked(word); node != nil {
+		previous = node.verified
+		node.verified = verified
+		if node.dbID != nil {
+			change.ID = *node.dbID
+		}
+	}
+	sl.changes.Emit(change)
+	return previous, nil
+}
+
+// ListUnverified returns the limit most searched stored words awaiting review,
+// most searched first, for a moderation queue
+func (sl *SearchLogger) ListUnverified(ctx context.Context, limit int) ([]store.WordCount, error) {
+	verifiedStore, ok := sl.db.(store.VerifiedSearchStore)
+	if !ok {
+		return nil, errors.New("store does not support verified words")
+	}
+
+	counts, err := verifiedStore.ListUnverified(ctx, limit)
+	return counts, store.Classify(err)
+}
+
+// SuggestVerified is Suggest restricted to the words marked verified, so that
+// unreviewed queries of the users never surface in autocomplete
+func (sl *SearchLogger) SuggestVerified(prefix string, limit int) ([]string, error) {
+	return sl.suggest(prefix, limit, wordMatch{verifiedOnly: true})
+}
+
+// findLocked returns the node of word, nil if it is not in the trie, caller must hold the lock
+func (sl *SearchLogger) findLocked(word string) *TrieNode {
+	return findFrom(sl.trieRoot, word)
+}
+
+// loadVerifiedWords flags the verified words of the trie, when the store keeps them
+func (sl *SearchLogger) loadVerifiedWords(ctx context.Context) error {
+	verifiedStore, ok := sl.db.(store.VerifiedSearchStore)
+	if !ok {
+		return nil
+	}
+
+	words, err := verifiedStore.GetVerifiedWords(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get verified words from database: %w", store.Classify(err))
+	}
+
+	log.Printf("Loading %d verified words from database", len(words))
+	for _, word := range words {
+		if node := sl.findLocked(sl.normalizer.Normalize(word)); node != nil {
+			node.verified = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package trie
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/afanwang/logsearch/wal"
+)
+
+// WithWAL records every search in l before it touches the trie, so the words
+// still pending when the process crashes are not lost. On startup the records
+// after the last checkpoint are replayed into the trie with their original
+// timestamps. Each flush cycle checkpoints the records whose words reached the
+// store, letting l delete its old segments. Close closes l, a failing
+// constructor leaves it to the caller.
+//
+// Replay is at-least-once: a word stored after the last checkpoint but before
+// the crash is stored again, bumping its count.
+func WithWAL(l *wal.Log) Option {
+	return func(sl *SearchLogger) {
+		sl.wal = l
+	}
+}
+
+// taggedSearch starts the records carrying category tags. The records written
+// before them start with the big-endian Unix nano timestamp, whose first byte
+// is below 0x80 for any time after 1970, so both are still replayed.
+const taggedSearch = 0xff
+
+// encodeSearch encodes a search as the taggedSearch marker, its Unix nano
+// timestamp and category tags, followed by the raw word
+func encodeSearch(word string, tags uint64, at time.Time) []byte {
+	data := make([]byte, 17+len(word))
+	data[0] = taggedSearch
+	binary.BigEndian.PutUint64(data[1:], uint64(at.UnixNano()))
+	binary.BigEndian.PutUint64(data[9:], tags)
+	copy(data[17:], word)
+	return data
+}
+
+// decodeSearch decodes a record of encodeSearch, or an untagged record of
+// only the timestamp and the word
+func decodeSearch(data []byte) (string, uint64, time.Time, error) {
+	if len(data) > 0 && data[0] == taggedSearch {
+		if len(data) < 17 {
+			return "", 0, time.Time{}, fmt.Errorf("tagged search record of %d bytes is too short", len(data))
+		}
+		at := time.Unix(0, int64(binary.BigEndian.Uint64(data[1:])))
+		return string(data[17:]), binary.BigEndian.Uint64(data[9:]), at, nil
+	}
+	if len(data) < 8 {
+		return "", 0, time.Time{}, fmt.Errorf("search record of %d bytes is too short", len(data))
+	}
+	return string(data[8:]), 0, time.Unix(0, int64(binary.BigEndian.Uint64(data))), nil
+}
+
+// appendWAL records a search tagged with tags and returns its sequence number, 0 without WAL
+func (sl *SearchLogger) appendWAL(word string, tags uint64, at time.Time) (uint64, error) {
+	if sl.wal == nil {
+		return 0, nil
+	}
+
+	seq, err := sl.wal.Append(encodeSearch(word, tags, at))
+	if err != nil {
+		return 0, fmt.Errorf("failed to write search to wal: %w", err)
+	}
+	return seq, nil
+}
+
+// replayWAL applies the searches logged after the last checkpoint, caller must hold the write lock
+func (sl *SearchLogger) replayWAL(ctx context.Context) error {
+	if sl.wal == nil {
+		return nil
+	}
+
+	replayed := 0
+	err := sl.wal.Replay(func(seq uint64, data []byte) error {
+		word, tags, at, err := decodeSearch(data)
+		if err != nil {
+			return err
+		}
+		replayed++
+		return sl.logSearchLocked(ctx, word, tags, at, seq)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Replayed %d searches from the wal", replayed)
+	return nil
+}
+
+// checkpointWALLocked checkpoints every search whose word reached the store,
+// caller must hold the write lock so no search is appended meanwhile
+func (sl *SearchLogger) checkpointWALLocked() error {
+	if sl.wal == nil {
+		return nil
+	}
+
+	applied := sl.wal.LastSeq()
+	if seq := sl.oldestPendingSeq(); seq > 0 {
+		applied = seq - 1
+	}
+	if err := sl.wal.Checkpoint(applied); err != nil {
+		return fmt.Errorf("failed to checkpoint wal: %w", err)
+	}
+	return nil
+}
+
+// oldestPendingSeq returns the sequence number of the oldest search whose word
+// is not stored yet, 0 if every search is. The scan is bounded by the searches
+// of the last timeout, older entries left the expiry heap.
+func (sl *SearchLogger) oldestPendingSeq() uint64 {
+	var oldest uint64
+	pending := func(seq uint64) {
+		if seq > 0 && (oldest == 0 || seq < oldest) {
+			oldest = seq
+		}
+	}
+
+	for _, entry := range sl.expiry {
+		// Stale entries were superseded by a later search of the same word
+		if !entry.stale() && entry.node.dbID == nil {
+			pending(entry.node.seq)
+		}
+	}
+	if sl.updates != nil && len(sl.updates.pending) > 0 {
+		pending(sl.updates.oldestSeq)
+	}
+	return oldest
+}
+
+// closeWAL checkpoints and closes the wal after the final drain
+func (sl *SearchLogger) closeWAL() error {
+	if sl.wal == nil {
+		return nil
+	}
+
+	sl.mutex.Lock()
+	err := sl.checkpointWALLocked()
+	sl.mutex.Unlock()
+	return errors.Join(err, sl.wal.Close())
+}
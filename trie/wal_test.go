@@ -0,0 +1,139 @@
+package trie
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/store"
+	"github.com/afanwang/logsearch/wal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// replayedWords returns the words of the searches the wal in dir would replay
+func replayedWords(t *testing.T, dir string) []string {
+	l, err := wal.Open(dir)
+	require.NoError(t, err)
+	defer l.Close()
+
+	var words []string
+	require.NoError(t, l.Replay(func(seq uint64, data []byte) error {
+		word, _, _, err := decodeSearch(data)
+		words = append(words, word)
+		return err
+	}))
+	return words
+}
+
+func TestWALReplaysAfterCrash(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	db := store.NewMockPostgresDB()
+
+	l, err := wal.Open(dir)
+	require.NoError(t, err)
+	logger, err := NewSearchLoggerWithDB(time.Hour, db, WithWAL(l))
+	require.NoError(t, err)
+
+	for _, word := range []string{"b", "bu", "bus", "cat"} {
+		require.NoError(t, logger.LogSearch(ctx, word))
+	}
+
+	// Crash: stop the flushing routine without draining the pending words
+	logger.cancel()
+	<-logger.done
+	require.NoError(t, l.Close())
+
+	stored, err := db.GetAllSearchedWords(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, stored)
+
+	l, err = wal.Open(dir)
+	require.NoError(t, err)
+	logger, err = NewSearchLoggerWithDB(time.Hour, db, WithWAL(l))
+	require.NoError(t, err)
+
+	// The replayed words are stored like any other, and checkpointed once they are
+	require.NoError(t, logger.Flush(ctx))
+	stored, err = logger.GetStoredSearches(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"bus", "cat"}, stored)
+
+	// Only the search still pending is left to replay
+	require.NoError(t, logger.LogSearch(ctx, "dog"))
+	logger.mutex.Lock()
+	require.NoError(t, logger.checkpointWALLocked())
+	logger.mutex.Unlock()
+	assert.Equal(t, []string{"dog"}, replayedWords(t, dir))
+
+	require.NoError(t, logger.Close())
+	assert.Empty(t, replayedWords(t, dir))
+}
+
+func TestWALReplaysCategories(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	db := store.NewMockPostgresDB()
+
+	l, err := wal.Open(dir)
+	require.NoError(t, err)
+	logger, err := NewSearchLoggerWithDB(time.Hour, db, WithWAL(l), WithCategories("products", "help-center"))
+	require.NoError(t, err)
+
+	require.NoError(t, logger.LogSearchEvent(ctx, logsearch.SearchEvent{Query: "iphone", Category: "products"}))
+	require.NoError(t, logger.LogSearchBatch(ctx, []logsearch.SearchEvent{{Query: "install", Category: "help-center"}}))
+	// A record written before the records carried tags
+	_, err = l.Append(append(binary.BigEndian.AppendUint64(nil, uint64(time.Now().UnixNano())), "invoice"...))
+	require.NoError(t, err)
+
+	// Crash: stop the flushing routine without draining the pending words
+	logger.cancel()
+	<-logger.done
+	require.NoError(t, l.Close())
+
+	l, err = wal.Open(dir)
+	require.NoError(t, err)
+	logger, err = NewSearchLoggerWithDB(time.Hour, db, WithWAL(l), WithCategories("products", "help-center"))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	// The replayed words keep their categories
+	require.NoError(t, logger.Flush(ctx))
+	for category, want := range map[string][]string{
+		"products":    {"iphone"},
+		"help-center": {"install"},
+		"":            {"install", "invoice", "iphone"},
+	} {
+		suggestions, err := logger.SuggestInCategory("i", category, 10)
+		require.NoError(t, err)
+		assert.Equal(t, want, suggestions, category)
+	}
+}
+
+func TestWALKeepsBufferedUpdates(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	l, err := wal.Open(dir)
+	require.NoError(t, err)
+	logger, err := NewSearchLogger(time.Hour, WithWAL(l), WithWriteBuffer(100, time.Hour))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	require.NoError(t, logger.LogSearch(ctx, "bus"))
+	require.NoError(t, logger.Flush(ctx))
+	assert.Empty(t, replayedWords(t, dir))
+
+	// The rename of "bus" is only buffered, so the search cannot be checkpointed yet
+	require.NoError(t, logger.LogSearch(ctx, "business"))
+	logger.mutex.Lock()
+	require.NoError(t, logger.checkpointWALLocked())
+	logger.mutex.Unlock()
+	assert.Equal(t, []string{"business"}, replayedWords(t, dir))
+
+	require.NoError(t, logger.Flush(ctx))
+	assert.Empty(t, replayedWords(t, dir))
+}
@@ -0,0 +1,163 @@
+package trie
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/changes"
+	"github.com/afanwang/logsearch/metrics"
+	"github.com/afanwang/logsearch/store"
+	"github.com/afanwang/logsearch/tracing"
+)
+
+// Option configures optional SearchLogger behavior
+type Option func(*SearchLogger)
+
+// updateBuffer coalesces the record renames done when a stored word is
+// extended, so "bus" → "busi" → "business" costs one UPDATE instead of three
+type updateBuffer struct {
+	maxSize  int
+	interval time.Duration
+	// pending[dbID] is the coalesced update of the record
+	pending map[int64]*store.WordUpdate
+	// renamed[dbID] is the word of the record before its pending update
+	renamed map[int64]string
+	// oldestSeq is the WAL sequence number of the oldest pending update
+	oldestSeq uint64
+}
+
+// WithWriteBuffer buffers the updates of extended words and flushes them in
+// one batch when maxSize records are pending or every interval, whichever
+// comes first. New words are always inserted in one batch per flush cycle
+// when the store implements store.BatchSearchStore. Close flushes whatever is left.
+func WithWriteBuffer(maxSize int, interval time.Duration) Option {
+	return func(sl *SearchLogger) {
+		if maxSize <= 0 || interval <= 0 {
+			return
+		}
+		sl.updates = &updateBuffer{
+			maxSize:  maxSize,
+			interval: interval,
+			pending:  make(map[int64]*store.WordUpdate),
+			renamed:  make(map[int64]string),
+		}
+	}
+}
+
+// queueUpdate records that the record id was renamed from oldWord to newWord by
+// the search with the WAL sequence number seq, caller must hold the write lock
+func (sl *SearchLogger) queueUpdate(ctx context.Context, id int64, oldWord, newWord string, timestamp time.Time, seq uint64) error {
+	b := sl.updates
+	if len(b.pending) == 0 {
+		b.oldestSeq = seq
+	}
+	if update, ok := b.pending[id]; ok {
+		update.Word = newWord
+		update.LastUpdatedAt = timestamp
+		update.Count++
+	} else {
+		b.pending[id] = &store.WordUpdate{ID: id, Word: newWord, LastUpdatedAt: timestamp, Count: 1}
+		b.renamed[id] = oldWord
+	}
+
+	if len(b.pending) >= b.maxSize {
+		return sl.flushUpdatesLocked(ctx)
+	}
+	return nil
+}
+
+// flushUpdatesLocked writes every pending update, caller must hold the write lock.
+// On failure the updates stay pending and are retried by the next flush.
+func (sl *SearchLogger) flushUpdatesLocked(ctx context.Context) error {
+	b := sl.updates
+	if b == nil || len(b.pending) == 0 {
+		return nil
+	}
+
+	updates := make([]store.WordUpdate, 0, len(b.pending))
+	for _, update := range b.pending {
+		updates = append(updates, *update)
+	}
+
+	if batchStore, ok := sl.db.(store.BatchSearchStore); ok {
+		ctx, span := sl.tracer.Start(ctx, "store.UpdateBatch", tracing.KeyOp.String(metrics.OpBatch), tracing.KeyWrites.Int(len(updates)))
+		start := time.Now()
+		err := batchStore.UpdateBatch(ctx, updates)
+		sl.metrics.ObserveWrite(metrics.LoggerTrie, metrics.OpBatch, start, err)
+		tracing.End(span, err)
+		if err != nil {
+			return fmt.Errorf("failed to flush %d buffered updates: %w", len(updates), store.Classify(err))
+		}
+		for _, update := range updates {
+			sl.wordFinalized(logsearch.FinalizedWord{Word: update.Word, Count: update.Count, At: update.LastUpdatedAt})
+			sl.changes.Emit(changes.Change{Op: changes.OpUpdate, ID: update.ID, Word: update.Word, OldWord: b.renamed[update.ID], Count: update.Count, At: update.LastUpdatedAt})
+		}
+	} else {
+		for _, update := range updates {
+			updateCtx, span := sl.tracer.Start(ctx, "store.Update", tracing.KeyOp.String(metrics.OpUpdate))
+			start := time.Now()
+			err := sl.db.Update(updateCtx, update.ID, update.Word, update.LastUpdatedAt)
+			sl.metrics.ObserveWrite(metrics.LoggerTrie, metrics.OpUpdate, start, err)
+			tracing.End(span, err)
+			if err != nil {
+				return fmt.Errorf("failed to flush buffered update of record %d: %w", update.ID, store.Classify(err))
+			}
+			delete(b.pending, update.ID)
+			sl.wordFinalized(logsearch.FinalizedWord{Word: update.Word, Count: update.Count, At: update.LastUpdatedAt})
+			sl.changes.Emit(changes.Change{Op: changes.OpUpdate, ID: update.ID, Word: update.Word, OldWord: b.renamed[update.ID], Count: update.Count, At: update.LastUpdatedAt})
+			delete(b.renamed, update.ID)
+		}
+	}
+
+	log.Printf("Flushed %d buffered word updates", len(updates))
+	b.pending = make(map[int64]*store.WordUpdate)
+	b.renamed = make(map[int64]string)
+	return nil
+}
+
+// storeWordsToDB stores the completed words, in one batch when the store supports it.
+// Failures are logged and returned joined, the words are retried by the next flush cycle.
+func (sl *SearchLogger) storeWordsToDB(ctx context.Context, words []string, nodes []*TrieNode) error {
+	batchStore, ok := sl.db.(store.BatchSearchStore)
+	if !ok || len(words) == 1 {
+		var errs []error
+		for i, word := range words {
+			if err := sl.storeWordToDB(ctx, word, nodes[i]); err != nil {
+				log.Printf("Error storing word '%s': %v", word, err)
+				errs = append(errs, err)
+				continue
+			}
+			sl.metrics.Decision(metrics.LoggerTrie, metrics.DecisionNew)
+			sl.heavy.Add(word, 1)
+			sl.trending.Add(word, 1, nodes[i].lastSeen)
+		}
+		return errors.Join(errs...)
+	}
+
+	ctx, span := sl.tracer.Start(ctx, "store.InsertOrReplaceBatch", tracing.KeyOp.String(metrics.OpBatch), tracing.KeyWrites.Int(len(words)))
+	now := time.Now()
+	ids, err := batchStore.InsertOrReplaceBatch(ctx, words, now, now)
+	sl.metrics.ObserveWrite(metrics.LoggerTrie, metrics.OpBatch, now, err)
+	tracing.End(span, err)
+	if err != nil {
+		log.Printf("Error storing %d words: %v", len(words), err)
+		return err
+	}
+
+	for i := range words {
+		id := ids[i]
+		nodes[i].dbID = &id
+		sl.rememberStored(words[i])
+		sl.metrics.Decision(metrics.LoggerTrie, metrics.DecisionNew)
+		sl.heavy.Add(words[i], 1)
+		sl.trending.Add(words[i], 1, nodes[i].lastSeen)
+		sl.wordFinalized(logsearch.FinalizedWord{Word: words[i], Count: 1, At: now})
+		sl.changes.Emit(changes.Change{Op: changes.OpInsert, ID: id, Word: words[i], Count: 1, At: now})
+	}
+	log.Printf("Stored %d words to database in one batch", len(words))
+	return nil
+}
@@ -0,0 +1,117 @@
+package logsearch
+
+import "sort"
+
+// KeyboardLayout knows which keys are next to each other, to tell a slipped
+// finger from a different word
+type KeyboardLayout struct {
+	adjacent map[rune]map[rune]bool
+}
+
+// NewKeyboardLayout creates a layout from its rows of keys, top row first.
+// Keys are adjacent to their left and right neighbours and, rows being
+// staggered like on a typewriter, to the keys at the same and the next
+// position of the row above, e.g. "s" touches "a", "d", "w", "e", "z" and "x"
+// on QWERTY.
+func NewKeyboardLayout(rows ...string) *KeyboardLayout {
+	k := &KeyboardLayout{adjacent: make(map[rune]map[rune]bool)}
+	link := func(a, b rune) {
+		if k.adjacent[a] == nil {
+			k.adjacent[a] = make(map[rune]bool)
+		}
+		if k.adjacent[b] == nil {
+			k.adjacent[b] = make(map[rune]bool)
+		}
+		k.adjacent[a][b] = true
+		k.adjacent[b][a] = true
+	}
+
+	var above []rune
+	for _, row := range rows {
+		keys := []rune(row)
+		for i, key := range keys {
+			if i > 0 {
+				link(keys[i-1], key)
+			}
+			for _, j := range []int{i, i + 1} {
+				if j < len(above) {
+					link(above[j], key)
+				}
+			}
+		}
+		above = keys
+	}
+	return k
+}
+
+// QWERTY is the lowercase US QWERTY layout with its digit row
+var QWERTY = NewKeyboardLayout("1234567890", "qwertyuiop", "asdfghjkl", "zxcvbnm")
+
+// Adjacent reports whether the keys of a and b are next to each other
+func (k *KeyboardLayout) Adjacent(a, b rune) bool {
+	return k.adjacent[a][b]
+}
+
+// WithTypoMerge folds a search into the user's stored word that differs from it
+// by one substitution of adjacent keys of layout, QWERTY when nil, e.g.
+// "businesd" and "business", so the typo does not get a record of its own.
+// The canonical form of the pair is the more popular one in the index of
+// WithSpellCorrection when enabled, otherwise the newer search, users
+// retyping a word to fix it. Merging the typo counts one more search of the
+// canonical word, renaming the stored word when the new search is canonical.
+func WithTypoMerge(layout *KeyboardLayout) Option {
+	return func(sl *SearchLoggerV2) {
+		if layout == nil {
+			layout = QWERTY
+		}
+		sl.typos = layout
+	}
+}
+
+// storedTypo returns the word of the sorted words that word is a keyboard typo
+// of, or the other way around, when WithTypoMerge is enabled. A word stored
+// itself is no typo.
+func (sl *SearchLoggerV2) storedTypo(sorted []string, word string) (string, bool) {
+	if sl.typos == nil {
+		return "", false
+	}
+	if i := sort.SearchStrings(sorted, word); i < len(sorted) && sorted[i] == word {
+		return "", false
+	}
+
+	runes := []rune(word)
+	for _, candidate := range sorted {
+		if sl.typos.oneSlip(runes, []rune(candidate)) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// oneSlip reports whether a and b only differ by one substitution of adjacent keys
+func (k *KeyboardLayout) oneSlip(a, b []rune) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	slips := 0
+	for i := range a {
+		if a[i] == b[i] {
+			continue
+		}
+		if !k.Adjacent(a[i], b[i]) {
+			return false
+		}
+		slips++
+	}
+	return slips == 1
+}
+
+// canonicalOf returns which of the stored word and the new search differing
+// by a typo is the canonical form, see WithTypoMerge
+func (sl *SearchLoggerV2) canonicalOf(stored, word string) string {
+	if sl.spell.Count(stored) > sl.spell.Count(word) {
+		return stored
+	}
+	return word
+}
@@ -0,0 +1,74 @@
+package logsearch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyboardLayout(t *testing.T) {
+	for _, pair := range []string{"sd", "sa", "sw", "se", "sz", "sx", "q1", "q2", "mn", "mk"} {
+		runes := []rune(pair)
+		assert.True(t, QWERTY.Adjacent(runes[0], runes[1]), pair)
+		assert.True(t, QWERTY.Adjacent(runes[1], runes[0]), pair)
+	}
+	for _, pair := range []string{"sf", "sq", "sc", "qs", "ss"} {
+		runes := []rune(pair)
+		assert.False(t, QWERTY.Adjacent(runes[0], runes[1]), pair)
+	}
+
+	assert.True(t, QWERTY.oneSlip([]rune("businesd"), []rune("business")))
+	assert.False(t, QWERTY.oneSlip([]rune("business"), []rune("business")))
+	assert.False(t, QWERTY.oneSlip([]rune("bus"), []rune("bug")), "s and g are not adjacent")
+	assert.False(t, QWERTY.oneSlip([]rune("bis"), []rune("bua")), "Two slips are two words")
+}
+
+func TestSearchLoggerV2_TypoMerge(t *testing.T) {
+	ctx := context.Background()
+	for _, buffered := range []bool{false, true} {
+		opts := []Option{WithTypoMerge(nil)}
+		if buffered {
+			opts = append(opts, WithWriteBuffer(100, time.Hour))
+		}
+		logger, err := NewSearchLoggerV2(opts...)
+		assert.NoError(t, err)
+
+		// The newer search fixes the typo, keeping its count
+		for _, word := range []string{"businesd", "business", "bug"} {
+			assert.NoError(t, logger.LogSearchV2(ctx, "user_1", word))
+		}
+		assert.NoError(t, logger.Flush(ctx))
+		searches, err := logger.GetUserSearches(ctx, "user_1")
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{"business", "bug"}, searches, "buffered: %v", buffered)
+
+		top, err := logger.GetTopSearches(ctx, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []store.WordCount{{Word: "business", Count: 2}, {Word: "bug", Count: 1}}, top, "buffered: %v", buffered)
+		assert.NoError(t, logger.Close())
+	}
+}
+
+func TestSearchLoggerV2_TypoMergeIntoPopular(t *testing.T) {
+	ctx := context.Background()
+	logger, err := NewSearchLoggerV2(WithTypoMerge(nil), WithSpellCorrection(1))
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	for _, user := range []string{"user_1", "user_2"} {
+		assert.NoError(t, logger.LogSearchV2(ctx, user, "business"))
+	}
+
+	// The typo of a popular word is counted as the popular word
+	assert.NoError(t, logger.LogSearchV2(ctx, "user_1", "businesd"))
+	searches, err := logger.GetUserSearches(ctx, "user_1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"business"}, searches)
+
+	top, err := logger.GetTopSearches(ctx, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []store.WordCount{{Word: "business", Count: 3}}, top)
+}
@@ -0,0 +1,196 @@
+package logsearch
+
+import (
+	"container/list"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// userCache keeps each user's stored words as a sorted slice so the prefix
+// checks of LogSearchV2 are binary searches instead of a store round trip.
+// The total number of cached words is capped, idle users are evicted first.
+// A nil *userCache is a disabled cache, all methods are no-ops on it.
+type userCache struct {
+	mutex    sync.Mutex
+	maxWords int
+	words    int
+	// lru holds *userCacheEntry, most recently used at the front
+	lru   *list.List
+	users map[string]*list.Element
+}
+
+type userCacheEntry struct {
+	userIdentifier string
+	// words is sorted and never modified in place, so it can be handed out without copying
+	words []string
+}
+
+func newUserCache(maxWords int) *userCache {
+	return &userCache{
+		maxWords: maxWords,
+		lru:      list.New(),
+		users:    make(map[string]*list.Element),
+	}
+}
+
+// get returns the user's sorted words and whether the user is cached
+func (c *userCache) get(userIdentifier string) ([]string, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, ok := c.users[userIdentifier]
+	if !ok {
+		return nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*userCacheEntry).words, true
+}
+
+// set caches the user's sorted words as loaded from the store
+func (c *userCache) set(userIdentifier string, words []string) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.store(userIdentifier, words)
+}
+
+// add records a newly stored word for a cached user
+func (c *userCache) add(userIdentifier, word string) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, ok := c.users[userIdentifier]
+	if !ok {
+		return
+	}
+
+	words := elem.Value.(*userCacheEntry).words
+	i := sort.SearchStrings(words, word)
+	if i < len(words) && words[i] == word {
+		return
+	}
+
+	updated := make([]string, 0, len(words)+1)
+	updated = append(updated, words[:i]...)
+	updated = append(updated, word)
+	updated = append(updated, words[i:]...)
+	c.store(userIdentifier, updated)
+}
+
+// replace records that oldWord was extended to newWord for a cached user
+func (c *userCache) replace(userIdentifier, oldWord, newWord string) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, ok := c.users[userIdentifier]
+	if !ok {
+		return
+	}
+
+	words := elem.Value.(*userCacheEntry).words
+	updated := make([]string, 0, len(words)+1)
+	for _, word := range words {
+		if word != oldWord && word != newWord {
+			updated = append(updated, word)
+		}
+	}
+	updated = append(updated, newWord)
+	sort.Strings(updated)
+	c.store(userIdentifier, updated)
+}
+
+// invalidate drops the user so the next lookup reloads from the store
+func (c *userCache) invalidate(userIdentifier string) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, ok := c.users[userIdentifier]; ok {
+		c.remove(elem)
+	}
+}
+
+// clear drops every user so the next lookups reload from the store
+func (c *userCache) clear() {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.lru.Init()
+	c.users = make(map[string]*list.Element)
+	c.words = 0
+}
+
+// store sets the user's words and evicts idle users beyond the cap, caller must hold the mutex
+func (c *userCache) store(userIdentifier string, words []string) {
+	if elem, ok := c.users[userIdentifier]; ok {
+		c.remove(elem)
+	}
+
+	// A single user larger than the whole cache is not worth caching
+	if len(words) > c.maxWords {
+		return
+	}
+
+	c.users[userIdentifier] = c.lru.PushFront(&userCacheEntry{userIdentifier: userIdentifier, words: words})
+	c.words += len(words)
+
+	for c.words > c.maxWords {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops a cache entry, caller must hold the mutex
+func (c *userCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*userCacheEntry)
+	delete(c.users, entry.userIdentifier)
+	c.words -= len(entry.words)
+}
+
+// longestStoredPrefix returns the longest word in sorted that is a strict prefix of word
+func longestStoredPrefix(sorted []string, word string) (string, bool) {
+	for end := len(word) - 1; end > 0; end-- {
+		prefix := word[:end]
+		i := sort.SearchStrings(sorted, prefix)
+		if i < len(sorted) && sorted[i] == prefix {
+			return prefix, true
+		}
+	}
+	return "", false
+}
+
+// storedExtension returns a word in sorted that word is a strict prefix of
+func storedExtension(sorted []string, word string) (string, bool) {
+	// Every extension of word sorts right after word itself
+	i := sort.SearchStrings(sorted, word)
+	for ; i < len(sorted) && strings.HasPrefix(sorted[i], word); i++ {
+		if len(sorted[i]) > len(word) {
+			return sorted[i], true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,55 @@
+package logsearch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserCache_EvictsIdleUsers(t *testing.T) {
+	cache := newUserCache(4)
+	cache.set("user_1", []string{"apple", "band"})
+	cache.set("user_2", []string{"cat"})
+
+	// Touch user_1 so user_2 becomes the most idle user
+	_, ok := cache.get("user_1")
+	assert.True(t, ok)
+
+	cache.set("user_3", []string{"dog", "egg"})
+
+	_, ok = cache.get("user_2")
+	assert.False(t, ok, "Idle user should have been evicted")
+	_, ok = cache.get("user_3")
+	assert.True(t, ok)
+	assert.LessOrEqual(t, cache.words, 4)
+}
+
+func TestUserCache_AddAndReplaceKeepOrder(t *testing.T) {
+	cache := newUserCache(10)
+	cache.set("user_1", []string{"band", "cat"})
+
+	cache.add("user_1", "apple")
+	cache.replace("user_1", "cat", "cats")
+
+	words, ok := cache.get("user_1")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"apple", "band", "cats"}, words)
+}
+
+func TestPrefixLookups(t *testing.T) {
+	sorted := []string{"b", "bus", "business", "cat"}
+
+	prefix, ok := longestStoredPrefix(sorted, "busin")
+	assert.True(t, ok)
+	assert.Equal(t, "bus", prefix)
+
+	_, ok = longestStoredPrefix(sorted, "dog")
+	assert.False(t, ok)
+
+	extension, ok := storedExtension(sorted, "busi")
+	assert.True(t, ok)
+	assert.Equal(t, "business", extension)
+
+	_, ok = storedExtension(sorted, "business")
+	assert.False(t, ok)
+}
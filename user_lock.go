@@ -0,0 +1,27 @@
+package logsearch
+
+import (
+	"context"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// WithUserLocks consolidates every search while holding the lock of its user
+// in the store, so that replicas sharing the store, or concurrent requests of
+// one replica, never consolidate a search of a user against words another
+// one is rewriting, which would store duplicate or conflicting records. The
+// store must implement store.UserLockStore. It cannot be combined with
+// WithUserCache or WithWriteBuffer, whose words only this replica knows.
+func WithUserLocks() Option {
+	return func(sl *SearchLoggerV2) {
+		sl.userLocks = true
+	}
+}
+
+// lockUser runs fn while holding the lock of the user, or right away without WithUserLocks
+func (sl *SearchLoggerV2) lockUser(ctx context.Context, userIdentifier string, fn func(ctx context.Context) error) error {
+	if !sl.userLocks {
+		return fn(ctx)
+	}
+	return sl.db.(store.UserLockStore).WithUserLock(ctx, userIdentifier, fn)
+}
@@ -0,0 +1,65 @@
+package logsearch
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Validator bounds and sanitizes the raw searches of both loggers before they
+// reach the trie or the store, so hostile input cannot build unbounded trie
+// paths or store unprintable words. It is safe for concurrent use.
+type Validator struct {
+	maxBytes int
+	maxRunes int
+}
+
+// DefaultValidator accepts words up to MaxWordBytes bytes, with no bound on runes
+var DefaultValidator = NewValidator(MaxWordBytes, 0)
+
+// NewValidator creates a validator rejecting words longer than maxBytes bytes,
+// MaxWordBytes when not positive, or than maxRunes runes, unbounded when not positive
+func NewValidator(maxBytes, maxRunes int) *Validator {
+	if maxBytes <= 0 {
+		maxBytes = MaxWordBytes
+	}
+	return &Validator{maxBytes: maxBytes, maxRunes: max(maxRunes, 0)}
+}
+
+// Sanitize checks a raw search and returns it without control characters,
+// tabs and line breaks becoming spaces. It returns a wrapped ErrEmptyWord for
+// a word empty before or after sanitizing, ErrWordTooLong beyond the limits
+// and ErrInvalidInput for binary garbage: invalid UTF-8 or NUL bytes.
+func (v *Validator) Sanitize(word string) (string, error) {
+	if word == "" {
+		return "", ErrEmptyWord
+	}
+	// Bounding the bytes first keeps the work on hostile input bounded too
+	if len(word) > v.maxBytes {
+		return "", fmt.Errorf("%w: %d bytes exceeds %d", ErrWordTooLong, len(word), v.maxBytes)
+	}
+	if !utf8.ValidString(word) {
+		return "", fmt.Errorf("%w: word is not valid UTF-8", ErrInvalidInput)
+	}
+	if strings.IndexByte(word, 0) >= 0 {
+		return "", fmt.Errorf("%w: word contains a NUL byte", ErrInvalidInput)
+	}
+
+	word = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\t' || r == '\n' || r == '\r':
+			return ' '
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, word)
+	if word == "" {
+		return "", ErrEmptyWord
+	}
+	if n := utf8.RuneCountInString(word); v.maxRunes > 0 && n > v.maxRunes {
+		return "", fmt.Errorf("%w: %d runes exceeds %d", ErrWordTooLong, n, v.maxRunes)
+	}
+	return word, nil
+}
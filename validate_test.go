@@ -0,0 +1,46 @@
+package logsearch
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidator(t *testing.T) {
+	v := NewValidator(8, 4)
+	tests := []struct {
+		name string
+		in   string
+		want string
+		err  error
+	}{
+		{"plain", "bus", "bus", nil},
+		{"control characters stripped", "b\x1bu\x7fs", "bus", nil},
+		{"whitespace controls become spaces", "a\tb\n", "a b ", nil},
+		{"empty", "", "", ErrEmptyWord},
+		{"only controls", "\x1b\x07", "", ErrEmptyWord},
+		{"invalid UTF-8", "bu\xffs", "", ErrInvalidInput},
+		{"NUL byte", "bu\x00s", "", ErrInvalidInput},
+		{"too many bytes", "businesses", "", ErrWordTooLong},
+		{"too many runes", "héllo", "", ErrWordTooLong},
+		{"runes within bytes", "日本", "日本", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := v.Sanitize(tt.in)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	// Not positive limits fall back to MaxWordBytes and no rune limit
+	_, err := NewValidator(0, 0).Sanitize(strings.Repeat("é", MaxWordBytes/2))
+	assert.NoError(t, err)
+	_, err = NewValidator(-1, -1).Sanitize(strings.Repeat("a", MaxWordBytes+1))
+	assert.ErrorIs(t, err, ErrWordTooLong)
+}
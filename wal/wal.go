@@ -0,0 +1,422 @@
+// Package wal is an append-only write-ahead log of opaque records, split in
+// segment files. Every record gets a sequence number. Once the effects of the
+// records up to a sequence number are durable elsewhere, Checkpoint records it
+// and deletes the segments holding only older records, and Replay skips them.
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/afanwang/logsearch/encrypt"
+)
+
+const (
+	// segmentExt is the extension of the segment files, named after the sequence number of their first record
+	segmentExt = ".wal"
+	// checkpointFile holds the sequence number of the last checkpointed record
+	checkpointFile = "checkpoint"
+	// headerSize is the CRC-32, the data length and the sequence number preceding the data of a record
+	headerSize = 4 + 4 + 8
+	// maxRecordSize bounds the data length read back, so a corrupt length cannot allocate unbounded memory
+	maxRecordSize = 16 << 20
+)
+
+// ErrCorrupt is returned by Replay for a damaged record before the end of the log
+var ErrCorrupt = errors.New("wal: corrupt record")
+
+// segment is a log file, first is the sequence number of its first record
+type segment struct {
+	first uint64
+	path  string
+}
+
+// Log is a write-ahead log in a directory, safe for concurrent use
+type Log struct {
+	dir         string
+	segmentSize int64
+	sync        bool
+	// cipher encrypts the data of the records, nil when disabled
+	cipher *encrypt.Cipher
+
+	mutex    sync.Mutex
+	segments []segment
+	// file is the last segment, appended to
+	file *os.File
+	size int64
+	// lastSeq is the sequence number of the last appended record
+	lastSeq uint64
+	// checkpoint is the sequence number of the last checkpointed record
+	checkpoint uint64
+}
+
+// Option configures optional Log behavior
+type Option func(*Log)
+
+// WithSegmentSize starts a new segment once the current one reaches size bytes, 64 MiB by default
+func WithSegmentSize(size int64) Option {
+	return func(l *Log) {
+		if size > 0 {
+			l.segmentSize = size
+		}
+	}
+}
+
+// WithSync sets whether every append is fsynced, true by default. Without it a
+// process crash loses nothing but an operating system crash may lose the last records.
+func WithSync(sync bool) Option {
+	return func(l *Log) {
+		l.sync = sync
+	}
+}
+
+// WithCipher encrypts the data of every record with c, bound to its sequence
+// number, so the segments do not hold the searches in clear. A log must always
+// be opened with the cipher it was written with: Replay fails with
+// encrypt.ErrDecrypt on records written in clear or with another key. A nil c
+// writes the records in clear.
+func WithCipher(c *encrypt.Cipher) Option {
+	return func(l *Log) {
+		l.cipher = c
+	}
+}
+
+// Open opens the log in dir, creating dir if needed. A record torn by a crash
+// at the end of the last segment is truncated away.
+func Open(dir string, opts ...Option) (*Log, error) {
+	l := &Log{
+		dir:         dir,
+		segmentSize: 64 << 20,
+		sync:        true,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create wal directory: %w", err)
+	}
+	if err := l.readCheckpoint(); err != nil {
+		return nil, err
+	}
+	if err := l.listSegments(); err != nil {
+		return nil, err
+	}
+
+	if len(l.segments) == 0 {
+		l.lastSeq = l.checkpoint
+		if err := l.createSegment(l.lastSeq + 1); err != nil {
+			return nil, err
+		}
+		return l, nil
+	}
+
+	if err := l.openLastSegment(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// readCheckpoint loads the checkpoint file, a missing file means nothing was checkpointed
+func (l *Log) readCheckpoint() error {
+	data, err := os.ReadFile(filepath.Join(l.dir, checkpointFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read wal checkpoint: %w", err)
+	}
+
+	seq, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid wal checkpoint %q: %w", data, err)
+	}
+	l.checkpoint = seq
+	return nil
+}
+
+// listSegments finds the segment files of the directory in sequence order
+func (l *Log) listSegments() error {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return fmt.Errorf("failed to list wal segments: %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		l.segments = append(l.segments, segment{first: first, path: filepath.Join(l.dir, name)})
+	}
+
+	sort.Slice(l.segments, func(i, j int) bool { return l.segments[i].first < l.segments[j].first })
+	return nil
+}
+
+// openLastSegment scans the last segment for its last valid record, truncates
+// whatever follows it and opens the segment for appending
+func (l *Log) openLastSegment() error {
+	last := l.segments[len(l.segments)-1]
+	file, err := os.OpenFile(last.path, os.O_RDWR, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open wal segment: %w", err)
+	}
+
+	l.lastSeq = last.first - 1
+	var offset int64
+	err = readRecords(file, func(seq uint64, data []byte) error {
+		l.lastSeq = seq
+		offset += headerSize + int64(len(data))
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrCorrupt) {
+		file.Close()
+		return fmt.Errorf("failed to scan wal segment %s: %w", last.path, err)
+	}
+
+	// A torn write only ever damages the tail of the last segment
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to truncate wal segment: %w", err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to seek wal segment: %w", err)
+	}
+
+	l.file = file
+	l.size = offset
+	return nil
+}
+
+// createSegment starts a segment whose first record will be first, caller must hold the mutex
+func (l *Log) createSegment(first uint64) error {
+	path := filepath.Join(l.dir, fmt.Sprintf("%020d%s", first, segmentExt))
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create wal segment: %w", err)
+	}
+
+	l.segments = append(l.segments, segment{first: first, path: path})
+	l.file = file
+	l.size = 0
+	return nil
+}
+
+// Append writes a record and returns its sequence number. The record is
+// fsynced before Append returns unless the log was opened WithSync(false).
+func (l *Log) Append(data []byte) (uint64, error) {
+	// The encryption adds its nonce and tag to the stored record
+	limit := maxRecordSize
+	if l.cipher != nil {
+		limit -= l.cipher.Overhead()
+	}
+	if len(data) > limit {
+		return 0, fmt.Errorf("wal record of %d bytes exceeds %d bytes", len(data), limit)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return 0, errors.New("wal is closed")
+	}
+
+	if l.size >= l.segmentSize {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	seq := l.lastSeq + 1
+	if l.cipher != nil {
+		data = l.cipher.Seal(data, seqAD(seq))
+	}
+	record := make([]byte, headerSize+len(data))
+	binary.BigEndian.PutUint32(record[4:8], uint32(len(data)))
+	binary.BigEndian.PutUint64(record[8:16], seq)
+	copy(record[headerSize:], data)
+	binary.BigEndian.PutUint32(record[0:4], crc32.ChecksumIEEE(record[8:]))
+
+	if _, err := l.file.Write(record); err != nil {
+		// Drop the partial record so the next append does not follow garbage
+		l.file.Truncate(l.size)
+		l.file.Seek(l.size, io.SeekStart)
+		return 0, fmt.Errorf("failed to append wal record: %w", err)
+	}
+	if l.sync {
+		if err := l.file.Sync(); err != nil {
+			return 0, fmt.Errorf("failed to sync wal: %w", err)
+		}
+	}
+
+	l.size += int64(len(record))
+	l.lastSeq = seq
+	return seq, nil
+}
+
+// rotate closes the current segment and starts the next one, caller must hold the mutex
+func (l *Log) rotate() error {
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync wal segment: %w", err)
+	}
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("failed to close wal segment: %w", err)
+	}
+	return l.createSegment(l.lastSeq + 1)
+}
+
+// LastSeq returns the sequence number of the last appended record, 0 if none
+func (l *Log) LastSeq() uint64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.lastSeq
+}
+
+// Replay calls fn with every record after the checkpoint, oldest first,
+// stopping at and returning the first error of fn. It must not run
+// concurrently with Append.
+func (l *Log) Replay(fn func(seq uint64, data []byte) error) error {
+	l.mutex.Lock()
+	segments := append([]segment(nil), l.segments...)
+	checkpoint := l.checkpoint
+	l.mutex.Unlock()
+
+	for i, seg := range segments {
+		// Skip the segments holding only checkpointed records
+		if i+1 < len(segments) && segments[i+1].first <= checkpoint+1 {
+			continue
+		}
+
+		file, err := os.Open(seg.path)
+		if err != nil {
+			return fmt.Errorf("failed to open wal segment: %w", err)
+		}
+		err = readRecords(file, func(seq uint64, data []byte) error {
+			if seq <= checkpoint {
+				return nil
+			}
+			if l.cipher != nil {
+				var err error
+				if data, err = l.cipher.Open(data, seqAD(seq)); err != nil {
+					return fmt.Errorf("record %d: %w", seq, err)
+				}
+			}
+			return fn(seq, data)
+		})
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to replay wal segment %s: %w", seg.path, err)
+		}
+	}
+	return nil
+}
+
+// Checkpoint records that the records up to seq no longer need replaying and
+// deletes the segments holding only such records. A seq at or before the
+// current checkpoint is a no-op.
+func (l *Log) Checkpoint(seq uint64) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	seq = min(seq, l.lastSeq)
+	if seq <= l.checkpoint {
+		return nil
+	}
+
+	// Write then rename, so a crash leaves either checkpoint in place
+	path := filepath.Join(l.dir, checkpointFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatUint(seq, 10)), 0o644); err != nil {
+		return fmt.Errorf("failed to write wal checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write wal checkpoint: %w", err)
+	}
+	l.checkpoint = seq
+
+	// The last segment is kept for appending even when fully checkpointed
+	var errs []error
+	for len(l.segments) > 1 && l.segments[1].first <= seq+1 {
+		if err := os.Remove(l.segments[0].path); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete wal segment: %w", err))
+			break
+		}
+		l.segments = l.segments[1:]
+	}
+	return errors.Join(errs...)
+}
+
+// Close syncs and closes the log
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := errors.Join(l.file.Sync(), l.file.Close())
+	l.file = nil
+	return err
+}
+
+// seqAD is the additional data binding an encrypted record to its sequence number
+func seqAD(seq uint64) []byte {
+	ad := make([]byte, 8)
+	binary.BigEndian.PutUint64(ad, seq)
+	return ad
+}
+
+// readRecords calls fn with every record of r. It returns ErrCorrupt at the
+// first record that is truncated or fails its checksum, nil at a clean end.
+func readRecords(r io.Reader, fn func(seq uint64, data []byte) error) error {
+	br := bufio.NewReader(r)
+	header := make([]byte, headerSize)
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return ErrCorrupt
+			}
+			return err
+		}
+
+		length := binary.BigEndian.Uint32(header[4:8])
+		if length > maxRecordSize {
+			return ErrCorrupt
+		}
+		record := make([]byte, 8+length)
+		copy(record, header[8:16])
+		if _, err := io.ReadFull(br, record[8:]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return ErrCorrupt
+			}
+			return err
+		}
+		if crc32.ChecksumIEEE(record) != binary.BigEndian.Uint32(header[0:4]) {
+			return ErrCorrupt
+		}
+
+		if err := fn(binary.BigEndian.Uint64(record[0:8]), record[8:]); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package wal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/afanwang/logsearch/encrypt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// replayAll returns the data of every record replayed by l
+func replayAll(t *testing.T, l *Log) []string {
+	var records []string
+	require.NoError(t, l.Replay(func(seq uint64, data []byte) error {
+		records = append(records, string(data))
+		return nil
+	}))
+	return records
+}
+
+// segmentFiles returns the names of the segment files in dir
+func segmentFiles(t *testing.T, dir string) []string {
+	matches, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	require.NoError(t, err)
+	for i, match := range matches {
+		matches[i] = filepath.Base(match)
+	}
+	return matches
+}
+
+func TestAppendAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir)
+	require.NoError(t, err)
+
+	for i, word := range []string{"bus", "cat", "dog"} {
+		seq, err := l.Append([]byte(word))
+		require.NoError(t, err)
+		assert.Equal(t, uint64(i+1), seq)
+	}
+	assert.Equal(t, []string{"bus", "cat", "dog"}, replayAll(t, l))
+	require.NoError(t, l.Close())
+
+	_, err = l.Append([]byte("emu"))
+	assert.Error(t, err, "Appending to a closed log should fail")
+
+	// The records and the sequence outlive the log
+	l, err = Open(dir)
+	require.NoError(t, err)
+	defer l.Close()
+	assert.Equal(t, uint64(3), l.LastSeq())
+	assert.Equal(t, []string{"bus", "cat", "dog"}, replayAll(t, l))
+
+	seq, err := l.Append([]byte("emu"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), seq)
+}
+
+func TestRotationAndCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	// Every segment fills up after two records
+	l, err := Open(dir, WithSegmentSize(2*(headerSize+3)), WithSync(false))
+	require.NoError(t, err)
+
+	for _, word := range []string{"bus", "cat", "dog", "emu", "fox"} {
+		_, err := l.Append([]byte(word))
+		require.NoError(t, err)
+	}
+	assert.Len(t, segmentFiles(t, dir), 3)
+
+	// The first segment only holds checkpointed records, the second one still holds "emu"
+	require.NoError(t, l.Checkpoint(3))
+	assert.Equal(t, []string{"00000000000000000003.wal", "00000000000000000005.wal"}, segmentFiles(t, dir))
+	assert.Equal(t, []string{"emu", "fox"}, replayAll(t, l))
+
+	// Older checkpoints are ignored
+	require.NoError(t, l.Checkpoint(1))
+	assert.Equal(t, []string{"emu", "fox"}, replayAll(t, l))
+
+	// The last segment is kept for appending
+	require.NoError(t, l.Checkpoint(5))
+	assert.Equal(t, []string{"00000000000000000005.wal"}, segmentFiles(t, dir))
+	assert.Empty(t, replayAll(t, l))
+	require.NoError(t, l.Close())
+
+	l, err = Open(dir)
+	require.NoError(t, err)
+	defer l.Close()
+	assert.Empty(t, replayAll(t, l))
+	seq, err := l.Append([]byte("gnu"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(6), seq)
+	assert.Equal(t, []string{"gnu"}, replayAll(t, l))
+}
+
+func TestTornTail(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir)
+	require.NoError(t, err)
+	for _, word := range []string{"bus", "cat"} {
+		_, err := l.Append([]byte(word))
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	// A crash in the middle of the last write leaves half a record behind
+	path := filepath.Join(dir, segmentFiles(t, dir)[0])
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-2))
+
+	l, err = Open(dir)
+	require.NoError(t, err)
+	defer l.Close()
+	assert.Equal(t, uint64(1), l.LastSeq())
+	assert.Equal(t, []string{"bus"}, replayAll(t, l))
+
+	seq, err := l.Append([]byte("dog"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), seq)
+	assert.Equal(t, []string{"bus", "dog"}, replayAll(t, l))
+}
+
+func TestCorruptSegment(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, WithSegmentSize(1))
+	require.NoError(t, err)
+	for _, word := range []string{"bus", "cat"} {
+		_, err := l.Append([]byte(word))
+		require.NoError(t, err)
+	}
+
+	// Damage a record of the first, already rotated, segment
+	path := filepath.Join(dir, segmentFiles(t, dir)[0])
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	err = l.Replay(func(seq uint64, data []byte) error { return nil })
+	assert.ErrorIs(t, err, ErrCorrupt)
+	require.NoError(t, l.Close())
+}
+
+func TestCipher(t *testing.T) {
+	dir := t.TempDir()
+	c, err := encrypt.New(bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	l, err := Open(dir, WithCipher(c))
+	require.NoError(t, err)
+
+	for _, word := range []string{"secret bus", "secret cat"} {
+		_, err := l.Append([]byte(word))
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	// The segments do not hold the searches in clear
+	for _, name := range segmentFiles(t, dir) {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "secret")
+	}
+
+	l, err = Open(dir, WithCipher(c))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"secret bus", "secret cat"}, replayAll(t, l))
+	require.NoError(t, l.Close())
+
+	// Another key cannot read them
+	other, err := encrypt.New(bytes.Repeat([]byte{2}, 32))
+	require.NoError(t, err)
+	l, err = Open(dir, WithCipher(other))
+	require.NoError(t, err)
+	defer l.Close()
+	err = l.Replay(func(seq uint64, data []byte) error { return nil })
+	assert.ErrorIs(t, err, encrypt.ErrDecrypt)
+}
@@ -0,0 +1,65 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader carries the signature of a delivery, "t=<unix seconds>,v1=<hex HMAC-SHA256>"
+const SignatureHeader = "X-Logsearch-Signature"
+
+// ErrInvalidSignature is returned by Verify for a delivery not signed with the secret
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Sign returns the signature header of a body sent at t: the HMAC-SHA256 with
+// secret of the unix time, a dot and the body, so a captured delivery cannot
+// be replayed later with another timestamp
+func Sign(secret string, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac(secret, timestamp, body))
+}
+
+// Verify checks the signature header of a received body, refusing a delivery
+// signed more than tolerance away from now, 0 accepting any time. Receivers
+// call it with the raw body, before decoding it.
+func Verify(secret, header string, body []byte, tolerance time.Duration) error {
+	var timestamp, signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature = value
+		}
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: no timestamp", ErrInvalidSignature)
+	}
+	if tolerance > 0 {
+		if age := time.Since(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+			return fmt.Errorf("%w: signed %s ago", ErrInvalidSignature, age.Round(time.Second))
+		}
+	}
+	decoded, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(decoded, mac(secret, timestamp, body)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// mac is the HMAC-SHA256 of a signed timestamp and body
+func mac(secret, timestamp string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
@@ -0,0 +1,455 @@
+// Package webhook notifies HTTP endpoints when a never seen word is stored,
+// when a word crosses a search count threshold or when its searches spike,
+// e.g. to alert on emerging queries and incidents. A Notifier is registered as a hook of the trie logger, whose store
+// holds one record per word. Deliveries are signed with HMAC-SHA256, retried
+// with exponential backoff, and their status is kept for the delivery API.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/anomaly"
+	"github.com/afanwang/logsearch/store"
+)
+
+// EventType is the reason of a notification
+type EventType string
+
+const (
+	// NewTerm is sent the first time a word is stored
+	NewTerm EventType = "term.new"
+	// Threshold is sent when the search count of a word reaches a threshold of WithThresholds
+	Threshold EventType = "term.threshold"
+	// Spike is sent when the searches of a word spike, see WithSpikes
+	Spike EventType = "term.spike"
+)
+
+// Event is the JSON body of a notification
+type Event struct {
+	Type EventType `json:"type"`
+	Word string    `json:"word"`
+	// Count is the search count of the word, or its searches in the bucket of a Spike event
+	Count int `json:"count"`
+	// Threshold is the threshold reached by a Threshold event
+	Threshold int `json:"threshold,omitempty"`
+	// Mean and StdDev are the baseline of a Spike event, see anomaly.Spike
+	Mean   float64   `json:"mean,omitempty"`
+	StdDev float64   `json:"std_dev,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// Endpoint is a webhook receiving notifications
+type Endpoint struct {
+	URL string `json:"url"`
+	// Secret signs the deliveries, see Verify, none when empty
+	Secret string `json:"secret"`
+	// Events are the types delivered to the endpoint, all when empty
+	Events []EventType `json:"events"`
+}
+
+// wants reports whether the endpoint subscribed to events of type t
+func (e Endpoint) wants(t EventType) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, event := range e.Events {
+		if event == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Status is the state of a delivery
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusDelivered Status = "delivered"
+	// StatusFailed is a delivery refused by the endpoint or out of attempts
+	StatusFailed Status = "failed"
+	// StatusDropped is a delivery never attempted because the queue of the endpoint was full
+	StatusDropped Status = "dropped"
+)
+
+// Delivery is a notification sent to an endpoint
+type Delivery struct {
+	ID       uint64 `json:"id"`
+	URL      string `json:"url"`
+	Event    Event  `json:"event"`
+	Status   Status `json:"status"`
+	Attempts int    `json:"attempts"`
+	// StatusCode is the answer to the last attempt, 0 when the endpoint could not be reached
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// endpoint is an Endpoint with its queue of deliveries
+type endpoint struct {
+	Endpoint
+	queue chan *Delivery
+}
+
+// Notifier fires the webhooks. It is safe for concurrent use.
+type Notifier struct {
+	endpoints   []*endpoint
+	thresholds  []int
+	spikes      *anomaly.Detector
+	client      *http.Client
+	maxAttempts int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	queueSize   int
+	historySize int
+
+	mutex sync.Mutex
+	// counts are the search counts of the stored words, a word is new when absent
+	counts map[string]int
+	// history is a ring of the last deliveries, the delivery API
+	history []*Delivery
+	lastID  uint64
+	closed  bool
+
+	// abort cancels the deliveries once Close gave up
+	abort       context.Context
+	cancelAbort context.CancelFunc
+	workers     sync.WaitGroup
+}
+
+// Option configures optional Notifier behavior
+type Option func(*Notifier)
+
+// WithThresholds sends a Threshold event when the search count of a word
+// reaches one of thresholds, e.g. 100 and 1000. None by default.
+func WithThresholds(thresholds ...int) Option {
+	return func(n *Notifier) {
+		for _, threshold := range thresholds {
+			if threshold > 0 {
+				n.thresholds = append(n.thresholds, threshold)
+			}
+		}
+		sort.Ints(n.thresholds)
+	}
+}
+
+// WithSpikes sends a Spike event when detector raises a spike of the searches
+// of a word, e.g. WithSpikes(anomaly.New(5*time.Minute, 12, 3)). Every search
+// is counted when its word is stored, so the buckets should be several times
+// wider than the timeout of the trie logger. None by default.
+func WithSpikes(detector *anomaly.Detector) Option {
+	return func(n *Notifier) {
+		n.spikes = detector
+	}
+}
+
+// WithRetries attempts a delivery up to maxAttempts times, waiting min before
+// the first retry and doubling the wait up to max, 5 attempts from 1s to 1m by default
+func WithRetries(maxAttempts int, min, max time.Duration) Option {
+	return func(n *Notifier) {
+		if maxAttempts > 0 && min > 0 && max >= min {
+			n.maxAttempts, n.minBackoff, n.maxBackoff = maxAttempts, min, max
+		}
+	}
+}
+
+// WithQueueSize bounds the deliveries waiting for each endpoint, 1000 by
+// default. Notifications are dropped while the queue is full, rather than
+// slowing the logger down.
+func WithQueueSize(size int) Option {
+	return func(n *Notifier) {
+		if size > 0 {
+			n.queueSize = size
+		}
+	}
+}
+
+// WithHistory keeps the status of the last size deliveries, 100 by default
+func WithHistory(size int) Option {
+	return func(n *Notifier) {
+		if size > 0 {
+			n.historySize = size
+		}
+	}
+}
+
+// WithHTTPClient sends the deliveries with client, http.DefaultClient by default
+func WithHTTPClient(client *http.Client) Option {
+	return func(n *Notifier) {
+		if client != nil {
+			n.client = client
+		}
+	}
+}
+
+// New starts a Notifier delivering to endpoints, stop it with Close. Every
+// word stored before is new to it, unless given to Seed.
+func New(endpoints []Endpoint, opts ...Option) (*Notifier, error) {
+	n := &Notifier{
+		client:      http.DefaultClient,
+		maxAttempts: 5,
+		minBackoff:  time.Second,
+		maxBackoff:  time.Minute,
+		queueSize:   1000,
+		historySize: 100,
+		counts:      make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+
+	for _, e := range endpoints {
+		if u, err := url.Parse(e.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("invalid webhook URL %q", e.URL)
+		}
+		n.endpoints = append(n.endpoints, &endpoint{Endpoint: e, queue: make(chan *Delivery, n.queueSize)})
+	}
+	n.abort, n.cancelAbort = context.WithCancel(context.Background())
+
+	n.workers.Add(len(n.endpoints))
+	for _, e := range n.endpoints {
+		go n.run(e)
+	}
+	return n, nil
+}
+
+// Seed records the words already stored with their search counts, e.g. the
+// records of trie.GetStoredRecords on startup, so they are not announced as
+// new and their thresholds are not reached again
+func (n *Notifier) Seed(counts []store.WordCount) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for _, count := range counts {
+		n.counts[count.Word] = max(n.counts[count.Word], count.Count)
+	}
+}
+
+// Hook returns the hook firing the webhooks, register it with trie.WithWordFinalizedHook
+func (n *Notifier) Hook() logsearch.WordFinalizedHook {
+	return n.notify
+}
+
+// notify updates the count of a stored word and queues the notifications it triggers
+func (n *Notifier) notify(word logsearch.FinalizedWord) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.closed {
+		return
+	}
+
+	previous, seen := n.counts[word.Word]
+	count := previous + word.Count
+	// The extended prefixes were merged into the record of the word, they stay seen
+	for _, replaced := range word.ReplacedWords {
+		if replaced != word.Word {
+			count += n.counts[replaced]
+			n.counts[replaced] = 0
+		}
+	}
+	n.counts[word.Word] = count
+
+	at := word.At.UTC()
+	if !seen {
+		n.queueLocked(Event{Type: NewTerm, Word: word.Word, Count: count, At: at})
+	}
+	for _, threshold := range n.thresholds {
+		if previous < threshold && count >= threshold {
+			n.queueLocked(Event{Type: Threshold, Word: word.Word, Count: count, Threshold: threshold, At: at})
+		}
+	}
+	if spike, ok := n.spikes.Add(word.Word, int64(word.Count), at); ok {
+		n.queueLocked(Event{Type: Spike, Word: word.Word, Count: int(spike.Count), Mean: spike.Mean, StdDev: spike.StdDev, At: at})
+	}
+}
+
+// queueLocked queues a delivery of event to every subscribed endpoint, caller must hold the mutex
+func (n *Notifier) queueLocked(event Event) {
+	now := time.Now()
+	for _, e := range n.endpoints {
+		if !e.wants(event.Type) {
+			continue
+		}
+		n.lastID++
+		d := &Delivery{ID: n.lastID, URL: e.URL, Event: event, Status: StatusPending, CreatedAt: now, UpdatedAt: now}
+		if len(n.history) < n.historySize {
+			n.history = append(n.history, d)
+		} else {
+			n.history[int((d.ID-1)%uint64(n.historySize))] = d
+		}
+
+		select {
+		case e.queue <- d:
+		default:
+			d.Status = StatusDropped
+		}
+	}
+}
+
+// run delivers the queue of an endpoint until it is closed
+func (n *Notifier) run(e *endpoint) {
+	defer n.workers.Done()
+	for d := range e.queue {
+		n.deliver(e, d)
+	}
+}
+
+// deliver attempts a delivery until it succeeds, fails for good or the Notifier is aborted
+func (n *Notifier) deliver(e *endpoint, d *Delivery) {
+	n.mutex.Lock()
+	event, id := d.Event, d.ID
+	n.mutex.Unlock()
+	body, err := json.Marshal(event)
+	if err != nil {
+		n.update(d, 0, StatusFailed, 0, err)
+		return
+	}
+
+	backoff := n.minBackoff
+	for attempt := 1; ; attempt++ {
+		statusCode, err := n.send(e, id, event.Type, body)
+		switch {
+		case err == nil:
+			n.update(d, 1, StatusDelivered, statusCode, nil)
+			return
+		case !temporary(statusCode) || attempt == n.maxAttempts:
+			n.update(d, 1, StatusFailed, statusCode, err)
+			return
+		}
+		n.update(d, 1, StatusPending, statusCode, err)
+
+		select {
+		case <-time.After(backoff):
+			backoff = min(2*backoff, n.maxBackoff)
+		case <-n.abort.Done():
+			n.update(d, 0, StatusFailed, statusCode, fmt.Errorf("gave up on close: %w", err))
+			return
+		}
+	}
+}
+
+// send POSTs a signed body, returning the status code of the answer and an error unless it is 2xx
+func (n *Notifier) send(e *endpoint, id uint64, t EventType, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(n.abort, http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Logsearch-Event", string(t))
+	req.Header.Set("X-Logsearch-Delivery", strconv.FormatUint(id, 10))
+	if e.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(e.Secret, time.Now(), body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook answered %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// temporary reports whether an attempt answered statusCode may succeed later:
+// when the endpoint could not be reached, on timeouts, rate limits and server errors
+func temporary(statusCode int) bool {
+	return statusCode == 0 || statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// update records the status of a delivery after attempts more attempts
+func (n *Notifier) update(d *Delivery, attempts int, status Status, statusCode int, err error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	d.Attempts += attempts
+	d.Status, d.StatusCode, d.Error = status, statusCode, ""
+	if err != nil {
+		d.Error = err.Error()
+	}
+	d.UpdatedAt = time.Now()
+}
+
+// Deliveries returns the last deliveries with the given status, or all of them
+// when status is empty, newest first
+func (n *Notifier) Deliveries(status Status) []Delivery {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	deliveries := make([]Delivery, 0, len(n.history))
+	for _, d := range n.history {
+		if status == "" || d.Status == status {
+			deliveries = append(deliveries, *d)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].ID > deliveries[j].ID
+	})
+	return deliveries
+}
+
+// DeliveriesResponse is returned by the delivery API
+type DeliveriesResponse struct {
+	Deliveries []Delivery `json:"deliveries"`
+}
+
+// ServeHTTP serves the delivery API, GET ?status={status} returning the
+// last deliveries as a DeliveriesResponse, optionally only those with status
+func (n *Notifier) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status := Status(r.URL.Query().Get("status"))
+	switch status {
+	case "", StatusPending, StatusDelivered, StatusFailed, StatusDropped:
+	default:
+		http.Error(w, "status must be pending, delivered, failed or dropped", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DeliveriesResponse{Deliveries: n.Deliveries(status)})
+}
+
+// Close delivers the queued notifications and stops the Notifier. Once ctx is
+// done it stops retrying, fails what is left and returns the error of ctx.
+// Close the logger first, so that its last words are notified.
+func (n *Notifier) Close(ctx context.Context) error {
+	n.mutex.Lock()
+	if !n.closed {
+		n.closed = true
+		for _, e := range n.endpoints {
+			close(e.queue)
+		}
+	}
+	n.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		n.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		n.cancelAbort()
+		return nil
+	case <-ctx.Done():
+		n.cancelAbort()
+		<-done
+		return ctx.Err()
+	}
+}
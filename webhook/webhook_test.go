@@ -0,0 +1,190 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch"
+	"github.com/afanwang/logsearch/anomaly"
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receiver is a webhook endpoint answering the first requests with failStatus,
+// checking the signatures if it has a secret
+type receiver struct {
+	mutex      sync.Mutex
+	secret     string
+	failures   int
+	failStatus int
+	events     []Event
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	if rc.secret != "" && Verify(rc.secret, r.Header.Get(SignatureHeader), body, time.Minute) != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if rc.failures > 0 {
+		rc.failures--
+		w.WriteHeader(rc.failStatus)
+		return
+	}
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil || r.Header.Get("X-Logsearch-Event") != string(event.Type) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rc.events = append(rc.events, event)
+}
+
+func (rc *receiver) received() []Event {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	return append([]Event(nil), rc.events...)
+}
+
+func TestNotifierNewTermsAndThresholds(t *testing.T) {
+	rc := &receiver{secret: "s3cret"}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	n, err := New([]Endpoint{{URL: server.URL, Secret: "s3cret"}}, WithThresholds(3, 10))
+	require.NoError(t, err)
+	n.Seed([]store.WordCount{{Word: "bus", Count: 9}})
+
+	at := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	hook := n.Hook()
+	hook(logsearch.FinalizedWord{Word: "ca", Count: 1, At: at})
+	hook(logsearch.FinalizedWord{Word: "cat", ReplacedWords: []string{"ca"}, Count: 1, At: at})
+	hook(logsearch.FinalizedWord{Word: "cat", Count: 1, At: at})
+	// The prefix extended before is not new, the seeded word is not either
+	hook(logsearch.FinalizedWord{Word: "ca", Count: 1, At: at})
+	hook(logsearch.FinalizedWord{Word: "bus", Count: 2, At: at})
+	require.NoError(t, n.Close(context.Background()))
+
+	assert.Equal(t, []Event{
+		{Type: NewTerm, Word: "ca", Count: 1, At: at},
+		{Type: NewTerm, Word: "cat", Count: 2, At: at},
+		{Type: Threshold, Word: "cat", Count: 3, Threshold: 3, At: at},
+		{Type: Threshold, Word: "bus", Count: 11, Threshold: 10, At: at},
+	}, rc.received())
+
+	deliveries := n.Deliveries(StatusDelivered)
+	require.Len(t, deliveries, 4)
+	assert.Equal(t, uint64(4), deliveries[0].ID)
+	assert.Equal(t, 1, deliveries[0].Attempts)
+	assert.Equal(t, http.StatusOK, deliveries[0].StatusCode)
+}
+
+func TestNotifierSpikes(t *testing.T) {
+	rc := &receiver{}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	n, err := New([]Endpoint{{URL: server.URL, Events: []EventType{Spike}}}, WithSpikes(anomaly.New(time.Minute, 2, 3, anomaly.WithMinCount(5))))
+	require.NoError(t, err)
+
+	at := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	hook := n.Hook()
+	hook(logsearch.FinalizedWord{Word: "login", Count: 1, At: at})
+	hook(logsearch.FinalizedWord{Word: "login", Count: 1, At: at.Add(time.Minute)})
+	hook(logsearch.FinalizedWord{Word: "login", Count: 8, At: at.Add(2 * time.Minute)})
+	require.NoError(t, n.Close(context.Background()))
+
+	assert.Equal(t, []Event{{Type: Spike, Word: "login", Count: 8, Mean: 1, At: at.Add(2 * time.Minute)}}, rc.received())
+}
+
+func TestNotifierRetries(t *testing.T) {
+	flaky := &receiver{failures: 2, failStatus: http.StatusServiceUnavailable}
+	flakyServer := httptest.NewServer(flaky)
+	defer flakyServer.Close()
+	// A wrong secret is refused for good
+	refusing := &receiver{secret: "other"}
+	refusingServer := httptest.NewServer(refusing)
+	defer refusingServer.Close()
+
+	n, err := New([]Endpoint{
+		{URL: flakyServer.URL},
+		{URL: refusingServer.URL, Secret: "s3cret", Events: []EventType{NewTerm}},
+	}, WithRetries(3, time.Millisecond, 2*time.Millisecond))
+	require.NoError(t, err)
+	n.Hook()(logsearch.FinalizedWord{Word: "cat", Count: 1})
+	require.NoError(t, n.Close(context.Background()))
+
+	assert.Len(t, flaky.received(), 1)
+	assert.Empty(t, refusing.received())
+
+	deliveries := n.Deliveries("")
+	require.Len(t, deliveries, 2)
+	byURL := map[string]Delivery{deliveries[0].URL: deliveries[0], deliveries[1].URL: deliveries[1]}
+	assert.Equal(t, StatusDelivered, byURL[flakyServer.URL].Status)
+	assert.Equal(t, 3, byURL[flakyServer.URL].Attempts)
+	assert.Equal(t, StatusFailed, byURL[refusingServer.URL].Status)
+	assert.Equal(t, 1, byURL[refusingServer.URL].Attempts)
+	assert.Equal(t, http.StatusUnauthorized, byURL[refusingServer.URL].StatusCode)
+}
+
+func TestNotifierCloseGivesUp(t *testing.T) {
+	down := &receiver{failures: 1000, failStatus: http.StatusBadGateway}
+	server := httptest.NewServer(down)
+	defer server.Close()
+
+	n, err := New([]Endpoint{{URL: server.URL}}, WithRetries(1000, time.Hour, time.Hour))
+	require.NoError(t, err)
+	n.Hook()(logsearch.FinalizedWord{Word: "cat", Count: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, n.Close(ctx), context.DeadlineExceeded)
+	deliveries := n.Deliveries(StatusFailed)
+	require.Len(t, deliveries, 1)
+	assert.Contains(t, deliveries[0].Error, "gave up on close")
+}
+
+func TestNotifierDeliveryAPI(t *testing.T) {
+	n, err := New([]Endpoint{{URL: "http://127.0.0.1:1"}}, WithQueueSize(1), WithRetries(1, time.Millisecond, time.Millisecond))
+	require.NoError(t, err)
+	n.Hook()(logsearch.FinalizedWord{Word: "cat", Count: 1})
+	require.NoError(t, n.Close(context.Background()))
+
+	rec := httptest.NewRecorder()
+	n.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?status=failed", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp DeliveriesResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	require.Len(t, resp.Deliveries, 1)
+	assert.Equal(t, "cat", resp.Deliveries[0].Event.Word)
+	assert.NotEmpty(t, resp.Deliveries[0].Error)
+
+	rec = httptest.NewRecorder()
+	n.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?status=lost", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	_, err = New([]Endpoint{{URL: "ftp://example.com"}})
+	assert.Error(t, err)
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"word":"cat"}`)
+	header := Sign("s3cret", time.Now(), body)
+	assert.NoError(t, Verify("s3cret", header, body, time.Minute))
+	assert.ErrorIs(t, Verify("other", header, body, time.Minute), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("s3cret", header, []byte(`{"word":"dog"}`), time.Minute), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("s3cret", "v1=00", body, 0), ErrInvalidSignature)
+
+	old := Sign("s3cret", time.Now().Add(-time.Hour), body)
+	assert.ErrorIs(t, Verify("s3cret", old, body, time.Minute), ErrInvalidSignature)
+	assert.NoError(t, Verify("s3cret", old, body, 0))
+}
@@ -0,0 +1,397 @@
+package logsearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/afanwang/logsearch/metrics"
+	"github.com/afanwang/logsearch/store"
+	"github.com/afanwang/logsearch/tracing"
+)
+
+// writeBuffer coalesces the writes of SearchLoggerV2 per (user, word) and
+// flushes them to the store in one batch once maxSize writes are pending or
+// every interval. A user typing "b", "bu", "bus" between two flushes costs a
+// single write of "bus" instead of three round trips.
+type writeBuffer struct {
+	mutex    sync.Mutex
+	maxSize  int
+	interval time.Duration
+	// pending[userIdentifier][word] is the coalesced write of the word
+	pending map[string]map[string]*store.UserSearchWrite
+	size    int
+	// heartbeat is the UnixNano time flushRoutine last completed a round
+	heartbeat atomic.Int64
+}
+
+// WithWriteBuffer buffers store writes and flushes them in batches when
+// maxSize coalesced writes are pending or every interval, whichever comes
+// first. Buffered searches are visible to GetUserSearches right away but are
+// only durable once flushed, Close flushes whatever is left.
+func WithWriteBuffer(maxSize int, interval time.Duration) Option {
+	return func(sl *SearchLoggerV2) {
+		if maxSize <= 0 || interval <= 0 {
+			return
+		}
+		sl.buffer = &writeBuffer{
+			maxSize:  maxSize,
+			interval: interval,
+			pending:  make(map[string]map[string]*store.UserSearchWrite),
+		}
+	}
+}
+
+// flushRoutine flushes the buffer every interval until ctx is cancelled by Close
+func (sl *SearchLoggerV2) flushRoutine(ctx context.Context) {
+	defer sl.wg.Done()
+
+	ticker := time.NewTicker(sl.buffer.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			sl.buffer.mutex.Lock()
+			if err := sl.flushLocked(ctx); err != nil {
+				log.Printf("Error flushing buffered searches: %v", err)
+			}
+			sl.buffer.mutex.Unlock()
+			sl.buffer.heartbeat.Store(time.Now().UnixNano())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// bufferUserSearch applies the dedup decision of a search to the pending writes,
+// surface is the typed form of word when stemming and region where it was made
+func (sl *SearchLoggerV2) bufferUserSearch(ctx context.Context, userIdentifier, word, surface string, timestamp time.Time, late bool, region store.Region) error {
+	b := sl.buffer
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	stored, err := sl.sortedUserWords(ctx, userIdentifier)
+	if err != nil {
+		return err
+	}
+	pending := b.pending[userIdentifier]
+	existingWords, previousWord := sl.enterSession(userIdentifier, word, overlayPending(stored, pending), timestamp, late)
+
+	if existingWord, ok := longestStoredPrefix(existingWords, word); ok {
+		fmt.Fprintf(sl.out, " (extending '%s' to '%s')", existingWord, word)
+		sl.decided(ctx, metrics.DecisionExtend)
+		b.extend(userIdentifier, existingWord, word, timestamp)
+		b.setSurface(userIdentifier, word, surface)
+		b.setRegion(userIdentifier, word, region)
+		sl.moved(existingWord, word, timestamp)
+	} else if existingWord, ok := storedExtension(existingWords, word); ok {
+		fmt.Fprintf(sl.out, " (ignoring prefix of '%s')", existingWord)
+		sl.decided(ctx, metrics.DecisionIgnore)
+		return nil
+	} else if existingWord, ok := sl.storedTypo(existingWords, word); ok && !late && sl.canonicalOf(existingWord, word) == word {
+		fmt.Fprintf(sl.out, " (fixing typo '%s' to '%s')", existingWord, word)
+		sl.decided(ctx, metrics.DecisionTypo)
+		b.extend(userIdentifier, existingWord, word, timestamp)
+		b.setSurface(userIdentifier, word, surface)
+		b.setRegion(userIdentifier, word, region)
+		sl.moved(existingWord, word, timestamp)
+	} else if ok {
+		fmt.Fprintf(sl.out, " (merging typo into '%s')", existingWord)
+		sl.decided(ctx, metrics.DecisionTypo)
+		b.insert(userIdentifier, existingWord, timestamp)
+		b.setRegion(userIdentifier, existingWord, region)
+		sl.counted(existingWord, 1, timestamp)
+	} else if existingWord, ok := sl.lateBranch(existingWords, word, late); ok {
+		fmt.Fprintf(sl.out, " (ignoring branch corrected to '%s')", existingWord)
+		sl.decided(ctx, metrics.DecisionBranch)
+		return nil
+	} else if existingWord, ok := sl.correctedBranch(existingWords, previousWord, word); ok {
+		fmt.Fprintf(sl.out, " (correcting '%s' to '%s')", existingWord, word)
+		sl.decided(ctx, metrics.DecisionBranch)
+		b.extend(userIdentifier, existingWord, word, timestamp)
+		b.setSurface(userIdentifier, word, surface)
+		b.setRegion(userIdentifier, word, region)
+		sl.moved(existingWord, word, timestamp)
+	} else {
+		sl.decided(ctx, metrics.DecisionNew)
+		b.insert(userIdentifier, word, timestamp)
+		b.setSurface(userIdentifier, word, surface)
+		b.setRegion(userIdentifier, word, region)
+		sl.counted(word, 1, timestamp)
+		fmt.Fprintf(sl.out, " (new)")
+	}
+
+	if b.size >= b.maxSize {
+		return sl.flushLocked(ctx)
+	}
+	return nil
+}
+
+// userPending returns the pending writes of a user, creating them if needed
+func (b *writeBuffer) userPending(userIdentifier string) map[string]*store.UserSearchWrite {
+	pending := b.pending[userIdentifier]
+	if pending == nil {
+		pending = make(map[string]*store.UserSearchWrite)
+		b.pending[userIdentifier] = pending
+	}
+	return pending
+}
+
+// forget drops and returns the pending writes of a user, caller must hold the mutex
+func (b *writeBuffer) forget(userIdentifier string) map[string]*store.UserSearchWrite {
+	pending := b.pending[userIdentifier]
+	b.size -= len(pending)
+	delete(b.pending, userIdentifier)
+	return pending
+}
+
+// insert records one more search of word
+func (b *writeBuffer) insert(userIdentifier, word string, timestamp time.Time) {
+	pending := b.userPending(userIdentifier)
+	if write, ok := pending[word]; ok {
+		write.Count++
+		write.LastUpdatedAt = timestamp
+		return
+	}
+
+	pending[word] = &store.UserSearchWrite{
+		UserIdentifier:  userIdentifier,
+		Word:            word,
+		FirstSearchedAt: timestamp,
+		LastUpdatedAt:   timestamp,
+		Count:           1,
+	}
+	b.size++
+}
+
+// setSurface records the typed form of a pending word, the last one typed wins
+func (b *writeBuffer) setSurface(userIdentifier, word, surface string) {
+	b.pending[userIdentifier][word].SurfaceWord = surface
+}
+
+// setRegion records where a pending word was last searched, the last region known wins
+func (b *writeBuffer) setRegion(userIdentifier, word string, region store.Region) {
+	if !region.IsZero() {
+		b.pending[userIdentifier][word].Region = region
+	}
+}
+
+// extend records that the user extended oldWord, stored or pending, to newWord
+func (b *writeBuffer) extend(userIdentifier, oldWord, newWord string, timestamp time.Time) {
+	pending := b.userPending(userIdentifier)
+
+	write, ok := pending[oldWord]
+	if ok {
+		delete(pending, oldWord)
+	} else {
+		write = &store.UserSearchWrite{
+			UserIdentifier:  userIdentifier,
+			ReplaceWords:    []string{oldWord},
+			FirstSearchedAt: timestamp,
+		}
+		b.size++
+	}
+	write.Word = newWord
+	write.LastUpdatedAt = timestamp
+	write.Count++
+
+	if existing, ok := pending[newWord]; ok {
+		existing.ReplaceWords = append(existing.ReplaceWords, write.ReplaceWords...)
+		existing.Count += write.Count
+		existing.LastUpdatedAt = timestamp
+		if write.FirstSearchedAt.Before(existing.FirstSearchedAt) {
+			existing.FirstSearchedAt = write.FirstSearchedAt
+		}
+		b.size--
+		return
+	}
+	pending[newWord] = write
+}
+
+// flushLocked writes every pending write to the store, caller must hold the buffer mutex.
+// On failure the writes stay pending and are retried by the next flush, except
+// those a *store.PartialWriteError reports applied.
+func (sl *SearchLoggerV2) flushLocked(ctx context.Context) error {
+	b := sl.buffer
+	if b.size == 0 {
+		return nil
+	}
+
+	writes := make([]store.UserSearchWrite, 0, b.size)
+	users := make([]string, 0, len(b.pending))
+	for userIdentifier, pending := range b.pending {
+		users = append(users, userIdentifier)
+		for _, write := range pending {
+			writes = append(writes, *write)
+		}
+	}
+
+	flushCtx, span := sl.tracer.Start(ctx, "logsearch.flush", tracing.KeyLogger.String(metrics.LoggerV2), tracing.KeyWrites.Int(len(writes)))
+	start := time.Now()
+	err := sl.applyWrites(flushCtx, writes)
+	sl.metrics.ObserveFlush(metrics.LoggerV2, len(writes), start, err)
+	tracing.End(span, err)
+	// Whether or not they reached the store, the writes may have changed the reads of their users
+	sl.invalidateResults(ctx, users...)
+	if err != nil {
+		for userIdentifier := range b.pending {
+			sl.cache.invalidate(userIdentifier)
+		}
+		var partial *store.PartialWriteError
+		if errors.As(err, &partial) {
+			applied := writes[:partial.Applied]
+			for _, write := range applied {
+				delete(b.pending[write.UserIdentifier], write.Word)
+				if len(b.pending[write.UserIdentifier]) == 0 {
+					delete(b.pending, write.UserIdentifier)
+				}
+				b.size--
+			}
+			sl.flushed(ctx, applied)
+		}
+		return fmt.Errorf("failed to flush %d buffered searches: %w", len(writes), store.Classify(err))
+	}
+
+	sl.flushed(ctx, writes)
+	b.pending = make(map[string]map[string]*store.UserSearchWrite)
+	b.size = 0
+	return nil
+}
+
+// flushed records the writes that reached the store in the cache, the audience
+// and the finalization hooks, and enforces the quotas of their users
+func (sl *SearchLoggerV2) flushed(ctx context.Context, writes []store.UserSearchWrite) {
+	adds := make([]store.AudienceAdd, 0, len(writes))
+	users := make(map[string]bool)
+	for _, write := range writes {
+		for _, oldWord := range write.ReplaceWords {
+			sl.cache.replace(write.UserIdentifier, oldWord, write.Word)
+		}
+		adds = append(adds, store.AudienceAdd{Word: write.Word, UserIdentifier: write.UserIdentifier, At: write.LastUpdatedAt})
+		sl.cache.add(write.UserIdentifier, write.Word)
+		sl.setSurface(ctx, write.UserIdentifier, write.Word, write.SurfaceWord)
+		sl.setRegion(ctx, write.UserIdentifier, write.Word, write.Region)
+		sl.wordFinalized(FinalizedWord{
+			UserIdentifier: write.UserIdentifier,
+			Word:           write.Word,
+			ReplacedWords:  write.ReplaceWords,
+			Count:          write.Count,
+			At:             write.LastUpdatedAt,
+		})
+		users[write.UserIdentifier] = true
+	}
+
+	sl.countAudience(ctx, adds)
+	for userIdentifier := range users {
+		sl.enforceQuota(ctx, userIdentifier)
+	}
+}
+
+// applyWrites sends the writes as one batch when the store supports it, or one by one otherwise.
+// The one by one fallback counts an extension as a single search like LogSearchV2 does.
+func (sl *SearchLoggerV2) applyWrites(ctx context.Context, writes []store.UserSearchWrite) error {
+	if batchStore, ok := sl.db.(store.BatchUserSearchStore); ok {
+		ctx, span := sl.tracer.Start(ctx, "store.ApplyUserSearchWrites", tracing.KeyOp.String(metrics.OpBatch), tracing.KeyWrites.Int(len(writes)))
+		start := time.Now()
+		err := batchStore.ApplyUserSearchWrites(ctx, writes)
+		sl.metrics.ObserveWrite(metrics.LoggerV2, metrics.OpBatch, start, err)
+		tracing.End(span, err)
+		return err
+	}
+
+	for i, write := range writes {
+		if len(write.ReplaceWords) == 0 {
+			writeCtx, span := sl.tracer.Start(ctx, "store.InsertOrUpdateUserSearch", tracing.KeyOp.String(metrics.OpInsert))
+			start := time.Now()
+			_, err := sl.db.InsertOrUpdateUserSearch(writeCtx, write.UserIdentifier, write.Word, write.FirstSearchedAt, write.LastUpdatedAt)
+			sl.metrics.ObserveWrite(metrics.LoggerV2, metrics.OpInsert, start, err)
+			tracing.End(span, err)
+			if err != nil {
+				return partialWrite(i, err)
+			}
+			continue
+		}
+		for _, oldWord := range write.ReplaceWords {
+			writeCtx, span := sl.tracer.Start(ctx, "store.UpdateUserSearchByWord", tracing.KeyOp.String(metrics.OpUpdate))
+			start := time.Now()
+			err := sl.db.UpdateUserSearchByWord(writeCtx, write.UserIdentifier, oldWord, write.Word, write.LastUpdatedAt)
+			sl.metrics.ObserveWrite(metrics.LoggerV2, metrics.OpUpdate, start, err)
+			tracing.End(span, err)
+			if err != nil {
+				return partialWrite(i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// partialWrite reports the first applied writes of a batch that failed with err
+func partialWrite(applied int, err error) error {
+	if applied == 0 {
+		return err
+	}
+	return &store.PartialWriteError{Applied: applied, Err: err}
+}
+
+// Flush finalizes every pending word and writes every buffered search to the store,
+// it is a no-op without a write buffer or finalization timeout
+func (sl *SearchLoggerV2) Flush(ctx context.Context) error {
+	now := time.Now()
+	if err := sl.finalizeDue(ctx, now, now); err != nil {
+		return err
+	}
+	if sl.buffer == nil {
+		return nil
+	}
+
+	sl.buffer.mutex.Lock()
+	defer sl.buffer.mutex.Unlock()
+	return sl.flushLocked(ctx)
+}
+
+// bufferedUserSearches returns the user's stored words with the pending writes applied
+func (sl *SearchLoggerV2) bufferedUserSearches(ctx context.Context, userIdentifier string) ([]string, error) {
+	sl.buffer.mutex.Lock()
+	defer sl.buffer.mutex.Unlock()
+
+	stored, err := sl.storedUserSearches(ctx, userIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	sorted := append([]string(nil), stored...)
+	sort.Strings(sorted)
+
+	return overlayPending(sorted, sl.buffer.pending[userIdentifier]), nil
+}
+
+// overlayPending returns the sorted stored words with the pending writes applied
+func overlayPending(stored []string, pending map[string]*store.UserSearchWrite) []string {
+	if len(pending) == 0 {
+		return stored
+	}
+
+	replaced := make(map[string]bool)
+	for _, write := range pending {
+		for _, word := range write.ReplaceWords {
+			replaced[word] = true
+		}
+	}
+
+	words := make([]string, 0, len(stored)+len(pending))
+	for _, word := range stored {
+		if !replaced[word] && pending[word] == nil {
+			words = append(words, word)
+		}
+	}
+	for word := range pending {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	return words
+}
@@ -0,0 +1,199 @@
+package logsearch
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// batchCountingStore records the batches written to the mock store
+type batchCountingStore struct {
+	*store.MockPostgresDBV2
+	batches [][]store.UserSearchWrite
+}
+
+func (s *batchCountingStore) ApplyUserSearchWrites(ctx context.Context, writes []store.UserSearchWrite) error {
+	s.batches = append(s.batches, writes)
+	return s.MockPostgresDBV2.ApplyUserSearchWrites(ctx, writes)
+}
+
+func TestWriteBuffer_CoalescesProgressiveTyping(t *testing.T) {
+	ctx := context.Background()
+	db := &batchCountingStore{MockPostgresDBV2: store.NewMockPostgresDBV2()}
+	logger, err := NewSearchLoggerV2WithDB(db, WithWriteBuffer(100, time.Hour))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	events := []SearchEvent{
+		{UserIdentifier: "user_1", Query: "b"},
+		{UserIdentifier: "user_1", Query: "bu"},
+		{UserIdentifier: "user_1", Query: "bus"},
+		{UserIdentifier: "user_2", Query: "dog"},
+		{UserIdentifier: "user_2", Query: "do"},
+	}
+	require.NoError(t, logger.LogSearchBatch(ctx, events))
+
+	// Pending searches are visible before the flush
+	searches, err := logger.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bus"}, searches)
+	assert.Empty(t, db.batches)
+
+	require.NoError(t, logger.Flush(ctx))
+	require.Len(t, db.batches, 1)
+	assert.Len(t, db.batches[0], 2, "One coalesced write per user")
+
+	// Extending a flushed word merges the stored prefix
+	require.NoError(t, logger.LogSearchV2(ctx, "user_1", "business"))
+	require.NoError(t, logger.Flush(ctx))
+
+	searches, err = db.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"business"}, searches)
+	searches, err = db.GetUserSearches(ctx, "user_2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dog"}, searches)
+}
+
+func TestWriteBuffer_FlushBySize(t *testing.T) {
+	ctx := context.Background()
+	db := &batchCountingStore{MockPostgresDBV2: store.NewMockPostgresDBV2()}
+	logger, err := NewSearchLoggerV2WithDB(db, WithWriteBuffer(2, time.Hour))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	require.NoError(t, logger.LogSearchV2(ctx, "user_1", "apple"))
+	assert.Empty(t, db.batches)
+	require.NoError(t, logger.LogSearchV2(ctx, "user_1", "cat"))
+	assert.Len(t, db.batches, 1)
+}
+
+func TestWriteBuffer_FlushByIntervalAndClose(t *testing.T) {
+	ctx := context.Background()
+	db := &batchCountingStore{MockPostgresDBV2: store.NewMockPostgresDBV2()}
+	logger, err := NewSearchLoggerV2WithDB(db, WithWriteBuffer(100, 20*time.Millisecond))
+	require.NoError(t, err)
+
+	require.NoError(t, logger.LogSearchV2(ctx, "user_1", "apple"))
+	time.Sleep(60 * time.Millisecond)
+
+	searches, err := db.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"apple"}, searches)
+
+	require.NoError(t, logger.LogSearchV2(ctx, "user_1", "cat"))
+	require.NoError(t, logger.Close())
+
+	searches, err = db.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"apple", "cat"}, searches)
+}
+
+func TestWriteBuffer_FallbackWithoutBatchStore(t *testing.T) {
+	ctx := context.Background()
+	db := &countingStore{UserSearchStore: store.NewMockPostgresDBV2()}
+	logger, err := NewSearchLoggerV2WithDB(db, WithWriteBuffer(100, time.Hour), WithUserCache(100))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	for _, word := range []string{"c", "ca", "cat"} {
+		require.NoError(t, logger.LogSearchV2(ctx, "user_1", word))
+	}
+	require.NoError(t, logger.Flush(ctx))
+	require.NoError(t, logger.LogSearchV2(ctx, "user_1", "cats"))
+	require.NoError(t, logger.Flush(ctx))
+
+	searches, err := db.UserSearchStore.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cats"}, searches)
+}
+
+func TestWriteBuffer_SQLiteStore(t *testing.T) {
+	ctx := context.Background()
+	cfg := store.SQLiteConfig{Path: filepath.Join(t.TempDir(), "logsearch.db")}
+	logger, err := NewSearchLoggerV2WithSQLite(cfg, WithWriteBuffer(100, time.Hour))
+	require.NoError(t, err)
+
+	for _, word := range []string{"b", "bu", "bus"} {
+		require.NoError(t, logger.LogSearchV2(ctx, "user_1", word))
+	}
+	require.NoError(t, logger.Flush(ctx))
+	require.NoError(t, logger.LogSearchV2(ctx, "user_1", "business"))
+	require.NoError(t, logger.Close())
+
+	// Searches persist across restarts
+	logger, err = NewSearchLoggerV2WithSQLite(cfg)
+	require.NoError(t, err)
+	defer logger.Close()
+
+	searches, err := logger.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"business"}, searches)
+
+	top, err := logger.GetTopSearches(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []store.WordCount{{Word: "business", Count: 4}}, top)
+}
+
+func TestWriteBuffer_RetriesFailedFlush(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDBV2()
+	logger, err := NewSearchLoggerV2WithDB(db, WithWriteBuffer(100, time.Hour))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	require.NoError(t, logger.LogSearchV2(ctx, "user_1", "bus"))
+
+	// A failed batch stays buffered and is written by the next flush
+	db.Faults().SetErrorRate("ApplyUserSearchWrites", 1)
+	assert.ErrorIs(t, logger.Flush(ctx), store.ErrStoreUnavailable)
+	db.Faults().Reset()
+	require.NoError(t, logger.LogSearchV2(ctx, "user_1", "business"))
+	require.NoError(t, logger.Flush(ctx))
+
+	searches, err := db.GetUserSearches(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"business"}, searches)
+}
+
+// partialStore applies only the first write of a batch while failing is set
+type partialStore struct {
+	*store.MockPostgresDBV2
+	failing bool
+}
+
+func (s *partialStore) ApplyUserSearchWrites(ctx context.Context, writes []store.UserSearchWrite) error {
+	if !s.failing || len(writes) < 2 {
+		return s.MockPostgresDBV2.ApplyUserSearchWrites(ctx, writes)
+	}
+	if err := s.MockPostgresDBV2.ApplyUserSearchWrites(ctx, writes[:1]); err != nil {
+		return err
+	}
+	return &store.PartialWriteError{Applied: 1, Err: store.ErrInjectedFault}
+}
+
+func TestWriteBuffer_RetriesPartialFlush(t *testing.T) {
+	ctx := context.Background()
+	db := &partialStore{MockPostgresDBV2: store.NewMockPostgresDBV2(), failing: true}
+	logger, err := NewSearchLoggerV2WithDB(db, WithWriteBuffer(100, time.Hour))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	require.NoError(t, logger.LogSearchV2(ctx, "user_1", "bus"))
+	require.NoError(t, logger.LogSearchV2(ctx, "user_2", "cat"))
+
+	// Only the write not applied stays buffered, the retry counts every search once
+	assert.ErrorIs(t, logger.Flush(ctx), store.ErrInjectedFault)
+	assert.Equal(t, 1, logger.buffer.size)
+	db.failing = false
+	require.NoError(t, logger.Flush(ctx))
+
+	top, err := logger.GetTopSearches(ctx, 10)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []store.WordCount{{Word: "bus", Count: 1}, {Word: "cat", Count: 1}}, top)
+}
@@ -0,0 +1,52 @@
+package logsearch
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/afanwang/logsearch/store"
+)
+
+// WithZeroResultTracking tracks the words whose submitted searches return no
+// result, the gaps of the searched content, from the ResultCount of the
+// submitted SearchEvent. A word is listed by GetZeroResultTerms once
+// minStreak of its submitted searches in a row, by any user, returned
+// nothing, and drops out as soon as one returns results. minStreak below 1
+// disables it. The store must implement store.ZeroResultStore.
+func WithZeroResultTracking(minStreak int) Option {
+	return func(sl *SearchLoggerV2) {
+		if minStreak > 0 {
+			sl.zeroResultStreak = minStreak
+		}
+	}
+}
+
+// recordResults records the result count of a submitted search of word at at
+// when known. It is for analytics only, a failure is logged.
+func (sl *SearchLoggerV2) recordResults(ctx context.Context, word string, results *int, at time.Time) {
+	if sl.zeroResultStreak == 0 || results == nil {
+		return
+	}
+	if err := sl.db.(store.ZeroResultStore).RecordSearchResults(ctx, word, *results, at); err != nil {
+		log.Printf("Error recording result count of search '%s': %v", word, store.Classify(err))
+	}
+}
+
+// GetZeroResultTerms returns up to limit words whose latest submitted
+// searches returned no result, at least the minStreak of
+// WithZeroResultTracking in a row, longest streak first. With WithStemmer
+// the words are stems. The store must implement store.ZeroResultStore.
+func (sl *SearchLoggerV2) GetZeroResultTerms(ctx context.Context, limit int) ([]store.ZeroResultTerm, error) {
+	zeroStore, ok := sl.db.(store.ZeroResultStore)
+	if !ok {
+		return nil, errors.New("store does not support zero-result terms")
+	}
+	if limit <= 0 {
+		return []store.ZeroResultTerm{}, nil
+	}
+
+	terms, err := zeroStore.ZeroResultTerms(ctx, max(sl.zeroResultStreak, 1), limit)
+	return terms, store.Classify(err)
+}
@@ -0,0 +1,49 @@
+package logsearch
+
+import (
+	"context"
+	"testing"
+
+	"github.com/afanwang/logsearch/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchLoggerV2_ZeroResultTracking(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMockPostgresDBV2()
+	logger, err := NewSearchLoggerV2WithDB(db, WithZeroResultTracking(2), WithFilters(MinLength(3)))
+	require.NoError(t, err)
+	defer logger.Close()
+
+	submit := func(user, word string, results int) {
+		require.NoError(t, logger.LogSearchEvent(ctx, SearchEvent{UserIdentifier: user, Query: word, Submitted: true, ResultCount: &results}))
+	}
+	submit("user_1", "Blue Sofa", 0)
+	submit("user_2", "blue sofa", 0)
+	submit("user_1", "red sofa", 0)
+	submit("user_1", "bus", 0)
+	submit("user_2", "bus", 0)
+	submit("user_2", "bus", 3)
+	submit("user_1", "tv", 0)
+	submit("user_2", "tv", 0)
+	none := 0
+	require.NoError(t, logger.LogSearchEvent(ctx, SearchEvent{UserIdentifier: "user_1", Query: "red sofa", ResultCount: &none}))
+
+	terms, err := logger.GetZeroResultTerms(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, terms, 1, "Below the streak, ended by results, filtered or not submitted, the other words are left out")
+	assert.Equal(t, "blue sofa", terms[0].Word)
+	assert.Equal(t, 2, terms[0].Streak)
+
+	searches, err := logger.GetUserSearches(ctx, "user_2")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"blue sofa", "bus"}, searches, "The searches are stored as usual")
+
+	negative := -1
+	err = logger.LogSearchEvent(ctx, SearchEvent{UserIdentifier: "user_1", Query: "cat", Submitted: true, ResultCount: &negative})
+	assert.ErrorIs(t, err, ErrInvalidInput)
+
+	_, err = NewSearchLoggerV2WithDB(&countingStore{UserSearchStore: store.NewMockPostgresDBV2()}, WithZeroResultTracking(1))
+	assert.Error(t, err, "Stores without zero-result terms should be rejected")
+}